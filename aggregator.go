@@ -0,0 +1,203 @@
+package avs
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// ContextProvider provides the current value of a context (e.g., the
+// PlaybackState of the audio player) for the events sent to AVS.
+type ContextProvider interface {
+	Context() (TypedMessage, error)
+}
+
+// The ContextProviderFunc type is an adapter to allow the use of ordinary
+// functions as context providers.
+type ContextProviderFunc func() (TypedMessage, error)
+
+// Context calls f().
+func (f ContextProviderFunc) Context() (TypedMessage, error) {
+	return f()
+}
+
+// ContextAggregator gathers the contexts from a set of providers.
+//
+// Providers that are expensive to query may declare a max staleness, in which
+// case their last value is reused within that window unless fresh contexts
+// are requested. A provider that fails is left out of the gathered contexts
+// rather than failing the whole event.
+//
+// When the encoded contexts are larger than the Budget, they're trimmed by
+// the trim functions of their providers (see AddTrimmed), in the order the
+// providers were added, and then left out, the last added first, until they
+// fit. The Priority contexts are never trimmed nor left out, so that
+// Recognize events always get them.
+type ContextAggregator struct {
+	// Logger, if set, receives a line for every provider that fails, and
+	// for every context left out to fit the budget.
+	Logger *log.Logger
+	// Clock, if set, replaces the system clock.
+	Clock Clock
+	// Budget, if positive, is the size in bytes of the encoded array of
+	// contexts that they shouldn't exceed.
+	Budget int
+	// Priority are the types of the contexts that are kept whole whatever
+	// the budget. If nil, PlaybackState and SpeechState are.
+	Priority []MessageType
+	// Metrics, if set, is told whenever the contexts are over budget.
+	Metrics *Metrics
+
+	mu      sync.Mutex
+	entries []*contextEntry
+}
+
+type contextEntry struct {
+	provider     ContextProvider
+	maxStaleness time.Duration
+	trim         ContextTrimFunc
+	value        TypedMessage
+	size         int // of the encoded value
+	updated      time.Time
+}
+
+// ContextTrimFunc returns a smaller version of a context (e.g., an
+// AlertsState with fewer alerts), for a ContextAggregator over budget. It
+// must not change the context, which may be cached.
+type ContextTrimFunc func(context TypedMessage) TypedMessage
+
+// The contexts that are kept whole by aggregators without Priority.
+var defaultPriorityContexts = []MessageType{TypePlaybackState, TypeSpeechState}
+
+// NewContextAggregator returns a new ContextAggregator without any providers.
+func NewContextAggregator() *ContextAggregator {
+	return &ContextAggregator{}
+}
+
+// Add registers a provider. Its value is reused for up to maxStaleness; a
+// zero maxStaleness means that the provider is queried every time.
+func (a *ContextAggregator) Add(provider ContextProvider, maxStaleness time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, &contextEntry{provider: provider, maxStaleness: maxStaleness})
+}
+
+// AddTrimmed registers a provider like Add, with a function that trims its
+// context when the contexts are over budget.
+func (a *ContextAggregator) AddTrimmed(provider ContextProvider, maxStaleness time.Duration, trim ContextTrimFunc) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, &contextEntry{provider: provider, maxStaleness: maxStaleness, trim: trim})
+}
+
+// Contexts returns the contexts of all the providers, in the order they were
+// added. If fresh is true, cached values are not used.
+func (a *ContextAggregator) Contexts(fresh bool) []TypedMessage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := clockOrDefault(a.Clock).Now()
+	contexts := make([]TypedMessage, 0, len(a.entries))
+	var gathered []*contextEntry
+	for _, e := range a.entries {
+		if !fresh && e.maxStaleness > 0 && e.value != nil && now.Sub(e.updated) <= e.maxStaleness {
+			contexts = append(contexts, e.value)
+			gathered = append(gathered, e)
+			continue
+		}
+		value, err := e.provider.Context()
+		if err != nil {
+			if a.Logger != nil {
+				a.Logger.Printf("avs: dropping context from provider %T: %v", e.provider, err)
+			}
+			e.value = nil
+			continue
+		}
+		e.value, e.updated = value, now
+		e.size = 0
+		if value != nil {
+			contexts = append(contexts, value)
+			gathered = append(gathered, e)
+		}
+	}
+	if a.Budget > 0 {
+		contexts = a.fit(contexts, gathered)
+	}
+	return contexts
+}
+
+// Trims and leaves out contexts until they fit the budget. The lock must be
+// held.
+func (a *ContextAggregator) fit(contexts []TypedMessage, gathered []*contextEntry) []TypedMessage {
+	sizes := make([]int, len(contexts))
+	total := 1 // the brackets and commas of the array
+	for i, e := range gathered {
+		if e.size == 0 {
+			e.size = encodedSize(e.value)
+		}
+		sizes[i] = e.size
+		total += e.size + 1
+	}
+	if total <= a.Budget {
+		return contexts
+	}
+	a.Metrics.contextOverBudget(total, a.Budget)
+	priority := a.Priority
+	if priority == nil {
+		priority = defaultPriorityContexts
+	}
+	trimmable := make([]bool, len(contexts))
+	for i, c := range contexts {
+		trimmable[i] = true
+		for _, t := range priority {
+			if c.GetMessage().Type() == t {
+				trimmable[i] = false
+			}
+		}
+	}
+	trimmed := make([]TypedMessage, len(contexts))
+	copy(trimmed, contexts)
+	for i, e := range gathered {
+		if total <= a.Budget {
+			break
+		}
+		if !trimmable[i] || e.trim == nil {
+			continue
+		}
+		trimmed[i] = e.trim(contexts[i])
+		size := encodedSize(trimmed[i])
+		total += size - sizes[i]
+		sizes[i] = size
+	}
+	for i := len(trimmed) - 1; i >= 0 && total > a.Budget; i-- {
+		if !trimmable[i] {
+			continue
+		}
+		if a.Logger != nil {
+			a.Logger.Printf("avs: leaving out context %s of %d bytes to fit the budget", trimmed[i].GetMessage(), sizes[i])
+		}
+		trimmed[i] = nil
+		total -= sizes[i] + 1
+	}
+	contexts = trimmed[:0]
+	for _, c := range trimmed {
+		if c != nil {
+			contexts = append(contexts, c)
+		}
+	}
+	return contexts
+}
+
+// Returns the size of the encoded context, or 0 if it can't be encoded.
+func encodedSize(context TypedMessage) int {
+	data, err := codec().Marshal(context)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// Fill sets the contexts of the request. Fresh contexts are gathered for user
+// initiated events (i.e., Recognize).
+func (a *ContextAggregator) Fill(request *Request) {
+	request.Context = a.Contexts(isUserInitiated(request.Event))
+}
@@ -0,0 +1,134 @@
+package avs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContextAggregatorStaleness(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2017, 5, 16, 12, 0, 0, 0, time.UTC)}
+	var logs bytes.Buffer
+	a := NewContextAggregator()
+	a.Clock = clock
+	a.Logger = log.New(&logs, "", 0)
+	var volumeCalls, alertsCalls int
+	a.Add(ContextProviderFunc(func() (TypedMessage, error) {
+		volumeCalls++
+		return NewVolumeState(volumeCalls, false), nil
+	}), time.Minute)
+	var alertsErr error
+	a.Add(ContextProviderFunc(func() (TypedMessage, error) {
+		alertsCalls++
+		return NewAlertsState(nil, nil), alertsErr
+	}), 0)
+
+	a.Contexts(false)
+	clock.now = clock.now.Add(30 * time.Second)
+	contexts := a.Contexts(false)
+	if volumeCalls != 1 || alertsCalls != 2 {
+		t.Errorf("got %d and %d calls; want the volume cached", volumeCalls, alertsCalls)
+	}
+	if v := contexts[0].(*VolumeState).Payload.Volume; v != 1 {
+		t.Errorf("got volume %d; want the cached 1", v)
+	}
+	// Fresh contexts skip the cache, and so does a stale value.
+	a.Contexts(true)
+	clock.now = clock.now.Add(2 * time.Minute)
+	a.Contexts(false)
+	if volumeCalls != 3 {
+		t.Errorf("got %d calls to the volume provider; want 3", volumeCalls)
+	}
+
+	// A failing provider is left out.
+	alertsErr = errors.New("no alerts")
+	contexts = a.Contexts(false)
+	if len(contexts) != 1 || contexts[0].GetMessage().Type() != TypeVolumeState {
+		t.Errorf("got %d contexts; want only VolumeState", len(contexts))
+	}
+	if !strings.Contains(logs.String(), "no alerts") {
+		t.Errorf("the failure wasn't logged: %s", logs.String())
+	}
+}
+
+func TestContextAggregatorBudget(t *testing.T) {
+	var alerts []Alert
+	for i := 0; i < 50; i++ {
+		alerts = append(alerts, Alert{
+			Token:         fmt.Sprintf("alert%02d", i),
+			Type:          AlertTypeAlarm,
+			ScheduledTime: Timestamp{time.Date(2017, 5, 16, 12, 0, 0, 0, time.UTC).Add(time.Duration(50-i) * time.Hour)},
+		})
+	}
+	state := NewAlertsState(alerts, alerts[:1])
+	var logs bytes.Buffer
+	var overBudget []int
+	a := NewContextAggregator()
+	a.Logger = log.New(&logs, "", 0)
+	a.Metrics = &Metrics{ContextOverBudget: func(size, budget int) { overBudget = append(overBudget, size) }}
+	a.Add(ContextProviderFunc(func() (TypedMessage, error) {
+		return NewPlaybackState("token", 0, PlayerActivityPlaying), nil
+	}), 0)
+	a.AddTrimmed(ContextProviderFunc(func() (TypedMessage, error) { return state, nil }), 0, TrimAlertsState(3))
+	a.Add(ContextProviderFunc(func() (TypedMessage, error) {
+		return NewSpeechState("t1", 0, PlayerActivityFinished), nil
+	}), 0)
+	a.Add(ContextProviderFunc(func() (TypedMessage, error) { return NewVolumeState(50, false), nil }), 0)
+
+	names := func(contexts []TypedMessage) []string {
+		var names []string
+		for _, c := range contexts {
+			names = append(names, c.GetMessage().Type().Name)
+		}
+		return names
+	}
+	all := []string{"PlaybackState", "AlertsState", "SpeechState", "VolumeState"}
+	if got := names(a.Contexts(true)); !reflect.DeepEqual(got, all) || len(overBudget) != 0 {
+		t.Fatalf("got %v without a budget", got)
+	}
+
+	// Trimming the alerts is enough.
+	a.Budget = 1200
+	contexts := a.Contexts(true)
+	if got := names(contexts); !reflect.DeepEqual(got, all) {
+		t.Fatalf("got %v", got)
+	}
+	if len(overBudget) != 1 || overBudget[0] <= a.Budget {
+		t.Errorf("got over budget sizes %v", overBudget)
+	}
+	trimmed := contexts[1].(*AlertsState).Payload.AllAlerts
+	var tokens []string
+	for _, alert := range trimmed {
+		tokens = append(tokens, alert.Token)
+	}
+	// The soonest and the active one.
+	if want := []string{"alert49", "alert48", "alert47", "alert00"}; !reflect.DeepEqual(tokens, want) {
+		t.Errorf("kept alerts %v, want %v", tokens, want)
+	}
+	if len(state.Payload.AllAlerts) != 50 {
+		t.Error("trimming changed the context of the provider")
+	}
+	if data, _ := json.Marshal(contexts); len(data) > a.Budget {
+		t.Errorf("contexts are %d bytes, over the budget of %d", len(data), a.Budget)
+	}
+
+	// Then the contexts that aren't a priority are left out, the last added
+	// first.
+	a.Budget = 300
+	if got, want := names(a.Contexts(true)), []string{"PlaybackState", "SpeechState"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !strings.Contains(logs.String(), "Speaker.VolumeState") {
+		t.Errorf("leaving out VolumeState wasn't logged: %s", logs.String())
+	}
+	a.Budget = 1
+	if got, want := names(a.Contexts(true)), []string{"PlaybackState", "SpeechState"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v for a tiny budget, want the priority contexts", got)
+	}
+}
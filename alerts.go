@@ -0,0 +1,217 @@
+package avs
+
+import "sort"
+
+// The directives of the Alerts interface.
+var (
+	TypeDeleteAlert = MessageType{"Alerts", "DeleteAlert"}
+	TypeSetAlert    = MessageType{"Alerts", "SetAlert"}
+)
+
+// The events of the Alerts interface.
+var (
+	TypeAlertEnteredBackground = MessageType{"Alerts", "AlertEnteredBackground"}
+	TypeAlertEnteredForeground = MessageType{"Alerts", "AlertEnteredForeground"}
+	TypeAlertStarted           = MessageType{"Alerts", "AlertStarted"}
+	TypeAlertStopped           = MessageType{"Alerts", "AlertStopped"}
+	TypeDeleteAlertFailed      = MessageType{"Alerts", "DeleteAlertFailed"}
+	TypeDeleteAlertSucceeded   = MessageType{"Alerts", "DeleteAlertSucceeded"}
+	TypeSetAlertFailed         = MessageType{"Alerts", "SetAlertFailed"}
+	TypeSetAlertSucceeded      = MessageType{"Alerts", "SetAlertSucceeded"}
+)
+
+// The context of the Alerts interface.
+var TypeAlertsState = MessageType{"Alerts", "AlertsState"}
+
+func init() {
+	registerDirective(TypeDeleteAlert, DeleteAlert{})
+	registerDirective(TypeSetAlert, SetAlert{})
+	register(TypeAlertEnteredBackground, AlertEnteredBackground{})
+	register(TypeAlertEnteredForeground, AlertEnteredForeground{})
+	register(TypeAlertStarted, AlertStarted{})
+	register(TypeAlertStopped, AlertStopped{})
+	register(TypeDeleteAlertFailed, DeleteAlertFailed{})
+	register(TypeDeleteAlertSucceeded, DeleteAlertSucceeded{})
+	register(TypeSetAlertFailed, SetAlertFailed{})
+	register(TypeSetAlertSucceeded, SetAlertSucceeded{})
+	register(TypeAlertsState, AlertsState{})
+}
+
+// The DeleteAlert directive.
+type DeleteAlert struct {
+	*Message
+	Payload struct {
+		Token string `json:"token"`
+	} `json:"payload"`
+}
+
+// The SetAlert directive.
+type SetAlert struct {
+	*Message
+	Payload Alert `json:"payload"`
+}
+
+// The AlertEnteredBackground event.
+type AlertEnteredBackground struct {
+	*Message
+	Payload struct {
+		Token string `json:"token"`
+	} `json:"payload"`
+}
+
+func NewAlertEnteredBackground(messageId, token string, opts ...HeaderOption) *AlertEnteredBackground {
+	m := new(AlertEnteredBackground)
+	m.Message = newEvent("Alerts", "AlertEnteredBackground", messageId, "", opts...)
+	m.Payload.Token = token
+	return m
+}
+
+// The AlertEnteredForeground event.
+type AlertEnteredForeground struct {
+	*Message
+	Payload struct {
+		Token string `json:"token"`
+	} `json:"payload"`
+}
+
+func NewAlertEnteredForeground(messageId, token string, opts ...HeaderOption) *AlertEnteredForeground {
+	m := new(AlertEnteredForeground)
+	m.Message = newEvent("Alerts", "AlertEnteredForeground", messageId, "", opts...)
+	m.Payload.Token = token
+	return m
+}
+
+// The AlertStarted event.
+type AlertStarted struct {
+	*Message
+	Payload struct {
+		Token string `json:"token"`
+	} `json:"payload"`
+}
+
+func NewAlertStarted(messageId, token string, opts ...HeaderOption) *AlertStarted {
+	m := new(AlertStarted)
+	m.Message = newEvent("Alerts", "AlertStarted", messageId, "", opts...)
+	m.Payload.Token = token
+	return m
+}
+
+// The AlertStopped event.
+type AlertStopped struct {
+	*Message
+	Payload struct {
+		Token string `json:"token"`
+	} `json:"payload"`
+}
+
+func NewAlertStopped(messageId, token string, opts ...HeaderOption) *AlertStopped {
+	m := new(AlertStopped)
+	m.Message = newEvent("Alerts", "AlertStopped", messageId, "", opts...)
+	m.Payload.Token = token
+	return m
+}
+
+// The DeleteAlertFailed event.
+type DeleteAlertFailed struct {
+	*Message
+	Payload struct {
+		Token string `json:"token"`
+	} `json:"payload"`
+}
+
+func NewDeleteAlertFailed(messageId, token string, opts ...HeaderOption) *DeleteAlertFailed {
+	m := new(DeleteAlertFailed)
+	m.Message = newEvent("Alerts", "DeleteAlertFailed", messageId, "", opts...)
+	m.Payload.Token = token
+	return m
+}
+
+// The DeleteAlertSucceeded event.
+type DeleteAlertSucceeded struct {
+	*Message
+	Payload struct {
+		Token string `json:"token"`
+	} `json:"payload"`
+}
+
+func NewDeleteAlertSucceeded(messageId, token string, opts ...HeaderOption) *DeleteAlertSucceeded {
+	m := new(DeleteAlertSucceeded)
+	m.Message = newEvent("Alerts", "DeleteAlertSucceeded", messageId, "", opts...)
+	m.Payload.Token = token
+	return m
+}
+
+// The SetAlertFailed event.
+type SetAlertFailed struct {
+	*Message
+	Payload struct {
+		Token string `json:"token"`
+	} `json:"payload"`
+}
+
+func NewSetAlertFailed(messageId, token string, opts ...HeaderOption) *SetAlertFailed {
+	m := new(SetAlertFailed)
+	m.Message = newEvent("Alerts", "SetAlertFailed", messageId, "", opts...)
+	m.Payload.Token = token
+	return m
+}
+
+// The SetAlertSucceeded event.
+type SetAlertSucceeded struct {
+	*Message
+	Payload struct {
+		Token string `json:"token"`
+	} `json:"payload"`
+}
+
+func NewSetAlertSucceeded(messageId, token string, opts ...HeaderOption) *SetAlertSucceeded {
+	m := new(SetAlertSucceeded)
+	m.Message = newEvent("Alerts", "SetAlertSucceeded", messageId, "", opts...)
+	m.Payload.Token = token
+	return m
+}
+
+// The AlertsState context.
+type AlertsState struct {
+	*Message
+	Payload struct {
+		AllAlerts    []Alert `json:"allAlerts"`
+		ActiveAlerts []Alert `json:"activeAlerts"`
+	} `json:"payload"`
+}
+
+// TrimAlertsState returns a ContextTrimFunc that keeps the n alerts scheduled
+// the soonest in AllAlerts of an AlertsState, along with the active alerts.
+func TrimAlertsState(n int) ContextTrimFunc {
+	return func(context TypedMessage) TypedMessage {
+		state, ok := context.(*AlertsState)
+		if !ok || len(state.Payload.AllAlerts) <= n {
+			return context
+		}
+		all := append([]Alert(nil), state.Payload.AllAlerts...)
+		sort.SliceStable(all, func(i, j int) bool {
+			return all[i].ScheduledTime.Before(all[j].ScheduledTime.Time)
+		})
+		active := make(map[string]bool)
+		for _, a := range state.Payload.ActiveAlerts {
+			active[a.Token] = true
+		}
+		kept := all[:0]
+		for i, a := range all {
+			if i < n || active[a.Token] {
+				kept = append(kept, a)
+			}
+		}
+		trimmed := NewAlertsState(kept, state.Payload.ActiveAlerts)
+		trimmed.Message = state.Message
+		return trimmed
+	}
+}
+
+func NewAlertsState(allAlerts, activeAlerts []Alert, opts ...HeaderOption) *AlertsState {
+	m := new(AlertsState)
+	m.Message = newContext("Alerts", "AlertsState", opts...)
+	m.Payload.AllAlerts = allAlerts
+	m.Payload.ActiveAlerts = activeAlerts
+	return m
+}
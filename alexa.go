@@ -0,0 +1,45 @@
+package avs
+
+// The directives of the Alexa interface.
+var (
+	TypeEventProcessed = MessageType{"Alexa", "EventProcessed"}
+	TypeReportState    = MessageType{"Alexa", "ReportState"}
+)
+
+// The events of the Alexa interface.
+var TypeStateReport = MessageType{"Alexa", "StateReport"}
+
+func init() {
+	registerDirective(TypeEventProcessed, EventProcessed{})
+	registerDirective(TypeReportState, ReportState{})
+	register(TypeStateReport, StateReport{})
+}
+
+// The EventProcessed directive, which confirms that AVS processed the event
+// with the eventCorrelationToken of its header.
+type EventProcessed struct {
+	*Message
+	Payload struct{} `json:"payload"`
+}
+
+// The ReportState directive, which asks for a StateReport event with the
+// correlationToken of its header.
+type ReportState struct {
+	*Message
+	Payload struct{} `json:"payload"`
+}
+
+// The StateReport event, sent in reply to a ReportState directive with the
+// state of the device in the context.
+type StateReport struct {
+	*Message
+	Payload struct{} `json:"payload"`
+}
+
+func NewStateReport(messageId, correlationToken string, opts ...HeaderOption) *StateReport {
+	m := new(StateReport)
+	m.Message = newEvent("Alexa", "StateReport", messageId, "", opts...)
+	m.Header["correlationToken"] = correlationToken
+	m.Header["payloadVersion"] = "3"
+	return m
+}
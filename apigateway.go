@@ -0,0 +1,22 @@
+package avs
+
+// The directives of the Alexa.ApiGateway interface.
+var TypeSetGateway = MessageType{"Alexa.ApiGateway", "SetGateway"}
+
+func init() {
+	registerDirective(TypeSetGateway, SetGateway{})
+}
+
+// The SetGateway directive, which tells the device which gateway to send its
+// events to from now on.
+type SetGateway struct {
+	*Message
+	Payload struct {
+		Gateway string `json:"gateway"`
+	} `json:"payload"`
+}
+
+// GatewayURL returns the base URL of the new gateway.
+func (m *SetGateway) GatewayURL() string {
+	return m.Payload.Gateway
+}
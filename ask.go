@@ -0,0 +1,66 @@
+package avs
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// InteractionResult is the outcome of a Recognize round trip made with Ask.
+type InteractionResult struct {
+	// The raw response, including every directive and attachment.
+	Response *Response
+	// The Speak directives in the response, in order.
+	Speaks []*Speak
+	// The audio of the Speak directives, one after the other. It's empty if
+	// there's nothing to say.
+	Speech io.Reader
+	// The Play directives in the response, in order.
+	Plays []*Play
+}
+
+// Directives returns all the directives in the response.
+func (r *InteractionResult) Directives() []*Message {
+	return r.Response.Directives
+}
+
+// Ask sends the audio (16 kHz, 16-bit mono PCM) to AVS in a Recognize event
+// and collects the response. The message id and dialog request id are
+// generated, and the request carries default contexts for an idle device at
+// full volume. It's meant for driving AVS from recordings, without a
+// microphone or a Dispatcher.
+func (c *Client) Ask(ctx context.Context, accessToken string, audio io.Reader) (*InteractionResult, error) {
+	request := NewRequest(accessToken)
+	request.Event = NewRecognize(RandomUUIDString(), RandomUUIDString())
+	request.Audio = audio
+	request.Context = []TypedMessage{
+		NewAlertsState([]Alert{}, []Alert{}),
+		NewPlaybackState("", 0, PlayerActivityIdle),
+		NewVolumeState(100, false),
+		NewSpeechState("", 0, PlayerActivityFinished),
+	}
+	response, err := c.DoContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return newInteractionResult(response), nil
+}
+
+// Collects the Speak and Play directives of a Recognize response.
+func newInteractionResult(response *Response) *InteractionResult {
+	result := &InteractionResult{Response: response}
+	var speech []io.Reader
+	for _, directive := range response.Directives {
+		switch d := directive.Typed().(type) {
+		case *Speak:
+			result.Speaks = append(result.Speaks, d)
+			if content, ok := response.Content[d.ContentId()]; ok {
+				speech = append(speech, bytes.NewReader(content))
+			}
+		case *Play:
+			result.Plays = append(result.Plays, d)
+		}
+	}
+	result.Speech = io.MultiReader(speech...)
+	return result
+}
@@ -0,0 +1,59 @@
+package avs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAsk(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			t.Errorf("unexpected request: %v", err)
+			return
+		}
+		p, _ := mr.NextPart()
+		var request struct {
+			Context []*Message `json:"context"`
+			Event   *Message   `json:"event"`
+		}
+		json.NewDecoder(p).Decode(&request)
+		if request.Event.Header["name"] != "Recognize" || request.Event.Header["dialogRequestId"] == "" || len(request.Context) != 4 {
+			t.Errorf("unexpected request %v with %d contexts", request.Event, len(request.Context))
+		}
+		audio, _ := mr.NextPart()
+		if data, _ := ioutil.ReadAll(audio); string(data) != "hello" {
+			t.Errorf("got audio %q", data)
+		}
+		w.Header().Set("Content-Type", "multipart/related; boundary=------abcde123; type=application/json")
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "--------abcde123\r\nContent-Type: application/json\r\n\r\n{\"directive\":%s}\r\n", speakDirective)
+		fmt.Fprint(w, "--------abcde123\r\nContent-Type: application/json\r\n\r\n"+
+			`{"directive":{"header":{"namespace":"AudioPlayer","name":"Play","messageId":"m2"},"payload":{"playBehavior":"REPLACE_ALL"}}}`+"\r\n")
+		fmt.Fprint(w, "--------abcde123\r\nContent-ID: <abc>\r\nContent-Type: application/octet-stream\r\n\r\nmp3\r\n--------abcde123--\r\n")
+	}))
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL}
+	result, err := client.Ask(context.Background(), "token", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Speaks) != 1 || len(result.Plays) != 1 || len(result.Directives()) != 2 {
+		t.Errorf("got %d Speak and %d Play directives", len(result.Speaks), len(result.Plays))
+	}
+	if speech, _ := ioutil.ReadAll(result.Speech); string(speech) != "mp3" {
+		t.Errorf("got speech %q; want mp3", speech)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.Ask(ctx, "token", strings.NewReader("hello")); err == nil {
+		t.Error("a canceled context should fail")
+	}
+}
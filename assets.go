@@ -0,0 +1,286 @@
+package avs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// The Store namespaces and key used by the asset cache. The index is kept
+// apart from the assets so that no asset id can overwrite it.
+const (
+	assetStoreNamespace = "AlertAssets"
+	assetIndexNamespace = "Alerts"
+	assetIndexKey       = "assets"
+)
+
+// How long Open waits for an asset that has to be downloaded again.
+const assetFetchTimeout = 30 * time.Second
+
+// A known asset, as persisted in the index. Assets that aren't in the cache
+// (e.g., evicted ones) keep their URL with no checksum, so that they can be
+// downloaded again.
+type assetEntry struct {
+	URL      string    `json:"url"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	Fetched  time.Time `json:"fetched"`
+	LastUsed uint64    `json:"lastUsed"`
+}
+
+// AssetCache downloads the custom assets of alerts ahead of time and keeps
+// them in a Store, so that they can be played when the alert fires even if
+// the device is offline by then.
+//
+// The cache holds at most maxSize bytes of assets, evicting the least
+// recently used ones. An asset that has expired, was evicted or fails its
+// checksum is downloaded again when it's opened.
+type AssetCache struct {
+	// Client, if set, provides the transport used for the downloads.
+	Client *Client
+	// Clock, if set, replaces the system clock.
+	Clock Clock
+	// MaxAge is how long a downloaded asset is used before it's downloaded
+	// again. Zero means forever.
+	MaxAge time.Duration
+	// DefaultTone, if set, is opened instead of an asset that can't be
+	// downloaded.
+	DefaultTone func() (io.ReadCloser, error)
+
+	store   Store
+	maxSize int64
+
+	mu      sync.Mutex
+	entries map[string]*assetEntry
+	used    uint64
+}
+
+// NewAssetCache returns a new AssetCache that stores up to maxSize bytes of
+// assets in the store. Assets cached by a previous AssetCache with the same
+// store are reused.
+func NewAssetCache(store Store, maxSize int64) *AssetCache {
+	c := &AssetCache{
+		store:   store,
+		maxSize: maxSize,
+		entries: make(map[string]*assetEntry),
+	}
+	if data, err := store.Get(assetIndexNamespace, assetIndexKey); err == nil {
+		json.Unmarshal(data, &c.entries)
+	}
+	for id, e := range c.entries {
+		if e == nil {
+			delete(c.entries, id)
+			continue
+		}
+		if e.LastUsed > c.used {
+			c.used = e.LastUsed
+		}
+	}
+	return c
+}
+
+// Prefetch downloads the assets that aren't cached yet or have expired (e.g.,
+// the Assets of a SetAlert directive). It tries every asset and returns the
+// first error.
+func (c *AssetCache) Prefetch(ctx context.Context, assets ...AlertAsset) error {
+	var first error
+	for _, asset := range assets {
+		c.mu.Lock()
+		e := c.entries[asset.AssetId]
+		fresh := e != nil && e.URL == asset.URL && e.SHA256 != "" && !c.expired(e)
+		if e == nil || e.URL != asset.URL {
+			c.entries[asset.AssetId] = &assetEntry{URL: asset.URL}
+		}
+		c.mu.Unlock()
+		if fresh {
+			continue
+		}
+		if _, err := c.fetch(ctx, asset.AssetId, asset.URL); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Open returns the audio of the asset. It's downloaded again if it isn't in
+// the cache or can't be used from it. If the download fails, the DefaultTone
+// is opened instead, if set.
+func (c *AssetCache) Open(assetId string) (io.ReadCloser, error) {
+	if data, ok := c.cached(assetId); ok {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	c.mu.Lock()
+	e, ok := c.entries[assetId]
+	var url string
+	if ok {
+		url = e.URL
+	}
+	c.mu.Unlock()
+	err := fmt.Errorf("avs: unknown asset %s", assetId)
+	if ok {
+		ctx, cancel := context.WithTimeout(context.Background(), assetFetchTimeout)
+		var data []byte
+		data, err = c.fetch(ctx, assetId, url)
+		cancel()
+		if err == nil {
+			return ioutil.NopCloser(bytes.NewReader(data)), nil
+		}
+	}
+	if c.DefaultTone != nil {
+		return c.DefaultTone()
+	}
+	return nil, err
+}
+
+// Delete removes the assets from the cache (e.g., when their alert is
+// deleted).
+func (c *AssetCache) Delete(assetIds ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range assetIds {
+		if c.entries[id] == nil {
+			continue
+		}
+		delete(c.entries, id)
+		if err := c.store.Delete(assetStoreNamespace, id); err != nil {
+			return err
+		}
+	}
+	return c.saveIndex()
+}
+
+// Size returns the number of bytes of assets in the cache.
+func (c *AssetCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size()
+}
+
+// Returns the cached audio of the asset if it's fresh and intact. Assets that
+// can't be used are removed from the cache.
+func (c *AssetCache) cached(assetId string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[assetId]
+	if e == nil || e.SHA256 == "" {
+		return nil, false
+	}
+	data, err := c.store.Get(assetStoreNamespace, assetId)
+	if err != nil || c.expired(e) || checksum(data) != e.SHA256 {
+		e.Size, e.SHA256 = 0, ""
+		c.store.Delete(assetStoreNamespace, assetId)
+		c.saveIndex()
+		return nil, false
+	}
+	c.used++
+	e.LastUsed = c.used
+	c.saveIndex()
+	return data, true
+}
+
+// Downloads the asset and adds it to the cache.
+func (c *AssetCache) fetch(ctx context.Context, assetId, url string) ([]byte, error) {
+	data, err := c.download(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("avs: downloading asset %s: %v", assetId, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.store.Put(assetStoreNamespace, assetId, data); err != nil {
+		return nil, err
+	}
+	c.used++
+	c.entries[assetId] = &assetEntry{
+		URL:      url,
+		Size:     int64(len(data)),
+		SHA256:   checksum(data),
+		Fetched:  clockOrDefault(c.Clock).Now(),
+		LastUsed: c.used,
+	}
+	if err := c.evict(assetId); err != nil {
+		return nil, err
+	}
+	return data, c.saveIndex()
+}
+
+func (c *AssetCache) download(ctx context.Context, url string) ([]byte, error) {
+	client := DefaultClient
+	if c.Client != nil {
+		client = c.Client
+	}
+	req, err := client.newRequestURL("GET", url, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	// Assets are hosted outside AVS and get no access token.
+	req.Header.Del("Authorization")
+	resp, err := client.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, notConnected(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("request failed with %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, c.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > c.maxSize {
+		return nil, fmt.Errorf("asset is larger than the cache (%d bytes)", c.maxSize)
+	}
+	return data, nil
+}
+
+// Evicts the least recently used assets, other than keep, until the cache
+// fits in its maximum size.
+func (c *AssetCache) evict(keep string) error {
+	for c.size() > c.maxSize {
+		oldest := ""
+		for id, e := range c.entries {
+			if id != keep && e.SHA256 != "" && (oldest == "" || e.LastUsed < c.entries[oldest].LastUsed) {
+				oldest = id
+			}
+		}
+		if oldest == "" {
+			break
+		}
+		c.entries[oldest].Size, c.entries[oldest].SHA256 = 0, ""
+		if err := c.store.Delete(assetStoreNamespace, oldest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *AssetCache) size() int64 {
+	var n int64
+	for _, e := range c.entries {
+		n += e.Size
+	}
+	return n
+}
+
+func (c *AssetCache) expired(e *assetEntry) bool {
+	return c.MaxAge > 0 && clockOrDefault(c.Clock).Now().Sub(e.Fetched) >= c.MaxAge
+}
+
+func (c *AssetCache) saveIndex() error {
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	return c.store.Put(assetIndexNamespace, assetIndexKey, data)
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
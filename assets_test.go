@@ -0,0 +1,118 @@
+package avs
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A clock that only changes the time returned by Now.
+type fixedClock struct {
+	realClock
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time { return c.now }
+
+func newAssetServer(t *testing.T) (*httptest.Server, *int32) {
+	var downloads int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("asset request with an Authorization header")
+		}
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&downloads, 1)
+		w.Write([]byte(strings.Repeat(r.URL.Path[1:], 10)))
+	}))
+	return server, &downloads
+}
+
+func readAsset(t *testing.T, c *AssetCache, id string) string {
+	r, err := c.Open(id)
+	if err != nil {
+		t.Fatalf("Open(%s): %v", id, err)
+	}
+	defer r.Close()
+	data, _ := ioutil.ReadAll(r)
+	return string(data)
+}
+
+func TestAssetCache(t *testing.T) {
+	server, downloads := newAssetServer(t)
+	defer server.Close()
+	store, _ := NewFileStore(t.TempDir(), nil)
+	c := NewAssetCache(store, 25)
+	assets := []AlertAsset{{"a", server.URL + "/a"}, {"b", server.URL + "/b"}}
+	if err := c.Prefetch(context.Background(), assets...); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Prefetch(context.Background(), assets...); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(downloads); n != 2 {
+		t.Errorf("got %d downloads; want 2", n)
+	}
+	if got := readAsset(t, c, "a"); got != strings.Repeat("a", 10) {
+		t.Errorf("got asset %q", got)
+	}
+	// Adding a third asset evicts b, the least recently used.
+	if err := c.Prefetch(context.Background(), AlertAsset{"c", server.URL + "/c"}); err != nil {
+		t.Fatal(err)
+	}
+	if size := c.Size(); size != 20 {
+		t.Errorf("got size %d; want 20", size)
+	}
+	// The cache survives a restart, and corrupt entries are downloaded again.
+	c = NewAssetCache(store, 25)
+	store.Put(assetStoreNamespace, "a", []byte("garbage"))
+	if got := readAsset(t, c, "a"); got != strings.Repeat("a", 10) {
+		t.Errorf("got corrupt asset %q", got)
+	}
+	if got := readAsset(t, c, "b"); got != strings.Repeat("b", 10) {
+		t.Errorf("got evicted asset %q", got)
+	}
+	if n := atomic.LoadInt32(downloads); n != 5 {
+		t.Errorf("got %d downloads; want 5", n)
+	}
+}
+
+func TestAssetCacheExpiry(t *testing.T) {
+	server, downloads := newAssetServer(t)
+	store, _ := NewFileStore(t.TempDir(), nil)
+	clock := &fixedClock{now: time.Now()}
+	c := NewAssetCache(store, 100)
+	c.Clock = clock
+	c.MaxAge = time.Hour
+	c.DefaultTone = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("beep")), nil
+	}
+	if err := c.Prefetch(context.Background(), AlertAsset{"a", server.URL + "/a"}); err != nil {
+		t.Fatal(err)
+	}
+	clock.now = clock.now.Add(time.Hour)
+	if got := readAsset(t, c, "a"); got != strings.Repeat("a", 10) {
+		t.Errorf("got asset %q", got)
+	}
+	if n := atomic.LoadInt32(downloads); n != 2 {
+		t.Errorf("got %d downloads; want 2", n)
+	}
+	// Once expired, an asset that can't be downloaded falls back to the
+	// default tone.
+	server.Close()
+	clock.now = clock.now.Add(time.Hour)
+	if got := readAsset(t, c, "a"); got != "beep" {
+		t.Errorf("got %q; want the default tone", got)
+	}
+	if err := c.Prefetch(context.Background(), AlertAsset{"x", server.URL + "/missing"}); err == nil {
+		t.Error("got no error for a failed download")
+	}
+}
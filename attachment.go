@@ -0,0 +1,118 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+
+	"github.com/fika-io/go-avs/multipart2"
+)
+
+// An Attachment is a binary part sent after the metadata of an event, such as
+// the audio of a Recognize event. The event may refer to it with the cid: URL
+// of its Id.
+type Attachment struct {
+	// Name is the field name of the part.
+	Name string
+	// ContentType defaults to application/octet-stream.
+	ContentType string
+	// Id, if set, is sent in the Content-ID header of the part.
+	Id     ContentId
+	Reader io.Reader
+}
+
+func (a Attachment) contentType() string {
+	if a.ContentType == "" {
+		return "application/octet-stream"
+	}
+	return a.ContentType
+}
+
+// SendEvent sends the event with the attachments and the provided contexts.
+// The attachments are written in order after the metadata. The request is
+// only retried if every attachment can be read again from where it started
+// (i.e., its Reader is an io.Seeker).
+func (c *Client) SendEvent(ctx context.Context, accessToken string, event TypedMessage, attachments []Attachment, contexts ...TypedMessage) (*Response, error) {
+	request := NewRequest(accessToken)
+	request.Event = event
+	request.Context = append(request.Context, contexts...)
+	request.Attachments = attachments
+	return c.DoContext(ctx, request)
+}
+
+// Returns the attachments of the request, starting with its audio.
+func (r *Request) attachments() []Attachment {
+	if r.Audio == nil {
+		return r.Attachments
+	}
+	return append([]Attachment{{Name: audioFieldName, Reader: r.Audio}}, r.Attachments...)
+}
+
+// Checks that the attachments have distinct names that don't collide with
+// the metadata.
+func validateAttachments(attachments []Attachment) error {
+	seen := make(map[string]bool, len(attachments))
+	for _, a := range attachments {
+		switch {
+		case a.Name == "":
+			return withKind(ErrInvalidMessage, errors.New("avs: attachment without a name"))
+		case a.Name == metadataFieldName || seen[a.Name]:
+			return withKind(ErrInvalidMessage, fmt.Errorf("avs: attachment %q is sent more than once", a.Name))
+		case a.Reader == nil:
+			return withKind(ErrInvalidMessage, fmt.Errorf("avs: attachment %q without a reader", a.Name))
+		}
+		seen[a.Name] = true
+	}
+	return nil
+}
+
+// rewinder moves the readers of attachments back to where they started, so
+// that a request can be sent again.
+type rewinder struct {
+	seekers []io.Seeker
+	starts  []int64
+}
+
+// Returns a rewinder for the attachments, or false if one of them can't be
+// read more than once.
+func newRewinder(attachments []Attachment) (*rewinder, bool) {
+	r := &rewinder{}
+	for _, a := range attachments {
+		seeker, ok := a.Reader.(io.Seeker)
+		if !ok {
+			return nil, false
+		}
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, false
+		}
+		r.seekers = append(r.seekers, seeker)
+		r.starts = append(r.starts, start)
+	}
+	return r, true
+}
+
+func (r *rewinder) rewind() error {
+	for i, seeker := range r.seekers {
+		if _, err := seeker.Seek(r.starts[i], io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeAttachment(writer *multipart2.Writer, a Attachment) error {
+	var header textproto.MIMEHeader
+	if a.Id != "" {
+		header = textproto.MIMEHeader{"Content-Id": {a.Id.MIMEHeader()}}
+	}
+	p, err := writer.CreateFormPart(a.Name, a.contentType(), header)
+	if err != nil {
+		return err
+	}
+	// Copy as the attachment is read, so that audio can be streamed.
+	_, err = io.Copy(p, a.Reader)
+	return err
+}
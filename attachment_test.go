@@ -0,0 +1,115 @@
+package avs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Returns a server that fails the first failures requests with an
+// INTERNAL_SERVICE_EXCEPTION and responds to the others with 204 No Content,
+// and a function that returns the parts of the last request, formatted as
+// "name type id body".
+func newAttachmentServer(t *testing.T, failures int) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var parts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			t.Errorf("unexpected request: %v", err)
+			return
+		}
+		var got []string
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			body, _ := ioutil.ReadAll(p)
+			if p.FormName() == metadataFieldName {
+				body = []byte("...")
+			}
+			got = append(got, fmt.Sprintf("%s %s %s %s", p.FormName(), p.Header.Get("Content-Type"), p.Header.Get("Content-Id"), body))
+		}
+		mu.Lock()
+		parts = got
+		failed := failures > 0
+		failures--
+		mu.Unlock()
+		if failed {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			fmt.Fprint(w, `{"header":{"namespace":"System","name":"Exception"},"payload":{"code":"INTERNAL_SERVICE_EXCEPTION","description":"test"}}`)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return parts
+	}
+}
+
+func TestSendEventAttachments(t *testing.T) {
+	server, parts := newAttachmentServer(t, 1)
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL, RetryPolicy: testRetryPolicy()}
+	request := NewRequest("token")
+	request.Event = NewRecognize("m1", "d1")
+	request.Audio = bytes.NewReader([]byte("AUDIO"))
+	request.Attachments = []Attachment{
+		{Name: "snippet", ContentType: "audio/mpeg", Id: "snippet@device", Reader: strings.NewReader("MP3")},
+		{Name: "image", Reader: bytes.NewReader([]byte("JPEG"))},
+	}
+	if _, err := client.Do(request); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"metadata application/json; charset=UTF-8  ...",
+		"audio application/octet-stream  AUDIO",
+		"snippet audio/mpeg <snippet@device> MP3",
+		"image application/octet-stream  JPEG",
+	}
+	// The second attempt must send the attachments again from the start.
+	if got := parts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got parts\n%q\nwant\n%q", got, want)
+	}
+
+	// An attachment that can't be read again prevents retries.
+	server, parts = newAttachmentServer(t, 1)
+	defer server.Close()
+	client.EndpointURL = server.URL
+	_, err := client.SendEvent(context.Background(), "token", NewSynchronizeState("m2"), []Attachment{
+		{Name: "pipe", Reader: ioutil.NopCloser(strings.NewReader("DATA"))},
+	})
+	if e, ok := err.(*Exception); !ok || e.Payload.Code != ExceptionCodeInternalService {
+		t.Errorf("got %v, want the exception of the only attempt", err)
+	}
+	if got := parts(); len(got) != 2 || got[1] != "pipe application/octet-stream  DATA" {
+		t.Errorf("got parts %q", got)
+	}
+}
+
+func TestSendEventInvalidAttachments(t *testing.T) {
+	client := &Client{EndpointURL: "http://127.0.0.1:1"}
+	for _, attachments := range [][]Attachment{
+		{{Reader: strings.NewReader("x")}},
+		{{Name: "metadata", Reader: strings.NewReader("x")}},
+		{{Name: "a", Reader: strings.NewReader("x")}, {Name: "a", Reader: strings.NewReader("y")}},
+		{{Name: "a"}},
+	} {
+		_, err := client.SendEvent(context.Background(), "token", NewSynchronizeState("m1"), attachments)
+		if !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("%+v: got %v, want ErrInvalidMessage", attachments, err)
+		}
+	}
+}
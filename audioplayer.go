@@ -0,0 +1,420 @@
+package avs
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The directives of the AudioPlayer interface.
+var (
+	TypeClearQueue = MessageType{"AudioPlayer", "ClearQueue"}
+	TypePlay       = MessageType{"AudioPlayer", "Play"}
+	TypeStop       = MessageType{"AudioPlayer", "Stop"}
+)
+
+// The events of the AudioPlayer interface.
+var (
+	TypePlaybackFailed                = MessageType{"AudioPlayer", "PlaybackFailed"}
+	TypePlaybackFinished              = MessageType{"AudioPlayer", "PlaybackFinished"}
+	TypePlaybackNearlyFinished        = MessageType{"AudioPlayer", "PlaybackNearlyFinished"}
+	TypePlaybackPaused                = MessageType{"AudioPlayer", "PlaybackPaused"}
+	TypePlaybackQueueCleared          = MessageType{"AudioPlayer", "PlaybackQueueCleared"}
+	TypePlaybackResumed               = MessageType{"AudioPlayer", "PlaybackResumed"}
+	TypePlaybackStarted               = MessageType{"AudioPlayer", "PlaybackStarted"}
+	TypePlaybackStopped               = MessageType{"AudioPlayer", "PlaybackStopped"}
+	TypePlaybackStutterStarted        = MessageType{"AudioPlayer", "PlaybackStutterStarted"}
+	TypePlaybackStutterFinished       = MessageType{"AudioPlayer", "PlaybackStutterFinished"}
+	TypeProgressReportDelayElapsed    = MessageType{"AudioPlayer", "ProgressReportDelayElapsed"}
+	TypeProgressReportIntervalElapsed = MessageType{"AudioPlayer", "ProgressReportIntervalElapsed"}
+	TypeStreamMetadataExtracted       = MessageType{"AudioPlayer", "StreamMetadataExtracted"}
+)
+
+// The context of the AudioPlayer interface.
+var TypePlaybackState = MessageType{"AudioPlayer", "PlaybackState"}
+
+func init() {
+	registerDirective(TypeClearQueue, ClearQueue{})
+	registerDirective(TypePlay, Play{})
+	registerDirective(TypeStop, Stop{})
+	register(TypePlaybackFailed, PlaybackFailed{})
+	register(TypePlaybackFinished, PlaybackFinished{})
+	register(TypePlaybackNearlyFinished, PlaybackNearlyFinished{})
+	register(TypePlaybackPaused, PlaybackPaused{})
+	register(TypePlaybackQueueCleared, PlaybackQueueCleared{})
+	register(TypePlaybackResumed, PlaybackResumed{})
+	register(TypePlaybackStarted, PlaybackStarted{})
+	register(TypePlaybackStopped, PlaybackStopped{})
+	register(TypePlaybackStutterStarted, PlaybackStutterStarted{})
+	register(TypePlaybackStutterFinished, PlaybackStutterFinished{})
+	register(TypeProgressReportDelayElapsed, ProgressReportDelayElapsed{})
+	register(TypeProgressReportIntervalElapsed, ProgressReportIntervalElapsed{})
+	register(TypeStreamMetadataExtracted, StreamMetadataExtracted{})
+	register(TypePlaybackState, PlaybackState{})
+}
+
+// The ClearQueue directive.
+type ClearQueue struct {
+	*Message
+	Payload struct {
+		ClearBehavior ClearBehavior `json:"clearBehavior"`
+	} `json:"payload"`
+}
+
+// The Play directive.
+type Play struct {
+	*Message
+	Payload struct {
+		AudioItem    AudioItem    `json:"audioItem"`
+		PlayBehavior PlayBehavior `json:"playBehavior"`
+	} `json:"payload"`
+}
+
+// The Stop directive.
+type Stop struct {
+	*Message
+	Payload struct{} `json:"payload"`
+}
+
+// Also used by the PlaybackState context.
+type playbackState struct {
+	Token                string         `json:"token"`
+	OffsetInMilliseconds int            `json:"offsetInMilliseconds"`
+	PlayerActivity       PlayerActivity `json:"playerActivity"`
+}
+
+// The PlaybackFailed event.
+type PlaybackFailed struct {
+	*Message
+	Payload struct {
+		Token                string        `json:"token"`
+		CurrentPlaybackState playbackState `json:"currentPlaybackState"`
+		Error                struct {
+			Type    MediaErrorType `json:"type"`
+			Message string         `json:"message"`
+		} `json:"error"`
+	} `json:"payload"`
+}
+
+func NewPlaybackFailed(messageId, token string, errorType MediaErrorType, errorMessage string, opts ...HeaderOption) *PlaybackFailed {
+	m := new(PlaybackFailed)
+	m.Message = newEvent("AudioPlayer", "PlaybackFailed", messageId, "", opts...)
+	m.Payload.Token = token
+	m.Payload.Error.Type = errorType
+	m.Payload.Error.Message = errorMessage
+	return m
+}
+
+// The PlaybackFinished event.
+type PlaybackFinished struct {
+	*Message
+	Payload struct {
+		Token                string `json:"token"`
+		OffsetInMilliseconds int    `json:"offsetInMilliseconds"`
+	} `json:"payload"`
+}
+
+func NewPlaybackFinished(messageId, token string, offset time.Duration, opts ...HeaderOption) *PlaybackFinished {
+	m := new(PlaybackFinished)
+	m.Message = newEvent("AudioPlayer", "PlaybackFinished", messageId, "", opts...)
+	m.Payload.Token = token
+	m.Payload.OffsetInMilliseconds = int(offset.Seconds() * 1000)
+	return m
+}
+
+// The PlaybackNearlyFinished event.
+type PlaybackNearlyFinished struct {
+	*Message
+	Payload struct {
+		Token                string `json:"token"`
+		OffsetInMilliseconds int    `json:"offsetInMilliseconds"`
+	} `json:"payload"`
+}
+
+func NewPlaybackNearlyFinished(messageId, token string, offset time.Duration, opts ...HeaderOption) *PlaybackNearlyFinished {
+	m := new(PlaybackNearlyFinished)
+	m.Message = newEvent("AudioPlayer", "PlaybackNearlyFinished", messageId, "", opts...)
+	m.Payload.Token = token
+	m.Payload.OffsetInMilliseconds = int(offset.Seconds() * 1000)
+	return m
+}
+
+// The PlaybackPaused event.
+type PlaybackPaused struct {
+	*Message
+	Payload struct {
+		Token                string `json:"token"`
+		OffsetInMilliseconds int    `json:"offsetInMilliseconds"`
+	} `json:"payload"`
+}
+
+func NewPlaybackPaused(messageId, token string, offset time.Duration, opts ...HeaderOption) *PlaybackPaused {
+	m := new(PlaybackPaused)
+	m.Message = newEvent("AudioPlayer", "PlaybackPaused", messageId, "", opts...)
+	m.Payload.Token = token
+	m.Payload.OffsetInMilliseconds = int(offset.Seconds() * 1000)
+	return m
+}
+
+// The PlaybackQueueCleared event.
+type PlaybackQueueCleared struct {
+	*Message
+	Payload struct{} `json:"payload"`
+}
+
+func NewPlaybackQueueCleared(messageId string, opts ...HeaderOption) *PlaybackQueueCleared {
+	m := new(PlaybackQueueCleared)
+	m.Message = newEvent("AudioPlayer", "PlaybackQueueCleared", messageId, "", opts...)
+	return m
+}
+
+// The PlaybackResumed event.
+type PlaybackResumed struct {
+	*Message
+	Payload struct {
+		Token                string `json:"token"`
+		OffsetInMilliseconds int    `json:"offsetInMilliseconds"`
+	} `json:"payload"`
+}
+
+func NewPlaybackResumed(messageId, token string, offset time.Duration, opts ...HeaderOption) *PlaybackResumed {
+	m := new(PlaybackResumed)
+	m.Message = newEvent("AudioPlayer", "PlaybackResumed", messageId, "", opts...)
+	m.Payload.Token = token
+	m.Payload.OffsetInMilliseconds = int(offset.Seconds() * 1000)
+	return m
+}
+
+// The PlaybackStarted event.
+type PlaybackStarted struct {
+	*Message
+	Payload struct {
+		Token                string `json:"token"`
+		OffsetInMilliseconds int    `json:"offsetInMilliseconds"`
+	} `json:"payload"`
+}
+
+func NewPlaybackStarted(messageId, token string, offset time.Duration, opts ...HeaderOption) *PlaybackStarted {
+	m := new(PlaybackStarted)
+	m.Message = newEvent("AudioPlayer", "PlaybackStarted", messageId, "", opts...)
+	m.Payload.Token = token
+	m.Payload.OffsetInMilliseconds = int(offset.Seconds() * 1000)
+	return m
+}
+
+// The PlaybackStopped event.
+type PlaybackStopped struct {
+	*Message
+	Payload struct {
+		Token                string `json:"token"`
+		OffsetInMilliseconds int    `json:"offsetInMilliseconds"`
+	} `json:"payload"`
+}
+
+func NewPlaybackStopped(messageId, token string, offset time.Duration, opts ...HeaderOption) *PlaybackStopped {
+	m := new(PlaybackStopped)
+	m.Message = newEvent("AudioPlayer", "PlaybackStopped", messageId, "", opts...)
+	m.Payload.Token = token
+	m.Payload.OffsetInMilliseconds = int(offset.Seconds() * 1000)
+	return m
+}
+
+// The PlaybackStutterStarted event.
+type PlaybackStutterStarted struct {
+	*Message
+	Payload struct {
+		Token                string `json:"token"`
+		OffsetInMilliseconds int    `json:"offsetInMilliseconds"`
+	} `json:"payload"`
+}
+
+func NewPlaybackStutterStarted(messageId, token string, offset time.Duration, opts ...HeaderOption) *PlaybackStutterStarted {
+	m := new(PlaybackStutterStarted)
+	m.Message = newEvent("AudioPlayer", "PlaybackStutterStarted", messageId, "", opts...)
+	m.Payload.Token = token
+	m.Payload.OffsetInMilliseconds = int(offset.Seconds() * 1000)
+	return m
+}
+
+// The PlaybackStutterFinished event.
+type PlaybackStutterFinished struct {
+	*Message
+	Payload struct {
+		Token                         string `json:"token"`
+		OffsetInMilliseconds          int    `json:"offsetInMilliseconds"`
+		StutterDurationInMilliseconds int    `json:"stutterDurationInMilliseconds"`
+	} `json:"payload"`
+}
+
+func NewPlaybackStutterFinished(messageId, token string, offset, stutterDuration time.Duration, opts ...HeaderOption) *PlaybackStutterFinished {
+	m := new(PlaybackStutterFinished)
+	m.Message = newEvent("AudioPlayer", "PlaybackStutterFinished", messageId, "", opts...)
+	m.Payload.Token = token
+	m.Payload.OffsetInMilliseconds = int(offset.Seconds() * 1000)
+	m.Payload.StutterDurationInMilliseconds = int(stutterDuration.Seconds() * 1000)
+	return m
+}
+
+// The ProgressReportDelayElapsed event.
+type ProgressReportDelayElapsed struct {
+	*Message
+	Payload struct {
+		Token                string `json:"token"`
+		OffsetInMilliseconds int    `json:"offsetInMilliseconds"`
+	} `json:"payload"`
+}
+
+func NewProgressReportDelayElapsed(messageId, token string, offset time.Duration, opts ...HeaderOption) *ProgressReportDelayElapsed {
+	m := new(ProgressReportDelayElapsed)
+	m.Message = newEvent("AudioPlayer", "ProgressReportDelayElapsed", messageId, "", opts...)
+	m.Payload.Token = token
+	m.Payload.OffsetInMilliseconds = int(offset.Seconds() * 1000)
+	return m
+}
+
+// The ProgressReportIntervalElapsed event.
+type ProgressReportIntervalElapsed struct {
+	*Message
+	Payload struct {
+		Token                string `json:"token"`
+		OffsetInMilliseconds int    `json:"offsetInMilliseconds"`
+	} `json:"payload"`
+}
+
+func NewProgressReportIntervalElapsed(messageId, token string, offset time.Duration, opts ...HeaderOption) *ProgressReportIntervalElapsed {
+	m := new(ProgressReportIntervalElapsed)
+	m.Message = newEvent("AudioPlayer", "ProgressReportIntervalElapsed", messageId, "", opts...)
+	m.Payload.Token = token
+	m.Payload.OffsetInMilliseconds = int(offset.Seconds() * 1000)
+	return m
+}
+
+// The StreamMetadataExtracted event.
+type StreamMetadataExtracted struct {
+	*Message
+	Payload struct {
+		Token    string                 `json:"token"`
+		Metadata map[string]interface{} `json:"metadata"`
+	} `json:"payload"`
+}
+
+func NewStreamMetadataExtracted(messageId, token string, metadata map[string]interface{}, opts ...HeaderOption) *StreamMetadataExtracted {
+	m := new(StreamMetadataExtracted)
+	m.Message = newEvent("AudioPlayer", "StreamMetadataExtracted", messageId, "", opts...)
+	m.Payload.Token = token
+	m.Payload.Metadata = metadata
+	return m
+}
+
+// The PlaybackState context.
+//
+// A PlaybackState may be reused for every request while the same item is
+// playing: UpdateOffset only changes the offset, and everything else is
+// encoded once and cached until the header or payload changes.
+type PlaybackState struct {
+	*Message
+	Payload playbackState `json:"payload"`
+
+	mu     sync.Mutex
+	header map[string]string
+	cached playbackState
+	prefix []byte
+	suffix []byte
+}
+
+func NewPlaybackState(token string, offset time.Duration, activity PlayerActivity, opts ...HeaderOption) *PlaybackState {
+	m := new(PlaybackState)
+	m.Message = newContext("AudioPlayer", "PlaybackState", opts...)
+	m.Payload.OffsetInMilliseconds = int(offset.Seconds() * 1000)
+	m.Payload.PlayerActivity = activity
+	m.Payload.Token = token
+	return m
+}
+
+// UpdateOffset sets the offset of the playback state.
+func (m *PlaybackState) UpdateOffset(offset time.Duration) {
+	m.mu.Lock()
+	m.Payload.OffsetInMilliseconds = int(offset.Seconds() * 1000)
+	m.mu.Unlock()
+}
+
+// Buffers for encoding PlaybackState values.
+var playbackStateBuffers = sync.Pool{
+	New: func() interface{} { return new([]byte) },
+}
+
+// MarshalJSON implements the json.Marshaler interface. The output is the same
+// as the default encoding, but only the offset is encoded on every call.
+func (m *PlaybackState) MarshalJSON() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.isCached() {
+		if err := m.encode(); err != nil {
+			return nil, err
+		}
+	}
+	buf := playbackStateBuffers.Get().(*[]byte)
+	b := append((*buf)[:0], m.prefix...)
+	b = strconv.AppendInt(b, int64(m.Payload.OffsetInMilliseconds), 10)
+	b = append(b, m.suffix...)
+	data := make([]byte, len(b))
+	copy(data, b)
+	*buf = b
+	playbackStateBuffers.Put(buf)
+	return data, nil
+}
+
+// Returns whether the cached encoding is still valid. The lock must be held.
+func (m *PlaybackState) isCached() bool {
+	if m.prefix == nil || m.cached.Token != m.Payload.Token || m.cached.PlayerActivity != m.Payload.PlayerActivity {
+		return false
+	}
+	if m.Message == nil || m.header == nil {
+		return m.Message == nil && m.header == nil
+	}
+	if len(m.header) != len(m.Header) {
+		return false
+	}
+	for k, v := range m.Header {
+		if cv, ok := m.header[k]; !ok || cv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Encodes everything but the offset. The lock must be held.
+func (m *PlaybackState) encode() error {
+	token, err := json.Marshal(m.Payload.Token)
+	if err != nil {
+		return err
+	}
+	activity, err := json.Marshal(m.Payload.PlayerActivity)
+	if err != nil {
+		return err
+	}
+	m.prefix = append(m.prefix[:0], '{')
+	m.header = nil
+	if m.Message != nil {
+		header, err := json.Marshal(m.Header)
+		if err != nil {
+			return err
+		}
+		m.prefix = append(m.prefix, `"header":`...)
+		m.prefix = append(m.prefix, header...)
+		m.prefix = append(m.prefix, ',')
+		m.header = make(map[string]string, len(m.Header))
+		for k, v := range m.Header {
+			m.header[k] = v
+		}
+	}
+	m.prefix = append(m.prefix, `"payload":{"token":`...)
+	m.prefix = append(m.prefix, token...)
+	m.prefix = append(m.prefix, `,"offsetInMilliseconds":`...)
+	m.suffix = append(m.suffix[:0], `,"playerActivity":`...)
+	m.suffix = append(m.suffix, activity...)
+	m.suffix = append(m.suffix, "}}"...)
+	m.cached = m.Payload
+	return nil
+}
@@ -0,0 +1,115 @@
+package avs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Encodes a PlaybackState without its MarshalJSON method.
+func marshalPlaybackState(m *PlaybackState) ([]byte, error) {
+	return json.Marshal(struct {
+		*Message
+		Payload playbackState `json:"payload"`
+	}{m.Message, m.Payload})
+}
+
+func TestPlaybackStateMarshal(t *testing.T) {
+	m := NewPlaybackState("token \"1\"", 1500*time.Millisecond, PlayerActivityPlaying)
+	check := func() {
+		t.Helper()
+		got, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := marshalPlaybackState(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+	check()
+	m.UpdateOffset(2 * time.Second)
+	check()
+	m.Payload.Token = "token2"
+	check()
+	m.Payload.PlayerActivity = PlayerActivityPaused
+	check()
+	m.Header["messageId"] = "abc"
+	check()
+}
+
+func BenchmarkPlaybackStateMarshal(b *testing.B) {
+	m := NewPlaybackState("abc123token", 12500*time.Millisecond, PlayerActivityPlaying)
+	b.Run("Default", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m.Payload.OffsetInMilliseconds = i
+			if _, err := marshalPlaybackState(m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m.UpdateOffset(time.Duration(i) * time.Millisecond)
+			if _, err := m.MarshalJSON(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestStreamFormat(t *testing.T) {
+	const play = `{"header":{"namespace":"AudioPlayer","name":"Play","messageId":"m1"},` +
+		`"payload":{"playBehavior":"REPLACE_ALL","audioItem":{"audioItemId":"item1","stream":{"token":"t1","url":"cid:c1"%s}}}}`
+	mp3 := []byte{0xff, 0xfb, 0x90, 0x64}
+	for _, tt := range []struct {
+		stream            string
+		sniff             []byte
+		declared, guessed StreamFormat
+	}{
+		// The v20160207 shape.
+		{`,"streamFormat":"AUDIO_MPEG"`, nil, StreamFormatAudioMPEG, StreamFormatAudioMPEG},
+		// The current shape, without a format.
+		{``, mp3, StreamFormatUnknown, StreamFormatAudioMPEG},
+		{``, []byte("ID3\x04"), StreamFormatUnknown, StreamFormatAudioMPEG},
+		{``, []byte("OggS"), StreamFormatUnknown, StreamFormatUnknown},
+		{``, nil, StreamFormatUnknown, StreamFormatUnknown},
+		{`,"streamFormat":"AUDIO_AAC"`, mp3, "AUDIO_AAC", StreamFormatAudioMPEG},
+		{`,"streamFormat":"AUDIO_AAC"`, nil, "AUDIO_AAC", StreamFormatUnknown},
+		{`,"streamFormat":null`, nil, StreamFormatUnknown, StreamFormatUnknown},
+		{`,"streamFormat":1`, nil, StreamFormatUnknown, StreamFormatUnknown},
+	} {
+		var m Message
+		if err := json.Unmarshal([]byte(fmt.Sprintf(play, tt.stream)), &m); err != nil {
+			t.Fatalf("%s: %v", tt.stream, err)
+		}
+		p, ok := m.Typed().(*Play)
+		if !ok {
+			t.Fatalf("%s: got %#v", tt.stream, m.Typed())
+		}
+		stream := &p.Payload.AudioItem.Stream
+		if stream.StreamFormat != tt.declared {
+			t.Errorf("%s: got format %q; want %q", tt.stream, stream.StreamFormat, tt.declared)
+		}
+		if got := stream.GuessFormat(tt.sniff); got != tt.guessed {
+			t.Errorf("%s: guessed %q from % x; want %q", tt.stream, got, tt.sniff, tt.guessed)
+		}
+		// Formats are encoded again as declared.
+		if tt.declared != StreamFormatUnknown {
+			data, err := json.Marshal(stream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := `"streamFormat":"` + string(tt.declared) + `"`; !strings.Contains(string(data), want) {
+				t.Errorf("%s: encoded as %s", tt.stream, data)
+			}
+		}
+	}
+}
@@ -66,10 +66,14 @@ const (
 	PingPath       = "/ping"
 )
 
+// DefaultEndpointURL is the base endpoint URL for the AVS API in North
+// America.
+const DefaultEndpointURL = "https://avs-alexa-na.amazon.com"
+
 // DefaultClient is the default Client.
 var DefaultClient = &Client{
 	// EndpointURL is the base endpoint URL for the AVS API.
-	EndpointURL: "https://avs-alexa-na.amazon.com",
+	EndpointURL: DefaultEndpointURL,
 }
 
 // CreateDownchannel establishes a persistent connection with AVS and returns a
@@ -0,0 +1,94 @@
+// Package avsaudio has helpers for the audio exchanged with AVS, such as
+// storing Speak attachments and captured microphone audio, and extracting the
+// metadata of audio player streams.
+package avsaudio
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Format is the container format of an audio attachment.
+type Format int
+
+// Possible values for Format.
+const (
+	FormatUnknown Format = iota
+	FormatMP3
+	FormatOggOpus
+	FormatWAV
+)
+
+// Extension returns the usual file extension for the format, including the
+// dot, or an empty string if the format is unknown.
+func (f Format) Extension() string {
+	switch f {
+	case FormatMP3:
+		return ".mp3"
+	case FormatOggOpus:
+		return ".opus"
+	case FormatWAV:
+		return ".wav"
+	}
+	return ""
+}
+
+// String returns the name of the format.
+func (f Format) String() string {
+	switch f {
+	case FormatMP3:
+		return "MP3"
+	case FormatOggOpus:
+		return "Ogg/Opus"
+	case FormatWAV:
+		return "WAV"
+	}
+	return "unknown"
+}
+
+// SniffLen is the number of bytes that Sniff needs to recognize a format.
+const SniffLen = 36
+
+// Sniff returns the format of the audio that starts with data.
+func Sniff(data []byte) Format {
+	switch {
+	case bytes.HasPrefix(data, []byte("ID3")):
+		return FormatMP3
+	case len(data) >= 2 && data[0] == 0xff && data[1]&0xe0 == 0xe0:
+		// An MPEG audio frame sync.
+		return FormatMP3
+	case bytes.HasPrefix(data, []byte("OggS")):
+		// The first page of an Ogg/Opus stream carries the OpusHead packet.
+		if len(data) >= 36 && bytes.Equal(data[28:36], []byte("OpusHead")) {
+			return FormatOggOpus
+		}
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WAVE")):
+		return FormatWAV
+	}
+	return FormatUnknown
+}
+
+// SaveAttachment writes the audio to a file. If path has no extension, the
+// one for the sniffed format is added. It returns the path of the file.
+func SaveAttachment(r io.Reader, path string) (string, error) {
+	br := bufio.NewReader(r)
+	if filepath.Ext(path) == "" {
+		head, err := br.Peek(SniffLen)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		path += Sniff(head).Extension()
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, br); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
@@ -0,0 +1,54 @@
+package avsaudio
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+	opus := append([]byte("OggS"), make([]byte, 24)...)
+	opus = append(opus, "OpusHead"...)
+	tests := []struct {
+		data []byte
+		want Format
+	}{
+		{[]byte("ID3\x04\x00"), FormatMP3},
+		{[]byte{0xff, 0xfb, 0x90, 0x64}, FormatMP3},
+		{opus, FormatOggOpus},
+		{[]byte("OggS"), FormatUnknown},
+		{wavHeader(0, 16000, 1), FormatWAV},
+		{[]byte("hello"), FormatUnknown},
+		{nil, FormatUnknown},
+	}
+	for _, test := range tests {
+		if got := Sniff(test.data); got != test.want {
+			t.Errorf("Sniff(%q) = %s; want %s", test.data, got, test.want)
+		}
+	}
+}
+
+func TestSaveAttachment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "avsaudio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mp3 := []byte{0xff, 0xfb, 0x90, 0x64, 1, 2, 3}
+	path, err := SaveAttachment(bytes.NewReader(mp3), filepath.Join(dir, "speak"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "speak.mp3" {
+		t.Errorf("got path %s; want speak.mp3", path)
+	}
+	if data, _ := ioutil.ReadFile(path); !bytes.Equal(data, mp3) {
+		t.Errorf("got %v; want %v", data, mp3)
+	}
+	path, err = SaveAttachment(bytes.NewReader(mp3), filepath.Join(dir, "speak.bin"))
+	if err != nil || filepath.Base(path) != "speak.bin" {
+		t.Errorf("an explicit extension should be kept, got %s, %v", path, err)
+	}
+}
@@ -0,0 +1,241 @@
+package avsaudio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode/utf16"
+)
+
+// ErrInvalidMetadata is returned by the readers of NewICYReader and
+// NewID3Reader when the metadata of the stream is corrupt or truncated.
+var ErrInvalidMetadata = errors.New("avsaudio: invalid stream metadata")
+
+// The largest ID3v2 tag that is parsed. Larger tags (e.g., with embedded
+// pictures) are skipped without being held in memory.
+const maxID3TagSize = 1 << 20
+
+// NewICYReader returns a reader of the audio of an ICY stream (e.g., an
+// internet radio), which has a metadata block after every metaint bytes of
+// audio (see the icy-metaint response header). The blocks are stripped, and
+// the fields of the ones that aren't empty (e.g., StreamTitle) are passed to
+// onMetadata, from Read. A truncated block, or one without any field, is an
+// ErrInvalidMetadata error.
+func NewICYReader(r io.Reader, metaint int, onMetadata func(map[string]string)) io.Reader {
+	return &icyReader{r: r, metaint: metaint, left: metaint, onMetadata: onMetadata}
+}
+
+type icyReader struct {
+	r          io.Reader
+	metaint    int
+	left       int // bytes of audio until the next block
+	onMetadata func(map[string]string)
+	err        error
+}
+
+func (r *icyReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.metaint <= 0 {
+		return r.r.Read(p)
+	}
+	if r.left == 0 {
+		if r.err = r.readBlock(); r.err != nil {
+			return 0, r.err
+		}
+		r.left = r.metaint
+	}
+	if len(p) > r.left {
+		p = p[:r.left]
+	}
+	n, err := r.r.Read(p)
+	r.left -= n
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// Reads the metadata block, whose length is given by its first byte in units
+// of 16 bytes.
+func (r *icyReader) readBlock() error {
+	var length [1]byte
+	if _, err := io.ReadFull(r.r, length[:]); err != nil {
+		return err
+	}
+	if length[0] == 0 {
+		return nil
+	}
+	block := make([]byte, int(length[0])*16)
+	if _, err := io.ReadFull(r.r, block); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrInvalidMetadata
+		}
+		return err
+	}
+	text := string(bytes.TrimRight(block, "\x00"))
+	if text == "" {
+		return nil
+	}
+	fields := parseICY(text)
+	if len(fields) == 0 {
+		return ErrInvalidMetadata
+	}
+	if r.onMetadata != nil {
+		r.onMetadata(fields)
+	}
+	return nil
+}
+
+// Parses the fields of a metadata block, like StreamTitle='A - B';. Values
+// may contain quotes.
+func parseICY(block string) map[string]string {
+	fields := make(map[string]string)
+	for block != "" {
+		i := strings.Index(block, "='")
+		if i < 0 {
+			break
+		}
+		key := block[:i]
+		block = block[i+2:]
+		end := strings.Index(block, "';")
+		if end < 0 {
+			end = strings.LastIndexByte(block, '\'')
+			if end < 0 {
+				end = len(block)
+			}
+		}
+		fields[key] = block[:end]
+		block = strings.TrimPrefix(block[end:], "'")
+		block = strings.TrimPrefix(block, ";")
+	}
+	return fields
+}
+
+// NewID3Reader returns a reader of the audio that follows the ID3v2 tag at
+// the start of r, if any. The text frames of the tag are passed to
+// onMetadata by their id (e.g., TIT2 for the title), on the first call to
+// Read. Tags over 1 MiB are skipped without being parsed, and a truncated tag
+// is an ErrInvalidMetadata error.
+func NewID3Reader(r io.Reader, onMetadata func(map[string]string)) io.Reader {
+	return &id3Reader{r: bufio.NewReader(r), onMetadata: onMetadata}
+}
+
+type id3Reader struct {
+	r          *bufio.Reader
+	onMetadata func(map[string]string)
+	started    bool
+}
+
+func (r *id3Reader) Read(p []byte) (int, error) {
+	if !r.started {
+		r.started = true
+		if err := r.readTag(); err != nil {
+			return 0, err
+		}
+	}
+	return r.r.Read(p)
+}
+
+func (r *id3Reader) readTag() error {
+	header, err := r.r.Peek(10)
+	if err != nil || string(header[:3]) != "ID3" {
+		// Too short for a tag, or no tag: it's all audio.
+		return nil
+	}
+	major, flags := header[3], header[5]
+	size, ok := syncsafe(header[6:10])
+	if !ok {
+		return ErrInvalidMetadata
+	}
+	if flags&0x10 != 0 {
+		size += 10 // footer
+	}
+	r.r.Discard(10)
+	if size > maxID3TagSize {
+		if _, err := io.CopyN(ioutil.Discard, r.r, int64(size)); err != nil {
+			return ErrInvalidMetadata
+		}
+		return nil
+	}
+	tag := make([]byte, size)
+	if _, err := io.ReadFull(r.r, tag); err != nil {
+		return ErrInvalidMetadata
+	}
+	// Frames behind an extended header or unsynchronized aren't parsed.
+	if flags&0xc0 != 0 || (major != 3 && major != 4) {
+		return nil
+	}
+	if fields := parseID3Frames(tag, major); len(fields) > 0 && r.onMetadata != nil {
+		r.onMetadata(fields)
+	}
+	return nil
+}
+
+// Returns the text frames of an ID3v2.3 or ID3v2.4 tag.
+func parseID3Frames(tag []byte, major byte) map[string]string {
+	fields := make(map[string]string)
+	for len(tag) >= 10 && tag[0] != 0 {
+		id := string(tag[:4])
+		size := int(binary.BigEndian.Uint32(tag[4:8]))
+		if major == 4 {
+			n, ok := syncsafe(tag[4:8])
+			if !ok {
+				break
+			}
+			size = n
+		}
+		if size > len(tag)-10 {
+			break
+		}
+		if body := tag[10 : 10+size]; id[0] == 'T' && id != "TXXX" && len(body) > 0 {
+			fields[id] = decodeID3Text(body[0], body[1:])
+		}
+		tag = tag[10+size:]
+	}
+	return fields
+}
+
+// Decodes the text of a frame in its encoding: ISO-8859-1, UTF-16 with a
+// byte order mark, UTF-16BE or UTF-8.
+func decodeID3Text(encoding byte, text []byte) string {
+	switch encoding {
+	case 1, 2:
+		order := binary.ByteOrder(binary.BigEndian)
+		if encoding == 1 && len(text) >= 2 {
+			if text[0] == 0xff && text[1] == 0xfe {
+				order = binary.LittleEndian
+			}
+			text = text[2:]
+		}
+		units := make([]uint16, len(text)/2)
+		for i := range units {
+			units[i] = order.Uint16(text[2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	case 3:
+		return strings.TrimRight(string(text), "\x00")
+	}
+	runes := make([]rune, len(text))
+	for i, b := range text {
+		runes[i] = rune(b)
+	}
+	return strings.TrimRight(string(runes), "\x00")
+}
+
+// Decodes a 28-bit syncsafe integer, whose bytes have their high bit clear.
+func syncsafe(b []byte) (int, bool) {
+	n := 0
+	for _, c := range b {
+		if c&0x80 != 0 {
+			return 0, false
+		}
+		n = n<<7 | int(c)
+	}
+	return n, true
+}
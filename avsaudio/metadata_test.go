@@ -0,0 +1,139 @@
+package avsaudio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"testing/iotest"
+	"unicode/utf16"
+)
+
+// Returns an ICY stream of the audio with the metadata blocks inserted every
+// metaint bytes, along with the audio.
+func icyStream(metaint int, blocks ...string) (stream, audio []byte) {
+	var buf bytes.Buffer
+	for i, block := range blocks {
+		chunk := bytes.Repeat([]byte{byte('a' + i)}, metaint)
+		audio = append(audio, chunk...)
+		buf.Write(chunk)
+		n := (len(block) + 15) / 16
+		buf.WriteByte(byte(n))
+		buf.WriteString(block)
+		buf.Write(make([]byte, n*16-len(block)))
+	}
+	// Some audio after the last block.
+	buf.WriteString("end")
+	return buf.Bytes(), append(audio, "end"...)
+}
+
+func TestICYReader(t *testing.T) {
+	stream, audio := icyStream(100,
+		"StreamTitle='Artist - It's a Song';StreamUrl='https://example.com';",
+		"",
+		"StreamTitle='Next';",
+	)
+	for name, wrap := range map[string]func(io.Reader) io.Reader{
+		"whole":   func(r io.Reader) io.Reader { return r },
+		"onebyte": iotest.OneByteReader,
+		"halves":  iotest.HalfReader,
+	} {
+		var got []map[string]string
+		r := NewICYReader(wrap(bytes.NewReader(stream)), 100, func(m map[string]string) { got = append(got, m) })
+		// Reads of 37 bytes land mid-buffer across the blocks.
+		var out []byte
+		buf := make([]byte, 37)
+		for {
+			n, err := r.Read(buf)
+			out = append(out, buf[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		if !bytes.Equal(out, audio) {
+			t.Errorf("%s: got audio %q", name, out)
+		}
+		want := []map[string]string{
+			{"StreamTitle": "Artist - It's a Song", "StreamUrl": "https://example.com"},
+			{"StreamTitle": "Next"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got metadata %v, want %v", name, got, want)
+		}
+	}
+
+	// A block cut short, or without any field, is an error.
+	stream, _ = icyStream(10, "StreamTitle='Cut';")
+	if _, err := ioutil.ReadAll(NewICYReader(bytes.NewReader(stream[:15]), 10, nil)); err != ErrInvalidMetadata {
+		t.Errorf("got %v for a truncated block, want ErrInvalidMetadata", err)
+	}
+	stream, _ = icyStream(10, "\xff\xfbnot metadata")
+	if _, err := ioutil.ReadAll(NewICYReader(bytes.NewReader(stream), 10, nil)); err != ErrInvalidMetadata {
+		t.Errorf("got %v for a block of audio, want ErrInvalidMetadata", err)
+	}
+}
+
+// Returns an ID3v2.3 frame.
+func id3Frame(id string, body []byte) []byte {
+	frame := make([]byte, 10, 10+len(body))
+	copy(frame, id)
+	binary.BigEndian.PutUint32(frame[4:], uint32(len(body)))
+	return append(frame, body...)
+}
+
+func TestID3Reader(t *testing.T) {
+	title := []byte{1, 0xff, 0xfe}
+	for _, u := range utf16.Encode([]rune("Café")) {
+		title = append(title, byte(u), byte(u>>8))
+	}
+	var frames []byte
+	frames = append(frames, id3Frame("TIT2", title)...)
+	frames = append(frames, id3Frame("TPE1", []byte("\x00Art\xefst"))...)
+	frames = append(frames, id3Frame("APIC", []byte("image"))...)
+	frames = append(frames, make([]byte, 16)...) // padding
+	size := len(frames)
+	tag := append([]byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}, frames...)
+	audio := []byte("\xff\xfbaudio")
+
+	var got map[string]string
+	data, err := ioutil.ReadAll(NewID3Reader(iotest.OneByteReader(bytes.NewReader(append(tag, audio...))), func(m map[string]string) { got = m }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, audio) {
+		t.Errorf("got audio %q", data)
+	}
+	if want := map[string]string{"TIT2": "Café", "TPE1": "Artïst"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got metadata %v, want %v", got, want)
+	}
+
+	// Streams without a tag are left alone.
+	data, _ = ioutil.ReadAll(NewID3Reader(bytes.NewReader(audio), nil))
+	if !bytes.Equal(data, audio) {
+		t.Errorf("got %q without a tag", data)
+	}
+	if _, err := ioutil.ReadAll(NewID3Reader(bytes.NewReader(tag[:20]), nil)); err != ErrInvalidMetadata {
+		t.Errorf("got %v for a truncated tag, want ErrInvalidMetadata", err)
+	}
+
+	// A tag over the limit is skipped, not parsed: its size can't make the
+	// reader allocate it.
+	size = maxID3TagSize + 1
+	huge := append([]byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}, frames...)
+	huge = append(huge, make([]byte, size-len(frames))...)
+	got = nil
+	data, err = ioutil.ReadAll(NewID3Reader(bytes.NewReader(append(huge, audio...)), func(m map[string]string) { got = m }))
+	if err != nil || !bytes.Equal(data, audio) || got != nil {
+		t.Errorf("got %q, %v and metadata %v for an oversized tag; want the audio alone", data, err, got)
+	}
+	size = 0x0fffffff
+	huge = []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	if _, err := ioutil.ReadAll(NewID3Reader(bytes.NewReader(append(huge, frames...)), nil)); err != ErrInvalidMetadata {
+		t.Errorf("got %v for a truncated oversized tag, want ErrInvalidMetadata", err)
+	}
+}
@@ -0,0 +1,57 @@
+package avsaudio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+// WrapWAV returns a reader of a WAV file with the PCM audio, which must be
+// 16-bit little-endian samples. The audio is read in full on the first call
+// to Read, since the header holds its length.
+func WrapWAV(pcm io.Reader, sampleRate, channels int) io.Reader {
+	return &wavReader{pcm: pcm, sampleRate: sampleRate, channels: channels}
+}
+
+type wavReader struct {
+	pcm        io.Reader
+	sampleRate int
+	channels   int
+	r          io.Reader
+}
+
+func (w *wavReader) Read(p []byte) (int, error) {
+	if w.r == nil {
+		data, err := ioutil.ReadAll(w.pcm)
+		if err != nil {
+			return 0, err
+		}
+		w.r = io.MultiReader(bytes.NewReader(wavHeader(len(data), w.sampleRate, w.channels)), bytes.NewReader(data))
+	}
+	return w.r.Read(p)
+}
+
+// Returns the 44 byte header of a 16-bit PCM WAV file.
+func wavHeader(dataLen, sampleRate, channels int) []byte {
+	const bitsPerSample = 16
+	blockAlign := channels * bitsPerSample / 8
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+dataLen))
+	buf.WriteString("WAVEfmt ")
+	for _, v := range []interface{}{
+		uint32(16), // Size of the fmt chunk.
+		uint16(1),  // PCM.
+		uint16(channels),
+		uint32(sampleRate),
+		uint32(sampleRate * blockAlign),
+		uint16(blockAlign),
+		uint16(bitsPerSample),
+	} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(dataLen))
+	return buf.Bytes()
+}
@@ -0,0 +1,29 @@
+package avsaudio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+func TestWrapWAV(t *testing.T) {
+	pcm := make([]byte, 3200)
+	data, err := ioutil.ReadAll(WrapWAV(bytes.NewReader(pcm), 16000, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 44+len(pcm) || Sniff(data) != FormatWAV {
+		t.Fatalf("got %d bytes of %s", len(data), Sniff(data))
+	}
+	le := binary.LittleEndian
+	if size := le.Uint32(data[4:]); size != uint32(36+len(pcm)) {
+		t.Errorf("got RIFF size %d", size)
+	}
+	if rate, byteRate := le.Uint32(data[24:]), le.Uint32(data[28:]); rate != 16000 || byteRate != 32000 {
+		t.Errorf("got sample rate %d and byte rate %d", rate, byteRate)
+	}
+	if string(data[36:40]) != "data" || le.Uint32(data[40:]) != uint32(len(pcm)) {
+		t.Errorf("unexpected data chunk header %q", data[36:44])
+	}
+}
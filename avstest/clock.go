@@ -0,0 +1,164 @@
+// Package avstest provides utilities for testing code that uses the avs
+// package.
+package avstest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fika-io/go-avs"
+)
+
+// FakeClock is an avs.Clock whose time only changes when told to. Timers and
+// tickers fire as the clock is advanced past their deadlines.
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeTimer
+}
+
+// NewFakeClock returns a FakeClock set to the provided time.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the current time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the time once the clock has been
+// advanced by d.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// NewTimer returns a timer that fires once the clock has been advanced by d.
+func (c *FakeClock) NewTimer(d time.Duration) avs.Timer {
+	return c.add(d, 0)
+}
+
+// NewTicker returns a ticker that fires every time the clock has been
+// advanced by d.
+func (c *FakeClock) NewTicker(d time.Duration) avs.Ticker {
+	if d <= 0 {
+		panic("avstest: non-positive interval for NewTicker")
+	}
+	return fakeTicker{c.add(d, d)}
+}
+
+// Advance moves the clock forward by d, firing any timers and tickers along
+// the way in chronological order.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	target := c.now.Add(d)
+	for {
+		var next *fakeTimer
+		for _, t := range c.waiters {
+			if !t.when.After(target) && (next == nil || t.when.Before(next.when)) {
+				next = t
+			}
+		}
+		if next == nil {
+			break
+		}
+		if next.when.After(c.now) {
+			c.now = next.when
+		}
+		select {
+		case next.ch <- c.now:
+		default:
+		}
+		if next.period > 0 {
+			next.when = next.when.Add(next.period)
+		} else {
+			c.remove(next)
+		}
+	}
+	c.now = target
+}
+
+// Waiters returns the number of timers and tickers currently waiting for the
+// clock to advance.
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// BlockUntil blocks until at least n timers and tickers are waiting for the
+// clock to advance. It's useful to make sure that a goroutine has started
+// waiting before advancing the clock.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+func (c *FakeClock) add(d, period time.Duration) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, ch: make(chan time.Time, 1), period: period}
+	t.when = c.now.Add(d)
+	if d <= 0 && period == 0 {
+		t.ch <- c.now
+		return t
+	}
+	c.waiters = append(c.waiters, t)
+	c.cond.Broadcast()
+	return t
+}
+
+// Removes a timer. Returns whether it was waiting.
+func (c *FakeClock) remove(t *fakeTimer) bool {
+	for i, w := range c.waiters {
+		if w == t {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	c      *FakeClock
+	ch     chan time.Time
+	when   time.Time
+	period time.Duration
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	active := t.c.remove(t)
+	t.when = t.c.now.Add(d)
+	t.c.waiters = append(t.c.waiters, t)
+	t.c.cond.Broadcast()
+	return active
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	return t.c.remove(t)
+}
+
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
@@ -0,0 +1,51 @@
+package avstest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClockTimers(t *testing.T) {
+	start := time.Date(2016, 2, 7, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+	timer := c.NewTimer(2 * time.Second)
+	ticker := c.NewTicker(time.Second)
+	after := c.After(3 * time.Second)
+
+	c.Advance(time.Second)
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired early")
+	default:
+	}
+	if now := <-ticker.C(); !now.Equal(start.Add(time.Second)) {
+		t.Errorf("ticker fired at %s", now)
+	}
+
+	c.Advance(2 * time.Second)
+	if now := <-timer.C(); !now.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("timer fired at %s", now)
+	}
+	if now := <-after; !now.Equal(start.Add(3 * time.Second)) {
+		t.Errorf("After fired at %s", now)
+	}
+	ticker.Stop()
+	if n := c.Waiters(); n != 0 {
+		t.Errorf("%d waiters left", n)
+	}
+	if now := c.Now(); !now.Equal(start.Add(3 * time.Second)) {
+		t.Errorf("Now() = %s", now)
+	}
+}
+
+func TestFakeClockBlockUntil(t *testing.T) {
+	c := NewFakeClock(time.Now())
+	done := make(chan bool)
+	go func() {
+		<-c.After(time.Minute)
+		close(done)
+	}()
+	c.BlockUntil(1)
+	c.Advance(time.Minute)
+	<-done
+}
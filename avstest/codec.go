@@ -0,0 +1,78 @@
+package avstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+)
+
+// CheckJSONCodec fails the test if the codec would change what the package
+// sends or receives compared to encoding/json: json.RawMessage values must be
+// kept byte for byte, numbers decoded as json.Number must keep all their
+// digits, fields must be matched in any order and messages must be encoded
+// exactly like encoding/json encodes them.
+func CheckJSONCodec(t testing.TB, codec avs.JSONCodec) {
+	t.Helper()
+
+	// Raw payloads are kept as is, including their whitespace.
+	raw := `{"header":{"name":"Unknown","namespace":"Future"},"payload":{ "token" : "t1", "n": 1.50 }}`
+	var m avs.Message
+	if err := codec.Unmarshal([]byte(raw), &m); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got, want := string(m.Payload), `{ "token" : "t1", "n": 1.50 }`; got != want {
+		t.Errorf("decoded raw payload %s, want %s", got, want)
+	}
+	if data, err := codec.Marshal(&m); err != nil || string(data) != `{"header":{"name":"Unknown","namespace":"Future"},"payload":{"token":"t1","n":1.50}}` {
+		t.Errorf("encoded raw payload %s (%v)", data, err)
+	}
+
+	// Large numbers survive a round trip.
+	const number = `{"offsets":[1234567890123456789,9007199254740993]}`
+	dec := codec.NewDecoder(strings.NewReader(number + " "))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if dec.More() {
+		t.Error("More reported a value after the last one")
+	}
+	if data, err := codec.Marshal(v); err != nil || string(data) != number {
+		t.Errorf("got %s (%v) after a round trip, want %s", data, err, number)
+	}
+
+	// The payload may come before the header.
+	var reordered avs.Message
+	if err := codec.Unmarshal([]byte(`{"payload":{"token":"t1"},"header":{"namespace":"SpeechSynthesizer","name":"Speak"}}`), &reordered); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if reordered.Type() != avs.TypeSpeak || string(reordered.Payload) != `{"token":"t1"}` {
+		t.Errorf("decoded %s with payload %s out of order", reordered.Type(), reordered.Payload)
+	}
+
+	// The wire output is the same as encoding/json's.
+	request := avs.NewRequest("token")
+	request.Event = avs.NewRecognize("m1", "d1")
+	request.AddContext(avs.NewPlaybackState("token <&>", 1500*time.Millisecond, avs.PlayerActivityPlaying))
+	request.AddContext(avs.NewVolumeState(50, false))
+	request.AddContext(avs.NewSpeechState("t1", 0, avs.PlayerActivityFinished))
+	for _, value := range []interface{}{request, avs.NewSpeechStarted("m2", "t1"), avs.NewSetAlertSucceeded("m3", "a1")} {
+		want, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := codec.Marshal(value)
+		if err != nil {
+			t.Errorf("Marshal %T: %v", value, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("encoded %T as\n%s\nwant\n%s", value, got, want)
+		}
+	}
+}
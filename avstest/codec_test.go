@@ -0,0 +1,11 @@
+package avstest
+
+import (
+	"testing"
+
+	"github.com/fika-io/go-avs"
+)
+
+func TestCheckJSONCodec(t *testing.T) {
+	CheckJSONCodec(t, avs.DefaultJSONCodec)
+}
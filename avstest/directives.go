@@ -0,0 +1,202 @@
+package avstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/fika-io/go-avs"
+)
+
+// The number of the last message id returned by NextMessageId.
+var messageIds int64
+
+// NextMessageId returns the next message id of the directives built by this
+// package: "message-1", "message-2" and so on. The sequence is shared by all
+// the tests of a package; ResetMessageIds starts it over.
+func NextMessageId() string {
+	return fmt.Sprintf("message-%d", atomic.AddInt64(&messageIds, 1))
+}
+
+// ResetMessageIds starts the sequence of NextMessageId over.
+func ResetMessageIds() {
+	atomic.StoreInt64(&messageIds, 0)
+}
+
+// SetDialogRequestId makes the directive part of the interaction started by
+// the event with the dialog request id, as if it were in its response.
+func SetDialogRequestId(directive avs.TypedMessage, dialogRequestId string) {
+	directive.GetMessage().Header["dialogRequestId"] = dialogRequestId
+}
+
+// Builds the directive as AVS would send it and parses it like the Client
+// does, so that it's the same as a directive that was received.
+func directive(typ avs.MessageType, header map[string]string, payload interface{}) avs.TypedMessage {
+	h := map[string]string{
+		"namespace": typ.Namespace,
+		"name":      typ.Name,
+		"messageId": NextMessageId(),
+	}
+	for k, v := range header {
+		h[k] = v
+	}
+	if payload == nil {
+		payload = struct{}{}
+	}
+	data, err := json.Marshal(map[string]interface{}{"header": h, "payload": payload})
+	if err != nil {
+		panic(err)
+	}
+	m, err := avs.TypedFromReader(bytes.NewReader(data))
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+/********** Alexa **********/
+
+// EventProcessed returns an Alexa.EventProcessed directive for the event with
+// the correlation token.
+func EventProcessed(eventCorrelationToken string) *avs.EventProcessed {
+	return directive(avs.TypeEventProcessed, map[string]string{"eventCorrelationToken": eventCorrelationToken}, nil).(*avs.EventProcessed)
+}
+
+// ReportState returns an Alexa.ReportState directive with the correlation
+// token.
+func ReportState(correlationToken string) *avs.ReportState {
+	return directive(avs.TypeReportState, map[string]string{"correlationToken": correlationToken, "payloadVersion": "3"}, nil).(*avs.ReportState)
+}
+
+/********** Alexa.ApiGateway **********/
+
+// SetGateway returns an Alexa.ApiGateway.SetGateway directive.
+func SetGateway(gateway string) *avs.SetGateway {
+	return directive(avs.TypeSetGateway, nil, map[string]string{"gateway": gateway}).(*avs.SetGateway)
+}
+
+/********** Alerts **********/
+
+// SetAlert returns an Alerts.SetAlert directive for an alert with the token.
+func SetAlert(token string, alertType avs.AlertType, scheduledTime time.Time) *avs.SetAlert {
+	return directive(avs.TypeSetAlert, nil, avs.Alert{
+		Token:         token,
+		Type:          alertType,
+		ScheduledTime: avs.Timestamp{Time: scheduledTime},
+	}).(*avs.SetAlert)
+}
+
+// DeleteAlert returns an Alerts.DeleteAlert directive for the alert with the
+// token.
+func DeleteAlert(token string) *avs.DeleteAlert {
+	return directive(avs.TypeDeleteAlert, nil, map[string]string{"token": token}).(*avs.DeleteAlert)
+}
+
+/********** AudioPlayer **********/
+
+// Play returns an AudioPlayer.Play directive for a stream with the token and
+// URL, which may be a cid: URL.
+func Play(token, url string, behavior avs.PlayBehavior) *avs.Play {
+	return directive(avs.TypePlay, nil, map[string]interface{}{
+		"playBehavior": behavior,
+		"audioItem": avs.AudioItem{
+			AudioItemId: "audio-item-" + token,
+			Stream: avs.Stream{
+				Token: token,
+				URL:   url,
+			},
+		},
+	}).(*avs.Play)
+}
+
+// ClearQueue returns an AudioPlayer.ClearQueue directive.
+func ClearQueue(behavior avs.ClearBehavior) *avs.ClearQueue {
+	return directive(avs.TypeClearQueue, nil, map[string]interface{}{"clearBehavior": behavior}).(*avs.ClearQueue)
+}
+
+// Stop returns an AudioPlayer.Stop directive.
+func Stop() *avs.Stop {
+	return directive(avs.TypeStop, nil, nil).(*avs.Stop)
+}
+
+/********** Notifications **********/
+
+// SetIndicator returns a Notifications.SetIndicator directive. The audio
+// indicator is played with the asset if playAudio is true.
+func SetIndicator(persistVisual, playAudio bool, asset avs.AlertAsset) *avs.SetIndicator {
+	return directive(avs.TypeSetIndicator, nil, map[string]interface{}{
+		"persistVisualIndicator": persistVisual,
+		"playAudioIndicator":     playAudio,
+		"asset":                  asset,
+	}).(*avs.SetIndicator)
+}
+
+// ClearIndicator returns a Notifications.ClearIndicator directive.
+func ClearIndicator() *avs.ClearIndicator {
+	return directive(avs.TypeClearIndicator, nil, nil).(*avs.ClearIndicator)
+}
+
+/********** Speaker **********/
+
+// SetVolume returns a Speaker.SetVolume directive.
+func SetVolume(volume int) *avs.SetVolume {
+	return directive(avs.TypeSetVolume, nil, map[string]int{"volume": volume}).(*avs.SetVolume)
+}
+
+// AdjustVolume returns a Speaker.AdjustVolume directive.
+func AdjustVolume(volume int) *avs.AdjustVolume {
+	return directive(avs.TypeAdjustVolume, nil, map[string]int{"volume": volume}).(*avs.AdjustVolume)
+}
+
+// SetMute returns a Speaker.SetMute directive.
+func SetMute(mute bool) *avs.SetMute {
+	return directive(avs.TypeSetMute, nil, map[string]bool{"mute": mute}).(*avs.SetMute)
+}
+
+/********** SpeechRecognizer **********/
+
+// ExpectSpeech returns a SpeechRecognizer.ExpectSpeech directive.
+func ExpectSpeech(timeout time.Duration) *avs.ExpectSpeech {
+	return directive(avs.TypeExpectSpeech, nil, map[string]int64{
+		"timeoutInMilliseconds": int64(timeout / time.Millisecond),
+	}).(*avs.ExpectSpeech)
+}
+
+// SetWakeWords returns a SpeechRecognizer.SetWakeWords directive with the wake
+// words in the default scope.
+func SetWakeWords(wakeWords ...string) *avs.SetWakeWords {
+	return directive(avs.TypeSetWakeWords, nil, map[string][]avs.ScopedWakeWords{
+		"wakeWords": {{Scopes: []string{avs.WakeWordScope}, Values: wakeWords}},
+	}).(*avs.SetWakeWords)
+}
+
+// StopCapture returns a SpeechRecognizer.StopCapture directive.
+func StopCapture() *avs.StopCapture {
+	return directive(avs.TypeStopCapture, nil, nil).(*avs.StopCapture)
+}
+
+/********** SpeechSynthesizer **********/
+
+// Speak returns a SpeechSynthesizer.Speak directive for the MP3 speech
+// attached with the content id.
+func Speak(contentId string) *avs.Speak {
+	return directive(avs.TypeSpeak, nil, map[string]string{
+		"format": "AUDIO_MPEG",
+		"url":    "cid:" + contentId,
+		"token":  "speak-" + contentId,
+	}).(*avs.Speak)
+}
+
+/********** System **********/
+
+// SetEndpoint returns a System.SetEndpoint directive.
+func SetEndpoint(endpoint string) *avs.SetEndpoint {
+	return directive(avs.TypeSetEndpoint, nil, map[string]string{"endpoint": endpoint}).(*avs.SetEndpoint)
+}
+
+// ResetUserInactivity returns a System.ResetUserInactivity directive.
+func ResetUserInactivity() *avs.ResetUserInactivity {
+	return directive(avs.TypeResetUserInactivity, nil, nil).(*avs.ResetUserInactivity)
+}
@@ -0,0 +1,76 @@
+package avstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+)
+
+func TestDirectives(t *testing.T) {
+	ResetMessageIds()
+	directives := map[avs.MessageType]avs.TypedMessage{
+		avs.TypeEventProcessed:      EventProcessed("ect1"),
+		avs.TypeReportState:         ReportState("ct1"),
+		avs.TypeSetGateway:          SetGateway("https://alexa.na.gateway.devices.a2z.com"),
+		avs.TypeSetAlert:            SetAlert("alert1", avs.AlertTypeTimer, time.Unix(1500000000, 0)),
+		avs.TypeDeleteAlert:         DeleteAlert("alert1"),
+		avs.TypePlay:                Play("track1", "https://example.com/track1.mp3", avs.PlayBehaviorReplaceAll),
+		avs.TypeClearQueue:          ClearQueue(avs.ClearBehaviorClearAll),
+		avs.TypeStop:                Stop(),
+		avs.TypeSetIndicator:        SetIndicator(true, true, avs.AlertAsset{AssetId: "tone", URL: "https://example.com/tone.mp3"}),
+		avs.TypeClearIndicator:      ClearIndicator(),
+		avs.TypeSetVolume:           SetVolume(50),
+		avs.TypeAdjustVolume:        AdjustVolume(-10),
+		avs.TypeSetMute:             SetMute(true),
+		avs.TypeExpectSpeech:        ExpectSpeech(8 * time.Second),
+		avs.TypeSetWakeWords:        SetWakeWords("ALEXA"),
+		avs.TypeStopCapture:         StopCapture(),
+		avs.TypeSpeak:               Speak("speech1"),
+		avs.TypeSetEndpoint:         SetEndpoint("https://avs-alexa-eu.amazon.com"),
+		avs.TypeResetUserInactivity: ResetUserInactivity(),
+	}
+	// Every directive has a constructor.
+	for _, typ := range avs.RegisteredDirectives() {
+		if directives[typ] == nil {
+			t.Errorf("%s has no constructor", typ)
+		}
+	}
+	ids := make(map[string]bool)
+	for typ, directive := range directives {
+		m := directive.GetMessage()
+		if m.Type() != typ {
+			t.Errorf("got a %s; want a %s", m.Type(), typ)
+		}
+		if err := m.Validate(); err != nil {
+			t.Errorf("%s: %v", typ, err)
+		}
+		if m.Typed() != directive {
+			t.Errorf("%s: Typed doesn't return the directive", typ)
+		}
+		ids[m.Header["messageId"]] = true
+	}
+	if len(ids) != len(directives) || !ids["message-1"] || !ids["message-19"] {
+		t.Errorf("got message ids %v", ids)
+	}
+
+	play := directives[avs.TypePlay].(*avs.Play)
+	if play.Payload.AudioItem.Stream.Token != "track1" || play.Payload.PlayBehavior != avs.PlayBehaviorReplaceAll {
+		t.Errorf("got %+v", play.Payload)
+	}
+	indicator := directives[avs.TypeSetIndicator].(*avs.SetIndicator)
+	if !indicator.Payload.PersistVisualIndicator || !indicator.Payload.PlayAudioIndicator || indicator.Payload.Asset.AssetId != "tone" {
+		t.Errorf("got %+v", indicator.Payload)
+	}
+	speak := directives[avs.TypeSpeak].(*avs.Speak)
+	if speak.ContentId() != "speech1" {
+		t.Errorf("got content id %q", speak.ContentId())
+	}
+	if d := directives[avs.TypeExpectSpeech].(*avs.ExpectSpeech).Timeout(); d != 8*time.Second {
+		t.Errorf("got timeout %s", d)
+	}
+	SetDialogRequestId(speak, "d1")
+	if id, _ := speak.DialogRequestId(); id != "d1" {
+		t.Errorf("got dialog request id %q", id)
+	}
+}
@@ -0,0 +1,66 @@
+package avstest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// PipeListener is an in-memory net.Listener whose connections are opened
+// with its DialContext method, so that a client and a server can talk
+// without any network:
+//
+//	ln := avstest.NewPipeListener()
+//	go http.Serve(ln, handler)
+//	client := &avs.Client{EndpointURL: "http://avs", DialContext: ln.DialContext}
+type PipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewPipeListener returns a new PipeListener.
+func NewPipeListener() *PipeListener {
+	return &PipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+// Accept waits for the next connection opened by DialContext.
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errors.New("avstest: listener closed")
+	}
+}
+
+// Close stops accepting connections. The open ones aren't closed.
+func (l *PipeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr returns a placeholder address.
+func (l *PipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// DialContext opens a connection to the listener, ignoring the network and
+// the address. It can be used as the DialContext of an avs.Client.
+func (l *PipeListener) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, errors.New("avstest: listener closed")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
@@ -0,0 +1,46 @@
+package avstest
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+)
+
+func TestServerOverPipe(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	ln := NewPipeListener()
+	defer ln.Close()
+	httpServer := &http.Server{Handler: s.Config.Handler}
+	go httpServer.Serve(ln)
+	defer httpServer.Close()
+
+	// The endpoint doesn't resolve; every connection goes through the pipe.
+	client, err := avs.NewClient(avs.WithEndpointURL("http://avs.invalid"), avs.WithDialContext(ln.DialContext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	down, err := client.OpenDownchannel("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer down.Close()
+	request := avs.NewRequest("token")
+	request.Event = avs.NewSynchronizeState("m1")
+	if _, err := client.Do(request); err != nil {
+		t.Fatal(err)
+	}
+	if requests := s.Requests(); len(requests) != 1 {
+		t.Fatalf("got %d requests; want 1", len(requests))
+	}
+	if err := client.Ping("token"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-down.Directives:
+		t.Fatalf("downchannel closed: %v", down.Err())
+	case <-time.After(10 * time.Millisecond):
+	}
+}
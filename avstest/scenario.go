@@ -0,0 +1,404 @@
+package avstest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+)
+
+// How long a Scenario waits for an expectation, in real time.
+const scenarioTimeout = 2 * time.Second
+
+// A Scenario drives a Dispatcher with a DirectiveSequencer through a dialog,
+// step by step: directives arriving, dialogs starting (e.g., the user barging
+// in), time passing on a FakeClock, and expectations about which directives
+// were handled, dropped or canceled, and in which order. For example:
+//
+//	avstest.NewScenario().
+//		StartDialog("d1").
+//		DirectiveLasting(speak, 5*time.Second).ExpectStarted(speak).
+//		Directive(expectSpeech).
+//		BargeIn().
+//		ExpectCanceled(speak).ExpectDropped(expectSpeech).
+//		Run(t, nil)
+//
+// The directives are dispatched one at a time in the order of their steps,
+// like Dispatcher.Run does, so a directive waits for the handlers of the
+// ones before it. The steps that only start something (Directive, StartDialog,
+// Advance) don't wait; the Expect steps wait until what they expect has
+// happened, or fail the test.
+type Scenario struct {
+	steps     []scenarioStep
+	handlers  map[string]avs.Handler
+	durations map[string]time.Duration
+	bargeIns  int
+	// The names of the directives dispatched, for their default handlers.
+	names []string
+}
+
+type scenarioStep struct {
+	name string
+	run  func(r *scenarioRun) error
+}
+
+// NewScenario returns an empty Scenario.
+func NewScenario() *Scenario {
+	return &Scenario{handlers: make(map[string]avs.Handler), durations: make(map[string]time.Duration)}
+}
+
+// Handle registers the handler for the directives with the name, like
+// Dispatcher.Handle, so that a scenario can check the assumptions of the
+// application's own handlers. Directives without one get a handler that
+// returns at once, or after the duration given to DirectiveLasting.
+func (s *Scenario) Handle(name string, handler avs.Handler) *Scenario {
+	s.handlers[name] = handler
+	return s
+}
+
+// StartDialog sends a Recognize event with the dialog request id through the
+// BeforeSend hook of the DirectiveSequencer, as the Client does, which makes
+// it the current dialog.
+func (s *Scenario) StartDialog(dialogRequestId string) *Scenario {
+	return s.add("StartDialog "+dialogRequestId, func(r *scenarioRun) error {
+		return r.beforeSend(context.Background(), &avs.Envelope{Event: avs.NewRecognize(NextMessageId(), dialogRequestId)})
+	})
+}
+
+// BargeIn starts a new dialog, as if the user spoke the wake word while the
+// device was busy with the current one.
+func (s *Scenario) BargeIn() *Scenario {
+	s.bargeIns++
+	return s.StartDialog(fmt.Sprintf("barge-in-%d", s.bargeIns))
+}
+
+// Directive dispatches the directive once the directives before it are
+// done. Its default handler returns at once.
+func (s *Scenario) Directive(directive avs.TypedMessage) *Scenario {
+	s.names = append(s.names, directive.GetMessage().Type().Key())
+	return s.add("Directive "+describe(directive), func(r *scenarioRun) error {
+		r.queue <- directive.GetMessage()
+		return nil
+	})
+}
+
+// DirectiveLasting dispatches the directive like Directive, with a default
+// handler that takes the duration on the FakeClock (e.g., to play a long
+// Speak), unless its context is canceled first.
+func (s *Scenario) DirectiveLasting(directive avs.TypedMessage, d time.Duration) *Scenario {
+	s.durations[messageId(directive)] = d
+	return s.Directive(directive)
+}
+
+// Advance advances the FakeClock of the scenario.
+func (s *Scenario) Advance(d time.Duration) *Scenario {
+	return s.add(fmt.Sprintf("Advance %s", d), func(r *scenarioRun) error {
+		r.clock.Advance(d)
+		return nil
+	})
+}
+
+// ExpectStarted waits until the handler of the directive has started.
+func (s *Scenario) ExpectStarted(directive avs.TypedMessage) *Scenario {
+	return s.expect("ExpectStarted", outcomeStarted, directive)
+}
+
+// ExpectHandled waits until the handlers of the directives have completed
+// without being canceled, and checks that they did in this order.
+func (s *Scenario) ExpectHandled(directives ...avs.TypedMessage) *Scenario {
+	return s.expect("ExpectHandled", outcomeHandled, directives...)
+}
+
+// ExpectDropped waits until the directives have been dispatched without
+// reaching their handlers (e.g., because their dialog was superseded).
+func (s *Scenario) ExpectDropped(directives ...avs.TypedMessage) *Scenario {
+	return s.expect("ExpectDropped", outcomeDropped, directives...)
+}
+
+// ExpectCanceled waits until the handlers of the directives have returned
+// after their context was canceled (e.g., by a new dialog), and checks that
+// they did in this order.
+func (s *Scenario) ExpectCanceled(directives ...avs.TypedMessage) *Scenario {
+	return s.expect("ExpectCanceled", outcomeCanceled, directives...)
+}
+
+func (s *Scenario) add(name string, run func(r *scenarioRun) error) *Scenario {
+	s.steps = append(s.steps, scenarioStep{name: name, run: run})
+	return s
+}
+
+func (s *Scenario) expect(step string, outcome scenarioOutcome, directives ...avs.TypedMessage) *Scenario {
+	names := make([]string, len(directives))
+	for i, directive := range directives {
+		names[i] = describe(directive)
+	}
+	return s.add(step+" "+strings.Join(names, ", "), func(r *scenarioRun) error {
+		return r.wait(outcome, directives)
+	})
+}
+
+// Run runs the steps of the scenario on the dispatcher, or on a new one if
+// it's nil, and fails the test at the first expectation that isn't met. The
+// dispatcher gets a DirectiveSequencer and the FakeClock of the scenario if
+// it has none. The handlers of the scenario are registered on it, along with
+// the default handlers of its directives, replacing the ones it had for the
+// same names.
+func (s *Scenario) Run(t testing.TB, d *avs.Dispatcher) {
+	t.Helper()
+	if d == nil {
+		d = avs.NewDispatcher()
+	}
+	if d.Dialogs == nil {
+		d.Dialogs = avs.NewDirectiveSequencer()
+	}
+	clock, ok := d.Clock.(*FakeClock)
+	if !ok {
+		clock = NewFakeClock(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+		if d.Clock == nil {
+			d.Clock = clock
+		}
+	}
+	r := &scenarioRun{
+		scenario:   s,
+		clock:      clock,
+		queue:      make(chan *avs.Message, len(s.steps)),
+		changed:    make(chan struct{}),
+		outcomes:   make(map[string]scenarioOutcome),
+		beforeSend: d.Dialogs.BeforeSend(),
+	}
+	for name, handler := range s.handlers {
+		d.Handle(name, r.observe(handler))
+	}
+	for _, name := range s.names {
+		namespace := strings.SplitN(name, ".", 2)[0]
+		if s.handlers[name] == nil && s.handlers[namespace] == nil {
+			d.Handle(name, avs.HandlerFunc(r.defaultHandler))
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range r.queue {
+			d.Dispatch(ctx, m)
+			r.record(messageId(m), outcomeDispatched)
+		}
+	}()
+	defer func() {
+		cancel()
+		close(r.queue)
+		<-done
+	}()
+
+	for i, step := range s.steps {
+		if err := step.run(r); err != nil {
+			t.Fatalf("step %d (%s): %v\n%s", i+1, step.name, err, r.history())
+		}
+	}
+}
+
+// What happened to a directive.
+type scenarioOutcome int
+
+const (
+	outcomeNone scenarioOutcome = iota
+	outcomeStarted
+	outcomeHandled
+	outcomeCanceled
+	// Dispatch returned, which is only recorded as outcomeDropped if the
+	// handler wasn't called.
+	outcomeDispatched
+	outcomeDropped
+)
+
+// The state of a running Scenario.
+type scenarioRun struct {
+	scenario   *Scenario
+	clock      *FakeClock
+	queue      chan *avs.Message
+	beforeSend avs.BeforeSendHook
+
+	mu sync.Mutex
+	// Closed and replaced whenever an outcome is recorded.
+	changed  chan struct{}
+	outcomes map[string]scenarioOutcome
+	// The outcomes in the order they happened, for ordering checks and
+	// failure messages.
+	log []string
+	// The message ids of the directives whose handlers completed, in order.
+	done []string
+}
+
+// Takes the duration given to DirectiveLasting, if any, unless the context
+// is canceled first. It's only reported as started once its timer is set,
+// so that the clock can be advanced right after ExpectStarted.
+func (r *scenarioRun) defaultHandler(ctx context.Context, directive avs.TypedMessage) error {
+	var timeout <-chan time.Time
+	if d := r.scenario.durations[messageId(directive)]; d > 0 {
+		timer := r.clock.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+	r.record(messageId(directive), outcomeStarted)
+	var err error
+	if timeout != nil {
+		select {
+		case <-timeout:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	r.completed(ctx, directive)
+	return err
+}
+
+// Wraps a handler to record when it starts and how it completes.
+func (r *scenarioRun) observe(handler avs.Handler) avs.Handler {
+	return avs.HandlerFunc(func(ctx context.Context, directive avs.TypedMessage) error {
+		r.record(messageId(directive), outcomeStarted)
+		err := handler.HandleDirective(ctx, directive)
+		r.completed(ctx, directive)
+		return err
+	})
+}
+
+// Records that the handler of the directive returned, canceled or not.
+func (r *scenarioRun) completed(ctx context.Context, directive avs.TypedMessage) {
+	if ctx.Err() != nil {
+		r.record(messageId(directive), outcomeCanceled)
+	} else {
+		r.record(messageId(directive), outcomeHandled)
+	}
+}
+
+func (r *scenarioRun) record(id string, outcome scenarioOutcome) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if outcome == outcomeDispatched {
+		if r.outcomes[id] != outcomeNone {
+			// Its handler was called.
+			return
+		}
+		outcome = outcomeDropped
+	}
+	r.outcomes[id] = outcome
+	r.log = append(r.log, fmt.Sprintf("%s %s", id, outcome))
+	if outcome == outcomeHandled || outcome == outcomeCanceled {
+		r.done = append(r.done, id)
+	}
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// Waits until every directive has the outcome, and checks the order of the
+// completed ones.
+func (r *scenarioRun) wait(outcome scenarioOutcome, directives []avs.TypedMessage) error {
+	deadline := time.After(scenarioTimeout)
+	for {
+		r.mu.Lock()
+		changed := r.changed
+		var missing avs.TypedMessage
+		for _, directive := range directives {
+			if !r.outcomes[messageId(directive)].reached(outcome) {
+				missing = directive
+				break
+			}
+		}
+		var err error
+		if missing == nil {
+			err = r.checkOrder(outcome, directives)
+		} else if got := r.outcomes[messageId(missing)]; got.final() && got != outcome {
+			err = fmt.Errorf("%s was %s", describe(missing), got)
+		}
+		r.mu.Unlock()
+		if missing == nil || err != nil {
+			return err
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return fmt.Errorf("%s still %s after %s", describe(missing), r.outcomeOf(missing), scenarioTimeout)
+		}
+	}
+}
+
+// Returns whether the outcome satisfies the expected one: a handler that
+// completed has started too.
+func (o scenarioOutcome) reached(want scenarioOutcome) bool {
+	if want == outcomeStarted {
+		return o == outcomeStarted || o == outcomeHandled || o == outcomeCanceled
+	}
+	return o == want
+}
+
+// Checks that the directives completed in the order given. The lock must be
+// held.
+func (r *scenarioRun) checkOrder(outcome scenarioOutcome, directives []avs.TypedMessage) error {
+	if outcome != outcomeHandled && outcome != outcomeCanceled {
+		return nil
+	}
+	position := make(map[string]int)
+	for i, id := range r.done {
+		position[id] = i
+	}
+	for i := 1; i < len(directives); i++ {
+		if position[messageId(directives[i])] < position[messageId(directives[i-1])] {
+			return fmt.Errorf("%s completed before %s", describe(directives[i]), describe(directives[i-1]))
+		}
+	}
+	return nil
+}
+
+func (r *scenarioRun) outcomeOf(directive avs.TypedMessage) scenarioOutcome {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.outcomes[messageId(directive)]
+}
+
+// Returns what happened so far, for failure messages.
+func (r *scenarioRun) history() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.log) == 0 {
+		return "nothing happened"
+	}
+	return "happened:\n  " + strings.Join(r.log, "\n  ")
+}
+
+// Returns whether nothing else can happen to a directive with the outcome.
+func (o scenarioOutcome) final() bool {
+	return o == outcomeHandled || o == outcomeCanceled || o == outcomeDropped
+}
+
+func (o scenarioOutcome) String() string {
+	switch o {
+	case outcomeNone:
+		return "not dispatched"
+	case outcomeStarted:
+		return "started"
+	case outcomeHandled:
+		return "handled"
+	case outcomeCanceled:
+		return "canceled"
+	case outcomeDispatched:
+		return "dispatched"
+	case outcomeDropped:
+		return "dropped"
+	}
+	return fmt.Sprintf("scenarioOutcome(%d)", int(o))
+}
+
+func messageId(directive avs.TypedMessage) string {
+	return directive.GetMessage().Header["messageId"]
+}
+
+// Describes a directive by its type and message id (e.g.,
+// "SpeechSynthesizer.Speak message-1").
+func describe(directive avs.TypedMessage) string {
+	return directive.GetMessage().Type().Key() + " " + messageId(directive)
+}
@@ -0,0 +1,135 @@
+package avstest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+)
+
+// Returns a directive of the dialog.
+func inDialog(directive avs.TypedMessage, dialogRequestId string) avs.TypedMessage {
+	SetDialogRequestId(directive, dialogRequestId)
+	return directive
+}
+
+// Before any dialog starts, the directives of every dialog are handled.
+func TestScenarioBeforeFirstDialog(t *testing.T) {
+	speak1, speak2 := inDialog(Speak("a"), "d1"), inDialog(Speak("b"), "d2")
+	NewScenario().
+		Directive(speak1).Directive(speak2).
+		ExpectHandled(speak1, speak2).
+		Run(t, nil)
+}
+
+// The directives of superseded dialogs are dropped; those of the current one
+// and those without a dialog request id are handled.
+func TestScenarioSupersededDialog(t *testing.T) {
+	stale, current, volume := inDialog(Speak("a"), "d1"), inDialog(Speak("b"), "d2"), SetVolume(50)
+	NewScenario().
+		StartDialog("d1").
+		StartDialog("d2").
+		Directive(stale).Directive(current).Directive(volume).
+		ExpectDropped(stale).
+		ExpectHandled(current, volume).
+		Run(t, nil)
+}
+
+// A barge-in cancels the handler of the current dialog promptly, and drops
+// the directives of the dialog that were still to come. It isn't a failure
+// of the handler.
+func TestScenarioBargeIn(t *testing.T) {
+	speak := inDialog(Speak("a"), "d1")
+	expectSpeech := inDialog(ExpectSpeech(8*time.Second), "d1")
+	d := avs.NewDispatcher()
+	var failures int
+	d.ReportException = func(*avs.ExceptionEncountered) { failures++ }
+	NewScenario().
+		StartDialog("d1").
+		DirectiveLasting(speak, time.Minute).
+		Directive(expectSpeech).
+		ExpectStarted(speak).
+		BargeIn().
+		ExpectCanceled(speak).
+		ExpectDropped(expectSpeech).
+		Run(t, d)
+	if failures != 0 {
+		t.Errorf("got %d exceptions", failures)
+	}
+}
+
+// Directives without a dialog request id aren't canceled by a new dialog.
+func TestScenarioNoDialogRequestId(t *testing.T) {
+	play := Play("song", "https://example.com/song.mp3", avs.PlayBehaviorReplaceAll)
+	NewScenario().
+		StartDialog("d1").
+		DirectiveLasting(play, time.Minute).
+		ExpectStarted(play).
+		BargeIn().
+		Advance(time.Minute).
+		ExpectHandled(play).
+		Run(t, nil)
+}
+
+// Starting the current dialog again doesn't cancel its directives.
+func TestScenarioSameDialog(t *testing.T) {
+	speak := inDialog(Speak("a"), "d1")
+	NewScenario().
+		StartDialog("d1").
+		DirectiveLasting(speak, 5*time.Second).
+		ExpectStarted(speak).
+		StartDialog("d1").
+		Advance(5*time.Second).
+		ExpectHandled(speak).
+		Run(t, nil)
+}
+
+// Directives are handled one at a time, in order: a long one delays the next.
+func TestScenarioOrder(t *testing.T) {
+	speak1, speak2 := inDialog(Speak("a"), "d1"), inDialog(Speak("b"), "d1")
+	var handled []string
+	NewScenario().
+		Handle("SpeechSynthesizer.Speak", avs.HandlerFunc(func(ctx context.Context, directive avs.TypedMessage) error {
+			handled = append(handled, directive.(*avs.Speak).ContentId())
+			return nil
+		})).
+		StartDialog("d1").
+		Directive(speak1).Directive(speak2).
+		ExpectHandled(speak1, speak2).
+		Run(t, nil)
+	if len(handled) != 2 || handled[0] != "a" || handled[1] != "b" {
+		t.Errorf("handled %v; want [a b]", handled)
+	}
+
+	long, next := inDialog(Speak("c"), "d2"), inDialog(Speak("d"), "d2")
+	NewScenario().
+		StartDialog("d2").
+		DirectiveLasting(long, 10*time.Second).
+		Directive(next).
+		ExpectStarted(long).
+		Advance(10*time.Second).
+		ExpectHandled(long, next).
+		Run(t, nil)
+}
+
+// A failing scenario reports the step and what happened.
+func TestScenarioFailure(t *testing.T) {
+	speak := inDialog(Speak("a"), "d1")
+	tb := &recordingTB{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewScenario().
+			StartDialog("d2").
+			Directive(speak).
+			ExpectHandled(speak).
+			Run(tb, nil)
+	}()
+	<-done
+	want := "step 3 (ExpectHandled SpeechSynthesizer.Speak " + messageId(speak) + "): SpeechSynthesizer.Speak " +
+		messageId(speak) + " was dropped\nhappened:\n  " + messageId(speak) + " dropped"
+	if len(tb.errors) != 1 || tb.errors[0] != want {
+		t.Errorf("got failures %q; want %q", tb.errors, want)
+	}
+}
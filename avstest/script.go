@@ -0,0 +1,192 @@
+package avstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fika-io/go-avs"
+)
+
+// A Turn is the response of the Server to one Recognize event: its
+// directives, in order, and the attachments they refer to.
+type Turn struct {
+	Directives  []avs.TypedMessage
+	Attachments map[avs.ContentId][]byte
+}
+
+// A Script is the canned response of AVS to an utterance. A multi-turn
+// script (e.g., with ExpectSpeech follow-ups) has a turn for every Recognize
+// event of the dialog.
+type Script struct {
+	// Label identifies the utterance in messages, e.g. "what's the weather".
+	Label string
+	Turns []Turn
+}
+
+// LoadScript reads a script from a JSON file, usually in testdata:
+//
+//	{"turns": [{
+//		"directives": [{"header": {...}, "payload": {...}}],
+//		"attachments": {"speech-1": "weather.mp3"}
+//	}]}
+//
+// The attachments are read from files relative to the script. The label of
+// the script is the name of the file without its extension.
+func LoadScript(path string) (*Script, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Turns []struct {
+			Directives  []json.RawMessage `json:"directives"`
+			Attachments map[string]string `json:"attachments"`
+		} `json:"turns"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("avstest: script %s: %v", path, err)
+	}
+	base := filepath.Base(path)
+	script := &Script{Label: strings.TrimSuffix(base, filepath.Ext(base))}
+	for i, t := range file.Turns {
+		turn := Turn{Attachments: make(map[avs.ContentId][]byte)}
+		for _, raw := range t.Directives {
+			directive, err := avs.TypedFromReader(bytes.NewReader(raw))
+			if err != nil {
+				return nil, fmt.Errorf("avstest: script %s, turn %d: %v", path, i+1, err)
+			}
+			turn.Directives = append(turn.Directives, directive)
+		}
+		for id, name := range t.Attachments {
+			content, err := ioutil.ReadFile(filepath.Join(filepath.Dir(path), name))
+			if err != nil {
+				return nil, fmt.Errorf("avstest: script %s, turn %d: %v", path, i+1, err)
+			}
+			turn.Attachments[avs.ContentId(id)] = content
+		}
+		script.Turns = append(script.Turns, turn)
+	}
+	return script, nil
+}
+
+// SimulateUtterance makes the server respond to the next Recognize events
+// with the turns of the script, as if the user said the utterance. The
+// directives get the dialog request id of the Recognize event they respond
+// to, unless they have one. Recognize events with no turn left get 204 No
+// Content.
+func (s *Server) SimulateUtterance(script *Script) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.turns = append(s.turns, script.Turns...)
+}
+
+// Removes and returns the next turn of the simulated utterances, if any.
+func (s *Server) nextTurn() (Turn, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.turns) == 0 {
+		return Turn{}, false
+	}
+	turn := s.turns[0]
+	s.turns = s.turns[1:]
+	return turn, true
+}
+
+// Writes the turn as the multipart response to the Recognize event with the
+// dialog request id.
+func writeTurn(w http.ResponseWriter, turn Turn, dialogRequestId string) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, directive := range turn.Directives {
+		data, err := json.Marshal(directive)
+		if err != nil {
+			return err
+		}
+		var m struct {
+			Header  map[string]string `json:"header"`
+			Payload json.RawMessage   `json:"payload"`
+		}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		if m.Header["dialogRequestId"] == "" && dialogRequestId != "" {
+			m.Header["dialogRequestId"] = dialogRequestId
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+		if err != nil {
+			return err
+		}
+		if err := json.NewEncoder(part).Encode(map[string]interface{}{"directive": m}); err != nil {
+			return err
+		}
+	}
+	for id, content := range turn.Attachments {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Id":   {id.MIMEHeader()},
+			"Content-Type": {"application/octet-stream"},
+		})
+		if err != nil {
+			return err
+		}
+		part.Write(content)
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "multipart/related; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+// AssertEvents checks that the events received so far include the events of
+// the types, in that order (e.g., SpeechStarted then SpeechFinished). Other
+// events may come in between. If they don't, it reports the events that
+// were received along with the first one missing.
+func (s *Server) AssertEvents(t testing.TB, want ...avs.MessageType) {
+	t.Helper()
+	var got []avs.MessageType
+	for _, request := range s.Requests() {
+		if request.Event != nil {
+			got = append(got, request.Event.GetMessage().Type())
+		}
+	}
+	i := 0
+	for _, typ := range got {
+		if i < len(want) && typ == want[i] {
+			i++
+		}
+	}
+	if i == len(want) {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "missing event %s", want[i])
+	if i > 0 {
+		fmt.Fprintf(&b, " after %s", want[i-1])
+	}
+	b.WriteString("\nwant (in order):")
+	for j, typ := range want {
+		mark := " "
+		if j == i {
+			mark = ">"
+		}
+		fmt.Fprintf(&b, "\n%s %s", mark, typ)
+	}
+	b.WriteString("\ngot:")
+	if len(got) == 0 {
+		b.WriteString(" no events")
+	}
+	for _, typ := range got {
+		fmt.Fprintf(&b, "\n  %s", typ)
+	}
+	t.Errorf("%s", b.String())
+}
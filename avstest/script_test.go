@@ -0,0 +1,119 @@
+package avstest
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/fika-io/go-avs"
+)
+
+func TestSimulateUtterance(t *testing.T) {
+	script, err := LoadScript("testdata/favorite_color.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if script.Label != "favorite_color" || len(script.Turns) != 2 {
+		t.Fatalf("loaded script %q with %d turns; want favorite_color with 2", script.Label, len(script.Turns))
+	}
+	s := NewServer()
+	defer s.Close()
+	s.SimulateUtterance(script)
+	client := &avs.Client{EndpointURL: s.URL}
+
+	for i, want := range []struct {
+		directives, audio string
+	}{
+		{"[SpeechSynthesizer.Speak SpeechRecognizer.ExpectSpeech]", "what is your favorite color"},
+		{"[SpeechSynthesizer.Speak]", "blue is a nice color"},
+	} {
+		dialogRequestId := fmt.Sprintf("dialog-%d", i+1)
+		request := avs.NewRequest("token")
+		request.Event = avs.NewRecognize(avs.RandomUUIDString(), dialogRequestId)
+		request.Audio = strings.NewReader("audio")
+		response, err := client.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var types []avs.MessageType
+		for _, directive := range response.Directives {
+			types = append(types, directive.Type())
+			if id := directive.Header["dialogRequestId"]; id != dialogRequestId {
+				t.Errorf("turn %d: %s has dialog request id %q; want %s", i+1, directive.Type(), id, dialogRequestId)
+			}
+		}
+		if fmt.Sprint(types) != want.directives {
+			t.Errorf("turn %d: got directives %v; want %s", i+1, types, want.directives)
+		}
+		speak, _ := response.Directives[0].Typed().(*avs.Speak)
+		if speak == nil {
+			t.Fatalf("turn %d: first directive isn't a Speak", i+1)
+		}
+		if audio, err := response.Attachment(speak.Payload.URL); string(audio) != want.audio {
+			t.Errorf("turn %d: got speech %q, %v; want %q", i+1, audio, err, want.audio)
+		}
+		for _, event := range []avs.TypedMessage{
+			avs.NewSpeechStarted(avs.RandomUUIDString(), speak.Payload.Token),
+			avs.NewSpeechFinished(avs.RandomUUIDString(), speak.Payload.Token),
+		} {
+			request := avs.NewRequest("token")
+			request.Event = event
+			if _, err := client.Do(request); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// With no turns left, Recognize gets no directives.
+	request := avs.NewRequest("token")
+	request.Event = avs.NewRecognize(avs.RandomUUIDString(), "dialog-3")
+	request.Audio = strings.NewReader("audio")
+	if response, err := client.Do(request); err != nil || len(response.Directives) != 0 {
+		t.Errorf("got %v, %v after the script; want no directives", response, err)
+	}
+
+	s.AssertEvents(t,
+		avs.TypeRecognize, avs.TypeSpeechStarted, avs.TypeSpeechFinished,
+		avs.TypeRecognize, avs.TypeSpeechStarted, avs.TypeSpeechFinished)
+}
+
+// Records the failures of AssertEvents and Scenario.Run.
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingTB) Helper() {}
+
+func (t *recordingTB) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *recordingTB) Fatalf(format string, args ...interface{}) {
+	t.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+func TestAssertEventsReportsMissingEvent(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	client := &avs.Client{EndpointURL: s.URL}
+	request := avs.NewRequest("token")
+	request.Event = avs.NewSpeechStarted(avs.RandomUUIDString(), "token")
+	if _, err := client.Do(request); err != nil {
+		t.Fatal(err)
+	}
+
+	tb := &recordingTB{TB: t}
+	s.AssertEvents(tb, avs.TypeSpeechStarted, avs.TypeSpeechFinished)
+	if len(tb.errors) != 1 {
+		t.Fatalf("got %d errors; want 1", len(tb.errors))
+	}
+	want := "missing event SpeechSynthesizer.SpeechFinished after SpeechSynthesizer.SpeechStarted\n" +
+		"want (in order):\n  SpeechSynthesizer.SpeechStarted\n> SpeechSynthesizer.SpeechFinished\n" +
+		"got:\n  SpeechSynthesizer.SpeechStarted"
+	if tb.errors[0] != want {
+		t.Errorf("got error\n%s\nwant\n%s", tb.errors[0], want)
+	}
+}
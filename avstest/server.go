@@ -0,0 +1,152 @@
+package avstest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/fika-io/go-avs"
+)
+
+// Server is a fake AVS endpoint. It accepts every event with 204 No Content,
+// unless a Recognize event is answered by a simulated utterance (see
+// SimulateUtterance), and records the requests it receives, with their
+// contexts parsed by avs.ParseEnvelope. Unless it's Lenient, events and
+// contexts with payload fields that their Go type doesn't have are answered
+// with 400 Bad Request (see avs.CheckPayload), so that tests notice messages
+// that AVS wouldn't expect. Metadata compressed by an avs.Client with
+// CompressMetadata is decompressed. Downchannels are kept open without
+// directives until the client closes them or the server is closed. Use its
+// URL as the EndpointURL of an avs.Client.
+type Server struct {
+	*httptest.Server
+
+	// Lenient, if set before the first request, accepts unknown payload
+	// fields.
+	Lenient bool
+
+	mu       sync.Mutex
+	requests []*avs.Request
+	turns    []Turn
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewServer starts and returns a new Server. The caller should call Close
+// when finished.
+func NewServer() *Server {
+	s := &Server{done: make(chan struct{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc(avs.EventsPath, s.handleEvent)
+	mux.HandleFunc(avs.DirectivesPath, s.handleDirectives)
+	mux.HandleFunc(avs.PingPath, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Requests returns the requests received so far. The access token is taken
+// from the Authorization header and the audio, if any, is buffered.
+func (s *Server) Requests() []*avs.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*avs.Request(nil), s.requests...)
+}
+
+// Close ends the open downchannels and shuts down the server.
+func (s *Server) Close() {
+	s.once.Do(func() { close(s.done) })
+	s.Server.Close()
+}
+
+func (s *Server) handleDirectives(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "multipart/related; boundary=avstest")
+	w.WriteHeader(http.StatusOK)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	select {
+	case <-r.Context().Done():
+	case <-s.done:
+	}
+}
+
+func (s *Server) handleEvent(w http.ResponseWriter, r *http.Request) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request *avs.Request
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch p.FormName() {
+		case "metadata":
+			var metadata io.Reader = p
+			if p.Header.Get("Content-Encoding") == "gzip" {
+				if metadata, err = gzip.NewReader(p); err != nil {
+					break
+				}
+			}
+			if request, err = avs.ParseEnvelope(metadata); err == nil && !s.Lenient {
+				err = checkPayloads(request)
+			}
+		case "audio":
+			var audio []byte
+			audio, err = ioutil.ReadAll(p)
+			if request != nil {
+				request.Audio = bytes.NewReader(audio)
+			}
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if request == nil {
+		http.Error(w, "missing metadata", http.StatusBadRequest)
+		return
+	}
+	if auth := r.Header.Get("Authorization"); len(auth) > len("Bearer ") {
+		request.AccessToken = auth[len("Bearer "):]
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, request)
+	s.mu.Unlock()
+	if request.Event != nil && request.Event.GetMessage().Type() == avs.TypeRecognize {
+		if turn, ok := s.nextTurn(); ok {
+			if err := writeTurn(w, turn, request.Event.GetMessage().Header["dialogRequestId"]); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Checks the payloads of the event and contexts of the request.
+func checkPayloads(request *avs.Request) error {
+	if request.Event != nil {
+		if err := avs.CheckPayload(request.Event.GetMessage()); err != nil {
+			return err
+		}
+	}
+	for _, c := range request.Context {
+		if err := avs.CheckPayload(c.GetMessage()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
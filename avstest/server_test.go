@@ -0,0 +1,69 @@
+package avstest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+)
+
+func TestServerRecordsRequests(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	client := &avs.Client{EndpointURL: s.URL}
+
+	request := avs.NewRequest("token1")
+	request.Event = avs.NewRecognize("m1", "d1")
+	request.AddContext(avs.NewPlaybackState("t", 3*time.Second, avs.PlayerActivityPlaying))
+	request.Audio = strings.NewReader("audio data")
+	if _, err := client.Do(request); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping("token1"); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := s.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests; want 1", len(requests))
+	}
+	got := requests[0]
+	if got.AccessToken != "token1" {
+		t.Errorf("got access token %q; want token1", got.AccessToken)
+	}
+	if got.Event.GetMessage().Type() != avs.TypeRecognize {
+		t.Errorf("got event %v; want Recognize", got.Event)
+	}
+	if c, ok := got.Context[0].(*avs.PlaybackState); !ok || c.Payload.OffsetInMilliseconds != 3000 {
+		t.Errorf("got context %#v; want a *PlaybackState at 3000 ms", got.Context[0])
+	}
+	if audio, _ := ioutil.ReadAll(got.Audio); string(audio) != "audio data" {
+		t.Errorf("got audio %q", audio)
+	}
+}
+
+func TestServerRejectsUnknownFields(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	client := &avs.Client{EndpointURL: s.URL}
+
+	var event avs.Message
+	data := `{"header":{"namespace":"AudioPlayer","name":"PlaybackStarted","messageId":"m1"},` +
+		`"payload":{"token":"t1","offsetInMilliseconds":0,"foo":true}}`
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		t.Fatal(err)
+	}
+	request := avs.NewRequest("token1")
+	request.Event = &event
+	if _, err := client.Do(request); err == nil {
+		t.Error("an unknown field was accepted")
+	}
+
+	s.Lenient = true
+	if _, err := client.Do(request); err != nil {
+		t.Errorf("lenient: %v", err)
+	}
+}
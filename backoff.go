@@ -0,0 +1,264 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Jitter specifies how a BackoffPolicy randomizes its delays, so that a fleet
+// of devices doesn't retry in lockstep.
+type Jitter int
+
+// Possible values for Jitter.
+const (
+	// JitterNone uses the exponential delays as is.
+	JitterNone Jitter = iota
+	// JitterFull picks every delay between zero and the exponential delay.
+	JitterFull
+	// JitterDecorrelated picks every delay between Initial and three times
+	// the previous delay, which spreads the retries more than JitterFull
+	// when many clients fail at once.
+	JitterDecorrelated
+)
+
+// BackoffPolicy describes how long to wait between the attempts of an
+// operation, and when to give up. The zero value retries immediately and
+// forever.
+type BackoffPolicy struct {
+	// The delay before the first retry. Without jitter, it's multiplied by
+	// Multiplier for every subsequent retry.
+	Initial time.Duration
+	// The maximum delay, if positive.
+	Max time.Duration
+	// The growth factor of the delays. Values below 1 mean 2.
+	Multiplier float64
+	Jitter     Jitter
+	// The maximum number of attempts, including the first one, if positive.
+	MaxAttempts int
+	// The maximum time from the first attempt to the start of the last one,
+	// if positive.
+	MaxElapsed time.Duration
+	// Clock, if set, replaces the system clock (or the Clock of the Client
+	// that applies the policy).
+	Clock Clock
+}
+
+// Delay returns the delay before the retry (zero-based), ignoring the limits
+// on attempts and elapsed time. With JitterDecorrelated, it depends on the
+// previous delay, which is zero before the first retry.
+func (p *BackoffPolicy) Delay(retry int, previous time.Duration) time.Duration {
+	var d time.Duration
+	switch p.Jitter {
+	case JitterDecorrelated:
+		if previous < p.Initial {
+			previous = p.Initial
+		}
+		d = p.Initial + randomDuration(clampDuration(3*float64(previous))-p.Initial)
+	case JitterFull:
+		d = randomDuration(p.exponential(retry))
+	default:
+		d = p.exponential(retry)
+	}
+	if p.Max > 0 && d > p.Max {
+		d = p.Max
+	}
+	return d
+}
+
+// Returns the delay before the retry without jitter.
+func (p *BackoffPolicy) exponential(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	return clampDuration(float64(p.Initial) * math.Pow(multiplier, float64(retry)))
+}
+
+// Converts a number of nanoseconds to a duration, without overflowing.
+func clampDuration(ns float64) time.Duration {
+	if ns >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(ns)
+}
+
+// Returns a random duration between zero and d, inclusive.
+func randomDuration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	if d == math.MaxInt64 {
+		return time.Duration(rand.Int63())
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// Backoff tracks the attempts of one operation under a BackoffPolicy.
+type Backoff struct {
+	policy   *BackoffPolicy
+	clock    Clock
+	started  time.Time
+	attempts int
+	previous time.Duration
+}
+
+// Start returns a Backoff for an operation whose first attempt starts now.
+func (p *BackoffPolicy) Start() *Backoff {
+	return p.start(nil)
+}
+
+// Returns a Backoff that uses the clock unless the policy has its own.
+func (p *BackoffPolicy) start(clock Clock) *Backoff {
+	if p.Clock != nil {
+		clock = p.Clock
+	}
+	clock = clockOrDefault(clock)
+	return &Backoff{policy: p, clock: clock, started: clock.Now(), attempts: 1}
+}
+
+// Next returns the delay before the next attempt, and false if the policy
+// allows no more attempts. A positive after (e.g., from a Retry-After header)
+// replaces the delay of the policy, even beyond Max, since retrying sooner
+// would be rejected again. MaxElapsed still applies: a delay past it means
+// no more attempts.
+func (b *Backoff) Next(after time.Duration) (time.Duration, bool) {
+	p := b.policy
+	if p.MaxAttempts > 0 && b.attempts >= p.MaxAttempts {
+		return 0, false
+	}
+	d := after
+	if d <= 0 {
+		d = p.Delay(b.attempts-1, b.previous)
+	}
+	if p.MaxElapsed > 0 && b.clock.Now().Add(d).Sub(b.started) > p.MaxElapsed {
+		return 0, false
+	}
+	b.attempts++
+	b.previous = d
+	return d, true
+}
+
+// Attempts returns the number of attempts so far, including the first one.
+func (b *Backoff) Attempts() int {
+	return b.attempts
+}
+
+// Permanent returns err marked as not worth retrying: Retry returns err as
+// soon as the operation returns it.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// WithRetryAfter returns err with the delay before the operation may be
+// attempted again, which Retry waits instead of the delay of its policy.
+func WithRetryAfter(err error, d time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &retryAfterError{err, d}
+}
+
+type retryAfterError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryAfterError) Error() string { return e.err.Error() }
+func (e *retryAfterError) Unwrap() error { return e.err }
+
+// Returns the delay that the error asks to wait before retrying: the one set
+// with WithRetryAfter, or the Retry-After header of the response of AVS.
+func retryAfterOf(err error) time.Duration {
+	var withDelay *retryAfterError
+	if errors.As(err, &withDelay) {
+		return withDelay.after
+	}
+	var exception *Exception
+	if errors.As(err, &exception) {
+		return exception.RetryAfter
+	}
+	var requestError *RequestError
+	if errors.As(err, &requestError) {
+		return requestError.RetryAfter
+	}
+	return 0
+}
+
+// Retry calls op until it succeeds, returns a Permanent error, the context is
+// done or the policy gives up, and returns the last error of op (without the
+// Permanent mark). Errors with a Retry-After delay (see WithRetryAfter) are
+// retried after that delay. A delay that would outlast the deadline of the
+// context isn't waited: the last error is returned right away. A nil policy
+// means that op is only called once.
+func Retry(ctx context.Context, policy *BackoffPolicy, op func(ctx context.Context) error) error {
+	return retryBackoff(ctx, policy, nil, op)
+}
+
+// Implements Retry with the clock, unless the policy has its own.
+func retryBackoff(ctx context.Context, policy *BackoffPolicy, clock Clock, op func(ctx context.Context) error) error {
+	if policy == nil {
+		return unwrapPermanent(op(ctx))
+	}
+	b := policy.start(clock)
+	for {
+		err := op(ctx)
+		if err == nil {
+			return nil
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		d, ok := b.Next(retryAfterOf(err))
+		if !ok {
+			return err
+		}
+		if sleepContext(ctx, b.clock, d) != nil {
+			return err
+		}
+	}
+}
+
+func unwrapPermanent(err error) error {
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return permanent.err
+	}
+	return err
+}
+
+// BackoffPolicies sets the BackoffPolicy of each kind of operation of a
+// Client that is retried. A nil policy keeps the behavior described on its
+// field.
+type BackoffPolicies struct {
+	// Events replaces the Backoff and Jitter of the RetryActions when the
+	// events sent with Do are retried. The Retries of the actions still
+	// apply.
+	Events *BackoffPolicy
+	// Downchannel does the same when a downchannel stream is opened.
+	Downchannel *BackoffPolicy
+	// Capabilities does the same for PublishCapabilities.
+	Capabilities *BackoffPolicy
+	// Reconnect retries replacing the stream of a downchannel after a GOAWAY
+	// or Reconnect, when the RetryPolicy gave up (e.g., while the network is
+	// down). If nil, the downchannel closes with the error after a GOAWAY,
+	// and a Reconnect is retried every second to a minute until the
+	// downchannel is closed.
+	Reconnect *BackoffPolicy
+	// TokenRefresh retries refreshing an expired access token with the
+	// TokenSource (see Client.SetTokenSource), unless the refresh is
+	// rejected with an ErrUnauthorized error. If nil, the request fails with
+	// the HTTP 403 error.
+	TokenRefresh *BackoffPolicy
+}
@@ -0,0 +1,233 @@
+package avs_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+	"github.com/fika-io/go-avs/avstest"
+)
+
+// Checks the bounds of the delays of random policies.
+func TestBackoffPolicyBounds(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		p := &avs.BackoffPolicy{
+			Initial:    time.Duration(r.Int63n(int64(time.Second))),
+			Multiplier: 1 + 3*r.Float64(),
+			Jitter:     avs.Jitter(r.Intn(3)),
+		}
+		if r.Intn(4) > 0 {
+			p.Max = p.Initial + time.Duration(r.Int63n(int64(time.Minute)))
+		}
+		var previous time.Duration
+		for retry := 0; retry < 100; retry++ {
+			d := p.Delay(retry, previous)
+			if d < 0 || p.Max > 0 && d > p.Max {
+				t.Fatalf("%+v: got delay %s for retry %d; want between 0 and Max", p, d, retry)
+			}
+			switch p.Jitter {
+			case avs.JitterNone:
+				if d < previous {
+					t.Fatalf("%+v: got delay %s after %s; want increasing delays", p, d, previous)
+				}
+			case avs.JitterFull:
+				exponential := (&avs.BackoffPolicy{Initial: p.Initial, Max: p.Max, Multiplier: p.Multiplier}).Delay(retry, 0)
+				if d > exponential {
+					t.Fatalf("%+v: got delay %s for retry %d; want at most %s", p, d, retry, exponential)
+				}
+			case avs.JitterDecorrelated:
+				upper := time.Duration(math.MaxInt64)
+				if previous < p.Initial {
+					upper = 3 * p.Initial
+				} else if previous < upper/3 {
+					upper = 3 * previous
+				}
+				if p.Max > 0 && upper > p.Max {
+					upper = p.Max
+				}
+				if d < p.Initial && d != p.Max || d > upper {
+					t.Fatalf("%+v: got delay %s after %s; want between Initial and %s", p, d, previous, upper)
+				}
+			}
+			previous = d
+		}
+	}
+	// The exponential delays don't overflow.
+	p := &avs.BackoffPolicy{Initial: time.Hour, Multiplier: 10}
+	if d := p.Delay(1000, 0); d <= 0 {
+		t.Errorf("got delay %s for retry 1000", d)
+	}
+}
+
+func TestBackoffLimits(t *testing.T) {
+	clock := avstest.NewFakeClock(time.Unix(0, 0))
+	p := &avs.BackoffPolicy{Initial: time.Second, MaxAttempts: 3, Clock: clock}
+	b := p.Start()
+	for _, want := range []time.Duration{time.Second, 2 * time.Second} {
+		if d, ok := b.Next(0); !ok || d != want {
+			t.Errorf("got %s, %t; want %s", d, ok, want)
+		}
+	}
+	if _, ok := b.Next(0); ok || b.Attempts() != 3 {
+		t.Errorf("got a 4th attempt; want MaxAttempts to stop at 3")
+	}
+
+	p = &avs.BackoffPolicy{Initial: time.Second, Max: 4 * time.Second, MaxElapsed: 10 * time.Second, Clock: clock}
+	b = p.Start()
+	// A Retry-After delay may exceed Max, but not MaxElapsed.
+	if d, ok := b.Next(8 * time.Second); !ok || d != 8*time.Second {
+		t.Errorf("got %s, %t; want the Retry-After delay", d, ok)
+	}
+	if _, ok := b.Next(30 * time.Second); ok {
+		t.Error("got an attempt after MaxElapsed")
+	}
+	b = p.Start()
+	var elapsed time.Duration
+	for {
+		d, ok := b.Next(0)
+		if !ok {
+			break
+		}
+		elapsed += d
+		clock.Advance(d)
+	}
+	if elapsed != 1*time.Second+2*time.Second+4*time.Second {
+		t.Errorf("waited %s; want 7s within MaxElapsed", elapsed)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	clock := avstest.NewFakeClock(time.Unix(0, 0))
+	p := &avs.BackoffPolicy{Initial: time.Second, Clock: clock}
+	attempts := 0
+	done := make(chan error)
+	go func() {
+		done <- avs.Retry(context.Background(), p, func(ctx context.Context) error {
+			attempts++
+			switch attempts {
+			case 1:
+				return errors.New("first")
+			case 2:
+				return avs.WithRetryAfter(errors.New("second"), time.Minute)
+			}
+			return nil
+		})
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+	clock.Advance(59 * time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("returned %v before the Retry-After delay", err)
+	default:
+	}
+	clock.Advance(time.Second)
+	if err := <-done; err != nil || attempts != 3 {
+		t.Errorf("got %v after %d attempts", err, attempts)
+	}
+
+	// Permanent errors aren't retried, and are returned without the mark.
+	stop := errors.New("stop")
+	attempts = 0
+	err := avs.Retry(context.Background(), p, func(ctx context.Context) error {
+		attempts++
+		return avs.Permanent(stop)
+	})
+	if err != stop || attempts != 1 {
+		t.Errorf("got %v after %d attempts; want stop after 1", err, attempts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		clock.BlockUntil(1)
+		cancel()
+	}()
+	if err := avs.Retry(ctx, p, func(ctx context.Context) error { return stop }); err != stop {
+		t.Errorf("got %v; want the last error once the context is done", err)
+	}
+
+	// A Retry-After delay beyond the deadline of the context isn't waited.
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	attempts = 0
+	err = avs.Retry(ctx, p, func(ctx context.Context) error {
+		attempts++
+		return avs.WithRetryAfter(stop, time.Hour)
+	})
+	if !errors.Is(err, stop) || attempts != 1 {
+		t.Errorf("got %v after %d attempts; want stop after 1", err, attempts)
+	}
+}
+
+// The Retry-After header of AVS overrides the delay of the backoff policy.
+func TestClientBackoff(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	clock := avstest.NewFakeClock(time.Unix(0, 0))
+	client, err := avs.NewClient(
+		avs.WithEndpointURL(server.URL),
+		avs.WithClock(clock),
+		avs.WithRetryPolicy(&avs.RetryPolicy{Actions: map[avs.ExceptionCode]avs.RetryAction{
+			avs.ExceptionCodeThrottling: {Retries: 3},
+		}}),
+		avs.WithBackoff(avs.BackoffPolicies{Events: &avs.BackoffPolicy{Initial: time.Second}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Config().Backoff.Events == nil || client.Config().Backoff.Reconnect != nil {
+		t.Errorf("got backoff policies %+v", client.Config().Backoff)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := client.Do(avs.NewSynchronizeStateRequest("token", "m1", nil))
+		done <- err
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(6 * time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("returned %v before the Retry-After delay", err)
+	default:
+	}
+	clock.Advance(time.Second)
+	if err := <-done; err != nil || atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("got %v after %d requests", err, requests)
+	}
+
+	// Canceling the context stops the wait.
+	atomic.StoreInt32(&requests, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_, err := client.DoContext(ctx, avs.NewSynchronizeStateRequest("token", "m2", nil))
+		done <- err
+	}()
+	clock.BlockUntil(1)
+	cancel()
+	select {
+	case err := <-done:
+		var requestError *avs.RequestError
+		if !errors.As(err, &requestError) || requestError.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("got %v; want the throttling error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the context didn't stop the wait for the Retry-After delay")
+	}
+}
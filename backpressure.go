@@ -0,0 +1,160 @@
+package avs
+
+import (
+	"errors"
+	"sync"
+)
+
+// BackpressurePolicy specifies what a downchannel does when its buffer is
+// full because the application isn't reading directives fast enough.
+type BackpressurePolicy int
+
+// Possible values for BackpressurePolicy.
+const (
+	// Stop reading from AVS until there's room in the buffer. AVS may close
+	// the connection if it's blocked for too long.
+	BackpressureBlock BackpressurePolicy = iota
+	// Drop the oldest buffered directive that isn't part of a dialog. If all
+	// of them are, the downchannel fails as with BackpressureFail.
+	BackpressureDropOldestNonDialog
+	// Close the downchannel with ErrQueueFull.
+	BackpressureFail
+)
+
+// ErrQueueFull is returned by Downchannel.Err when the downchannel was closed
+// because its buffer was full.
+var ErrQueueFull = errors.New("avs: directive queue is full")
+
+// A bounded queue of directives that are forwarded to the application by a
+// separate goroutine.
+type directiveQueue struct {
+	size    int
+	policy  BackpressurePolicy
+	metrics *Metrics
+
+	mu       sync.Mutex
+	items    []*Message
+	inflight int // 1 while a directive is being handed to the application
+	closed   bool
+	ready    chan struct{}
+	space    chan struct{}
+}
+
+func newDirectiveQueue(size int, policy BackpressurePolicy, metrics *Metrics) *directiveQueue {
+	return &directiveQueue{
+		size:    size,
+		policy:  policy,
+		metrics: metrics,
+		ready:   make(chan struct{}, 1),
+		space:   make(chan struct{}, 1),
+	}
+}
+
+// Adds the directive to the queue, applying the policy if the queue is full.
+// It returns nil without adding the directive if done is closed.
+func (q *directiveQueue) push(directive *Message, done <-chan struct{}) error {
+	for {
+		q.mu.Lock()
+		if len(q.items)+q.inflight < q.size {
+			q.items = append(q.items, directive)
+			q.metrics.queueDepth(len(q.items) + q.inflight)
+			q.mu.Unlock()
+			signal(q.ready)
+			return nil
+		}
+		switch q.policy {
+		case BackpressureBlock:
+			q.mu.Unlock()
+			select {
+			case <-q.space:
+			case <-done:
+				return nil
+			}
+			continue
+		case BackpressureDropOldestNonDialog:
+			dropped := q.dropOldestNonDialog(directive)
+			q.mu.Unlock()
+			if dropped == nil {
+				return ErrQueueFull
+			}
+			if dropped != directive {
+				signal(q.ready)
+			}
+			q.metrics.directiveDropped(dropped)
+			return nil
+		default:
+			q.mu.Unlock()
+			return ErrQueueFull
+		}
+	}
+}
+
+// Makes room for the directive by dropping the oldest one that isn't part of
+// a dialog, which may be the directive itself. It returns the dropped
+// directive, or nil if all of them are part of a dialog.
+func (q *directiveQueue) dropOldestNonDialog(directive *Message) *Message {
+	for i, m := range q.items {
+		if m.header("dialogRequestId") == "" {
+			copy(q.items[i:], q.items[i+1:])
+			q.items[len(q.items)-1] = directive
+			return m
+		}
+	}
+	if directive.header("dialogRequestId") == "" {
+		return directive
+	}
+	return nil
+}
+
+// Delivers the queued directives to out until the queue is closed and empty
+// or done is closed, then closes out.
+func (q *directiveQueue) forward(out chan<- *Message, done <-chan struct{}) {
+	defer close(out)
+	for {
+		q.mu.Lock()
+		if len(q.items) == 0 {
+			closed := q.closed
+			q.mu.Unlock()
+			if closed {
+				return
+			}
+			select {
+			case <-q.ready:
+			case <-done:
+				return
+			}
+			continue
+		}
+		m := q.items[0]
+		q.items[0] = nil
+		q.items = q.items[1:]
+		q.inflight = 1
+		q.mu.Unlock()
+		select {
+		case out <- m:
+		case <-done:
+			return
+		}
+		q.mu.Lock()
+		q.inflight = 0
+		q.metrics.queueDepth(len(q.items))
+		q.mu.Unlock()
+		signal(q.space)
+	}
+}
+
+// Closes the queue. The directives already in it are still delivered.
+func (q *directiveQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	signal(q.ready)
+}
+
+// Wakes up the goroutine waiting on c, if any, without blocking.
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,100 @@
+package avs
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testDirective(messageId, dialogRequestId string) *Message {
+	m := &Message{Header: map[string]string{"namespace": "Speaker", "name": "SetVolume", "messageId": messageId}}
+	if dialogRequestId != "" {
+		m.Header["dialogRequestId"] = dialogRequestId
+	}
+	return m
+}
+
+func TestDirectiveQueueDropOldestNonDialog(t *testing.T) {
+	var dropped []string
+	metrics := &Metrics{DirectiveDropped: func(m *Message) { dropped = append(dropped, m.Header["messageId"]) }}
+	q := newDirectiveQueue(2, BackpressureDropOldestNonDialog, metrics)
+	done := make(chan struct{})
+	for _, m := range []*Message{testDirective("a", ""), testDirective("b", "d1"), testDirective("c", ""), testDirective("d", "d1")} {
+		if err := q.push(m, done); err != nil {
+			t.Fatalf("%s: %v", m.Header["messageId"], err)
+		}
+	}
+	if fmt.Sprint(dropped) != "[a c]" {
+		t.Errorf("dropped %v; want [a c]", dropped)
+	}
+	if err := q.push(testDirective("e", "d1"), done); err != ErrQueueFull {
+		t.Errorf("dropping a dialog directive should fail, got %v", err)
+	}
+	if len(q.items) != 2 || q.items[0].Header["messageId"] != "b" || q.items[1].Header["messageId"] != "d" {
+		t.Errorf("unexpected queue %v", q.items)
+	}
+}
+
+func TestDirectiveQueueBlock(t *testing.T) {
+	var depths []int
+	q := newDirectiveQueue(1, BackpressureBlock, &Metrics{QueueDepth: func(depth int) { depths = append(depths, depth) }})
+	done := make(chan struct{})
+	if err := q.push(testDirective("a", ""), done); err != nil {
+		t.Fatal(err)
+	}
+	pushed := make(chan error)
+	go func() { pushed <- q.push(testDirective("b", ""), done) }()
+	select {
+	case <-pushed:
+		t.Fatal("push didn't block on a full queue")
+	case <-time.After(10 * time.Millisecond):
+	}
+	out := make(chan *Message)
+	go q.forward(out, done)
+	if m := <-out; m.Header["messageId"] != "a" {
+		t.Errorf("got %s; want a", m.Header["messageId"])
+	}
+	if err := <-pushed; err != nil {
+		t.Fatal(err)
+	}
+	q.close()
+	if m := <-out; m.Header["messageId"] != "b" {
+		t.Errorf("got %s; want b", m.Header["messageId"])
+	}
+	if _, ok := <-out; ok {
+		t.Error("the channel should be closed after the queue is drained")
+	}
+	if fmt.Sprint(depths) != "[1 0 1 0]" {
+		t.Errorf("got depths %v; want [1 0 1 0]", depths)
+	}
+}
+
+func TestDownchannelBackpressureFail(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "multipart/related; boundary=------abcde123; type=application/json")
+		w.WriteHeader(200)
+		for i := 1; i <= 3; i++ {
+			fmt.Fprintf(w, "--------abcde123\r\nContent-Type: application/json\r\n\r\n"+
+				`{"directive":{"header":{"namespace":"Speaker","name":"SetVolume","messageId":"m%d"},"payload":{"volume":%d}}}`+"\r\n", i, i)
+		}
+		fmt.Fprint(w, "--------abcde123--\r\n")
+	}))
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL, DirectiveBufferSize: 1, Backpressure: BackpressureFail}
+	d, err := client.OpenDownchannel("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	// Give the downchannel time to fill its buffer.
+	time.Sleep(50 * time.Millisecond)
+	var received int
+	for range d.Directives {
+		received++
+	}
+	if received != 1 || d.Err() != ErrQueueFull {
+		t.Errorf("got %d directives and error %v; want 1 and ErrQueueFull", received, d.Err())
+	}
+}
@@ -0,0 +1,195 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// EventResult is the outcome of one of the events sent with SendEvents.
+type EventResult struct {
+	Event TypedMessage
+	// The response that the event was sent in, which is shared by all the
+	// events if they were sent in a single request.
+	Response *Response
+	// Why the event wasn't accepted, if it wasn't.
+	Err error
+}
+
+// SendEvents sends the events, in order, in a single request to the /events
+// endpoint, each with the provided contexts.
+//
+// If AVS rejects the request, the events are sent again one by one, so that
+// the result of each event has its own error. If the request fails for any
+// other reason, the error (e.g., the Exception) is attached to every result
+// and returned too. Otherwise, every result has a nil error and any exception
+// in the shared response is left in its directives. If the context is
+// canceled while sending them one by one, the results are returned with the
+// context's error.
+func (c *Client) SendEvents(ctx context.Context, accessToken string, events []TypedMessage, contexts ...TypedMessage) ([]EventResult, error) {
+	envelopes := make([]*Envelope, len(events))
+	for i, event := range events {
+		envelopes[i] = &Envelope{Context: append([]TypedMessage(nil), contexts...), Event: event}
+	}
+	return c.sendEnvelopes(ctx, accessToken, envelopes)
+}
+
+// Implements SendEvents with the contexts of each envelope.
+func (c *Client) sendEnvelopes(ctx context.Context, accessToken string, envelopes []*Envelope) ([]EventResult, error) {
+	if len(envelopes) == 0 {
+		return nil, nil
+	}
+	results := make([]EventResult, len(envelopes))
+	for i, envelope := range envelopes {
+		results[i].Event = envelope.Event
+	}
+	request := NewRequest(accessToken)
+	request.Event = envelopes[0].Event
+	request.Context = envelopes[0].Context
+	request.batch = envelopes[1:]
+	response, err := c.DoContext(ctx, request)
+	if err == nil {
+		for i := range results {
+			results[i].Response = response
+		}
+		return results, nil
+	}
+	if !isRejection(err) {
+		for i := range results {
+			results[i].Err = err
+		}
+		return results, err
+	}
+	if len(envelopes) == 1 {
+		results[0].Err = err
+		return results, nil
+	}
+	for i, envelope := range envelopes {
+		if err := ctx.Err(); err != nil {
+			for j := i; j < len(results); j++ {
+				results[j].Err = err
+			}
+			return results, err
+		}
+		request := NewRequest(accessToken)
+		request.Event = envelope.Event
+		request.Context = envelope.Context
+		results[i].Response, results[i].Err = c.DoContext(ctx, request)
+	}
+	return results, nil
+}
+
+// Returns whether AVS responded to a request with a client error, as opposed
+// to the request failing to reach it or AVS failing to handle it.
+func isRejection(err error) bool {
+	var exception *Exception
+	if errors.As(err, &exception) {
+		return exception.StatusCode >= 400 && exception.StatusCode < 500
+	}
+	var requestErr *RequestError
+	return errors.As(err, &requestErr) && requestErr.StatusCode >= 400 && requestErr.StatusCode < 500
+}
+
+// EventQueue holds events that couldn't be sent (e.g., while offline) until
+// they're flushed. It's safe for concurrent use.
+type EventQueue struct {
+	// BatchSize is the number of queued events from which Flush sends them in
+	// a single request with SendEvents. Zero means 5; a negative value always
+	// sends them one by one.
+	BatchSize int
+
+	mu     sync.Mutex
+	events []queuedEvent
+}
+
+// A queued event, with the contexts it was queued with, if any.
+type queuedEvent struct {
+	event    TypedMessage
+	contexts []TypedMessage
+}
+
+const defaultBatchSize = 5
+
+// Add queues an event with the contexts of the device when it happened, so
+// that it's sent with them however late. An event queued without contexts
+// is sent with those passed to Flush.
+func (q *EventQueue) Add(event TypedMessage, contexts ...TypedMessage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.events = append(q.events, queuedEvent{event, contexts})
+}
+
+// Len returns the number of queued events.
+func (q *EventQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.events)
+}
+
+// Flush sends the queued events in order, with their own contexts or else
+// the provided ones. Events that AVS rejects are dropped, and events that
+// couldn't be sent for any other reason stay queued, except those that may
+// have reached AVS (see ErrIndeterminate) and aren't idempotent (see
+// Client.IsIdempotent), which are dropped too. It returns the first error
+// that kept an event from being sent.
+func (q *EventQueue) Flush(ctx context.Context, client *Client, accessToken string, contexts ...TypedMessage) error {
+	q.mu.Lock()
+	events := q.events
+	q.events = nil
+	q.mu.Unlock()
+	if len(events) == 0 {
+		return nil
+	}
+	batchSize := q.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultBatchSize
+	}
+	envelopes := make([]*Envelope, len(events))
+	for i, e := range events {
+		envelopes[i] = &Envelope{Context: e.contexts, Event: e.event}
+		if e.contexts == nil {
+			envelopes[i].Context = append([]TypedMessage(nil), contexts...)
+		}
+	}
+	var failed []queuedEvent
+	var firstErr error
+	if batchSize > 0 && len(events) >= batchSize {
+		results, _ := client.sendEnvelopes(ctx, accessToken, envelopes)
+		for i, result := range results {
+			if result.Err != nil && !isRejection(result.Err) {
+				if resendable(client, events[i], result.Err) {
+					failed = append(failed, events[i])
+				}
+				if firstErr == nil {
+					firstErr = result.Err
+				}
+			}
+		}
+	} else {
+		for i, envelope := range envelopes {
+			request := NewRequest(accessToken)
+			request.Event = envelope.Event
+			request.Context = envelope.Context
+			if _, err := client.DoContext(ctx, request); err != nil && !isRejection(err) {
+				// Keep the order: the events after this one aren't sent.
+				failed, firstErr = events[i+1:], err
+				if resendable(client, events[i], err) {
+					failed = events[i:]
+				}
+				break
+			}
+		}
+	}
+	if len(failed) > 0 {
+		q.mu.Lock()
+		q.events = append(failed, q.events...)
+		q.mu.Unlock()
+	}
+	return firstErr
+}
+
+// Reports whether the event that failed with err may stay queued: unless it's
+// idempotent, an event that may have reached AVS mustn't be sent again.
+func resendable(client *Client, e queuedEvent, err error) bool {
+	return !errors.Is(err, ErrIndeterminate) || client.IsIdempotent(&Request{Event: e.event})
+}
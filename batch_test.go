@@ -0,0 +1,194 @@
+package avs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Returns a server that rejects requests with more than maxEvents events,
+// fails the events named in fail with a 500, and a function that returns
+// the message ids of the events in each request it received.
+func newBatchServer(t *testing.T, maxEvents int, fail string) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			t.Errorf("unexpected request: %v", err)
+			return
+		}
+		var ids []string
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			var metadata struct {
+				Context []*Message `json:"context"`
+				Event   *Message   `json:"event"`
+			}
+			if err := json.NewDecoder(p).Decode(&metadata); err != nil || len(metadata.Context) != 1 {
+				t.Errorf("invalid metadata part: %v", err)
+			}
+			ids = append(ids, metadata.Event.Header["messageId"])
+		}
+		mu.Lock()
+		requests = append(requests, fmt.Sprint(ids))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case len(ids) > maxEvents:
+			w.WriteHeader(400)
+			fmt.Fprint(w, `{"header":{"namespace":"System","name":"Exception"},"payload":{"code":"INVALID_REQUEST_EXCEPTION","description":"too many events"}}`)
+		case len(ids) == 1 && ids[0] == fail:
+			w.WriteHeader(500)
+		default:
+			w.WriteHeader(204)
+		}
+	}))
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requests...)
+	}
+}
+
+func testEvents(ids ...string) []TypedMessage {
+	events := make([]TypedMessage, len(ids))
+	for i, id := range ids {
+		events[i] = NewPlaybackStarted(id, "t", 0)
+	}
+	return events
+}
+
+func TestSendEvents(t *testing.T) {
+	server, requests := newBatchServer(t, 3, "")
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL}
+	volume := NewVolumeState(50, false)
+	results, err := client.SendEvents(context.Background(), "token", testEvents("a", "b", "c"), volume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || results[0].Response != results[2].Response || results[1].Err != nil {
+		t.Errorf("unexpected results %+v", results)
+	}
+	if got := fmt.Sprint(requests()); got != "[[a b c]]" {
+		t.Errorf("got requests %s; want [[a b c]]", got)
+	}
+
+	// A rejected batch is sent again one event at a time.
+	results, err = client.SendEvents(context.Background(), "token", testEvents("d", "e", "f", "g"), volume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(requests()[1:]); got != "[[d e f g] [d] [e] [f] [g]]" {
+		t.Errorf("got requests %s", got)
+	}
+	for _, result := range results {
+		if result.Err != nil || result.Response == nil {
+			t.Errorf("unexpected result %+v", result)
+		}
+	}
+
+	// A single rejected event has its exception as its result.
+	rejecting, _ := newBatchServer(t, 0, "")
+	defer rejecting.Close()
+	client.EndpointURL = rejecting.URL
+	results, err = client.SendEvents(context.Background(), "token", testEvents("h"), volume)
+	var exception *Exception
+	if err != nil || len(results) != 1 || !errors.As(results[0].Err, &exception) {
+		t.Errorf("got %v, results %+v; want the exception in the result", err, results)
+	}
+}
+
+// A batch that fails for another reason than a rejection has the error in
+// every result.
+func TestSendEventsFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		fmt.Fprint(w, `{"header":{"namespace":"System","name":"Exception"},"payload":{"code":"INTERNAL_SERVICE_EXCEPTION","description":"down"}}`)
+	}))
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL}
+	results, err := client.SendEvents(context.Background(), "token", testEvents("a", "b"), NewVolumeState(50, false))
+	var exception *Exception
+	if !errors.As(err, &exception) || exception.Payload.Code != ExceptionCodeInternalService {
+		t.Fatalf("got %v; want the exception", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results; want 2", len(results))
+	}
+	for _, result := range results {
+		if result.Err != err || result.Event == nil {
+			t.Errorf("got result %+v; want the exception of its event", result)
+		}
+	}
+}
+
+func TestEventQueueFlush(t *testing.T) {
+	server, requests := newBatchServer(t, 10, "c")
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL}
+	volume := NewVolumeState(50, false)
+	q := &EventQueue{BatchSize: 3}
+	for _, event := range testEvents("a", "b") {
+		q.Add(event)
+	}
+	if err := q.Flush(context.Background(), client, "token", volume); err != nil || q.Len() != 0 {
+		t.Fatalf("got %v with %d events left", err, q.Len())
+	}
+	for _, event := range testEvents("c", "d", "e") {
+		q.Add(event)
+	}
+	if err := q.Flush(context.Background(), client, "token", volume); err != nil || q.Len() != 0 {
+		t.Fatalf("got %v with %d events left", err, q.Len())
+	}
+	if got := fmt.Sprint(requests()); got != "[[a] [b] [c d e]]" {
+		t.Errorf("got requests %s; want [[a] [b] [c d e]]", got)
+	}
+
+	// The event that fails on its own stays queued.
+	q.BatchSize = -1
+	for _, event := range testEvents("c", "f") {
+		q.Add(event)
+	}
+	if err := q.Flush(context.Background(), client, "token", volume); err == nil || q.Len() != 2 {
+		t.Errorf("got %v with %d events left; want an error and 2 events", err, q.Len())
+	}
+}
+
+// Events that may have reached AVS are dropped unless they're idempotent.
+func TestEventQueueFlushIndeterminate(t *testing.T) {
+	// The server drops the connection once it has read the request.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}))
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL}
+	for _, batchSize := range []int{-1, 2} {
+		q := &EventQueue{BatchSize: batchSize}
+		q.Add(NewPlaybackStarted("a", "t", 0))
+		q.Add(NewVolumeChanged("b", 30, false))
+		if err := q.Flush(context.Background(), client, "token"); !errors.Is(err, ErrIndeterminate) {
+			t.Errorf("batch size %d: got %v; want an ErrIndeterminate error", batchSize, err)
+		}
+		if q.Len() != 1 || q.events[0].event.GetMessage().Header["messageId"] != "b" {
+			t.Errorf("batch size %d: got %d events left; want the VolumeChanged", batchSize, q.Len())
+		}
+	}
+}
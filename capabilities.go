@@ -0,0 +1,234 @@
+package avs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultCapabilitiesURL is the endpoint to which capabilities are published.
+const DefaultCapabilitiesURL = "https://api.amazonalexa.com/v1/devices/@self/capabilities"
+
+// InterfaceVersion is the version of an interface, such as 1.0.
+type InterfaceVersion struct {
+	Major, Minor int
+}
+
+// ParseInterfaceVersion parses a version in the "major.minor" format.
+func ParseInterfaceVersion(s string) (InterfaceVersion, error) {
+	i := strings.IndexByte(s, '.')
+	if i < 0 {
+		return InterfaceVersion{}, fmt.Errorf("avs: invalid interface version %q", s)
+	}
+	major, err := strconv.Atoi(s[:i])
+	if err != nil || major < 0 {
+		return InterfaceVersion{}, fmt.Errorf("avs: invalid interface version %q", s)
+	}
+	minor, err := strconv.Atoi(s[i+1:])
+	if err != nil || minor < 0 {
+		return InterfaceVersion{}, fmt.Errorf("avs: invalid interface version %q", s)
+	}
+	return InterfaceVersion{major, minor}, nil
+}
+
+// String returns the version in the "major.minor" format.
+func (v InterfaceVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// Less returns whether v is older than other.
+func (v InterfaceVersion) Less(other InterfaceVersion) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	return v.Minor < other.Minor
+}
+
+// MarshalJSON encodes the version as a string.
+func (v InterfaceVersion) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.String())
+}
+
+// UnmarshalJSON decodes a version from a string.
+func (v *InterfaceVersion) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseInterfaceVersion(s)
+	if err != nil {
+		return err
+	}
+	*v = parsed
+	return nil
+}
+
+// Capability declares an interface that the device supports.
+type Capability struct {
+	Type      string           `json:"type"`
+	Interface string           `json:"interface"`
+	Version   InterfaceVersion `json:"version"`
+	// Configurations, for the interfaces that need them.
+	Configurations CapabilityConfigurations `json:"configurations,omitempty"`
+}
+
+// NewCapability returns the capability for version major.minor of the
+// interface (e.g., "AudioPlayer").
+func NewCapability(iface string, major, minor int) Capability {
+	return NewCapabilityVersion(iface, InterfaceVersion{major, minor})
+}
+
+// NewCapabilityVersion returns the capability for a version of the interface.
+// See also NewCurrentCapability.
+func NewCapabilityVersion(iface string, version InterfaceVersion) Capability {
+	return Capability{Type: "AlexaInterface", Interface: iface, Version: version}
+}
+
+// WithConfigurations returns a copy of the capability with the
+// configurations.
+func (c Capability) WithConfigurations(configurations CapabilityConfigurations) Capability {
+	c.Configurations = configurations
+	return c
+}
+
+// Validate checks that the capability names an interface and that its
+// configurations are valid and for the same interface.
+func (c Capability) Validate() error {
+	if c.Interface == "" {
+		return fmt.Errorf("avs: capability without an interface")
+	}
+	if c.Configurations == nil {
+		return nil
+	}
+	if iface := c.Configurations.Interface(); iface != c.Interface {
+		return fmt.Errorf("avs: %s configurations on the %s capability", iface, c.Interface)
+	}
+	return c.Configurations.Validate()
+}
+
+// UnmarshalJSON decodes a capability. Configurations are decoded into the
+// type used for the interface, or into RawConfigurations.
+func (c *Capability) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Type           string           `json:"type"`
+		Interface      string           `json:"interface"`
+		Version        InterfaceVersion `json:"version"`
+		Configurations json.RawMessage  `json:"configurations"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*c = Capability{Type: v.Type, Interface: v.Interface, Version: v.Version}
+	if len(v.Configurations) == 0 || string(v.Configurations) == "null" {
+		return nil
+	}
+	configurations := newConfigurations(v.Interface)
+	if configurations == nil {
+		c.Configurations = &RawConfigurations{For: v.Interface, JSON: v.Configurations}
+		return nil
+	}
+	if err := json.Unmarshal(v.Configurations, configurations); err != nil {
+		return err
+	}
+	c.Configurations = configurations
+	return nil
+}
+
+// DeviceProfile is the set of interfaces that a device supports. It's
+// published to AVS with Client.PublishCapabilities and, when set on a
+// Dispatcher, decides which directives are supported.
+type DeviceProfile struct {
+	Capabilities []Capability
+}
+
+// NewDeviceProfile returns a profile with the provided capabilities.
+func NewDeviceProfile(capabilities ...Capability) *DeviceProfile {
+	return &DeviceProfile{Capabilities: capabilities}
+}
+
+// Capability returns the capability declared for the interface, if any.
+func (p *DeviceProfile) Capability(iface string) (Capability, bool) {
+	for _, c := range p.Capabilities {
+		if c.Interface == iface {
+			return c, true
+		}
+	}
+	return Capability{}, false
+}
+
+// Supports returns whether the profile declares the interface.
+func (p *DeviceProfile) Supports(iface string) bool {
+	_, ok := p.Capability(iface)
+	return ok
+}
+
+// Validate checks every capability of the profile, and that no interface is
+// declared twice.
+func (p *DeviceProfile) Validate() error {
+	seen := make(map[string]bool, len(p.Capabilities))
+	for _, c := range p.Capabilities {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		if seen[c.Interface] {
+			return fmt.Errorf("avs: %s is declared more than once", c.Interface)
+		}
+		seen[c.Interface] = true
+	}
+	return nil
+}
+
+// MarshalJSON encodes the profile as a capabilities publication.
+func (p *DeviceProfile) MarshalJSON() ([]byte, error) {
+	capabilities := p.Capabilities
+	if capabilities == nil {
+		capabilities = []Capability{}
+	}
+	return json.Marshal(struct {
+		EnvelopeVersion string       `json:"envelopeVersion"`
+		Capabilities    []Capability `json:"capabilities"`
+	}{"20160207", capabilities})
+}
+
+// PublishCapabilities tells AVS which interfaces the device supports. It must
+// be called before connecting to AVS whenever the profile changes. The
+// profile is validated first. Publishing is safe to repeat, so failures are
+// retried per the RetryPolicy.
+func (c *Client) PublishCapabilities(accessToken string, profile *DeviceProfile) error {
+	if err := profile.Validate(); err != nil {
+		return err
+	}
+	body, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	url := c.CapabilitiesURL
+	if url == "" {
+		url = DefaultCapabilitiesURL
+	}
+	return c.RetryPolicy.retry(context.Background(), c.Clock, c.Metrics, c.Backoff.Capabilities, true, accessToken, func(accessToken string) error {
+		req, err := c.newRequestURL("PUT", url, accessToken, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.httpClient().Do(req)
+		if err != nil {
+			return notConnected(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			return &RequestError{
+				StatusCode: resp.StatusCode,
+				Status:     resp.Status,
+				RequestId:  resp.Header.Get("x-amzn-requestid"),
+				RetryAfter: retryAfter(resp.Header.Get("Retry-After"), 0),
+			}
+		}
+		return nil
+	})
+}
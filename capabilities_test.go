@@ -0,0 +1,95 @@
+package avs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInterfaceVersion(t *testing.T) {
+	v, err := ParseInterfaceVersion("1.10")
+	if err != nil || v != (InterfaceVersion{1, 10}) {
+		t.Fatalf("got %v, %v; want 1.10", v, err)
+	}
+	if !(InterfaceVersion{1, 9}).Less(v) || v.Less(InterfaceVersion{1, 9}) {
+		t.Error("1.9 should be older than 1.10")
+	}
+	for _, s := range []string{"", "1", "1.", "a.1", "1.-1"} {
+		if _, err := ParseInterfaceVersion(s); err == nil {
+			t.Errorf("%q should be invalid", s)
+		}
+	}
+	var c Capability
+	if err := json.Unmarshal([]byte(`{"type":"AlexaInterface","interface":"Speaker","version":"1.0"}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c != NewCapability("Speaker", 1, 0) {
+		t.Errorf("unexpected capability %+v", c)
+	}
+}
+
+func TestPublishCapabilities(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(400)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+	client := &Client{CapabilitiesURL: server.URL}
+	profile := NewDeviceProfile(NewCapability("AudioPlayer", 1, 0), NewCapability("Speaker", 1, 0))
+	if err := client.PublishCapabilities("token", profile); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"envelopeVersion":"20160207","capabilities":[` +
+		`{"type":"AlexaInterface","interface":"AudioPlayer","version":"1.0"},` +
+		`{"type":"AlexaInterface","interface":"Speaker","version":"1.0"}]}`
+	if body != want {
+		t.Errorf("got %s; want %s", body, want)
+	}
+	if err := client.PublishCapabilities("other", profile); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestDispatcherProfile(t *testing.T) {
+	d := NewDispatcher()
+	d.Profile = NewDeviceProfile(NewCapability("AudioPlayer", 1, 0))
+	var exceptions []*ExceptionEncountered
+	d.ReportException = func(e *ExceptionEncountered) { exceptions = append(exceptions, e) }
+	noop := func(ctx context.Context, directive TypedMessage) error { return nil }
+	d.HandleFunc("AudioPlayer.Play", noop)
+	d.HandleFunc("AudioPlayer.Stop", noop)
+	d.HandleFunc("Speaker", noop)
+	err := d.Validate()
+	if err == nil || !strings.Contains(err.Error(), "AudioPlayer.ClearQueue has no handler") || !strings.Contains(err.Error(), "handler for Speaker") {
+		t.Errorf("unexpected validation error %v", err)
+	}
+
+	for _, m := range []*Message{
+		{Header: map[string]string{"namespace": "AudioPlayer", "name": "ClearQueue", "messageId": "m1"}},
+		{Header: map[string]string{"namespace": "Speaker", "name": "SetVolume", "messageId": "m2"}},
+	} {
+		if err := d.Dispatch(context.Background(), m); !errors.Is(err, ErrUnsupportedDirective) {
+			t.Errorf("%s: got %v; want ErrUnsupportedDirective", m, err)
+		}
+	}
+	if len(exceptions) != 2 || exceptions[0].Payload.Error.Type != ErrorTypeUnsupportedOperation {
+		t.Errorf("unexpected exceptions %+v", exceptions)
+	}
+
+	d.HandleFunc("AudioPlayer.ClearQueue", noop)
+	d.Profile.Capabilities = append(d.Profile.Capabilities, NewCapability("Speaker", 1, 0))
+	if err := d.Validate(); err != nil {
+		t.Error(err)
+	}
+}
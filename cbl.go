@@ -0,0 +1,259 @@
+package avs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultAuthURL is the base URL of Login with Amazon.
+const DefaultAuthURL = "https://api.amazon.com"
+
+// The paths of the Login with Amazon endpoints used for code-based linking.
+const (
+	CodePairPath = "/auth/O2/create/codepair"
+	TokenPath    = "/auth/O2/token"
+)
+
+// CodePair is the code that the user enters at the verification URI to link
+// the device, along with the device code used to get the access token.
+type CodePair struct {
+	UserCode        string
+	DeviceCode      string
+	VerificationURI string
+	// When the code pair expires and how often the token may be polled.
+	Expires  time.Time
+	Interval time.Duration
+}
+
+// Token is an access token obtained from Login with Amazon.
+type Token struct {
+	AccessToken  string
+	RefreshToken string
+	Expiry       time.Time
+}
+
+// AuthError is returned when Login with Amazon rejects a request. Errors for
+// invalid or expired grants are ErrUnauthorized errors.
+type AuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	StatusCode  int    `json:"-"`
+}
+
+// Error returns the AuthError formatted as a human readable string.
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("avs: authorization failed with %s: %s", e.Code, e.Description)
+}
+
+// Is reports whether the error is of the kind of target.
+func (e *AuthError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.Code == "invalid_grant" || e.Code == "expired_token" || e.Code == "unauthorized_client"
+	case ErrThrottled:
+		return e.Code == "slow_down"
+	}
+	return false
+}
+
+// CBLAuthorizer links a device to a user account with code-based linking: the
+// device shows a code, which the user enters on another device, meanwhile
+// the device polls for its access token.
+type CBLAuthorizer struct {
+	// The security profile and product of the device.
+	ClientId           string
+	ProductId          string
+	DeviceSerialNumber string
+	// AuthURL is the base URL of Login with Amazon. If empty, DefaultAuthURL
+	// is used.
+	AuthURL string
+	// Client, if set, provides the transport and headers of the requests.
+	Client *Client
+	// Clock, if set, replaces the system clock.
+	Clock Clock
+	// Logger, if set, receives a line when Authorize links the device anew
+	// because its refresh token was revoked.
+	Logger *log.Logger
+}
+
+// RequestCodePair requests a new code for the user to enter.
+func (a *CBLAuthorizer) RequestCodePair(ctx context.Context) (*CodePair, error) {
+	scope, err := json.Marshal(map[string]interface{}{
+		"alexa:all": map[string]interface{}{
+			"productID": a.ProductId,
+			"productInstanceAttributes": map[string]string{
+				"deviceSerialNumber": a.DeviceSerialNumber,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		UserCode        string `json:"user_code"`
+		DeviceCode      string `json:"device_code"`
+		VerificationURI string `json:"verification_uri"`
+		ExpiresIn       int    `json:"expires_in"`
+		Interval        int    `json:"interval"`
+	}
+	err = a.post(ctx, CodePairPath, url.Values{
+		"response_type": {"device_code"},
+		"client_id":     {a.ClientId},
+		"scope":         {"alexa:all"},
+		"scope_data":    {string(scope)},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &CodePair{
+		UserCode:        resp.UserCode,
+		DeviceCode:      resp.DeviceCode,
+		VerificationURI: resp.VerificationURI,
+		Expires:         clockOrDefault(a.Clock).Now().Add(time.Duration(resp.ExpiresIn) * time.Second),
+		Interval:        time.Duration(resp.Interval) * time.Second,
+	}, nil
+}
+
+// WaitForToken polls for the access token of the code pair until the user
+// has entered the code, the code pair expires or ctx is done.
+func (a *CBLAuthorizer) WaitForToken(ctx context.Context, pair *CodePair) (*Token, error) {
+	clock := clockOrDefault(a.Clock)
+	interval := pair.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	for {
+		token, err := a.token(ctx, url.Values{
+			"grant_type":  {"device_code"},
+			"device_code": {pair.DeviceCode},
+			"user_code":   {pair.UserCode},
+		})
+		authErr, ok := err.(*AuthError)
+		switch {
+		case err == nil:
+			return token, nil
+		case ok && authErr.Code == "slow_down":
+			interval *= 2
+		case !ok || authErr.Code != "authorization_pending":
+			return nil, err
+		}
+		if !pair.Expires.IsZero() && !clock.Now().Add(interval).Before(pair.Expires) {
+			return nil, withKind(ErrUnauthorized, fmt.Errorf("avs: code %s expired before it was entered", pair.UserCode))
+		}
+		select {
+		case <-clock.After(interval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// The Store namespace and key of the refresh token saved by Authorize.
+const (
+	cblStoreNamespace  = "CBL"
+	cblRefreshTokenKey = "refreshToken"
+)
+
+// Authorize returns an access token for the device. If the store has the
+// refresh token of a previous link, the token is refreshed with it;
+// otherwise, or if the refresh token was revoked, the device is linked anew:
+// prompt is called with the code for the user to enter, and the refresh
+// token is saved in the store. The store may be nil.
+func (a *CBLAuthorizer) Authorize(ctx context.Context, store Store, prompt func(pair *CodePair)) (*Token, error) {
+	if store != nil {
+		if refreshToken, err := store.Get(cblStoreNamespace, cblRefreshTokenKey); err == nil {
+			token, err := a.Refresh(ctx, string(refreshToken))
+			if err == nil || !errors.Is(err, ErrUnauthorized) {
+				return token, err
+			}
+			if a.Logger != nil {
+				a.Logger.Printf("avs: linking the device again: %v", err)
+			}
+		}
+	}
+	pair, err := a.RequestCodePair(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prompt(pair)
+	token, err := a.WaitForToken(ctx, pair)
+	if err != nil {
+		return nil, err
+	}
+	if store != nil {
+		if err := store.Put(cblStoreNamespace, cblRefreshTokenKey, []byte(token.RefreshToken)); err != nil {
+			return nil, err
+		}
+	}
+	return token, nil
+}
+
+// Refresh returns a new access token for the refresh token.
+func (a *CBLAuthorizer) Refresh(ctx context.Context, refreshToken string) (*Token, error) {
+	return a.token(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {a.ClientId},
+	})
+}
+
+func (a *CBLAuthorizer) token(ctx context.Context, form url.Values) (*Token, error) {
+	var resp struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+	}
+	if err := a.post(ctx, TokenPath, form, &resp); err != nil {
+		return nil, err
+	}
+	return &Token{
+		AccessToken:  resp.AccessToken,
+		RefreshToken: resp.RefreshToken,
+		Expiry:       clockOrDefault(a.Clock).Now().Add(time.Duration(resp.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// Posts the form to Login with Amazon and decodes the JSON response into v.
+func (a *CBLAuthorizer) post(ctx context.Context, path string, form url.Values, v interface{}) error {
+	client := DefaultClient
+	if a.Client != nil {
+		client = a.Client
+	}
+	base := a.AuthURL
+	if base == "" {
+		base = DefaultAuthURL
+	}
+	req, err := client.newRequestURL("POST", base+path, "", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Del("Authorization")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return notConnected(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		authErr := &AuthError{StatusCode: resp.StatusCode}
+		if json.Unmarshal(data, authErr) != nil || authErr.Code == "" {
+			return &RequestError{StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+		return authErr
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return invalidJSON(err)
+	}
+	return nil
+}
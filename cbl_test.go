@@ -0,0 +1,132 @@
+package avs_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+	"github.com/fika-io/go-avs/avstest"
+)
+
+func TestCBLAuthorizer(t *testing.T) {
+	var polls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == avs.CodePairPath:
+			var scope map[string]map[string]interface{}
+			json.Unmarshal([]byte(r.Form.Get("scope_data")), &scope)
+			if r.Form.Get("client_id") != "client" || scope["alexa:all"]["productID"] != "product" {
+				t.Errorf("got code pair request %v", r.Form)
+			}
+			w.Write([]byte(`{"user_code":"ABC123","device_code":"dev","verification_uri":"https://amazon.com/us/code","expires_in":600,"interval":5}`))
+		case r.Form.Get("grant_type") == "device_code":
+			if atomic.AddInt32(&polls, 1) < 3 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"authorization_pending","error_description":"waiting"}`))
+				return
+			}
+			w.Write([]byte(`{"access_token":"access","refresh_token":"refresh","expires_in":3600}`))
+		case r.Form.Get("refresh_token") == "refresh":
+			w.Write([]byte(`{"access_token":"access2","refresh_token":"refresh","expires_in":3600}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant","error_description":"bad token"}`))
+		}
+	}))
+	defer server.Close()
+	clock := avstest.NewFakeClock(time.Now())
+	a := &avs.CBLAuthorizer{ClientId: "client", ProductId: "product", DeviceSerialNumber: "1", AuthURL: server.URL, Clock: clock}
+	ctx := context.Background()
+	pair, err := a.RequestCodePair(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair.UserCode != "ABC123" || pair.Interval != 5*time.Second {
+		t.Errorf("got code pair %+v", pair)
+	}
+	go func() {
+		for i := 0; i < 2; i++ {
+			clock.BlockUntil(1)
+			clock.Advance(pair.Interval)
+		}
+	}()
+	token, err := a.WaitForToken(ctx, pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access" || atomic.LoadInt32(&polls) != 3 {
+		t.Errorf("got token %+v after %d polls; want access after 3", token, polls)
+	}
+	if token, err = a.Refresh(ctx, "refresh"); err != nil || token.AccessToken != "access2" {
+		t.Errorf("got %+v, %v; want a refreshed token", token, err)
+	}
+	if _, err := a.Refresh(ctx, "revoked"); !errors.Is(err, avs.ErrUnauthorized) {
+		t.Errorf("got %v; want ErrUnauthorized", err)
+	}
+}
+
+func TestCBLAuthorizerAuthorize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == avs.CodePairPath:
+			w.Write([]byte(`{"user_code":"ABC123","device_code":"dev","verification_uri":"https://amazon.com/us/code","expires_in":600,"interval":5}`))
+		case r.Form.Get("grant_type") == "device_code":
+			w.Write([]byte(`{"access_token":"access","refresh_token":"refresh","expires_in":3600}`))
+		case r.Form.Get("refresh_token") == "refresh":
+			w.Write([]byte(`{"access_token":"access2","refresh_token":"refresh","expires_in":3600}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant","error_description":"bad token"}`))
+		}
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "avs-cbl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := avs.NewFileStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &avs.CBLAuthorizer{ClientId: "client", ProductId: "product", DeviceSerialNumber: "1", AuthURL: server.URL}
+	ctx := context.Background()
+	var prompts int
+	prompt := func(pair *avs.CodePair) {
+		if pair.UserCode != "ABC123" {
+			t.Errorf("prompted with %+v", pair)
+		}
+		prompts++
+	}
+
+	// The first call links the device, the second refreshes the saved token.
+	if token, err := a.Authorize(ctx, store, prompt); err != nil || token.AccessToken != "access" || prompts != 1 {
+		t.Fatalf("got %+v, %v after %d prompts; want a linked token", token, err, prompts)
+	}
+	if token, err := a.Authorize(ctx, store, prompt); err != nil || token.AccessToken != "access2" || prompts != 1 {
+		t.Errorf("got %+v, %v after %d prompts; want a refreshed token", token, err, prompts)
+	}
+
+	// A revoked refresh token links the device again and is replaced.
+	if err := store.Put("CBL", "refreshToken", []byte("revoked")); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := a.Authorize(ctx, store, prompt); err != nil || token.AccessToken != "access" || prompts != 2 {
+		t.Errorf("got %+v, %v after %d prompts; want a linked token", token, err, prompts)
+	}
+	if v, err := store.Get("CBL", "refreshToken"); err != nil || string(v) != "refresh" {
+		t.Errorf("saved %q, %v; want the new refresh token", v, err)
+	}
+}
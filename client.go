@@ -1,110 +1,489 @@
 package avs
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"golang.org/x/net/http2"
 	"io"
 	"io/ioutil"
-	"mime"
-	"mime/multipart"
+	"log"
 	"net"
 	"net/http"
+	"net/textproto"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/fika-io/go-avs/multipart2"
 )
 
-var (
-	tr = &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
+// The HTTP/2 transport shared by the clients that don't have their own.
+var tr = newTransport(nil, nil)
+
+// NewTransport returns a new HTTP/2 transport configured like the one that
+// clients share by default. Setting it as the Transport of a group of clients
+// makes them share its connections, apart from the other clients of the
+// process.
+func NewTransport() *http.Transport {
+	return newTransport(nil, tr.TLSClientConfig.Clone())
+}
+
+// Returns a new HTTP/2 transport that opens connections with dial, or through
+// the proxy of the environment with the system dialer if dial is nil. The TLS
+// configuration may be nil.
+func newTransport(dial func(ctx context.Context, network, addr string) (net.Conn, error), tlsConfig *tls.Config) *http.Transport {
+	t := &http.Transport{
+		DialContext:           dial,
+		TLSClientConfig:       tlsConfig,
 		MaxIdleConns:          100,
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 	}
-	_ = http2.ConfigureTransport(tr)
-)
+	if dial == nil {
+		t.Proxy = http.ProxyFromEnvironment
+		t.DialContext = (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			DualStack: true,
+		}).DialContext
+	}
+	_ = http2.ConfigureTransport(t)
+	return t
+}
 
-// Multipart object returned by AVS.
+// Multipart object returned by AVS. The directive is kept as received.
 type responsePart struct {
-	Directive *Message
+	Directive json.RawMessage
 }
 
 // Client enables making requests and creating downchannels to AVS.
+//
+// Any number of clients may be used concurrently in a process (e.g., one per
+// device of a gateway): their state is their own. They only share the HTTP/2
+// transport, unless they have their own (see IsolatedTransport and
+// NewTransport), and the settings of the package, which are global: the JSON
+// codec set with SetJSONCodec, the mode set with SetParseMode, the
+// RedactedFields of FormatMessage and Message.Redacted, and the
+// DefaultLimits that fill in the zero fields of their Limits. These should
+// only be changed before any client is used. The message registry and the
+// current interface versions (see CurrentVersion) are read-only.
+//
+// A Client holds the state of its requests (e.g., its health, its token
+// source and its transport) along with locks, so it must not be copied once
+// created: use a *Client, and create another client with the same settings
+// with NewClient instead (see Config).
 type Client struct {
 	EndpointURL string
+	// RateLimiter, if set, paces the events sent with Do. Recognize events,
+	// and the ESP measurements sent ahead of them, are never delayed.
+	RateLimiter *RateLimiter
+	// RetryPolicy, if set, decides which failed requests should be retried.
+	// Requests with audio are only retried if the audio is an io.Seeker.
+	RetryPolicy *RetryPolicy
+	// Backoff sets how long to wait between the retries of each kind of
+	// operation.
+	Backoff BackoffPolicies
+	// Clock, if set, replaces the system clock.
+	Clock Clock
+	// APIProfile, if set, converts the events sent with Do to that version
+	// of the API, so the event constructors can be used with any fleet.
+	APIProfile APIProfile
+	// EchoSpatialPerception, if set, is called right before a Recognize event
+	// is sent with Do. The measurements it returns are sent first in a
+	// ReportEchoSpatialPerceptionData event, which the RateLimiter doesn't
+	// delay. Returning ok as false skips it. Failing to send it doesn't keep
+	// the Recognize event from being sent; the error is logged to Logger.
+	EchoSpatialPerception func() (voiceEnergy, ambientEnergy float64, ok bool)
+	// UserAgent identifies the product to AVS (e.g., "MyProduct/1.2"). If
+	// empty, DefaultUserAgent is sent.
+	UserAgent string
+	// StreamingThreshold is the size in bytes above which directives are
+	// decoded while being read instead of being read into memory first. Zero
+	// means 64 KB; a negative value disables streaming.
+	StreamingThreshold int
+	// DirectiveBufferSize is the number of directives that a downchannel
+	// buffers while the application isn't reading them. Zero means none.
+	DirectiveBufferSize int
+	// Backpressure decides what a downchannel does when its buffer is full.
+	// It only applies if DirectiveBufferSize is positive; an unbuffered
+	// downchannel always blocks.
+	Backpressure BackpressurePolicy
+	// Metrics, if set, receives measurements from the client.
+	Metrics *Metrics
+	// CapabilitiesURL is the endpoint used by PublishCapabilities. If empty,
+	// DefaultCapabilitiesURL is used.
+	CapabilitiesURL string
+	// BeforeSend are called in order with every event sent to the /events
+	// endpoint, right before it's encoded. They get copies of the event and
+	// its contexts, which they may change, add or remove; an error aborts
+	// the request. The envelope is validated before and after the hooks.
+	BeforeSend []BeforeSendHook
+	// Transport, if set, replaces the package's shared HTTP/2 transport for
+	// all the requests of the client.
+	Transport http.RoundTripper
+	// Limits bounds the size of the parts of responses and downchannels.
+	Limits Limits
+	// Sequencer, if set, numbers every event sent, before the BeforeSend
+	// hooks. The events of the request are changed.
+	Sequencer *Sequencer
+	// DialContext, if set, opens the connections of the client instead of
+	// the system dialer (e.g., to reach AVS through a local proxy on a Unix
+	// socket). Connections are still secured with TLS for https endpoints,
+	// and the proxy of the environment isn't used. It can't be combined
+	// with Transport, and must be set before the client is used.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// IsolatedTransport gives the client its own HTTP/2 transport instead of
+	// the one shared by the clients of the process, so that its connections
+	// and their stream limits aren't shared with other devices (e.g., in a
+	// gateway for many devices). It can't be combined with Transport, and
+	// must be set before the client is used. Clients with a DialContext
+	// always have their own transport.
+	IsolatedTransport bool
+	// IdempotentNamespaces overrides which events may be sent again after an
+	// ErrIndeterminate error (see IsIdempotent): the events of a namespace
+	// in the map are idempotent if its value is true.
+	IdempotentNamespaces map[string]bool
+	// FailOnException makes Do and DoContext return the first
+	// System.Exception directive of a successful response as the error,
+	// along with the response and its other directives. The exception is
+	// subject to the RetryPolicy. DoStream is not affected; see
+	// Response.Exceptions.
+	FailOnException bool
+	// CompressMetadata sends the metadata part of the events (the event and
+	// its contexts) gzip-compressed, with a Content-Encoding header. It's
+	// off by default since the endpoint must accept it; audio and other
+	// attachments are never compressed. Directive parts are decompressed
+	// whatever this setting if they have a gzip Content-Encoding.
+	CompressMetadata bool
+	// OnDeauthorized, if set, is called with the error of the rejected
+	// request when AVS rejects the device for good. See SetTokenSource.
+	OnDeauthorized func(err error)
+	// RawPartHandler, if set, receives the parts of the downchannels that
+	// are neither JSON nor attachments (e.g., experimental extensions of the
+	// protocol). Otherwise, they're discarded and logged to Logger.
+	RawPartHandler RawPartHandler
+	// Logger, if set, receives debug messages, such as the raw parts of the
+	// downchannels that are discarded.
+	Logger *log.Logger
+
+	header      http.Header
+	endpointURL atomic.Value // set by SetEndpointURL
+	health      clientHealth
+	processed   processedTracker
+	auth        clientAuth
+
+	ownOnce      sync.Once
+	ownTransport *http.Transport
+}
+
+// BeforeSendHook is called with every event that a Client is about to send.
+// See Client.BeforeSend.
+type BeforeSendHook func(ctx context.Context, envelope *Envelope) error
+
+// DefaultUserAgent is the User-Agent sent by clients that don't set one.
+const DefaultUserAgent = "go-avs/" + LibraryVersion
+
+// LibraryVersion is the version of this package.
+const LibraryVersion = "0.1.0"
+
+// Headers that are set by the Client or that would break HTTP/2.
+var reservedHeaders = map[string]bool{
+	"Authorization":     true,
+	"Connection":        true,
+	"Content-Length":    true,
+	"Content-Type":      true,
+	"Host":              true,
+	"Keep-Alive":        true,
+	"Te":                true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+	"User-Agent":        true,
+}
+
+// SetHeader adds a header that's sent with every request to the endpoint of
+// AVS, including the downchannel and pings. It isn't sent to other hosts,
+// like Login with Amazon or the Capabilities API. Headers that the protocol
+// depends on, like Authorization and Content-Type, are rejected; use the
+// UserAgent field for the User-Agent. SetHeader must not be called while
+// requests are in flight.
+func (c *Client) SetHeader(key, value string) error {
+	key = http.CanonicalHeaderKey(key)
+	if reservedHeaders[key] {
+		return fmt.Errorf("avs: header %s can't be overridden", key)
+	}
+	if c.header == nil {
+		c.header = make(http.Header)
+	}
+	c.header.Set(key, value)
+	return nil
+}
+
+// SetEndpointURL switches the client to another base endpoint URL for the
+// requests made from then on. Unlike setting EndpointURL, it may be called
+// while requests are in flight.
+func (c *Client) SetEndpointURL(endpoint string) error {
+	if err := validateURL("endpoint", endpoint); err != nil {
+		return err
+	}
+	c.endpointURL.Store(endpoint)
+	return nil
+}
+
+// Returns the endpoint set with SetEndpointURL, or EndpointURL.
+func (c *Client) endpoint() string {
+	if endpoint, ok := c.endpointURL.Load().(string); ok {
+		return endpoint
+	}
+	return c.EndpointURL
+}
+
+// Returns an HTTP client that uses the client's transport.
+func (c *Client) httpClient() *http.Client {
+	if c.Transport != nil {
+		return &http.Client{Transport: c.Transport}
+	}
+	if c.DialContext != nil || c.IsolatedTransport {
+		// The TLS settings of the shared transport still apply.
+		c.ownOnce.Do(func() { c.ownTransport = newTransport(c.DialContext, tr.TLSClientConfig.Clone()) })
+		return &http.Client{Transport: c.ownTransport}
+	}
+	return &http.Client{Transport: tr}
+}
+
+// Returns the transport that the client created for itself, if any.
+func (c *Client) ownedTransport() *http.Transport {
+	if c.Transport != nil || c.DialContext == nil && !c.IsolatedTransport {
+		return nil
+	}
+	return c.httpClient().Transport.(*http.Transport)
+}
+
+// CloseIdleConnections closes the connections of the client that aren't in
+// use. Clients with their own transport (see IsolatedTransport) should call
+// it once they're no longer used, after closing their downchannels.
+func (c *Client) CloseIdleConnections() {
+	c.httpClient().CloseIdleConnections()
+}
+
+// Returns a new request to AVS with the client's headers.
+func (c *Client) newRequest(method, path, accessToken string, body io.Reader) (*http.Request, error) {
+	req, err := c.newRequestURL(method, c.endpoint()+path, accessToken, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range c.header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	return req, nil
+}
+
+// Returns a new request to any host, without the headers set with
+// SetHeader.
+func (c *Client) newRequestURL(method, url, accessToken string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	userAgent := c.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	return req, nil
 }
 
 // CreateDownchannel establishes a persistent connection with AVS and returns a
 // read-only channel through which AVS will deliver directives.
 func (c *Client) CreateDownchannel(accessToken string) (<-chan *Message, error) {
-	req, err := http.NewRequest("GET", c.EndpointURL+DirectivesPath, nil)
+	d, err := c.OpenDownchannel(accessToken)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-	http2Client := &http.Client{Transport: tr}
-	resp, err := http2Client.Do(req)
+	return d.Directives, nil
+}
+
+// Do posts a request to the AVS service's /events endpoint.
+func (c *Client) Do(request *Request) (*Response, error) {
+	return c.DoContext(context.Background(), request)
+}
+
+// DoContext is like Do but the request is canceled when the context is.
+func (c *Client) DoContext(ctx context.Context, request *Request) (*Response, error) {
+	return c.send(ctx, request, false)
+}
+
+// DoStream is like DoContext but returns as soon as AVS starts responding.
+// The directives are read with the Next method of the Response, and the
+// Response must be closed or read until Next returns io.EOF.
+func (c *Client) DoStream(ctx context.Context, request *Request) (*Response, error) {
+	return c.send(ctx, request, true)
+}
+
+func (c *Client) send(ctx context.Context, request *Request, stream bool) (*Response, error) {
+	atomic.AddInt32(&c.health.queued, 1)
+	defer atomic.AddInt32(&c.health.queued, -1)
+	source, generation, err := c.tokenSource()
 	if err != nil {
 		return nil, err
 	}
-	if more, err := checkStatusCode(resp); !more {
-		resp.Body.Close()
+	accessToken := request.AccessToken
+	if accessToken == "" && source != nil {
+		if accessToken, err = source.Token(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if c.Sequencer != nil {
+		if err := c.Sequencer.stamp(request); err != nil {
+			return nil, err
+		}
+	}
+	request, err = c.APIProfile.apply(request)
+	if err != nil {
 		return nil, err
 	}
-	directives := make(chan *Message)
-	go func() {
-		defer close(directives)
-		defer resp.Body.Close()
-		mr, err := newMultipartReaderFromResponse(resp)
-		if err != nil {
-			return
+	if request, err = c.beforeSend(ctx, request); err != nil {
+		return nil, err
+	}
+	c.reportEchoSpatialPerception(ctx, request)
+	attachments := request.attachments()
+	if err := validateAttachments(attachments); err != nil {
+		return nil, err
+	}
+	envelopes := append([]*Envelope{{Context: request.Context, Event: request.Event}}, request.batch...)
+	c.processed.expect(envelopes)
+	policy := c.RetryPolicy
+	rewind, ok := newRewinder(attachments)
+	if !ok {
+		// The attachments can't be sent more than once.
+		policy = nil
+	}
+	var response *Response
+	var exception *Exception
+	attempt := 0
+	send := func(accessToken string) error {
+		if attempt > 0 {
+			if err := rewind.rewind(); err != nil {
+				return err
+			}
 		}
-		// TODO: Consider reporting errors.
-		for {
-			p, err := mr.NextPart()
-			if err != nil {
-				break
+		attempt++
+		var err error
+		response, err = c.do(ctx, accessToken, request, attachments, stream)
+		exception = nil
+		if err == nil && !stream && c.FailOnException {
+			if exceptions := response.Exceptions(); len(exceptions) > 0 {
+				exception = exceptions[0]
+				return exception
 			}
-			data, err := ioutil.ReadAll(p)
-			if err != nil {
-				break
+		}
+		return err
+	}
+	err = policy.retry(ctx, c.Clock, c.Metrics, c.Backoff.Events, !inDialog(request), accessToken, send)
+	if err != nil && source != nil {
+		// A rejection deauthorizes the client even if the request can't be
+		// sent again.
+		if token, retry := c.reauthorize(ctx, source, generation, accessToken, err); retry && ok {
+			if err = send(token); err != nil {
+				if reason, _ := ClassifyForbidden(err); reason == ForbiddenRevoked {
+					c.deauthorize(generation, err)
+				}
 			}
-			var response responsePart
-			err = json.Unmarshal(data, &response)
-			if err != nil {
-				break
+		}
+	}
+	if err != nil {
+		// The events won't be processed.
+		c.processed.fail(envelopes, err)
+	}
+	if exception != nil {
+		return response, exception
+	}
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// Returns a copy of the request with the envelopes of its events passed
+// through the BeforeSend hooks. The request is returned as is if there are
+// no hooks.
+func (c *Client) beforeSend(ctx context.Context, request *Request) (*Request, error) {
+	if len(c.BeforeSend) == 0 {
+		return request, nil
+	}
+	envelopes := append([]*Envelope{{Context: request.Context, Event: request.Event}}, request.batch...)
+	for i, envelope := range envelopes {
+		if err := envelope.Validate(); err != nil {
+			return nil, err
+		}
+		envelope, err := envelope.clone()
+		if err != nil {
+			return nil, err
+		}
+		for _, hook := range c.BeforeSend {
+			if err := hook(ctx, envelope); err != nil {
+				return nil, err
 			}
-			directives <- response.Directive
 		}
-	}()
-	return directives, nil
+		if err := envelope.Validate(); err != nil {
+			return nil, err
+		}
+		envelopes[i] = envelope
+	}
+	r := *request
+	r.Context, r.Event, r.batch = envelopes[0].Context, envelopes[0].Event, envelopes[1:]
+	return &r, nil
 }
 
-// Do posts a request to the AVS service's /events endpoint.
-func (c *Client) Do(request *Request) (*Response, error) {
+// Sends the ESP measurements ahead of a Recognize event, if configured. A
+// failure is only logged, since the Recognize event is sent either way.
+func (c *Client) reportEchoSpatialPerception(ctx context.Context, request *Request) {
+	if c.EchoSpatialPerception == nil {
+		return
+	}
+	if _, ok := request.Event.(*Recognize); !ok {
+		return
+	}
+	voiceEnergy, ambientEnergy, ok := c.EchoSpatialPerception()
+	if !ok {
+		return
+	}
+	esp := NewRequest(request.AccessToken)
+	esp.Event = NewReportEchoSpatialPerceptionData(RandomUUIDString(), voiceEnergy, ambientEnergy)
+	if _, err := c.DoContext(ctx, esp); err != nil && c.Logger != nil {
+		c.Logger.Printf("avs: failed to report the ESP measurements: %v", err)
+	}
+}
+
+// Performs a single attempt at posting a request. If stream is true, the
+// response is returned before its body is read.
+func (c *Client) do(ctx context.Context, accessToken string, request *Request, attachments []Attachment, stream bool) (*Response, error) {
+	if c.RateLimiter != nil && !isUserInitiated(request.Event) && !precedesRecognize(request.Event) {
+		if err := c.RateLimiter.WaitContext(ctx); err != nil {
+			return nil, err
+		}
+	}
 	body, bodyIn := io.Pipe()
-	writer := multipart.NewWriter(bodyIn)
+	writer := multipart2.NewWriter(bodyIn)
 	go func() {
 		// Write to pipe must be parallel to allow HTTP request to read
-		err := writeJSON(writer, "metadata", request)
+		err := c.writeMetadata(writer, request)
+		for _, envelope := range request.batch {
+			if err == nil {
+				err = c.writeMetadata(writer, envelope)
+			}
+		}
 		if err != nil {
 			bodyIn.CloseWithError(err)
 			return
 		}
-		if request.Audio != nil {
-			p, err := writer.CreateFormFile("audio", "audio.wav")
-			if err != nil {
-				bodyIn.CloseWithError(err)
-				return
-			}
-			// Run io.Copy in goroutine so audio can be streamed
-			_, err = io.Copy(p, request.Audio)
-			if err != nil {
+		for _, a := range attachments {
+			if err := writeAttachment(writer, a); err != nil {
 				bodyIn.CloseWithError(err)
 				return
 			}
@@ -117,98 +496,182 @@ func (c *Client) Do(request *Request) (*Response, error) {
 		bodyIn.Close()
 	}()
 	// Send the request to AVS.
-	req, err := http.NewRequest("POST", c.EndpointURL+EventsPath, body)
+	sent := &countingReader{r: body}
+	req, err := c.newRequest("POST", EventsPath, accessToken, sent)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", request.AccessToken))
+	req = req.WithContext(ctx)
 	req.Header.Add("Content-Type", writer.FormDataContentType())
-	http2Client := &http.Client{Transport: tr}
+	if c.RetryPolicy != nil && c.RetryPolicy.IdempotencyKeyHeader != "" {
+		req.Header.Set(c.RetryPolicy.IdempotencyKeyHeader, IdempotencyKey(request))
+	}
+	http2Client := c.httpClient()
+	clock := clockOrDefault(c.Clock)
+	started := clock.Now()
 	resp, err := http2Client.Do(req)
 	if err != nil {
+		if sent.count() > 0 && ctx.Err() == nil {
+			err = withKind(ErrNotConnected, &IndeterminateError{
+				Request:        request,
+				Idempotent:     c.IsIdempotent(request),
+				IdempotencyKey: IdempotencyKey(request),
+				Err:            err,
+			})
+		} else {
+			err = notConnected(err)
+		}
+		c.health.requestDone(err)
 		return nil, err
 	}
-	defer resp.Body.Close()
-	more, err := checkStatusCode(resp)
+	more, err := checkStatusCode(resp, c.Limits.resolve().MaxDirectiveSize)
+	if err == nil {
+		c.health.eventSucceeded(clock.Now())
+	} else {
+		c.health.requestDone(err)
+	}
+	if c.RateLimiter != nil {
+		if d, ok := throttleDelay(resp, err); ok {
+			c.RateLimiter.Throttle(d)
+		}
+	}
+	if err != nil || !more {
+		resp.Body.Close()
+	}
 	if err != nil {
 		return nil, err
 	}
 	response := &Response{
 		RequestId:  resp.Header.Get("x-amzn-requestid"),
+		StatusCode: resp.StatusCode,
+		Started:    started,
 		Directives: []*Message{},
 		Content:    map[string][]byte{},
+		clock:      clock,
+		metrics:    c.Metrics,
+		processed:  &c.processed,
 	}
 	if !more {
 		// AVS returned an empty response, so there's nothing to parse.
+		response.Finished = clock.Now()
 		return response, nil
 	}
 	// Parse the multipart response.
-	mr, err := newMultipartReaderFromResponse(resp)
+	limits := c.Limits.resolve()
+	mr, err := newMultipartReaderFromResponse(resp, limits)
 	if err != nil {
+		resp.Body.Close()
+		return nil, withKind(ErrInvalidMessage, err)
+	}
+	if stream {
+		response.stream = &responseStream{body: resp.Body, mr: mr, threshold: c.StreamingThreshold, limits: limits, clock: clock}
+		return response, nil
+	}
+	defer resp.Body.Close()
+	if err := readResponse(mr, response, c.StreamingThreshold, limits); err != nil {
 		return nil, err
 	}
+	response.Finished = clock.Now()
+	return response, nil
+}
+
+// Writes the metadata part of a request, encoded with the codec and
+// compressed if the client is set to.
+func (c *Client) writeMetadata(writer *multipart2.Writer, metadata interface{}) error {
+	data, err := codec().Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	if !c.CompressMetadata {
+		return writer.WriteRawJSON(metadataFieldName, data)
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	c.Metrics.metadataCompressed(len(data), compressed.Len())
+	p, err := writer.CreateFormPart(metadataFieldName, "application/json; charset=UTF-8", textproto.MIMEHeader{"Content-Encoding": {"gzip"}})
+	if err != nil {
+		return err
+	}
+	_, err = p.Write(compressed.Bytes())
+	return err
+}
+
+// Reads the directives and attachments of a multipart response.
+func readResponse(mr *multipart2.Reader, response *Response, threshold int, limits Limits) error {
 	for {
 		p, err := mr.NextPart()
 		if err == io.EOF {
-			break
+			return nil
 		}
 		if err != nil {
-			return nil, err
+			return err
 		}
-		mediatype, _, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
-		if err != nil {
-			return nil, err
+		if _, err := readResponsePart(p, response, threshold, limits); err != nil {
+			return err
+		}
+	}
+}
+
+// Adds a part of a multipart response to the directives or the attachments of
+// the response. It returns the directive, if the part is one. The limits must
+// be resolved.
+func readResponsePart(p *multipart2.Part, response *Response, threshold int, limits Limits) (*Message, error) {
+	if contentId := p.Header.Get("Content-ID"); contentId != "" {
+		// This part is a referencable piece of content.
+		p.SetLimit(int64(limits.MaxAttachmentSize))
+		id := string(ContentIdFromMIMEHeader(contentId))
+		var data []byte
+		var err error
+		if response.streams != nil {
+			data, err = response.readAttachment(id, p)
+		} else {
+			data, err = p.ReadAll()
 		}
-		data, err := ioutil.ReadAll(p)
 		if err != nil {
 			return nil, err
 		}
-		if contentId := p.Header.Get("Content-ID"); contentId != "" {
-			// This part is a referencable piece of content.
-			// XXX: Content-ID generally always has angle brackets, but there may be corner cases?
-			response.Content[contentId[1:len(contentId)-1]] = data
-		} else if mediatype == "application/json" {
-			// This is a directive.
-			var resp responsePart
-			err = json.Unmarshal(data, &resp)
-			if err != nil {
-				return nil, err
-			}
-			if resp.Directive == nil {
-				return nil, fmt.Errorf("missing directive %s", string(data))
-			}
-			response.Directives = append(response.Directives, resp.Directive)
-		} else {
-			return nil, fmt.Errorf("unhandled part %s", p)
-		}
+		response.Content[id] = data
+		return nil, nil
 	}
-	return response, nil
+	// This is a directive.
+	directive, err := readDirectivePart(p, threshold, limits)
+	if err != nil {
+		return nil, err
+	}
+	if directive == nil {
+		return nil, withKind(ErrInvalidMessage, fmt.Errorf("avs: missing directive in part %v", p.Header))
+	}
+	directive.received = clockOrDefault(response.clock).Now()
+	directive.source = DirectiveSourceEventResponse
+	if !response.Started.IsZero() {
+		response.metrics.directiveLatency(directive, directive.received.Sub(response.Started))
+	}
+	response.Directives = append(response.Directives, directive)
+	response.processed.observe(directive)
+	return directive, nil
 }
 
 // Ping will ping AVS on behalf of a user to indicate that the connection is
 // still alive.
+//
+// Ping is a wrapper around PingContext with a background context.
 func (c *Client) Ping(accessToken string) error {
-	// TODO: Once Go supports sending PING frames, that would be a better alternative.
-	req, err := http.NewRequest("GET", c.EndpointURL+PingPath, nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-	http2Client := &http.Client{Transport: tr}
-	resp, err := http2Client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	_, err = checkStatusCode(resp)
-	return err
+	return c.PingContext(context.Background(), accessToken)
 }
 
 // Checks the status code of the response and returns whether the caller should
-// expect there to be more content, as well as any error.
+// expect there to be more content, as well as any error. The body of an error
+// is read up to the limit in bytes, if it's positive, beyond which a
+// PartTooLargeError is returned.
 //
 // This function should only be called before the body has been read.
-func checkStatusCode(resp *http.Response) (more bool, err error) {
+func checkStatusCode(resp *http.Response, limit int) (more bool, err error) {
 	switch resp.StatusCode {
 	case 200:
 		// Keep going.
@@ -218,13 +681,29 @@ func checkStatusCode(resp *http.Response) (more bool, err error) {
 		return false, nil
 	default:
 		// Attempt to parse the response as a System.Exception message.
-		data, _ := ioutil.ReadAll(resp.Body)
+		var body io.Reader = resp.Body
+		if limit > 0 {
+			body = io.LimitReader(resp.Body, int64(limit)+1)
+		}
+		data, _ := ioutil.ReadAll(body)
+		if limit > 0 && len(data) > limit {
+			return false, &multipart2.PartTooLargeError{Header: textproto.MIMEHeader(resp.Header), What: "body", Limit: int64(limit)}
+		}
+		requestId := resp.Header.Get("x-amzn-requestid")
 		var exception Exception
-		json.Unmarshal(data, &exception)
+		codec().Unmarshal(data, &exception)
 		if exception.Payload.Code != "" {
+			exception.StatusCode = resp.StatusCode
+			exception.RequestId = requestId
+			exception.RetryAfter = retryAfter(resp.Header.Get("Retry-After"), 0)
 			return false, &exception
 		}
 		// Fallback error.
-		return false, fmt.Errorf("request failed with %s", resp.Status)
+		return false, &RequestError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			RequestId:  requestId,
+			RetryAfter: retryAfter(resp.Header.Get("Retry-After"), 0),
+		}
 	}
 }
@@ -0,0 +1,265 @@
+package avs
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClientHeaders(t *testing.T) {
+	var mu sync.Mutex
+	headers := map[string]http.Header{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers[r.URL.Path] = r.Header
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := &Client{EndpointURL: server.URL, UserAgent: "TestProduct/1.0"}
+	if err := client.SetHeader("x-my-device-serial", "SN123"); err != nil {
+		t.Fatal(err)
+	}
+	request := NewRequest("token")
+	request.Event = NewSynchronizeState("m1")
+	if _, err := client.Do(request); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping("token"); err != nil {
+		t.Fatal(err)
+	}
+	// The server doesn't keep a downchannel open, but the request is made.
+	client.OpenDownchannel("token")
+
+	for _, path := range []string{EventsPath, PingPath, DirectivesPath} {
+		h := headers[path]
+		if h == nil {
+			t.Errorf("%s: no request", path)
+			continue
+		}
+		if got := h.Get("User-Agent"); got != "TestProduct/1.0" {
+			t.Errorf("%s: got User-Agent %q", path, got)
+		}
+		if got := h.Get("X-My-Device-Serial"); got != "SN123" {
+			t.Errorf("%s: got X-My-Device-Serial %q", path, got)
+		}
+		if got := h.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("%s: got Authorization %q", path, got)
+		}
+	}
+
+	client.UserAgent = ""
+	client.Ping("token")
+	if got := headers[PingPath].Get("User-Agent"); got != DefaultUserAgent {
+		t.Errorf("got User-Agent %q; want %q", got, DefaultUserAgent)
+	}
+
+	// The headers aren't sent to other hosts (e.g., Login with Amazon).
+	auth := &CBLAuthorizer{AuthURL: server.URL + "/lwa", ClientId: "c", ProductId: "p", DeviceSerialNumber: "s", Client: client}
+	auth.RequestCodePair(context.Background())
+	mu.Lock()
+	defer mu.Unlock()
+	if h := headers["/lwa"+CodePairPath]; h == nil || h.Get("X-My-Device-Serial") != "" {
+		t.Errorf("got headers %v for Login with Amazon; want a request without X-My-Device-Serial", h)
+	}
+}
+
+func TestSetHeaderReserved(t *testing.T) {
+	client := new(Client)
+	for _, key := range []string{"Authorization", "content-type", "User-Agent", "Transfer-Encoding"} {
+		if err := client.SetHeader(key, "x"); err == nil {
+			t.Errorf("SetHeader(%q) should fail", key)
+		}
+	}
+}
+
+func TestBeforeSend(t *testing.T) {
+	var metadata []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			t.Errorf("unexpected request: %v", err)
+			return
+		}
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			data, _ := ioutil.ReadAll(p)
+			metadata = append(metadata, string(data))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	var order []string
+	client := &Client{EndpointURL: server.URL}
+	client.BeforeSend = []BeforeSendHook{
+		func(ctx context.Context, envelope *Envelope) error {
+			order = append(order, "prefix")
+			m := envelope.Event.GetMessage()
+			m.Header["messageId"] = "device1-" + m.Header["messageId"]
+			envelope.Context = append(envelope.Context, &Message{
+				Header:  map[string]string{"namespace": "Fleet", "name": "Tag"},
+				Payload: json.RawMessage(`{"ring":"beta"}`),
+			})
+			return nil
+		},
+		func(ctx context.Context, envelope *Envelope) error {
+			order = append(order, "check")
+			if id := envelope.Event.GetMessage().Header["messageId"]; !strings.HasPrefix(id, "device1-") {
+				t.Errorf("the hooks should run in order, got message id %s", id)
+			}
+			return nil
+		},
+	}
+	event := NewPlaybackStarted("m1", "t", 0)
+	request := NewRequest("token")
+	request.Event = event
+	request.AddContext(NewVolumeState(50, false))
+	if _, err := client.Do(request); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"context":[{"header":{"name":"VolumeState","namespace":"Speaker"},"payload":{"volume":50,"muted":false}},` +
+		`{"header":{"name":"Tag","namespace":"Fleet"},"payload":{"ring":"beta"}}],` +
+		`"event":{"header":{"messageId":"device1-m1","name":"PlaybackStarted","namespace":"AudioPlayer"},"payload":{"token":"t","offsetInMilliseconds":0}}}`
+	if len(metadata) != 1 || strings.TrimSpace(metadata[0]) != want {
+		t.Errorf("got metadata %q; want %s", metadata, want)
+	}
+	if event.Header["messageId"] != "m1" || len(request.Context) != 1 {
+		t.Error("the hooks shouldn't change the request")
+	}
+	if strings.Join(order, " ") != "prefix check" {
+		t.Errorf("got hooks %v", order)
+	}
+
+	// A hook may abort the request, and can't make it invalid.
+	abort := errors.New("abort")
+	client.BeforeSend = []BeforeSendHook{func(ctx context.Context, envelope *Envelope) error { return abort }}
+	if _, err := client.Do(request); err != abort {
+		t.Errorf("got %v; want the hook's error", err)
+	}
+	client.BeforeSend = []BeforeSendHook{func(ctx context.Context, envelope *Envelope) error {
+		envelope.Context = append(envelope.Context, envelope.Context[0])
+		return nil
+	}}
+	if _, err := client.Do(request); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("got %v; want ErrInvalidMessage", err)
+	}
+	request.Event = nil
+	if _, err := client.Do(request); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("got %v; want ErrInvalidMessage", err)
+	}
+	if len(metadata) != 1 {
+		t.Errorf("got %d requests; want 1", len(metadata))
+	}
+}
+
+func TestDialContextTLS(t *testing.T) {
+	server := newGoAwayServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+	roots := tr.TLSClientConfig.RootCAs
+	tr.TLSClientConfig.RootCAs = server.roots
+	defer func() {
+		tr.TLSClientConfig.RootCAs = roots
+	}()
+
+	// The dialer reaches the server through another address, while TLS is
+	// still verified for the endpoint and negotiates HTTP/2.
+	var dials int32
+	client := &Client{
+		EndpointURL: "https://127.0.0.1:1",
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return new(net.Dialer).DialContext(ctx, network, server.ln.Addr().String())
+		},
+	}
+	if err := client.Ping("token"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Errorf("dialed %d times; want 1", n)
+	}
+	if err := (&Client{Transport: tr, DialContext: client.DialContext, EndpointURL: DefaultEndpointURL}).Validate(); err == nil {
+		t.Error("expected an error for a client with both a transport and a dialer")
+	}
+}
+
+func TestCompressMetadata(t *testing.T) {
+	var got *Request
+	var audio string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			switch p.FormName() {
+			case metadataFieldName:
+				if enc := p.Header.Get("Content-Encoding"); enc != "gzip" {
+					t.Errorf("got metadata encoding %q; want gzip", enc)
+				}
+				zr, err := gzip.NewReader(p)
+				if err != nil {
+					t.Error(err)
+					break
+				}
+				got, err = ParseEnvelope(zr)
+				if err != nil {
+					t.Error(err)
+				}
+			case "audio":
+				if enc := p.Header.Get("Content-Encoding"); enc != "" {
+					t.Errorf("got audio encoding %q", enc)
+				}
+				data, _ := ioutil.ReadAll(p)
+				audio = string(data)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	var uncompressed, compressed int
+	client := &Client{
+		EndpointURL:      server.URL,
+		CompressMetadata: true,
+		Metrics: &Metrics{MetadataCompressed: func(before, after int) {
+			uncompressed, compressed = before, after
+		}},
+	}
+	request := NewRequest("token")
+	request.Event = NewRecognize("m1", "d1")
+	request.Context = DefaultContexts()
+	request.Audio = strings.NewReader("audio data")
+	if _, err := client.Do(request); err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Event.GetMessage().Type() != TypeRecognize || len(got.Context) != len(request.Context) {
+		t.Fatalf("got request %+v", got)
+	}
+	if audio != "audio data" {
+		t.Errorf("got audio %q", audio)
+	}
+	if compressed == 0 || compressed >= uncompressed {
+		t.Errorf("got %d bytes compressed from %d", compressed, uncompressed)
+	}
+}
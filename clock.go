@@ -0,0 +1,90 @@
+package avs
+
+import (
+	"context"
+	"time"
+)
+
+// Clock provides the current time and timers to the time-dependent components
+// of the package. It can be replaced to make tests deterministic (see the
+// avstest package).
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is the Clock equivalent of time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+// Ticker is the Clock equivalent of time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// RealClock is the Clock that uses the system time.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// Returns the clock, or RealClock if it's nil.
+func clockOrDefault(c Clock) Clock {
+	if c == nil {
+		return RealClock
+	}
+	return c
+}
+
+// Blocks for the duration d according to the clock.
+func sleep(c Clock, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	<-clockOrDefault(c).After(d)
+}
+
+// Blocks for the duration d according to the clock, or until the context is
+// done, in which case it returns the context's error. If the deadline of the
+// context is less than d away, it returns context.DeadlineExceeded without
+// waiting.
+func sleepContext(ctx context.Context, c Clock, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if d <= 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return context.DeadlineExceeded
+	}
+	timer := clockOrDefault(c).NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
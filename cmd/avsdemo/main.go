@@ -0,0 +1,148 @@
+// Command avsdemo runs a voice interaction with AVS from a recording. It
+// links the device with code-based linking if no access token is provided,
+// opens a downchannel, sends the recording in a Recognize event and writes
+// the speech and the attached audio of the response to a file.
+//
+// Usage:
+//
+//	avsdemo -client-id ID -product-id ID -audio request.raw -out response.mp3
+//	avsdemo -token TOKEN < request.raw > response.mp3
+//
+// The recording must be 16 kHz, 16-bit mono PCM. Remote audio streams are
+// skipped.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/fika-io/go-avs"
+)
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "avsdemo:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("avsdemo", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	endpoint := flags.String("endpoint", avs.DefaultEndpointURL, "base URL of AVS")
+	accessToken := flags.String("token", "", "access token; if empty, the device is linked with code-based linking")
+	auth := new(avs.CBLAuthorizer)
+	flags.StringVar(&auth.AuthURL, "auth-url", avs.DefaultAuthURL, "base URL of Login with Amazon")
+	flags.StringVar(&auth.ClientId, "client-id", "", "client id of the security profile")
+	flags.StringVar(&auth.ProductId, "product-id", "", "product id of the device")
+	flags.StringVar(&auth.DeviceSerialNumber, "serial", "avsdemo", "serial number of the device")
+	audioPath := flags.String("audio", "-", "recording to send (16 kHz, 16-bit mono PCM); - for stdin")
+	outPath := flags.String("out", "-", "file to write the audio of the response to; - for stdout")
+	storeDir := flags.String("store", "", "directory to keep the refresh token and playback state in")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	logger := log.New(stderr, "", log.LstdFlags)
+
+	client, err := avs.NewClient(
+		avs.WithEndpointURL(*endpoint),
+		avs.WithUserAgent("avsdemo/"+avs.LibraryVersion),
+		avs.WithRetryPolicy(&avs.RetryPolicy{Actions: map[avs.ExceptionCode]avs.RetryAction{
+			avs.ExceptionCodeInternalService: {Retries: 2, Backoff: time.Second, Jitter: true},
+		}}),
+	)
+	if err != nil {
+		return err
+	}
+	var store avs.Store
+	if *storeDir != "" {
+		if store, err = avs.NewFileStore(*storeDir, nil); err != nil {
+			return err
+		}
+	}
+	token := *accessToken
+	if token == "" {
+		auth.Client, auth.Logger = client, logger
+		linked, err := auth.Authorize(ctx, store, func(pair *avs.CodePair) {
+			fmt.Fprintf(stderr, "Go to %s and enter the code %s\n", pair.VerificationURI, pair.UserCode)
+		})
+		if err != nil {
+			return err
+		}
+		token = linked.AccessToken
+	}
+	mic, err := openInput(*audioPath, stdin)
+	if err != nil {
+		return err
+	}
+	defer mic.Close()
+	out, err := openOutput(*outPath, stdout)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	player := &filePlayer{w: out}
+	device, err := avs.NewDevice(avs.DeviceConfig{
+		Client: client, Tokens: avs.StaticTokenSource(token), Store: store, Logger: logger, Player: player, Sink: player,
+	})
+	if err != nil {
+		return err
+	}
+	device.Dialog.FallbackHandler = func(ctx context.Context, reason avs.FallbackReason, err error, sink avs.AudioSink) {
+		logger.Printf("can't reach AVS (%s): %v", reason, err)
+	}
+	device.Dispatcher.UnknownDirectives = avs.UnknownDirectiveLog
+	if err := device.Start(ctx); err != nil {
+		return err
+	}
+	// Send the recording and play the response, with the audio items that
+	// are attached to it.
+	result, err := device.Recognize(ctx, mic)
+	if err == nil {
+		logger.Printf("response %s", result.Response)
+		err = device.PlayResult(ctx, result)
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if stopErr := device.Stop(shutdownCtx); err == nil {
+		err = stopErr
+	}
+	return err
+}
+
+func openInput(path string, stdin io.Reader) (io.ReadCloser, error) {
+	if path == "-" {
+		return ioutil.NopCloser(stdin), nil
+	}
+	return os.Open(path)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func openOutput(path string, stdout io.Writer) (io.WriteCloser, error) {
+	if path == "-" {
+		return nopWriteCloser{stdout}, nil
+	}
+	return os.Create(path)
+}
+
+// filePlayer "plays" audio by writing it to a file, one clip after the other.
+type filePlayer struct{ w io.Writer }
+
+func (p *filePlayer) PlaySpeech(ctx context.Context, speak *avs.Speak, audio io.Reader) error {
+	return p.PlayAudio(ctx, audio)
+}
+
+func (p *filePlayer) PlayAudio(ctx context.Context, audio io.Reader) error {
+	_, err := io.Copy(p.w, audio)
+	return err
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/fika-io/go-avs"
+	"github.com/fika-io/go-avs/avstest"
+)
+
+func TestRun(t *testing.T) {
+	server := avstest.NewServer()
+	defer server.Close()
+	var stdout, stderr bytes.Buffer
+	args := []string{"-endpoint", server.URL, "-token", "token"}
+	if err := run(context.Background(), args, strings.NewReader("audio"), &stdout, &stderr); err != nil {
+		t.Fatalf("%v\n%s", err, stderr.String())
+	}
+	var types []avs.MessageType
+	for _, r := range server.Requests() {
+		if r.AccessToken != "token" {
+			t.Errorf("got access token %q; want token", r.AccessToken)
+		}
+		types = append(types, r.Event.GetMessage().Type())
+	}
+	if len(types) != 2 || types[0] != avs.TypeSynchronizeState || types[1] != avs.TypeRecognize {
+		t.Errorf("got events %v; want SynchronizeState and Recognize", types)
+	}
+}
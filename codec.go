@@ -0,0 +1,65 @@
+package avs
+
+import (
+	"encoding/json"
+	"io"
+	"sync/atomic"
+)
+
+// JSONCodec encodes and decodes the JSON of messages, so that a faster
+// implementation than encoding/json can be used. It must behave like
+// encoding/json: json.RawMessage values are kept byte for byte, numbers are
+// decoded into interface{} values as json.Number by decoders set to
+// UseNumber, fields are matched in any order and the output of Marshal is
+// the same. avstest.CheckJSONCodec checks these.
+//
+// Messages are still split into their header and payload with encoding/json
+// when they're decoded while being read (see Client.StreamingThreshold),
+// and the MarshalJSON methods of messages use encoding/json.
+type JSONCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	NewDecoder(r io.Reader) JSONDecoder
+}
+
+// JSONDecoder reads and decodes JSON values from a stream, like
+// json.Decoder.
+type JSONDecoder interface {
+	Decode(v interface{}) error
+	More() bool
+	UseNumber()
+}
+
+// DefaultJSONCodec is the JSONCodec of encoding/json.
+var DefaultJSONCodec JSONCodec = encodingJSON{}
+
+type encodingJSON struct{}
+
+func (encodingJSON) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (encodingJSON) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (encodingJSON) NewDecoder(r io.Reader) JSONDecoder         { return json.NewDecoder(r) }
+
+// The codec set with SetJSONCodec, in a struct since atomic.Value needs a
+// consistent concrete type.
+type codecValue struct{ JSONCodec }
+
+var jsonCodec atomic.Value
+
+// SetJSONCodec replaces encoding/json for the messages of the package. A nil
+// codec restores DefaultJSONCodec. It should be called before the package is
+// used; messages being encoded or decoded meanwhile may use either codec. The
+// codec is shared by all the clients of the process.
+func SetJSONCodec(c JSONCodec) {
+	if c == nil {
+		c = DefaultJSONCodec
+	}
+	jsonCodec.Store(codecValue{c})
+}
+
+// Returns the codec set with SetJSONCodec.
+func codec() JSONCodec {
+	if c, ok := jsonCodec.Load().(codecValue); ok {
+		return c.JSONCodec
+	}
+	return DefaultJSONCodec
+}
@@ -0,0 +1,62 @@
+package avs
+
+import (
+	"encoding/json"
+	"io"
+	"sync/atomic"
+	"testing"
+)
+
+// A JSONCodec that counts its calls.
+type countingCodec struct {
+	marshals, unmarshals, decoders int32
+}
+
+func (c *countingCodec) Marshal(v interface{}) ([]byte, error) {
+	atomic.AddInt32(&c.marshals, 1)
+	return DefaultJSONCodec.Marshal(v)
+}
+
+func (c *countingCodec) Unmarshal(data []byte, v interface{}) error {
+	atomic.AddInt32(&c.unmarshals, 1)
+	return DefaultJSONCodec.Unmarshal(data, v)
+}
+
+func (c *countingCodec) NewDecoder(r io.Reader) JSONDecoder {
+	atomic.AddInt32(&c.decoders, 1)
+	return DefaultJSONCodec.NewDecoder(r)
+}
+
+func TestSetJSONCodec(t *testing.T) {
+	c := new(countingCodec)
+	SetJSONCodec(c)
+	defer SetJSONCodec(nil)
+
+	server, names := newEventServer(t)
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL}
+	request := NewRequest("token")
+	request.Event = NewSynchronizeState("m1")
+	if _, err := client.Do(request); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(); len(got) != 1 || got[0] != "SynchronizeState" {
+		t.Errorf("server got events %v", got)
+	}
+	if atomic.LoadInt32(&c.marshals) != 1 {
+		t.Errorf("the metadata was encoded %d times with the codec; want 1", c.marshals)
+	}
+
+	m := &Message{Header: map[string]string{"namespace": "SpeechSynthesizer", "name": "Speak", "messageId": "m1"}, Payload: json.RawMessage(`{"token":"t1"}`)}
+	if speak, ok := m.Typed().(*Speak); !ok || speak.Payload.Token != "t1" {
+		t.Errorf("Typed returned %#v", m.Typed())
+	}
+	if atomic.LoadInt32(&c.decoders) == 0 {
+		t.Error("Typed didn't decode the payload with the codec")
+	}
+
+	SetJSONCodec(nil)
+	if codec() != DefaultJSONCodec {
+		t.Error("SetJSONCodec(nil) didn't restore the default codec")
+	}
+}
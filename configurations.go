@@ -0,0 +1,308 @@
+package avs
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The names of the interfaces that can be declared in a DeviceProfile.
+const (
+	InterfaceAlerts              = "Alerts"
+	InterfaceAlexa               = "Alexa"
+	InterfaceApiGateway          = "Alexa.ApiGateway"
+	InterfaceAudioPlayer         = "AudioPlayer"
+	InterfaceBluetooth           = "Bluetooth"
+	InterfaceEqualizerController = "EqualizerController"
+	InterfaceNotifications       = "Notifications"
+	InterfacePlaybackController  = "PlaybackController"
+	InterfaceSettings            = "Settings"
+	InterfaceSpeaker             = "Speaker"
+	InterfaceSpeechRecognizer    = "SpeechRecognizer"
+	InterfaceSpeechSynthesizer   = "SpeechSynthesizer"
+	InterfaceSystem              = "System"
+)
+
+// The current versions of the interfaces by name, which the package targets.
+// It's read-only, so it's safely shared by all the clients of a process.
+var currentVersions = map[string]InterfaceVersion{
+	InterfaceAlerts:              {1, 3},
+	InterfaceAlexa:               {3, 0},
+	InterfaceApiGateway:          {1, 0},
+	InterfaceAudioPlayer:         {1, 4},
+	InterfaceBluetooth:           {2, 0},
+	InterfaceEqualizerController: {1, 0},
+	InterfaceNotifications:       {1, 0},
+	InterfacePlaybackController:  {1, 1},
+	InterfaceSettings:            {1, 0},
+	InterfaceSpeaker:             {1, 0},
+	InterfaceSpeechRecognizer:    {2, 1},
+	InterfaceSpeechSynthesizer:   {1, 0},
+	InterfaceSystem:              {1, 0},
+}
+
+// CurrentVersion returns the current version of the interface (e.g.,
+// InterfaceAudioPlayer), and false if the package doesn't know it.
+func CurrentVersion(iface string) (InterfaceVersion, bool) {
+	v, ok := currentVersions[iface]
+	return v, ok
+}
+
+// NewCurrentCapability returns the capability for the current version of the
+// interface, which is 0.0 if the package doesn't know it.
+func NewCurrentCapability(iface string) Capability {
+	v, _ := CurrentVersion(iface)
+	return NewCapabilityVersion(iface, v)
+}
+
+// CapabilityConfigurations is the configurations object of a capability, for
+// the interfaces that need one.
+type CapabilityConfigurations interface {
+	// Interface returns the interface that the configurations are for.
+	Interface() string
+	// Validate returns an error if the configurations would be rejected.
+	Validate() error
+}
+
+// RawConfigurations holds the configurations of an interface that this
+// package doesn't have a type for, as JSON.
+type RawConfigurations struct {
+	For  string
+	JSON json.RawMessage
+}
+
+// Interface returns the interface that the configurations are for.
+func (c *RawConfigurations) Interface() string {
+	return c.For
+}
+
+// Validate checks that the configurations are a JSON object.
+func (c *RawConfigurations) Validate() error {
+	var v map[string]json.RawMessage
+	if err := json.Unmarshal(c.JSON, &v); err != nil || v == nil {
+		return fmt.Errorf("avs: %s configurations aren't a JSON object", c.For)
+	}
+	return nil
+}
+
+// MarshalJSON returns the JSON of the configurations.
+func (c *RawConfigurations) MarshalJSON() ([]byte, error) {
+	return c.JSON, nil
+}
+
+// WakeWordScope is the default wake word scope.
+const WakeWordScope = "DEFAULT"
+
+// WakeWords is a set of wake words (e.g., "ALEXA") for the scopes in which
+// they're recognized.
+type WakeWords struct {
+	Scopes []string   `json:"scopes"`
+	Values [][]string `json:"values"`
+}
+
+// SpeechRecognizerConfigurations declares the wake words of a device.
+type SpeechRecognizerConfigurations struct {
+	WakeWords []WakeWords `json:"wakeWords"`
+}
+
+// NewSpeechRecognizerConfigurations returns configurations with the wake words
+// in the default scope.
+func NewSpeechRecognizerConfigurations(wakeWords ...string) *SpeechRecognizerConfigurations {
+	values := make([][]string, len(wakeWords))
+	for i, w := range wakeWords {
+		values[i] = []string{w}
+	}
+	return &SpeechRecognizerConfigurations{
+		WakeWords: []WakeWords{{Scopes: []string{WakeWordScope}, Values: values}},
+	}
+}
+
+// NewSpeechRecognizerCapability returns the SpeechRecognizer capability of
+// the current version, with the wake words in the default scope.
+func NewSpeechRecognizerCapability(wakeWords ...string) Capability {
+	return NewCurrentCapability(InterfaceSpeechRecognizer).
+		WithConfigurations(NewSpeechRecognizerConfigurations(wakeWords...))
+}
+
+// Supports reports whether the wake word is declared in any scope.
+func (c *SpeechRecognizerConfigurations) Supports(wakeWord string) bool {
+	for _, w := range c.WakeWords {
+		for _, v := range w.Values {
+			for _, value := range v {
+				if value == wakeWord {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Interface returns InterfaceSpeechRecognizer.
+func (c *SpeechRecognizerConfigurations) Interface() string {
+	return InterfaceSpeechRecognizer
+}
+
+// Validate checks that there is at least one wake word, and that every set
+// of wake words has scopes.
+func (c *SpeechRecognizerConfigurations) Validate() error {
+	if len(c.WakeWords) == 0 {
+		return fmt.Errorf("avs: SpeechRecognizer configurations without wake words")
+	}
+	for _, w := range c.WakeWords {
+		if len(w.Scopes) == 0 {
+			return fmt.Errorf("avs: SpeechRecognizer wake words without a scope")
+		}
+		if len(w.Values) == 0 {
+			return fmt.Errorf("avs: SpeechRecognizer wake words without values")
+		}
+		for _, v := range w.Values {
+			if len(v) == 0 || v[0] == "" {
+				return fmt.Errorf("avs: empty SpeechRecognizer wake word")
+			}
+		}
+	}
+	return nil
+}
+
+// EqualizerBand is a band of an equalizer.
+type EqualizerBand string
+
+// Possible values for EqualizerBand.
+const (
+	EqualizerBandBass     = EqualizerBand("BASS")
+	EqualizerBandMidrange = EqualizerBand("MIDRANGE")
+	EqualizerBandTreble   = EqualizerBand("TREBLE")
+)
+
+// EqualizerControllerConfigurations declares the bands and modes of an
+// equalizer.
+type EqualizerControllerConfigurations struct {
+	Bands struct {
+		Supported []EqualizerBandName `json:"supported"`
+		Range     struct {
+			Minimum int `json:"minimum"`
+			Maximum int `json:"maximum"`
+		} `json:"range"`
+	} `json:"bands"`
+	Modes *struct {
+		Supported []EqualizerModeName `json:"supported"`
+	} `json:"modes,omitempty"`
+	DefaultState struct {
+		Bands []EqualizerBandLevel `json:"bands"`
+		Mode  string               `json:"mode,omitempty"`
+	} `json:"defaultState"`
+}
+
+// EqualizerBandName names a supported band.
+type EqualizerBandName struct {
+	Name EqualizerBand `json:"name"`
+}
+
+// EqualizerModeName names a supported mode (e.g., "MOVIE").
+type EqualizerModeName struct {
+	Name string `json:"name"`
+}
+
+// EqualizerBandLevel is the level of a band.
+type EqualizerBandLevel struct {
+	Name  EqualizerBand `json:"name"`
+	Level int           `json:"level"`
+}
+
+// NewEqualizerControllerConfigurations returns configurations for the bands,
+// which range from min to max and default to 0.
+func NewEqualizerControllerConfigurations(min, max int, bands ...EqualizerBand) *EqualizerControllerConfigurations {
+	c := new(EqualizerControllerConfigurations)
+	c.Bands.Range.Minimum = min
+	c.Bands.Range.Maximum = max
+	for _, b := range bands {
+		c.Bands.Supported = append(c.Bands.Supported, EqualizerBandName{b})
+		c.DefaultState.Bands = append(c.DefaultState.Bands, EqualizerBandLevel{b, 0})
+	}
+	return c
+}
+
+// Interface returns InterfaceEqualizerController.
+func (c *EqualizerControllerConfigurations) Interface() string {
+	return InterfaceEqualizerController
+}
+
+// Validate checks that there is at least one band, that the range isn't
+// empty, and that the default state is within it and for supported bands and
+// modes.
+func (c *EqualizerControllerConfigurations) Validate() error {
+	if len(c.Bands.Supported) == 0 {
+		return fmt.Errorf("avs: EqualizerController configurations without bands")
+	}
+	if c.Bands.Range.Minimum > c.Bands.Range.Maximum {
+		return fmt.Errorf("avs: EqualizerController range %d to %d is empty", c.Bands.Range.Minimum, c.Bands.Range.Maximum)
+	}
+	supported := make(map[EqualizerBand]bool)
+	for _, b := range c.Bands.Supported {
+		supported[b.Name] = true
+	}
+	for _, b := range c.DefaultState.Bands {
+		if !supported[b.Name] {
+			return fmt.Errorf("avs: EqualizerController default state for unsupported band %s", b.Name)
+		}
+		if b.Level < c.Bands.Range.Minimum || b.Level > c.Bands.Range.Maximum {
+			return fmt.Errorf("avs: EqualizerController default level %d of %s is out of range", b.Level, b.Name)
+		}
+	}
+	if mode := c.DefaultState.Mode; mode != "" {
+		found := false
+		if c.Modes != nil {
+			for _, m := range c.Modes.Supported {
+				found = found || m.Name == mode
+			}
+		}
+		if !found {
+			return fmt.Errorf("avs: EqualizerController default mode %s isn't supported", mode)
+		}
+	}
+	return nil
+}
+
+// BluetoothProfile is a Bluetooth profile supported by a device (e.g.,
+// "A2DP_SINK" version "1.2").
+type BluetoothProfile struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// BluetoothConfigurations declares the Bluetooth profiles of a device.
+type BluetoothConfigurations struct {
+	Profiles []BluetoothProfile `json:"profiles"`
+}
+
+// Interface returns InterfaceBluetooth.
+func (c *BluetoothConfigurations) Interface() string {
+	return InterfaceBluetooth
+}
+
+// Validate checks that there is at least one profile, and that every
+// profile has a name and a version.
+func (c *BluetoothConfigurations) Validate() error {
+	if len(c.Profiles) == 0 {
+		return fmt.Errorf("avs: Bluetooth configurations without profiles")
+	}
+	for _, p := range c.Profiles {
+		if p.Name == "" || p.Version == "" {
+			return fmt.Errorf("avs: Bluetooth profile %q without a name or version", p.Name)
+		}
+	}
+	return nil
+}
+
+// Returns empty configurations of the type used for the interface.
+func newConfigurations(iface string) CapabilityConfigurations {
+	switch iface {
+	case InterfaceSpeechRecognizer:
+		return new(SpeechRecognizerConfigurations)
+	case InterfaceEqualizerController:
+		return new(EqualizerControllerConfigurations)
+	case InterfaceBluetooth:
+		return new(BluetoothConfigurations)
+	}
+	return nil
+}
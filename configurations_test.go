@@ -0,0 +1,101 @@
+package avs
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDeviceProfileConfigurations(t *testing.T) {
+	profile := NewDeviceProfile(
+		NewCurrentCapability(InterfaceSpeechRecognizer).
+			WithConfigurations(NewSpeechRecognizerConfigurations("ALEXA")),
+		NewCurrentCapability(InterfaceAudioPlayer),
+		NewCurrentCapability(InterfaceSpeaker),
+	)
+	if err := profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"envelopeVersion":"20160207","capabilities":[` +
+		`{"type":"AlexaInterface","interface":"SpeechRecognizer","version":"2.1","configurations":{"wakeWords":[{"scopes":["DEFAULT"],"values":[["ALEXA"]]}]}},` +
+		`{"type":"AlexaInterface","interface":"AudioPlayer","version":"1.4"},` +
+		`{"type":"AlexaInterface","interface":"Speaker","version":"1.0"}]}`
+	if string(data) != want {
+		t.Errorf("got %s; want %s", data, want)
+	}
+
+	var decoded struct {
+		Capabilities []Capability
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Capabilities, profile.Capabilities) {
+		t.Errorf("got %+v back", decoded.Capabilities)
+	}
+
+	if v, ok := CurrentVersion("Custom"); ok || v != (InterfaceVersion{}) {
+		t.Errorf("got version %s, %t for an unknown interface", v, ok)
+	}
+}
+
+func TestCapabilityConfigurationsValidate(t *testing.T) {
+	equalizer := NewEqualizerControllerConfigurations(-6, 6, EqualizerBandBass, EqualizerBandTreble)
+	badLevel := NewEqualizerControllerConfigurations(-6, 6, EqualizerBandBass)
+	badLevel.DefaultState.Bands[0].Level = 10
+	badMode := NewEqualizerControllerConfigurations(-6, 6, EqualizerBandBass)
+	badMode.DefaultState.Mode = "MOVIE"
+	tests := []struct {
+		capability Capability
+		err        string
+	}{
+		{NewCapability(InterfaceSpeechRecognizer, 2, 0).WithConfigurations(&SpeechRecognizerConfigurations{}), "without wake words"},
+		{NewCapability(InterfaceSpeechRecognizer, 2, 0).WithConfigurations(NewSpeechRecognizerConfigurations("")), "empty SpeechRecognizer wake word"},
+		{NewCapability(InterfaceSpeechRecognizer, 2, 0).WithConfigurations(NewSpeechRecognizerConfigurations()), "without values"},
+		{NewCapability(InterfaceEqualizerController, 1, 0).WithConfigurations(equalizer), ""},
+		{NewCapability(InterfaceEqualizerController, 1, 0).WithConfigurations(NewEqualizerControllerConfigurations(-6, 6)), "without bands"},
+		{NewCapability(InterfaceEqualizerController, 1, 0).WithConfigurations(NewEqualizerControllerConfigurations(6, -6, EqualizerBandBass)), "is empty"},
+		{NewCapability(InterfaceEqualizerController, 1, 0).WithConfigurations(badLevel), "out of range"},
+		{NewCapability(InterfaceEqualizerController, 1, 0).WithConfigurations(badMode), "isn't supported"},
+		{NewCapability(InterfaceBluetooth, 2, 0).WithConfigurations(&BluetoothConfigurations{[]BluetoothProfile{{"A2DP_SINK", "1.2"}}}), ""},
+		{NewCapability(InterfaceBluetooth, 2, 0).WithConfigurations(&BluetoothConfigurations{}), "without profiles"},
+		{NewCapability(InterfaceBluetooth, 2, 0).WithConfigurations(&BluetoothConfigurations{[]BluetoothProfile{{"A2DP_SINK", ""}}}), "without a name or version"},
+		{NewCapability(InterfaceSpeaker, 1, 0).WithConfigurations(equalizer), "EqualizerController configurations on the Speaker capability"},
+		{NewCapability("", 1, 0), "without an interface"},
+	}
+	for _, test := range tests {
+		err := NewDeviceProfile(test.capability).Validate()
+		if test.err == "" && err != nil {
+			t.Errorf("%s: %v", test.capability.Interface, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got %v; want an error containing %q", test.capability.Interface, err, test.err)
+		}
+	}
+	twice := NewDeviceProfile(NewCapability(InterfaceSpeaker, 1, 0), NewCapability(InterfaceSpeaker, 1, 0))
+	if err := twice.Validate(); err == nil {
+		t.Error("an interface can't be declared twice")
+	}
+}
+
+func TestRawConfigurations(t *testing.T) {
+	var c Capability
+	data := `{"type":"AlexaInterface","interface":"Alexa.Display","version":"1.0","configurations":{"display":{"type":"PIXEL"}}}`
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if encoded, _ := json.Marshal(c); string(encoded) != data {
+		t.Errorf("got %s; want %s", encoded, data)
+	}
+	c.Configurations = &RawConfigurations{For: "Alexa.Display", JSON: json.RawMessage(`[]`)}
+	if err := c.Validate(); err == nil {
+		t.Error("configurations must be an object")
+	}
+}
@@ -0,0 +1,52 @@
+package avs
+
+import (
+	"net/url"
+	"strings"
+)
+
+// ContentId identifies an attachment of a multipart message. Directives refer
+// to it with a cid: URL (e.g., "cid:abc@amazon.com"), while the attachment
+// carries it in its Content-ID header (e.g., "<abc@amazon.com>"). The
+// ContentId is the bare, unescaped id, which is also the key of
+// Response.Content.
+type ContentId string
+
+// NewContentId returns a random content id for an outgoing attachment.
+func NewContentId() ContentId {
+	return ContentId(RandomUUIDString())
+}
+
+// ParseCID returns the content id of a cid: URL. It reports false if the URL
+// isn't a cid: URL. The scheme is matched case insensitively, and escaped
+// characters and angle brackets around the id are removed.
+func ParseCID(u string) (ContentId, bool) {
+	if len(u) < 4 || !strings.EqualFold(u[:4], "cid:") {
+		return "", false
+	}
+	id := u[4:]
+	if unescaped, err := url.PathUnescape(id); err == nil {
+		id = unescaped
+	}
+	return ContentIdFromMIMEHeader(id), true
+}
+
+// ContentIdFromMIMEHeader returns the content id of a Content-ID header
+// value. The angle brackets are optional.
+func ContentIdFromMIMEHeader(h string) ContentId {
+	h = strings.TrimSpace(h)
+	if len(h) >= 2 && h[0] == '<' && h[len(h)-1] == '>' {
+		h = h[1 : len(h)-1]
+	}
+	return ContentId(h)
+}
+
+// String returns the cid: URL of the content id.
+func (id ContentId) String() string {
+	return "cid:" + url.PathEscape(string(id))
+}
+
+// MIMEHeader returns the Content-ID header value of the content id.
+func (id ContentId) MIMEHeader() string {
+	return "<" + string(id) + ">"
+}
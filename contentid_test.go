@@ -0,0 +1,61 @@
+package avs
+
+import (
+	"testing"
+)
+
+func TestParseCID(t *testing.T) {
+	tests := []struct {
+		url string
+		id  ContentId
+		ok  bool
+	}{
+		{"cid:abc", "abc", true},
+		{"cid:DeviceAgent_1234@Multimedia_Player", "DeviceAgent_1234@Multimedia_Player", true},
+		{"cid:DeviceAgent_1234%40Multimedia_Player", "DeviceAgent_1234@Multimedia_Player", true},
+		{"CID:abc", "abc", true},
+		{"cid:<abc>", "abc", true},
+		{"cid:100%", "100%", true},
+		{"cid:", "", true},
+		{"https://example.com/song.mp3", "", false},
+		{"cid", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		if id, ok := ParseCID(test.url); id != test.id || ok != test.ok {
+			t.Errorf("ParseCID(%q) = %q, %t; want %q, %t", test.url, id, ok, test.id, test.ok)
+		}
+	}
+}
+
+func TestContentIdFromMIMEHeader(t *testing.T) {
+	for _, h := range []string{"<abc@amazon.com>", "abc@amazon.com", " <abc@amazon.com> "} {
+		if id := ContentIdFromMIMEHeader(h); id != "abc@amazon.com" {
+			t.Errorf("ContentIdFromMIMEHeader(%q) = %q", h, id)
+		}
+	}
+}
+
+func TestContentIdFormat(t *testing.T) {
+	id := ContentId("a b@amazon.com")
+	if s := id.String(); s != "cid:a%20b@amazon.com" {
+		t.Errorf("got URL %q", s)
+	}
+	if parsed, _ := ParseCID(id.String()); parsed != id {
+		t.Errorf("got %q back from the URL", parsed)
+	}
+	if h := id.MIMEHeader(); h != "<a b@amazon.com>" {
+		t.Errorf("got header %q", h)
+	}
+	if a, b := NewContentId(), NewContentId(); a == "" || a == b {
+		t.Errorf("got content ids %q and %q", a, b)
+	}
+
+	speak := new(Speak)
+	speak.Payload.URL = "cid:DeviceAgent_1234%40Multimedia_Player"
+	play := new(Stream)
+	play.URL = speak.Payload.URL
+	if speak.ContentId() != "DeviceAgent_1234@Multimedia_Player" || play.ContentId() != speak.ContentId() {
+		t.Errorf("got %q and %q", speak.ContentId(), play.ContentId())
+	}
+}
@@ -0,0 +1,29 @@
+package avs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+// The golden file is written by hand after the context objects of the AVS
+// documentation, in their order of fields, rather than generated by
+// DefaultContexts, so it's compared as JSON values.
+func TestDefaultContextsGolden(t *testing.T) {
+	golden, err := ioutil.ReadFile("testdata/default_contexts.golden.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(DefaultContexts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !jsonEqual(t, got, golden) {
+		t.Errorf("got default contexts\n%s\nwant\n%s", got, golden)
+	}
+	// Every call returns new values.
+	DefaultContexts()[2].(*VolumeState).Payload.Volume = 10
+	if v := DefaultContexts()[2].(*VolumeState).Payload.Volume; v != 100 {
+		t.Errorf("got volume %d after changing a previous result", v)
+	}
+}
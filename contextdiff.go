@@ -0,0 +1,170 @@
+package avs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ContextDiff lists the differences between two context snapshots, as
+// returned by DiffContexts.
+type ContextDiff struct {
+	// The contexts that are only in the second snapshot, and those only in
+	// the first one.
+	Added   []MessageType
+	Removed []MessageType
+	// The contexts that are in both snapshots with different payloads.
+	Changed []ContextChange
+}
+
+// ContextChange lists the payload fields that differ between two contexts of
+// the same type.
+type ContextChange struct {
+	Type MessageType
+	// The fields as dot-separated paths (e.g., "token" or
+	// "activeAlerts.0.scheduledTime"), sorted.
+	Fields []string
+}
+
+// DiffContexts compares the context snapshot a with the later snapshot b
+// (e.g., the contexts of two SynchronizeState events). Contexts are matched
+// by namespace and name, and their payloads are compared in their JSON form.
+func DiffContexts(a, b []TypedMessage) *ContextDiff {
+	before, order := contextPayloads(a)
+	after, afterOrder := contextPayloads(b)
+	for _, t := range afterOrder {
+		if _, ok := before[t]; !ok {
+			order = append(order, t)
+		}
+	}
+	diff := &ContextDiff{}
+	for _, t := range order {
+		old, inBefore := before[t]
+		cur, inAfter := after[t]
+		switch {
+		case !inBefore:
+			diff.Added = append(diff.Added, t)
+		case !inAfter:
+			diff.Removed = append(diff.Removed, t)
+		default:
+			var fields []string
+			diffJSON("", old, cur, &fields)
+			if len(fields) > 0 {
+				sort.Strings(fields)
+				diff.Changed = append(diff.Changed, ContextChange{Type: t, Fields: fields})
+			}
+		}
+	}
+	return diff
+}
+
+// Empty returns whether the snapshots are the same.
+func (d *ContextDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String returns the differences with one line per context: added contexts
+// are prefixed with "+", removed ones with "-" and changed ones with "~",
+// followed by their changed fields.
+func (d *ContextDiff) String() string {
+	if d.Empty() {
+		return "no changes"
+	}
+	var lines []string
+	for _, t := range d.Added {
+		lines = append(lines, "+ "+t.Key())
+	}
+	for _, t := range d.Removed {
+		lines = append(lines, "- "+t.Key())
+	}
+	for _, c := range d.Changed {
+		lines = append(lines, fmt.Sprintf("~ %s: %s", c.Type, strings.Join(c.Fields, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Returns the decoded JSON payloads of the contexts by type, and the types in
+// the order of the contexts.
+func contextPayloads(contexts []TypedMessage) (map[MessageType]interface{}, []MessageType) {
+	payloads := make(map[MessageType]interface{}, len(contexts))
+	var order []MessageType
+	for _, context := range contexts {
+		if context == nil || context.GetMessage() == nil {
+			continue
+		}
+		t := context.GetMessage().Type()
+		if _, ok := payloads[t]; !ok {
+			order = append(order, t)
+		}
+		var v struct {
+			Payload interface{} `json:"payload"`
+		}
+		if data, err := codec().Marshal(context); err == nil {
+			unmarshalJSON(data, &v)
+		}
+		payloads[t] = v.Payload
+	}
+	return payloads, order
+}
+
+// Adds the paths of the values that differ between a and b to fields.
+func diffJSON(path string, a, b interface{}, fields *[]string) {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+	switch a := a.(type) {
+	case map[string]interface{}:
+		if b, ok := b.(map[string]interface{}); ok {
+			for key, value := range a {
+				diffJSON(join(key), value, b[key], fields)
+			}
+			for key, value := range b {
+				if _, ok := a[key]; !ok {
+					diffJSON(join(key), nil, value, fields)
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if b, ok := b.([]interface{}); ok && len(a) == len(b) {
+			for i := range a {
+				diffJSON(join(fmt.Sprint(i)), a[i], b[i], fields)
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		if path == "" {
+			path = "payload"
+		}
+		*fields = append(*fields, path)
+	}
+}
+
+// LogContextDiffs returns a hook that writes to the logger how the contexts
+// of every SynchronizeState event differ from those of the previous one. It's
+// meant for debugging (e.g., why AVS resumed the wrong track) and may be
+// added to Client.BeforeSend while a debug flag is set.
+func LogContextDiffs(logger *log.Logger) BeforeSendHook {
+	var mu sync.Mutex
+	var previous []TypedMessage
+	return func(ctx context.Context, envelope *Envelope) error {
+		if envelope.Event.GetMessage().Type() != TypeSynchronizeState {
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if previous != nil {
+			logger.Printf("avs: contexts changed since the last SynchronizeState:\n%s", DiffContexts(previous, envelope.Context))
+		}
+		previous = append([]TypedMessage{}, envelope.Context...)
+		return nil
+	}
+}
@@ -0,0 +1,59 @@
+package avs
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffContexts(t *testing.T) {
+	a := []TypedMessage{
+		NewPlaybackState("track-1", 5*time.Second, PlayerActivityPlaying),
+		NewVolumeState(50, false),
+		NewAlertsState([]Alert{}, []Alert{}),
+	}
+	b := []TypedMessage{
+		NewPlaybackState("track-2", 0, PlayerActivityPlaying),
+		NewAlertsState([]Alert{}, []Alert{}),
+		NewSpeechState("speak-1", 0, PlayerActivityFinished),
+	}
+	diff := DiffContexts(a, b)
+	want := &ContextDiff{
+		Added:   []MessageType{TypeSpeechState},
+		Removed: []MessageType{TypeVolumeState},
+		Changed: []ContextChange{{Type: TypePlaybackState, Fields: []string{"offsetInMilliseconds", "token"}}},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Fatalf("got %+v; want %+v", diff, want)
+	}
+	wantString := "+ SpeechSynthesizer.SpeechState\n- Speaker.VolumeState\n~ AudioPlayer.PlaybackState: offsetInMilliseconds, token"
+	if s := diff.String(); s != wantString {
+		t.Errorf("got %q; want %q", s, wantString)
+	}
+	if diff := DiffContexts(b, b); !diff.Empty() || diff.String() != "no changes" {
+		t.Errorf("got %+v for the same contexts", diff)
+	}
+}
+
+func TestLogContextDiffs(t *testing.T) {
+	var buf bytes.Buffer
+	hook := LogContextDiffs(log.New(&buf, "", 0))
+	send := func(event TypedMessage, contexts ...TypedMessage) {
+		if err := hook(context.Background(), &Envelope{Event: event, Context: contexts}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(NewSynchronizeState("m1"), NewVolumeState(50, false))
+	send(NewRecognize("m2", "d1"), NewVolumeState(10, false))
+	if buf.Len() != 0 {
+		t.Fatalf("logged %q before a second SynchronizeState", buf.String())
+	}
+	send(NewSynchronizeState("m3"), NewVolumeState(60, true))
+	if got := buf.String(); !strings.Contains(got, "~ Speaker.VolumeState: muted, volume") {
+		t.Errorf("got %q", got)
+	}
+}
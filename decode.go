@@ -0,0 +1,462 @@
+package avs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"strings"
+
+	"github.com/fika-io/go-avs/multipart2"
+)
+
+// The byte order mark that some gateways put before the JSON of directive
+// parts.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// The default size above which directive parts are decoded while they're
+// being read instead of being read into memory first.
+const defaultStreamingThreshold = 64 << 10
+
+// TypedFromReader decodes a single message (an object with a header and a
+// payload) from r and returns it with its most specific type, like Typed.
+//
+// If the header comes before the payload, which is always the case for
+// messages sent by AVS, the payload is decoded directly into the typed
+// message and the Payload of the underlying Message is left empty. JSON
+// nested deeper than DefaultLimits.MaxDepth is an ErrInvalidMessage error.
+func TypedFromReader(r io.Reader) (TypedMessage, error) {
+	typed, err := decodeMessage(newDecoder(&depthChecker{r: r, max: DefaultLimits.MaxDepth}))
+	if err != nil && err != io.EOF {
+		return nil, invalidJSON(err)
+	}
+	return typed, err
+}
+
+// Decodes a message object from the decoder. A JSON null is decoded as nil.
+func decodeMessage(dec *json.Decoder) (TypedMessage, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok == nil {
+		return nil, nil
+	}
+	if tok != json.Delim('{') {
+		return nil, withKind(ErrInvalidMessage, fmt.Errorf("avs: expected { in JSON, got %v", tok))
+	}
+	m := new(Message)
+	var typed TypedMessage
+	strict := parseMode() == ParseStrict
+	seen := make(map[string]bool)
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		k, _ := key.(string)
+		if strict {
+			// encoding/json matches the fields case insensitively.
+			if err := checkDuplicateKey(seen, strings.ToLower(k)); err != nil {
+				return nil, err
+			}
+		}
+		// So are the header and payload, like the parts that aren't streamed.
+		switch {
+		case strings.EqualFold(k, "header"):
+			if strict {
+				err = decodeHeaderStrict(dec, &m.Header)
+			} else {
+				err = dec.Decode(&m.Header)
+			}
+		case strings.EqualFold(k, "payload"):
+			var payload interface{}
+			if m.Header != nil {
+				if typed = newRegistered(m.Type()); typed != nil {
+					payload = bind(typed, m)
+				}
+			}
+			if payload != nil {
+				if err = dec.Decode(payload); isUnknownField(err) {
+					err = withKind(ErrInvalidMessage, fmt.Errorf("avs: %s payload: %v", m.Type(), err))
+				}
+			} else {
+				typed = nil
+				err = dec.Decode(&m.Payload)
+			}
+		default:
+			err = skipValue(dec)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+	if typed == nil {
+		if parseMode() == ParseStrict {
+			if err := CheckPayload(m); err != nil {
+				return nil, err
+			}
+		}
+		return m.Typed(), nil
+	}
+	m.typed = typed
+	return typed, nil
+}
+
+// Returns a JSON decoder that decodes numbers into interface{} values as
+// json.Number instead of float64, so that they're encoded again exactly as
+// received. It's always an encoding/json decoder, for its tokenizer. In
+// ParseStrict mode, it also rejects unknown fields.
+func newDecoder(r io.Reader) *json.Decoder {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if parseMode() == ParseStrict {
+		dec.DisallowUnknownFields()
+	}
+	return dec
+}
+
+// Like json.Unmarshal, with the codec set to decode numbers like newDecoder.
+func unmarshalJSON(data []byte, v interface{}) error {
+	dec := codec().NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("avs: data after the JSON value")
+	}
+	return nil
+}
+
+// Decodes a multipart response part ({"directive": {...}}) from r. It returns
+// nil if the part doesn't contain a directive. If keepRaw is true, the
+// directive keeps its JSON as received, which is recorded while it's decoded.
+func decodeResponsePart(r io.Reader, keepRaw bool) (*Message, error) {
+	var input bytes.Buffer
+	if keepRaw {
+		r = io.TeeReader(r, &input)
+	}
+	dec := newDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	var directive *Message
+	strict := parseMode() == ParseStrict
+	seen := make(map[string]bool)
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if k, ok := key.(string); ok && strict {
+			if err := checkDuplicateKey(seen, strings.ToLower(k)); err != nil {
+				return nil, err
+			}
+		}
+		// Field names are matched case insensitively, like json.Unmarshal.
+		if k, ok := key.(string); ok && strings.EqualFold(k, "directive") {
+			start := dec.InputOffset()
+			typed, err := decodeMessage(dec)
+			if err != nil {
+				return nil, err
+			}
+			if typed != nil {
+				directive = typed.GetMessage()
+				if keepRaw {
+					// The value follows the colon after the key.
+					raw := bytes.TrimLeft(input.Bytes()[start:dec.InputOffset()], " \t\r\n:")
+					directive.raw = append(json.RawMessage(nil), raw...)
+				}
+			}
+		} else if err := skipValue(dec); err != nil {
+			return nil, err
+		}
+	}
+	return directive, expectDelim(dec, '}')
+}
+
+// Reads a directive part. Parts up to threshold bytes are read into memory
+// and decoded as before; larger parts are decoded while being read. A
+// negative threshold disables streaming. Either way, the directive keeps its
+// JSON as received. The returned Message is nil for empty (keep-alive) parts
+// and parts without a directive. The limits must be resolved.
+//
+// In ParseStrict mode, duplicate keys in the directive or its header are
+// ErrInvalidMessage errors, since they would otherwise be resolved silently
+// by keeping the last value (e.g., of a dialogRequestId).
+//
+// Parts without a content type or charset are taken to be UTF-8 JSON, and a
+// byte order mark before the JSON is skipped.
+//
+// The rest of the part is drained, so that a part truncated after its
+// directive fails here rather than in the next NextPart.
+func readDirectivePart(p *multipart2.Part, threshold int, limits Limits) (*Message, error) {
+	directive, err := decodeDirectivePart(p, threshold, limits)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(ioutil.Discard, p); err != nil {
+		return nil, err
+	}
+	if err := p.Close(); err != nil {
+		return nil, err
+	}
+	return directive, nil
+}
+
+// Implements readDirectivePart, without draining the part.
+func decodeDirectivePart(p *multipart2.Part, threshold int, limits Limits) (*Message, error) {
+	if err := checkJSONPart(p); err != nil {
+		return nil, err
+	}
+	p.SetLimit(int64(limits.MaxDirectiveSize))
+	body, err := decodedBody(p, limits)
+	if err != nil {
+		return nil, err
+	}
+	if threshold == 0 {
+		threshold = defaultStreamingThreshold
+	}
+	var data []byte
+	if threshold < 0 {
+		data, err = ioutil.ReadAll(body)
+	} else {
+		data, err = ioutil.ReadAll(io.LimitReader(body, int64(threshold)+1))
+	}
+	if err != nil {
+		return nil, err
+	}
+	depth := &depthChecker{r: body, max: limits.MaxDepth}
+	if err := depth.check(data); err != nil {
+		return nil, err
+	}
+	streamed := threshold >= 0 && len(data) > threshold
+	data = bytes.TrimPrefix(data, utf8BOM)
+	if streamed {
+		return decodeResponsePart(io.MultiReader(bytes.NewReader(data), depth), true)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	var response responsePart
+	if err := codec().Unmarshal(data, &response); err != nil {
+		return nil, invalidJSON(err)
+	}
+	if len(response.Directive) == 0 || string(response.Directive) == "null" {
+		return nil, nil
+	}
+	directive := new(Message)
+	if err := codec().Unmarshal(response.Directive, directive); err != nil {
+		return nil, invalidJSON(err)
+	}
+	if parseMode() == ParseStrict {
+		// Decoding it again checks its keys and payload.
+		if _, err := decodeResponsePart(bytes.NewReader(data), false); err != nil {
+			return nil, invalidJSON(err)
+		}
+	}
+	directive.raw = response.Directive
+	return directive, nil
+}
+
+// Returns the body of the part, decompressed if it has a gzip
+// Content-Encoding. The decompressed body is subject to the
+// MaxDirectiveSize too, so that a small part can't expand without bounds.
+func decodedBody(p *multipart2.Part, limits Limits) (io.Reader, error) {
+	encoding := p.Header.Get("Content-Encoding")
+	switch {
+	case encoding == "" || strings.EqualFold(encoding, "identity"):
+		return p, nil
+	case !strings.EqualFold(encoding, "gzip"):
+		return nil, withKind(ErrInvalidMessage, fmt.Errorf("avs: part %d has the unsupported encoding %s", p.Index(), encoding))
+	}
+	zr, err := gzip.NewReader(p)
+	if err != nil {
+		return nil, withKind(ErrInvalidMessage, fmt.Errorf("avs: part %d: %v", p.Index(), err))
+	}
+	if limits.MaxDirectiveSize == 0 {
+		return zr, nil
+	}
+	return &decompressedReader{r: zr, part: p, limit: int64(limits.MaxDirectiveSize)}, nil
+}
+
+// Reads a decompressed part, failing with a PartTooLargeError beyond its
+// limit.
+type decompressedReader struct {
+	r     io.Reader
+	part  *multipart2.Part
+	limit int64
+	read  int64
+}
+
+func (d *decompressedReader) Read(p []byte) (int, error) {
+	if d.read > d.limit {
+		return 0, d.tooLarge()
+	}
+	// Read one byte more than the limit to tell whether it's exceeded.
+	if left := d.limit - d.read; int64(len(p)) > left+1 {
+		p = p[:left+1]
+	}
+	n, err := d.r.Read(p)
+	d.read += int64(n)
+	if d.read > d.limit {
+		return n - 1, d.tooLarge()
+	}
+	return n, err
+}
+
+func (d *decompressedReader) tooLarge() error {
+	return &multipart2.PartTooLargeError{Index: d.part.Index(), Header: d.part.Header, What: "body", Limit: d.limit}
+}
+
+// Returns an ErrInvalidMessage error if the content type of the part isn't
+// JSON in UTF-8.
+func checkJSONPart(p *multipart2.Part) error {
+	contentType := p.Header.Get("Content-Type")
+	if contentType == "" {
+		return nil
+	}
+	mediatype, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return withKind(ErrInvalidMessage, fmt.Errorf("avs: part %d has an invalid content type %q: %v", p.Index(), contentType, err))
+	}
+	if mediatype != "application/json" {
+		return withKind(ErrInvalidMessage, fmt.Errorf("avs: part %d isn't JSON but %s", p.Index(), contentType))
+	}
+	if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") && !strings.EqualFold(charset, "utf8") {
+		return withKind(ErrInvalidMessage, fmt.Errorf("avs: part %d has the unsupported charset %s", p.Index(), charset))
+	}
+	return nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return withKind(ErrInvalidMessage, fmt.Errorf("avs: expected %v in JSON, got %v", delim, tok))
+	}
+	return nil
+}
+
+// Returns JSON syntax and type errors as ErrInvalidMessage errors. Errors
+// from the underlying reader are returned as is.
+func invalidJSON(err error) error {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &syntaxError) || errors.As(err, &typeError) || err == io.ErrUnexpectedEOF || errors.Is(err, ErrInvalidMessage) {
+		return withKind(ErrInvalidMessage, err)
+	}
+	return err
+}
+
+// Decodes a header object, failing on duplicate keys.
+func decodeHeaderStrict(dec *json.Decoder, header *map[string]string) error {
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	hdec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := hdec.Token(); err != nil || tok != json.Delim('{') {
+		// Not an object: let json.Unmarshal report it.
+		return json.Unmarshal(raw, header)
+	}
+	seen := make(map[string]bool)
+	for hdec.More() {
+		key, err := hdec.Token()
+		if err != nil {
+			return err
+		}
+		if k, ok := key.(string); ok {
+			if err := checkDuplicateKey(seen, k); err != nil {
+				return err
+			}
+		}
+		if err := skipValue(hdec); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(raw, header)
+}
+
+// Returns an ErrInvalidMessage error if the key was seen already, and adds it
+// to the seen keys otherwise.
+func checkDuplicateKey(seen map[string]bool, key string) error {
+	if seen[key] {
+		return withKind(ErrInvalidMessage, fmt.Errorf("avs: duplicate key %q in JSON", key))
+	}
+	seen[key] = true
+	return nil
+}
+
+// depthChecker fails reading JSON nested deeper than max objects and arrays,
+// which would take a lot of stack and time to decode. A zero max means no
+// limit. It only tracks strings and brackets; the JSON is validated by its
+// decoder.
+type depthChecker struct {
+	r        io.Reader
+	max      int
+	depth    int
+	inString bool
+	escaped  bool
+	checked  int
+	err      error
+}
+
+// Read reads from the underlying reader and checks the data read.
+func (c *depthChecker) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.r.Read(p)
+	if c.err = c.check(p[:n]); c.err != nil {
+		// Leave out the data from the first bracket too many, so that the
+		// decoder doesn't get to it before the error.
+		return c.checked, c.err
+	}
+	return n, err
+}
+
+// Checks the next data of the JSON. On error, checked is the length of the
+// data up to the first bracket too many.
+func (c *depthChecker) check(data []byte) error {
+	if c.max <= 0 {
+		return nil
+	}
+	for i, b := range data {
+		c.checked = i
+		switch {
+		case c.escaped:
+			c.escaped = false
+		case c.inString:
+			if b == '\\' {
+				c.escaped = true
+			} else if b == '"' {
+				c.inString = false
+			}
+		case b == '"':
+			c.inString = true
+		case b == '{' || b == '[':
+			if c.depth++; c.depth > c.max {
+				return withKind(ErrInvalidMessage, fmt.Errorf("avs: JSON nested deeper than %d levels", c.max))
+			}
+		case b == '}' || b == ']':
+			c.depth--
+		}
+	}
+	return nil
+}
+
+func skipValue(dec *json.Decoder) error {
+	var v json.RawMessage
+	return dec.Decode(&v)
+}
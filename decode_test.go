@@ -0,0 +1,377 @@
+package avs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs/multipart2"
+)
+
+const speakDirective = `{"header":{"namespace":"SpeechSynthesizer","name":"Speak","messageId":"m1"},` +
+	`"payload":{"format":"AUDIO_MPEG","url":"cid:abc","token":"t1"}}`
+
+func TestTypedFromReader(t *testing.T) {
+	tests := []string{
+		speakDirective,
+		// The payload can't be decoded directly if it comes first.
+		`{"payload":{"format":"AUDIO_MPEG","url":"cid:abc","token":"t1"},` +
+			`"header":{"namespace":"SpeechSynthesizer","name":"Speak","messageId":"m1"}}`,
+		// The keys are matched case insensitively, like encoding/json does.
+		`{"Header":{"namespace":"SpeechSynthesizer","name":"Speak","messageId":"m1"},` +
+			`"PAYLOAD":{"format":"AUDIO_MPEG","url":"cid:abc","token":"t1"}}`,
+	}
+	for _, test := range tests {
+		typed, err := TypedFromReader(strings.NewReader(test))
+		if err != nil {
+			t.Fatalf("%s: %v", test, err)
+		}
+		speak, ok := typed.(*Speak)
+		if !ok {
+			t.Fatalf("%s: got %T, want *Speak", test, typed)
+		}
+		if speak.ContentId() != "abc" || speak.Payload.Token != "t1" || speak.Header["messageId"] != "m1" {
+			t.Errorf("%s: unexpected Speak %+v", test, speak.Payload)
+		}
+		if again, ok := speak.GetMessage().Typed().(*Speak); !ok || again.Payload != speak.Payload {
+			t.Errorf("%s: Typed on the message returned %T", test, again)
+		}
+	}
+	typed, err := TypedFromReader(strings.NewReader(`{"header":{"namespace":"Foo","name":"Bar"},"payload":{"a":1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := typed.(*Message); !ok || string(m.Payload) != `{"a":1}` {
+		t.Errorf("unknown message should keep its raw payload, got %#v", typed)
+	}
+	if _, err := TypedFromReader(strings.NewReader(`{"header":`)); err == nil {
+		t.Error("truncated message should fail")
+	}
+}
+
+func TestReadDirectivePart(t *testing.T) {
+	bodies := []string{
+		fmt.Sprintf(`{"directive":%s}`, speakDirective),
+		" \r\n",
+		`{"directive":null}`,
+	}
+	for _, threshold := range []int{0, 16, -1} {
+		for _, body := range bodies {
+			var buf bytes.Buffer
+			w := multipart2.NewWriter(&buf)
+			p, _ := w.CreatePart(nil)
+			p.Write([]byte(body))
+			w.Close()
+			r := multipart2.NewReader(&buf, w.Boundary())
+			part, err := r.NextPart()
+			if err != nil {
+				t.Fatal(err)
+			}
+			directive, err := readDirectivePart(part, threshold, DefaultLimits)
+			if err != nil {
+				t.Fatalf("threshold %d, %q: %v", threshold, body, err)
+			}
+			if body != bodies[0] {
+				if directive != nil {
+					t.Errorf("threshold %d, %q: got %v, want nil", threshold, body, directive)
+				}
+				continue
+			}
+			speak, ok := directive.Typed().(*Speak)
+			if !ok || speak.Payload.Token != "t1" {
+				t.Errorf("threshold %d: got %#v", threshold, directive.Typed())
+			}
+			if string(directive.raw) != speakDirective {
+				t.Errorf("threshold %d: got raw directive %s; want it as received", threshold, directive.raw)
+			}
+		}
+	}
+	// The raw directive of a streamed part is found between spaces too.
+	directive, err := readTestPart(t, fmt.Sprintf("{ \"directive\" :\r\n %s\n}", speakDirective), 16, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(directive.raw) != speakDirective {
+		t.Errorf("got raw directive %s; want it as received", directive.raw)
+	}
+}
+
+func TestReadDirectivePartContentTypes(t *testing.T) {
+	tests := []struct {
+		fixture string
+		err     string
+	}{
+		{"charset_utf8", ""},
+		{"charset_lowercase", ""},
+		{"bare", ""},
+		{"bom", ""},
+		{"no_content_type", ""},
+		{"text_html", "avs: part 0 isn't JSON but text/html; charset=UTF-8"},
+		{"latin1", "avs: part 0 has the unsupported charset ISO-8859-1"},
+	}
+	for _, test := range tests {
+		for _, threshold := range []int{0, 16, -1} {
+			data, err := ioutil.ReadFile("testdata/content_types/" + test.fixture + ".multipart")
+			if err != nil {
+				t.Fatal(err)
+			}
+			part, err := multipart2.NewReader(bytes.NewReader(data), "------abcde123").NextPart()
+			if err != nil {
+				t.Fatal(err)
+			}
+			directive, err := readDirectivePart(part, threshold, DefaultLimits)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err || !errors.Is(err, ErrInvalidMessage) {
+					t.Errorf("%s, threshold %d: got error %v; want %s", test.fixture, threshold, err, test.err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s, threshold %d: %v", test.fixture, threshold, err)
+				continue
+			}
+			if speak, ok := directive.Typed().(*Speak); !ok || speak.Payload.Token != "t1" {
+				t.Errorf("%s, threshold %d: got %#v", test.fixture, threshold, directive.Typed())
+			}
+		}
+	}
+}
+
+// Numbers too large for a float64 (e.g., numeric tokens) must be encoded again
+// exactly as received, whichever way the message was decoded.
+func TestLargeNumbersRoundTrip(t *testing.T) {
+	tests := []string{
+		// An unknown directive.
+		`{"header":{"name":"Unknown","namespace":"Future"},"payload":{"token":1234567890123456789,"offsets":[9007199254740993,1.50]}}`,
+		// A typed event with an interface{} field.
+		`{"header":{"messageId":"m1","name":"StreamMetadataExtracted","namespace":"AudioPlayer"},"payload":{"token":"t1","metadata":{"id":1234567890123456789}}}`,
+	}
+	for _, test := range tests {
+		typed, err := TypedFromReader(strings.NewReader(test))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m Message
+		if err := json.Unmarshal([]byte(test), &m); err != nil {
+			t.Fatal(err)
+		}
+		for _, msg := range []interface{}{typed, m.Typed()} {
+			data, err := json.Marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != test {
+				t.Errorf("%T: got %s, want %s", msg, data, test)
+			}
+		}
+	}
+	// Redacting reorders the fields but keeps the numbers.
+	m := &Message{Header: map[string]string{"namespace": "Future", "name": "Unknown"}, Payload: json.RawMessage(`{"offset":9007199254740993}`)}
+	if got := string(m.Redacted().Payload); got != `{"offset":9007199254740993}` {
+		t.Errorf("got redacted payload %s", got)
+	}
+
+	a := NewStreamMetadataExtracted("m1", "t1", map[string]interface{}{"id": json.Number("1234567890123456789")})
+	b := NewStreamMetadataExtracted("m1", "t1", map[string]interface{}{"id": json.Number("1234567890123456788")})
+	if diff := DiffContexts([]TypedMessage{a}, []TypedMessage{b}); diff.Empty() {
+		t.Error("numbers that differ in their last digit compared equal")
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	// AVS added a field to the stream of the AudioItem.
+	play := `{"header":{"namespace":"AudioPlayer","name":"Play","messageId":"m1"},` +
+		`"payload":{"playBehavior":"ENQUEUE","audioItem":{"audioItemId":"a1","stream":{"url":"cid:abc","token":"t1","foo":1}}}}`
+	// The same message with its payload first, which can't be decoded
+	// directly.
+	reordered := `{"payload":{"audioItem":{"stream":{"foo":1}}},` +
+		`"header":{"namespace":"AudioPlayer","name":"Play","messageId":"m1"}}`
+	decode := func() []error {
+		var errs []error
+		for _, data := range []string{play, reordered} {
+			_, err := TypedFromReader(strings.NewReader(data))
+			errs = append(errs, err)
+			for _, threshold := range []int{16, -1} {
+				var buf bytes.Buffer
+				w := multipart2.NewWriter(&buf)
+				p, _ := w.CreatePart(nil)
+				fmt.Fprintf(p, `{"directive":%s}`, data)
+				w.Close()
+				part, _ := multipart2.NewReader(&buf, w.Boundary()).NextPart()
+				_, err := readDirectivePart(part, threshold, DefaultLimits)
+				errs = append(errs, err)
+			}
+		}
+		return errs
+	}
+
+	for i, err := range decode() {
+		if err != nil {
+			t.Errorf("lenient %d: %v", i, err)
+		}
+	}
+	var m Message
+	json.Unmarshal([]byte(play), &m)
+	if err := CheckPayload(&m); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("got %v; want ErrInvalidMessage", err)
+	}
+
+	SetParseMode(ParseStrict)
+	defer SetParseMode(ParseLenient)
+	for i, err := range decode() {
+		if !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("strict %d: got %v; want ErrInvalidMessage", i, err)
+		}
+	}
+	if _, err := TypedFromReader(strings.NewReader(speakDirective)); err != nil {
+		t.Errorf("strict: %v", err)
+	}
+	if _, err := TypedFromReader(strings.NewReader(`{"header":{"namespace":"Foo","name":"Bar"},"payload":{"a":1}}`)); err != nil {
+		t.Errorf("strict: unknown message failed: %v", err)
+	}
+}
+
+// Writes the body in a multipart part and reads it as a directive part.
+func readTestPart(t *testing.T, body string, threshold int, limits Limits) (*Message, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart2.NewWriter(&buf)
+	p, _ := w.CreatePart(nil)
+	p.Write([]byte(body))
+	w.Close()
+	part, err := multipart2.NewReader(&buf, w.Boundary()).NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readDirectivePart(part, threshold, limits.resolve())
+}
+
+func TestJSONDepth(t *testing.T) {
+	nested := func(depth int) string {
+		return `{"header":{"namespace":"Foo","name":"Bar"},"payload":` +
+			strings.Repeat(`{"a":[`, depth) + strings.Repeat(`]}`, depth) + `}`
+	}
+	for _, threshold := range []int{16, -1} {
+		if _, err := readTestPart(t, fmt.Sprintf(`{"directive":%s}`, nested(20)), threshold, Limits{}); err != nil {
+			t.Errorf("threshold %d: %v", threshold, err)
+		}
+		_, err := readTestPart(t, fmt.Sprintf(`{"directive":%s}`, nested(10000)), threshold, Limits{})
+		if !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("threshold %d: got %v; want ErrInvalidMessage", threshold, err)
+		}
+		if _, err := readTestPart(t, fmt.Sprintf(`{"directive":%s}`, nested(100)), threshold, Limits{MaxDepth: -1}); err != nil {
+			t.Errorf("threshold %d: disabled limit: %v", threshold, err)
+		}
+	}
+	if _, err := TypedFromReader(strings.NewReader(nested(10000))); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("got %v; want ErrInvalidMessage", err)
+	}
+	if _, err := ParseEnvelope(strings.NewReader(`{"event":` + nested(10000) + `}`)); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("ParseEnvelope returned %v; want ErrInvalidMessage", err)
+	}
+	// Brackets in strings, even after escaped quotes, aren't nesting.
+	text := `{"header":{"namespace":"Foo","name":"Bar"},"payload":{"text":"\"` + strings.Repeat("[{", 1000) + `"}}`
+	if _, err := TypedFromReader(strings.NewReader(text)); err != nil {
+		t.Errorf("brackets in a string: %v", err)
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	tests := []string{
+		`{"directive":{"header":{"namespace":"SpeechSynthesizer","name":"Speak","dialogRequestId":"d1","dialogRequestId":"d2"},"payload":{}}}`,
+		`{"directive":{"header":{"namespace":"SpeechSynthesizer","name":"Speak"},"Header":{"namespace":"Alerts","name":"SetAlert"},"payload":{}}}`,
+		`{"directive":{"header":{"namespace":"SpeechSynthesizer","name":"Speak"},"payload":{}},"directive":{"header":{"namespace":"Alerts","name":"SetAlert"},"payload":{}}}`,
+	}
+	for _, test := range tests {
+		for _, threshold := range []int{16, -1} {
+			if _, err := readTestPart(t, test, threshold, Limits{}); err != nil {
+				t.Errorf("lenient, threshold %d: %s: %v", threshold, test, err)
+			}
+		}
+	}
+	SetParseMode(ParseStrict)
+	defer SetParseMode(ParseLenient)
+	for _, test := range tests {
+		for _, threshold := range []int{16, -1} {
+			if _, err := readTestPart(t, test, threshold, Limits{}); !errors.Is(err, ErrInvalidMessage) {
+				t.Errorf("strict, threshold %d: %s: got %v; want ErrInvalidMessage", threshold, test, err)
+			}
+		}
+	}
+	if _, err := readTestPart(t, fmt.Sprintf(`{"directive":%s}`, speakDirective), -1, Limits{}); err != nil {
+		t.Errorf("strict: %v", err)
+	}
+}
+
+func TestReadCompressedDirectivePart(t *testing.T) {
+	compress := func(data string) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(data))
+		zw.Close()
+		return buf.Bytes()
+	}
+	read := func(encoding string, body []byte, threshold int, limits Limits) (*Message, error) {
+		var buf bytes.Buffer
+		w := multipart2.NewWriter(&buf)
+		p, _ := w.CreatePart(textproto.MIMEHeader{"Content-Encoding": {encoding}})
+		p.Write(body)
+		w.Close()
+		part, err := multipart2.NewReader(&buf, w.Boundary()).NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return readDirectivePart(part, threshold, limits.resolve())
+	}
+	body := compress(fmt.Sprintf(`{"directive":%s}`, speakDirective))
+	for _, threshold := range []int{16, -1} {
+		directive, err := read("gzip", body, threshold, Limits{})
+		if err != nil {
+			t.Fatalf("threshold %d: %v", threshold, err)
+		}
+		if speak, ok := directive.Typed().(*Speak); !ok || speak.Payload.Token != "t1" {
+			t.Errorf("threshold %d: got %#v", threshold, directive.Typed())
+		}
+		// A small part can't expand beyond the limit.
+		bomb := compress(`{"directive":` + speakDirective + strings.Repeat(" ", 1<<20) + `}`)
+		if _, err := read("gzip", bomb, threshold, Limits{MaxDirectiveSize: 1 << 16}); !errors.Is(err, ErrPartTooLarge) {
+			t.Errorf("threshold %d: got %v; want ErrPartTooLarge", threshold, err)
+		}
+	}
+	if _, err := read("br", body, -1, Limits{}); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("got %v; want ErrInvalidMessage for an unsupported encoding", err)
+	}
+	if _, err := read("gzip", []byte("not gzip"), -1, Limits{}); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("got %v; want ErrInvalidMessage for a corrupt part", err)
+	}
+}
+
+// A response truncated after a directive fails instead of hanging, whether
+// the directive is streamed or buffered.
+func TestReadResponseTruncated(t *testing.T) {
+	body := "--b\r\nContent-Type: application/json\r\n\r\n" +
+		`{"directive":{"header":{"namespace":"SpeechSynthesizer","name":"Speak","messageId":"m1"},"payload":{"token":"t1"}}}`
+	for _, threshold := range []int{16, -1} {
+		done := make(chan error, 1)
+		go func() {
+			response := &Response{Directives: []*Message{}, Content: map[string][]byte{}}
+			done <- readResponse(multipart2.NewReader(strings.NewReader(body), "b"), response, threshold, DefaultLimits)
+		}()
+		select {
+		case err := <-done:
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("threshold %d: got %v; want io.ErrUnexpectedEOF", threshold, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("threshold %d: readResponse didn't return", threshold)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package avs
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Deduper remembers the message ids of recently seen messages so that
+// duplicates can be dropped. It keeps a bounded number of ids, evicting the
+// least recently seen ones first, and forgets ids after a period of time.
+type Deduper struct {
+	// Clock, if set, replaces the system clock.
+	Clock Clock
+
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type dedupeEntry struct {
+	messageId string
+	seen      time.Time
+}
+
+// NewDeduper returns a Deduper that remembers up to size message ids for the
+// duration ttl. A ttl of zero means that ids are only forgotten when evicted.
+func NewDeduper(size int, ttl time.Duration) *Deduper {
+	if size < 1 {
+		size = 1
+	}
+	return &Deduper{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Seen records the message and returns whether a message with the same
+// message id has already been seen. Messages without a message id are never
+// considered duplicates.
+func (d *Deduper) Seen(m *Message) bool {
+	messageId := m.header("messageId")
+	if messageId == "" {
+		return false
+	}
+	now := clockOrDefault(d.Clock).Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.entries[messageId]; ok {
+		entry := e.Value.(*dedupeEntry)
+		expired := d.ttl > 0 && now.Sub(entry.seen) > d.ttl
+		entry.seen = now
+		d.order.MoveToFront(e)
+		return !expired
+	}
+	d.entries[messageId] = d.order.PushFront(&dedupeEntry{messageId, now})
+	for d.order.Len() > d.size {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.entries, oldest.Value.(*dedupeEntry).messageId)
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package avs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+	"github.com/fika-io/go-avs/avstest"
+)
+
+func TestDeduperTTL(t *testing.T) {
+	clock := avstest.NewFakeClock(time.Now())
+	d := avs.NewDeduper(2, time.Minute)
+	d.Clock = clock
+	m := func(id string) *avs.Message {
+		return &avs.Message{Header: map[string]string{"messageId": id}}
+	}
+	if d.Seen(m("a")) || !d.Seen(m("a")) {
+		t.Errorf("expected second delivery to be a duplicate")
+	}
+	if d.Seen(m("")) || d.Seen(m("")) {
+		t.Errorf("messages without a message id must not be deduplicated")
+	}
+	clock.Advance(2 * time.Minute)
+	if d.Seen(m("a")) {
+		t.Errorf("expected message id to have expired")
+	}
+	d.Seen(m("b"))
+	d.Seen(m("c"))
+	if d.Seen(m("a")) {
+		t.Errorf("expected message id to have been evicted")
+	}
+}
+
+// Seeing a message id again makes it the most recent, so the least recently
+// seen one is evicted first.
+func TestDeduperEvictionOrder(t *testing.T) {
+	d := avs.NewDeduper(2, 0)
+	m := func(id string) *avs.Message {
+		return &avs.Message{Header: map[string]string{"messageId": id}}
+	}
+	d.Seen(m("a"))
+	d.Seen(m("b"))
+	d.Seen(m("a"))
+	d.Seen(m("c"))
+	if !d.Seen(m("a")) {
+		t.Errorf("evicted a, which was seen more recently than b")
+	}
+	if d.Seen(m("b")) {
+		t.Errorf("b should have been evicted")
+	}
+}
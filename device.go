@@ -0,0 +1,348 @@
+package avs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+)
+
+// InterfaceHandler handles the directives of an interface (e.g., Speaker)
+// and provides its context (e.g., VolumeState).
+type InterfaceHandler interface {
+	Handler
+	ContextProvider
+}
+
+// DeviceConfig configures a Device. Every component left nil gets a default
+// that's wired to the other components, supplied or not; a supplied
+// component is used as is, so it must be wired by the caller.
+type DeviceConfig struct {
+	// Client sends the events and opens the downchannel. If nil, a Client
+	// for DefaultEndpointURL is used.
+	Client *Client
+	// Tokens provides the access tokens. It's installed on the Client (see
+	// Client.SetTokenSource). Required.
+	Tokens TokenSource
+	// Store, if set, keeps the playback state across restarts.
+	Store Store
+	// Logger, if set, receives the directives that are dropped and the
+	// handlers that fail, and the debug messages of the default Client.
+	Logger *log.Logger
+
+	// The integrations with the hardware. Player plays the speech, and Sink
+	// plays local prompts. Speaker handles the directives of the Speaker
+	// interface and provides its VolumeState; without it, the device reports
+	// a full volume and ignores the directives. Alerts does the same for the
+	// Alerts interface and its AlertsState.
+	Player  SpeechPlayer
+	Sink    AudioSink
+	Speaker InterfaceHandler
+	Alerts  InterfaceHandler
+
+	// The components, which get a default if nil.
+	Contexts   *ContextAggregator
+	Focus      *FocusManager
+	Dialogs    *DirectiveSequencer
+	Dispatcher *Dispatcher
+	Playback   *PlaybackStateProvider
+	Speech     *SpeechTracker
+	Queue      *PlaybackQueue
+	Dialog     *DialogController
+}
+
+// Device wires the components of a single device: a Client, its
+// downchannel, a Dispatcher, a DialogController and a PlaybackQueue, with the
+// contexts of all of them. It has no logic of its own; its components are
+// exported to be used directly (e.g., Queue to play the audio items). The
+// dialog and the queue share the Focus, so that the items of the queue are
+// put in the background while Alexa listens and speaks.
+type Device struct {
+	Client     *Client
+	Tokens     TokenSource
+	Contexts   *ContextAggregator
+	Focus      *FocusManager
+	Dialogs    *DirectiveSequencer
+	Dispatcher *Dispatcher
+	Playback   *PlaybackStateProvider
+	Speech     *SpeechTracker
+	Queue      *PlaybackQueue
+	Dialog     *DialogController
+	// Sink plays the audio items attached to the responses (see
+	// PlayAttached). It's the Sink of the config, or discards the audio. If
+	// it's a Ducker, it's the Ducker of the default Queue.
+	Sink AudioSink
+
+	mu          sync.Mutex
+	started     bool
+	downchannel *Downchannel
+	cancel      context.CancelFunc
+	done        chan struct{} // closed once the dispatcher returns
+}
+
+// ErrDeviceStarted is returned by Device.Start if the device is already
+// started.
+var ErrDeviceStarted = errors.New("avs: device already started")
+
+// NewDevice returns a Device with the components of the config, and defaults
+// for the others. It returns an error if the config has no Tokens or if the
+// default Client can't be created.
+func NewDevice(config DeviceConfig) (*Device, error) {
+	if config.Tokens == nil {
+		return nil, errors.New("avs: device without a token source")
+	}
+	d := &Device{
+		Client:     config.Client,
+		Tokens:     config.Tokens,
+		Contexts:   config.Contexts,
+		Focus:      config.Focus,
+		Dialogs:    config.Dialogs,
+		Dispatcher: config.Dispatcher,
+		Playback:   config.Playback,
+		Speech:     config.Speech,
+		Queue:      config.Queue,
+		Dialog:     config.Dialog,
+		Sink:       config.Sink,
+	}
+	if d.Sink == nil {
+		d.Sink = discardSink{}
+	}
+	if d.Client == nil {
+		client, err := NewClient(WithLogger(config.Logger))
+		if err != nil {
+			return nil, err
+		}
+		d.Client = client
+	}
+	d.Client.SetTokenSource(config.Tokens)
+	if d.Focus == nil {
+		d.Focus = NewFocusManager()
+	}
+	if d.Dialogs == nil {
+		d.Dialogs = NewDirectiveSequencer()
+	}
+	if d.Playback == nil {
+		d.Playback = NewPlaybackStateProvider(config.Store, 0)
+	}
+	if d.Speech == nil {
+		d.Speech = NewSpeechTracker()
+	}
+	if d.Contexts == nil {
+		d.Contexts = NewContextAggregator()
+		d.Contexts.Add(d.Playback, 0)
+		d.Contexts.Add(d.Speech, 0)
+		if config.Speaker != nil {
+			d.Contexts.Add(config.Speaker, 0)
+		} else {
+			d.Contexts.Add(ContextProviderFunc(func() (TypedMessage, error) {
+				return NewVolumeState(defaultVolume, false), nil
+			}), 0)
+		}
+		if config.Alerts != nil {
+			d.Contexts.Add(config.Alerts, 0)
+		} else {
+			d.Contexts.Add(ContextProviderFunc(func() (TypedMessage, error) {
+				return NewAlertsState([]Alert{}, []Alert{}), nil
+			}), 0)
+		}
+	}
+	if d.Queue == nil {
+		d.Queue = &PlaybackQueue{Client: d.Client, Playback: d.Playback, Focus: d.Focus}
+		if ducker, ok := d.Sink.(Ducker); ok {
+			d.Queue.Ducker = ducker
+		}
+		d.Queue.Metadata = NewStreamMetadataReporter(func(event *StreamMetadataExtracted) {
+			// The metadata is extracted while the stream is read, which
+			// mustn't wait for the event.
+			go func() {
+				request := NewRequest("")
+				request.Event = event
+				if _, err := d.Client.Do(request); err != nil && config.Logger != nil {
+					config.Logger.Printf("avs: failed to send %s: %v", event, err)
+				}
+			}()
+		})
+		if logger := config.Logger; logger != nil {
+			d.Queue.Expired = func(play *Play) { logger.Printf("avs: dropping expired %s", play) }
+			d.Queue.Discarded = func(play *Play) { logger.Printf("avs: dropping stale %s", play) }
+		}
+	}
+	if d.Dialog == nil {
+		d.Dialog = &DialogController{
+			Client:   d.Client,
+			Contexts: d.Contexts,
+			Focus:    d.Focus,
+			Player:   config.Player,
+			Speech:   d.Speech,
+			Playback: d.Playback,
+			Sink:     config.Sink,
+			Dialogs:  d.Dialogs,
+		}
+	}
+	if d.Dispatcher == nil {
+		d.Dispatcher = NewDispatcher()
+		d.Dispatcher.Logger = config.Logger
+		d.Dispatcher.Dialogs = d.Dialogs
+		queue := d.Queue
+		d.Dispatcher.HandleFunc("AudioPlayer.Play", func(ctx context.Context, directive TypedMessage) error {
+			return queue.Enqueue(ctx, directive.(*Play))
+		})
+		d.Dispatcher.HandleFunc("AudioPlayer.ClearQueue", func(ctx context.Context, directive TypedMessage) error {
+			queue.Clear(directive.(*ClearQueue).Payload.ClearBehavior)
+			return nil
+		})
+		if config.Speaker != nil {
+			d.Dispatcher.Handle("Speaker", config.Speaker)
+		}
+		if config.Alerts != nil {
+			d.Dispatcher.Handle("Alerts", config.Alerts)
+		}
+	}
+	return d, nil
+}
+
+// Start opens the downchannel, dispatches its directives until Stop, and
+// sends the state of the device to AVS with SynchronizeState. If it fails,
+// the downchannel is closed again and the device may be started anew. It
+// returns ErrDeviceStarted if the device is already started.
+func (d *Device) Start(ctx context.Context) (err error) {
+	d.mu.Lock()
+	if d.started {
+		d.mu.Unlock()
+		return ErrDeviceStarted
+	}
+	d.started = true
+	d.mu.Unlock()
+	defer func() {
+		if err != nil {
+			d.close()
+		}
+	}()
+	token, err := d.Tokens.Token(ctx)
+	if err != nil {
+		return err
+	}
+	down, err := d.Client.OpenDownchannel(token)
+	if err != nil {
+		return err
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	d.mu.Lock()
+	d.downchannel, d.cancel, d.done = down, cancel, done
+	d.mu.Unlock()
+	go func() {
+		defer close(done)
+		d.Dispatcher.Run(runCtx, down.Directives)
+	}()
+	state := NewSynchronizeStateRequest("", RandomUUIDString(), d.Contexts)
+	_, err = d.Client.DoContext(ctx, state)
+	return err
+}
+
+// Recognize runs an interaction with the audio of the microphone. See
+// DialogController.Recognize.
+func (d *Device) Recognize(ctx context.Context, mic io.ReadCloser) (*InteractionResult, error) {
+	return d.Dialog.Recognize(ctx, mic)
+}
+
+// PlayAttached plays the audio item of the Play directive, which must be
+// attached to the response, with the Sink, keeping Playback up to date. It
+// returns an ErrAttachmentMissing error if the audio isn't attached (e.g., a
+// remote stream, whose URL isn't a content id). Playing the queue is left to
+// the caller (see Queue), who may fetch the remote streams instead.
+//
+// The audio is paused while the Queue is in the background (e.g., while
+// Alexa speaks), by holding it back from the Sink, unless the Sink ducks
+// it instead.
+func (d *Device) PlayAttached(ctx context.Context, response *Response, play *Play) error {
+	stream := play.Payload.AudioItem.Stream
+	audio, err := response.Attachment(stream.URL)
+	if err != nil {
+		return err
+	}
+	d.Playback.SetState(stream.Token, 0, PlayerActivityPlaying)
+	if err := d.Sink.PlayAudio(ctx, &focusReader{ctx, d.Queue, bytes.NewReader(audio)}); err != nil {
+		d.Playback.SetState(stream.Token, 0, PlayerActivityStopped)
+		return err
+	}
+	d.Playback.SetState(stream.Token, 0, PlayerActivityFinished)
+	return nil
+}
+
+// PlayResult enqueues the audio items of the interaction and plays the Queue
+// with PlayAttached until it's empty. The items that can't be enqueued are
+// dropped (see PlaybackQueue.Expired and Discarded), and so are the remote
+// streams, which a caller that can fetch them should play instead. It returns
+// the first error of PlayAttached.
+func (d *Device) PlayResult(ctx context.Context, result *InteractionResult) error {
+	for _, play := range result.Plays {
+		d.Queue.Enqueue(ctx, play)
+	}
+	for play := d.Queue.Peek(); play != nil; play = d.Queue.Advance() {
+		if play.Payload.AudioItem.Stream.ContentId() == "" {
+			continue
+		}
+		if err := d.PlayAttached(ctx, result.Response, play); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads the audio of an item of the queue, waiting while the queue is in the
+// background.
+type focusReader struct {
+	ctx   context.Context
+	queue *PlaybackQueue
+	r     io.Reader
+}
+
+func (r *focusReader) Read(p []byte) (int, error) {
+	if err := r.queue.waitForeground(r.ctx); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// Stop shuts down the DialogController, which reports what it interrupted,
+// then closes the downchannel and waits for the Dispatcher to return.
+func (d *Device) Stop(ctx context.Context) error {
+	err := d.Dialog.Shutdown(ctx)
+	d.close()
+	return err
+}
+
+// Closes the downchannel and stops the Dispatcher, if they were started.
+func (d *Device) close() {
+	d.mu.Lock()
+	down, cancel, done := d.downchannel, d.cancel, d.done
+	d.downchannel, d.cancel, d.done = nil, nil, nil
+	d.started = false
+	d.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+	if down != nil {
+		down.Close()
+	}
+}
+
+// StaticTokenSource returns a TokenSource that always provides the access
+// token, and can't refresh it.
+func StaticTokenSource(accessToken string) TokenSource {
+	return staticTokenSource(accessToken)
+}
+
+type staticTokenSource string
+
+func (s staticTokenSource) Token(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+func (s staticTokenSource) Refresh(ctx context.Context, expired string) (string, error) {
+	return "", errors.New("avs: the access token can't be refreshed")
+}
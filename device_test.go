@@ -0,0 +1,320 @@
+package avs_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+	"github.com/fika-io/go-avs/avstest"
+)
+
+// A Speaker that keeps the volume it's set to.
+type fakeSpeaker struct {
+	mu     sync.Mutex
+	volume int
+}
+
+func (s *fakeSpeaker) HandleDirective(ctx context.Context, directive avs.TypedMessage) error {
+	if setVolume, ok := directive.(*avs.SetVolume); ok {
+		s.mu.Lock()
+		s.volume = setVolume.Payload.Volume
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *fakeSpeaker) Context() (avs.TypedMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return avs.NewVolumeState(s.volume, false), nil
+}
+
+func TestDevice(t *testing.T) {
+	server := avstest.NewServer()
+	defer server.Close()
+	client, err := avs.NewClient(avs.WithEndpointURL(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	speaker := &fakeSpeaker{volume: 50}
+	device, err := avs.NewDevice(avs.DeviceConfig{
+		Client:  client,
+		Tokens:  avs.StaticTokenSource("token"),
+		Speaker: speaker,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := device.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := device.Recognize(ctx, ioutil.NopCloser(strings.NewReader("audio"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := device.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	server.AssertEvents(t, avs.TypeSynchronizeState, avs.TypeRecognize)
+	for _, r := range server.Requests() {
+		if r.AccessToken != "token" {
+			t.Errorf("got access token %q; want the token of the source", r.AccessToken)
+		}
+	}
+
+	// The directives of the Speaker interface go to the Speaker, which
+	// provides the VolumeState.
+	setVolume := &avs.Message{
+		Header:  map[string]string{"namespace": "Speaker", "name": "SetVolume", "messageId": "m1"},
+		Payload: json.RawMessage(`{"volume":30}`),
+	}
+	if err := device.Dispatcher.Dispatch(ctx, setVolume); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range device.Contexts.Contexts(true) {
+		if state, ok := m.(*avs.VolumeState); ok && state.Payload.Volume != 30 {
+			t.Errorf("got volume %d; want 30", state.Payload.Volume)
+		}
+	}
+}
+
+func TestDeviceComponents(t *testing.T) {
+	if _, err := avs.NewDevice(avs.DeviceConfig{}); err == nil {
+		t.Error("created a device without a token source")
+	}
+
+	// A supplied component is used as is, and the defaults are wired to it.
+	playback := avs.NewPlaybackStateProvider(nil, 0)
+	queue := &avs.PlaybackQueue{Playback: playback}
+	device, err := avs.NewDevice(avs.DeviceConfig{
+		Tokens:   avs.StaticTokenSource("token"),
+		Playback: playback,
+		Queue:    queue,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.Queue != queue || device.Playback != playback || device.Dialog.Playback != playback {
+		t.Error("the supplied components weren't used")
+	}
+	var contexts []avs.MessageType
+	for _, m := range device.Contexts.Contexts(true) {
+		contexts = append(contexts, m.GetMessage().Type())
+	}
+	if len(contexts) != 4 {
+		t.Errorf("got contexts %v; want PlaybackState, SpeechState, VolumeState and AlertsState", contexts)
+	}
+}
+
+// A device can't be started twice, and a failed Start closes its downchannel.
+func TestDeviceStart(t *testing.T) {
+	closed := make(chan struct{}, 2)
+	mux := http.NewServeMux()
+	mux.HandleFunc(avs.DirectivesPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "multipart/related; boundary=test")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		closed <- struct{}{}
+	})
+	mux.HandleFunc(avs.EventsPath, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rejected", http.StatusBadRequest)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client, err := avs.NewClient(avs.WithEndpointURL(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := avs.NewDevice(avs.DeviceConfig{Client: client, Tokens: avs.StaticTokenSource("token")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := device.Start(ctx); err == nil || err == avs.ErrDeviceStarted {
+			t.Fatalf("got %v; want the error of SynchronizeState", err)
+		}
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the downchannel wasn't closed after a failed Start")
+		}
+	}
+
+	ok := avstest.NewServer()
+	defer ok.Close()
+	client.EndpointURL = ok.URL
+	if err := device.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := device.Start(ctx); err != avs.ErrDeviceStarted {
+		t.Errorf("got %v for a second Start; want ErrDeviceStarted", err)
+	}
+	if err := device.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A Sink that keeps what it plays, or fails.
+type recordingSink struct {
+	bytes.Buffer
+	err error
+}
+
+func (s *recordingSink) PlayAudio(ctx context.Context, audio io.Reader) error {
+	if s.err != nil {
+		return s.err
+	}
+	_, err := io.Copy(s, audio)
+	return err
+}
+
+func TestDevicePlayAttached(t *testing.T) {
+	sink := new(recordingSink)
+	device, err := avs.NewDevice(avs.DeviceConfig{Tokens: avs.StaticTokenSource("token"), Sink: sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := &avs.Response{Content: map[string][]byte{"song": []byte("audio")}}
+	play := newPlay("s1", avs.PlayBehaviorReplaceAll, time.Time{})
+	play.Payload.AudioItem.Stream.URL = "cid:song"
+	ctx := context.Background()
+	if err := device.PlayAttached(ctx, response, play); err != nil {
+		t.Fatal(err)
+	}
+	if sink.String() != "audio" {
+		t.Errorf("played %q; want the attachment", sink.String())
+	}
+	if token, _, activity := device.Playback.State(); token != "s1" || activity != avs.PlayerActivityFinished {
+		t.Errorf("got %s, %s; want s1 FINISHED", token, activity)
+	}
+
+	// A missing attachment, and a failing Sink, are errors.
+	play.Payload.AudioItem.Stream.URL = "https://example.com/song.mp3"
+	if err := device.PlayAttached(ctx, response, play); !errors.Is(err, avs.ErrAttachmentMissing) {
+		t.Errorf("got %v for a remote stream; want ErrAttachmentMissing", err)
+	}
+	play.Payload.AudioItem.Stream.URL = "cid:song"
+	sink.err = errors.New("write failed")
+	if err := device.PlayAttached(ctx, response, play); err != sink.err {
+		t.Errorf("got %v; want the error of the Sink", err)
+	}
+	if _, _, activity := device.Playback.State(); activity != avs.PlayerActivityStopped {
+		t.Errorf("got %s after a failure; want STOPPED", activity)
+	}
+}
+
+// PlayResult plays the attached items in order and skips the remote streams.
+func TestDevicePlayResult(t *testing.T) {
+	sink := new(recordingSink)
+	device, err := avs.NewDevice(avs.DeviceConfig{Tokens: avs.StaticTokenSource("token"), Sink: sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &avs.InteractionResult{
+		Response: &avs.Response{Content: map[string][]byte{"a": []byte("one "), "b": []byte("two")}},
+	}
+	for i, url := range []string{"cid:a", "https://example.com/song.mp3", "cid:b"} {
+		play := newPlay(fmt.Sprintf("s%d", i), avs.PlayBehaviorEnqueue, time.Time{})
+		play.Payload.AudioItem.Stream.URL = url
+		result.Plays = append(result.Plays, play)
+	}
+	ctx := context.Background()
+	if err := device.PlayResult(ctx, result); err != nil {
+		t.Fatal(err)
+	}
+	if sink.String() != "one two" {
+		t.Errorf("played %q; want the attachments in order", sink.String())
+	}
+	if play := device.Queue.Peek(); play != nil {
+		t.Errorf("got %s in the queue; want it empty", play.Payload.AudioItem.Stream.Token)
+	}
+
+	// The first error of the Sink stops the playback.
+	sink.Reset()
+	sink.err = errors.New("write failed")
+	if err := device.PlayResult(ctx, result); err != sink.err {
+		t.Errorf("got %v; want the error of the Sink", err)
+	}
+}
+
+// A microphone that records the focus of the queue when it's read.
+type focusMic struct {
+	io.Reader
+	queue *avs.PlaybackQueue
+	focus avs.FocusState
+}
+
+func (m *focusMic) Read(p []byte) (int, error) {
+	if m.focus == "" {
+		m.focus = m.queue.FocusState()
+	}
+	return m.Reader.Read(p)
+}
+
+// The dialog puts the item being played in the background, which pauses it.
+func TestDeviceFocus(t *testing.T) {
+	server := avstest.NewServer()
+	defer server.Close()
+	client, err := avs.NewClient(avs.WithEndpointURL(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := new(recordingSink)
+	device, err := avs.NewDevice(avs.DeviceConfig{Client: client, Tokens: avs.StaticTokenSource("token"), Sink: sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := device.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer device.Stop(ctx)
+	play := newPlay("s1", avs.PlayBehaviorReplaceAll, time.Time{})
+	play.Payload.AudioItem.Stream.URL = "cid:song"
+	if err := device.Queue.Enqueue(ctx, play); err != nil {
+		t.Fatal(err)
+	}
+	if got := device.Queue.FocusState(); got != avs.FocusStateForeground {
+		t.Fatalf("got %s for the item; want FOREGROUND", got)
+	}
+	mic := &focusMic{Reader: strings.NewReader("audio"), queue: device.Queue}
+	if _, err := device.Recognize(ctx, ioutil.NopCloser(mic)); err != nil {
+		t.Fatal(err)
+	}
+	if mic.focus != avs.FocusStateBackground {
+		t.Errorf("got %s during the dialog; want BACKGROUND", mic.focus)
+	}
+	if got := device.Queue.FocusState(); got != avs.FocusStateForeground {
+		t.Errorf("got %s after the dialog; want FOREGROUND", got)
+	}
+
+	// The audio is held back from the Sink while the dialog has the focus.
+	dialog := new(fakeObserver)
+	device.Focus.AcquireChannel(avs.ChannelDialog, dialog)
+	done := make(chan error)
+	go func() {
+		done <- device.PlayAttached(ctx, &avs.Response{Content: map[string][]byte{"song": []byte("audio")}}, play)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("played the item in the background: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	device.Focus.ReleaseChannel(avs.ChannelDialog, dialog)
+	if err := <-done; err != nil || sink.String() != "audio" {
+		t.Errorf("got %v and %q after the dialog", err, sink.String())
+	}
+}
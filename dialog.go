@@ -0,0 +1,435 @@
+package avs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrShutdown is returned by components that have been shut down. It is an
+// ErrClosed error.
+var ErrShutdown = withKind(ErrClosed, errors.New("avs: shut down"))
+
+// ErrInteractionInProgress is returned by DialogController.Recognize while
+// another interaction is in progress.
+var ErrInteractionInProgress = errors.New("avs: interaction in progress")
+
+// Shutdowner is implemented by the components that a DialogController shuts
+// down with it.
+type Shutdowner interface {
+	// Shutdown releases the resources of the component. It should return
+	// when done or when ctx is done, whichever happens first.
+	Shutdown(ctx context.Context) error
+}
+
+// SpeechPlayer plays the audio of Speak directives.
+type SpeechPlayer interface {
+	// PlaySpeech plays the audio of the Speak directive. It should return
+	// when the audio has been played or as soon as ctx is canceled.
+	PlaySpeech(ctx context.Context, speak *Speak, audio io.Reader) error
+}
+
+// DialogState is the state of the interaction of a DialogController.
+type DialogState string
+
+// Possible values for DialogState.
+const (
+	// No interaction is in progress.
+	DialogStateIdle DialogState = "IDLE"
+	// The audio of the user is being uploaded.
+	DialogStateListening DialogState = "LISTENING"
+	// The response, or a fallback prompt, is being played.
+	DialogStateSpeaking DialogState = "SPEAKING"
+)
+
+// DialogController runs the Recognize interactions of a device: it uploads
+// what the microphone captures, plays the Speak directives of the response
+// and sends the SpeechStarted and SpeechFinished events.
+//
+// Shutdown tears down an interaction in progress and the components the
+// controller uses, so that AVS isn't left thinking that the device is still
+// listening, speaking or playing.
+type DialogController struct {
+	// The client used to send the events.
+	Client *Client
+	// The access token of the events.
+	AccessToken string
+	// Contexts, if set, fills the context of the events.
+	Contexts *ContextAggregator
+	// Focus, if set, gives the Dialog channel to the interaction.
+	Focus *FocusManager
+	// Player plays the speech. Without one, the speech isn't played.
+	Player SpeechPlayer
+	// Speech, if set, keeps track of the speech being played. It should also
+	// provide the SpeechState context of the Contexts.
+	Speech *SpeechTracker
+	// Playback, if set, is the state of the audio player. It's stopped on
+	// Shutdown.
+	Playback *PlaybackStateProvider
+	// Managers are the other components (e.g., alerts) that are shut down
+	// with the controller, in order.
+	Managers []Shutdowner
+	// FallbackHandler, if set, is called when Recognize fails because AVS
+	// can't be reached or the user isn't authorized, so that a local prompt
+	// can be played to the Sink. Recognize returns once it does.
+	FallbackHandler func(ctx context.Context, reason FallbackReason, err error, sink AudioSink)
+	// Sink plays local audio, such as fallback prompts. Without one, the
+	// fallback handler gets a sink that discards the audio.
+	Sink AudioSink
+	// WakeWord, if set, suppresses the wake word while the speech and the
+	// fallback prompts play.
+	WakeWord *SelfTriggerGuard
+	// WakeWords, if set, keeps changes of the wake words made during an
+	// interaction from taking effect until it's over.
+	WakeWords *WakeWordManager
+	// Incremental plays every Speak directive as soon as it arrives,
+	// streaming its audio while it downloads, instead of once the whole
+	// response has been read. See Client.DoIncremental.
+	Incremental bool
+	// Dialogs, if set, lets a new interaction barge in on the one in
+	// progress (see Recognize). It should be the Dialogs of the Dispatcher,
+	// so that the directives of the superseded dialog are dropped too.
+	Dialogs *DirectiveSequencer
+	// Records, if set, gets the record of every interaction once it's over,
+	// before Recognize returns. Interactions that fail to start (e.g., with
+	// ErrInteractionInProgress) have none.
+	Records func(record *InteractionRecord)
+
+	mu       sync.Mutex
+	shutdown bool
+	current  *interaction
+	state    DialogState
+}
+
+// An interaction in progress.
+type interaction struct {
+	cancel   context.CancelFunc
+	mic      io.Closer
+	done     chan struct{}
+	recorder *interactionRecorder
+}
+
+// The FocusObserver of the Dialog channel. Losing the channel doesn't end
+// the interaction.
+type dialogFocus struct{}
+
+func (*dialogFocus) FocusChanged(channel Channel, state FocusState) {}
+
+// Recognize uploads the audio captured by the microphone in a Recognize event
+// and plays the speech of the response. The microphone is closed when the
+// interaction is torn down by Shutdown.
+//
+// With Dialogs set, a new interaction supersedes the one in progress instead
+// of failing with ErrInteractionInProgress: the speech being played stops,
+// without a SpeechFinished event, and the superseded Recognize returns
+// ErrDialogSuperseded.
+func (c *DialogController) Recognize(ctx context.Context, mic io.ReadCloser) (*InteractionResult, error) {
+	dialogRequestId := RandomUUIDString()
+	var recorder *interactionRecorder
+	if c.Records != nil {
+		recorder = newInteractionRecorder(c.Client.Clock, dialogRequestId)
+	}
+	result, err := c.recognizeDialog(ctx, mic, dialogRequestId, recorder)
+	if record := recorder.finish(err); record != nil {
+		c.Records(record)
+	}
+	return result, err
+}
+
+// Runs the interaction in its dialog, superseding the interaction in
+// progress if there are Dialogs. The recorder may be nil.
+func (c *DialogController) recognizeDialog(ctx context.Context, mic io.ReadCloser, dialogRequestId string, recorder *interactionRecorder) (*InteractionResult, error) {
+	if c.Dialogs == nil {
+		return c.recognize(ctx, mic, dialogRequestId, recorder)
+	}
+	if err := c.supersede(ctx, dialogRequestId); err != nil {
+		return nil, err
+	}
+	dialogCtx, cancel, _ := c.Dialogs.Context(ctx, dialogRequestId)
+	defer cancel()
+	result, err := c.recognize(dialogCtx, mic, dialogRequestId, recorder)
+	if err != nil && err != ErrShutdown && ctx.Err() == nil && dialogCtx.Err() != nil {
+		return result, ErrDialogSuperseded
+	}
+	return result, err
+}
+
+// Starts the dialog, which interrupts the interaction in progress, if any,
+// and waits for it to end.
+func (c *DialogController) supersede(ctx context.Context, dialogRequestId string) error {
+	c.mu.Lock()
+	previous, shutdown := c.current, c.shutdown
+	c.mu.Unlock()
+	if shutdown {
+		return ErrShutdown
+	}
+	c.Dialogs.StartDialog(dialogRequestId)
+	if c.Speech != nil {
+		c.Speech.StartDialog(dialogRequestId)
+	}
+	if previous == nil {
+		return nil
+	}
+	select {
+	case <-previous.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Runs the interaction, which fails if another one is in progress.
+func (c *DialogController) recognize(ctx context.Context, mic io.ReadCloser, dialogRequestId string, recorder *interactionRecorder) (*InteractionResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	i := &interaction{cancel: cancel, mic: mic, done: make(chan struct{}), recorder: recorder}
+	c.mu.Lock()
+	switch {
+	case c.shutdown:
+		c.mu.Unlock()
+		return nil, ErrShutdown
+	case c.current != nil:
+		c.mu.Unlock()
+		return nil, ErrInteractionInProgress
+	}
+	c.current = i
+	c.state = DialogStateListening
+	c.mu.Unlock()
+	recorder.start()
+	if c.WakeWords != nil {
+		defer c.WakeWords.BeginInteraction()()
+	}
+	defer func() {
+		c.mu.Lock()
+		c.current = nil
+		c.state = DialogStateIdle
+		c.mu.Unlock()
+		close(i.done)
+	}()
+
+	focus := new(dialogFocus)
+	if c.Focus != nil {
+		if err := c.Focus.AcquireChannel(ChannelDialog, focus); err != nil {
+			return nil, err
+		}
+		defer c.Focus.ReleaseChannel(ChannelDialog, focus)
+	}
+	request := NewRequest(c.AccessToken)
+	request.Event = NewRecognize(RandomUUIDString(), dialogRequestId)
+	request.Audio = recorder.capture(mic)
+	if c.Contexts != nil {
+		c.Contexts.Fill(request)
+	}
+	if c.Incremental {
+		return c.recognizeIncremental(ctx, i, request)
+	}
+	response, err := c.Client.DoContext(ctx, request)
+	recorder.request(response, err)
+	if err != nil {
+		if reason, ok := fallbackReason(err); ok && ctx.Err() == nil {
+			c.fallback(ctx, reason, err)
+		}
+		return nil, c.interrupted(err)
+	}
+	for _, directive := range response.Directives {
+		recorder.directive(directive.Type())
+	}
+	result := newInteractionResult(response)
+	for _, speak := range result.Speaks {
+		if ctx.Err() != nil {
+			break
+		}
+		audio, err := response.Attachment(speak.Payload.URL)
+		if err != nil {
+			return result, err
+		}
+		if err := c.speak(ctx, i, speak, bytes.NewReader(audio)); err != nil {
+			return result, err
+		}
+	}
+	return result, c.interrupted(ctx.Err())
+}
+
+// Sends the request with Client.DoIncremental, playing every Speak directive
+// as its audio arrives.
+func (c *DialogController) recognizeIncremental(ctx context.Context, i *interaction, request *Request) (*InteractionResult, error) {
+	var speakErr error
+	response, err := c.Client.DoIncremental(ctx, request, func(response *Response, directive TypedMessage) {
+		i.recorder.directive(directive.GetMessage().Type())
+		speak, ok := directive.(*Speak)
+		if !ok || speakErr != nil || ctx.Err() != nil {
+			return
+		}
+		audio, err := response.OpenAttachment(speak.Payload.URL)
+		if err == nil {
+			err = c.speak(ctx, i, speak, audio)
+			audio.Close()
+		}
+		speakErr = err
+	})
+	i.recorder.request(response, err)
+	if response == nil {
+		if reason, ok := fallbackReason(err); ok && ctx.Err() == nil {
+			c.fallback(ctx, reason, err)
+		}
+		return nil, c.interrupted(err)
+	}
+	result := newInteractionResult(response)
+	if speakErr != nil {
+		return result, speakErr
+	}
+	if err != nil {
+		return result, c.interrupted(err)
+	}
+	return result, c.interrupted(ctx.Err())
+}
+
+// Plays a Speak directive between the SpeechStarted and SpeechFinished
+// events. If the interaction is torn down while playing, the speech is
+// interrupted and SpeechFinished isn't sent.
+func (c *DialogController) speak(ctx context.Context, i *interaction, speak *Speak, audio io.Reader) error {
+	token := speak.Payload.Token
+	c.mu.Lock()
+	c.state = DialogStateSpeaking
+	c.mu.Unlock()
+	if err := c.sendEvent(ctx, i.recorder, NewSpeechStarted(RandomUUIDString(), token)); err != nil {
+		return c.interrupted(err)
+	}
+	if player := c.Player; player != nil {
+		if c.WakeWord != nil {
+			player = c.WakeWord.SpeechPlayer(player)
+		}
+		if c.Speech != nil {
+			c.Speech.Started(speak)
+		}
+		if err := player.PlaySpeech(ctx, speak, audio); err != nil && ctx.Err() == nil {
+			if c.Speech != nil {
+				c.Speech.Failed(speak)
+			}
+			return err
+		}
+	}
+	c.mu.Lock()
+	if ctx.Err() != nil {
+		c.mu.Unlock()
+		if c.Speech != nil {
+			c.Speech.Interrupted(speak)
+		}
+		return nil
+	}
+	if c.Speech != nil {
+		c.Speech.Finished(speak)
+	}
+	c.mu.Unlock()
+	return c.interrupted(c.sendEvent(ctx, i.recorder, NewSpeechFinished(RandomUUIDString(), token)))
+}
+
+// State returns the state of the interaction.
+func (c *DialogController) State() DialogState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == "" {
+		return DialogStateIdle
+	}
+	return c.state
+}
+
+// Calls the fallback handler, if any, in the SPEAKING state.
+func (c *DialogController) fallback(ctx context.Context, reason FallbackReason, err error) {
+	if c.FallbackHandler == nil {
+		return
+	}
+	sink := c.Sink
+	if sink == nil {
+		sink = discardSink{}
+	}
+	if c.WakeWord != nil {
+		sink = c.WakeWord.AudioSink(sink)
+	}
+	c.mu.Lock()
+	c.state = DialogStateSpeaking
+	c.mu.Unlock()
+	c.FallbackHandler(ctx, reason, err, sink)
+}
+
+// Returns ErrShutdown instead of err if the controller has been shut down.
+func (c *DialogController) interrupted(err error) error {
+	if err == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.shutdown {
+		return ErrShutdown
+	}
+	return err
+}
+
+// Sends the event. Its request id is recorded by the recorder, if any.
+func (c *DialogController) sendEvent(ctx context.Context, recorder *interactionRecorder, event TypedMessage) error {
+	request := NewRequest(c.AccessToken)
+	request.Event = event
+	if c.Contexts != nil {
+		c.Contexts.Fill(request)
+	}
+	response, err := c.Client.DoContext(ctx, request)
+	recorder.request(response, err)
+	return err
+}
+
+// Shutdown tears down the controller. The interaction in progress, if any,
+// is stopped: the microphone is closed, the upload is aborted and the speech
+// of the response isn't played. A speech that was interrupted gets no event
+// (see SpeechTracker.InterruptionEvent), but PlaybackStopped is sent if the
+// audio player was playing. Finally, Focus, Playback and the Managers are
+// shut down.
+//
+// Once ctx is done, Shutdown stops waiting for the interaction and the
+// events, but still shuts down the other components. It returns the first
+// error that occurred. Recognize returns ErrShutdown afterwards.
+func (c *DialogController) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	c.shutdown = true
+	i := c.current
+	c.mu.Unlock()
+
+	var firstErr error
+	setErr := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if i != nil {
+		i.mic.Close()
+		i.cancel()
+		select {
+		case <-i.done:
+		case <-ctx.Done():
+		}
+	}
+	if c.Playback != nil {
+		token, offset, activity := c.Playback.State()
+		if activity == PlayerActivityPlaying {
+			c.Playback.SetState(token, offset, PlayerActivityStopped)
+			if ctx.Err() == nil {
+				setErr(c.sendEvent(ctx, nil, NewPlaybackStopped(RandomUUIDString(), token, offset)))
+			}
+		}
+	}
+
+	// The events that couldn't be sent leave the teardown incomplete.
+	setErr(ctx.Err())
+	managers := make([]Shutdowner, 0, len(c.Managers)+2)
+	if c.Focus != nil {
+		managers = append(managers, c.Focus)
+	}
+	if c.Playback != nil {
+		managers = append(managers, c.Playback)
+	}
+	for _, m := range append(managers, c.Managers...) {
+		setErr(m.Shutdown(ctx))
+	}
+	return firstErr
+}
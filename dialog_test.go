@@ -0,0 +1,309 @@
+package avs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Returns a function that fails the test if goroutines running code of this
+// package were started since and are still running, like goleak does.
+func checkGoroutines(t *testing.T) func() {
+	before := goroutines()
+	return func() {
+		t.Helper()
+		var leaked []string
+		for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			leaked = leaked[:0]
+			for id, stack := range goroutines() {
+				if _, ok := before[id]; !ok && strings.Contains(stack, "go-avs") && !strings.Contains(stack, "testing.tRunner") {
+					leaked = append(leaked, stack)
+				}
+			}
+			if len(leaked) == 0 || time.Now().After(deadline) {
+				break
+			}
+		}
+		for _, stack := range leaked {
+			t.Errorf("leaked goroutine:\n%s", stack)
+		}
+	}
+}
+
+// Returns the stack of every goroutine by id.
+func goroutines() map[string]string {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	stacks := make(map[string]string)
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		if i := strings.Index(stack, " ["); i > 0 {
+			stacks[stack[:i]] = stack
+		}
+	}
+	return stacks
+}
+
+// Returns a server that records the names of the events it receives. It
+// answers Recognize events with speakDirective once the audio has been
+// read, or never if hang is set.
+func newDialogServer(t *testing.T, hang bool, uploading chan<- struct{}) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var names []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			t.Errorf("unexpected request: %v", err)
+			return
+		}
+		p, _ := mr.NextPart()
+		var metadata struct {
+			Event *Message `json:"event"`
+		}
+		json.NewDecoder(p).Decode(&metadata)
+		name := metadata.Event.Header["name"]
+		mu.Lock()
+		names = append(names, name)
+		mu.Unlock()
+		if name != "Recognize" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		audio, _ := mr.NextPart()
+		if hang {
+			uploading <- struct{}{}
+			// Blocks until the client aborts the upload.
+			io.Copy(ioutil.Discard, audio)
+			return
+		}
+		io.Copy(ioutil.Discard, audio)
+		w.Header().Set("Content-Type", "multipart/related; boundary=------abcde123; type=application/json")
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "--------abcde123\r\nContent-Type: application/json\r\n\r\n{\"directive\":%s}\r\n", speakDirective)
+		fmt.Fprint(w, "--------abcde123\r\nContent-ID: <abc>\r\nContent-Type: application/octet-stream\r\n\r\nmp3\r\n--------abcde123--\r\n")
+	}))
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), names...)
+	}
+}
+
+// A SpeechPlayer that blocks until it's canceled.
+type blockingPlayer struct {
+	playing chan string
+}
+
+func (p *blockingPlayer) PlaySpeech(ctx context.Context, speak *Speak, audio io.Reader) error {
+	p.playing <- speak.Payload.Token
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+type shutdownRecorder struct {
+	called bool
+}
+
+func (r *shutdownRecorder) Shutdown(ctx context.Context) error {
+	r.called = true
+	return nil
+}
+
+type focusRecorder struct {
+	mu     sync.Mutex
+	states []FocusState
+}
+
+func (r *focusRecorder) FocusChanged(channel Channel, state FocusState) {
+	r.mu.Lock()
+	r.states = append(r.states, state)
+	r.mu.Unlock()
+}
+
+func TestDialogControllerShutdownDuringUpload(t *testing.T) {
+	defer checkGoroutines(t)()
+	uploading := make(chan struct{}, 1)
+	server, names := newDialogServer(t, true, uploading)
+	defer server.Close()
+
+	focus := NewFocusManager()
+	content := new(focusRecorder)
+	focus.AcquireChannel(ChannelContent, content)
+	playback := NewPlaybackStateProvider(nil, 0)
+	playback.SetState("song", 5*time.Second, PlayerActivityPlaying)
+	alerts := new(shutdownRecorder)
+	c := &DialogController{
+		Client:      &Client{EndpointURL: server.URL},
+		AccessToken: "token",
+		Focus:       focus,
+		Player:      &blockingPlayer{playing: make(chan string, 1)},
+		Playback:    playback,
+		Managers:    []Shutdowner{alerts},
+	}
+	mic, w := io.Pipe()
+	go w.Write([]byte("hello"))
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c.Recognize(context.Background(), mic)
+		errs <- err
+	}()
+	<-uploading
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != ErrShutdown {
+		t.Errorf("Recognize returned %v; want ErrShutdown", err)
+	}
+	if _, err := w.Write([]byte("more")); err == nil {
+		t.Error("the microphone should be closed")
+	}
+	if got := fmt.Sprint(names()); got != "[Recognize PlaybackStopped]" {
+		t.Errorf("got events %s; want [Recognize PlaybackStopped]", got)
+	}
+	if _, _, activity := playback.State(); activity != PlayerActivityStopped {
+		t.Errorf("got player activity %s; want STOPPED", activity)
+	}
+	if got := fmt.Sprint(content.states); got != "[FOREGROUND BACKGROUND FOREGROUND NONE]" {
+		t.Errorf("got content focus %s", got)
+	}
+	if !alerts.called {
+		t.Error("the managers should be shut down")
+	}
+	if _, err := c.Recognize(context.Background(), ioutil.NopCloser(strings.NewReader(""))); err != ErrShutdown {
+		t.Errorf("got %v after Shutdown; want ErrShutdown", err)
+	}
+}
+
+func TestDialogControllerShutdownDuringSpeech(t *testing.T) {
+	defer checkGoroutines(t)()
+	server, names := newDialogServer(t, false, nil)
+	defer server.Close()
+
+	player := &blockingPlayer{playing: make(chan string, 1)}
+	c := &DialogController{
+		Client:      &Client{EndpointURL: server.URL},
+		AccessToken: "token",
+		Focus:       NewFocusManager(),
+		Player:      player,
+	}
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c.Recognize(context.Background(), ioutil.NopCloser(strings.NewReader("hello")))
+		errs <- err
+	}()
+	if token := <-player.playing; token != "t1" {
+		t.Errorf("playing %q; want t1", token)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != ErrShutdown {
+		t.Errorf("Recognize returned %v; want ErrShutdown", err)
+	}
+	// The interrupted speech gets no SpeechFinished event.
+	if got := fmt.Sprint(names()); got != "[Recognize SpeechStarted]" {
+		t.Errorf("got events %s", got)
+	}
+	if err := c.Focus.AcquireChannel(ChannelDialog, new(focusRecorder)); !errors.Is(err, ErrShutdown) {
+		t.Errorf("got %v after Shutdown; want ErrShutdown", err)
+	}
+}
+
+func TestDialogControllerShutdownDeadline(t *testing.T) {
+	defer checkGoroutines(t)()
+	// Shutdown reports that the teardown is incomplete once ctx is done.
+	uploading := make(chan struct{}, 1)
+	server, _ := newDialogServer(t, true, uploading)
+	defer server.Close()
+	c := &DialogController{Client: &Client{EndpointURL: server.URL}, AccessToken: "token"}
+	mic, w := io.Pipe()
+	go w.Write([]byte("hello"))
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c.Recognize(context.Background(), mic)
+		errs <- err
+	}()
+	<-uploading
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Shutdown(ctx); err != context.Canceled {
+		t.Errorf("got %v; want context.Canceled", err)
+	}
+	<-errs
+}
+
+// A SpeechPlayer whose first speech lasts 10 seconds unless it's canceled.
+type longSpeechPlayer struct {
+	mu      sync.Mutex
+	plays   int
+	playing chan struct{}
+}
+
+func (p *longSpeechPlayer) PlaySpeech(ctx context.Context, speak *Speak, audio io.Reader) error {
+	p.mu.Lock()
+	p.plays++
+	first := p.plays == 1
+	p.mu.Unlock()
+	if !first {
+		return nil
+	}
+	close(p.playing)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(10 * time.Second):
+		return nil
+	}
+}
+
+func TestDialogControllerBargeIn(t *testing.T) {
+	defer checkGoroutines(t)()
+	server, names := newDialogServer(t, false, nil)
+	defer server.Close()
+
+	player := &longSpeechPlayer{playing: make(chan struct{})}
+	c := &DialogController{
+		Client:      &Client{EndpointURL: server.URL},
+		AccessToken: "token",
+		Focus:       NewFocusManager(),
+		Player:      player,
+		Dialogs:     NewDirectiveSequencer(),
+	}
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c.Recognize(context.Background(), ioutil.NopCloser(strings.NewReader("hello")))
+		errs <- err
+	}()
+	<-player.playing
+	started := time.Now()
+	if _, err := c.Recognize(context.Background(), ioutil.NopCloser(strings.NewReader("stop"))); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("barging in took %s", elapsed)
+	}
+	if err := <-errs; err != ErrDialogSuperseded {
+		t.Errorf("superseded Recognize returned %v; want ErrDialogSuperseded", err)
+	}
+	// The interrupted speech gets no SpeechFinished event.
+	if got := fmt.Sprint(names()); got != "[Recognize SpeechStarted Recognize SpeechStarted SpeechFinished]" {
+		t.Errorf("got events %s", got)
+	}
+	if c.State() != DialogStateIdle {
+		t.Errorf("state %s after the interactions; want IDLE", c.State())
+	}
+}
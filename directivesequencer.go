@@ -0,0 +1,93 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrDialogSuperseded is returned by DialogController.Recognize when another
+// interaction started (e.g., the user barged in) before it was done.
+var ErrDialogSuperseded = errors.New("avs: dialog superseded by a new one")
+
+// DirectiveSequencer keeps track of the current dialog, which is the
+// interaction started by the last Recognize event, and gives the handlers of
+// its directives a context that is canceled as soon as a new dialog starts,
+// so that a long Speak stops promptly when the user barges in.
+//
+// A Dispatcher with a DirectiveSequencer drops the directives of older
+// dialogs. Directives without a dialog request id aren't affected.
+type DirectiveSequencer struct {
+	mu              sync.Mutex
+	dialogRequestId string
+	done            chan struct{}
+}
+
+// NewDirectiveSequencer returns a new DirectiveSequencer without a dialog.
+func NewDirectiveSequencer() *DirectiveSequencer {
+	return &DirectiveSequencer{}
+}
+
+// StartDialog makes dialogRequestId the current dialog, canceling the
+// contexts of the previous one. Starting the current dialog again does
+// nothing.
+func (s *DirectiveSequencer) StartDialog(dialogRequestId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if dialogRequestId == s.dialogRequestId {
+		return
+	}
+	if s.done != nil {
+		close(s.done)
+	}
+	s.dialogRequestId = dialogRequestId
+	s.done = make(chan struct{})
+}
+
+// DialogRequestId returns the dialog request id of the current dialog, or ""
+// if none has started.
+func (s *DirectiveSequencer) DialogRequestId() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dialogRequestId
+}
+
+// BeforeSend returns a hook for Client.BeforeSend that starts a dialog for
+// every Recognize event sent.
+func (s *DirectiveSequencer) BeforeSend() BeforeSendHook {
+	return func(ctx context.Context, envelope *Envelope) error {
+		if recognize, ok := envelope.Event.(*Recognize); ok {
+			if id, _ := recognize.DialogRequestId(); id != "" {
+				s.StartDialog(id)
+			}
+		}
+		return nil
+	}
+}
+
+// Context returns a context for the dialog that is canceled when parent is
+// done or another dialog starts. It reports false if the dialog isn't the
+// current one, in which case the context is already canceled. An empty
+// dialog request id, or any id before the first dialog starts, gets a
+// context that is only canceled with parent.
+func (s *DirectiveSequencer) Context(parent context.Context, dialogRequestId string) (context.Context, context.CancelFunc, bool) {
+	ctx, cancel := context.WithCancel(parent)
+	s.mu.Lock()
+	current, done := s.dialogRequestId, s.done
+	s.mu.Unlock()
+	if dialogRequestId == "" || done == nil {
+		return ctx, cancel, true
+	}
+	if dialogRequestId != current {
+		cancel()
+		return ctx, cancel, false
+	}
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel, true
+}
@@ -0,0 +1,85 @@
+package avs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newDialogSpeak(messageId, dialogRequestId string) *Message {
+	return &Message{
+		Header: map[string]string{
+			"namespace":       "SpeechSynthesizer",
+			"name":            "Speak",
+			"messageId":       messageId,
+			"dialogRequestId": dialogRequestId,
+		},
+		Payload: json.RawMessage(`{"format":"AUDIO_MPEG","url":"cid:abc","token":"` + messageId + `"}`),
+	}
+}
+
+func TestDispatcherDialogs(t *testing.T) {
+	d := NewDispatcher()
+	d.Dialogs = NewDirectiveSequencer()
+	playing := make(chan string, 1)
+	var handled []string
+	d.HandleFunc("SpeechSynthesizer.Speak", func(ctx context.Context, directive TypedMessage) error {
+		token := directive.(*Speak).Payload.Token
+		handled = append(handled, token)
+		if token != "long" {
+			return nil
+		}
+		playing <- token
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Second):
+			return nil
+		}
+	})
+
+	// Before any dialog starts, the directives of every dialog are handled.
+	if err := d.Dispatch(context.Background(), newDialogSpeak("early", "d0")); err != nil {
+		t.Fatal(err)
+	}
+	d.Dialogs.StartDialog("d1")
+	errs := make(chan error, 1)
+	go func() {
+		errs <- d.Dispatch(context.Background(), newDialogSpeak("long", "d1"))
+	}()
+	<-playing
+	started := time.Now()
+	d.Dialogs.StartDialog("d2")
+	if err := <-errs; err != nil {
+		t.Errorf("interrupted handler returned %v; want nil", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("handler took %s to stop", elapsed)
+	}
+	for _, m := range []*Message{newDialogSpeak("stale", "d1"), newDialogSpeak("current", "d2"), newDialogSpeak("none", "")} {
+		if err := d.Dispatch(context.Background(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := fmt.Sprint(handled), "[early long current none]"; got != want {
+		t.Errorf("handled %s; want %s", got, want)
+	}
+	if id := d.Dialogs.DialogRequestId(); id != "d2" {
+		t.Errorf("current dialog %q; want d2", id)
+	}
+}
+
+func TestDirectiveSequencerBeforeSend(t *testing.T) {
+	s := NewDirectiveSequencer()
+	hook := s.BeforeSend()
+	hook(context.Background(), &Envelope{Event: NewSynchronizeState("m1")})
+	if id := s.DialogRequestId(); id != "" {
+		t.Errorf("SynchronizeState started dialog %q", id)
+	}
+	hook(context.Background(), &Envelope{Event: NewRecognize("m2", "d1")})
+	if id := s.DialogRequestId(); id != "d1" {
+		t.Errorf("current dialog %q; want d1", id)
+	}
+}
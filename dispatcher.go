@@ -0,0 +1,452 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Handler responds to a directive from AVS.
+type Handler interface {
+	HandleDirective(ctx context.Context, directive TypedMessage) error
+}
+
+// The HandlerFunc type is an adapter to allow the use of ordinary functions
+// as directive handlers.
+type HandlerFunc func(ctx context.Context, directive TypedMessage) error
+
+// HandleDirective calls f(ctx, directive).
+func (f HandlerFunc) HandleDirective(ctx context.Context, directive TypedMessage) error {
+	return f(ctx, directive)
+}
+
+// ErrUnsupportedDirective is returned by Dispatch for directives that the
+// Dispatcher's Profile doesn't support.
+var ErrUnsupportedDirective = errors.New("avs: unsupported directive")
+
+// UnknownDirectivePolicy specifies what a Dispatcher does with directives of
+// a type that this package doesn't know (i.e., for which Typed returns the
+// *Message itself).
+type UnknownDirectivePolicy int
+
+// Possible values for UnknownDirectivePolicy.
+const (
+	// Unknown directives are dispatched like the others, so they only reach
+	// the handlers registered for their namespace.
+	UnknownDirectiveIgnore UnknownDirectivePolicy = iota
+	// Unknown directives are written to the Logger.
+	UnknownDirectiveLog
+	// Unknown directives are reported with an UNSUPPORTED_OPERATION
+	// exception that carries the directive as received.
+	UnknownDirectiveReportException
+	// Unknown directives are passed to UnknownDirective.
+	UnknownDirectiveCallback
+)
+
+// Dispatcher routes directives to the handlers registered for them.
+type Dispatcher struct {
+	// Filters are applied in order to every valid directive before anything
+	// else. The first one that doesn't accept the directive decides what
+	// happens to it (see FilterAction); a dropped directive of a dialog
+	// doesn't keep the directives after it from being dispatched.
+	Filters []DirectiveFilter
+	// Dedupe, if set, is used to drop directives that have already been
+	// dispatched (e.g., when a directive is delivered again after a
+	// reconnect).
+	Dedupe *Deduper
+	// Replay, if set, holds the directives without a handler until one is
+	// registered for them.
+	Replay *ReplayBuffer
+	// Dialogs, if set, drops the directives of superseded dialogs and gives
+	// the handlers of the current dialog a context that is canceled when the
+	// next one starts. A handler cut short that way isn't considered to have
+	// failed.
+	Dialogs *DirectiveSequencer
+	// Logger, if set, receives a line for every directive that is dropped and
+	// every handler that fails.
+	Logger *log.Logger
+	// Timeout, if positive, is the maximum time a handler may spend on a
+	// directive. The context passed to the handler is canceled when it
+	// expires, and Dispatch returns without waiting for the handler.
+	Timeout time.Duration
+	// ReportException, if set, is called with an ExceptionEncountered event
+	// that should be sent to AVS when a handler panics or a directive isn't
+	// supported.
+	ReportException func(event *ExceptionEncountered)
+	// SlowHandler, if set, is called for every directive that took longer
+	// than SlowThreshold to handle.
+	SlowHandler   func(directive *Message, elapsed time.Duration)
+	SlowThreshold time.Duration
+	// Clock, if set, replaces the system clock.
+	Clock Clock
+	// Profile, if set, is the set of interfaces that the device supports.
+	// Directives for other interfaces, and directives without a handler, are
+	// then reported with an UNSUPPORTED_OPERATION exception instead of being
+	// ignored. See also Validate.
+	Profile *DeviceProfile
+	// UnknownDirectives specifies what to do with the directives of an
+	// unknown type that have no handler registered for their exact name.
+	// Except for UnknownDirectiveIgnore, they're not passed to the handler of
+	// their namespace.
+	UnknownDirectives UnknownDirectivePolicy
+	// UnknownDirective is called with the unknown directives when
+	// UnknownDirectives is UnknownDirectiveCallback.
+	UnknownDirective func(directive *Message)
+	// Metrics, if set, receives the latency of the handlers.
+	Metrics *Metrics
+	// DirectResponses makes DispatchResponse dispatch the directives of
+	// responses on the calling goroutine, concurrently with the directives
+	// from Run. By default, they're handed to Run while it's running, so that
+	// all directives are dispatched one at a time in the order they arrive,
+	// whichever way AVS sent them.
+	DirectResponses bool
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	// Directives of responses handed to Run. idle is closed when no Run is
+	// running to receive them.
+	responses chan *delivery
+	runMu     sync.Mutex
+	runs      int
+	idle      chan struct{}
+	// Asks Run to replay the held directives.
+	replays chan struct{}
+	// The number of unknown directives being reported.
+	reporting int32
+}
+
+// A directive handed to Run by DispatchResponse, with the channel that gets
+// the error of its handler.
+type delivery struct {
+	ctx      context.Context
+	message  *Message
+	finished chan error
+}
+
+// The type of the context key that marks the contexts of handlers called by
+// Run.
+type runKey struct{}
+
+// NewDispatcher returns a new Dispatcher without any handlers.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		handlers:  make(map[string]Handler),
+		responses: make(chan *delivery),
+		replays:   make(chan struct{}, 1),
+	}
+}
+
+// Handle registers the handler for the provided directive. The name is either
+// the namespace and name of a directive (e.g., "Speaker.SetVolume") or just a
+// namespace (e.g., "Speaker") to handle all the directives in it. Handlers
+// registered for a specific directive take precedence.
+func (d *Dispatcher) Handle(name string, handler Handler) {
+	d.mu.Lock()
+	d.handlers[name] = handler
+	d.mu.Unlock()
+	if d.Replay != nil && d.Replay.Len() > 0 {
+		d.scheduleReplay()
+	}
+}
+
+// HandleFunc registers the handler function for the provided directive.
+func (d *Dispatcher) HandleFunc(name string, handler func(ctx context.Context, directive TypedMessage) error) {
+	d.Handle(name, HandlerFunc(handler))
+}
+
+// Dispatch passes a directive to its handler and returns the handler's error.
+// Directives without a handler are ignored and invalid directives return the
+// error from Validate. Directives that the Filters don't accept are dropped
+// or rejected first. A handler that panics or times out is considered to
+// have completed with an error.
+func (d *Dispatcher) Dispatch(ctx context.Context, m *Message) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	if ok, err := d.filter(m); !ok {
+		return err
+	}
+	if d.Dedupe != nil && d.Dedupe.Seen(m) {
+		d.logf("avs: dropping duplicate directive %s (message id %s)", m, m.header("messageId"))
+		return nil
+	}
+	if d.Profile != nil && !d.Profile.Supports(m.header("namespace")) {
+		d.logf("avs: directive %s is for an undeclared interface", m)
+		d.reportException(m, ErrorTypeUnsupportedOperation, fmt.Sprintf("%s isn't supported", m.header("namespace")))
+		return fmt.Errorf("%w: %s", ErrUnsupportedDirective, m)
+	}
+	if _, unknown := m.Typed().(*Message); unknown && d.UnknownDirectives != UnknownDirectiveIgnore && !d.hasHandler(m.Type().Key()) {
+		return d.unknownDirective(m)
+	}
+	if d.Replay != nil && d.hold(m) {
+		return nil
+	}
+	return d.dispatchHandler(ctx, m)
+}
+
+// Passes the directive to its handler, from Dispatch or when it's replayed.
+func (d *Dispatcher) dispatchHandler(ctx context.Context, m *Message) error {
+	handler := d.handler(m)
+	if handler == nil {
+		d.logf("avs: no handler for directive %s", m)
+		if d.Profile != nil {
+			d.reportException(m, ErrorTypeUnsupportedOperation, fmt.Sprintf("%s isn't supported", m))
+			return fmt.Errorf("%w: %s", ErrUnsupportedDirective, m)
+		}
+		return nil
+	}
+	if d.Dialogs == nil {
+		return d.invoke(ctx, handler, m)
+	}
+	dialogCtx, cancel, current := d.Dialogs.Context(ctx, m.header("dialogRequestId"))
+	defer cancel()
+	if !current {
+		d.logf("avs: dropping directive %s of a superseded dialog", m)
+		return nil
+	}
+	err := d.invoke(dialogCtx, handler, m)
+	if err != nil && dialogCtx.Err() != nil && ctx.Err() == nil {
+		d.logf("avs: handler for %s interrupted by a new dialog", m)
+		return nil
+	}
+	return err
+}
+
+// DispatchResponse dispatches the directives of a response to an event in
+// order, so that they're handled like the ones from the downchannel. It
+// returns the error of the first directive that fails, in which case the
+// directives after it aren't dispatched. Streamed responses are read as the
+// directives are dispatched.
+//
+// While Run is running, the directives are dispatched by Run, between the
+// directives from the downchannel, unless DirectResponses is set or
+// DispatchResponse is called by a handler that Run called, which would
+// otherwise wait for itself. Either way, DispatchResponse returns once the
+// directives have been handled.
+func (d *Dispatcher) DispatchResponse(ctx context.Context, response *Response) error {
+	direct := d.DirectResponses || d.responses == nil || ctx.Value(runKey{}) == d
+	for {
+		directive, err := response.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if direct {
+			err = d.Dispatch(ctx, directive.GetMessage())
+		} else {
+			err = d.handOver(ctx, directive.GetMessage())
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Hands the directive to Run and waits until it's dispatched. If Run returns
+// in the meantime, the directive is dispatched here instead.
+func (d *Dispatcher) handOver(ctx context.Context, m *Message) error {
+	d.runMu.Lock()
+	idle := d.idle
+	d.runMu.Unlock()
+	if idle == nil {
+		return d.Dispatch(ctx, m)
+	}
+	r := &delivery{ctx: ctx, message: m, finished: make(chan error, 1)}
+	select {
+	case d.responses <- r:
+		return <-r.finished
+	case <-idle:
+		return d.Dispatch(ctx, m)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) runStarted() {
+	d.runMu.Lock()
+	defer d.runMu.Unlock()
+	if d.runs == 0 {
+		d.idle = make(chan struct{})
+	}
+	d.runs++
+}
+
+func (d *Dispatcher) runStopped() {
+	d.runMu.Lock()
+	defer d.runMu.Unlock()
+	d.runs--
+	if d.runs == 0 {
+		close(d.idle)
+		d.idle = nil
+	}
+}
+
+// Run dispatches every directive received on the channel until it's closed
+// or the context is canceled. Meanwhile, it also dispatches the directives
+// of DispatchResponse (see DirectResponses) and those of the Replay buffer.
+func (d *Dispatcher) Run(ctx context.Context, directives <-chan *Message) {
+	if d.responses != nil {
+		d.runStarted()
+		defer d.runStopped()
+	}
+	for {
+		select {
+		case m, ok := <-directives:
+			if !ok {
+				return
+			}
+			if err := d.Dispatch(context.WithValue(ctx, runKey{}, d), m); err != nil {
+				d.logf("avs: handler for %s failed: %v", m, err)
+			}
+		case r := <-d.responses:
+			r.finished <- d.Dispatch(context.WithValue(r.ctx, runKey{}, d), r.message)
+		case <-d.replays:
+			d.replay(context.WithValue(ctx, runKey{}, d))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Validate checks that every handler registered on the Dispatcher is for an
+// interface declared in its Profile, and that every directive of a declared
+// interface has a handler. It should be called once all the handlers are
+// registered.
+func (d *Dispatcher) Validate() error {
+	if d.Profile == nil {
+		return fmt.Errorf("avs: dispatcher has no device profile")
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var problems []string
+	for name := range d.handlers {
+		namespace := name
+		if i := strings.IndexByte(name, '.'); i >= 0 {
+			namespace = name[:i]
+		}
+		if !d.Profile.Supports(namespace) {
+			problems = append(problems, fmt.Sprintf("handler for %s but %s isn't declared", name, namespace))
+		}
+	}
+	for _, t := range directiveTypes {
+		if d.Profile.Supports(t.Namespace) && d.handlers[t.Key()] == nil && d.handlers[t.Namespace] == nil {
+			problems = append(problems, fmt.Sprintf("%s is declared but %s has no handler", t.Namespace, t))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("avs: dispatcher doesn't match its profile: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (d *Dispatcher) invoke(ctx context.Context, handler Handler, m *Message) error {
+	clock := clockOrDefault(d.Clock)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var timeout <-chan time.Time
+	if d.Timeout > 0 {
+		timer := clock.NewTimer(d.Timeout)
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+	started := clock.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				d.logf("avs: handler for %s panicked: %v\n%s", m, r, debug.Stack())
+				d.reportException(m, ErrorTypeInternalError, fmt.Sprintf("handler panicked: %v", r))
+				done <- fmt.Errorf("handler for %s panicked: %v", m, r)
+			}
+		}()
+		done <- handler.HandleDirective(ctx, m.Typed())
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-timeout:
+		cancel()
+		err = context.DeadlineExceeded
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	finished := clock.Now()
+	elapsed := finished.Sub(started)
+	if d.SlowHandler != nil && elapsed > d.SlowThreshold {
+		d.SlowHandler(m, elapsed)
+	}
+	sinceReceived := elapsed
+	if received := m.Metadata().ReceivedAt; !received.IsZero() {
+		sinceReceived = finished.Sub(received)
+	}
+	d.Metrics.handlerLatency(m, sinceReceived, elapsed)
+	return err
+}
+
+// Applies the UnknownDirectives policy.
+func (d *Dispatcher) unknownDirective(m *Message) error {
+	switch d.UnknownDirectives {
+	case UnknownDirectiveLog:
+		d.logf("avs: unknown directive %s", FormatMessage(m))
+	case UnknownDirectiveReportException:
+		// Directives that arrive while an exception is being reported (e.g.,
+		// in the response to a failed ExceptionEncountered event) are only
+		// logged, so that reporting can't loop.
+		if !atomic.CompareAndSwapInt32(&d.reporting, 0, 1) {
+			d.logf("avs: not reporting unknown directive %s while reporting another one", m)
+			return nil
+		}
+		defer atomic.StoreInt32(&d.reporting, 0)
+		d.logf("avs: unknown directive %s", m)
+		d.reportException(m, ErrorTypeUnsupportedOperation, fmt.Sprintf("%s isn't supported", m))
+		return fmt.Errorf("%w: %s", ErrUnsupportedDirective, m)
+	case UnknownDirectiveCallback:
+		if d.UnknownDirective != nil {
+			d.UnknownDirective(m)
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) reportException(m *Message, errorType ErrorType, message string) {
+	if d.ReportException == nil {
+		return
+	}
+	data := []byte(m.raw)
+	if data == nil {
+		data, _ = codec().Marshal(m)
+	}
+	d.ReportException(NewExceptionEncountered(RandomUUIDString(), string(data), errorType, message))
+}
+
+func (d *Dispatcher) hasHandler(name string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.handlers[name] != nil
+}
+
+func (d *Dispatcher) handler(m *Message) Handler {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if h, ok := d.handlers[m.Type().Key()]; ok {
+		return h
+	}
+	return d.handlers[m.header("namespace")]
+}
+
+func (d *Dispatcher) logf(format string, v ...interface{}) {
+	if d.Logger != nil {
+		d.Logger.Printf(format, v...)
+	}
+}
@@ -0,0 +1,288 @@
+package avs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// An unknown directive, spaced and ordered the way no encoder would.
+const unknownDirective = `{ "payload": {"b": 1, "a": [2]},
+  "header": {"namespace": "Custom", "name": "Frob", "messageId": "m9"} }`
+
+// Returns the unknown directive as received by a Client.
+func receiveUnknownDirective(t *testing.T) *Message {
+	server := newResponseServer("--------abcde123\r\nContent-Type: application/json\r\n\r\n" +
+		`{"directive":` + unknownDirective + "}\r\n--------abcde123--\r\n")
+	defer server.Close()
+	response, err := (&Client{EndpointURL: server.URL}).Do(NewRequest("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Directives) != 1 {
+		t.Fatalf("got %d directives; want 1", len(response.Directives))
+	}
+	return response.Directives[0]
+}
+
+func TestUnknownDirectiveReportException(t *testing.T) {
+	m := receiveUnknownDirective(t)
+	d := NewDispatcher()
+	d.UnknownDirectives = UnknownDirectiveReportException
+	d.HandleFunc("Custom", func(ctx context.Context, directive TypedMessage) error {
+		t.Error("the namespace handler shouldn't be called")
+		return nil
+	})
+	var exceptions []*ExceptionEncountered
+	d.ReportException = func(e *ExceptionEncountered) {
+		exceptions = append(exceptions, e)
+		// The exception event fails and its response has the same directive.
+		if err := d.Dispatch(context.Background(), m); err != nil {
+			t.Errorf("got %v while reporting", err)
+		}
+	}
+	if err := d.Dispatch(context.Background(), m); !errors.Is(err, ErrUnsupportedDirective) {
+		t.Errorf("got %v; want ErrUnsupportedDirective", err)
+	}
+	if len(exceptions) != 1 {
+		t.Fatalf("got %d exceptions; want 1", len(exceptions))
+	}
+	if got := exceptions[0].Payload.UnparsedDirective; got != unknownDirective {
+		t.Errorf("got unparsed directive %q; want it as received", got)
+	}
+	if exceptions[0].Payload.Error.Type != ErrorTypeUnsupportedOperation {
+		t.Errorf("got error type %s", exceptions[0].Payload.Error.Type)
+	}
+
+	// Directives with a handler for their exact name are dispatched.
+	called := false
+	d.HandleFunc("Custom.Frob", func(ctx context.Context, directive TypedMessage) error {
+		called = true
+		return nil
+	})
+	if err := d.Dispatch(context.Background(), m); err != nil || !called || len(exceptions) != 1 {
+		t.Errorf("got %v, called %t", err, called)
+	}
+}
+
+func TestUnknownDirectivePolicies(t *testing.T) {
+	m := receiveUnknownDirective(t)
+	var namespace, callback []string
+	var logs bytes.Buffer
+	d := NewDispatcher()
+	d.Logger = log.New(&logs, "", 0)
+	d.HandleFunc("Custom", func(ctx context.Context, directive TypedMessage) error {
+		namespace = append(namespace, directive.GetMessage().String())
+		return nil
+	})
+	d.UnknownDirective = func(directive *Message) {
+		callback = append(callback, directive.String())
+	}
+	for _, policy := range []UnknownDirectivePolicy{UnknownDirectiveIgnore, UnknownDirectiveLog, UnknownDirectiveCallback} {
+		d.UnknownDirectives = policy
+		if err := d.Dispatch(context.Background(), m); err != nil {
+			t.Errorf("policy %d: %v", policy, err)
+		}
+	}
+	if fmt.Sprint(namespace) != "[Custom.Frob]" || fmt.Sprint(callback) != "[Custom.Frob]" {
+		t.Errorf("got %v for the namespace handler and %v for the callback", namespace, callback)
+	}
+	if !strings.Contains(logs.String(), "unknown directive Custom.Frob messageId=m9") {
+		t.Errorf("unexpected logs %q", logs.String())
+	}
+
+	// Known directives aren't affected.
+	d.UnknownDirectives = UnknownDirectiveCallback
+	speak, _ := TypedFromReader(strings.NewReader(speakDirective))
+	if err := d.Dispatch(context.Background(), speak.GetMessage()); err != nil || len(callback) != 1 {
+		t.Errorf("got %v with %d callbacks", err, len(callback))
+	}
+}
+
+// A Speak directive and its ExpectSpeech, as received by a device whose
+// Recognize response carries the Speak while the ExpectSpeech arrives on the
+// downchannel.
+func receiveDialog(t *testing.T) (*Response, *Message) {
+	server := newResponseServer("--------abcde123\r\nContent-Type: application/json\r\n\r\n" +
+		`{"directive":{"header":{"namespace":"SpeechSynthesizer","name":"Speak","messageId":"m1","dialogRequestId":"d1"},` +
+		`"payload":{"url":"cid:s1","format":"AUDIO_MPEG","token":"t1"}}}` + "\r\n--------abcde123--\r\n")
+	defer server.Close()
+	response, err := (&Client{EndpointURL: server.URL}).Do(NewRequest("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := &Message{Header: map[string]string{
+		"namespace": "SpeechRecognizer", "name": "ExpectSpeech", "messageId": "m2", "dialogRequestId": "d1",
+	}, Payload: []byte(`{"timeoutInMilliseconds":8000}`)}
+	expect.source = DirectiveSourceDownchannel
+	return response, expect
+}
+
+func TestDispatchResponseWithDownchannel(t *testing.T) {
+	for _, direct := range []bool{false, true} {
+		response, expect := receiveDialog(t)
+		d := NewDispatcher()
+		d.DirectResponses = direct
+		var mu sync.Mutex
+		var events []string
+		record := func(event string) {
+			mu.Lock()
+			events = append(events, event)
+			mu.Unlock()
+		}
+		speaking := make(chan struct{})
+		expected := make(chan struct{})
+		d.HandleFunc("SpeechSynthesizer.Speak", func(ctx context.Context, directive TypedMessage) error {
+			record("speak from " + directive.GetMessage().Metadata().Source.String())
+			close(speaking)
+			select {
+			case <-expected:
+			case <-time.After(50 * time.Millisecond):
+			}
+			record("spoken")
+			return nil
+		})
+		d.HandleFunc("SpeechRecognizer.ExpectSpeech", func(ctx context.Context, directive TypedMessage) error {
+			record("expect from " + directive.GetMessage().Metadata().Source.String())
+			close(expected)
+			return nil
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		directives := make(chan *Message)
+		go d.Run(ctx, directives)
+		// Wait for Run to be ready for the response.
+		for running := false; !running; time.Sleep(time.Millisecond) {
+			d.runMu.Lock()
+			running = d.runs > 0
+			d.runMu.Unlock()
+		}
+		done := make(chan error)
+		go func() { done <- d.DispatchResponse(ctx, response) }()
+		<-speaking
+		go func() { directives <- expect }()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		<-expected
+		cancel()
+		mu.Lock()
+		got := strings.Join(events, ", ")
+		mu.Unlock()
+		want := "speak from EventResponse, spoken, expect from Downchannel"
+		if direct {
+			want = "speak from EventResponse, expect from Downchannel, spoken"
+		}
+		if got != want {
+			t.Errorf("direct %t: got %s; want %s", direct, got, want)
+		}
+	}
+}
+
+// A handler that panics fails its directive, is reported to AVS, and doesn't
+// stop the dispatcher.
+func TestDispatcherRouting(t *testing.T) {
+	d := NewDispatcher()
+	d.Dedupe = NewDeduper(10, 0)
+	var handled []string
+	d.HandleFunc("Speaker", func(ctx context.Context, directive TypedMessage) error {
+		handled = append(handled, "Speaker:"+directive.GetMessage().Header["name"])
+		return nil
+	})
+	d.HandleFunc("Speaker.SetMute", func(ctx context.Context, directive TypedMessage) error {
+		handled = append(handled, "SetMute")
+		return nil
+	})
+	directive := func(name, messageId string) *Message {
+		return &Message{
+			Header:  map[string]string{"namespace": "Speaker", "name": name, "messageId": messageId},
+			Payload: []byte(`{"volume":10,"mute":true}`),
+		}
+	}
+	for _, m := range []*Message{
+		// The handler of the exact name wins over the one of the namespace,
+		// which gets the other directives in it.
+		directive("SetMute", "m1"),
+		directive("SetVolume", "m2"),
+		// Duplicates are dropped.
+		directive("SetVolume", "m2"),
+		{Header: map[string]string{"namespace": "Alerts", "name": "DeleteAlert", "messageId": "m3"}, Payload: []byte(`{"token":"a1"}`)},
+	} {
+		if err := d.Dispatch(context.Background(), m); err != nil {
+			t.Errorf("%s: %v", m, err)
+		}
+	}
+	if fmt.Sprint(handled) != "[SetMute Speaker:SetVolume]" {
+		t.Errorf("handled %v", handled)
+	}
+}
+
+func TestDispatcherPanic(t *testing.T) {
+	var logs bytes.Buffer
+	var exceptions []*ExceptionEncountered
+	d := NewDispatcher()
+	d.Logger = log.New(&logs, "", 0)
+	d.ReportException = func(e *ExceptionEncountered) {
+		exceptions = append(exceptions, e)
+	}
+	d.HandleFunc("Speaker", func(ctx context.Context, directive TypedMessage) error {
+		if directive.GetMessage().header("messageId") == "m1" {
+			panic("boom")
+		}
+		return nil
+	})
+	err := d.Dispatch(context.Background(), testDirective("m1", ""))
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("got %v; want the panic as an error", err)
+	}
+	if !strings.Contains(logs.String(), "panicked: boom") {
+		t.Errorf("got logs %q; want the panic", logs.String())
+	}
+	if len(exceptions) != 1 {
+		t.Fatalf("got %d exceptions; want 1", len(exceptions))
+	}
+	payload := exceptions[0].Payload
+	if payload.Error.Type != ErrorTypeInternalError || !strings.Contains(payload.UnparsedDirective, `"m1"`) {
+		t.Errorf("got exception %+v; want an INTERNAL_ERROR for m1", payload)
+	}
+	if err := d.Dispatch(context.Background(), testDirective("m2", "")); err != nil {
+		t.Errorf("got %v after a panic", err)
+	}
+	if len(exceptions) != 1 {
+		t.Errorf("got %d exceptions; want none for m2", len(exceptions))
+	}
+}
+
+// A handler that outlives the Timeout fails with context.DeadlineExceeded,
+// and its context is canceled.
+func TestDispatcherTimeout(t *testing.T) {
+	canceled := make(chan struct{})
+	d := NewDispatcher()
+	d.Timeout = 10 * time.Millisecond
+	d.HandleFunc("Speaker", func(ctx context.Context, directive TypedMessage) error {
+		<-ctx.Done()
+		close(canceled)
+		return nil
+	})
+	if err := d.Dispatch(context.Background(), testDirective("m1", "")); err != context.DeadlineExceeded {
+		t.Errorf("got %v; want context.DeadlineExceeded", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the context of the handler wasn't canceled")
+	}
+
+	// Handlers that finish in time return their own error.
+	d.Timeout = time.Minute
+	failed := errors.New("failed")
+	d.HandleFunc("Speaker", func(ctx context.Context, directive TypedMessage) error { return failed })
+	if err := d.Dispatch(context.Background(), testDirective("m2", "")); err != failed {
+		t.Errorf("got %v; want the error of the handler", err)
+	}
+}
@@ -0,0 +1,498 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"reflect"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/fika-io/go-avs/multipart2"
+)
+
+// Downchannel is a persistent connection through which AVS delivers
+// directives.
+type Downchannel struct {
+	// Directives delivered by AVS. The channel is closed when the downchannel
+	// is closed, either by AVS or with the Close method.
+	Directives <-chan *Message
+	// The Amazon request id of the downchannel stream (for debugging purposes).
+	// If the connection was rotated, this is the id of the first stream.
+	RequestId string
+	// When the downchannel was established.
+	Started time.Time
+
+	client             *Client
+	accessToken        string
+	clock              Clock
+	streamingThreshold int
+	limits             Limits
+	queue              *directiveQueue
+	resp               *http.Response
+	conn               net.Conn // the HTTP/2 connection of resp, if known
+	next               *http.Response
+	handingOff         bool
+	done               chan struct{}
+	closeOnce          sync.Once
+	mu                 sync.Mutex
+	err                error
+	rotations          int
+	reconnect          bool
+	lastActivity       time.Time
+}
+
+// OpenDownchannel establishes a persistent connection with AVS and returns a
+// Downchannel through which AVS will deliver directives.
+//
+// When AVS rotates the connection with an HTTP/2 GOAWAY, the Downchannel opens
+// a new stream on a new connection and keeps delivering directives on the
+// same channel. A GOAWAY that lets the stream finish is noticed as soon as a
+// ping (see Client.PingContext) goes through another connection: the new
+// stream is then opened before the old one is closed, so that no directive
+// is missed. GOAWAY errors are detected from the HTTP/2 transports of both
+// net/http and golang.org/x/net/http2.
+func (c *Client) OpenDownchannel(accessToken string) (*Downchannel, error) {
+	resp, accessToken, err := c.openDownchannelStream(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	directives := make(chan *Message)
+	d := &Downchannel{
+		Directives:         directives,
+		RequestId:          resp.Header.Get("x-amzn-requestid"),
+		Started:            clockOrDefault(c.Clock).Now(),
+		client:             c,
+		accessToken:        accessToken,
+		clock:              clockOrDefault(c.Clock),
+		streamingThreshold: c.StreamingThreshold,
+		limits:             c.Limits.resolve(),
+		done:               make(chan struct{}),
+	}
+	d.resp, d.conn = d.track(resp), connOf(resp)
+	if c.DirectiveBufferSize > 0 {
+		d.queue = newDirectiveQueue(c.DirectiveBufferSize, c.Backpressure, c.Metrics)
+	}
+	c.health.downchannelOpened(d)
+	go d.run(directives)
+	return d, nil
+}
+
+// Opens the downchannel stream, applying the retry policy. It also returns the
+// access token that was used, which may have been refreshed.
+func (c *Client) openDownchannelStream(accessToken string) (*http.Response, string, error) {
+	var resp *http.Response
+	err := c.RetryPolicy.retry(context.Background(), c.Clock, c.Metrics, c.Backoff.Downchannel, true, accessToken, func(token string) error {
+		var err error
+		resp, err = c.openStream(token)
+		accessToken = token
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return resp, accessToken, nil
+}
+
+func (c *Client) openStream(accessToken string) (*http.Response, error) {
+	req, err := c.newRequest("GET", DirectivesPath, accessToken, nil)
+	if err != nil {
+		return nil, err
+	}
+	http2Client := c.httpClient()
+	resp, err := http2Client.Do(withConnTrace(req))
+	if err != nil {
+		return nil, notConnected(err)
+	}
+	if more, err := checkStatusCode(resp, c.Limits.resolve().MaxDirectiveSize); !more {
+		resp.Body.Close()
+		if err == nil {
+			err = withKind(ErrNotConnected, fmt.Errorf("avs: downchannel returned no content"))
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Close closes the downchannel.
+func (d *Downchannel) Close() error {
+	var err error
+	d.closeOnce.Do(func() {
+		close(d.done)
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		err = d.resp.Body.Close()
+	})
+	return err
+}
+
+// Reconnect replaces the stream of the downchannel with a new one on a new
+// connection, as when AVS sends a GOAWAY, e.g. because the connection looks
+// stale. Directives keep being delivered on the same channel. The new stream
+// is retried per the Reconnect backoff of the client, or else every second to
+// a minute, until the downchannel is closed.
+//
+// The idle connections of the transport are closed first if the client has
+// its own (see IsolatedTransport), so that the new stream can't go through
+// the stale connection. A shared transport may reuse it.
+func (d *Downchannel) Reconnect() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed() {
+		return withKind(ErrClosed, errors.New("avs: downchannel is closed"))
+	}
+	d.reconnect = true
+	return d.resp.Body.Close()
+}
+
+// LastActivity returns when the last bytes were received on the downchannel,
+// including the empty parts that AVS sends to keep it alive, or when it was
+// opened if nothing was received yet.
+func (d *Downchannel) LastActivity() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lastActivity
+}
+
+// Rotations returns the number of times the connection was replaced after AVS
+// sent a GOAWAY or after Reconnect.
+func (d *Downchannel) Rotations() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rotations
+}
+
+// Err returns the error that caused the downchannel to close, if any. It
+// should be called after the Directives channel has been closed.
+func (d *Downchannel) Err() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+// String returns a description of the downchannel suitable for logs.
+func (d *Downchannel) String() string {
+	return fmt.Sprintf("downchannel (request id %s) opened %s ago", d.RequestId, d.clock.Now().Sub(d.Started))
+}
+
+func (d *Downchannel) run(directives chan<- *Message) {
+	if d.queue != nil {
+		go d.queue.forward(directives, d.done)
+		defer d.queue.close()
+	} else {
+		defer close(directives)
+	}
+	var err error
+	for {
+		d.mu.Lock()
+		resp := d.resp
+		d.mu.Unlock()
+		err = d.read(resp, directives)
+		d.mu.Lock()
+		reconnect, accessToken, handedOff := d.reconnect, d.accessToken, d.next
+		d.reconnect, d.next, d.handingOff = false, nil, false
+		d.mu.Unlock()
+		resp.Body.Close()
+		if d.closed() {
+			if handedOff != nil {
+				handedOff.Body.Close()
+			}
+			break
+		}
+		if handedOff != nil {
+			// The new stream was opened before the old one was closed.
+			d.mu.Lock()
+			d.resp, d.conn = d.track(handedOff), connOf(handedOff)
+			d.rotations++
+			d.mu.Unlock()
+			if d.closed() {
+				handedOff.Body.Close()
+			}
+			continue
+		}
+		if !reconnect && !isGoAway(err) {
+			break
+		}
+		policy := d.client.Backoff.Reconnect
+		if reconnect {
+			// The stale connection has no stream left, so this keeps the
+			// transport from opening the new stream on it. A shared transport
+			// is left alone, since its idle connections belong to other
+			// clients too.
+			if own := d.client.ownedTransport(); own != nil {
+				own.CloseIdleConnections()
+			}
+			if policy == nil {
+				// The connection looked broken, so the network may need
+				// some time to come back.
+				policy = defaultReconnectBackoff
+			}
+		}
+		// AVS rotates connections with GOAWAY. The transport won't reuse the
+		// old connection, so this opens the replacement stream on a new one.
+		var next *http.Response
+		next, accessToken, err = d.reopen(accessToken, policy)
+		if err != nil {
+			break
+		}
+		d.mu.Lock()
+		d.resp, d.conn = d.track(next), connOf(next)
+		d.accessToken = accessToken
+		d.rotations++
+		d.mu.Unlock()
+		if d.closed() {
+			// Close may have closed the previous body instead of this one.
+			next.Body.Close()
+		}
+	}
+	if d.closed() {
+		// Errors caused by closing the downchannel aren't interesting.
+		err = nil
+	}
+	d.mu.Lock()
+	d.err = err
+	d.mu.Unlock()
+	d.client.health.downchannelClosed(d)
+}
+
+// The policy that retries the replacement stream after Reconnect, if the
+// client has no Reconnect backoff.
+var defaultReconnectBackoff = &BackoffPolicy{Initial: time.Second, Max: time.Minute, Jitter: JitterFull}
+
+// Opens the replacement stream of the downchannel, retrying per the policy
+// until the downchannel is closed.
+func (d *Downchannel) reopen(accessToken string, policy *BackoffPolicy) (*http.Response, string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-d.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	var resp *http.Response
+	err := retryBackoff(ctx, policy, d.clock, func(ctx context.Context) error {
+		next, token, err := d.client.openDownchannelStream(accessToken)
+		if err != nil {
+			return err
+		}
+		resp, accessToken = next, token
+		return nil
+	})
+	return resp, accessToken, err
+}
+
+// RawPartHandler handles a part of a downchannel that is neither JSON nor an
+// attachment (see Client.RawPartHandler). The body is the part as received,
+// up to Limits.MaxAttachmentSize bytes, and is only valid until the handler
+// returns; what the handler doesn't read is discarded. It's called by the
+// goroutine reading the downchannel, so the directives that follow wait for
+// it.
+type RawPartHandler func(header textproto.MIMEHeader, body io.Reader)
+
+// Returns the RawPartHandler of the client, or one that discards the parts.
+func (c *Client) rawPartHandler() RawPartHandler {
+	if c.RawPartHandler != nil {
+		return c.RawPartHandler
+	}
+	return func(header textproto.MIMEHeader, body io.Reader) {
+		if c.Logger != nil {
+			c.Logger.Printf("avs: discarding a downchannel part of type %s", header.Get("Content-Type"))
+		}
+	}
+}
+
+// Returns whether the part has a content type that isn't JSON and no
+// Content-ID, which attachments have.
+func isRawPart(p *multipart2.Part) bool {
+	if p.Header.Get("Content-ID") != "" {
+		return false
+	}
+	contentType := p.Header.Get("Content-Type")
+	if contentType == "" {
+		return false
+	}
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediatype != "application/json"
+}
+
+// Returns the response with its body recording the activity of the
+// downchannel.
+func (d *Downchannel) track(resp *http.Response) *http.Response {
+	d.lastActivity = d.clock.Now()
+	resp.Body = &activityReader{ReadCloser: resp.Body, d: d}
+	return resp
+}
+
+// Records when bytes are read from the body of a downchannel stream.
+type activityReader struct {
+	io.ReadCloser
+	d *Downchannel
+}
+
+func (r *activityReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		now := r.d.clock.Now()
+		r.d.mu.Lock()
+		r.d.lastActivity = now
+		r.d.mu.Unlock()
+	}
+	return n, err
+}
+
+func (d *Downchannel) closed() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// The error of the streams that a GOAWAY cuts short, in both HTTP/2
+// transports. It's unexported, so it's recognized by its message.
+const goAwayStreamError = "http2: Transport received Server's graceful shutdown GOAWAY"
+
+// Returns whether the error was caused by the server sending a GOAWAY. Since
+// Go 1.27, the HTTP/2 transport of golang.org/x/net/http2 wraps the one of
+// net/http, which reports a GOAWAY with its own error type, out of reach of
+// errors.As; it's recognized by its name instead.
+func isGoAway(err error) bool {
+	var goAway http2.GoAwayError
+	if errors.As(err, &goAway) {
+		return true
+	}
+	for ; err != nil; err = errors.Unwrap(err) {
+		if err.Error() == goAwayStreamError {
+			return true
+		}
+		t := reflect.TypeOf(err)
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch {
+		case t.PkgPath() == "net/http/internal/http2" && t.Name() == "GoAwayError",
+			t.PkgPath() == "net/http" && t.Name() == "http2GoAwayError":
+			return true
+		}
+	}
+	return false
+}
+
+// Replaces the stream of the downchannel if new requests no longer go
+// through its connection, as after a GOAWAY that lets the stream finish. conn
+// is the connection of a request that was just made.
+func (d *Downchannel) checkConnection(conn net.Conn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if conn == nil || d.conn == nil || conn == d.conn || d.handingOff || d.closed() {
+		return
+	}
+	d.handingOff = true
+	go d.handOff()
+}
+
+// Opens a new stream and then closes the current one, which makes run switch
+// to the new stream.
+func (d *Downchannel) handOff() {
+	d.mu.Lock()
+	accessToken := d.accessToken
+	d.mu.Unlock()
+	next, accessToken, err := d.client.openDownchannelStream(accessToken)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil || d.closed() {
+		d.handingOff = false
+		if err == nil {
+			next.Body.Close()
+		} else if d.client.Logger != nil {
+			d.client.Logger.Printf("avs: failed to replace the stream of the %s: %v", d, err)
+		}
+		return
+	}
+	d.next, d.accessToken = next, accessToken
+	d.resp.Body.Close()
+}
+
+type connKey struct{}
+
+// Returns the request with a trace that records the connection it's sent on
+// (see connOf).
+func withConnTrace(req *http.Request) *http.Request {
+	conn := new(net.Conn)
+	ctx := context.WithValue(req.Context(), connKey{}, conn)
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { *conn = info.Conn },
+	})
+	return req.WithContext(ctx)
+}
+
+// Returns the HTTP/2 connection of the response to a request made with
+// withConnTrace, or nil. HTTP/1 connections carry one request at a time, so
+// they can't be compared.
+func connOf(resp *http.Response) net.Conn {
+	if resp.ProtoMajor != 2 || resp.Request == nil {
+		return nil
+	}
+	if conn, ok := resp.Request.Context().Value(connKey{}).(*net.Conn); ok {
+		return *conn
+	}
+	return nil
+}
+
+func (d *Downchannel) read(resp *http.Response, directives chan<- *Message) error {
+	mr, err := newMultipartReaderFromResponse(resp, d.limits)
+	if err != nil {
+		return err
+	}
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if isRawPart(p) {
+			p.SetLimit(int64(d.limits.MaxAttachmentSize))
+			d.client.rawPartHandler()(p.Header, p)
+			continue
+		}
+		directive, err := readDirectivePart(p, d.streamingThreshold, d.limits)
+		if err != nil {
+			return err
+		}
+		if directive == nil {
+			// Skip empty (keep-alive) parts.
+			continue
+		}
+		directive.received = d.clock.Now()
+		directive.source = DirectiveSourceDownchannel
+		if directive.Validate() != nil {
+			// Skip junk that isn't a directive.
+			continue
+		}
+		d.client.processed.observe(directive)
+		if d.queue != nil {
+			if err := d.queue.push(directive, d.done); err != nil {
+				return err
+			}
+			continue
+		}
+		select {
+		case directives <- directive:
+		case <-d.done:
+			return nil
+		}
+	}
+}
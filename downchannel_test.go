@@ -0,0 +1,343 @@
+package avs
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// An HTTP/2 server that can send a GOAWAY on the connections it serves.
+type goAwayServer struct {
+	URL   string
+	ln    net.Listener
+	roots *x509.CertPool
+
+	mu    sync.Mutex
+	conns []*lockedConn
+}
+
+// Serializes writes so that a GOAWAY frame can be injected between the
+// frames written by the HTTP/2 server.
+type lockedConn struct {
+	net.Conn
+	mu sync.Mutex
+}
+
+func (c *lockedConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+func newGoAwayServer(t *testing.T, handler http.Handler) *goAwayServer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &goAwayServer{roots: x509.NewCertPool()}
+	s.roots.AddCert(cert)
+	s.ln, err = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{http2.NextProtoTLS},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.URL = "https://" + s.ln.Addr().String()
+	go func() {
+		for {
+			conn, err := s.ln.Accept()
+			if err != nil {
+				return
+			}
+			if err := conn.(*tls.Conn).Handshake(); err != nil {
+				conn.Close()
+				continue
+			}
+			lc := &lockedConn{Conn: conn}
+			s.mu.Lock()
+			s.conns = append(s.conns, lc)
+			s.mu.Unlock()
+			go new(http2.Server).ServeConn(lc, &http2.ServeConnOpts{Handler: handler})
+		}
+	}()
+	return s
+}
+
+// Sends a GOAWAY on every open connection and closes them. Like AVS, it lets
+// the streams that are already open finish if lastStreamID is high enough.
+func (s *goAwayServer) GoAway(lastStreamID uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.mu.Lock()
+		http2.NewFramer(c.Conn, nil).WriteGoAway(lastStreamID, http2.ErrCodeNo, nil)
+		c.mu.Unlock()
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *goAwayServer) Close() {
+	s.ln.Close()
+	s.GoAway(0)
+}
+
+func TestDownchannelGoAway(t *testing.T) {
+	var mu sync.Mutex
+	streams := 0
+	server := newGoAwayServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		streams++
+		n := streams
+		mu.Unlock()
+		w.Header().Set("Content-Type", "multipart/related; boundary=------abcde123; type=application/json")
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "--------abcde123\r\nContent-Type: application/json\r\n\r\n"+
+			`{"directive":{"header":{"namespace":"Speaker","name":"SetVolume","messageId":"m%d"},"payload":{"volume":%d}}}`+"\r\n--------abcde123\r\n", n, n)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	roots := tr.TLSClientConfig.RootCAs
+	tr.TLSClientConfig.RootCAs = server.roots
+	defer func() {
+		tr.TLSClientConfig.RootCAs = roots
+	}()
+
+	client := &Client{EndpointURL: server.URL}
+	d, err := client.OpenDownchannel("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for i := 1; i <= 3; i++ {
+		select {
+		case m, ok := <-d.Directives:
+			if !ok {
+				t.Fatalf("downchannel closed: %v", d.Err())
+			}
+			if id := m.Header["messageId"]; id != fmt.Sprintf("m%d", i) {
+				t.Fatalf("got directive %s; want m%d", id, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for directive %d", i)
+		}
+		// Rotate the connection mid-stream, either letting the stream finish or
+		// not.
+		server.GoAway(uint32(i%2) * 1000)
+	}
+	if n := d.Rotations(); n < 2 {
+		t.Errorf("got %d rotations; want at least 2", n)
+	}
+}
+
+func TestIsGoAway(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want bool
+	}{
+		{http2.GoAwayError{LastStreamID: 1}, true},
+		{fmt.Errorf("reading: %w", http2.GoAwayError{}), true},
+		{&url.Error{Op: "Get", Err: errors.New(goAwayStreamError)}, true},
+		{io.ErrUnexpectedEOF, false},
+		{nil, false},
+	} {
+		if got := isGoAway(c.err); got != c.want {
+			t.Errorf("isGoAway(%v) = %t; want %t", c.err, got, c.want)
+		}
+	}
+}
+
+// A transport that pretends to multiplex the requests over HTTP/2
+// connections: each request is reported on the current connection, and the
+// downchannel streams are handed to the test.
+type fakeH2Transport struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	streams chan *io.PipeWriter
+}
+
+func (t *fakeH2Transport) setConn(conn net.Conn) {
+	t.mu.Lock()
+	t.conn = conn
+	t.mu.Unlock()
+}
+
+func (t *fakeH2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	conn := t.conn
+	t.mu.Unlock()
+	if trace := httptrace.ContextClientTrace(req.Context()); trace != nil && trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{Conn: conn})
+	}
+	resp := &http.Response{StatusCode: 204, ProtoMajor: 2, Header: make(http.Header), Body: http.NoBody, Request: req}
+	if req.URL.Path == DirectivesPath {
+		r, w := io.Pipe()
+		resp.StatusCode, resp.Body = 200, r
+		resp.Header.Set("Content-Type", "multipart/related; boundary=------abcde123; type=application/json")
+		t.streams <- w
+	}
+	return resp, nil
+}
+
+// Once a ping goes through another connection, the stream of the downchannel
+// is replaced before the old one is closed.
+func TestPreemptiveRotation(t *testing.T) {
+	first, second := net.Pipe()
+	defer first.Close()
+	transport := &fakeH2Transport{conn: first, streams: make(chan *io.PipeWriter, 2)}
+	client := &Client{EndpointURL: "https://avs.test", Transport: transport}
+	d, err := client.OpenDownchannel("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	// Sends a directive, which is read once the boundary after it is.
+	send := func(w io.Writer, id string, first bool) error {
+		part := "Content-Type: application/json\r\n\r\n" +
+			`{"directive":{"header":{"namespace":"Speaker","name":"SetVolume","messageId":"` + id + `"},"payload":{"volume":1}}}` +
+			"\r\n--------abcde123\r\n"
+		if first {
+			part = "--------abcde123\r\n" + part
+		}
+		_, err := io.WriteString(w, part)
+		return err
+	}
+	receive := func(want string) {
+		select {
+		case m := <-d.Directives:
+			if id := m.Header["messageId"]; id != want {
+				t.Fatalf("got directive %s; want %s", id, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+	old := <-transport.streams
+	go send(old, "m1", true)
+	receive("m1")
+
+	// The connection still takes new streams.
+	if err := client.Ping("token"); err != nil {
+		t.Fatal(err)
+	}
+	go send(old, "m2", false)
+	receive("m2")
+
+	// After a GOAWAY, the ping goes through a new connection.
+	transport.setConn(second)
+	if err := client.Ping("token"); err != nil {
+		t.Fatal(err)
+	}
+	var next *io.PipeWriter
+	select {
+	case next = <-transport.streams:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no replacement stream was opened")
+	}
+	go send(next, "m3", true)
+	receive("m3")
+	if err := send(old, "stale", false); err != io.ErrClosedPipe {
+		t.Errorf("got %v writing to the old stream; want it closed", err)
+	}
+	if n := d.Rotations(); n != 1 {
+		t.Errorf("got %d rotations; want 1", n)
+	}
+}
+
+func TestRawPartHandler(t *testing.T) {
+	raw := "\x00\x01binary\r\n--not a boundary\r\n\xff"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "multipart/related; boundary=------abcde123; type=application/json")
+		w.WriteHeader(200)
+		directive := "--------abcde123\r\nContent-Type: application/json\r\n\r\n" +
+			`{"directive":{"header":{"namespace":"Speaker","name":"SetVolume","messageId":"m%d"},"payload":{"volume":%d}}}` + "\r\n"
+		fmt.Fprintf(w, directive, 1, 1)
+		fmt.Fprint(w, "--------abcde123\r\nContent-Type: application/x-experimental\r\nX-Extension: test\r\n\r\n"+raw+"\r\n")
+		fmt.Fprintf(w, directive, 2, 2)
+		fmt.Fprint(w, "--------abcde123--\r\n")
+	}))
+	defer server.Close()
+
+	// Returns the message ids of the directives of a downchannel.
+	receive := func(client *Client) []string {
+		d, err := client.OpenDownchannel("token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		var ids []string
+		for directive := range d.Directives {
+			ids = append(ids, directive.Header["messageId"])
+		}
+		if d.Err() != nil {
+			t.Error(d.Err())
+		}
+		return ids
+	}
+
+	var parts []string
+	client := &Client{EndpointURL: server.URL, RawPartHandler: func(header textproto.MIMEHeader, body io.Reader) {
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Error(err)
+		}
+		parts = append(parts, header.Get("X-Extension")+":"+string(data))
+	}}
+	if ids := receive(client); fmt.Sprint(ids) != "[m1 m2]" {
+		t.Errorf("got directives %v; want m1 and m2", ids)
+	}
+	if len(parts) != 1 || parts[0] != "test:"+raw {
+		t.Errorf("got raw parts %q", parts)
+	}
+
+	// By default, the parts are discarded and logged.
+	var logs bytes.Buffer
+	client = &Client{EndpointURL: server.URL, Logger: log.New(&logs, "", 0)}
+	if ids := receive(client); fmt.Sprint(ids) != "[m1 m2]" {
+		t.Errorf("got directives %v; want m1 and m2", ids)
+	}
+	if !strings.Contains(logs.String(), "application/x-experimental") {
+		t.Errorf("got logs %q", logs.String())
+	}
+}
@@ -0,0 +1,102 @@
+package avs
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// The Store namespace and key of the endpoint set by AVS.
+const (
+	endpointStoreNamespace = "Endpoint"
+	endpointStoreKey       = "url"
+)
+
+// EndpointSwitcher handles the directives that move the device to another
+// endpoint, System.SetEndpoint and Alexa.ApiGateway.SetGateway, by switching
+// the endpoint of its Client. The endpoint is persisted in the Store, if set,
+// so that the device keeps using it after a restart (see Restore).
+//
+// Register it for both directives:
+//
+//	d.Handle("System.SetEndpoint", switcher)
+//	d.Handle("Alexa.ApiGateway.SetGateway", switcher)
+type EndpointSwitcher struct {
+	Client *Client
+	Store  Store
+	// OnSwitch, if set, is called with the new endpoint after a switch (e.g.,
+	// to open a new downchannel to it).
+	OnSwitch func(endpoint string)
+}
+
+// NewEndpointSwitcher returns an EndpointSwitcher for the client that
+// persists the endpoint in the store, which may be nil.
+func NewEndpointSwitcher(client *Client, store Store) *EndpointSwitcher {
+	return &EndpointSwitcher{Client: client, Store: store}
+}
+
+// Restore switches the client to the endpoint persisted by a previous switch,
+// if any. A persisted endpoint that isn't an HTTPS URL is an
+// ErrInvalidMessage error, and leaves the client as it is.
+func (s *EndpointSwitcher) Restore() error {
+	if s.Store == nil {
+		return nil
+	}
+	data, err := s.Store.Get(endpointStoreNamespace, endpointStoreKey)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	endpoint := string(data)
+	if err := validateHTTPSURL(endpoint); err != nil {
+		return err
+	}
+	return s.Client.SetEndpointURL(endpoint)
+}
+
+// Switch validates the endpoint, which must be an HTTPS URL, switches the
+// client to it and then persists it. An invalid endpoint is neither used nor
+// persisted. If persisting fails, the client still uses the new endpoint and
+// OnSwitch is still called, but the error is returned.
+func (s *EndpointSwitcher) Switch(endpoint string) error {
+	if err := validateHTTPSURL(endpoint); err != nil {
+		return err
+	}
+	if err := s.Client.SetEndpointURL(endpoint); err != nil {
+		return err
+	}
+	var err error
+	if s.Store != nil {
+		err = s.Store.Put(endpointStoreNamespace, endpointStoreKey, []byte(endpoint))
+	}
+	if s.OnSwitch != nil {
+		s.OnSwitch(endpoint)
+	}
+	return err
+}
+
+// HandleDirective switches to the endpoint of a SetEndpoint or SetGateway
+// directive.
+func (s *EndpointSwitcher) HandleDirective(ctx context.Context, directive TypedMessage) error {
+	switch d := directive.(type) {
+	case *SetEndpoint:
+		return s.Switch(d.Payload.Endpoint)
+	case *SetGateway:
+		return s.Switch(d.GatewayURL())
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedDirective, directive.GetMessage())
+}
+
+// Endpoints sent by AVS must be secure.
+func validateHTTPSURL(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return withKind(ErrInvalidMessage, fmt.Errorf("avs: invalid endpoint URL: %v", err))
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return withKind(ErrInvalidMessage, fmt.Errorf("avs: endpoint URL %q isn't an absolute HTTPS URL", rawurl))
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package avs_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fika-io/go-avs"
+	"github.com/fika-io/go-avs/avstest"
+)
+
+func TestEndpointSwitcher(t *testing.T) {
+	var paths []string
+	gateway := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer gateway.Close()
+	store, _ := avs.NewFileStore(t.TempDir(), nil)
+	client := &avs.Client{EndpointURL: "https://avs.invalid", Transport: gateway.Client().Transport}
+	switcher := avs.NewEndpointSwitcher(client, store)
+	var switched []string
+	switcher.OnSwitch = func(endpoint string) {
+		switched = append(switched, endpoint)
+	}
+	d := avs.NewDispatcher()
+	d.Handle("System.SetEndpoint", switcher)
+	d.Handle("Alexa.ApiGateway.SetGateway", switcher)
+
+	directive := avstest.SetGateway(gateway.URL)
+	if got := directive.GatewayURL(); got != gateway.URL {
+		t.Errorf("got gateway %q, want %q", got, gateway.URL)
+	}
+	if err := d.Dispatch(context.Background(), directive.GetMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if len(switched) != 1 || switched[0] != gateway.URL {
+		t.Errorf("got switches %v", switched)
+	}
+	request := avs.NewRequest("token")
+	request.Event = avs.NewSynchronizeState("m1")
+	if _, err := client.DoContext(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 {
+		t.Errorf("gateway got %d requests, want 1", len(paths))
+	}
+	if got := client.Config().EndpointURL; got != gateway.URL {
+		t.Errorf("Config has endpoint %q, want %q", got, gateway.URL)
+	}
+
+	// Insecure endpoints are rejected, and the client keeps its endpoint.
+	for _, directive := range []avs.TypedMessage{
+		avstest.SetGateway("http://gateway.example.com"),
+		avstest.SetEndpoint("gateway.example.com"),
+	} {
+		if err := d.Dispatch(context.Background(), directive.GetMessage()); !errors.Is(err, avs.ErrInvalidMessage) {
+			t.Errorf("got %v for %s, want ErrInvalidMessage", err, directive.GetMessage())
+		}
+	}
+	if len(switched) != 1 {
+		t.Errorf("got switches %v", switched)
+	}
+
+	// After a restart, the endpoint is restored from the store.
+	restarted := &avs.Client{EndpointURL: avs.DefaultEndpointURL}
+	if err := avs.NewEndpointSwitcher(restarted, store).Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if got := restarted.Config().EndpointURL; got != gateway.URL {
+		t.Errorf("restored endpoint %q, want %q", got, gateway.URL)
+	}
+
+	// An insecure endpoint in the store isn't restored.
+	store.Put("Endpoint", "url", []byte("http://gateway.example.com"))
+	restarted = &avs.Client{EndpointURL: avs.DefaultEndpointURL}
+	if err := avs.NewEndpointSwitcher(restarted, store).Restore(); !errors.Is(err, avs.ErrInvalidMessage) {
+		t.Errorf("got %v, want ErrInvalidMessage", err)
+	}
+	if got := restarted.Config().EndpointURL; got != avs.DefaultEndpointURL {
+		t.Errorf("restored endpoint %q, want the default one", got)
+	}
+}
+
+// A Store that fails to write.
+type readOnlyStore struct{ avs.Store }
+
+func (readOnlyStore) Put(namespace, key string, value []byte) error {
+	return errors.New("read-only")
+}
+
+// The client switches even if the endpoint can't be persisted.
+func TestEndpointSwitcherStoreFailure(t *testing.T) {
+	store, _ := avs.NewFileStore(t.TempDir(), nil)
+	client := &avs.Client{EndpointURL: avs.DefaultEndpointURL}
+	switcher := avs.NewEndpointSwitcher(client, readOnlyStore{store})
+	switched := false
+	switcher.OnSwitch = func(endpoint string) { switched = true }
+	const endpoint = "https://avs-alexa-eu.amazon.com"
+	if err := switcher.Switch(endpoint); err == nil {
+		t.Error("got no error; want the error of the store")
+	}
+	if got := client.Config().EndpointURL; got != endpoint || !switched {
+		t.Errorf("got endpoint %q, switched %t; want the new endpoint", got, switched)
+	}
+}
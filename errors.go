@@ -0,0 +1,85 @@
+package avs
+
+import (
+	"context"
+	"errors"
+)
+
+// The kinds of errors returned by the package. Errors of these kinds wrap
+// their cause, so they're matched with errors.Is while errors.As still finds
+// the cause (e.g., an *Exception or a net.Error):
+//
+//	switch {
+//	case errors.Is(err, avs.ErrUnauthorized):
+//		// Refresh the access token.
+//	case errors.Is(err, avs.ErrThrottled):
+//		// Back off.
+//	case errors.Is(err, avs.ErrNotConnected):
+//		// Wait for the network to come back.
+//	}
+var (
+	// ErrNotConnected is the kind of the errors returned when AVS can't be
+	// reached, or when it closes a connection before responding.
+	ErrNotConnected = errors.New("avs: not connected")
+	// ErrClosed is the kind of the errors returned by components that have
+	// been closed.
+	ErrClosed = errors.New("avs: closed")
+	// ErrUnauthorized is the kind of the errors returned when AVS rejects the
+	// access token: UNAUTHORIZED_REQUEST_EXCEPTION exceptions and HTTP 401
+	// and 403 responses.
+	ErrUnauthorized = errors.New("avs: unauthorized")
+	// ErrThrottled is the kind of the errors returned when AVS asks the
+	// device to slow down: THROTTLING_EXCEPTION exceptions and HTTP 429
+	// responses.
+	ErrThrottled = errors.New("avs: throttled")
+	// ErrAttachmentMissing is the kind of the errors returned when a
+	// directive refers to an attachment that the response doesn't have.
+	ErrAttachmentMissing = errors.New("avs: attachment missing")
+	// ErrInvalidMessage is the kind of the errors returned for messages and
+	// responses that can't be parsed or lack required fields.
+	ErrInvalidMessage = errors.New("avs: invalid message")
+	// ErrIndeterminate is the kind of the errors returned when the connection
+	// is lost after the event was at least partly sent, so AVS may or may
+	// not have processed it. These errors are also ErrNotConnected errors,
+	// and their cause is an *IndeterminateError.
+	ErrIndeterminate = errors.New("avs: indeterminate")
+	// ErrRetryBudgetExhausted is the kind of the errors returned when a
+	// request would have been retried, but the RetryBudget of the policy
+	// has no retries left. Their cause is the error of the last attempt.
+	ErrRetryBudgetExhausted = errors.New("avs: retry budget exhausted")
+)
+
+// An error of one of the kinds above. Its message is the one of its cause.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+// Returns err as an error of the kind. A nil err returns nil.
+func withKind(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind, err}
+}
+
+// Returns the error of a request that failed before AVS responded as an
+// ErrNotConnected error, unless the request was canceled.
+func notConnected(err error) error {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return withKind(ErrNotConnected, err)
+}
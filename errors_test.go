@@ -0,0 +1,95 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorKinds(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind error
+	}{
+		{&Exception{Payload: struct {
+			Code        ExceptionCode `json:"code"`
+			Description string        `json:"description"`
+		}{Code: ExceptionCodeUnauthorizedRequest}}, ErrUnauthorized},
+		{&Exception{Payload: struct {
+			Code        ExceptionCode `json:"code"`
+			Description string        `json:"description"`
+		}{Code: ExceptionCodeThrottling}}, ErrThrottled},
+		{&RequestError{StatusCode: 401}, ErrUnauthorized},
+		{&RequestError{StatusCode: 403}, ErrUnauthorized},
+		{&RequestError{StatusCode: 429}, ErrThrottled},
+		{ErrNoHeader, ErrInvalidMessage},
+		{ErrShutdown, ErrClosed},
+		{fmt.Errorf("sending: %w", ErrNoHeader), ErrInvalidMessage},
+	}
+	kinds := []error{ErrNotConnected, ErrClosed, ErrUnauthorized, ErrThrottled, ErrAttachmentMissing, ErrInvalidMessage}
+	for _, test := range tests {
+		for _, kind := range kinds {
+			if got := errors.Is(test.err, kind); got != (kind == test.kind) {
+				t.Errorf("errors.Is(%v, %v) = %t", test.err, kind, got)
+			}
+		}
+	}
+}
+
+func TestClientErrorKinds(t *testing.T) {
+	offline := httptest.NewServer(http.NotFoundHandler())
+	offline.Close()
+	_, err := (&Client{EndpointURL: offline.URL}).Do(NewRequest("token"))
+	var netError net.Error
+	if !errors.Is(err, ErrNotConnected) || !errors.As(err, &netError) {
+		t.Errorf("got %v; want an ErrNotConnected net.Error", err)
+	}
+	if err := (&Client{EndpointURL: offline.URL}).Ping("token"); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Ping returned %v; want ErrNotConnected", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (&Client{EndpointURL: offline.URL}).DoContext(ctx, NewRequest("token")); errors.Is(err, ErrNotConnected) {
+		t.Errorf("a canceled request returned %v", err)
+	}
+
+	server := newResponseServer("--------abcde123\r\nContent-Type: application/json\r\n\r\n{\"directive\":{\"header\":\r\n--------abcde123--\r\n")
+	defer server.Close()
+	_, err = (&Client{EndpointURL: server.URL}).Do(NewRequest("token"))
+	if !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("got %v; want ErrInvalidMessage", err)
+	}
+
+	if _, err := TypedFromReader(strings.NewReader(`{"header":[]}`)); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("got %v; want ErrInvalidMessage", err)
+	}
+	if _, err := TypedFromReader(strings.NewReader(``)); err != io.EOF {
+		t.Errorf("got %v; want io.EOF", err)
+	}
+	response := &Response{Content: map[string][]byte{"abc": []byte("mp3")}}
+	if data, err := response.Attachment("cid:abc"); err != nil || string(data) != "mp3" {
+		t.Errorf("got %q, %v", data, err)
+	}
+	if _, err := response.Attachment("cid:def"); !errors.Is(err, ErrAttachmentMissing) {
+		t.Errorf("got %v; want ErrAttachmentMissing", err)
+	}
+}
+
+func TestResponseNextAfterClose(t *testing.T) {
+	server := newResponseServer(speakAndExpectSpeech)
+	defer server.Close()
+	response, err := (&Client{EndpointURL: server.URL}).DoStream(context.Background(), NewRequest("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Close()
+	if _, err := response.Next(); !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v; want ErrClosed", err)
+	}
+}
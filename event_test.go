@@ -0,0 +1,43 @@
+package avs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Returns a server that responds to every event with 204 No Content, and a
+// function that returns the names of the events it received.
+func newEventServer(t *testing.T) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var names []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			t.Errorf("unexpected request: %v", err)
+			return
+		}
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Errorf("unexpected request: %v", err)
+			return
+		}
+		var metadata struct {
+			Event *Message `json:"event"`
+		}
+		if err := json.NewDecoder(p).Decode(&metadata); err != nil {
+			t.Errorf("invalid metadata: %v", err)
+		}
+		mu.Lock()
+		names = append(names, metadata.Event.Header["name"])
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), names...)
+	}
+}
@@ -0,0 +1,47 @@
+package avs_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/fika-io/go-avs"
+)
+
+// Errors returned by the package are matched by kind with errors.Is. The
+// original cause, such as an *avs.Exception, remains available to errors.As.
+func Example_errors() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(429)
+		fmt.Fprint(w, `{"header":{"namespace":"System","name":"Exception"},"payload":{"code":"THROTTLING_EXCEPTION","description":"slow down"}}`)
+	}))
+	defer server.Close()
+	client := &avs.Client{EndpointURL: server.URL}
+
+	_, err := client.Do(avs.NewRequest("token"))
+	var exception *avs.Exception
+	switch {
+	case err == nil:
+		fmt.Println("sent")
+	case errors.Is(err, avs.ErrUnauthorized):
+		fmt.Println("refresh the access token")
+	case errors.Is(err, avs.ErrThrottled):
+		fmt.Println("back off and retry")
+	case errors.Is(err, avs.ErrNotConnected):
+		fmt.Println("wait for the network")
+	case errors.Is(err, avs.ErrInvalidMessage):
+		fmt.Println("report a bug")
+	case errors.As(err, &exception):
+		fmt.Println("AVS said:", exception.Payload.Description)
+	default:
+		fmt.Println(err)
+	}
+	if errors.As(err, &exception) {
+		fmt.Println(exception.StatusCode, exception.Payload.Code)
+	}
+	// Output:
+	// back off and retry
+	// 429 THROTTLING_EXCEPTION
+}
@@ -0,0 +1,58 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+)
+
+// FallbackReason specifies why an interaction fell back to a local response.
+type FallbackReason string
+
+// Possible values for FallbackReason.
+const (
+	// AVS couldn't be reached (e.g., the device is offline).
+	FallbackOffline FallbackReason = "OFFLINE"
+	// AVS rejected the access token.
+	FallbackUnauthorized FallbackReason = "UNAUTHORIZED"
+)
+
+// AudioSink plays local audio, such as prompts stored on the device.
+type AudioSink interface {
+	// PlayAudio plays the audio. It should return when the audio has been
+	// played or as soon as ctx is canceled.
+	PlayAudio(ctx context.Context, audio io.Reader) error
+}
+
+// An AudioSink that discards the audio.
+type discardSink struct{}
+
+func (discardSink) PlayAudio(ctx context.Context, audio io.Reader) error {
+	_, err := io.Copy(ioutil.Discard, audio)
+	return err
+}
+
+// Returns why a request failed if it warrants a local response: network
+// errors are OFFLINE, and HTTP 401 and 403 responses and
+// UNAUTHORIZED_REQUEST_EXCEPTION exceptions are UNAUTHORIZED. Other
+// exceptions from AVS and canceled requests don't.
+func fallbackReason(err error) (FallbackReason, bool) {
+	var exception *Exception
+	if errors.As(err, &exception) {
+		return FallbackUnauthorized, exception.Payload.Code == ExceptionCodeUnauthorizedRequest
+	}
+	var requestError *RequestError
+	if errors.As(err, &requestError) {
+		return FallbackUnauthorized, requestError.StatusCode == 401 || requestError.StatusCode == 403
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrShutdown) {
+		return "", false
+	}
+	var netError net.Error
+	if errors.As(err, &netError) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return FallbackOffline, true
+	}
+	return "", false
+}
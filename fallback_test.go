@@ -0,0 +1,92 @@
+package avs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type recordingSink struct {
+	played []string
+}
+
+func (s *recordingSink) PlayAudio(ctx context.Context, audio io.Reader) error {
+	data, err := ioutil.ReadAll(audio)
+	s.played = append(s.played, string(data))
+	return err
+}
+
+func TestDialogControllerFallback(t *testing.T) {
+	unauthorized := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(403)
+		fmt.Fprint(w, `{"header":{"namespace":"System","name":"Exception"},"payload":{"code":"UNAUTHORIZED_REQUEST_EXCEPTION","description":"expired"}}`)
+	}))
+	defer unauthorized.Close()
+	invalid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		fmt.Fprint(w, `{"header":{"namespace":"System","name":"Exception"},"payload":{"code":"INVALID_REQUEST_EXCEPTION","description":"bad"}}`)
+	}))
+	defer invalid.Close()
+	offline := httptest.NewServer(http.NotFoundHandler())
+	offline.Close()
+
+	tests := []struct {
+		url    string
+		reason FallbackReason
+	}{
+		{offline.URL, FallbackOffline},
+		{unauthorized.URL, FallbackUnauthorized},
+		{invalid.URL, ""},
+	}
+	for _, test := range tests {
+		sink := new(recordingSink)
+		var reasons []FallbackReason
+		var c *DialogController
+		c = &DialogController{
+			Client:      &Client{EndpointURL: test.url},
+			AccessToken: "token",
+			Sink:        sink,
+			FallbackHandler: func(ctx context.Context, reason FallbackReason, err error, sink AudioSink) {
+				if err == nil || c.State() != DialogStateSpeaking {
+					t.Errorf("got %v in state %s", err, c.State())
+				}
+				reasons = append(reasons, reason)
+				sink.PlayAudio(ctx, strings.NewReader(string(reason)))
+			},
+		}
+		if _, err := c.Recognize(context.Background(), ioutil.NopCloser(strings.NewReader("hello"))); err == nil {
+			t.Errorf("%s: expected an error", test.url)
+		}
+		want := fmt.Sprint([]FallbackReason{test.reason})
+		if test.reason == "" {
+			want = "[]"
+		}
+		if got := fmt.Sprint(reasons); got != want || fmt.Sprint(sink.played) != want {
+			t.Errorf("%s: got reasons %s and played %s; want %s", test.url, got, sink.played, want)
+		}
+		if state := c.State(); state != DialogStateIdle {
+			t.Errorf("%s: got state %s; want IDLE", test.url, state)
+		}
+	}
+}
+
+func TestFallbackReason(t *testing.T) {
+	for _, err := range []error{context.Canceled, ErrShutdown, &RequestError{StatusCode: 500}, io.EOF} {
+		if reason, ok := fallbackReason(err); ok {
+			t.Errorf("%v: got %s", err, reason)
+		}
+	}
+	if reason, ok := fallbackReason(fmt.Errorf("post: %w", io.ErrUnexpectedEOF)); !ok || reason != FallbackOffline {
+		t.Errorf("got %s, %t; want OFFLINE", reason, ok)
+	}
+	if reason, ok := fallbackReason(&RequestError{StatusCode: 401}); !ok || reason != FallbackUnauthorized {
+		t.Errorf("got %s, %t; want UNAUTHORIZED", reason, ok)
+	}
+}
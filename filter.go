@@ -0,0 +1,76 @@
+package avs
+
+import "fmt"
+
+// FilterAction is what a Dispatcher does with a directive, as decided by its
+// filters.
+type FilterAction int
+
+// Possible values for FilterAction.
+const (
+	// The directive is dispatched as usual.
+	FilterAccept FilterAction = iota
+	// The directive is dropped silently, as if it had been handled.
+	FilterDrop
+	// The directive is reported with an UNSUPPORTED_OPERATION exception and
+	// Dispatch fails with ErrUnsupportedDirective, which aborts the rest of
+	// a response passed to DispatchResponse.
+	FilterReject
+)
+
+// String returns the name of the action.
+func (a FilterAction) String() string {
+	switch a {
+	case FilterAccept:
+		return "ACCEPT"
+	case FilterDrop:
+		return "DROP"
+	case FilterReject:
+		return "REJECT"
+	}
+	return fmt.Sprintf("FilterAction(%d)", int(a))
+}
+
+// A DirectiveFilter decides what a Dispatcher does with a directive before
+// it's routed to its handler. It's called with valid directives only.
+type DirectiveFilter func(directive TypedMessage) FilterAction
+
+// RejectNamespaces returns a filter that rejects the directives of the
+// namespaces, e.g. "AudioPlayer" and "PlaybackController" for a device that
+// doesn't play music.
+func RejectNamespaces(namespaces ...string) DirectiveFilter {
+	rejected := make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		rejected[namespace] = true
+	}
+	return func(directive TypedMessage) FilterAction {
+		if rejected[directive.GetMessage().header("namespace")] {
+			return FilterReject
+		}
+		return FilterAccept
+	}
+}
+
+// Applies the filters of the dispatcher to the directive. It returns whether
+// the directive should be dispatched and, if not, the error of Dispatch.
+func (d *Dispatcher) filter(m *Message) (bool, error) {
+	action := FilterAccept
+	for _, f := range d.Filters {
+		if action = f(m.Typed()); action != FilterAccept {
+			break
+		}
+	}
+	switch action {
+	case FilterAccept:
+		return true, nil
+	case FilterDrop:
+		d.Metrics.directiveFiltered(m, action)
+		d.logf("avs: dropping filtered directive %s (message id %s)", m, m.header("messageId"))
+		return false, nil
+	default:
+		d.Metrics.directiveFiltered(m, FilterReject)
+		d.logf("avs: rejecting filtered directive %s (message id %s)", m, m.header("messageId"))
+		d.reportException(m, ErrorTypeUnsupportedOperation, fmt.Sprintf("%s isn't supported", m))
+		return false, fmt.Errorf("%w: %s", ErrUnsupportedDirective, m)
+	}
+}
@@ -0,0 +1,61 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDispatcherFilters(t *testing.T) {
+	part := func(namespace, name, id string) string {
+		return "--------abcde123\r\nContent-Type: application/json\r\n\r\n" +
+			fmt.Sprintf(`{"directive":{"header":{"namespace":%q,"name":%q,"messageId":%q,"dialogRequestId":"d1"},"payload":{}}}`, namespace, name, id) +
+			"\r\n"
+	}
+	server := newResponseServer(part("Speaker", "SetVolume", "m1") + part("SpeechSynthesizer", "Speak", "m2") +
+		part("AudioPlayer", "Play", "m3") + part("SpeechRecognizer", "ExpectSpeech", "m4") + "--------abcde123--\r\n")
+	defer server.Close()
+	response, err := (&Client{EndpointURL: server.URL}).Do(NewRequest("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handled, filtered []string
+	var exceptions []*ExceptionEncountered
+	d := NewDispatcher()
+	d.Filters = []DirectiveFilter{
+		func(directive TypedMessage) FilterAction {
+			if directive.GetMessage().Type() == TypeSetVolume {
+				return FilterDrop
+			}
+			return FilterAccept
+		},
+		RejectNamespaces("AudioPlayer", "PlaybackController"),
+	}
+	d.Metrics = &Metrics{DirectiveFiltered: func(directive *Message, action FilterAction) {
+		filtered = append(filtered, fmt.Sprintf("%s %s", action, directive))
+	}}
+	d.ReportException = func(e *ExceptionEncountered) { exceptions = append(exceptions, e) }
+	for _, namespace := range []string{"Speaker", "SpeechSynthesizer", "AudioPlayer", "SpeechRecognizer"} {
+		d.HandleFunc(namespace, func(ctx context.Context, directive TypedMessage) error {
+			handled = append(handled, directive.GetMessage().String())
+			return nil
+		})
+	}
+
+	// The dropped SetVolume doesn't stop the Speak after it, while the
+	// rejected Play aborts the rest of the dialog.
+	if err := d.DispatchResponse(context.Background(), response); !errors.Is(err, ErrUnsupportedDirective) {
+		t.Errorf("got %v; want ErrUnsupportedDirective", err)
+	}
+	if fmt.Sprint(handled) != "[SpeechSynthesizer.Speak]" {
+		t.Errorf("handled %v; want [SpeechSynthesizer.Speak]", handled)
+	}
+	if fmt.Sprint(filtered) != "[DROP Speaker.SetVolume REJECT AudioPlayer.Play]" {
+		t.Errorf("metrics got %v", filtered)
+	}
+	if len(exceptions) != 1 || exceptions[0].Payload.Error.Type != ErrorTypeUnsupportedOperation {
+		t.Errorf("got exceptions %v; want one UNSUPPORTED_OPERATION", exceptions)
+	}
+}
@@ -0,0 +1,257 @@
+package avs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Channel is an audio focus channel. Only the active channel with the highest
+// priority is in the foreground; the other active channels are in the
+// background and should pause or duck their audio.
+type Channel string
+
+// The audio focus channels defined by AVS, from highest to lowest priority.
+const (
+	ChannelDialog  Channel = "Dialog"
+	ChannelAlerts  Channel = "Alerts"
+	ChannelContent Channel = "Content"
+)
+
+// The priority of each channel. Lower values have a higher priority.
+var channelPriority = map[Channel]int{
+	ChannelDialog:  0,
+	ChannelAlerts:  1,
+	ChannelContent: 2,
+}
+
+// FocusState specifies the focus of an observer on a channel.
+type FocusState string
+
+// Possible values for FocusState.
+const (
+	// The observer may play audio normally.
+	FocusStateForeground FocusState = "FOREGROUND"
+	// A higher priority channel is active; the observer should pause or duck.
+	FocusStateBackground FocusState = "BACKGROUND"
+	// The Dialog channel is active and the observer of the Content channel
+	// may keep playing with its volume lowered (see FocusManager.DuckContent).
+	FocusStateDucked FocusState = "DUCKED"
+	// The observer has lost the channel and must stop playing.
+	FocusStateNone FocusState = "NONE"
+)
+
+// FocusObserver is notified when its focus on a channel changes. Observers
+// are compared with ==, so they must be comparable (usually pointers).
+type FocusObserver interface {
+	FocusChanged(channel Channel, state FocusState)
+}
+
+// Ducker is implemented by the observers of the Content channel that can
+// lower their volume instead of pausing.
+type Ducker interface {
+	// SetDuck scales the volume by the level, from 0 to 1. A level of 1
+	// restores the volume.
+	SetDuck(level float64)
+}
+
+// DefaultDuckLevel is the level of the volume of ducked content, for the
+// FocusManagers that don't set one.
+const DefaultDuckLevel = 0.2
+
+// FocusManager arbitrates the audio focus channels between the components
+// that play audio. Each channel is held by at most one observer at a time.
+//
+// Observers are notified in order, never while the FocusManager is locked, so
+// they may acquire and release channels from within FocusChanged.
+type FocusManager struct {
+	// DuckContent makes the observer of the Content channel duck rather
+	// than pause while the Dialog channel is in the foreground, if it's a
+	// Ducker: SetDuck is called with the DuckLevel before it's notified of
+	// DUCKED, and with 1 after it's notified of its next state. Content is
+	// always in the background while the Alerts channel is active.
+	DuckContent bool
+	// DuckLevel is the level passed to SetDuck. Zero means
+	// DefaultDuckLevel.
+	DuckLevel float64
+
+	mu          sync.Mutex
+	holders     map[Channel]*focusHolder
+	pending     []focusChange
+	dispatching bool
+	shutdown    bool
+}
+
+type focusHolder struct {
+	observer FocusObserver
+	state    FocusState
+}
+
+// A notification of an observer: either a change of its focus or, with
+// duck set, a call to SetDuck.
+type focusChange struct {
+	observer FocusObserver
+	channel  Channel
+	state    FocusState
+	duck     bool
+	level    float64
+}
+
+// NewFocusManager returns a FocusManager with all channels free.
+func NewFocusManager() *FocusManager {
+	return &FocusManager{holders: make(map[Channel]*focusHolder)}
+}
+
+// AcquireChannel gives the channel to the observer. If another observer is
+// holding the channel, it's notified of NONE. The observer is then notified of
+// FOREGROUND or BACKGROUND depending on the other active channels.
+func (m *FocusManager) AcquireChannel(channel Channel, observer FocusObserver) error {
+	if _, ok := channelPriority[channel]; !ok {
+		return fmt.Errorf("avs: unknown focus channel %q", channel)
+	}
+	m.mu.Lock()
+	if m.shutdown {
+		m.mu.Unlock()
+		return ErrShutdown
+	}
+	if h, ok := m.holders[channel]; ok {
+		if h.observer == observer {
+			m.mu.Unlock()
+			return nil
+		}
+		m.notify(h, channel, FocusStateNone)
+	}
+	m.holders[channel] = &focusHolder{observer: observer, state: FocusStateNone}
+	m.update()
+	m.mu.Unlock()
+	m.dispatch()
+	return nil
+}
+
+// ReleaseChannel releases the channel if it's held by the observer, which is
+// then notified of NONE. The next active channel is brought to the
+// foreground. It returns whether the observer was holding the channel.
+func (m *FocusManager) ReleaseChannel(channel Channel, observer FocusObserver) bool {
+	m.mu.Lock()
+	h, ok := m.holders[channel]
+	if !ok || h.observer != observer {
+		m.mu.Unlock()
+		return false
+	}
+	delete(m.holders, channel)
+	m.notify(h, channel, FocusStateNone)
+	m.update()
+	m.mu.Unlock()
+	m.dispatch()
+	return true
+}
+
+// Shutdown releases every channel, notifying the holders of NONE from the
+// lowest priority channel up. AcquireChannel returns ErrShutdown afterwards.
+func (m *FocusManager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.shutdown = true
+	for _, channel := range []Channel{ChannelContent, ChannelAlerts, ChannelDialog} {
+		if h, ok := m.holders[channel]; ok {
+			delete(m.holders, channel)
+			m.notify(h, channel, FocusStateNone)
+		}
+	}
+	m.mu.Unlock()
+	m.dispatch()
+	return nil
+}
+
+// Foreground returns the channel currently in the foreground, or an empty
+// Channel if no channel is active.
+func (m *FocusManager) Foreground() Channel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.foreground()
+}
+
+// State returns the focus state of a channel.
+func (m *FocusManager) State(channel Channel) FocusState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if h, ok := m.holders[channel]; ok {
+		return h.state
+	}
+	return FocusStateNone
+}
+
+// Returns the active channel with the highest priority. The lock must be held.
+func (m *FocusManager) foreground() Channel {
+	var fg Channel
+	for channel := range m.holders {
+		if fg == "" || channelPriority[channel] < channelPriority[fg] {
+			fg = channel
+		}
+	}
+	return fg
+}
+
+// Recomputes the state of every holder and queues the notifications for the
+// ones that changed. The lock must be held.
+func (m *FocusManager) update() {
+	fg := m.foreground()
+	_, alerts := m.holders[ChannelAlerts]
+	// Notify in priority order so that the background transitions are seen
+	// before the new foreground one.
+	for _, channel := range []Channel{ChannelContent, ChannelAlerts, ChannelDialog} {
+		h, ok := m.holders[channel]
+		if !ok {
+			continue
+		}
+		state := FocusStateBackground
+		if channel == fg {
+			state = FocusStateForeground
+		} else if _, ducker := h.observer.(Ducker); channel == ChannelContent && fg == ChannelDialog && !alerts && m.DuckContent && ducker {
+			state = FocusStateDucked
+		}
+		m.notify(h, channel, state)
+	}
+}
+
+// Queues the notifications of a change of state of the holder, with the
+// calls to SetDuck around ducking. The lock must be held.
+func (m *FocusManager) notify(h *focusHolder, channel Channel, state FocusState) {
+	if state == h.state {
+		return
+	}
+	if state == FocusStateDucked {
+		level := m.DuckLevel
+		if level == 0 {
+			level = DefaultDuckLevel
+		}
+		m.pending = append(m.pending, focusChange{observer: h.observer, channel: channel, duck: true, level: level})
+	}
+	m.pending = append(m.pending, focusChange{observer: h.observer, channel: channel, state: state})
+	if h.state == FocusStateDucked {
+		m.pending = append(m.pending, focusChange{observer: h.observer, channel: channel, duck: true, level: 1})
+	}
+	h.state = state
+}
+
+// Delivers the queued notifications unless another call is already doing so.
+func (m *FocusManager) dispatch() {
+	m.mu.Lock()
+	if m.dispatching {
+		m.mu.Unlock()
+		return
+	}
+	m.dispatching = true
+	for len(m.pending) > 0 {
+		change := m.pending[0]
+		m.pending = m.pending[1:]
+		m.mu.Unlock()
+		if change.duck {
+			change.observer.(Ducker).SetDuck(change.level)
+		} else {
+			change.observer.FocusChanged(change.channel, change.state)
+		}
+		m.mu.Lock()
+	}
+	m.dispatching = false
+	m.mu.Unlock()
+}
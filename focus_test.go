@@ -0,0 +1,146 @@
+package avs
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type recordingObserver struct {
+	name   string
+	events *[]string
+	onNone func()
+}
+
+func (o *recordingObserver) FocusChanged(channel Channel, state FocusState) {
+	*o.events = append(*o.events, fmt.Sprintf("%s %s %s", o.name, channel, state))
+	if state == FocusStateNone && o.onNone != nil {
+		o.onNone()
+	}
+}
+
+func TestFocusManager(t *testing.T) {
+	var events []string
+	m := NewFocusManager()
+	music := &recordingObserver{name: "music", events: &events}
+	speech := &recordingObserver{name: "speech", events: &events}
+	alarm := &recordingObserver{name: "alarm", events: &events}
+
+	m.AcquireChannel(ChannelContent, music)
+	m.AcquireChannel(ChannelDialog, speech)
+	m.AcquireChannel(ChannelAlerts, alarm)
+	if fg := m.Foreground(); fg != ChannelDialog {
+		t.Errorf("foreground = %q, want %q", fg, ChannelDialog)
+	}
+	m.ReleaseChannel(ChannelDialog, speech)
+	m.ReleaseChannel(ChannelAlerts, alarm)
+	if m.ReleaseChannel(ChannelAlerts, alarm) {
+		t.Error("releasing a free channel should return false")
+	}
+	want := []string{
+		"music Content FOREGROUND",
+		"music Content BACKGROUND",
+		"speech Dialog FOREGROUND",
+		"alarm Alerts BACKGROUND",
+		"speech Dialog NONE",
+		"alarm Alerts FOREGROUND",
+		"alarm Alerts NONE",
+		"music Content FOREGROUND",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got events\n%q\nwant\n%q", events, want)
+	}
+	if err := m.AcquireChannel("Visual", music); err == nil {
+		t.Error("acquiring an unknown channel should fail")
+	}
+}
+
+func TestFocusManagerReentrant(t *testing.T) {
+	var events []string
+	m := NewFocusManager()
+	first := &recordingObserver{name: "first", events: &events}
+	second := &recordingObserver{name: "second", events: &events}
+	// Acquiring a channel from an observer must not deadlock.
+	first.onNone = func() { m.AcquireChannel(ChannelAlerts, first) }
+	m.AcquireChannel(ChannelContent, first)
+	m.AcquireChannel(ChannelContent, second)
+	want := []string{
+		"first Content FOREGROUND",
+		"first Content NONE",
+		"second Content FOREGROUND",
+		"second Content BACKGROUND",
+		"first Alerts FOREGROUND",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got events\n%q\nwant\n%q", events, want)
+	}
+	if state := m.State(ChannelContent); state != FocusStateBackground {
+		t.Errorf("state = %q, want %q", state, FocusStateBackground)
+	}
+}
+
+type duckingObserver struct {
+	recordingObserver
+}
+
+func (o *duckingObserver) SetDuck(level float64) {
+	*o.events = append(*o.events, fmt.Sprintf("%s volume %.1f", o.name, level))
+}
+
+func TestFocusManagerDucking(t *testing.T) {
+	var events []string
+	m := NewFocusManager()
+	m.DuckContent = true
+	music := &duckingObserver{recordingObserver{name: "music", events: &events}}
+	speech := &recordingObserver{name: "speech", events: &events}
+	alarm := &recordingObserver{name: "alarm", events: &events}
+
+	// A short question and answer over music ducks it.
+	m.AcquireChannel(ChannelContent, music)
+	m.AcquireChannel(ChannelDialog, speech)
+	if state := m.State(ChannelContent); state != FocusStateDucked {
+		t.Errorf("content is %s during the dialog, want DUCKED", state)
+	}
+	m.ReleaseChannel(ChannelDialog, speech)
+	// An alert pauses it even during a dialog.
+	m.AcquireChannel(ChannelDialog, speech)
+	m.AcquireChannel(ChannelAlerts, alarm)
+	m.ReleaseChannel(ChannelAlerts, alarm)
+	m.ReleaseChannel(ChannelDialog, speech)
+	want := []string{
+		"music Content FOREGROUND",
+		"music volume 0.2",
+		"music Content DUCKED",
+		"speech Dialog FOREGROUND",
+		"speech Dialog NONE",
+		"music Content FOREGROUND",
+		"music volume 1.0",
+		"music volume 0.2",
+		"music Content DUCKED",
+		"speech Dialog FOREGROUND",
+		"music Content BACKGROUND",
+		"music volume 1.0",
+		"alarm Alerts BACKGROUND",
+		"alarm Alerts NONE",
+		"music volume 0.2",
+		"music Content DUCKED",
+		"speech Dialog NONE",
+		"music Content FOREGROUND",
+		"music volume 1.0",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got events\n%q\nwant\n%q", events, want)
+	}
+
+	// Observers that can't duck pause.
+	events = nil
+	m = NewFocusManager()
+	m.DuckContent = true
+	m.DuckLevel = 0.5
+	plain := &recordingObserver{name: "plain", events: &events}
+	m.AcquireChannel(ChannelContent, plain)
+	m.AcquireChannel(ChannelDialog, speech)
+	if state := m.State(ChannelContent); state != FocusStateBackground {
+		t.Errorf("content without a Ducker is %s, want BACKGROUND", state)
+	}
+}
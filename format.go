@@ -0,0 +1,168 @@
+package avs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Redaction replaces the values removed by Message.Redacted.
+const Redaction = "███"
+
+// RedactedFields are the payload fields, at any depth, whose values are
+// replaced by Message.Redacted. They hold tokens, signed URLs and what the
+// user said. They're global: changing them affects the logs of every client,
+// and should only be done before any is used.
+var RedactedFields = []string{
+	"token",
+	"expectedPreviousToken",
+	"url",
+	"endpoint",
+	"unparsedDirective",
+	"text",
+}
+
+// Summarizer is implemented by typed messages that describe their payload
+// in FormatMessage.
+type Summarizer interface {
+	// Summarize returns a one-line summary of the payload.
+	Summarize() string
+}
+
+// Redacted returns a copy of the message for logs, in which the values of the
+// RedactedFields in the payload are replaced by Redaction. Payloads that
+// aren't valid JSON are redacted entirely.
+func (m *Message) Redacted() *Message {
+	c := m.Clone()
+	if c == nil || len(c.Payload) == 0 {
+		return c
+	}
+	var payload interface{}
+	if err := unmarshalJSON(c.Payload, &payload); err != nil {
+		c.Payload, _ = codec().Marshal(Redaction)
+		return c
+	}
+	fields := make(map[string]bool, len(RedactedFields))
+	for _, f := range RedactedFields {
+		fields[f] = true
+	}
+	c.Payload, _ = codec().Marshal(redact(payload, fields))
+	return c
+}
+
+func redact(v interface{}, fields map[string]bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, value := range v {
+			if fields[k] {
+				v[k] = Redaction
+			} else {
+				v[k] = redact(value, fields)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redact(value, fields)
+		}
+	}
+	return v
+}
+
+// FormatMessage returns a line describing the message for logs, with its
+// namespace and name, message id, dialog request id, sequence number and a
+// summary of the payload, e.g.:
+//
+//	AudioPlayer.Play messageId=m1: REPLACE_ALL token=abc offset=0s
+//
+// Typed messages that implement Summarizer provide their own summary. The
+// built-in summaries leave out the values of the RedactedFields too, and for
+// other messages the redacted payload is shown.
+func FormatMessage(msg TypedMessage) string {
+	if msg == nil || msg.GetMessage() == nil {
+		return "<nil>"
+	}
+	m := msg.GetMessage()
+	var b strings.Builder
+	b.WriteString(m.String())
+	if id := m.header("messageId"); id != "" {
+		fmt.Fprintf(&b, " messageId=%s", id)
+	}
+	if id := m.header("dialogRequestId"); id != "" {
+		fmt.Fprintf(&b, " dialogRequestId=%s", id)
+	}
+	if m.sequence != 0 {
+		fmt.Fprintf(&b, " seq=%d", m.sequence)
+	}
+	if summary := summarize(msg); summary != "" {
+		b.WriteString(": ")
+		b.WriteString(summary)
+	}
+	return b.String()
+}
+
+// The longest payload shown by FormatMessage for messages without a summary.
+const maxFormattedPayload = 120
+
+func summarize(msg TypedMessage) string {
+	if typed, ok := msg.(*Message); ok {
+		msg = typed.Typed()
+	}
+	switch d := msg.(type) {
+	case Summarizer:
+		return d.Summarize()
+	case *Play:
+		s := d.Payload.AudioItem.Stream
+		return fmt.Sprintf("%s token=%s offset=%s", d.Payload.PlayBehavior, summaryField("token", s.Token), time.Duration(s.OffsetInMilliseconds)*time.Millisecond)
+	case *ClearQueue:
+		return string(d.Payload.ClearBehavior)
+	case *Speak:
+		return fmt.Sprintf("%s token=%s", d.Payload.Format, summaryField("token", d.Payload.Token))
+	case *SetAlert:
+		return fmt.Sprintf("%s token=%s at %s", d.Payload.Type, summaryField("token", d.Payload.Token), d.Payload.ScheduledTime)
+	case *DeleteAlert:
+		return "token=" + summaryField("token", d.Payload.Token)
+	case *SetVolume:
+		return fmt.Sprintf("volume=%d", d.Payload.Volume)
+	case *AdjustVolume:
+		return fmt.Sprintf("volume%+d", d.Payload.Volume)
+	case *SetMute:
+		return fmt.Sprintf("mute=%t", d.Payload.Mute)
+	case *ExpectSpeech:
+		return fmt.Sprintf("timeout=%s", d.Timeout())
+	case *Exception:
+		return d.Error()
+	}
+	// Typed messages keep their payload in their own struct, so it's taken
+	// from their encoding.
+	data, _ := codec().Marshal(msg)
+	var envelope struct {
+		Payload json.RawMessage `json:"payload"`
+	}
+	codec().Unmarshal(data, &envelope)
+	payload := (&Message{Payload: envelope.Payload}).Redacted().Payload
+	s := string(payload)
+	if s == "{}" || s == "null" {
+		return ""
+	}
+	if len(s) > maxFormattedPayload {
+		n := maxFormattedPayload
+		for !utf8.RuneStart(s[n]) {
+			n--
+		}
+		s = s[:n] + "…"
+	}
+	return s
+}
+
+// Returns the value of the payload field for a built-in summary, or
+// Redaction if the field is one of the RedactedFields.
+func summaryField(name, value string) string {
+	for _, f := range RedactedFields {
+		if f == name {
+			return Redaction
+		}
+	}
+	return value
+}
@@ -0,0 +1,71 @@
+package avs
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+type summarizedMessage struct {
+	*Message
+}
+
+func (m *summarizedMessage) Summarize() string { return "custom" }
+
+func TestRedacted(t *testing.T) {
+	m := &Message{
+		Header:  map[string]string{"namespace": "AudioPlayer", "name": "Play", "messageId": "m1"},
+		Payload: json.RawMessage(`{"playBehavior":"ENQUEUE","audioItem":{"stream":{"url":"https://example.com/a.mp3?Signature=abc","token":"secret","offsetInMilliseconds":0}}}`),
+	}
+	r := m.Redacted()
+	want := `{"audioItem":{"stream":{"offsetInMilliseconds":0,"token":"███","url":"███"}},"playBehavior":"ENQUEUE"}`
+	if !jsonEqual(t, r.Payload, []byte(want)) {
+		t.Errorf("got %s; want %s", r.Payload, want)
+	}
+	if !strings.Contains(string(m.Payload), "secret") {
+		t.Error("Redacted modified the original message")
+	}
+	alerts := NewAlertsState([]Alert{{Token: "a1"}, {Token: "a2"}}, []Alert{})
+	data, _ := json.Marshal(alerts)
+	var parsed Message
+	json.Unmarshal(data, &parsed)
+	if p := string(parsed.Redacted().Payload); strings.Contains(p, "a1") || strings.Contains(p, "a2") {
+		t.Errorf("tokens in arrays weren't redacted: %s", p)
+	}
+	if p := string((&Message{Payload: json.RawMessage("not json")}).Redacted().Payload); p != `"███"` {
+		t.Errorf("invalid payload should be redacted entirely, got %s", p)
+	}
+}
+
+func TestFormatMessage(t *testing.T) {
+	var play Message
+	json.Unmarshal([]byte(`{"header":{"namespace":"AudioPlayer","name":"Play","messageId":"m1","dialogRequestId":"d1"},`+
+		`"payload":{"playBehavior":"REPLACE_ALL","audioItem":{"stream":{"token":"abc","offsetInMilliseconds":1500}}}}`), &play)
+	tests := []struct {
+		msg  TypedMessage
+		want string
+	}{
+		{&play, "AudioPlayer.Play messageId=m1 dialogRequestId=d1: REPLACE_ALL token=███ offset=1.5s"},
+		{play.Typed(), "AudioPlayer.Play messageId=m1 dialogRequestId=d1: REPLACE_ALL token=███ offset=1.5s"},
+		{NewPlaybackStarted("m2", "abc", 3*time.Second), `AudioPlayer.PlaybackStarted messageId=m2: {"offsetInMilliseconds":3000,"token":"███"}`},
+		{NewPlayCommandIssued("m3"), "PlaybackController.PlayCommandIssued messageId=m3"},
+		{&summarizedMessage{&Message{Header: map[string]string{"namespace": "Foo", "name": "Bar"}}}, "Foo.Bar: custom"},
+		{nil, "<nil>"},
+	}
+	for _, test := range tests {
+		if got := FormatMessage(test.msg); got != test.want {
+			t.Errorf("got %q; want %q", got, test.want)
+		}
+	}
+	// The built-in summaries follow RedactedFields.
+	defer func(fields []string) { RedactedFields = fields }(RedactedFields)
+	RedactedFields = nil
+	if got, want := FormatMessage(&play), "AudioPlayer.Play messageId=m1 dialogRequestId=d1: REPLACE_ALL token=abc offset=1.5s"; got != want {
+		t.Errorf("got %q without redacted fields; want %q", got, want)
+	}
+	long := &Message{Header: map[string]string{"namespace": "Foo", "name": "Bar"}, Payload: json.RawMessage(`{"token":"` + strings.Repeat("x", 50) + `","list":[` + strings.Repeat(`"███",`, 40) + `1]}`)}
+	if got := FormatMessage(long); len(got) > 200 || !strings.HasSuffix(got, "…") {
+		t.Errorf("long payload wasn't truncated: %s", got)
+	}
+}
@@ -0,0 +1,78 @@
+package avs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs/multipart2"
+)
+
+// Exercises the accessors of a typed message so that they're covered by the
+// fuzzers too.
+func useTyped(t *testing.T, typed TypedMessage) {
+	_ = typed.GetMessage().String()
+	switch d := typed.(type) {
+	case *ExpectSpeech:
+		_ = d.Timeout()
+	case *Speak:
+		_ = d.ContentId()
+	case *Exception:
+		_ = d.Error()
+	}
+	if _, err := json.Marshal(typed); err != nil {
+		t.Fatalf("could not encode %T: %v", typed, err)
+	}
+}
+
+func FuzzMessage(f *testing.F) {
+	f.Add([]byte(speakDirective))
+	f.Add([]byte(`{"header":{"namespace":"System","name":"Exception"},"payload":{"code":"THROTTLING_EXCEPTION","description":"slow down"}}`))
+	f.Add([]byte(`{"header":null,"payload":null}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var m Message
+		if err := json.Unmarshal(data, &m); err == nil {
+			useTyped(t, m.Typed())
+		}
+		typed, err := TypedFromReader(bytes.NewReader(data))
+		if err == nil && typed != nil {
+			useTyped(t, typed)
+			useTyped(t, typed.GetMessage().Typed())
+		}
+	})
+}
+
+func FuzzReadResponse(f *testing.F) {
+	const boundary = "------abcde123"
+	f.Add(fmt.Sprintf("--%s\r\nContent-Type: application/json\r\n\r\n{\"directive\":%s}\r\n"+
+		"--%s\r\nContent-ID: <abc>\r\nContent-Type: application/octet-stream\r\n\r\n\xff\xfb\x90\x64\r\n--%s--\r\n",
+		boundary, speakDirective, boundary, boundary))
+	f.Add(fmt.Sprintf("--%s\r\nContent-ID: >\r\nContent-Type: application/octet-stream\r\n\r\n\r\n--%s--\r\n", boundary, boundary))
+	// A part truncated mid-body (see testdata/fuzz/FuzzReadResponse).
+	f.Add(fmt.Sprintf("--%s\r\nContent-ID: <abc>\r\nContent-Type: application/octet-stream\r\n\r\n\xff\xfb", boundary))
+	f.Fuzz(func(t *testing.T, body string) {
+		for _, threshold := range []int{-1, 16} {
+			response := &Response{Content: map[string][]byte{}}
+			mr := multipart2.NewReader(strings.NewReader(body), boundary)
+			// A hang is a failure, rather than a stuck fuzzer.
+			done := make(chan error, 1)
+			go func() {
+				done <- readResponse(mr, response, threshold, DefaultLimits)
+			}()
+			select {
+			case err := <-done:
+				if err != nil {
+					continue
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("threshold %d: readResponse didn't return", threshold)
+			}
+			for _, d := range response.Directives {
+				useTyped(t, d.Typed())
+			}
+		}
+	})
+}
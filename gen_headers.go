@@ -0,0 +1,75 @@
+//go:build ignore
+
+// This program generates headers_gen.go, which has the accessors of the
+// header fields that only some messages have, and the table used by
+// Message.Validate to check the required ones. Run it with go generate.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// The header fields defined by the AVS documentation for specific messages.
+var fields = []struct {
+	Namespace, Name string
+	// The Go type of the message, if it's not Name.
+	Type     string
+	Field    string
+	Required bool
+}{
+	{Namespace: "Alexa", Name: "EventProcessed", Field: "eventCorrelationToken", Required: true},
+	{Namespace: "Alexa", Name: "ReportState", Field: "correlationToken", Required: true},
+	{Namespace: "Alexa", Name: "StateReport", Field: "correlationToken", Required: true},
+	{Namespace: "AudioPlayer", Name: "Play", Field: "dialogRequestId"},
+	{Namespace: "SpeechRecognizer", Name: "ExpectSpeech", Field: "dialogRequestId"},
+	{Namespace: "SpeechRecognizer", Name: "Recognize", Field: "dialogRequestId", Required: true},
+	{Namespace: "SpeechRecognizer", Name: "StopCapture", Field: "dialogRequestId"},
+	{Namespace: "SpeechSynthesizer", Name: "Speak", Field: "dialogRequestId"},
+}
+
+func main() {
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by gen_headers.go; DO NOT EDIT.\n\npackage avs\n")
+	for _, f := range fields {
+		typ := f.Type
+		if typ == "" {
+			typ = f.Name
+		}
+		method := strings.ToUpper(f.Field[:1]) + f.Field[1:]
+		kind := "optional"
+		if f.Required {
+			kind = "required"
+		}
+		fmt.Fprintf(&buf, "\n// %s returns the %s header field of the message\n// (%s), and whether it's set.\n", method, f.Field, kind)
+		fmt.Fprintf(&buf, "func (m *%s) %s() (string, bool) {\n\treturn m.headerField(%q)\n}\n", typ, method, f.Field)
+	}
+	var types []string
+	required := make(map[string][]string)
+	for _, f := range fields {
+		if !f.Required {
+			continue
+		}
+		key := fmt.Sprintf("{%q, %q}", f.Namespace, f.Name)
+		if required[key] == nil {
+			types = append(types, key)
+		}
+		required[key] = append(required[key], fmt.Sprintf("%q", f.Field))
+	}
+	buf.WriteString("\n// The header fields that messages must have, besides namespace and name.\nvar requiredHeaderFields = map[MessageType][]string{\n")
+	for _, key := range types {
+		fmt.Fprintf(&buf, "\t%s: {%s},\n", key, strings.Join(required[key], ", "))
+	}
+	buf.WriteString("}\n")
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("headers_gen.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
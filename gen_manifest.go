@@ -0,0 +1,132 @@
+//go:build ignore
+
+// This program generates manifest_gen.go, which lists the message structs of
+// the package (the structs that embed *Message) by the file that declares
+// them. It fails if a struct isn't registered by the init function of its
+// file, so that Typed can't miss it. Run it with go generate.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+func main() {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		name := fi.Name()
+		return !strings.HasSuffix(name, "_test.go") && !strings.HasPrefix(name, "gen_") && !strings.HasSuffix(name, "_gen.go")
+	}, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pkg := pkgs["avs"]
+	if pkg == nil {
+		log.Fatal("no avs package in the current directory")
+	}
+	var files []string
+	for name := range pkg.Files {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by gen_manifest.go; DO NOT EDIT.\n\npackage avs\n\n")
+	buf.WriteString("// The message structs of the package by the file that declares and\n// registers them.\n")
+	buf.WriteString("var manifest = map[string][]TypedMessage{\n")
+	failed := false
+	for _, name := range files {
+		file := pkg.Files[name]
+		structs := messageStructs(file)
+		if len(structs) == 0 {
+			continue
+		}
+		registered := registeredTypes(file)
+		fmt.Fprintf(&buf, "\t%q: {\n", name)
+		for _, s := range structs {
+			if !registered[s] {
+				fmt.Fprintf(os.Stderr, "%s: %s embeds *Message but isn't registered by the init function of the file\n", name, s)
+				failed = true
+			}
+			fmt.Fprintf(&buf, "\t\t(*%s)(nil),\n", s)
+		}
+		buf.WriteString("\t},\n")
+	}
+	buf.WriteString("}\n")
+	if failed {
+		os.Exit(1)
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("manifest_gen.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Returns the names of the structs of the file that embed *Message, in order.
+func messageStructs(file *ast.File) []string {
+	var names []string
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			spec := spec.(*ast.TypeSpec)
+			if st, ok := spec.Type.(*ast.StructType); ok && embedsMessage(st) {
+				names = append(names, spec.Name.Name)
+			}
+		}
+	}
+	return names
+}
+
+func embedsMessage(st *ast.StructType) bool {
+	for _, field := range st.Fields.List {
+		if star, ok := field.Type.(*ast.StarExpr); ok && len(field.Names) == 0 {
+			if ident, ok := star.X.(*ast.Ident); ok && ident.Name == "Message" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the structs registered by the init functions of the file, from
+// calls such as register(TypeSpeak, Speak{}).
+func registeredTypes(file *ast.File) map[string]bool {
+	registered := make(map[string]bool)
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || fn.Name.Name != "init" {
+			continue
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) != 2 {
+				return true
+			}
+			if f, ok := call.Fun.(*ast.Ident); !ok || (f.Name != "register" && f.Name != "registerDirective") {
+				return true
+			}
+			if lit, ok := call.Args[1].(*ast.CompositeLit); ok {
+				if ident, ok := lit.Type.(*ast.Ident); ok {
+					registered[ident.Name] = true
+				}
+			}
+			return true
+		})
+	}
+	return registered
+}
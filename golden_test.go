@@ -0,0 +1,122 @@
+package avs
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/messages")
+
+// Returns the path of the golden file for the message type.
+func goldenPath(t MessageType) string {
+	return filepath.Join("testdata", "messages", t.Key()+".json")
+}
+
+// Decodes a message into its registered Go type through Typed and encodes it
+// again.
+func roundTrip(t *testing.T, typ MessageType, data []byte) []byte {
+	var m Message
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("%s: %v", typ, err)
+	}
+	if m.Type() != typ {
+		t.Fatalf("%s: golden file is for %s", typ, m.Type())
+	}
+	typed := m.Typed()
+	if got, want := reflect.TypeOf(typed).Elem(), registry[typ]; got != want {
+		t.Fatalf("%s: got %s; want %s", typ, got, want)
+	}
+	out, err := json.Marshal(typed)
+	if err != nil {
+		t.Fatalf("%s: %v", typ, err)
+	}
+	return out
+}
+
+func TestMessageGolden(t *testing.T) {
+	known := make(map[string]bool)
+	for _, typ := range RegisteredTypes() {
+		path := goldenPath(typ)
+		known[filepath.Base(path)] = true
+		golden, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) && *update {
+			// Start from an empty message, to be filled in by hand.
+			golden, _ = json.Marshal(&Message{Header: map[string]string{"namespace": typ.Namespace, "name": typ.Name}})
+		} else if os.IsNotExist(err) {
+			t.Errorf("%s has no golden file; run go test -run TestMessageGolden -update and fill in %s", typ, path)
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got := roundTrip(t, typ, golden)
+		if *update {
+			var buf bytes.Buffer
+			json.Indent(&buf, got, "", "  ")
+			buf.WriteByte('\n')
+			if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if !jsonEqual(t, golden, got) {
+			t.Errorf("%s doesn't round trip:\n got: %s\nwant: %s", typ, got, golden)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join("testdata", "messages", "*.json"))
+	for _, file := range files {
+		if !known[filepath.Base(file)] {
+			t.Errorf("golden file %s isn't for a registered message type", file)
+		}
+	}
+}
+
+// The doc vectors in testdata/docs/<namespace>/<version> are synthetic: they
+// follow the shape of the examples of the AVS documentation, with made-up ids,
+// tokens and URLs (see testdata/docs/README). There is one for every
+// registered message type, under the version of its interface that the
+// package targets, and each must round trip through its typed message.
+func TestDocVectors(t *testing.T) {
+	covered := make(map[MessageType]bool)
+	dirs, _ := filepath.Glob(filepath.Join("testdata", "docs", "*", "*"))
+	for _, dir := range dirs {
+		namespace, version := filepath.Base(filepath.Dir(dir)), filepath.Base(dir)
+		v, err := ParseInterfaceVersion(version)
+		if err != nil {
+			t.Errorf("%s: %v", dir, err)
+			continue
+		}
+		if target, ok := CurrentVersion(namespace); !ok {
+			t.Errorf("%s: %s has no current version", dir, namespace)
+		} else if v != target {
+			t.Errorf("%s: doc vectors are for %s %s; the package targets %s", dir, namespace, v, target)
+		}
+		files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		for _, file := range files {
+			typ := MessageType{namespace, strings.TrimSuffix(filepath.Base(file), ".json")}
+			if registry[typ] == nil {
+				t.Errorf("%s isn't for a registered message type", file)
+				continue
+			}
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := roundTrip(t, typ, data); !jsonEqual(t, data, got) {
+				t.Errorf("%s doesn't round trip:\n got: %s\nwant: %s", file, got, data)
+			}
+			covered[typ] = true
+		}
+	}
+	for _, typ := range RegisteredTypes() {
+		if !covered[typ] {
+			t.Errorf("%s has no doc vector in testdata/docs/%s", typ, typ.Namespace)
+		}
+	}
+}
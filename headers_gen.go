@@ -0,0 +1,59 @@
+// Code generated by gen_headers.go; DO NOT EDIT.
+
+package avs
+
+// EventCorrelationToken returns the eventCorrelationToken header field of the message
+// (required), and whether it's set.
+func (m *EventProcessed) EventCorrelationToken() (string, bool) {
+	return m.headerField("eventCorrelationToken")
+}
+
+// CorrelationToken returns the correlationToken header field of the message
+// (required), and whether it's set.
+func (m *ReportState) CorrelationToken() (string, bool) {
+	return m.headerField("correlationToken")
+}
+
+// CorrelationToken returns the correlationToken header field of the message
+// (required), and whether it's set.
+func (m *StateReport) CorrelationToken() (string, bool) {
+	return m.headerField("correlationToken")
+}
+
+// DialogRequestId returns the dialogRequestId header field of the message
+// (optional), and whether it's set.
+func (m *Play) DialogRequestId() (string, bool) {
+	return m.headerField("dialogRequestId")
+}
+
+// DialogRequestId returns the dialogRequestId header field of the message
+// (optional), and whether it's set.
+func (m *ExpectSpeech) DialogRequestId() (string, bool) {
+	return m.headerField("dialogRequestId")
+}
+
+// DialogRequestId returns the dialogRequestId header field of the message
+// (required), and whether it's set.
+func (m *Recognize) DialogRequestId() (string, bool) {
+	return m.headerField("dialogRequestId")
+}
+
+// DialogRequestId returns the dialogRequestId header field of the message
+// (optional), and whether it's set.
+func (m *StopCapture) DialogRequestId() (string, bool) {
+	return m.headerField("dialogRequestId")
+}
+
+// DialogRequestId returns the dialogRequestId header field of the message
+// (optional), and whether it's set.
+func (m *Speak) DialogRequestId() (string, bool) {
+	return m.headerField("dialogRequestId")
+}
+
+// The header fields that messages must have, besides namespace and name.
+var requiredHeaderFields = map[MessageType][]string{
+	{"Alexa", "EventProcessed"}:       {"eventCorrelationToken"},
+	{"Alexa", "ReportState"}:          {"correlationToken"},
+	{"Alexa", "StateReport"}:          {"correlationToken"},
+	{"SpeechRecognizer", "Recognize"}: {"dialogRequestId"},
+}
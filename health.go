@@ -0,0 +1,148 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Health is a snapshot of the state of the connection of a Client with AVS,
+// e.g. to expose on a health endpoint or to drive a status LED.
+type Health struct {
+	// Connected reports whether a downchannel is open and the last request
+	// to AVS didn't fail to connect.
+	Connected bool
+	// When the last ping was sent, how long its response took and whether it
+	// failed.
+	LastPing        time.Time
+	LastPingLatency time.Duration
+	LastPingErr     error
+	// When AVS last accepted an event.
+	LastEventSuccess time.Time
+	// How long the current downchannel has been open, or zero if there is
+	// none.
+	DownchannelAge time.Duration
+	// The number of events being sent, including those waiting for the rate
+	// limiter.
+	QueuedEvents int
+}
+
+// The health of a Client, updated as it makes requests.
+type clientHealth struct {
+	queued int32
+
+	mu               sync.Mutex
+	connErr          error
+	lastPing         time.Time
+	lastPingLatency  time.Duration
+	lastPingErr      error
+	lastEventSuccess time.Time
+	downchannel      *Downchannel
+}
+
+// Records the outcome of a request. Only connection errors mark the client
+// as disconnected; AVS rejecting a request means that it's reachable.
+func (h *clientHealth) requestDone(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil || !errors.Is(err, ErrNotConnected) {
+		h.connErr = nil
+	} else {
+		h.connErr = err
+	}
+}
+
+func (h *clientHealth) eventSucceeded(at time.Time) {
+	h.requestDone(nil)
+	h.mu.Lock()
+	h.lastEventSuccess = at
+	h.mu.Unlock()
+}
+
+func (h *clientHealth) pinged(at time.Time, latency time.Duration, err error) {
+	h.requestDone(err)
+	h.mu.Lock()
+	h.lastPing, h.lastPingLatency, h.lastPingErr = at, latency, err
+	h.mu.Unlock()
+}
+
+func (h *clientHealth) downchannelOpened(d *Downchannel) {
+	h.requestDone(nil)
+	h.mu.Lock()
+	h.downchannel = d
+	h.mu.Unlock()
+}
+
+// Returns the downchannel that is open, if any.
+func (h *clientHealth) openDownchannel() *Downchannel {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.downchannel
+}
+
+func (h *clientHealth) downchannelClosed(d *Downchannel) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.downchannel == d {
+		h.downchannel = nil
+	}
+}
+
+// Health returns a snapshot of the state of the connection with AVS.
+func (c *Client) Health() Health {
+	h := &c.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	health := Health{
+		Connected:        h.downchannel != nil && h.connErr == nil,
+		LastPing:         h.lastPing,
+		LastPingLatency:  h.lastPingLatency,
+		LastPingErr:      h.lastPingErr,
+		LastEventSuccess: h.lastEventSuccess,
+		QueuedEvents:     int(atomic.LoadInt32(&h.queued)),
+	}
+	if h.downchannel != nil {
+		health.DownchannelAge = clockOrDefault(c.Clock).Now().Sub(h.downchannel.Started)
+	}
+	return health
+}
+
+// PingContext pings AVS on behalf of a user to check that the connection is
+// still alive. AVS answers with 204 No Content; any other status is returned
+// as an error. The latency is reported to the Metrics and by Health. If the
+// ping goes through another HTTP/2 connection than the open downchannel, its
+// stream is replaced (see OpenDownchannel).
+func (c *Client) PingContext(ctx context.Context, accessToken string) error {
+	// TODO: Once Go supports sending PING frames, that would be a better alternative.
+	req, err := c.newRequest("GET", PingPath, accessToken, nil)
+	if err != nil {
+		return err
+	}
+	clock := clockOrDefault(c.Clock)
+	started := clock.Now()
+	resp, err := c.httpClient().Do(withConnTrace(req.WithContext(ctx)))
+	if err != nil {
+		err = notConnected(err)
+		c.health.pinged(started, 0, err)
+		return err
+	}
+	defer resp.Body.Close()
+	if d := c.health.openDownchannel(); d != nil {
+		d.checkConnection(connOf(resp))
+	}
+	latency := clock.Now().Sub(started)
+	c.Metrics.pingLatency(latency)
+	if resp.StatusCode != 204 {
+		if _, err = checkStatusCode(resp, c.Limits.resolve().MaxDirectiveSize); err == nil {
+			err = &RequestError{
+				StatusCode: resp.StatusCode,
+				Status:     resp.Status,
+				RequestId:  resp.Header.Get("x-amzn-requestid"),
+			}
+		}
+	}
+	c.health.pinged(started, latency, err)
+	return err
+}
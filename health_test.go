@@ -0,0 +1,80 @@
+package avs
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	pingStatus := http.StatusNoContent
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case PingPath:
+			w.WriteHeader(pingStatus)
+		case DirectivesPath:
+			w.Header().Set("Content-Type", "multipart/related; boundary=b")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	var latencies []time.Duration
+	client := &Client{EndpointURL: server.URL, Metrics: &Metrics{
+		PingLatency: func(d time.Duration) { latencies = append(latencies, d) },
+	}}
+	if h := client.Health(); h.Connected || !h.LastPing.IsZero() {
+		t.Errorf("got %+v before any request", h)
+	}
+	d, err := client.OpenDownchannel("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping("token"); err != nil {
+		t.Fatal(err)
+	}
+	request := NewRequest("token")
+	request.Event = NewSynchronizeState("m1")
+	if _, err := client.Do(request); err != nil {
+		t.Fatal(err)
+	}
+	h := client.Health()
+	if !h.Connected || h.LastPing.IsZero() || h.LastPingErr != nil || h.LastEventSuccess.IsZero() {
+		t.Errorf("got %+v; want a connected client", h)
+	}
+	if h.DownchannelAge <= 0 || h.QueuedEvents != 0 {
+		t.Errorf("got downchannel age %s and %d queued event(s)", h.DownchannelAge, h.QueuedEvents)
+	}
+	if len(latencies) != 1 {
+		t.Errorf("got %d ping latencies; want 1", len(latencies))
+	}
+
+	// Statuses other than 204 are errors, but AVS is still reachable.
+	pingStatus = http.StatusOK
+	var requestErr *RequestError
+	if err := client.Ping("token"); !errors.As(err, &requestErr) || requestErr.StatusCode != 200 {
+		t.Errorf("got %v; want a RequestError with status 200", err)
+	}
+	if h := client.Health(); !h.Connected || h.LastPingErr == nil {
+		t.Errorf("got %+v; want a connected client with a ping error", h)
+	}
+
+	d.Close()
+	for i := 0; i < 100 && client.Health().DownchannelAge != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if h := client.Health(); h.Connected || h.DownchannelAge != 0 {
+		t.Errorf("got %+v after closing the downchannel", h)
+	}
+}
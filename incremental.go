@@ -0,0 +1,246 @@
+package avs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/fika-io/go-avs/multipart2"
+)
+
+// The size of the chunks in which the attachments of an incremental response
+// are made available to their readers.
+const attachmentChunkSize = 4 << 10
+
+// DoIncremental is like DoContext but calls handle with every directive as
+// soon as its part is read, while the rest of the response, including the
+// attachments, is still arriving. A handler may play the audio of a Speak
+// directive as it downloads by reading it from Response.OpenAttachment,
+// instead of waiting for the whole response.
+//
+// The handler is called in order, from a single goroutine, with the response
+// being read. DoIncremental returns once the response has been read and the
+// handler has returned for every directive. Reading the response doesn't
+// wait for the handler, and the other fields and methods of the response must
+// not be used until DoIncremental returns. If the response fails after some
+// directives have been handled, it's returned along with the error.
+func (c *Client) DoIncremental(ctx context.Context, request *Request, handle func(response *Response, directive TypedMessage)) (*Response, error) {
+	response, err := c.DoStream(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if response.stream == nil {
+		// The response is empty.
+		return response, nil
+	}
+	response.streams = make(map[string]*attachmentStream)
+	response.stream.iterated = true
+	q := newIncrementalQueue()
+	go func() {
+		for {
+			directive, err := response.stream.read(response)
+			if err != nil {
+				response.endStreams(err)
+				q.close(err)
+				return
+			}
+			q.push(directive.Typed())
+		}
+	}()
+	for {
+		directive, ok := q.pop()
+		if !ok {
+			break
+		}
+		handle(response, directive)
+	}
+	if err := q.err; err != io.EOF {
+		return response, err
+	}
+	return response, nil
+}
+
+// OpenAttachment returns a reader of the attachment with the content id,
+// which may be a cid: URL. While DoIncremental reads the response, the reader
+// returns the data of the attachment as it arrives, including for
+// attachments that haven't started yet; it fails with an ErrAttachmentMissing
+// error if the response ends without the attachment. Otherwise, it's like
+// Attachment. The attachment is still kept in memory, so it fails with an
+// ErrPartTooLarge error if it exceeds Limits.MaxAttachmentSize.
+func (r *Response) OpenAttachment(contentId string) (io.ReadCloser, error) {
+	if id, ok := ParseCID(contentId); ok {
+		contentId = string(id)
+	}
+	if r.streams == nil {
+		data, err := r.Attachment(contentId)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	r.streamsMu.Lock()
+	defer r.streamsMu.Unlock()
+	s := r.streams[contentId]
+	if s == nil {
+		if r.streamsEnded {
+			return nil, withKind(ErrAttachmentMissing, fmt.Errorf("avs: response has no attachment %s", contentId))
+		}
+		s = newAttachmentStream()
+		r.streams[contentId] = s
+	}
+	return &attachmentReader{s: s}, nil
+}
+
+// Reads an attachment of an incremental response into its stream and
+// returns it once complete.
+func (r *Response) readAttachment(contentId string, p *multipart2.Part) ([]byte, error) {
+	r.streamsMu.Lock()
+	s := r.streams[contentId]
+	if s == nil {
+		s = newAttachmentStream()
+		r.streams[contentId] = s
+	}
+	r.streamsMu.Unlock()
+	buf := make([]byte, attachmentChunkSize)
+	for {
+		n, err := p.Read(buf)
+		s.write(buf[:n])
+		if err == io.EOF {
+			s.end(io.EOF)
+			return s.bytes(), nil
+		}
+		if err != nil {
+			s.end(err)
+			return nil, err
+		}
+	}
+}
+
+// Ends the streams of the attachments that never arrived, once the response
+// has been read.
+func (r *Response) endStreams(err error) {
+	if err == io.EOF {
+		err = withKind(ErrAttachmentMissing, fmt.Errorf("avs: response ended without the attachment"))
+	}
+	r.streamsMu.Lock()
+	defer r.streamsMu.Unlock()
+	r.streamsEnded = true
+	for _, s := range r.streams {
+		s.end(err)
+	}
+}
+
+// attachmentStream holds an attachment as it arrives.
+type attachmentStream struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	data []byte
+	err  error // io.EOF once complete
+}
+
+func newAttachmentStream() *attachmentStream {
+	s := new(attachmentStream)
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *attachmentStream) write(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	s.mu.Lock()
+	s.data = append(s.data, p...)
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// Ends the stream with err, unless it has already ended.
+func (s *attachmentStream) end(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+func (s *attachmentStream) bytes() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data
+}
+
+type attachmentReader struct {
+	s   *attachmentStream
+	off int
+}
+
+func (r *attachmentReader) Read(p []byte) (int, error) {
+	s := r.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for r.off >= len(s.data) && s.err == nil {
+		s.cond.Wait()
+	}
+	if r.off < len(s.data) {
+		n := copy(p, s.data[r.off:])
+		r.off += n
+		return n, nil
+	}
+	return 0, s.err
+}
+
+func (r *attachmentReader) Close() error {
+	return nil
+}
+
+// incrementalQueue hands the directives read by DoIncremental to the handler
+// without ever blocking the reader, since the handler may be waiting for an
+// attachment that comes after the next directive.
+type incrementalQueue struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	directives []TypedMessage
+	closed     bool
+	err        error
+}
+
+func newIncrementalQueue() *incrementalQueue {
+	q := new(incrementalQueue)
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *incrementalQueue) push(directive TypedMessage) {
+	q.mu.Lock()
+	q.directives = append(q.directives, directive)
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+func (q *incrementalQueue) close(err error) {
+	q.mu.Lock()
+	q.closed = true
+	q.err = err
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// Returns the next directive, or false once the queue is closed and empty.
+func (q *incrementalQueue) pop() (TypedMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.directives) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.directives) == 0 {
+		return nil, false
+	}
+	directive := q.directives[0]
+	q.directives[0] = nil
+	q.directives = q.directives[1:]
+	return directive, true
+}
@@ -0,0 +1,249 @@
+package avs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a server that responds to Recognize events with speakDirective and
+// then sends the chunks of its audio, calling wait before each one, followed
+// by an ExpectSpeech directive. Other events get 204 No Content.
+func newSlowSpeechServer(chunks []string, wait func()) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !bytes.Contains(body, []byte(`"name":"Recognize"`)) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		flush := w.(http.Flusher).Flush
+		w.Header().Set("Content-Type", "multipart/related; boundary=------abcde123; type=application/json")
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "--------abcde123\r\nContent-Type: application/json\r\n\r\n{\"directive\":%s}\r\n", speakDirective)
+		fmt.Fprint(w, "--------abcde123\r\nContent-ID: <abc>\r\nContent-Type: application/octet-stream\r\n\r\n")
+		flush()
+		for _, chunk := range chunks {
+			wait()
+			fmt.Fprint(w, chunk)
+			flush()
+		}
+		fmt.Fprint(w, "\r\n--------abcde123\r\nContent-Type: application/json\r\n\r\n"+
+			`{"directive":{"header":{"namespace":"SpeechRecognizer","name":"ExpectSpeech","messageId":"m2"},"payload":{"timeoutInMilliseconds":8000}}}`+"\r\n"+
+			"--------abcde123--\r\n")
+	}))
+}
+
+func TestDoIncremental(t *testing.T) {
+	release := make(chan struct{})
+	server := newSlowSpeechServer([]string{"mp", "3"}, func() { <-release })
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL}
+	request := NewRequest("token")
+	request.Event = NewRecognize("m1", "d1")
+	var names []string
+	var audio string
+	response, err := client.DoIncremental(context.Background(), request, func(response *Response, directive TypedMessage) {
+		names = append(names, directive.GetMessage().Type().Name)
+		speak, ok := directive.(*Speak)
+		if !ok {
+			return
+		}
+		// The audio is only sent once the Speak directive has been handled.
+		close(release)
+		r, err := response.OpenAttachment(speak.Payload.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Error(err)
+		}
+		audio = string(data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Speak", "ExpectSpeech"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got directives %v, want %v", names, want)
+	}
+	if audio != "mp3" {
+		t.Errorf("got audio %q, want mp3", audio)
+	}
+	if data, err := response.Attachment("cid:abc"); err != nil || string(data) != "mp3" {
+		t.Errorf("got attachment %q (%v), want mp3", data, err)
+	}
+	if len(response.Directives) != 2 || response.Finished.IsZero() {
+		t.Errorf("response wasn't read to the end: %s", response)
+	}
+	r, err := response.OpenAttachment("cid:abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(r); string(data) != "mp3" {
+		t.Errorf("got %q after the response was read, want mp3", data)
+	}
+	if _, err := response.OpenAttachment("cid:other"); !errors.Is(err, ErrAttachmentMissing) {
+		t.Errorf("got %v for a missing attachment, want ErrAttachmentMissing", err)
+	}
+}
+
+func TestDoIncrementalMissingAttachment(t *testing.T) {
+	server := newResponseServer("--------abcde123\r\nContent-Type: application/json\r\n\r\n{\"directive\":" + speakDirective + "}\r\n--------abcde123--\r\n")
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL}
+	request := NewRequest("token")
+	request.Event = NewRecognize("m1", "d1")
+	var readErr error
+	_, err := client.DoIncremental(context.Background(), request, func(response *Response, directive TypedMessage) {
+		// Depending on how far the response has been read, either opening or
+		// reading the attachment fails.
+		r, err := response.OpenAttachment(directive.(*Speak).Payload.URL)
+		if err == nil {
+			_, err = ioutil.ReadAll(r)
+		}
+		readErr = err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(readErr, ErrAttachmentMissing) {
+		t.Errorf("got %v, want ErrAttachmentMissing", readErr)
+	}
+}
+
+// Attachments over MaxAttachmentSize aren't streamed: they fail even while
+// being read.
+func TestDoIncrementalLargeAttachment(t *testing.T) {
+	server := newSlowSpeechServer([]string{"0123456789", "0123456789"}, func() {})
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL, Limits: Limits{MaxAttachmentSize: 16}}
+	request := NewRequest("token")
+	request.Event = NewRecognize("m1", "d1")
+	var readErr error
+	_, err := client.DoIncremental(context.Background(), request, func(response *Response, directive TypedMessage) {
+		if speak, ok := directive.(*Speak); ok {
+			r, err := response.OpenAttachment(speak.Payload.URL)
+			if err == nil {
+				_, err = ioutil.ReadAll(r)
+				r.Close()
+			}
+			readErr = err
+		}
+	})
+	if !errors.Is(err, ErrPartTooLarge) || !errors.Is(readErr, ErrPartTooLarge) {
+		t.Errorf("got %v, and %v for the reader; want ErrPartTooLarge", err, readErr)
+	}
+}
+
+type firstChunkPlayer struct {
+	first chan string
+}
+
+func (p *firstChunkPlayer) PlaySpeech(ctx context.Context, speak *Speak, audio io.Reader) error {
+	buf := make([]byte, 2)
+	n, err := audio.Read(buf)
+	p.first <- string(buf[:n])
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, audio)
+	return err
+}
+
+func TestDialogControllerIncremental(t *testing.T) {
+	release := make(chan struct{})
+	server := newSlowSpeechServer([]string{"mp", "3"}, func() { <-release })
+	defer server.Close()
+	player := &firstChunkPlayer{first: make(chan string, 1)}
+	c := &DialogController{
+		Client:      &Client{EndpointURL: server.URL},
+		AccessToken: "token",
+		Player:      player,
+		Incremental: true,
+	}
+	results := make(chan error, 1)
+	go func() {
+		result, err := c.Recognize(context.Background(), ioutil.NopCloser(strings.NewReader("hello")))
+		if err == nil && len(result.Speaks) != 1 {
+			err = fmt.Errorf("got %d Speak directives, want 1", len(result.Speaks))
+		}
+		results <- err
+	}()
+	// The first chunk is only sent now, so the speech started playing before
+	// the response was read.
+	release <- struct{}{}
+	if first := <-player.first; first != "mp" {
+		t.Errorf("got %q first, want mp", first)
+	}
+	close(release)
+	if err := <-results; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Measures the time from sending a Recognize event until the first byte of
+// its speech can be played, with the audio arriving in chunks.
+func BenchmarkTimeToFirstAudio(b *testing.B) {
+	chunks := make([]string, 20)
+	for i := range chunks {
+		chunks[i] = strings.Repeat("x", 512)
+	}
+	server := newSlowSpeechServer(chunks, func() { time.Sleep(2 * time.Millisecond) })
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL}
+	newRequest := func() *Request {
+		request := NewRequest("token")
+		request.Event = NewRecognize("m1", "d1")
+		return request
+	}
+	b.Run("DoContext", func(b *testing.B) {
+		var total time.Duration
+		for i := 0; i < b.N; i++ {
+			started := time.Now()
+			response, err := client.DoContext(context.Background(), newRequest())
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := response.Attachment("cid:abc"); err != nil {
+				b.Fatal(err)
+			}
+			total += time.Since(started)
+		}
+		b.ReportMetric(float64(total)/float64(time.Millisecond)/float64(b.N), "ms/first-audio")
+	})
+	b.Run("DoIncremental", func(b *testing.B) {
+		var total time.Duration
+		for i := 0; i < b.N; i++ {
+			started := time.Now()
+			_, err := client.DoIncremental(context.Background(), newRequest(), func(response *Response, directive TypedMessage) {
+				speak, ok := directive.(*Speak)
+				if !ok {
+					return
+				}
+				r, err := response.OpenAttachment(speak.Payload.URL)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := r.Read(make([]byte, 1)); err != nil {
+					b.Fatal(err)
+				}
+				total += time.Since(started)
+				io.Copy(ioutil.Discard, r)
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(total)/float64(time.Millisecond)/float64(b.N), "ms/first-audio")
+	})
+}
@@ -0,0 +1,99 @@
+package avs
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// IndeterminateError is the cause of ErrIndeterminate errors: the connection
+// was lost while the request was being sent, before AVS responded.
+type IndeterminateError struct {
+	// The request that may or may not have been processed.
+	Request *Request
+	// Idempotent reports whether every event of the request may be sent
+	// again safely, per Client.IsIdempotent. EventQueue keeps those queued
+	// and drops the others.
+	Idempotent bool
+	// The IdempotencyKey of the request, to find out from the logs whether
+	// it was processed.
+	IdempotencyKey string
+	Err            error
+}
+
+// Error returns the IndeterminateError formatted as a human readable string.
+func (e *IndeterminateError) Error() string {
+	return fmt.Sprintf("avs: connection lost while sending %s, which may have been processed: %v", e.Request.Event.GetMessage(), e.Err)
+}
+
+// Is reports whether target is ErrIndeterminate.
+func (e *IndeterminateError) Is(target error) bool {
+	return target == ErrIndeterminate
+}
+
+// Unwrap returns the error of the connection.
+func (e *IndeterminateError) Unwrap() error {
+	return e.Err
+}
+
+// The events that report the state of the device, which AVS handles the same
+// way if they're received twice.
+var idempotentEvents = map[MessageType]bool{
+	TypeStateReport:          true,
+	TypeMuteChanged:          true,
+	TypeVolumeChanged:        true,
+	TypeSettingsUpdated:      true,
+	TypeSoftwareInfo:         true,
+	TypeSynchronizeState:     true,
+	TypeUserInactivityReport: true,
+}
+
+// IsIdempotent reports whether sending the events of the request twice has
+// the same effect as sending them once, so that they may be sent again after
+// an ErrIndeterminate error.
+//
+// By default, only the events that report state are idempotent:
+// Alexa.StateReport, Speaker.MuteChanged, Speaker.VolumeChanged,
+// Settings.SettingsUpdated, System.SynchronizeState and
+// System.UserInactivityReport. Others, like Recognize, would start another
+// interaction. The IdempotentNamespaces of the client take precedence.
+func (c *Client) IsIdempotent(request *Request) bool {
+	events := []TypedMessage{request.Event}
+	for _, envelope := range request.batch {
+		events = append(events, envelope.Event)
+	}
+	for _, event := range events {
+		t := event.GetMessage().Type()
+		idempotent, ok := c.IdempotentNamespaces[t.Namespace]
+		if !ok {
+			idempotent = idempotentEvents[t]
+		}
+		if !idempotent {
+			return false
+		}
+	}
+	return true
+}
+
+// Counts the bytes read through it, which may be read concurrently.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(&r.n, int64(n))
+	return n, err
+}
+
+func (r *countingReader) Close() error {
+	if c, ok := r.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (r *countingReader) count() int64 {
+	return atomic.LoadInt64(&r.n)
+}
@@ -0,0 +1,75 @@
+package avs
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIndeterminateError(t *testing.T) {
+	// The server drops the connection after reading part of the request.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadFull(r.Body, make([]byte, 16))
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}))
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL}
+
+	request := NewRequest("token")
+	request.Event = NewSynchronizeState("m1")
+	request.Audio = strings.NewReader(strings.Repeat("a", 1<<20))
+	_, err := client.Do(request)
+	var indeterminate *IndeterminateError
+	if !errors.Is(err, ErrIndeterminate) || !errors.Is(err, ErrNotConnected) || !errors.As(err, &indeterminate) {
+		t.Fatalf("got %v; want an ErrIndeterminate error", err)
+	}
+	if indeterminate.Request != request || !indeterminate.Idempotent {
+		t.Errorf("got %+v; want the idempotent request", indeterminate)
+	}
+
+	// Requests that never reached the server are only ErrNotConnected.
+	server.Close()
+	request = NewRequest("token")
+	request.Event = NewSynchronizeState("m2")
+	if _, err := client.Do(request); !errors.Is(err, ErrNotConnected) || errors.Is(err, ErrIndeterminate) {
+		t.Errorf("got %v for a closed server; want an ErrNotConnected error", err)
+	}
+}
+
+func TestIsIdempotent(t *testing.T) {
+	client := &Client{}
+	for _, test := range []struct {
+		event TypedMessage
+		want  bool
+	}{
+		{NewSynchronizeState("m1"), true},
+		{NewVolumeChanged("m1", 10, false), true},
+		{NewRecognize("m1", "d1"), false},
+		{NewSpeechStarted("m1", "t1"), false},
+	} {
+		request := NewRequest("token")
+		request.Event = test.event
+		if got := client.IsIdempotent(request); got != test.want {
+			t.Errorf("%s: got %t; want %t", test.event.GetMessage(), got, test.want)
+		}
+	}
+
+	client.IdempotentNamespaces = map[string]bool{"SpeechSynthesizer": true, "System": false}
+	request := NewRequest("token")
+	request.Event = NewSpeechStarted("m1", "t1")
+	if !client.IsIdempotent(request) {
+		t.Error("SpeechStarted should be idempotent with the override")
+	}
+	request.Event = NewSynchronizeState("m1")
+	if client.IsIdempotent(request) {
+		t.Error("SynchronizeState shouldn't be idempotent with the override")
+	}
+}
@@ -0,0 +1,77 @@
+package avs
+
+import (
+	"math"
+
+	"github.com/fika-io/go-avs/multipart2"
+)
+
+// ErrPartTooLarge is matched by the errors returned when a part of a response
+// or of the downchannel exceeds the Limits of the Client. The error is a
+// *multipart2.PartTooLargeError, which identifies the part.
+var ErrPartTooLarge = multipart2.ErrPartTooLarge
+
+// Limits protects a Client from oversized responses and downchannel parts, and
+// from directives nested too deeply to be decoded cheaply. A response that
+// exceeds a limit fails; a downchannel that does is closed, with the error
+// returned by Err. Zero fields use the values of DefaultLimits, and negative
+// ones disable the limit.
+type Limits struct {
+	// MaxDirectiveSize is the maximum size in bytes of the JSON part of a
+	// directive, and of the body of an error response.
+	MaxDirectiveSize int
+	// MaxAttachmentSize is the maximum size in bytes of an attachment, which
+	// is read into memory. Larger attachments fail rather than switching to
+	// streaming, even with DoIncremental: the attachments of a response may
+	// be opened until it's done (see Response.OpenAttachment), and its
+	// directives are read ahead of the handler, so an attachment can't be
+	// dropped once read. AVS sends long audio as URLs to fetch instead.
+	MaxAttachmentSize int
+	// MaxHeaderBytes is the maximum size in bytes of the header of a part,
+	// and MaxHeaders the maximum number of fields in it.
+	MaxHeaderBytes int
+	MaxHeaders     int
+	// MaxDepth is the maximum number of nested objects and arrays in the
+	// JSON of a directive, which is an ErrInvalidMessage error.
+	MaxDepth int
+}
+
+// DefaultLimits are the limits used for the fields of Limits that are zero.
+// They're global: changing them affects every client whose Limits have zero
+// fields, and should only be done before any client is used.
+var DefaultLimits = Limits{
+	MaxDirectiveSize:  4 << 20,
+	MaxAttachmentSize: 32 << 20,
+	MaxHeaderBytes:    multipart2.DefaultMaxHeaderBytes,
+	MaxHeaders:        multipart2.DefaultMaxHeaders,
+	MaxDepth:          64,
+}
+
+// Returns the limits with the defaults filled in. Disabled limits are zero,
+// which means no limit to multipart2.
+func (l Limits) resolve() Limits {
+	resolve := func(v, def int) int {
+		switch {
+		case v == 0:
+			return def
+		case v < 0:
+			return 0
+		}
+		return v
+	}
+	return Limits{
+		MaxDirectiveSize:  resolve(l.MaxDirectiveSize, DefaultLimits.MaxDirectiveSize),
+		MaxAttachmentSize: resolve(l.MaxAttachmentSize, DefaultLimits.MaxAttachmentSize),
+		MaxHeaderBytes:    resolve(l.MaxHeaderBytes, DefaultLimits.MaxHeaderBytes),
+		MaxHeaders:        resolve(l.MaxHeaders, DefaultLimits.MaxHeaders),
+		MaxDepth:          resolve(l.MaxDepth, DefaultLimits.MaxDepth),
+	}
+}
+
+// Returns the limit, or the largest one for a disabled limit.
+func orUnlimited(limit int) int {
+	if limit == 0 {
+		return math.MaxInt32
+	}
+	return limit
+}
@@ -0,0 +1,127 @@
+package avs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs/multipart2"
+)
+
+func TestResolveLimits(t *testing.T) {
+	got := Limits{MaxDirectiveSize: 10, MaxAttachmentSize: -1}.resolve()
+	want := Limits{
+		MaxDirectiveSize:  10,
+		MaxAttachmentSize: 0,
+		MaxHeaderBytes:    DefaultLimits.MaxHeaderBytes,
+		MaxHeaders:        DefaultLimits.MaxHeaders,
+		MaxDepth:          DefaultLimits.MaxDepth,
+	}
+	if got != want {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestReadResponseLimits(t *testing.T) {
+	directive := fmt.Sprintf(`{"directive":%s}`, speakDirective)
+	tests := []struct {
+		limits    Limits
+		threshold int
+		wantIndex int
+	}{
+		{Limits{}, 0, -1},
+		{Limits{MaxDirectiveSize: 16}, 0, 0},
+		{Limits{MaxDirectiveSize: 16}, 8, 0},
+		{Limits{MaxDirectiveSize: -1, MaxAttachmentSize: 4}, 0, 1},
+	}
+	for i, test := range tests {
+		var buf bytes.Buffer
+		w := multipart2.NewWriter(&buf)
+		p, _ := w.CreatePart(map[string][]string{"Content-Type": {"application/json"}})
+		p.Write([]byte(directive))
+		p, _ = w.CreatePart(map[string][]string{
+			"Content-Type": {"application/octet-stream"},
+			"Content-ID":   {"<audio>"},
+		})
+		p.Write([]byte("0123456789"))
+		w.Close()
+		mr := multipart2.NewReader(&buf, w.Boundary())
+		response := &Response{Content: map[string][]byte{}}
+		err := readResponse(mr, response, test.threshold, test.limits.resolve())
+		if test.wantIndex < 0 {
+			if err != nil {
+				t.Errorf("%d: %v", i, err)
+			}
+			continue
+		}
+		var tooLarge *multipart2.PartTooLargeError
+		if !errors.Is(err, ErrPartTooLarge) || !errors.As(err, &tooLarge) {
+			t.Errorf("%d: got error %v; want ErrPartTooLarge", i, err)
+		} else if tooLarge.Index != test.wantIndex {
+			t.Errorf("%d: got part %d; want part %d", i, tooLarge.Index, test.wantIndex)
+		}
+	}
+}
+
+// The body of an error is read up to MaxDirectiveSize.
+func TestErrorBodyTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(bytes.Repeat([]byte("x"), 1<<20))
+	}))
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL, Limits: Limits{MaxDirectiveSize: 1024}}
+	request := NewRequest("token")
+	request.Event = NewSynchronizeState("m1")
+	_, err := client.Do(request)
+	var tooLarge *multipart2.PartTooLargeError
+	if !errors.Is(err, ErrPartTooLarge) || !errors.As(err, &tooLarge) || tooLarge.Limit != 1024 {
+		t.Errorf("got %v; want ErrPartTooLarge", err)
+	}
+	client.Limits.MaxDirectiveSize = -1
+	var requestError *RequestError
+	if _, err := client.Do(request); !errors.As(err, &requestError) {
+		t.Errorf("got %v without a limit; want a RequestError", err)
+	}
+}
+
+func TestDownchannelPartTooLarge(t *testing.T) {
+	server := newGoAwayServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "multipart/related; boundary=------abcde123; type=application/json")
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "--------abcde123\r\nContent-Type: application/json\r\n\r\n"+
+			`{"directive":{"header":{"namespace":"Speaker","name":"SetVolume","messageId":"m1"},"payload":{"volume":%s}}}`+"\r\n--------abcde123\r\n",
+			strings.Repeat(" ", 1024)+"1")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	roots := tr.TLSClientConfig.RootCAs
+	tr.TLSClientConfig.RootCAs = server.roots
+	defer func() {
+		tr.TLSClientConfig.RootCAs = roots
+	}()
+
+	client := &Client{EndpointURL: server.URL, Limits: Limits{MaxDirectiveSize: 512}}
+	d, err := client.OpenDownchannel("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	select {
+	case m, ok := <-d.Directives:
+		if ok {
+			t.Fatalf("got directive %v; want the downchannel to close", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the downchannel to close")
+	}
+	if err := d.Err(); !errors.Is(err, ErrPartTooLarge) {
+		t.Errorf("got error %v; want ErrPartTooLarge", err)
+	}
+}
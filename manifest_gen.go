@@ -0,0 +1,95 @@
+// Code generated by gen_manifest.go; DO NOT EDIT.
+
+package avs
+
+// The message structs of the package by the file that declares and
+// registers them.
+var manifest = map[string][]TypedMessage{
+	"alerts.go": {
+		(*DeleteAlert)(nil),
+		(*SetAlert)(nil),
+		(*AlertEnteredBackground)(nil),
+		(*AlertEnteredForeground)(nil),
+		(*AlertStarted)(nil),
+		(*AlertStopped)(nil),
+		(*DeleteAlertFailed)(nil),
+		(*DeleteAlertSucceeded)(nil),
+		(*SetAlertFailed)(nil),
+		(*SetAlertSucceeded)(nil),
+		(*AlertsState)(nil),
+	},
+	"alexa.go": {
+		(*EventProcessed)(nil),
+		(*ReportState)(nil),
+		(*StateReport)(nil),
+	},
+	"apigateway.go": {
+		(*SetGateway)(nil),
+	},
+	"audioplayer.go": {
+		(*ClearQueue)(nil),
+		(*Play)(nil),
+		(*Stop)(nil),
+		(*PlaybackFailed)(nil),
+		(*PlaybackFinished)(nil),
+		(*PlaybackNearlyFinished)(nil),
+		(*PlaybackPaused)(nil),
+		(*PlaybackQueueCleared)(nil),
+		(*PlaybackResumed)(nil),
+		(*PlaybackStarted)(nil),
+		(*PlaybackStopped)(nil),
+		(*PlaybackStutterStarted)(nil),
+		(*PlaybackStutterFinished)(nil),
+		(*ProgressReportDelayElapsed)(nil),
+		(*ProgressReportIntervalElapsed)(nil),
+		(*StreamMetadataExtracted)(nil),
+		(*PlaybackState)(nil),
+	},
+	"notifications.go": {
+		(*ClearIndicator)(nil),
+		(*SetIndicator)(nil),
+		(*IndicatorState)(nil),
+	},
+	"playbackcontroller.go": {
+		(*NextCommandIssued)(nil),
+		(*PauseCommandIssued)(nil),
+		(*PlayCommandIssued)(nil),
+		(*PreviousCommandIssued)(nil),
+	},
+	"settings.go": {
+		(*SettingsUpdated)(nil),
+	},
+	"speaker.go": {
+		(*AdjustVolume)(nil),
+		(*SetMute)(nil),
+		(*SetVolume)(nil),
+		(*MuteChanged)(nil),
+		(*VolumeChanged)(nil),
+		(*VolumeState)(nil),
+	},
+	"speechrecognizer.go": {
+		(*ExpectSpeech)(nil),
+		(*SetWakeWords)(nil),
+		(*StopCapture)(nil),
+		(*ExpectSpeechTimedOut)(nil),
+		(*Recognize)(nil),
+		(*ReportEchoSpatialPerceptionData)(nil),
+		(*WakeWordsChanged)(nil),
+		(*RecognizerState)(nil),
+	},
+	"speechsynthesizer.go": {
+		(*Speak)(nil),
+		(*SpeechFinished)(nil),
+		(*SpeechStarted)(nil),
+		(*SpeechState)(nil),
+	},
+	"system.go": {
+		(*SetEndpoint)(nil),
+		(*ResetUserInactivity)(nil),
+		(*Exception)(nil),
+		(*ExceptionEncountered)(nil),
+		(*SoftwareInfo)(nil),
+		(*SynchronizeState)(nil),
+		(*UserInactivityReport)(nil),
+	},
+}
@@ -2,8 +2,10 @@ package avs
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
+	"time"
 )
 
 // TypedMessage is an interface that represents both raw Message objects and
@@ -20,82 +22,243 @@ type TypedMessage interface {
 }
 
 // Message is a general structure for contexts, events and directives.
+//
+// Payloads survive decoding and encoding byte for byte: unknown payloads are
+// kept as raw JSON, and numbers decoded into interface{} values (e.g., the
+// Metadata of StreamMetadataExtracted) are kept as json.Number, so that
+// numeric tokens too large for a float64 aren't rounded.
 type Message struct {
 	Header  map[string]string `json:"header"`
 	Payload json.RawMessage   `json:"payload,omitempty"`
+
+	// Set when the payload was decoded directly into a typed message, in
+	// which case Payload is empty.
+	typed TypedMessage
+	// The directive as received from AVS, if it was read into memory.
+	raw json.RawMessage
+	// When and how the directive was received from AVS.
+	received time.Time
+	source   DirectiveSource
+	// The number given to the event by a Sequencer.
+	sequence uint64
+}
+
+// DirectiveSource specifies how a directive was delivered by AVS.
+type DirectiveSource int
+
+// Possible values for DirectiveSource.
+const (
+	// The message wasn't received from AVS (e.g., it was built or parsed by
+	// the application).
+	DirectiveSourceUnknown DirectiveSource = iota
+	// The directive was in the response to an event (e.g., the Speak
+	// directive of a Recognize event).
+	DirectiveSourceEventResponse
+	// The directive was sent on the downchannel (e.g., a Speak directive of
+	// a routine).
+	DirectiveSourceDownchannel
+)
+
+// String returns the name of the source.
+func (s DirectiveSource) String() string {
+	switch s {
+	case DirectiveSourceEventResponse:
+		return "EventResponse"
+	case DirectiveSourceDownchannel:
+		return "Downchannel"
+	}
+	return "Unknown"
+}
+
+// MessageMetadata describes how a message was delivered.
+type MessageMetadata struct {
+	// When the directive was read from a response or the downchannel, per
+	// the Clock of the Client. It's zero for other messages.
+	ReceivedAt time.Time
+	// Where the directive was read from.
+	Source DirectiveSource
+	// The number given to an event sent by a Client with a Sequencer, or
+	// zero.
+	Sequence uint64
 }
 
+// Metadata returns the delivery details of the message. It returns the zero
+// MessageMetadata for a nil message.
+func (m *Message) Metadata() MessageMetadata {
+	if m == nil {
+		return MessageMetadata{}
+	}
+	return MessageMetadata{ReceivedAt: m.received, Source: m.source, Sequence: m.sequence}
+}
+
+// ErrNoHeader is returned by Validate for messages without a header, or with
+// a header that lacks a namespace or a name. It is an ErrInvalidMessage error.
+var ErrNoHeader = withKind(ErrInvalidMessage, errors.New("avs: message has no header"))
+
 // GetMessage returns a pointer to the underlying Message object.
 func (m *Message) GetMessage() *Message {
 	return m
 }
 
-// String returns the namespace and name as a single string.
+// Type returns the namespace and name of the message. It returns the zero
+// MessageType for a nil message or a message without a header.
+func (m *Message) Type() MessageType {
+	return MessageType{m.header("namespace"), m.header("name")}
+}
+
+// String returns the namespace and name as a single string. It returns an
+// empty string for a nil message or a message without a namespace and name.
 func (m *Message) String() string {
-	return fmt.Sprintf("%s.%s", m.Header["namespace"], m.Header["name"])
+	t := m.Type()
+	if t == (MessageType{}) {
+		return ""
+	}
+	return t.Key()
+}
+
+// Validate returns ErrNoHeader if the message doesn't have a namespace and a
+// name, and an ErrInvalidMessage error if it lacks a header field that its
+// type requires (e.g., the dialogRequestId of a Recognize event). Messages
+// delivered by AVS that fail validation should be dropped.
+func (m *Message) Validate() error {
+	if m.header("namespace") == "" || m.header("name") == "" {
+		return ErrNoHeader
+	}
+	for _, field := range requiredHeaderFields[m.Type()] {
+		if _, ok := m.headerField(field); !ok {
+			return withKind(ErrInvalidMessage, fmt.Errorf("avs: %s message has no %s header field", m, field))
+		}
+	}
+	return nil
+}
+
+// Returns a header value, or an empty string for a nil message or header.
+func (m *Message) header(key string) string {
+	if m == nil {
+		return ""
+	}
+	return m.Header[key]
+}
+
+//go:generate go run gen_headers.go
+
+// Returns a header value and whether it's set and not empty. It backs the
+// generated accessors in headers_gen.go.
+func (m *Message) headerField(key string) (string, bool) {
+	v := m.header(key)
+	return v, v != ""
+}
+
+// Clone returns a deep copy of the message. Every header field is kept,
+// including the ones that this package doesn't know about. If the payload was
+// decoded directly into a typed message, the copy gets it in encoded form.
+func (m *Message) Clone() *Message {
+	if m == nil {
+		return nil
+	}
+	c := &Message{received: m.received, source: m.source, sequence: m.sequence}
+	if m.Header != nil {
+		c.Header = make(map[string]string, len(m.Header))
+		for k, v := range m.Header {
+			c.Header[k] = v
+		}
+	}
+	if m.typed != nil {
+		if payload := payloadOf(m.typed); payload != nil {
+			c.Payload, _ = codec().Marshal(payload)
+		}
+	} else if m.Payload != nil {
+		c.Payload = append(json.RawMessage(nil), m.Payload...)
+	}
+	return c
+}
+
+// CloneWithNamespace returns a deep copy of the message, like Clone, with
+// another namespace and name. The copy is typed and validated as a message of
+// the new namespace and name, so that a message can be adapted to an
+// interface that AVS renamed or re-versioned without giving up its payload.
+// The messages built by the constructors of this package keep their payload
+// in their Go type rather than in the Message; use WithHeaderOverride for
+// those.
+func (m *Message) CloneWithNamespace(namespace, name string) *Message {
+	c := m.Clone()
+	if c == nil {
+		return nil
+	}
+	if c.Header == nil {
+		c.Header = make(map[string]string, 2)
+	}
+	c.Header["namespace"] = namespace
+	c.Header["name"] = name
+	return c
+}
+
+// HeaderOption changes the header of a message built by one of the
+// constructors of this package (e.g., NewSpeechStarted).
+type HeaderOption func(header map[string]string)
+
+// WithHeaderOverride sets a header field of the message, replacing the value
+// set by the constructor; an empty value removes the field. It's an escape
+// hatch for when AVS changes the wire details of a message (e.g., its
+// namespace) before this package catches up: the message keeps the payload
+// of its Go type, but it's validated, and typed once it's parsed, according
+// to its new namespace and name.
+func WithHeaderOverride(key, value string) HeaderOption {
+	return func(header map[string]string) {
+		if value == "" {
+			delete(header, key)
+			return
+		}
+		header[key] = value
+	}
+}
+
+func applyHeaderOptions(m *Message, opts []HeaderOption) {
+	for _, opt := range opts {
+		opt(m.Header)
+	}
 }
 
 // Typed returns a more specific type for this message.
 //
-// This only parses directives as they're the only type of message sent by AVS.
+// Every type returned by RegisteredTypes is parsed: directives, which are
+// the messages sent by AVS, and events and contexts, which are needed to
+// inspect requests. A nil message returns nil, and messages without a known
+// namespace and name return themselves.
 func (m *Message) Typed() TypedMessage {
-	switch m.String() {
-	case "Alerts.DeleteAlert":
-		return fill(new(DeleteAlert), m)
-	case "Alerts.SetAlert":
-		return fill(new(SetAlert), m)
-	case "AudioPlayer.ClearQueue":
-		return fill(new(ClearQueue), m)
-	case "AudioPlayer.Play":
-		return fill(new(Play), m)
-	case "AudioPlayer.Stop":
-		return fill(new(Stop), m)
-	case "Speaker.AdjustVolume":
-		return fill(new(AdjustVolume), m)
-	case "Speaker.SetMute":
-		return fill(new(SetMute), m)
-	case "Speaker.SetVolume":
-		return fill(new(SetVolume), m)
-	case "SpeechRecognizer.ExpectSpeech":
-		return fill(new(ExpectSpeech), m)
-	case "SpeechRecognizer.StopCapture":
-		return fill(new(StopCapture), m)
-	case "SpeechSynthesizer.Speak":
-		return fill(new(Speak), m)
-	case "System.Exception":
-		// Exception is not a directive, but may also be sent by AVS.
-		return fill(new(Exception), m)
-	case "System.SetEndpoint":
-		return fill(new(SetEndpoint), m)
-	case "System.ResetUserInactivity":
-		return fill(new(ResetUserInactivity), m)
-	default:
-		return m
-	}
-}
-
-// The Exception message.
-type Exception struct {
-	*Message
-	Payload struct {
-		Code        string `json:"code"`
-		Description string `json:"description"`
-	} `json:"payload"`
-}
-
-// Error returns the Exception formatted as a human readable string.
-func (m *Exception) Error() string {
-	return fmt.Sprintf("%s: %s", m.Payload.Code, m.Payload.Description)
+	if m == nil {
+		return nil
+	}
+	if m.typed != nil {
+		return m.typed
+	}
+	if dst := newRegistered(m.Type()); dst != nil {
+		return fill(dst, m)
+	}
+	return m
 }
 
 // Convenience function to set up an empty typed message object from a raw Message.
 func fill(dst TypedMessage, src *Message) TypedMessage {
-	v := reflect.ValueOf(dst).Elem()
-	v.FieldByName("Message").Set(reflect.ValueOf(src))
-	payload := v.FieldByName("Payload")
-	if payload.Kind() != reflect.Struct {
-		return dst
+	if payload := bind(dst, src); payload != nil {
+		unmarshalJSON(src.Payload, payload)
 	}
-	json.Unmarshal(src.Payload, payload.Addr().Interface())
 	return dst
 }
+
+// Returns a pointer to the Payload of a typed message object, or nil if it
+// doesn't have a payload struct.
+func payloadOf(typed TypedMessage) interface{} {
+	payload := reflect.ValueOf(typed).Elem().FieldByName("Payload")
+	if payload.Kind() != reflect.Struct {
+		return nil
+	}
+	return payload.Addr().Interface()
+}
+
+// Sets the Message of a typed message object and returns a pointer to its
+// Payload, or nil if it doesn't have a payload struct.
+func bind(dst TypedMessage, src *Message) interface{} {
+	reflect.ValueOf(dst).Elem().FieldByName("Message").Set(reflect.ValueOf(src))
+	return payloadOf(dst)
+}
@@ -0,0 +1,346 @@
+package avs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNilMessage(t *testing.T) {
+	var m *Message
+	if s := m.String(); s != "" {
+		t.Errorf("String() = %q, want empty string", s)
+	}
+	if m.GetMessage() != nil {
+		t.Error("GetMessage() should return nil")
+	}
+	if typed := m.Typed(); typed != nil {
+		t.Errorf("Typed() = %#v, want nil", typed)
+	}
+	if err := m.Validate(); err != ErrNoHeader {
+		t.Errorf("Validate() = %v, want ErrNoHeader", err)
+	}
+	if NewDeduper(1, 0).Seen(m) {
+		t.Error("a nil message should never be a duplicate")
+	}
+	if err := NewDispatcher().Dispatch(context.Background(), m); err != ErrNoHeader {
+		t.Errorf("Dispatch() = %v, want ErrNoHeader", err)
+	}
+}
+
+func TestMessageWithoutHeader(t *testing.T) {
+	tests := []struct {
+		data   string
+		string string
+		err    error
+	}{
+		{`{"payload":{}}`, "", ErrNoHeader},
+		{`{"header":null,"payload":null}`, "", ErrNoHeader},
+		{`{"header":{}}`, "", ErrNoHeader},
+		{`{"header":{"namespace":"Speaker"}}`, "Speaker.", ErrNoHeader},
+		{`{"header":{"name":"Speak"}}`, ".Speak", ErrNoHeader},
+		{`{"header":{"namespace":"SpeechSynthesizer","name":"Speak"}}`, "SpeechSynthesizer.Speak", nil},
+	}
+	for _, test := range tests {
+		var m Message
+		if err := json.Unmarshal([]byte(test.data), &m); err != nil {
+			t.Fatalf("%s: %v", test.data, err)
+		}
+		if s := m.String(); s != test.string {
+			t.Errorf("%s: String() = %q, want %q", test.data, s, test.string)
+		}
+		if err := m.Validate(); err != test.err {
+			t.Errorf("%s: Validate() = %v, want %v", test.data, err, test.err)
+		}
+		typed := m.Typed()
+		if typed == nil || typed.GetMessage() != &m {
+			t.Errorf("%s: Typed() should wrap the message, got %#v", test.data, typed)
+		}
+	}
+}
+
+func TestTypedWithoutPayload(t *testing.T) {
+	m := &Message{Header: map[string]string{"namespace": "SpeechSynthesizer", "name": "Speak"}}
+	speak, ok := m.Typed().(*Speak)
+	if !ok {
+		t.Fatalf("got %T, want *Speak", m.Typed())
+	}
+	if speak.ContentId() != "" || speak.Payload.Format != "" {
+		t.Errorf("missing payload should leave the typed payload empty, got %+v", speak.Payload)
+	}
+	m = &Message{
+		Header:  map[string]string{"namespace": "SpeechRecognizer", "name": "ExpectSpeech"},
+		Payload: json.RawMessage(`"junk"`),
+	}
+	if expect, ok := m.Typed().(*ExpectSpeech); !ok || expect.Timeout() != 0 {
+		t.Errorf("malformed payload should leave the typed payload empty")
+	}
+}
+
+func TestMessageType(t *testing.T) {
+	directives := []MessageType{
+		TypeDeleteAlert, TypeSetAlert, TypeClearQueue, TypePlay, TypeStop,
+		TypeAdjustVolume, TypeSetMute, TypeSetVolume, TypeExpectSpeech,
+		TypeStopCapture, TypeSpeak, TypeResetUserInactivity, TypeSetEndpoint,
+		TypeException,
+	}
+	for _, typ := range directives {
+		m := &Message{Header: map[string]string{"namespace": typ.Namespace, "name": typ.Name}}
+		if m.Type() != typ {
+			t.Errorf("Type() = %v, want %v", m.Type(), typ)
+		}
+		if m.String() != typ.Key() {
+			t.Errorf("String() = %q, want %q", m.String(), typ.Key())
+		}
+		if _, ok := m.Typed().(*Message); ok {
+			t.Errorf("%v has no typed message", typ)
+		}
+	}
+	if typ := NewPlaybackState("", 0, PlayerActivityIdle).Type(); typ != TypePlaybackState {
+		t.Errorf("PlaybackState has type %v", typ)
+	}
+	if typ := (*Message)(nil).Type(); typ != (MessageType{}) {
+		t.Errorf("nil message has type %v", typ)
+	}
+}
+
+func TestRegisteredTypes(t *testing.T) {
+	keys := make(map[string]MessageType)
+	goTypes := make(map[reflect.Type]MessageType)
+	for _, typ := range RegisteredTypes() {
+		if other, ok := keys[typ.Key()]; ok {
+			t.Errorf("%#v and %#v have the same key", typ, other)
+		}
+		keys[typ.Key()] = typ
+		if other, ok := goTypes[registry[typ]]; ok {
+			t.Errorf("%s is registered for both %s and %s", registry[typ], typ, other)
+		}
+		goTypes[registry[typ]] = typ
+
+		zero := newRegistered(typ)
+		bind(zero, &Message{Header: map[string]string{"namespace": typ.Namespace, "name": typ.Name}})
+		data, err := json.Marshal(zero)
+		if err != nil {
+			t.Errorf("%s: %v", typ, err)
+			continue
+		}
+		var m Message
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Errorf("%s: %v", typ, err)
+			continue
+		}
+		if got, want := reflect.TypeOf(m.Typed()), reflect.PtrTo(registry[typ]); got != want {
+			t.Errorf("%s: Typed returned %s; want %s", typ, got, want)
+		}
+	}
+}
+
+// Catches message structs that were added without being registered, since
+// Typed wouldn't return them.
+func TestEveryMessageTypeIsRegistered(t *testing.T) {
+	registered := make(map[string]bool)
+	for _, goType := range registry {
+		registered[goType.Name()] = true
+	}
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range pkgs["avs"].Files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				spec := spec.(*ast.TypeSpec)
+				st, ok := spec.Type.(*ast.StructType)
+				if !ok || !embedsMessage(st) || registered[spec.Name.Name] {
+					continue
+				}
+				t.Errorf("%s embeds *Message but isn't in the registry", spec.Name.Name)
+			}
+		}
+	}
+}
+
+// The manifest generated by gen_manifest.go must agree with the registry, or
+// it's stale and go generate must be run again.
+func TestManifest(t *testing.T) {
+	n := 0
+	for file, messages := range manifest {
+		for _, m := range messages {
+			goType := reflect.TypeOf(m).Elem()
+			found := false
+			for _, registered := range registry {
+				if registered == goType {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Errorf("%s: %s is in the manifest but isn't registered", file, goType.Name())
+			}
+			n++
+		}
+	}
+	if n != len(registry) {
+		t.Errorf("the manifest has %d message types, the registry %d", n, len(registry))
+	}
+}
+
+func embedsMessage(st *ast.StructType) bool {
+	for _, field := range st.Fields.List {
+		if star, ok := field.Type.(*ast.StarExpr); ok && len(field.Names) == 0 {
+			if ident, ok := star.X.(*ast.Ident); ok && ident.Name == "Message" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Header fields that AVS may add in the future must survive every way of
+// decoding, copying and encoding a message.
+func TestUnknownHeaderFields(t *testing.T) {
+	extra := map[string]string{"eventCorrelationToken": "ect1", "futureField": "value"}
+	for _, typ := range RegisteredTypes() {
+		golden, err := ioutil.ReadFile(goldenPath(typ))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fixture map[string]interface{}
+		json.Unmarshal(golden, &fixture)
+		header := fixture["header"].(map[string]interface{})
+		for k, v := range extra {
+			header[k] = v
+		}
+		data, _ := json.Marshal(fixture)
+
+		var m Message
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatal(err)
+		}
+		streamed, err := TypedFromReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs := map[string]interface{}{
+			"Typed":                    m.Typed(),
+			"Clone":                    m.Clone(),
+			"Clone then Typed":         m.Clone().Typed(),
+			"TypedFromReader":          streamed,
+			"Clone of streamed":        streamed.GetMessage().Clone(),
+			"Typed of streamed":        streamed.GetMessage().Typed(),
+			"Clone of streamed, Typed": streamed.GetMessage().Clone().Typed(),
+		}
+		for name, out := range outputs {
+			got, err := json.Marshal(out)
+			if err != nil {
+				t.Fatalf("%s, %s: %v", typ, name, err)
+			}
+			if !jsonEqual(t, data, got) {
+				t.Errorf("%s, %s changed the message:\n got: %s\nwant: %s", typ, name, got, data)
+			}
+		}
+	}
+	m := &Message{Header: map[string]string{"namespace": "System", "name": "SetEndpoint", "futureField": "value"}}
+	c := m.Clone()
+	c.Header["futureField"] = "changed"
+	if m.Header["futureField"] != "value" {
+		t.Error("Clone shares the header with the original")
+	}
+}
+
+func TestHeaderFieldAccessors(t *testing.T) {
+	speak := new(Speak)
+	speak.Message = &Message{Header: map[string]string{
+		"namespace": "SpeechSynthesizer", "name": "Speak", "messageId": "m1", "dialogRequestId": "d1",
+	}}
+	if id, ok := speak.DialogRequestId(); id != "d1" || !ok {
+		t.Errorf("got %q, %t; want d1, true", id, ok)
+	}
+	delete(speak.Header, "dialogRequestId")
+	if id, ok := speak.DialogRequestId(); id != "" || ok {
+		t.Errorf("got %q, %t without the field", id, ok)
+	}
+	if err := speak.Validate(); err != nil {
+		t.Errorf("Speak without the optional field: %v", err)
+	}
+
+	recognize := NewRecognize("m1", "d1")
+	if err := recognize.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	delete(recognize.Header, "dialogRequestId")
+	if err := recognize.Validate(); !errors.Is(err, ErrInvalidMessage) || errors.Is(err, ErrNoHeader) {
+		t.Errorf("got %v for a Recognize without dialogRequestId", err)
+	}
+}
+
+func TestHeaderOverrides(t *testing.T) {
+	// The overridden names are the ones validated and parsed.
+	started := NewSpeechStarted("m1", "token", WithHeaderOverride("name", "SpeechFinished"))
+	if typ := started.Type(); typ != TypeSpeechFinished {
+		t.Errorf("got type %s; want the override", typ)
+	}
+	data, err := json.Marshal(started)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Message
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if finished, ok := m.Typed().(*SpeechFinished); !ok || finished.Payload.Token != "token" {
+		t.Errorf("got %#v; want a SpeechFinished with the payload", m.Typed())
+	}
+	timedOut := NewExpectSpeechTimedOut("m2", WithHeaderOverride("name", "Recognize"))
+	if err := timedOut.Validate(); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("got %v; want the Recognize rules", err)
+	}
+	recognize := NewRecognize("m3", "d1", WithHeaderOverride("namespace", "SpeechRecognizer2"), WithHeaderOverride("dialogRequestId", ""))
+	if err := recognize.Validate(); err != nil {
+		t.Errorf("got %v for an unknown namespace", err)
+	}
+	if _, ok := recognize.DialogRequestId(); ok {
+		t.Error("the empty override didn't remove the field")
+	}
+	state := NewVolumeState(50, false, WithHeaderOverride("namespace", "Speaker2"))
+	if typ := state.Type(); typ != (MessageType{"Speaker2", "VolumeState"}) {
+		t.Errorf("got context type %s", typ)
+	}
+
+	// A clone with another namespace keeps the header and the payload.
+	data, _ = json.Marshal(NewSpeechFinished("m4", "token"))
+	var original Message
+	if err := json.Unmarshal(data, &original); err != nil {
+		t.Fatal(err)
+	}
+	c := original.CloneWithNamespace("SpeechSynthesizer", "SpeechStarted")
+	if typ := original.Type(); typ != TypeSpeechFinished {
+		t.Errorf("the original became %s", typ)
+	}
+	if s, ok := c.Typed().(*SpeechStarted); !ok || s.Payload.Token != "token" || s.Header["messageId"] != "m4" {
+		t.Errorf("got %#v; want a SpeechStarted with the payload", c.Typed())
+	}
+	if c := c.CloneWithNamespace("SpeechSynthesizer2", "SpeechStarted"); c.Typed() != TypedMessage(c) {
+		t.Errorf("got %T for an unknown namespace; want the message itself", c.Typed())
+	}
+	var nilMessage *Message
+	if c := nilMessage.CloneWithNamespace("System", "SetEndpoint"); c != nil {
+		t.Errorf("got %v for a nil message", c)
+	}
+}
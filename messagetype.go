@@ -0,0 +1,85 @@
+package avs
+
+import (
+	"reflect"
+	"sort"
+)
+
+// MessageType identifies a kind of message by its namespace and name. It's
+// comparable, so it can be used in switch statements and as a map key:
+//
+//	switch directive.Type() {
+//	case avs.TypeSpeak:
+//		// ...
+//	}
+type MessageType struct {
+	Namespace string
+	Name      string
+}
+
+// Key returns the namespace and name as a single string (e.g.,
+// "SpeechSynthesizer.Speak").
+func (t MessageType) Key() string {
+	return t.Namespace + "." + t.Name
+}
+
+// String returns the same value as Key.
+func (t MessageType) String() string {
+	return t.Key()
+}
+
+// The Go types of the messages, registered by the file of each namespace
+// (e.g., alerts.go for the Alerts interface) in its init function. The
+// manifest generated from the declarations of the package checks that none
+// is missing. The registry is read-only after init, so it's safely shared by
+// all the clients of a process.
+//
+//go:generate go run gen_manifest.go
+var registry = make(map[MessageType]reflect.Type)
+
+// All the directives, to check which ones a Dispatcher handles.
+var directiveTypes []MessageType
+
+// Registers the Go type of the message, given as a zero value (e.g.,
+// Speak{}).
+func register(t MessageType, message interface{}) {
+	if _, ok := registry[t]; ok {
+		panic("avs: " + t.Key() + " registered twice")
+	}
+	registry[t] = reflect.TypeOf(message)
+}
+
+// Registers the Go type of a directive.
+func registerDirective(t MessageType, message interface{}) {
+	register(t, message)
+	directiveTypes = append(directiveTypes, t)
+}
+
+// RegisteredTypes returns the types of all the messages that have a specific
+// Go type, which Message.Typed returns, sorted by key.
+func RegisteredTypes() []MessageType {
+	types := make([]MessageType, 0, len(registry))
+	for t := range registry {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Key() < types[j].Key() })
+	return types
+}
+
+// RegisteredDirectives returns the registered types that are directives,
+// sorted by key.
+func RegisteredDirectives() []MessageType {
+	types := append([]MessageType(nil), directiveTypes...)
+	sort.Slice(types, func(i, j int) bool { return types[i].Key() < types[j].Key() })
+	return types
+}
+
+// Returns an empty value of the Go type registered for the message type, or
+// nil if there is none.
+func newRegistered(t MessageType) TypedMessage {
+	typ, ok := registry[t]
+	if !ok {
+		return nil
+	}
+	return reflect.New(typ).Interface().(TypedMessage)
+}
@@ -0,0 +1,109 @@
+package avs
+
+import (
+	"time"
+)
+
+// Metrics receives measurements from a Client and the downchannels it opens,
+// and from a Dispatcher. The durations are measured with their Clock, so
+// they're meant to be recorded in histograms.
+// Nil fields are ignored. The functions are called synchronously, so they
+// shouldn't block.
+type Metrics struct {
+	// QueueDepth is called with the number of directives buffered by a
+	// downchannel whenever it changes.
+	QueueDepth func(depth int)
+	// DirectiveDropped is called for every directive that a downchannel drops
+	// because of its backpressure policy.
+	DirectiveDropped func(directive *Message)
+	// DirectiveLatency is called for every directive in a response to an
+	// event, with the time from sending the event until the directive was
+	// received (e.g., from Recognize to its Speak directive).
+	DirectiveLatency func(directive *Message, latency time.Duration)
+	// HandlerLatency is called by a Dispatcher for every directive that it
+	// passes to a handler, with the time from receiving the directive until
+	// the handler completed, and the time spent in the handler. For
+	// directives that weren't received from AVS, both are the same.
+	HandlerLatency func(directive *Message, sinceReceived, handling time.Duration)
+	// PingLatency is called with the time it took AVS to answer every ping.
+	PingLatency func(latency time.Duration)
+	// DirectiveFiltered is called by a Dispatcher for every directive that
+	// its filters drop or reject.
+	DirectiveFiltered func(directive *Message, action FilterAction)
+	// WakeWordSuppressed is called by a SelfTriggerGuard whenever it
+	// suppresses the detection of the wake word (true) or stops suppressing
+	// it (false).
+	WakeWordSuppressed func(suppressed bool)
+	// ContextOverBudget is called by a ContextAggregator whenever the size
+	// of the contexts it gathered, in bytes, is over its budget, before
+	// they're trimmed.
+	ContextOverBudget func(size, budget int)
+	// RetryBudgetExhausted is called whenever a Client gives up on retrying
+	// a request because the RetryBudget of its policy has no retries left,
+	// with the exception code that would have been retried.
+	RetryBudgetExhausted func(code ExceptionCode)
+	// MetadataCompressed is called for the metadata part of every event
+	// sent by a Client with CompressMetadata, with its size in bytes before
+	// and after compression.
+	MetadataCompressed func(uncompressed, compressed int)
+}
+
+func (m *Metrics) queueDepth(depth int) {
+	if m != nil && m.QueueDepth != nil {
+		m.QueueDepth(depth)
+	}
+}
+
+func (m *Metrics) directiveDropped(directive *Message) {
+	if m != nil && m.DirectiveDropped != nil {
+		m.DirectiveDropped(directive)
+	}
+}
+
+func (m *Metrics) directiveLatency(directive *Message, latency time.Duration) {
+	if m != nil && m.DirectiveLatency != nil {
+		m.DirectiveLatency(directive, latency)
+	}
+}
+
+func (m *Metrics) handlerLatency(directive *Message, sinceReceived, handling time.Duration) {
+	if m != nil && m.HandlerLatency != nil {
+		m.HandlerLatency(directive, sinceReceived, handling)
+	}
+}
+
+func (m *Metrics) pingLatency(latency time.Duration) {
+	if m != nil && m.PingLatency != nil {
+		m.PingLatency(latency)
+	}
+}
+
+func (m *Metrics) directiveFiltered(directive *Message, action FilterAction) {
+	if m != nil && m.DirectiveFiltered != nil {
+		m.DirectiveFiltered(directive, action)
+	}
+}
+
+func (m *Metrics) wakeWordSuppressed(suppressed bool) {
+	if m != nil && m.WakeWordSuppressed != nil {
+		m.WakeWordSuppressed(suppressed)
+	}
+}
+
+func (m *Metrics) contextOverBudget(size, budget int) {
+	if m != nil && m.ContextOverBudget != nil {
+		m.ContextOverBudget(size, budget)
+	}
+}
+
+func (m *Metrics) retryBudgetExhausted(code ExceptionCode) {
+	if m != nil && m.RetryBudgetExhausted != nil {
+		m.RetryBudgetExhausted(code)
+	}
+}
+
+func (m *Metrics) metadataCompressed(uncompressed, compressed int) {
+	if m != nil && m.MetadataCompressed != nil {
+		m.MetadataCompressed(uncompressed, compressed)
+	}
+}
@@ -0,0 +1,75 @@
+package avs_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+	"github.com/fika-io/go-avs/avstest"
+)
+
+func TestLatencyMetrics(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := avstest.NewFakeClock(start)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clock.Advance(300 * time.Millisecond)
+		w.Header().Set("Content-Type", "multipart/related; boundary=------abcde123; type=application/json")
+		w.WriteHeader(200)
+		fmt.Fprint(w, "--------abcde123\r\nContent-Type: application/json\r\n\r\n"+
+			`{"directive":{"header":{"namespace":"SpeechSynthesizer","name":"Speak","messageId":"m1"},"payload":{"url":"cid:abc"}}}`+
+			"\r\n--------abcde123--\r\n")
+	}))
+	defer server.Close()
+
+	var observed []string
+	metrics := &avs.Metrics{
+		DirectiveLatency: func(directive *avs.Message, latency time.Duration) {
+			observed = append(observed, fmt.Sprintf("%s arrived after %s", directive, latency))
+		},
+		HandlerLatency: func(directive *avs.Message, sinceReceived, handling time.Duration) {
+			observed = append(observed, fmt.Sprintf("%s handled after %s in %s", directive, sinceReceived, handling))
+		},
+	}
+	client := &avs.Client{EndpointURL: server.URL, Clock: clock, Metrics: metrics}
+	request := avs.NewRequest("token")
+	request.Event = avs.NewRecognize("m0", "d0")
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	directive := response.Directives[0]
+	if got := directive.Metadata().ReceivedAt; !got.Equal(start.Add(300 * time.Millisecond)) {
+		t.Errorf("got ReceivedAt %s", got)
+	}
+	if typed := directive.Typed(); !typed.GetMessage().Metadata().ReceivedAt.Equal(directive.Metadata().ReceivedAt) {
+		t.Error("the typed directive should have the same metadata")
+	}
+
+	clock.Advance(20 * time.Millisecond)
+	d := avs.NewDispatcher()
+	d.Clock = clock
+	d.Metrics = metrics
+	d.HandleFunc("SpeechSynthesizer.Speak", func(ctx context.Context, directive avs.TypedMessage) error {
+		clock.Advance(50 * time.Millisecond)
+		return nil
+	})
+	if err := d.Dispatch(context.Background(), directive); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Dispatch(context.Background(), directive.Clone()); err != nil {
+		t.Fatal(err)
+	}
+	want := "[SpeechSynthesizer.Speak arrived after 300ms" +
+		" SpeechSynthesizer.Speak handled after 70ms in 50ms" +
+		" SpeechSynthesizer.Speak handled after 120ms in 50ms]"
+	if got := fmt.Sprint(observed); got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+	if (&avs.Message{}).Metadata() != (avs.MessageMetadata{}) {
+		t.Error("new messages have no metadata")
+	}
+}
@@ -0,0 +1,71 @@
+package avs_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/fika-io/go-avs"
+	"github.com/fika-io/go-avs/avstest"
+)
+
+// A gateway runs many devices in one process, each with its own client.
+func TestConcurrentClients(t *testing.T) {
+	const devices = 50
+	server := avstest.NewServer()
+	defer server.Close()
+	shared := avs.NewTransport()
+	defer shared.CloseIdleConnections()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, devices)
+	for i := 0; i < devices; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			opts := []avs.Option{avs.WithEndpointURL(server.URL)}
+			if i%2 == 0 {
+				opts = append(opts, avs.WithIsolatedTransport())
+			} else {
+				opts = append(opts, avs.WithTransport(shared))
+			}
+			client, err := avs.NewClient(opts...)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer client.CloseIdleConnections()
+			token := fmt.Sprintf("token%d", i)
+			d, err := client.OpenDownchannel(token)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer d.Close()
+			if _, err := client.Do(avs.NewSynchronizeStateRequest(token, fmt.Sprintf("m%d", i), nil)); err != nil {
+				errs <- err
+				return
+			}
+			if err := client.Ping(token); err != nil {
+				errs <- err
+				return
+			}
+			if !client.Health().Connected {
+				errs <- fmt.Errorf("client %d isn't connected", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	tokens := make(map[string]bool)
+	for _, r := range server.Requests() {
+		tokens[r.AccessToken] = true
+	}
+	if len(tokens) != devices {
+		t.Errorf("got events from %d devices; want %d", len(tokens), devices)
+	}
+}
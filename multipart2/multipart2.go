@@ -6,11 +6,13 @@ package multipart2
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
 	"net/textproto"
+	"strings"
 )
 
 const (
@@ -22,19 +24,152 @@ const (
 
 const peekBufferSize = 1024
 
+// The limits used by a Reader whose limits are zero.
+const (
+	DefaultMaxHeaderBytes = 16 << 10
+	DefaultMaxHeaders     = 64
+)
+
+// ErrPartTooLarge is the error matched by a PartTooLargeError.
+var ErrPartTooLarge = errors.New("multipart: part too large")
+
+// PartTooLargeError is returned when the header or the body of a part exceeds
+// its limit. It identifies the part by its index in the stream (from 0), and
+// its header, if it was read.
+type PartTooLargeError struct {
+	Index  int
+	Header textproto.MIMEHeader
+	// What exceeded the limit ("header" or "body") and the limit in bytes,
+	// or in header fields for a header with too many fields.
+	What  string
+	Limit int64
+}
+
+func (e *PartTooLargeError) Error() string {
+	desc := ""
+	if ct := e.Header.Get("Content-Type"); ct != "" {
+		desc = " (" + ct + ")"
+	}
+	return fmt.Sprintf("multipart: %s of part %d%s exceeds the limit of %d", e.What, e.Index, desc, e.Limit)
+}
+
+// Unwrap returns ErrPartTooLarge.
+func (e *PartTooLargeError) Unwrap() error {
+	return ErrPartTooLarge
+}
+
+// FormatError is returned when the multipart stream is malformed. Offset is
+// the position in the stream (counted in bytes from the start) at which the
+// problem was detected.
+type FormatError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("multipart: %v at offset %d", e.Err, e.Offset)
+}
+
+// Unwrap returns the underlying error.
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// ParseContentType parses a multipart Content-Type header value and returns
+// its media type and boundary. Unlike mime.ParseMediaType, it tolerates
+// parameter values that aren't quoted even though they should be (e.g.,
+// type=application/json), which some servers send.
+func ParseContentType(v string) (mediatype, boundary string, err error) {
+	mediatype, params, err := mime.ParseMediaType(v)
+	if err != nil {
+		mediatype, params, err = parseMediaTypeLenient(v)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	if !strings.HasPrefix(mediatype, "multipart/") {
+		return "", "", fmt.Errorf("unexpected content type %s", mediatype)
+	}
+	if params["boundary"] == "" {
+		return "", "", fmt.Errorf("missing boundary in content type %q", v)
+	}
+	return mediatype, params["boundary"], nil
+}
+
+func parseMediaTypeLenient(v string) (string, map[string]string, error) {
+	fields := strings.Split(v, ";")
+	mediatype := strings.ToLower(strings.TrimSpace(fields[0]))
+	if mediatype == "" {
+		return "", nil, fmt.Errorf("invalid content type %q", v)
+	}
+	params := make(map[string]string)
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		value := strings.TrimSpace(kv[1])
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		params[key] = value
+	}
+	return mediatype, params, nil
+}
+
 type Part struct {
 	Header            textproto.MIMEHeader
 	reader            io.Reader
 	partReader        *partReader
 	disposition       string
 	dispositionParams map[string]string
+
+	index    int
+	limit    int64 // zero for no limit
+	read     int64
+	tooLarge error
+}
+
+// SetLimit sets the maximum size in bytes of the body of the part. Reading
+// beyond it returns a PartTooLargeError. Zero or less means no limit.
+func (p *Part) SetLimit(n int64) {
+	p.limit = n
+}
+
+// Index returns the position of the part in the stream, from 0.
+func (p *Part) Index() int {
+	return p.index
 }
 
+// Close discards the rest of the part and releases its buffers. Reading
+// from the part after closing it returns io.EOF. It returns the error that
+// kept the rest of the part from being read (e.g., a stream that ends within
+// the part), after which the Reader can't go on.
 func (p *Part) Close() error {
-	if p.partReader == nil {
-		return nil
+	var err error
+	if p.partReader != nil {
+		err = p.partReader.Close()
+	}
+	if rd, ok := p.reader.(*bufio.Reader); ok {
+		p.reader = eofReader{}
+		putBufioReader(rd)
 	}
-	return p.partReader.Close()
+	return err
+}
+
+// ReadAll reads the rest of the part and returns it. The data is read into a
+// pooled buffer and copied out, so the returned slice belongs to the caller
+// and remains valid after the part is closed.
+func (p *Part) ReadAll() ([]byte, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if _, err := buf.ReadFrom(p); err != nil {
+		return nil, err
+	}
+	data := make([]byte, buf.Len())
+	copy(data, buf.Bytes())
+	return data, nil
 }
 
 func (p *Part) FormName() string {
@@ -63,25 +198,81 @@ func (p *Part) parseContentDisposition() {
 	}
 }
 
-func newPart(r *Reader) *Part {
-	p := new(Part)
+func newPart(r *Reader) (*Part, error) {
+	p := &Part{index: r.partsRead}
 	if r.currentPart != nil {
 		panic("newPart: expected reader.currentPart to be nil")
 	}
 	r.currentPart = p
 	p.partReader = &partReader{r, p, false}
-	rd := bufio.NewReader(p.partReader)
-	if header, _ := textproto.NewReader(rd).ReadMIMEHeader(); header != nil {
-		p.Header = header
-	} else {
-		p.Header = textproto.MIMEHeader{}
-	}
+	// The header is read through a limiter, so that the buffered reader
+	// can't read more of the part than the header may take.
+	limiter := &headerLimiter{r: p.partReader, remaining: r.maxHeaderBytes()}
+	rd := getBufioReader(limiter)
 	p.reader = rd
-	return p
+	header, _ := textproto.NewReader(rd).ReadMIMEHeader()
+	if limiter.exceeded {
+		return p, &PartTooLargeError{Index: p.index, What: "header", Limit: int64(r.maxHeaderBytes())}
+	}
+	limiter.remaining = -1
+	if header == nil {
+		header = textproto.MIMEHeader{}
+	}
+	p.Header = header
+	fields := 0
+	for _, values := range header {
+		fields += len(values)
+	}
+	if fields > r.maxHeaders() {
+		return p, &PartTooLargeError{Index: p.index, Header: header, What: "header", Limit: int64(r.maxHeaders())}
+	}
+	return p, nil
 }
 
 func (p *Part) Read(d []byte) (n int, err error) {
-	return p.reader.Read(d)
+	if p.limit <= 0 {
+		return p.reader.Read(d)
+	}
+	if p.tooLarge != nil {
+		return 0, p.tooLarge
+	}
+	// Read one byte more than allowed to tell whether the part is too large.
+	if max := p.limit - p.read + 1; int64(len(d)) > max {
+		d = d[:max]
+	}
+	n, err = p.reader.Read(d)
+	p.read += int64(n)
+	if p.read > p.limit {
+		n -= int(p.read - p.limit)
+		p.read = p.limit
+		p.tooLarge = &PartTooLargeError{Index: p.index, Header: p.Header, What: "body", Limit: p.limit}
+		return n, p.tooLarge
+	}
+	return n, err
+}
+
+// Passes the bytes of a part header through, then the whole part once
+// remaining is negative.
+type headerLimiter struct {
+	r         io.Reader
+	remaining int
+	exceeded  bool
+}
+
+func (l *headerLimiter) Read(d []byte) (int, error) {
+	if l.remaining < 0 {
+		return l.r.Read(d)
+	}
+	if l.remaining == 0 {
+		l.exceeded = true
+		return 0, ErrPartTooLarge
+	}
+	if len(d) > l.remaining {
+		d = d[:l.remaining]
+	}
+	n, err := l.r.Read(d)
+	l.remaining -= n
+	return n, err
 }
 
 type partReader struct {
@@ -91,10 +282,11 @@ type partReader struct {
 }
 
 func (pr *partReader) Close() error {
-	if pr.r != nil {
-		io.Copy(ioutil.Discard, pr)
+	if pr.r == nil {
+		return nil
 	}
-	return nil
+	_, err := io.Copy(ioutil.Discard, pr)
+	return err
 }
 
 func (pr *partReader) Read(d []byte) (n int, err error) {
@@ -161,7 +353,7 @@ func (pr *partReader) Read(d []byte) (n int, err error) {
 				pr.r.r += n
 				pr.needsTopUp = true
 				if err == io.EOF {
-					err = io.ErrUnexpectedEOF
+					err = pr.r.formatError(io.ErrUnexpectedEOF)
 				}
 				return
 			}
@@ -171,18 +363,25 @@ func (pr *partReader) Read(d []byte) (n int, err error) {
 	n = copy(d, pr.r.buf[pr.r.r:pr.r.w])
 	pr.r.r += n
 	if err == io.EOF {
-		err = io.ErrUnexpectedEOF
+		err = pr.r.formatError(io.ErrUnexpectedEOF)
 	}
 	return
 }
 
 type Reader struct {
+	// MaxHeaderBytes is the maximum size of the header of a part, and
+	// MaxHeaders the maximum number of fields in it. Zero means
+	// DefaultMaxHeaderBytes and DefaultMaxHeaders.
+	MaxHeaderBytes int
+	MaxHeaders     int
+
 	reader         io.Reader
 	buf            []byte
 	partsRead      int
 	currentPart    *Part
 	state          int
 	r, w           int
+	consumed       int64
 	dash           []byte
 	dashBoundary   []byte
 	nl             []byte
@@ -207,7 +406,13 @@ func (r *Reader) NextPart() (*Part, error) {
 			return nil, io.EOF
 		}
 		if r.state == sInsidePart {
-			r.currentPart.Close()
+			if err := r.currentPart.Close(); err != nil {
+				return nil, err
+			}
+			if r.state == sInsidePart {
+				// The part ended without its boundary.
+				return nil, r.formatError(io.ErrUnexpectedEOF)
+			}
 			continue
 		}
 		line, err := r.readSlice('\n')
@@ -216,12 +421,12 @@ func (r *Reader) NextPart() (*Part, error) {
 		}
 		if r.state == sAfterPart {
 			if !bytes.Equal(line, r.nl) {
-				return nil, fmt.Errorf("expected newline, got %#v", string(line))
+				return nil, r.formatError(fmt.Errorf("expected newline, got %#v", string(line)))
 			}
 			r.state = sExpectingPart
 		}
 		if r.state != sExpectingPart {
-			return nil, fmt.Errorf("expected state to be %d, was %d", sExpectingPart, r.state)
+			return nil, r.formatError(fmt.Errorf("expected state to be %d, was %d", sExpectingPart, r.state))
 		}
 		if bytes.HasPrefix(line, r.dashBoundary) {
 			rest := line[len(r.dashBoundary):]
@@ -234,12 +439,59 @@ func (r *Reader) NextPart() (*Part, error) {
 			}
 			if bytes.Equal(rest, r.nl) {
 				r.state = sInsidePart
-				return newPart(r), nil
+				p, err := newPart(r)
+				if err != nil {
+					return nil, err
+				}
+				return p, nil
 			}
 		}
 	}
 }
 
+// ReadParts reads every part in the stream and calls fn for each of them. The
+// part is closed once fn returns. ReadParts stops at the end of the stream,
+// at the first error returned by fn, or at the first malformed section.
+func (r *Reader) ReadParts(fn func(*Part) error) error {
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(p)
+		p.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *Reader) maxHeaderBytes() int {
+	if r.MaxHeaderBytes > 0 {
+		return r.MaxHeaderBytes
+	}
+	return DefaultMaxHeaderBytes
+}
+
+func (r *Reader) maxHeaders() int {
+	if r.MaxHeaders > 0 {
+		return r.MaxHeaders
+	}
+	return DefaultMaxHeaders
+}
+
+// Returns the offset in the stream of the next unread byte.
+func (r *Reader) offset() int64 {
+	return r.consumed - int64(r.w-r.r)
+}
+
+func (r *Reader) formatError(err error) error {
+	return &FormatError{Offset: r.offset(), Err: err}
+}
+
 func (r *Reader) readSlice(delim byte) (line []byte, err error) {
 	for {
 		idx := bytes.IndexByte(r.buf[r.r:r.w], delim)
@@ -262,9 +514,15 @@ func (r *Reader) topUp() error {
 		r.r = 0
 	}
 	if r.w >= len(r.buf) {
-		return fmt.Errorf("can't top up full buffer")
+		return r.formatError(fmt.Errorf("can't top up full buffer"))
 	}
 	n, err := r.reader.Read(r.buf[r.w:])
 	r.w += n
+	r.consumed += int64(n)
+	if n > 0 {
+		// Readers may return the last bytes along with io.EOF. The error is
+		// returned again by the next read, once the bytes have been used.
+		return nil
+	}
 	return err
 }
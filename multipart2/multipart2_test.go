@@ -10,6 +10,7 @@ package multipart2
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -18,6 +19,8 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func escapeString(v string) string {
@@ -108,6 +111,11 @@ func TestMultipartSlowInput(t *testing.T) {
 	testMultipart(t, &slowReader{bodyReader}, false)
 }
 
+func TestMultipartDataWithEOF(t *testing.T) {
+	bodyReader := strings.NewReader(testMultipartBody("\r\n"))
+	testMultipart(t, iotest.DataErrReader(bodyReader), false)
+}
+
 func testMultipart(t *testing.T, r io.Reader, onlyNewlines bool) {
 	reader := NewReader(r, "MyBoundary")
 	buf := new(bytes.Buffer)
@@ -291,9 +299,48 @@ Oh no, premature EOF!
 		t.Fatalf("didn't get a part")
 	}
 	_, err = io.Copy(ioutil.Discard, part)
-	if err != io.ErrUnexpectedEOF {
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
 		t.Fatalf("expected error io.ErrUnexpectedEOF; got %v", err)
 	}
+	var ferr *FormatError
+	if !errors.As(err, &ferr) {
+		t.Fatalf("expected a *FormatError; got %T", err)
+	}
+	if start := int64(strings.Index(body, "Oh no")); ferr.Offset < start || ferr.Offset > int64(len(body)) {
+		t.Errorf("FormatError.Offset = %d; want within [%d, %d]", ferr.Offset, start, len(body))
+	}
+}
+
+// A part truncated mid-body fails the next part instead of looping forever,
+// whether it was read or not.
+func TestNextPartTruncated(t *testing.T) {
+	body := "--MyBoundary\r\nfoo-bar: baz\r\n\r\nOh no, premature EOF!"
+	for _, read := range []bool{false, true} {
+		r := NewReader(strings.NewReader(body), "MyBoundary")
+		part, err := r.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read {
+			ioutil.ReadAll(part)
+		}
+		done := make(chan error, 1)
+		go func() {
+			_, err := r.NextPart()
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("read %t: got %v; want io.ErrUnexpectedEOF", read, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("read %t: NextPart didn't return", read)
+		}
+		if err := part.Close(); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("read %t: Close returned %v", read, err)
+		}
+	}
 }
 
 type slowReader struct {
@@ -699,3 +746,173 @@ func roundTripParseTest() parseTest {
 	t.sep = w.Boundary()
 	return t
 }
+
+func TestParseContentType(t *testing.T) {
+	tests := []struct {
+		in, mediatype, boundary string
+	}{
+		{`multipart/related; boundary=abc123`, "multipart/related", "abc123"},
+		{`multipart/related; boundary="abc123"`, "multipart/related", "abc123"},
+		{`multipart/related; boundary=------abcde123; type=application/json`, "multipart/related", "------abcde123"},
+		{`multipart/related; type=application/json; boundary="abc 123"`, "multipart/related", "abc 123"},
+		{`multipart/form-data; boundary=abc123; charset=UTF-8`, "multipart/form-data", "abc123"},
+	}
+	for _, test := range tests {
+		mediatype, boundary, err := ParseContentType(test.in)
+		if err != nil {
+			t.Errorf("ParseContentType(%q): %v", test.in, err)
+			continue
+		}
+		expectEq(t, test.mediatype, mediatype, "media type of "+test.in)
+		expectEq(t, test.boundary, boundary, "boundary of "+test.in)
+	}
+	for _, in := range []string{"application/json", "multipart/related", ""} {
+		if _, _, err := ParseContentType(in); err == nil {
+			t.Errorf("ParseContentType(%q): expected an error", in)
+		}
+	}
+}
+
+// A capture of a downchannel stream with a keep-alive (empty) part and a
+// directive part without a Content-Length.
+var downchannelCapture = "--------abcde123\r\n" +
+	"\r\n" +
+	"--------abcde123\r\n" +
+	"Content-Type: application/json; charset=UTF-8\r\n" +
+	"\r\n" +
+	`{"directive":{"header":{"namespace":"Alerts","name":"DeleteAlert","messageId":"abc"},"payload":{"token":"t"}}}` + "\r\n" +
+	"--------abcde123\r\n" +
+	"\r\n" +
+	"--------abcde123--\r\n"
+
+func TestReadParts(t *testing.T) {
+	for _, nl := range []string{"\r\n", "\n"} {
+		body := strings.Replace(downchannelCapture, "\r\n", nl, -1)
+		r := NewReader(strings.NewReader(body), "------abcde123")
+		var bodies []string
+		err := r.ReadParts(func(p *Part) error {
+			data, err := ioutil.ReadAll(p)
+			bodies = append(bodies, string(data))
+			return err
+		})
+		if err != nil {
+			t.Fatalf("ReadParts: %v", err)
+		}
+		if len(bodies) != 3 {
+			t.Fatalf("got %d parts; want 3", len(bodies))
+		}
+		expectEq(t, "", bodies[0], "keep-alive part")
+		if !strings.HasPrefix(bodies[1], `{"directive":`) {
+			t.Errorf("unexpected directive part %q", bodies[1])
+		}
+		expectEq(t, "", bodies[2], "keep-alive part")
+	}
+}
+
+func TestReadPartsCallbackError(t *testing.T) {
+	r := NewReader(strings.NewReader(downchannelCapture), "------abcde123")
+	stop := errors.New("stop")
+	n := 0
+	err := r.ReadParts(func(p *Part) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("ReadParts = %v after %d parts; want %v after 1 part", err, n, stop)
+	}
+}
+
+func TestPartLimit(t *testing.T) {
+	body := "--b\r\nContent-Type: application/json\r\n\r\n0123456789\r\n" +
+		"--b\r\nContent-Type: application/octet-stream\r\n\r\n0123456789abcdef\r\n--b--\r\n"
+	r := NewReader(strings.NewReader(body), "b")
+	for i, want := range []string{"0123456789", ""} {
+		p, err := r.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		p.SetLimit(10)
+		data, err := ioutil.ReadAll(p)
+		if i == 0 {
+			if err != nil {
+				t.Fatalf("part 0: %v", err)
+			}
+			expectEq(t, want, string(data), "part 0")
+			continue
+		}
+		var tooLarge *PartTooLargeError
+		if !errors.As(err, &tooLarge) || !errors.Is(err, ErrPartTooLarge) {
+			t.Fatalf("part 1: got error %v; want a PartTooLargeError", err)
+		}
+		if tooLarge.Index != 1 || tooLarge.What != "body" || tooLarge.Limit != 10 ||
+			tooLarge.Header.Get("Content-Type") != "application/octet-stream" {
+			t.Errorf("got %+v", tooLarge)
+		}
+		if len(data) != 10 {
+			t.Errorf("read %d bytes; want the 10 allowed", len(data))
+		}
+	}
+}
+
+func TestHeaderLimits(t *testing.T) {
+	long := "--b\r\nX-Long: " + strings.Repeat("a", 100) + "\r\n\r\nbody\r\n--b--\r\n"
+	many := "--b\r\n" + strings.Repeat("X-Field: a\r\n", 5) + "\r\nbody\r\n--b--\r\n"
+	tests := []struct {
+		body      string
+		bytes, n  int
+		wantError bool
+	}{
+		{long, 0, 0, false},
+		{long, 64, 0, true},
+		{many, 0, 0, false},
+		{many, 0, 4, true},
+		{many, 0, 5, false},
+	}
+	for i, test := range tests {
+		r := NewReader(strings.NewReader(test.body), "b")
+		r.MaxHeaderBytes = test.bytes
+		r.MaxHeaders = test.n
+		p, err := r.NextPart()
+		if !test.wantError {
+			if err != nil {
+				t.Errorf("%d: %v", i, err)
+				continue
+			}
+			data, _ := ioutil.ReadAll(p)
+			expectEq(t, "body", string(data), fmt.Sprintf("%d: body", i))
+			continue
+		}
+		var tooLarge *PartTooLargeError
+		if !errors.As(err, &tooLarge) || tooLarge.What != "header" || tooLarge.Index != 0 {
+			t.Errorf("%d: got error %v; want a PartTooLargeError for the header", i, err)
+		}
+		if p != nil {
+			t.Errorf("%d: got a part with the error", i)
+		}
+	}
+}
+
+func FuzzReader(f *testing.F) {
+	f.Add(downchannelCapture)
+	f.Add(strings.Replace(downchannelCapture, "\r\n", "\n", -1))
+	f.Add(downchannelCapture[:len(downchannelCapture)/2])
+	f.Fuzz(func(t *testing.T, body string) {
+		r := NewReader(strings.NewReader(body), "------abcde123")
+		for i := 0; i < 100; i++ {
+			p, err := r.NextPart()
+			if err != nil {
+				return
+			}
+			if _, err := io.Copy(ioutil.Discard, p); err != nil {
+				var ferr *FormatError
+				if !errors.As(err, &ferr) {
+					t.Fatalf("expected a *FormatError; got %T: %v", err, err)
+				}
+				if ferr.Offset < 0 || ferr.Offset > int64(len(body)) {
+					t.Fatalf("offset %d out of range", ferr.Offset)
+				}
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package multipart2
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+)
+
+// MaxPooledBufferSize is the largest capacity, in bytes, of a buffer that is
+// kept for reuse once a part has been read. Larger buffers are left to the
+// garbage collector so that a single unusually large part doesn't stay pinned
+// in memory. It should be set before any parsing starts.
+var MaxPooledBufferSize = 4 << 20
+
+var (
+	buffers      = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+	bufioReaders sync.Pool
+)
+
+func getBuffer() *bytes.Buffer {
+	return buffers.Get().(*bytes.Buffer)
+}
+
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > MaxPooledBufferSize {
+		return
+	}
+	b.Reset()
+	buffers.Put(b)
+}
+
+func getBufioReader(r io.Reader) *bufio.Reader {
+	if rd, ok := bufioReaders.Get().(*bufio.Reader); ok {
+		rd.Reset(r)
+		return rd
+	}
+	return bufio.NewReader(r)
+}
+
+func putBufioReader(rd *bufio.Reader) {
+	rd.Reset(nil)
+	bufioReaders.Put(rd)
+}
+
+// Returned by parts once they've been released.
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
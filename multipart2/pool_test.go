@@ -0,0 +1,105 @@
+package multipart2
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"testing"
+)
+
+// Builds a response body with a Speak directive and an attachment of the
+// provided size.
+func speakResponse(size int) (body []byte, boundary string) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteJSON("metadata", map[string]interface{}{
+		"directive": map[string]interface{}{
+			"header":  map[string]string{"namespace": "SpeechSynthesizer", "name": "Speak"},
+			"payload": map[string]string{"url": "cid:audio", "format": "AUDIO_MPEG"},
+		},
+	})
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-ID", "<audio>")
+	h.Set("Content-Type", "application/octet-stream")
+	p, _ := w.CreatePart(h)
+	p.Write(bytes.Repeat([]byte{0xff, 0xfb, 0x90, 0x64}, size/4))
+	w.Close()
+	return buf.Bytes(), w.Boundary()
+}
+
+func TestPartReadAll(t *testing.T) {
+	body, boundary := speakResponse(64 << 10)
+	r := NewReader(bytes.NewReader(body), boundary)
+	var parts [][]byte
+	var closed []*Part
+	err := r.ReadParts(func(p *Part) error {
+		data, err := p.ReadAll()
+		if err != nil {
+			return err
+		}
+		parts = append(parts, data)
+		closed = append(closed, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("got %d parts, want 2", len(parts))
+	}
+	if len(parts[1]) != 64<<10 || !bytes.Equal(parts[1][:4], []byte{0xff, 0xfb, 0x90, 0x64}) {
+		t.Errorf("attachment was not kept intact after close (%d bytes)", len(parts[1]))
+	}
+	for _, p := range closed {
+		if n, err := p.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+			t.Errorf("read after close = %d, %v; want 0, EOF", n, err)
+		}
+	}
+}
+
+func BenchmarkReadSpeakAttachment(b *testing.B) {
+	body, boundary := speakResponse(2 << 20)
+	b.Run("ReadAll", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(body)))
+		for i := 0; i < b.N; i++ {
+			r := NewReader(bytes.NewReader(body), boundary)
+			err := r.ReadParts(func(p *Part) error {
+				_, err := p.ReadAll()
+				return err
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ioutil.ReadAll", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(body)))
+		for i := 0; i < b.N; i++ {
+			r := NewReader(bytes.NewReader(body), boundary)
+			err := r.ReadParts(func(p *Part) error {
+				_, err := ioutil.ReadAll(p)
+				return err
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Stream", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(body)))
+		for i := 0; i < b.N; i++ {
+			r := NewReader(bytes.NewReader(body), boundary)
+			err := r.ReadParts(func(p *Part) error {
+				_, err := io.Copy(ioutil.Discard, p)
+				return err
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package multipart2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+// Writer generates multipart/form-data bodies. Unlike the built-in
+// mime/multipart package, every write is flushed to the underlying writer
+// (if it supports flushing) so that streamed parts hit the wire promptly.
+type Writer struct {
+	w *multipart.Writer
+}
+
+// NewWriter returns a new multipart Writer that writes to w. If w implements
+// either Flush() or Flush() error, it will be flushed after every write.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{multipart.NewWriter(&flushWriter{w})}
+}
+
+// Boundary returns the Writer's boundary.
+func (w *Writer) Boundary() string {
+	return w.w.Boundary()
+}
+
+// FormDataContentType returns the Content-Type for a multipart/form-data
+// body with this Writer's boundary.
+func (w *Writer) FormDataContentType() string {
+	return w.w.FormDataContentType()
+}
+
+// CreatePart creates a new part with the provided header.
+func (w *Writer) CreatePart(header textproto.MIMEHeader) (io.Writer, error) {
+	return w.w.CreatePart(header)
+}
+
+// CreateOctetStream creates a new form-data part with the provided field name
+// and the Content-Type application/octet-stream.
+func (w *Writer) CreateOctetStream(fieldname string) (io.Writer, error) {
+	return w.CreateFormPart(fieldname, "application/octet-stream", nil)
+}
+
+// CreateFormPart creates a new form-data part with the provided field name,
+// Content-Type and extra header fields (e.g., Content-ID), which may be nil.
+func (w *Writer) CreateFormPart(fieldname, contentType string, header textproto.MIMEHeader) (io.Writer, error) {
+	h := make(textproto.MIMEHeader, len(header)+2)
+	for k, v := range header {
+		h[k] = v
+	}
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(fieldname)))
+	h.Set("Content-Type", contentType)
+	return w.w.CreatePart(h)
+}
+
+// WriteJSON encodes a JSON value and writes it to a form-data part with the
+// provided field name and the Content-Type application/json; charset=UTF-8.
+func (w *Writer) WriteJSON(fieldname string, value interface{}) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := json.NewEncoder(buf).Encode(value); err != nil {
+		return err
+	}
+	// Drop the newline added by Encode.
+	return w.WriteRawJSON(fieldname, bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
+
+// WriteRawJSON writes data, which must be encoded JSON, to a form-data part
+// with the provided field name and the Content-Type application/json;
+// charset=UTF-8.
+func (w *Writer) WriteRawJSON(fieldname string, data []byte) error {
+	p, err := w.CreateFormPart(fieldname, "application/json; charset=UTF-8", nil)
+	if err != nil {
+		return err
+	}
+	_, err = p.Write(data)
+	return err
+}
+
+// Close finishes the multipart message by writing the trailing boundary.
+func (w *Writer) Close() error {
+	return w.w.Close()
+}
+
+// Wraps a writer and flushes it after every write, if supported.
+type flushWriter struct {
+	w io.Writer
+}
+
+func (fw *flushWriter) Write(p []byte) (n int, err error) {
+	n, err = fw.w.Write(p)
+	if err != nil {
+		return
+	}
+	switch f := fw.w.(type) {
+	case interface{ Flush() error }:
+		err = f.Flush()
+	case interface{ Flush() }:
+		f.Flush()
+	}
+	return
+}
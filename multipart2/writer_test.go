@@ -0,0 +1,119 @@
+package multipart2
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+type flushCounter struct {
+	bytes.Buffer
+	flushes int
+}
+
+func (fc *flushCounter) Flush() {
+	fc.flushes++
+}
+
+func TestWriterParts(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.WriteJSON("metadata", map[string]string{"hello": "world"}); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	p, err := w.CreateOctetStream("audio")
+	if err != nil {
+		t.Fatalf("CreateOctetStream: %v", err)
+	}
+	p.Write([]byte("AUDIO"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if ct := w.FormDataContentType(); !strings.HasSuffix(ct, "boundary="+w.Boundary()) {
+		t.Errorf("FormDataContentType = %q", ct)
+	}
+
+	r := NewReader(&buf, w.Boundary())
+	tests := []struct {
+		name, contentType, body string
+	}{
+		{"metadata", "application/json; charset=UTF-8", `{"hello":"world"}`},
+		{"audio", "application/octet-stream", "AUDIO"},
+	}
+	for _, test := range tests {
+		part, err := r.NextPart()
+		if err != nil {
+			t.Fatalf("NextPart for %s: %v", test.name, err)
+		}
+		expectEq(t, test.name, part.FormName(), "FormName")
+		expectEq(t, test.contentType, part.Header.Get("Content-Type"), "Content-Type")
+		data, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatalf("ReadAll for %s: %v", test.name, err)
+		}
+		expectEq(t, test.body, string(data), "body")
+	}
+	if _, err := r.NextPart(); err != io.EOF {
+		t.Errorf("expected io.EOF after last part, got %v", err)
+	}
+}
+
+func TestWriterLargeChunkedBody(t *testing.T) {
+	const chunkSize, chunks = 320, 10000
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	p, err := w.CreateOctetStream("audio")
+	if err != nil {
+		t.Fatalf("CreateOctetStream: %v", err)
+	}
+	chunk := make([]byte, chunkSize)
+	for i := 0; i < chunks; i++ {
+		for j := range chunk {
+			chunk[j] = byte(i + j)
+		}
+		if _, err := p.Write(chunk); err != nil {
+			t.Fatalf("Write chunk %d: %v", i, err)
+		}
+	}
+	w.Close()
+
+	r := NewReader(&buf, w.Boundary())
+	part, err := r.NextPart()
+	if err != nil {
+		t.Fatalf("NextPart: %v", err)
+	}
+	data, err := ioutil.ReadAll(part)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if len(data) != chunkSize*chunks {
+		t.Fatalf("got %d bytes; want %d", len(data), chunkSize*chunks)
+	}
+	for i := 0; i < chunks; i++ {
+		for j := 0; j < chunkSize; j++ {
+			if data[i*chunkSize+j] != byte(i+j) {
+				t.Fatalf("mismatch at chunk %d, byte %d", i, j)
+			}
+		}
+	}
+}
+
+func TestWriterFlushes(t *testing.T) {
+	fc := new(flushCounter)
+	w := NewWriter(fc)
+	p, err := w.CreateOctetStream("audio")
+	if err != nil {
+		t.Fatalf("CreateOctetStream: %v", err)
+	}
+	before := fc.flushes
+	n := fc.Len()
+	p.Write([]byte("chunk"))
+	if fc.flushes != before+1 {
+		t.Errorf("expected a flush after writing a chunk; got %d flushes", fc.flushes-before)
+	}
+	if fc.Len() != n+len("chunk") {
+		t.Errorf("expected chunk to be written through immediately")
+	}
+}
@@ -0,0 +1,263 @@
+package avs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// The directives of the Notifications interface.
+var (
+	TypeClearIndicator = MessageType{"Notifications", "ClearIndicator"}
+	TypeSetIndicator   = MessageType{"Notifications", "SetIndicator"}
+)
+
+// The context of the Notifications interface.
+var TypeIndicatorState = MessageType{"Notifications", "IndicatorState"}
+
+func init() {
+	registerDirective(TypeClearIndicator, ClearIndicator{})
+	registerDirective(TypeSetIndicator, SetIndicator{})
+	register(TypeIndicatorState, IndicatorState{})
+}
+
+// The ClearIndicator directive.
+type ClearIndicator struct {
+	*Message
+	Payload struct{} `json:"payload"`
+}
+
+// The SetIndicator directive.
+type SetIndicator struct {
+	*Message
+	Payload struct {
+		PersistVisualIndicator bool       `json:"persistVisualIndicator"`
+		PlayAudioIndicator     bool       `json:"playAudioIndicator"`
+		Asset                  AlertAsset `json:"asset"`
+	} `json:"payload"`
+}
+
+// The IndicatorState context.
+type IndicatorState struct {
+	*Message
+	Payload struct {
+		IsEnabled                  bool `json:"isEnabled"`
+		IsVisualIndicatorPersisted bool `json:"isVisualIndicatorPersisted"`
+	} `json:"payload"`
+}
+
+func NewIndicatorState(isEnabled, isVisualIndicatorPersisted bool, opts ...HeaderOption) *IndicatorState {
+	m := new(IndicatorState)
+	m.Message = newContext("Notifications", "IndicatorState", opts...)
+	m.Payload.IsEnabled = isEnabled
+	m.Payload.IsVisualIndicatorPersisted = isVisualIndicatorPersisted
+	return m
+}
+
+// The Store namespace and key of the state of the indicator.
+const (
+	notificationsStoreNamespace = "Notifications"
+	notificationsStoreKey       = "indicator"
+)
+
+// The state of the indicator, as persisted.
+type indicator struct {
+	Enabled   bool `json:"enabled"`
+	Persisted bool `json:"persisted"`
+}
+
+// NotificationsManager handles the directives of the Notifications interface
+// and provides its IndicatorState context.
+//
+// The audio indicator of a SetIndicator directive is played on the Alerts
+// channel once it's in the foreground, so an ongoing dialog isn't
+// interrupted, and stops if the channel is lost. Its asset is downloaded
+// through the AssetCache, which opens its DefaultTone if the download fails.
+// ClearIndicator stops it immediately.
+//
+// The state of the indicator is persisted in the Store, if set, so that the
+// visual indicator is restored after a restart.
+//
+// Register it for both directives:
+//
+//	d.Handle("Notifications.SetIndicator", notifications)
+//	d.Handle("Notifications.ClearIndicator", notifications)
+type NotificationsManager struct {
+	// OnIndicator, if set, is called whenever the indicator changes, e.g. to
+	// turn a light on or off. A visual indicator that isn't persisted should
+	// only be shown briefly.
+	OnIndicator func(enabled, persisted bool)
+
+	focus  *FocusManager
+	assets *AssetCache
+	sink   AudioSink
+	store  Store
+
+	mu        sync.Mutex
+	indicator indicator
+	chime     *chime
+}
+
+// An audio indicator being played.
+type chime struct {
+	cancel     context.CancelFunc
+	foreground chan struct{}
+	started    bool
+	done       chan struct{}
+}
+
+// NewNotificationsManager returns a NotificationsManager that plays the audio
+// indicators on the sink, with the assets of the cache, and persists the
+// state of the indicator in the store, which may be nil. Without a focus
+// manager, the audio indicators are played right away.
+func NewNotificationsManager(focus *FocusManager, assets *AssetCache, sink AudioSink, store Store) *NotificationsManager {
+	m := &NotificationsManager{focus: focus, assets: assets, sink: sink, store: store}
+	if store != nil {
+		if data, err := store.Get(notificationsStoreNamespace, notificationsStoreKey); err == nil {
+			json.Unmarshal(data, &m.indicator)
+		}
+	}
+	return m
+}
+
+// HandleDirective sets or clears the indicator. The audio indicator of
+// SetIndicator is played in the background; HandleDirective doesn't wait for
+// it.
+func (m *NotificationsManager) HandleDirective(ctx context.Context, directive TypedMessage) error {
+	switch d := directive.(type) {
+	case *SetIndicator:
+		m.stopChime()
+		if err := m.setIndicator(indicator{Enabled: true, Persisted: d.Payload.PersistVisualIndicator}); err != nil {
+			return err
+		}
+		if d.Payload.PlayAudioIndicator {
+			m.startChime(d.Payload.Asset)
+		}
+		return nil
+	case *ClearIndicator:
+		m.stopChime()
+		return m.setIndicator(indicator{})
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedDirective, directive.GetMessage())
+}
+
+// Context returns the IndicatorState context.
+func (m *NotificationsManager) Context() (TypedMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return NewIndicatorState(m.indicator.Enabled, m.indicator.Persisted), nil
+}
+
+// Enabled returns whether the indicator is on and whether its visual
+// indicator is persisted.
+func (m *NotificationsManager) Enabled() (enabled, persisted bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.indicator.Enabled, m.indicator.Persisted
+}
+
+// FocusChanged starts the audio indicator when the Alerts channel is in the
+// foreground, and stops it when the channel is lost or goes to the
+// background.
+func (m *NotificationsManager) FocusChanged(channel Channel, state FocusState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.chime
+	if c == nil {
+		return
+	}
+	switch {
+	case state == FocusStateForeground && !c.started:
+		c.started = true
+		close(c.foreground)
+	case state == FocusStateNone, state != FocusStateForeground && c.started:
+		c.cancel()
+	}
+}
+
+// Shutdown stops the audio indicator.
+func (m *NotificationsManager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	c := m.chime
+	m.mu.Unlock()
+	if c == nil {
+		return nil
+	}
+	c.cancel()
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Sets and persists the state of the indicator.
+func (m *NotificationsManager) setIndicator(ind indicator) error {
+	m.mu.Lock()
+	m.indicator = ind
+	m.mu.Unlock()
+	if m.OnIndicator != nil {
+		m.OnIndicator(ind.Enabled, ind.Persisted)
+	}
+	if m.store == nil {
+		return nil
+	}
+	data, err := json.Marshal(ind)
+	if err != nil {
+		return err
+	}
+	return m.store.Put(notificationsStoreNamespace, notificationsStoreKey, data)
+}
+
+func (m *NotificationsManager) startChime(asset AlertAsset) {
+	if m.assets == nil || m.sink == nil || asset.AssetId == "" {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &chime{cancel: cancel, foreground: make(chan struct{}), done: make(chan struct{})}
+	m.mu.Lock()
+	m.chime = c
+	m.mu.Unlock()
+	go m.play(ctx, c, asset)
+}
+
+// Stops the audio indicator being played, if any, and waits until it has.
+func (m *NotificationsManager) stopChime() {
+	m.Shutdown(context.Background())
+}
+
+func (m *NotificationsManager) play(ctx context.Context, c *chime, asset AlertAsset) {
+	defer func() {
+		c.cancel()
+		if m.focus != nil {
+			m.focus.ReleaseChannel(ChannelAlerts, m)
+		}
+		m.mu.Lock()
+		if m.chime == c {
+			m.chime = nil
+		}
+		m.mu.Unlock()
+		close(c.done)
+	}()
+	// A failed download is left to Open, which tries again or falls back
+	// to the default tone.
+	m.assets.Prefetch(ctx, asset)
+	if m.focus != nil {
+		if err := m.focus.AcquireChannel(ChannelAlerts, m); err != nil {
+			return
+		}
+		select {
+		case <-c.foreground:
+		case <-ctx.Done():
+			return
+		}
+	}
+	audio, err := m.assets.Open(asset.AssetId)
+	if err != nil {
+		return
+	}
+	defer audio.Close()
+	m.sink.PlayAudio(ctx, audio)
+}
@@ -0,0 +1,141 @@
+package avs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// An AudioSink that reports the audio it plays, then plays until canceled.
+type blockingSink struct {
+	played  chan string
+	stopped chan struct{}
+}
+
+func (s *blockingSink) PlayAudio(ctx context.Context, audio io.Reader) error {
+	data, _ := ioutil.ReadAll(audio)
+	s.played <- string(data)
+	<-ctx.Done()
+	close(s.stopped)
+	return ctx.Err()
+}
+
+// Returns a Notifications directive with an empty payload, parsed as if it
+// had been received.
+func newNotificationsDirective(t *testing.T, name, messageId string) TypedMessage {
+	var m Message
+	data := `{"header":{"namespace":"Notifications","name":"` + name + `","messageId":"` + messageId + `"},"payload":{}}`
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m.Typed()
+}
+
+func newSetIndicator(t *testing.T, persist, audio bool, url string) *SetIndicator {
+	d := newNotificationsDirective(t, "SetIndicator", "m1").(*SetIndicator)
+	d.Payload.PersistVisualIndicator = persist
+	d.Payload.PlayAudioIndicator = audio
+	d.Payload.Asset = AlertAsset{AssetId: "tone", URL: url}
+	return d
+}
+
+func TestNotificationsManager(t *testing.T) {
+	server, _ := newAssetServer(t)
+	defer server.Close()
+	store, _ := NewFileStore(t.TempDir(), nil)
+	focus := NewFocusManager()
+	var events []string
+	dialog := &recordingObserver{name: "dialog", events: &events}
+	focus.AcquireChannel(ChannelDialog, dialog)
+	sink := &blockingSink{played: make(chan string, 1), stopped: make(chan struct{})}
+	m := NewNotificationsManager(focus, NewAssetCache(store, 1<<20), sink, store)
+	var indicators []bool
+	m.OnIndicator = func(enabled, persisted bool) {
+		indicators = append(indicators, enabled)
+	}
+	ctx := context.Background()
+
+	// The chime waits for the dialog to finish.
+	if err := m.HandleDirective(ctx, newSetIndicator(t, true, true, server.URL+"/tone")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for focus.State(ChannelAlerts) != FocusStateBackground {
+		if time.Now().After(deadline) {
+			t.Fatal("the chime didn't acquire the Alerts channel")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-sink.played:
+		t.Fatal("the chime interrupted the dialog")
+	default:
+	}
+	focus.ReleaseChannel(ChannelDialog, dialog)
+	select {
+	case audio := <-sink.played:
+		if audio != "tonetonetonetonetonetonetonetonetonetone" {
+			t.Errorf("got audio %q", audio)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the chime wasn't played")
+	}
+	state, _ := m.Context()
+	if s := state.(*IndicatorState); !s.Payload.IsEnabled || !s.Payload.IsVisualIndicatorPersisted {
+		t.Errorf("got indicator state %+v", s.Payload)
+	}
+
+	// ClearIndicator stops the chime before returning.
+	if err := m.HandleDirective(ctx, newNotificationsDirective(t, "ClearIndicator", "m2")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sink.stopped:
+	default:
+		t.Error("the chime is still playing")
+	}
+	if s := focus.State(ChannelAlerts); s != FocusStateNone {
+		t.Errorf("got Alerts channel %s; want it released", s)
+	}
+	if enabled, _ := m.Enabled(); enabled || len(indicators) != 2 || indicators[1] {
+		t.Errorf("got indicator %t and changes %v; want it cleared", enabled, indicators)
+	}
+
+	// The indicator is restored after a restart.
+	if err := m.HandleDirective(ctx, newSetIndicator(t, true, false, "")); err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewNotificationsManager(focus, nil, nil, store)
+	if enabled, persisted := restarted.Enabled(); !enabled || !persisted {
+		t.Errorf("got %t, %t after a restart; want a persisted indicator", enabled, persisted)
+	}
+}
+
+// Without a focus manager, the chime is played right away.
+func TestNotificationsManagerWithoutFocus(t *testing.T) {
+	server, _ := newAssetServer(t)
+	defer server.Close()
+	store, _ := NewFileStore(t.TempDir(), nil)
+	sink := &blockingSink{played: make(chan string, 1), stopped: make(chan struct{})}
+	m := NewNotificationsManager(nil, NewAssetCache(store, 1<<20), sink, nil)
+	ctx := context.Background()
+	if err := m.HandleDirective(ctx, newSetIndicator(t, false, true, server.URL+"/tone")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sink.played:
+	case <-time.After(time.Second):
+		t.Fatal("the chime wasn't played")
+	}
+	if err := m.HandleDirective(ctx, newNotificationsDirective(t, "ClearIndicator", "m2")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sink.stopped:
+	default:
+		t.Error("the chime is still playing")
+	}
+}
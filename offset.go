@@ -0,0 +1,149 @@
+package avs
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// OffsetTracker keeps the offsets reported to AVS for an audio item
+// monotonic, as AVS's progress tracking misbehaves when an offset goes back
+// (e.g., a PlaybackStutterFinished offset before the PlaybackStutterStarted
+// one, because the player rewound to a buffer boundary).
+//
+// Offsets are tracked per token: the first offset of a new token is taken as
+// is. Intentional jumps back must be announced with Seek.
+//
+// Offsets are in content time: at a PlaybackRate of 1.5, an offset advances
+// by 15 seconds every 10 seconds. With a PlaybackRate, an offset further
+// ahead than the rate allows since the previous one (e.g., a player
+// reporting wall time at a rate below 1, or a jump forward that wasn't
+// announced with Seek) is reported as is, but flagged with OnImplausible.
+type OffsetTracker struct {
+	// Logger, if set, receives a warning for every offset that is clamped or
+	// implausible.
+	Logger *log.Logger
+	// Clock, if set, replaces the system clock. It must be set before the
+	// tracker is used.
+	Clock Clock
+	// PlaybackRate is the speed at which the audio plays (e.g., 1.25 for
+	// audiobooks played faster). Zero means 1, without checking that the
+	// offsets are plausible. It must be set before the tracker is used; use
+	// SetPlaybackRate to change it during playback.
+	PlaybackRate float64
+	// OnImplausible, if set, is called with every offset that is further
+	// ahead of the previous one than the playback rate allows, and with the
+	// furthest offset that was expected.
+	OnImplausible func(token string, offset, expected time.Duration)
+
+	mu     sync.Mutex
+	token  string
+	offset time.Duration
+	// The content offset and the time from which the next offset is
+	// expected to have advanced at the playback rate.
+	anchor   time.Duration
+	anchored time.Time
+}
+
+// How far ahead of the expected offset an offset may be before it's
+// implausible, at least, and as a fraction of the expected progress.
+const (
+	offsetSlack         = time.Second
+	offsetSlackFraction = 10
+)
+
+// Report returns the offset to report for the audio item with the token: the
+// offset truncated to the millisecond, or the last one reported if it's
+// earlier.
+func (t *OffsetTracker) Report(token string, offset time.Duration) time.Duration {
+	offset = offset.Truncate(time.Millisecond)
+	now := clockOrDefault(t.Clock).Now()
+	t.mu.Lock()
+	if token != t.token {
+		t.token, t.offset = token, offset
+		t.anchor, t.anchored = offset, now
+		t.mu.Unlock()
+		return offset
+	}
+	if offset < t.offset {
+		if t.Logger != nil {
+			t.Logger.Printf("avs: offset of %s went back from %s to %s; reporting %s", token, t.offset, offset, t.offset)
+		}
+		reported := t.offset
+		t.mu.Unlock()
+		return reported
+	}
+	checked := t.PlaybackRate > 0
+	progress := scaleDuration(now.Sub(t.anchored), t.rate())
+	expected := t.anchor + progress + offsetSlack + progress/offsetSlackFraction
+	t.offset = offset
+	t.anchor, t.anchored = offset, now
+	t.mu.Unlock()
+	if checked && offset > expected {
+		if t.Logger != nil {
+			t.Logger.Printf("avs: offset of %s jumped to %s; expected at most %s", token, offset, expected)
+		}
+		if t.OnImplausible != nil {
+			t.OnImplausible(token, offset, expected)
+		}
+	}
+	return offset
+}
+
+// SetPlaybackRate changes the playback rate from now on.
+func (t *OffsetTracker) SetPlaybackRate(rate float64) {
+	now := clockOrDefault(t.Clock).Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" {
+		// The progress so far was made at the previous rate.
+		t.anchor += scaleDuration(now.Sub(t.anchored), t.rate())
+		t.anchored = now
+	}
+	t.PlaybackRate = rate
+}
+
+// Rate returns the playback rate.
+func (t *OffsetTracker) Rate() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rate()
+}
+
+// Returns the playback rate. t.mu must be held.
+func (t *OffsetTracker) rate() float64 {
+	if t.PlaybackRate <= 0 {
+		return 1
+	}
+	return t.PlaybackRate
+}
+
+// Seek records that the player jumped to the offset in the current audio
+// item (e.g., when the user seeks back), so that the next offsets are
+// compared to it.
+func (t *OffsetTracker) Seek(offset time.Duration) {
+	now := clockOrDefault(t.Clock).Now()
+	t.mu.Lock()
+	t.offset = offset.Truncate(time.Millisecond)
+	t.anchor, t.anchored = t.offset, now
+	t.mu.Unlock()
+}
+
+// Last returns the last offset reported for the token, if it's the current
+// one.
+func (t *OffsetTracker) Last(token string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if token == "" || token != t.token {
+		return 0, false
+	}
+	return t.offset, true
+}
+
+// Returns the duration d of wall time in content time at the rate.
+func scaleDuration(d time.Duration, rate float64) time.Duration {
+	if rate == 1 {
+		return d
+	}
+	return time.Duration(float64(d) * rate)
+}
@@ -0,0 +1,118 @@
+package avs
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOffsetTrackerSeek(t *testing.T) {
+	var logs bytes.Buffer
+	tracker := &OffsetTracker{Logger: log.New(&logs, "", 0)}
+	// Pause at 30s, seek back to 10s and resume.
+	steps := []struct {
+		seek   bool
+		offset time.Duration
+		want   time.Duration
+	}{
+		{false, 30*time.Second + 500*time.Microsecond, 30 * time.Second},
+		{true, 10 * time.Second, 10 * time.Second},
+		{false, 10 * time.Second, 10 * time.Second},
+		{false, 12 * time.Second, 12 * time.Second},
+	}
+	for i, step := range steps {
+		if step.seek {
+			tracker.Seek(step.offset)
+			if got, _ := tracker.Last("song"); got != step.want {
+				t.Errorf("step %d: got last offset %s after seeking; want %s", i, got, step.want)
+			}
+			continue
+		}
+		if got := tracker.Report("song", step.offset); got != step.want {
+			t.Errorf("step %d: got %s; want %s", i, got, step.want)
+		}
+	}
+	if logs.Len() != 0 {
+		t.Errorf("logged %q for a seek", logs.String())
+	}
+}
+
+func TestOffsetTrackerUnderrun(t *testing.T) {
+	var logs bytes.Buffer
+	tracker := &OffsetTracker{Logger: log.New(&logs, "", 0)}
+	// The stutter starts at 10s, and the player resumes from an earlier
+	// buffer boundary.
+	if got := tracker.Report("song", 10*time.Second); got != 10*time.Second {
+		t.Fatalf("got %s", got)
+	}
+	if got := tracker.Report("song", 9800*time.Millisecond); got != 10*time.Second {
+		t.Errorf("got %s after the underrun; want 10s", got)
+	}
+	if !strings.Contains(logs.String(), "went back") {
+		t.Errorf("got log %q; want a warning", logs.String())
+	}
+	if got := tracker.Report("song", 11*time.Second); got != 11*time.Second {
+		t.Errorf("got %s after recovering; want 11s", got)
+	}
+	// A new item starts over.
+	if got := tracker.Report("next", time.Second); got != time.Second {
+		t.Errorf("got %s for a new token; want 1s", got)
+	}
+	if last, ok := tracker.Last("song"); ok {
+		t.Errorf("got last offset %s for the previous token", last)
+	}
+}
+
+func TestOffsetTrackerPlaybackRate(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2017, 5, 16, 12, 0, 0, 0, time.UTC)}
+	var implausible []time.Duration
+	tracker := &OffsetTracker{Clock: clock, PlaybackRate: 1.5, OnImplausible: func(token string, offset, expected time.Duration) {
+		implausible = append(implausible, offset)
+	}}
+	tracker.Report("book", 0)
+	// At 1.5x, 10 seconds of playback are 15 seconds of content.
+	clock.now = clock.now.Add(10 * time.Second)
+	tracker.Report("book", 15*time.Second)
+	if len(implausible) != 0 {
+		t.Errorf("flagged %v at the playback rate", implausible)
+	}
+
+	// Offsets in wall time run ahead at 0.75x.
+	tracker.SetPlaybackRate(0.75)
+	clock.now = clock.now.Add(20 * time.Second)
+	tracker.Report("book", 35*time.Second)
+	if len(implausible) != 1 || implausible[0] != 35*time.Second {
+		t.Errorf("got implausible offsets %v; want 35s", implausible)
+	}
+
+	// The rate applies from the time it changed.
+	clock.now = clock.now.Add(10 * time.Second)
+	tracker.SetPlaybackRate(2)
+	clock.now = clock.now.Add(10 * time.Second)
+	tracker.Report("book", 62*time.Second)
+	if len(implausible) != 1 {
+		t.Errorf("got implausible offsets %v after a rate change", implausible)
+	}
+
+	// So does a seek.
+	tracker.Seek(5 * time.Minute)
+	clock.now = clock.now.Add(time.Second)
+	tracker.Report("book", 5*time.Minute+2*time.Second)
+	if len(implausible) != 1 {
+		t.Errorf("got implausible offsets %v after a seek", implausible)
+	}
+}
+
+func TestPlaybackStateProviderPlaybackRate(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2017, 5, 16, 12, 0, 0, 0, time.UTC)}
+	p := NewPlaybackStateProvider(nil, 0)
+	p.Clock = clock
+	p.Offsets = &OffsetTracker{Clock: clock, PlaybackRate: 1.25}
+	p.SetState("book", 10*time.Second, PlayerActivityPlaying)
+	clock.now = clock.now.Add(8 * time.Second)
+	if _, offset, _ := p.State(); offset != 20*time.Second {
+		t.Errorf("got offset %s after 8s at 1.25x; want 20s", offset)
+	}
+}
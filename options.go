@@ -0,0 +1,406 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// An Option configures a Client created with NewClient.
+type Option func(c *Client) error
+
+// Config is a snapshot of the settings of a Client, as returned by
+// Client.Config. The fields are documented on Client.
+type Config struct {
+	EndpointURL           string
+	RateLimiter           *RateLimiter
+	RetryPolicy           *RetryPolicy
+	Backoff               BackoffPolicies
+	Clock                 Clock
+	APIProfile            APIProfile
+	EchoSpatialPerception func() (voiceEnergy, ambientEnergy float64, ok bool)
+	UserAgent             string
+	StreamingThreshold    int
+	DirectiveBufferSize   int
+	Backpressure          BackpressurePolicy
+	Metrics               *Metrics
+	CapabilitiesURL       string
+	BeforeSend            []BeforeSendHook
+	Transport             http.RoundTripper
+	Sequencer             *Sequencer
+	DialContext           func(ctx context.Context, network, addr string) (net.Conn, error)
+	IsolatedTransport     bool
+	Limits                Limits
+	FailOnException       bool
+	IdempotentNamespaces  map[string]bool
+	CompressMetadata      bool
+	OnDeauthorized        func(err error)
+	RawPartHandler        RawPartHandler
+	Logger                *log.Logger
+	// The extra headers set with SetHeader or WithHeader, which are only
+	// sent to AVS.
+	Header http.Header
+}
+
+// String returns a summary of the settings suitable for logs.
+func (c Config) String() string {
+	return fmt.Sprintf("endpoint %s, user agent %q, API profile %q, rate limited %t, retried %t, %d directive(s) buffered, %d hook(s), %d extra header(s)",
+		c.EndpointURL, c.UserAgent, c.APIProfile, c.RateLimiter != nil, c.RetryPolicy != nil,
+		c.DirectiveBufferSize, len(c.BeforeSend), len(c.Header))
+}
+
+// NewClient returns a new Client for DefaultEndpointURL configured with the
+// options. It returns an error if an option fails or if the resulting
+// settings are contradictory (see Client.Validate), instead of failing on
+// first use.
+func NewClient(opts ...Option) (*Client, error) {
+	c := &Client{EndpointURL: DefaultEndpointURL}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Config returns a snapshot of the settings of the client, for debugging.
+// Changing it doesn't affect the client. It holds none of the state of the
+// client, unlike a copy of the Client, which must not be made.
+func (c *Client) Config() Config {
+	return Config{
+		EndpointURL:           c.endpoint(),
+		RateLimiter:           c.RateLimiter,
+		RetryPolicy:           c.RetryPolicy,
+		Backoff:               c.Backoff,
+		Clock:                 c.Clock,
+		APIProfile:            c.APIProfile,
+		EchoSpatialPerception: c.EchoSpatialPerception,
+		UserAgent:             c.UserAgent,
+		StreamingThreshold:    c.StreamingThreshold,
+		DirectiveBufferSize:   c.DirectiveBufferSize,
+		Backpressure:          c.Backpressure,
+		Metrics:               c.Metrics,
+		CapabilitiesURL:       c.CapabilitiesURL,
+		BeforeSend:            append([]BeforeSendHook(nil), c.BeforeSend...),
+		Transport:             c.Transport,
+		Sequencer:             c.Sequencer,
+		DialContext:           c.DialContext,
+		IsolatedTransport:     c.IsolatedTransport,
+		Limits:                c.Limits,
+		FailOnException:       c.FailOnException,
+		IdempotentNamespaces:  copyBoolMap(c.IdempotentNamespaces),
+		CompressMetadata:      c.CompressMetadata,
+		OnDeauthorized:        c.OnDeauthorized,
+		RawPartHandler:        c.RawPartHandler,
+		Logger:                c.Logger,
+		Header:                c.header.Clone(),
+	}
+}
+
+// Validate checks that the settings of the client are usable and don't
+// contradict each other. NewClient calls it; clients that are set up field by
+// field may call it before use.
+func (c *Client) Validate() error {
+	if err := validateURL("endpoint", c.endpoint()); err != nil {
+		return err
+	}
+	if c.CapabilitiesURL != "" {
+		if err := validateURL("capabilities", c.CapabilitiesURL); err != nil {
+			return err
+		}
+	}
+	switch c.APIProfile {
+	case "", APIProfileV1, APIProfileV2:
+	default:
+		return fmt.Errorf("avs: unknown API profile %q", c.APIProfile)
+	}
+	if c.DirectiveBufferSize < 0 {
+		return fmt.Errorf("avs: negative directive buffer size %d", c.DirectiveBufferSize)
+	}
+	switch c.Backpressure {
+	case BackpressureBlock:
+	case BackpressureDropOldestNonDialog, BackpressureFail:
+		if c.DirectiveBufferSize == 0 {
+			return errors.New("avs: backpressure policy set without a directive buffer")
+		}
+	default:
+		return fmt.Errorf("avs: unknown backpressure policy %d", c.Backpressure)
+	}
+	if p := c.RetryPolicy; p != nil && p.RefreshToken == nil {
+		for code, action := range p.Actions {
+			if action.RefreshToken && action.Retries > 0 {
+				return fmt.Errorf("avs: retry policy refreshes the token on %s but has no RefreshToken function", code)
+			}
+		}
+	}
+	if c.Transport != nil && c.DialContext != nil {
+		return errors.New("avs: both a transport and a dialer are set")
+	}
+	if c.Transport != nil && c.IsolatedTransport {
+		return errors.New("avs: both a transport and an isolated transport are set")
+	}
+	for i, hook := range c.BeforeSend {
+		if hook == nil {
+			return fmt.Errorf("avs: before send hook %d is nil", i)
+		}
+	}
+	return nil
+}
+
+func copyBoolMap(m map[string]bool) map[string]bool {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]bool, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func validateURL(name, rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return fmt.Errorf("avs: invalid %s URL: %v", name, err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("avs: %s URL %q isn't an absolute HTTP URL", name, rawurl)
+	}
+	return nil
+}
+
+// WithEndpointURL sets the base endpoint URL (e.g., for another region or a
+// mock server).
+func WithEndpointURL(url string) Option {
+	return func(c *Client) error {
+		c.EndpointURL = url
+		return nil
+	}
+}
+
+// WithRateLimiter paces the events sent by the client. See Client.RateLimiter.
+func WithRateLimiter(l *RateLimiter) Option {
+	return func(c *Client) error {
+		c.RateLimiter = l
+		return nil
+	}
+}
+
+// WithRetryPolicy sets the retry policy. See Client.RetryPolicy.
+func WithRetryPolicy(p *RetryPolicy) Option {
+	return func(c *Client) error {
+		c.RetryPolicy = p
+		return nil
+	}
+}
+
+// WithBackoff sets the non-nil policies of p, keeping the others. See
+// Client.Backoff.
+func WithBackoff(p BackoffPolicies) Option {
+	return func(c *Client) error {
+		for _, policy := range []struct{ to, from **BackoffPolicy }{
+			{&c.Backoff.Events, &p.Events},
+			{&c.Backoff.Downchannel, &p.Downchannel},
+			{&c.Backoff.Capabilities, &p.Capabilities},
+			{&c.Backoff.Reconnect, &p.Reconnect},
+			{&c.Backoff.TokenRefresh, &p.TokenRefresh},
+		} {
+			if *policy.from != nil {
+				*policy.to = *policy.from
+			}
+		}
+		return nil
+	}
+}
+
+// WithClock replaces the system clock.
+func WithClock(clock Clock) Option {
+	return func(c *Client) error {
+		c.Clock = clock
+		return nil
+	}
+}
+
+// WithAPIProfile sets the version of the events sent. See Client.APIProfile.
+func WithAPIProfile(p APIProfile) Option {
+	return func(c *Client) error {
+		c.APIProfile = p
+		return nil
+	}
+}
+
+// WithEchoSpatialPerception sets the function that measures the energies
+// reported before Recognize events. See Client.EchoSpatialPerception.
+func WithEchoSpatialPerception(measure func() (voiceEnergy, ambientEnergy float64, ok bool)) Option {
+	return func(c *Client) error {
+		c.EchoSpatialPerception = measure
+		return nil
+	}
+}
+
+// WithUserAgent sets the User-Agent sent to AVS.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) error {
+		c.UserAgent = userAgent
+		return nil
+	}
+}
+
+// WithStreamingThreshold sets the size above which directives are decoded
+// while being read. See Client.StreamingThreshold.
+func WithStreamingThreshold(n int) Option {
+	return func(c *Client) error {
+		c.StreamingThreshold = n
+		return nil
+	}
+}
+
+// WithDirectiveBuffer sets the number of directives that downchannels buffer
+// and what they do when the buffer is full.
+func WithDirectiveBuffer(size int, policy BackpressurePolicy) Option {
+	return func(c *Client) error {
+		c.DirectiveBufferSize = size
+		c.Backpressure = policy
+		return nil
+	}
+}
+
+// WithMetrics sets the hooks that receive measurements from the client.
+func WithMetrics(m *Metrics) Option {
+	return func(c *Client) error {
+		c.Metrics = m
+		return nil
+	}
+}
+
+// WithCapabilitiesURL sets the endpoint used by PublishCapabilities.
+func WithCapabilitiesURL(url string) Option {
+	return func(c *Client) error {
+		c.CapabilitiesURL = url
+		return nil
+	}
+}
+
+// WithBeforeSend adds hooks that are called with every event sent. See
+// Client.BeforeSend.
+func WithBeforeSend(hooks ...BeforeSendHook) Option {
+	return func(c *Client) error {
+		c.BeforeSend = append(c.BeforeSend, hooks...)
+		return nil
+	}
+}
+
+// WithTransport replaces the shared HTTP/2 transport. Clients given the same
+// transport (e.g., from NewTransport) share their connections.
+func WithTransport(t http.RoundTripper) Option {
+	return func(c *Client) error {
+		c.Transport = t
+		return nil
+	}
+}
+
+// WithIsolatedTransport gives the client its own HTTP/2 transport. See
+// Client.IsolatedTransport.
+func WithIsolatedTransport() Option {
+	return func(c *Client) error {
+		c.IsolatedTransport = true
+		return nil
+	}
+}
+
+// WithSequencer numbers the events sent by the client. See Client.Sequencer.
+func WithSequencer(s *Sequencer) Option {
+	return func(c *Client) error {
+		c.Sequencer = s
+		return nil
+	}
+}
+
+// WithDialContext sets the function that opens the connections of the
+// client. See Client.DialContext.
+func WithDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(c *Client) error {
+		c.DialContext = dial
+		return nil
+	}
+}
+
+// WithLimits sets the limits on the size of the parts of responses and
+// downchannels.
+func WithLimits(l Limits) Option {
+	return func(c *Client) error {
+		c.Limits = l
+		return nil
+	}
+}
+
+// WithCompressedMetadata sends the metadata of the events gzip-compressed.
+// See Client.CompressMetadata.
+func WithCompressedMetadata() Option {
+	return func(c *Client) error {
+		c.CompressMetadata = true
+		return nil
+	}
+}
+
+// WithTokenSource installs the source of the access tokens of the client.
+// See Client.SetTokenSource.
+func WithTokenSource(source TokenSource) Option {
+	return func(c *Client) error {
+		c.SetTokenSource(source)
+		return nil
+	}
+}
+
+// WithRawPartHandler sets the handler of the raw parts of the downchannels.
+// See Client.RawPartHandler.
+func WithRawPartHandler(h RawPartHandler) Option {
+	return func(c *Client) error {
+		c.RawPartHandler = h
+		return nil
+	}
+}
+
+// WithLogger sets the logger of the debug messages of the client.
+func WithLogger(logger *log.Logger) Option {
+	return func(c *Client) error {
+		c.Logger = logger
+		return nil
+	}
+}
+
+// WithFailOnException makes Do and DoContext fail with the System.Exception
+// directives of successful responses. See Client.FailOnException.
+func WithFailOnException() Option {
+	return func(c *Client) error {
+		c.FailOnException = true
+		return nil
+	}
+}
+
+// WithIdempotentNamespace sets whether the events of the namespace may be sent
+// again after an ErrIndeterminate error. See Client.IsIdempotent.
+func WithIdempotentNamespace(namespace string, idempotent bool) Option {
+	return func(c *Client) error {
+		if c.IdempotentNamespaces == nil {
+			c.IdempotentNamespaces = make(map[string]bool)
+		}
+		c.IdempotentNamespaces[namespace] = idempotent
+		return nil
+	}
+}
+
+// WithHeader sets a header sent with every request to AVS. See
+// Client.SetHeader.
+func WithHeader(key, value string) Option {
+	return func(c *Client) error {
+		return c.SetHeader(key, value)
+	}
+}
@@ -0,0 +1,60 @@
+package avs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewClient(t *testing.T) {
+	limiter := NewRateLimiter(1, 1)
+	c, err := NewClient(
+		WithRateLimiter(limiter),
+		WithUserAgent("Test/1.0"),
+		WithDirectiveBuffer(8, BackpressureFail),
+		WithHeader("X-Test", "yes"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := c.Config()
+	if config.EndpointURL != DefaultEndpointURL {
+		t.Errorf("got endpoint %q; want %q", config.EndpointURL, DefaultEndpointURL)
+	}
+	if config.RateLimiter != limiter || config.UserAgent != "Test/1.0" {
+		t.Errorf("options weren't applied: %+v", config)
+	}
+	if config.DirectiveBufferSize != 8 || config.Backpressure != BackpressureFail {
+		t.Errorf("got buffer %d with policy %d; want 8 with %d", config.DirectiveBufferSize, config.Backpressure, BackpressureFail)
+	}
+	// The snapshot doesn't share the client's headers.
+	config.Header.Set("X-Test", "no")
+	if v := c.header.Get("X-Test"); v != "yes" {
+		t.Errorf("client header changed to %q through the snapshot", v)
+	}
+}
+
+func TestNewClientInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"relative endpoint", []Option{WithEndpointURL("/events")}, "endpoint URL"},
+		{"unknown profile", []Option{WithAPIProfile("v3")}, "API profile"},
+		{"negative buffer", []Option{WithDirectiveBuffer(-1, BackpressureBlock)}, "negative"},
+		{"policy without buffer", []Option{WithDirectiveBuffer(0, BackpressureDropOldestNonDialog)}, "without a directive buffer"},
+		{"refresh without function", []Option{WithRetryPolicy(DefaultRetryPolicy())}, "RefreshToken"},
+		{"nil hook", []Option{WithBeforeSend(nil)}, "nil"},
+		{"reserved header", []Option{WithHeader("Authorization", "x")}, "can't be overridden"},
+	}
+	for _, test := range tests {
+		c, err := NewClient(test.opts...)
+		if err == nil {
+			t.Errorf("%s: got client %v; want error", test.name, c.Config())
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %q; want it to mention %q", test.name, err, test.want)
+		}
+	}
+}
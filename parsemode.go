@@ -0,0 +1,82 @@
+package avs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// ParseMode specifies how payloads with fields that their Go type doesn't
+// have are decoded.
+type ParseMode int32
+
+// Possible values for ParseMode.
+const (
+	// Unknown fields are ignored, so that the messages still decode when AVS
+	// adds to them. It's the default.
+	ParseLenient ParseMode = iota
+	// Unknown fields, including those of nested objects (e.g., the AudioItem
+	// of a Play directive), are ErrInvalidMessage errors, and so are
+	// duplicate keys in directives and their headers. It's meant for tests
+	// against recorded traffic, to notice when AVS adds to a message.
+	ParseStrict
+)
+
+// String returns the name of the mode.
+func (m ParseMode) String() string {
+	if m == ParseStrict {
+		return "Strict"
+	}
+	return "Lenient"
+}
+
+var currentParseMode int32
+
+// SetParseMode sets how the messages read from AVS and parsed with
+// ParseEnvelope or TypedFromReader are decoded. Like SetJSONCodec, it should
+// be called before the package is used, and it's shared by all the clients
+// of the process.
+//
+// Message.Typed always decodes leniently; use CheckPayload to check a
+// message.
+func SetParseMode(mode ParseMode) {
+	atomic.StoreInt32(&currentParseMode, int32(mode))
+}
+
+// Returns the mode set with SetParseMode.
+func parseMode() ParseMode {
+	return ParseMode(atomic.LoadInt32(&currentParseMode))
+}
+
+// CheckPayload returns an ErrInvalidMessage error if the payload of the
+// message has fields that its Go type doesn't have, or values of the wrong
+// type. Messages without a Go type, and typed messages whose payload was
+// decoded directly (see TypedFromReader), always pass.
+func CheckPayload(m *Message) error {
+	if m == nil || len(m.Payload) == 0 {
+		return nil
+	}
+	typed := newRegistered(m.Type())
+	if typed == nil {
+		return nil
+	}
+	payload := payloadOf(typed)
+	if payload == nil {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(m.Payload))
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(payload); err != nil {
+		return withKind(ErrInvalidMessage, fmt.Errorf("avs: %s payload: %v", m.Type(), err))
+	}
+	return nil
+}
+
+// Returns whether the error is that of a decoder set to
+// DisallowUnknownFields, which encoding/json doesn't give a type.
+func isUnknownField(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "json: unknown field ")
+}
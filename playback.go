@@ -0,0 +1,198 @@
+package avs
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// The Store namespace and key used for the playback progress.
+const (
+	playbackStoreNamespace = "AudioPlayer"
+	playbackStoreKey       = "progress"
+)
+
+// The persisted playback progress.
+type playbackProgress struct {
+	Token                string `json:"token"`
+	OffsetInMilliseconds int64  `json:"offsetInMilliseconds"`
+}
+
+// PositionReporter is implemented by audio players that can tell where they
+// are in the audio item being played.
+type PositionReporter interface {
+	// Position returns the token of the current audio item, the position of
+	// the player in it and its activity. The position is in content time,
+	// which is what AVS expects and resumes from: a player at 1.5x is 15
+	// seconds into the item after 10 seconds of playback, not 10.
+	Position() (token string, offset time.Duration, activity PlayerActivity, err error)
+}
+
+// PlaybackStateProvider keeps track of the state of the audio player and
+// provides the PlaybackState context.
+//
+// If it has a Store, the progress is saved on every PAUSED or STOPPED
+// transition and periodically while playing. On creation, any saved progress
+// is restored as a STOPPED state at the saved offset.
+type PlaybackStateProvider struct {
+	// Clock, if set, replaces the system clock. It must be set before the
+	// first call to SetState.
+	Clock Clock
+	// Reporter, if set, is asked for the position of the player whenever the
+	// state is requested, instead of extrapolating it from the last update.
+	// If it fails, the last known state is used. It must be set before the
+	// provider is used.
+	Reporter PositionReporter
+	// Offsets, if set, keeps the offsets of an audio item from going back,
+	// except after Seek. Its playback rate is used to extrapolate the offset
+	// while playing. It must be set before the provider is used.
+	Offsets *OffsetTracker
+
+	store      Store
+	checkpoint time.Duration
+	start      sync.Once
+	done       chan struct{}
+	once       sync.Once
+
+	mu       sync.Mutex
+	token    string
+	offset   time.Duration
+	activity PlayerActivity
+	updated  time.Time
+}
+
+// NewPlaybackStateProvider returns a new PlaybackStateProvider. The store may
+// be nil. If checkpoint is positive, the progress is also saved at that
+// interval while playing.
+func NewPlaybackStateProvider(store Store, checkpoint time.Duration) *PlaybackStateProvider {
+	p := &PlaybackStateProvider{
+		store:      store,
+		checkpoint: checkpoint,
+		done:       make(chan struct{}),
+		activity:   PlayerActivityIdle,
+	}
+	if store == nil {
+		return p
+	}
+	var progress playbackProgress
+	data, err := store.Get(playbackStoreNamespace, playbackStoreKey)
+	if err == nil && json.Unmarshal(data, &progress) == nil && progress.Token != "" {
+		p.token = progress.Token
+		p.offset = time.Duration(progress.OffsetInMilliseconds) * time.Millisecond
+		p.activity = PlayerActivityStopped
+	}
+	return p
+}
+
+// SetState updates the state of the audio player. It should be called on
+// every transition (e.g., whenever a Playback* event is sent).
+func (p *PlaybackStateProvider) SetState(token string, offset time.Duration, activity PlayerActivity) {
+	if p.store != nil && p.checkpoint > 0 {
+		p.start.Do(func() { go p.saveEvery(p.checkpoint) })
+	}
+	if p.Offsets != nil {
+		offset = p.Offsets.Report(token, offset)
+	}
+	p.mu.Lock()
+	p.token = token
+	p.offset = offset
+	p.activity = activity
+	p.updated = clockOrDefault(p.Clock).Now()
+	p.mu.Unlock()
+	if activity == PlayerActivityPaused || activity == PlayerActivityStopped {
+		p.save()
+	}
+}
+
+// Seek records that the player jumped to the offset in the current audio
+// item, which may be before the last offset.
+func (p *PlaybackStateProvider) Seek(offset time.Duration) {
+	if p.Offsets != nil {
+		p.Offsets.Seek(offset)
+	}
+	p.mu.Lock()
+	p.offset = offset
+	p.updated = clockOrDefault(p.Clock).Now()
+	p.mu.Unlock()
+}
+
+// State returns the current token, offset and activity of the audio player,
+// as reported by the Reporter. Without a Reporter, or if it fails, it returns
+// the last known state, extrapolating the offset from the last update while
+// playing at the playback rate of the Offsets.
+func (p *PlaybackStateProvider) State() (token string, offset time.Duration, activity PlayerActivity) {
+	if p.Reporter != nil {
+		token, offset, activity, err := p.Reporter.Position()
+		if err == nil {
+			if p.Offsets != nil {
+				offset = p.Offsets.Report(token, offset)
+			}
+			p.mu.Lock()
+			p.token, p.offset, p.activity = token, offset, activity
+			p.updated = clockOrDefault(p.Clock).Now()
+			p.mu.Unlock()
+			return token, offset, activity
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	offset = p.offset
+	if p.activity == PlayerActivityPlaying {
+		elapsed := clockOrDefault(p.Clock).Now().Sub(p.updated)
+		if p.Offsets != nil {
+			elapsed = scaleDuration(elapsed, p.Offsets.Rate())
+		}
+		offset += elapsed
+	}
+	return p.token, offset, p.activity
+}
+
+// PlaybackState returns the current PlaybackState context.
+func (p *PlaybackStateProvider) PlaybackState() *PlaybackState {
+	return NewPlaybackState(p.State())
+}
+
+// Context returns the current PlaybackState context.
+func (p *PlaybackStateProvider) Context() (TypedMessage, error) {
+	return p.PlaybackState(), nil
+}
+
+// Close stops saving the progress periodically.
+func (p *PlaybackStateProvider) Close() error {
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
+
+// Shutdown saves the progress and stops saving it periodically.
+func (p *PlaybackStateProvider) Shutdown(ctx context.Context) error {
+	p.save()
+	return p.Close()
+}
+
+func (p *PlaybackStateProvider) saveEvery(interval time.Duration) {
+	ticker := clockOrDefault(p.Clock).NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if _, _, activity := p.State(); activity == PlayerActivityPlaying {
+				p.save()
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *PlaybackStateProvider) save() {
+	if p.store == nil {
+		return
+	}
+	token, offset, _ := p.State()
+	if token == "" {
+		return
+	}
+	data, _ := json.Marshal(playbackProgress{token, int64(offset / time.Millisecond)})
+	p.store.Put(playbackStoreNamespace, playbackStoreKey, data)
+}
@@ -0,0 +1,95 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeReporter struct {
+	token    string
+	offset   time.Duration
+	activity PlayerActivity
+	err      error
+}
+
+func (r *fakeReporter) Position() (string, time.Duration, PlayerActivity, error) {
+	return r.token, r.offset, r.activity, r.err
+}
+
+func TestPlaybackStateProviderReporter(t *testing.T) {
+	reporter := &fakeReporter{token: "song", offset: 42 * time.Second, activity: PlayerActivityPlaying}
+	p := NewPlaybackStateProvider(nil, 0)
+	p.Reporter = reporter
+	p.SetState("song", 0, PlayerActivityPlaying)
+
+	context, err := p.Context()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := context.(*PlaybackState)
+	if state.Payload.Token != "song" || state.Payload.OffsetInMilliseconds != 42000 || state.Payload.PlayerActivity != PlayerActivityPlaying {
+		t.Errorf("got %+v; want the reported position", state.Payload)
+	}
+
+	// The last known state is used while the player can't report.
+	reporter.activity = PlayerActivityPaused
+	p.SetState("song", 50*time.Second, PlayerActivityPaused)
+	reporter.err = errors.New("player is busy")
+	reporter.offset = time.Hour
+	if token, offset, activity := p.State(); token != "song" || offset != 50*time.Second || activity != PlayerActivityPaused {
+		t.Errorf("got %s, %s, %s; want song, 50s, PAUSED", token, offset, activity)
+	}
+}
+
+func TestPlaybackStateProviderProgress(t *testing.T) {
+	store, err := NewFileStore(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fixedClock{now: time.Date(2017, 5, 16, 12, 0, 0, 0, time.UTC)}
+	p := NewPlaybackStateProvider(store, 0)
+	p.Clock = clock
+	p.SetState("song", 10*time.Second, PlayerActivityPlaying)
+	if _, err := store.Get(playbackStoreNamespace, playbackStoreKey); err == nil {
+		t.Error("saved the progress while playing")
+	}
+	// The offset is extrapolated while playing.
+	clock.now = clock.now.Add(5 * time.Second)
+	if _, offset, _ := p.State(); offset != 15*time.Second {
+		t.Errorf("got offset %s after 5s of playback; want 15s", offset)
+	}
+	p.SetState("song", 30*time.Second, PlayerActivityPaused)
+
+	// After a restart, the progress is restored as stopped.
+	restarted := NewPlaybackStateProvider(store, 0)
+	if token, offset, activity := restarted.State(); token != "song" || offset != 30*time.Second || activity != PlayerActivityStopped {
+		t.Errorf("got %s, %s, %s; want song, 30s, STOPPED", token, offset, activity)
+	}
+
+	// Shutdown saves the progress while playing.
+	restarted.Clock = clock
+	restarted.SetState("next", time.Minute, PlayerActivityPlaying)
+	if err := restarted.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if token, offset, _ := NewPlaybackStateProvider(store, 0).State(); token != "next" || offset != time.Minute {
+		t.Errorf("got %s, %s after shutdown; want next, 1m0s", token, offset)
+	}
+}
+
+func TestPlaybackStateProviderOffsets(t *testing.T) {
+	p := NewPlaybackStateProvider(nil, 0)
+	p.Offsets = new(OffsetTracker)
+	p.SetState("song", 20*time.Second, PlayerActivityPaused)
+	p.SetState("song", 19*time.Second, PlayerActivityPaused)
+	if _, offset, _ := p.State(); offset != 20*time.Second {
+		t.Errorf("got %s; want the offset clamped to 20s", offset)
+	}
+	p.Seek(5 * time.Second)
+	p.SetState("song", 5*time.Second, PlayerActivityPaused)
+	if _, offset, _ := p.State(); offset != 5*time.Second {
+		t.Errorf("got %s after seeking; want 5s", offset)
+	}
+}
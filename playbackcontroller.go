@@ -0,0 +1,64 @@
+package avs
+
+// The events of the PlaybackController interface.
+var (
+	TypeNextCommandIssued     = MessageType{"PlaybackController", "NextCommandIssued"}
+	TypePauseCommandIssued    = MessageType{"PlaybackController", "PauseCommandIssued"}
+	TypePlayCommandIssued     = MessageType{"PlaybackController", "PlayCommandIssued"}
+	TypePreviousCommandIssued = MessageType{"PlaybackController", "PreviousCommandIssued"}
+)
+
+func init() {
+	register(TypeNextCommandIssued, NextCommandIssued{})
+	register(TypePauseCommandIssued, PauseCommandIssued{})
+	register(TypePlayCommandIssued, PlayCommandIssued{})
+	register(TypePreviousCommandIssued, PreviousCommandIssued{})
+}
+
+// The NextCommandIssued event.
+type NextCommandIssued struct {
+	*Message
+	Payload struct{} `json:"payload"`
+}
+
+func NewNextCommandIssued(messageId string, opts ...HeaderOption) *NextCommandIssued {
+	m := new(NextCommandIssued)
+	m.Message = newEvent("PlaybackController", "NextCommandIssued", messageId, "", opts...)
+	return m
+}
+
+// The PauseCommandIssued event.
+type PauseCommandIssued struct {
+	*Message
+	Payload struct{} `json:"payload"`
+}
+
+func NewPauseCommandIssued(messageId string, opts ...HeaderOption) *PauseCommandIssued {
+	m := new(PauseCommandIssued)
+	m.Message = newEvent("PlaybackController", "PauseCommandIssued", messageId, "", opts...)
+	return m
+}
+
+// The PlayCommandIssued event.
+type PlayCommandIssued struct {
+	*Message
+	Payload struct{} `json:"payload"`
+}
+
+func NewPlayCommandIssued(messageId string, opts ...HeaderOption) *PlayCommandIssued {
+	m := new(PlayCommandIssued)
+	m.Message = newEvent("PlaybackController", "PlayCommandIssued", messageId, "", opts...)
+	return m
+}
+
+// The PreviousCommandIssued event.
+type PreviousCommandIssued struct {
+	*Message
+	Payload struct{} `json:"payload"`
+}
+
+func NewPreviousCommandIssued(messageId string, opts ...HeaderOption) *PreviousCommandIssued {
+	m := new(PreviousCommandIssued)
+	m.Message = newEvent("PlaybackController", "PreviousCommandIssued", messageId, "", opts...)
+	return m
+}
@@ -0,0 +1,451 @@
+package avs
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrExpired is returned by PlaybackQueue.Enqueue for audio items that expired
+// before they were enqueued.
+var ErrExpired = errors.New("avs: audio item has expired")
+
+// ErrUnexpectedPreviousToken is returned by PlaybackQueue.Enqueue for ENQUEUE
+// items whose expected previous token isn't the token of the last item in
+// the queue.
+var ErrUnexpectedPreviousToken = errors.New("avs: audio item doesn't follow the last item in the queue")
+
+// PlaybackQueue holds the audio items of Play directives in the order in
+// which they should be played, applying their play behavior.
+//
+// Items whose expiry time has passed when they're enqueued are dropped and
+// reported to AVS with a PlaybackFailed event. As the device clock may be
+// ahead, an item is only considered expired once SkewTolerance has passed
+// since its expiry time. Items without an expiry time never expire, and an
+// item that expires after it was enqueued is still played.
+//
+// ENQUEUE items are also dropped if their expected previous token isn't the
+// token of the last item of the queue. This happens when a REPLACE_ALL item
+// arrives while AVS is sending an item meant to follow the replaced ones.
+//
+// With a FocusManager, the queue holds the Content channel while it has
+// items, so that the dialog and the alerts put them in the background. The
+// player should follow the focus of the queue (see FocusState and Focused):
+// pause in the BACKGROUND, resume in the FOREGROUND and stop at NONE.
+type PlaybackQueue struct {
+	// Client and AccessToken are used to send the PlaybackFailed events. If
+	// Client is nil, expired items are dropped silently.
+	Client      *Client
+	AccessToken string
+	// Playback, if set, provides the playback state sent with the
+	// PlaybackFailed events.
+	Playback *PlaybackStateProvider
+	// Clock, if set, replaces the system clock.
+	Clock Clock
+	// SkewTolerance is how long after its expiry time an item is still
+	// accepted.
+	SkewTolerance time.Duration
+	// Expired, if set, is called with every item that is dropped because it
+	// expired.
+	Expired func(play *Play)
+	// Discarded, if set, is called with every ENQUEUE item that is dropped
+	// because of its expected previous token.
+	Discarded func(play *Play)
+	// Changed, if set, is called whenever the items of the queue or their
+	// titles change, after the change (e.g., to refresh a UI with Current
+	// and Upcoming).
+	Changed func()
+	// Metadata, if set, reports the metadata extracted from the streams of
+	// the items (see Extracted), and forgets each stream once its item is
+	// removed.
+	Metadata *StreamMetadataReporter
+	// Focus, if set, gives the Content channel to the queue while it has
+	// items.
+	Focus *FocusManager
+	// Ducker, if set, lowers the volume of the items instead of pausing
+	// them while the Dialog channel is in the foreground, if the Focus ducks
+	// content (see FocusManager.DuckContent).
+	Ducker Ducker
+	// Focused, if set, is called with every change of the focus of the
+	// queue, after the change.
+	Focused func(state FocusState)
+
+	mu     sync.Mutex
+	items  []*queuedPlay
+	titles map[string]string // by audio item id
+	// The token of the last item removed by Advance or by clearing the
+	// queue, which is the last item of the queue while it's empty.
+	played string
+	// The observer of the Content channel, whether it holds the channel and
+	// its focus. refocused is closed when the focus changes.
+	observer  FocusObserver
+	holding   bool
+	focus     FocusState
+	refocused chan struct{}
+	// Keeps the channel from being acquired and released out of order.
+	focusMu sync.Mutex
+}
+
+// An item of the queue.
+type queuedPlay struct {
+	play     *Play
+	enqueued time.Time
+}
+
+// QueueItem describes an item of a PlaybackQueue. Changing it doesn't affect
+// the queue.
+type QueueItem struct {
+	Token       string
+	AudioItemId string
+	// Title is the title set with SetTitle, if any.
+	Title   string
+	URLType StreamURLType
+	// Enqueued is when the item was added to the queue.
+	Enqueued time.Time
+	// Expiry is the expiry time of the stream, or zero if it doesn't expire.
+	Expiry time.Time
+}
+
+// ShortToken returns a short abbreviation of the token, which is opaque and
+// may be long, to identify the item in logs and UIs. It isn't unique.
+func (i QueueItem) ShortToken() string {
+	sum := sha1.Sum([]byte(i.Token))
+	return hex.EncodeToString(sum[:4])
+}
+
+// StreamURLType tells where the audio of a stream comes from.
+type StreamURLType int
+
+// Possible values for StreamURLType.
+const (
+	// StreamURLRemote streams are downloaded from their URL.
+	StreamURLRemote StreamURLType = iota
+	// StreamURLAttachment streams are attached to the Play directive; their
+	// URL is a cid: URL.
+	StreamURLAttachment
+)
+
+// Enqueue adds the audio item of the Play directive to the queue according to
+// its play behavior. If the item has expired, the queue is left as is, a
+// PlaybackFailed event with MEDIA_ERROR_INVALID_REQUEST is sent and
+// ErrExpired is returned, or the error sending the event.
+//
+// An ENQUEUE item with an expected previous token that doesn't match the last
+// item is discarded and ErrUnexpectedPreviousToken is returned. If the queue
+// has never had an item, there is nothing to match and it's accepted.
+//
+// The item being played is expected to be the first one; REPLACE_ALL removes
+// it along with the rest.
+func (q *PlaybackQueue) Enqueue(ctx context.Context, play *Play) error {
+	if q.expired(play) {
+		if q.Expired != nil {
+			q.Expired(play)
+		}
+		if err := q.reportExpired(ctx, play); err != nil {
+			return err
+		}
+		return ErrExpired
+	}
+	q.mu.Lock()
+	switch play.Payload.PlayBehavior {
+	case PlayBehaviorEnqueue:
+		expected := play.Payload.AudioItem.Stream.ExpectedPreviousToken
+		if tail := q.tail(); expected != "" && tail != "" && expected != tail {
+			q.mu.Unlock()
+			if q.Discarded != nil {
+				q.Discarded(play)
+			}
+			return ErrUnexpectedPreviousToken
+		}
+	case PlayBehaviorReplaceAll:
+		q.remove(0)
+	case PlayBehaviorReplaceEnqueued:
+		q.remove(1)
+	}
+	q.items = append(q.items, &queuedPlay{play: play, enqueued: clockOrDefault(q.Clock).Now()})
+	q.mu.Unlock()
+	q.changed()
+	return nil
+}
+
+// Removes the items from the index on, along with their titles. The lock
+// must be held.
+func (q *PlaybackQueue) remove(from int) {
+	if len(q.items) <= from {
+		return
+	}
+	for _, item := range q.items[from:] {
+		delete(q.titles, item.play.Payload.AudioItem.AudioItemId)
+		q.forget(item.play)
+	}
+	q.items = q.items[:from]
+}
+
+// Forgets the metadata reported for the stream of the item.
+func (q *PlaybackQueue) forget(play *Play) {
+	if q.Metadata != nil {
+		q.Metadata.Forget(play.Payload.AudioItem.Stream.Token)
+	}
+}
+
+// Extracted returns the function that reports the metadata extracted from the
+// stream of the item with the Metadata reporter, to be passed to a metadata
+// extractor (e.g., avsaudio.NewICYReader). It returns nil if the queue has
+// no Metadata reporter, which the extractors of avsaudio accept.
+func (q *PlaybackQueue) Extracted(play *Play) func(metadata map[string]string) {
+	if q.Metadata == nil {
+		return nil
+	}
+	return q.Metadata.Extracted(play.Payload.AudioItem.Stream.Token)
+}
+
+func (q *PlaybackQueue) changed() {
+	q.updateFocus()
+	if q.Changed != nil {
+		q.Changed()
+	}
+}
+
+// FocusState returns the focus of the queue on the Content channel, which is
+// NONE while it doesn't hold the channel.
+func (q *PlaybackQueue) FocusState() FocusState {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.focus == "" {
+		return FocusStateNone
+	}
+	return q.focus
+}
+
+// Acquires the Content channel if the queue has items and releases it once
+// it's empty.
+func (q *PlaybackQueue) updateFocus() {
+	if q.Focus == nil {
+		return
+	}
+	q.focusMu.Lock()
+	defer q.focusMu.Unlock()
+	q.mu.Lock()
+	if q.observer == nil {
+		if q.Ducker != nil {
+			q.observer = &duckingQueueFocus{queueFocus{q}}
+		} else {
+			q.observer = &queueFocus{q}
+		}
+	}
+	observer, holding, hold := q.observer, q.holding, len(q.items) > 0
+	q.holding = hold
+	q.mu.Unlock()
+	switch {
+	case hold && !holding:
+		if err := q.Focus.AcquireChannel(ChannelContent, observer); err != nil {
+			q.mu.Lock()
+			q.holding = false
+			q.mu.Unlock()
+		}
+	case !hold && holding:
+		q.Focus.ReleaseChannel(ChannelContent, observer)
+	}
+}
+
+// Records a change of the focus of the queue.
+func (q *PlaybackQueue) focusChanged(state FocusState) {
+	q.mu.Lock()
+	q.focus = state
+	if state == FocusStateNone {
+		// The channel was released, or taken by another observer, in which
+		// case the next item acquires it again.
+		q.holding = false
+	}
+	if q.refocused != nil {
+		close(q.refocused)
+		q.refocused = nil
+	}
+	q.mu.Unlock()
+	if q.Focused != nil {
+		q.Focused(state)
+	}
+}
+
+// Blocks while the queue is in the background, or until ctx is done.
+func (q *PlaybackQueue) waitForeground(ctx context.Context) error {
+	for {
+		q.mu.Lock()
+		if q.focus != FocusStateBackground {
+			q.mu.Unlock()
+			return nil
+		}
+		if q.refocused == nil {
+			q.refocused = make(chan struct{})
+		}
+		refocused := q.refocused
+		q.mu.Unlock()
+		select {
+		case <-refocused:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// The FocusObserver of the Content channel for a queue.
+type queueFocus struct{ q *PlaybackQueue }
+
+func (f *queueFocus) FocusChanged(channel Channel, state FocusState) {
+	f.q.focusChanged(state)
+}
+
+// The FocusObserver of the Content channel for a queue with a Ducker.
+type duckingQueueFocus struct{ queueFocus }
+
+func (f *duckingQueueFocus) SetDuck(level float64) {
+	f.q.Ducker.SetDuck(level)
+}
+
+// Returns the token of the last item of the queue.
+func (q *PlaybackQueue) tail() string {
+	if len(q.items) == 0 {
+		return q.played
+	}
+	return q.items[len(q.items)-1].play.Payload.AudioItem.Stream.Token
+}
+
+// Peek returns the item being played, or nil if the queue is empty.
+func (q *PlaybackQueue) Peek() *Play {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 {
+		return nil
+	}
+	return q.items[0].play
+}
+
+// Advance removes the item being played (e.g., when it finished) and returns
+// the next one, or nil if the queue is empty.
+func (q *PlaybackQueue) Advance() *Play {
+	q.mu.Lock()
+	var next *Play
+	if len(q.items) > 0 {
+		q.played = q.items[0].play.Payload.AudioItem.Stream.Token
+		delete(q.titles, q.items[0].play.Payload.AudioItem.AudioItemId)
+		q.forget(q.items[0].play)
+		q.items[0] = nil
+		q.items = q.items[1:]
+	}
+	if len(q.items) > 0 {
+		next = q.items[0].play
+	}
+	q.mu.Unlock()
+	q.changed()
+	return next
+}
+
+// Clear removes items from the queue as requested by a ClearQueue directive.
+// Clearing all of them also forgets the titles of the items that were never
+// enqueued. The last item that was cleared is still the last item of the
+// queue, so that a stale ENQUEUE expecting another one is discarded.
+func (q *PlaybackQueue) Clear(behavior ClearBehavior) {
+	q.mu.Lock()
+	if behavior == ClearBehaviorClearAll {
+		q.played = q.tail()
+		q.remove(0)
+		q.titles = nil
+	} else {
+		q.remove(1)
+	}
+	q.mu.Unlock()
+	q.changed()
+}
+
+// SetTitle sets the title of the audio item with the id (e.g., from the
+// player info shown for it), which may be enqueued later.
+func (q *PlaybackQueue) SetTitle(audioItemId, title string) {
+	q.mu.Lock()
+	if q.titles == nil {
+		q.titles = make(map[string]string)
+	}
+	q.titles[audioItemId] = title
+	q.mu.Unlock()
+	q.changed()
+}
+
+// Current returns the item being played, and false if the queue is empty.
+func (q *PlaybackQueue) Current() (QueueItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 {
+		return QueueItem{}, false
+	}
+	return q.view(q.items[0]), true
+}
+
+// Upcoming returns the items after the one being played, in order.
+func (q *PlaybackQueue) Upcoming() []QueueItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) <= 1 {
+		return nil
+	}
+	items := make([]QueueItem, len(q.items)-1)
+	for i, item := range q.items[1:] {
+		items[i] = q.view(item)
+	}
+	return items
+}
+
+// Returns the QueueItem of an item. The lock must be held.
+func (q *PlaybackQueue) view(item *queuedPlay) QueueItem {
+	audioItem := item.play.Payload.AudioItem
+	v := QueueItem{
+		Token:       audioItem.Stream.Token,
+		AudioItemId: audioItem.AudioItemId,
+		Title:       q.titles[audioItem.AudioItemId],
+		Enqueued:    item.enqueued,
+		Expiry:      audioItem.Stream.ExpiryTime.Time,
+	}
+	if audioItem.Stream.ContentId() != "" {
+		v.URLType = StreamURLAttachment
+	}
+	return v
+}
+
+// Len returns the number of items in the queue, including the one being
+// played.
+func (q *PlaybackQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+func (q *PlaybackQueue) expired(play *Play) bool {
+	expiry := play.Payload.AudioItem.Stream.ExpiryTime
+	if expiry.IsZero() {
+		return false
+	}
+	return clockOrDefault(q.Clock).Now().After(expiry.Add(q.SkewTolerance))
+}
+
+func (q *PlaybackQueue) reportExpired(ctx context.Context, play *Play) error {
+	if q.Client == nil {
+		return nil
+	}
+	stream := play.Payload.AudioItem.Stream
+	event := NewPlaybackFailed(RandomUUIDString(), stream.Token, MediaErrorTypeInvalidRequest,
+		fmt.Sprintf("audio item expired at %s", stream.ExpiryTime))
+	if q.Playback != nil {
+		token, offset, activity := q.Playback.State()
+		event.Payload.CurrentPlaybackState = playbackState{
+			Token:                token,
+			OffsetInMilliseconds: int(offset / time.Millisecond),
+			PlayerActivity:       activity,
+		}
+	}
+	request := NewRequest(q.AccessToken)
+	request.Event = event
+	_, err := q.Client.DoContext(ctx, request)
+	return err
+}
@@ -0,0 +1,263 @@
+package avs_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+	"github.com/fika-io/go-avs/avstest"
+)
+
+func newPlay(token string, behavior avs.PlayBehavior, expiry time.Time) *avs.Play {
+	play := new(avs.Play)
+	play.Message = new(avs.Message)
+	play.Payload.PlayBehavior = behavior
+	play.Payload.AudioItem.Stream.Token = token
+	play.Payload.AudioItem.Stream.ExpiryTime = avs.Timestamp{Time: expiry}
+	return play
+}
+
+func tokens(q *avs.PlaybackQueue) []string {
+	var tokens []string
+	for play := q.Peek(); play != nil; play = q.Advance() {
+		tokens = append(tokens, play.Payload.AudioItem.Stream.Token)
+	}
+	return tokens
+}
+
+func TestPlaybackQueueBehaviors(t *testing.T) {
+	q := new(avs.PlaybackQueue)
+	ctx := context.Background()
+	q.Enqueue(ctx, newPlay("a", avs.PlayBehaviorReplaceAll, time.Time{}))
+	q.Enqueue(ctx, newPlay("b", avs.PlayBehaviorEnqueue, time.Time{}))
+	q.Enqueue(ctx, newPlay("c", avs.PlayBehaviorReplaceEnqueued, time.Time{}))
+	q.Enqueue(ctx, newPlay("d", avs.PlayBehaviorEnqueue, time.Time{}))
+	if got := tokens(q); len(got) != 3 || got[0] != "a" || got[1] != "c" || got[2] != "d" {
+		t.Errorf("got %v; want [a c d]", got)
+	}
+	q.Enqueue(ctx, newPlay("e", avs.PlayBehaviorEnqueue, time.Time{}))
+	q.Enqueue(ctx, newPlay("f", avs.PlayBehaviorEnqueue, time.Time{}))
+	q.Clear(avs.ClearBehaviorClearEnqueued)
+	if q.Len() != 1 || q.Peek().Payload.AudioItem.Stream.Token != "e" {
+		t.Errorf("got %d item(s) after CLEAR_ENQUEUED; want only e", q.Len())
+	}
+	q.Enqueue(ctx, newPlay("g", avs.PlayBehaviorReplaceAll, time.Time{}))
+	if got := tokens(q); len(got) != 1 || got[0] != "g" {
+		t.Errorf("got %v; want [g]", got)
+	}
+}
+
+func enqueueAfter(token, previous string) *avs.Play {
+	play := newPlay(token, avs.PlayBehaviorEnqueue, time.Time{})
+	play.Payload.AudioItem.Stream.ExpectedPreviousToken = previous
+	return play
+}
+
+func TestPlaybackQueueExpectedPreviousToken(t *testing.T) {
+	var discarded []string
+	q := &avs.PlaybackQueue{Discarded: func(play *avs.Play) {
+		discarded = append(discarded, play.Payload.AudioItem.Stream.Token)
+	}}
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, enqueueAfter("a", "unknown")); err != nil {
+		t.Errorf("got %v for the first item; want it accepted", err)
+	}
+	if err := q.Enqueue(ctx, enqueueAfter("b", "a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(ctx, enqueueAfter("x", "a")); err != avs.ErrUnexpectedPreviousToken {
+		t.Errorf("got %v; want ErrUnexpectedPreviousToken", err)
+	}
+	// Once the queue is played to the end, the last item is still the tail.
+	q.Advance()
+	q.Advance()
+	if err := q.Enqueue(ctx, enqueueAfter("c", "b")); err != nil {
+		t.Errorf("got %v after the queue ended; want c accepted", err)
+	}
+	// Clearing the queue keeps its last item too.
+	q.Enqueue(ctx, enqueueAfter("d", "c"))
+	q.Clear(avs.ClearBehaviorClearAll)
+	if err := q.Enqueue(ctx, enqueueAfter("y", "c")); err != avs.ErrUnexpectedPreviousToken {
+		t.Errorf("got %v after CLEAR_ALL; want ErrUnexpectedPreviousToken", err)
+	}
+	if err := q.Enqueue(ctx, enqueueAfter("e", "d")); err != nil {
+		t.Errorf("got %v after CLEAR_ALL; want e accepted", err)
+	}
+	if len(discarded) != 2 || discarded[0] != "x" || discarded[1] != "y" {
+		t.Errorf("got discarded %v; want [x y]", discarded)
+	}
+}
+
+func TestPlaybackQueueReplaceAllRace(t *testing.T) {
+	// The user skips while AVS sends the item meant to follow "a": the
+	// REPLACE_ALL for "b" may arrive before or after the stale ENQUEUE, and
+	// in both cases only "b" and what follows it should be played.
+	for _, staleFirst := range []bool{false, true} {
+		q := new(avs.PlaybackQueue)
+		ctx := context.Background()
+		q.Enqueue(ctx, newPlay("a", avs.PlayBehaviorReplaceAll, time.Time{}))
+		enqueueStale := func() {
+			if err := q.Enqueue(ctx, enqueueAfter("a2", "a")); staleFirst == (err != nil) {
+				t.Errorf("stale first %t: got %v for a2", staleFirst, err)
+			}
+		}
+		if staleFirst {
+			enqueueStale()
+		}
+		q.Enqueue(ctx, newPlay("b", avs.PlayBehaviorReplaceAll, time.Time{}))
+		if !staleFirst {
+			enqueueStale()
+		}
+		if err := q.Enqueue(ctx, enqueueAfter("b2", "b")); err != nil {
+			t.Errorf("stale first %t: got %v for b2", staleFirst, err)
+		}
+		if got := tokens(q); len(got) != 2 || got[0] != "b" || got[1] != "b2" {
+			t.Errorf("stale first %t: got %v; want [b b2]", staleFirst, got)
+		}
+	}
+}
+
+func TestPlaybackQueueExpiry(t *testing.T) {
+	server := avstest.NewServer()
+	defer server.Close()
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	var expired []*avs.Play
+	q := &avs.PlaybackQueue{
+		Client:        &avs.Client{EndpointURL: server.URL},
+		AccessToken:   "token",
+		Clock:         avstest.NewFakeClock(now),
+		SkewTolerance: time.Minute,
+		Expired:       func(play *avs.Play) { expired = append(expired, play) },
+	}
+	ctx := context.Background()
+	// Within the tolerance, the item is accepted.
+	if err := q.Enqueue(ctx, newPlay("a", avs.PlayBehaviorEnqueue, now.Add(-30*time.Second))); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(ctx, newPlay("b", avs.PlayBehaviorEnqueue, now.Add(-2*time.Minute))); err != avs.ErrExpired {
+		t.Errorf("got %v; want ErrExpired", err)
+	}
+	if q.Len() != 1 || len(expired) != 1 || expired[0].Payload.AudioItem.Stream.Token != "b" {
+		t.Errorf("got %d queued and %d expired item(s); want 1 of each", q.Len(), len(expired))
+	}
+	requests := server.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests; want 1", len(requests))
+	}
+	failed, ok := requests[0].Event.(*avs.PlaybackFailed)
+	if !ok {
+		t.Fatalf("got event %v; want PlaybackFailed", requests[0].Event)
+	}
+	if failed.Payload.Token != "b" || failed.Payload.Error.Type != avs.MediaErrorTypeInvalidRequest {
+		t.Errorf("got PlaybackFailed for %s with %s", failed.Payload.Token, failed.Payload.Error.Type)
+	}
+}
+
+func TestPlaybackQueueIntrospection(t *testing.T) {
+	clock := avstest.NewFakeClock(time.Date(2017, 5, 16, 12, 0, 0, 0, time.UTC))
+	changes := make(chan struct{}, 10)
+	q := &avs.PlaybackQueue{Clock: clock, Changed: func() { changes <- struct{}{} }}
+	ctx := context.Background()
+	if _, ok := q.Current(); ok {
+		t.Error("got a current item in an empty queue")
+	}
+	q.SetTitle("item-b", "Song B")
+	a := newPlay("a", avs.PlayBehaviorReplaceAll, time.Time{})
+	a.Payload.AudioItem.Stream.URL = "cid:attached"
+	q.Enqueue(ctx, a)
+	clock.Advance(time.Second)
+	expiry := clock.Now().Add(time.Hour)
+	b := newPlay("b", avs.PlayBehaviorEnqueue, expiry)
+	b.Payload.AudioItem.AudioItemId = "item-b"
+	b.Payload.AudioItem.Stream.URL = "https://example.com/b.mp3"
+	q.Enqueue(ctx, b)
+	if got := len(changes); got != 3 {
+		t.Errorf("got %d change notifications, want 3", got)
+	}
+
+	current, ok := q.Current()
+	if !ok || current.Token != "a" || current.URLType != avs.StreamURLAttachment || !current.Expiry.IsZero() {
+		t.Errorf("got current item %+v", current)
+	}
+	upcoming := q.Upcoming()
+	if len(upcoming) != 1 {
+		t.Fatalf("got %d upcoming items, want 1", len(upcoming))
+	}
+	want := avs.QueueItem{
+		Token:       "b",
+		AudioItemId: "item-b",
+		Title:       "Song B",
+		URLType:     avs.StreamURLRemote,
+		Enqueued:    clock.Now(),
+		Expiry:      expiry,
+	}
+	if upcoming[0] != want {
+		t.Errorf("got upcoming item %+v, want %+v", upcoming[0], want)
+	}
+	if short := upcoming[0].ShortToken(); len(short) != 8 || short == current.ShortToken() {
+		t.Errorf("got short tokens %s and %s", short, current.ShortToken())
+	}
+	// The views are copies.
+	upcoming[0].Token = "changed"
+	if q.Upcoming()[0].Token != "b" {
+		t.Error("changing a view changed the queue")
+	}
+
+	q.Advance()
+	if current, _ := q.Current(); current.Title != "Song B" || len(q.Upcoming()) != 0 {
+		t.Errorf("got current item %+v after advancing", current)
+	}
+	q.Advance()
+	q.Enqueue(ctx, b)
+	if current, _ := q.Current(); current.Title != "" {
+		t.Errorf("title %q was kept after the item left the queue", current.Title)
+	}
+}
+
+type fakeObserver struct{}
+
+func (*fakeObserver) FocusChanged(channel avs.Channel, state avs.FocusState) {}
+
+type fakeDucker struct{ levels []float64 }
+
+func (d *fakeDucker) SetDuck(level float64) { d.levels = append(d.levels, level) }
+
+// The queue holds the Content channel while it has items.
+func TestPlaybackQueueFocus(t *testing.T) {
+	focus := avs.NewFocusManager()
+	var states []avs.FocusState
+	q := &avs.PlaybackQueue{Focus: focus, Focused: func(state avs.FocusState) { states = append(states, state) }}
+	ctx := context.Background()
+	q.Enqueue(ctx, newPlay("a", avs.PlayBehaviorReplaceAll, time.Time{}))
+	q.Enqueue(ctx, newPlay("b", avs.PlayBehaviorEnqueue, time.Time{}))
+	if got := q.FocusState(); got != avs.FocusStateForeground {
+		t.Errorf("got %s with items; want FOREGROUND", got)
+	}
+	dialog := new(fakeObserver)
+	focus.AcquireChannel(avs.ChannelDialog, dialog)
+	if got := q.FocusState(); got != avs.FocusStateBackground {
+		t.Errorf("got %s during a dialog; want BACKGROUND", got)
+	}
+	focus.ReleaseChannel(avs.ChannelDialog, dialog)
+	q.Advance()
+	q.Advance()
+	if got := focus.State(avs.ChannelContent); got != avs.FocusStateNone {
+		t.Errorf("the Content channel is %s once the queue is empty; want NONE", got)
+	}
+	want := []avs.FocusState{avs.FocusStateForeground, avs.FocusStateBackground, avs.FocusStateForeground, avs.FocusStateNone}
+	if fmt.Sprint(states) != fmt.Sprint(want) {
+		t.Errorf("got focus %v; want %v", states, want)
+	}
+
+	// With a Ducker, the items duck instead.
+	focus.DuckContent = true
+	ducker := new(fakeDucker)
+	q = &avs.PlaybackQueue{Focus: focus, Ducker: ducker}
+	q.Enqueue(ctx, newPlay("c", avs.PlayBehaviorReplaceAll, time.Time{}))
+	focus.AcquireChannel(avs.ChannelDialog, dialog)
+	if got := q.FocusState(); got != avs.FocusStateDucked || len(ducker.levels) != 1 || ducker.levels[0] != avs.DefaultDuckLevel {
+		t.Errorf("got %s and levels %v during a dialog; want DUCKED", got, ducker.levels)
+	}
+}
@@ -0,0 +1,127 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrProcessedNotRequested is returned by Client.WaitProcessed for tokens of
+// events that weren't sent with AwaitProcessed.
+var ErrProcessedNotRequested = errors.New("avs: no processing confirmation was requested for the event")
+
+// How many events a Client keeps track of, counting those already confirmed.
+const maxTrackedEvents = 256
+
+// AwaitProcessed sets an eventCorrelationToken on the event of the request,
+// unless it has one, and returns it. Once the request is sent,
+// Client.WaitProcessed waits for the EventProcessed directive with the token.
+func (r *Request) AwaitProcessed() string {
+	m := r.Event.GetMessage()
+	token, ok := m.headerField("eventCorrelationToken")
+	if !ok {
+		token = RandomUUIDString()
+		m.Header["eventCorrelationToken"] = token
+	}
+	return token
+}
+
+// WaitProcessed waits until AVS confirms with an EventProcessed directive
+// that it processed the event with the correlation token, which must have
+// been sent with a request of the client (see Request.AwaitProcessed). The
+// directive may arrive in the response to the event or on the downchannel.
+//
+// It returns ErrProcessedNotRequested for unknown tokens, the error of the
+// request if it failed, or the error of ctx if it's done first.
+func (c *Client) WaitProcessed(ctx context.Context, correlationToken string) error {
+	return c.processed.wait(ctx, correlationToken)
+}
+
+// The events waiting for an EventProcessed directive.
+type processedTracker struct {
+	mu     sync.Mutex
+	events map[string]*processedEvent
+	order  []string
+}
+
+type processedEvent struct {
+	done chan struct{}
+	err  error
+}
+
+// Starts tracking the events of the envelopes that have a correlation token.
+func (t *processedTracker) expect(envelopes []*Envelope) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, envelope := range envelopes {
+		if envelope.Event == nil {
+			continue
+		}
+		token, ok := envelope.Event.GetMessage().headerField("eventCorrelationToken")
+		if !ok || t.events[token] != nil {
+			continue
+		}
+		if t.events == nil {
+			t.events = make(map[string]*processedEvent)
+		}
+		if len(t.order) >= maxTrackedEvents {
+			delete(t.events, t.order[0])
+			t.order = t.order[1:]
+		}
+		t.events[token] = &processedEvent{done: make(chan struct{})}
+		t.order = append(t.order, token)
+	}
+}
+
+// Fails the events of the envelopes that are still waiting, because their
+// request failed.
+func (t *processedTracker) fail(envelopes []*Envelope, err error) {
+	for _, envelope := range envelopes {
+		if envelope.Event == nil {
+			continue
+		}
+		if token, ok := envelope.Event.GetMessage().headerField("eventCorrelationToken"); ok {
+			t.finish(token, err)
+		}
+	}
+}
+
+// Confirms the event of the directive if it's an EventProcessed directive.
+func (t *processedTracker) observe(directive *Message) {
+	if t == nil || directive.Type() != TypeEventProcessed {
+		return
+	}
+	if token, ok := directive.headerField("eventCorrelationToken"); ok {
+		t.finish(token, nil)
+	}
+}
+
+func (t *processedTracker) finish(token string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.events[token]
+	if e == nil {
+		return
+	}
+	select {
+	case <-e.done:
+	default:
+		e.err = err
+		close(e.done)
+	}
+}
+
+func (t *processedTracker) wait(ctx context.Context, token string) error {
+	t.mu.Lock()
+	e := t.events[token]
+	t.mu.Unlock()
+	if e == nil {
+		return ErrProcessedNotRequested
+	}
+	select {
+	case <-e.done:
+		return e.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
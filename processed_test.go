@@ -0,0 +1,78 @@
+package avs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWaitProcessed(t *testing.T) {
+	confirm := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !confirm {
+			w.WriteHeader(204)
+			return
+		}
+		r.ParseMultipartForm(1 << 20)
+		var request Request
+		json.Unmarshal([]byte(r.MultipartForm.Value["metadata"][0]), &request)
+		token := request.Event.GetMessage().Header["eventCorrelationToken"]
+		w.Header().Set("Content-Type", "multipart/related; boundary=------abcde123; type=application/json")
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "--------abcde123\r\nContent-Type: application/json\r\n\r\n"+
+			`{"directive":{"header":{"namespace":"Alexa","name":"EventProcessed","messageId":"m2","eventCorrelationToken":%q},"payload":{}}}`+
+			"\r\n--------abcde123--\r\n", token)
+	}))
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL}
+	ctx := context.Background()
+
+	request := NewRequest("token")
+	request.Event = NewSynchronizeState("m1")
+	token := request.AwaitProcessed()
+	if _, err := client.Do(request); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WaitProcessed(ctx, token); err != nil {
+		t.Errorf("WaitProcessed: %v", err)
+	}
+	if err := client.WaitProcessed(ctx, "unknown"); err != ErrProcessedNotRequested {
+		t.Errorf("got %v for an unknown token; want ErrProcessedNotRequested", err)
+	}
+
+	confirm = false
+	request.Event = NewSynchronizeState("m3")
+	token = request.AwaitProcessed()
+	if _, err := client.Do(request); err != nil {
+		t.Fatal(err)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := client.WaitProcessed(timeoutCtx, token); err != context.DeadlineExceeded {
+		t.Errorf("got %v without a confirmation; want context.DeadlineExceeded", err)
+	}
+}
+
+func TestWaitProcessedFailedRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL}
+	request := NewRequest("token")
+	request.Event = NewSynchronizeState("m1")
+	token := request.AwaitProcessed()
+	_, sendErr := client.Do(request)
+	if sendErr == nil {
+		t.Fatal("expected the request to fail")
+	}
+	var requestErr *RequestError
+	if err := client.WaitProcessed(context.Background(), token); !errors.As(err, &requestErr) {
+		t.Errorf("got %v; want the error of the request", err)
+	}
+}
@@ -0,0 +1,214 @@
+package avs
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ProgressReporter schedules the ProgressReportDelayElapsed and
+// ProgressReportIntervalElapsed events that the ProgressReport of a Play
+// directive asks for.
+//
+// The reports are scheduled in content time, like the offsets: at a playback
+// rate of 1.5, an interval of 15 seconds elapses every 10 seconds. The offset
+// is extrapolated from the last Start, Resume or Seek, so every change of the
+// player must be reported to keep it accurate.
+type ProgressReporter struct {
+	// Clock, if set, replaces the system clock. It must be set before the
+	// first call to Start.
+	Clock Clock
+	// OnReport is called with every event due, which should be sent to AVS.
+	// It's called from a goroutine of the reporter.
+	OnReport func(event TypedMessage)
+
+	mu     sync.Mutex
+	token  string
+	report ProgressReport
+	// The offset at the time since, in content time.
+	offset time.Duration
+	since  time.Time
+	rate   float64
+	// Whether the audio item is playing, rather than paused.
+	playing bool
+	// Whether the delay report was sent or is behind the offset.
+	delayDone bool
+	// The interval reports are due after that offset.
+	intervalAfter time.Duration
+	// Closed to stop waiting for the next report.
+	wait chan struct{}
+}
+
+// NewProgressReporter returns a ProgressReporter that passes the events due
+// to onReport.
+func NewProgressReporter(onReport func(event TypedMessage)) *ProgressReporter {
+	return &ProgressReporter{OnReport: onReport, rate: 1}
+}
+
+// Start starts scheduling the reports of the audio item with the token, which
+// starts playing at the offset. The reports of the previous item stop.
+func (r *ProgressReporter) Start(token string, offset time.Duration, report ProgressReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.token = token
+	r.report = report
+	r.offset = offset
+	r.since = clockOrDefault(r.Clock).Now()
+	r.playing = true
+	r.delayDone = report.Delay() <= 0 || offset >= report.Delay()
+	r.intervalAfter = offset
+	r.schedule()
+}
+
+// Pause stops the reports until Resume.
+func (r *ProgressReporter) Pause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.offset = r.offsetLocked()
+	r.since = clockOrDefault(r.Clock).Now()
+	r.playing = false
+	r.schedule()
+}
+
+// Resume restarts the reports after Pause.
+func (r *ProgressReporter) Resume() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.since = clockOrDefault(r.Clock).Now()
+	r.playing = r.token != ""
+	r.schedule()
+}
+
+// Seek records that the player jumped to the offset. The reports due
+// between the old and the new offsets aren't sent.
+func (r *ProgressReporter) Seek(offset time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.offset = offset
+	r.since = clockOrDefault(r.Clock).Now()
+	if delay := r.report.Delay(); delay > 0 {
+		r.delayDone = offset >= delay
+	}
+	r.intervalAfter = offset
+	r.schedule()
+}
+
+// SetRate changes the playback rate from now on. Zero means 1.
+func (r *ProgressReporter) SetRate(rate float64) {
+	if rate <= 0 {
+		rate = 1
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.offset = r.offsetLocked()
+	r.since = clockOrDefault(r.Clock).Now()
+	r.rate = rate
+	r.schedule()
+}
+
+// Stop stops the reports of the audio item.
+func (r *ProgressReporter) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.token = ""
+	r.playing = false
+	r.schedule()
+}
+
+// Offset returns the token of the audio item and its offset in content time.
+func (r *ProgressReporter) Offset() (token string, offset time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.token, r.offsetLocked()
+}
+
+// Returns the current offset. r.mu must be held.
+func (r *ProgressReporter) offsetLocked() time.Duration {
+	if !r.playing {
+		return r.offset
+	}
+	return r.offset + scaleDuration(clockOrDefault(r.Clock).Now().Sub(r.since), r.playbackRate())
+}
+
+// Returns the playback rate. r.mu must be held.
+func (r *ProgressReporter) playbackRate() float64 {
+	if r.rate <= 0 {
+		return 1
+	}
+	return r.rate
+}
+
+// Returns the offset of the next report and whether there's one. r.mu must
+// be held.
+func (r *ProgressReporter) next() (time.Duration, bool) {
+	var at time.Duration
+	ok := false
+	if !r.delayDone {
+		at, ok = r.report.Delay(), true
+	}
+	if interval := r.report.Interval(); interval > 0 {
+		if next := (r.intervalAfter/interval + 1) * interval; !ok || next < at {
+			at, ok = next, true
+		}
+	}
+	return at, ok
+}
+
+// Stops waiting for the current report and waits for the next one, if the
+// audio item is playing. r.mu must be held.
+func (r *ProgressReporter) schedule() {
+	if r.wait != nil {
+		close(r.wait)
+		r.wait = nil
+	}
+	if !r.playing {
+		return
+	}
+	at, ok := r.next()
+	if !ok {
+		return
+	}
+	wait := make(chan struct{})
+	r.wait = wait
+	// The wall time until the offset of the report, rounded up so that the
+	// offset is reached.
+	d := time.Duration(math.Ceil(float64(at-r.offsetLocked()) / r.playbackRate()))
+	timer := clockOrDefault(r.Clock).NewTimer(d)
+	go func() {
+		select {
+		case <-timer.C():
+		case <-wait:
+			timer.Stop()
+			return
+		}
+		r.mu.Lock()
+		if r.wait != wait {
+			r.mu.Unlock()
+			return
+		}
+		events := []TypedMessage{r.event(at)}
+		// Reports at the same offset (e.g., a delay that's a multiple of
+		// the interval) are due too.
+		for next, ok := r.next(); ok && next <= at; next, ok = r.next() {
+			events = append(events, r.event(next))
+		}
+		r.wait = nil
+		r.schedule()
+		r.mu.Unlock()
+		if r.OnReport != nil {
+			for _, event := range events {
+				r.OnReport(event)
+			}
+		}
+	}()
+}
+
+// Returns the event of the report at the offset. r.mu must be held.
+func (r *ProgressReporter) event(at time.Duration) TypedMessage {
+	if !r.delayDone && at == r.report.Delay() {
+		r.delayDone = true
+		return NewProgressReportDelayElapsed(RandomUUIDString(), r.token, at)
+	}
+	r.intervalAfter = at
+	return NewProgressReportIntervalElapsed(RandomUUIDString(), r.token, at)
+}
@@ -0,0 +1,97 @@
+package avs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+	"github.com/fika-io/go-avs/avstest"
+)
+
+// Returns the name and offset of the next report.
+func nextReport(t *testing.T, reports <-chan avs.TypedMessage) (string, time.Duration) {
+	t.Helper()
+	select {
+	case event := <-reports:
+		switch e := event.(type) {
+		case *avs.ProgressReportDelayElapsed:
+			return "delay", time.Duration(e.Payload.OffsetInMilliseconds) * time.Millisecond
+		case *avs.ProgressReportIntervalElapsed:
+			return "interval", time.Duration(e.Payload.OffsetInMilliseconds) * time.Millisecond
+		}
+		t.Fatalf("got event %v", event)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no report")
+	}
+	return "", 0
+}
+
+func TestProgressReporterPlaybackRate(t *testing.T) {
+	clock := avstest.NewFakeClock(time.Date(2017, 5, 16, 12, 0, 0, 0, time.UTC))
+	reports := make(chan avs.TypedMessage, 10)
+	r := avs.NewProgressReporter(func(event avs.TypedMessage) { reports <- event })
+	r.Clock = clock
+	r.SetRate(1.5)
+	r.Start("book", 0, avs.ProgressReport{ProgressReportDelayInMilliseconds: 6000, ProgressReportIntervalInMilliseconds: 15000})
+
+	// At 1.5x, the delay of 6 seconds elapses after 4 seconds, and the
+	// interval of 15 seconds after 10.
+	clock.BlockUntil(1)
+	clock.Advance(3999 * time.Millisecond)
+	select {
+	case event := <-reports:
+		t.Fatalf("got %v before the delay", event)
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(time.Millisecond)
+	if name, offset := nextReport(t, reports); name != "delay" || offset != 6*time.Second {
+		t.Errorf("got %s report at %s; want the delay at 6s", name, offset)
+	}
+	clock.BlockUntil(1)
+	clock.Advance(6 * time.Second)
+	if name, offset := nextReport(t, reports); name != "interval" || offset != 15*time.Second {
+		t.Errorf("got %s report at %s; want the interval at 15s", name, offset)
+	}
+
+	// At 1.25x, the next interval takes 12 seconds.
+	clock.BlockUntil(1)
+	r.SetRate(1.25)
+	clock.BlockUntil(1)
+	clock.Advance(12 * time.Second)
+	if name, offset := nextReport(t, reports); name != "interval" || offset != 30*time.Second {
+		t.Errorf("got %s report at %s; want the interval at 30s", name, offset)
+	}
+	if token, offset := r.Offset(); token != "book" || offset != 30*time.Second {
+		t.Errorf("got %s at %s; want book at 30s", token, offset)
+	}
+
+	// Nothing elapses while paused.
+	clock.BlockUntil(1)
+	r.Pause()
+	clock.Advance(time.Minute)
+	r.Resume()
+	clock.BlockUntil(1)
+	clock.Advance(12 * time.Second)
+	if name, offset := nextReport(t, reports); name != "interval" || offset != 45*time.Second {
+		t.Errorf("got %s report at %s; want the interval at 45s", name, offset)
+	}
+
+	// A delay that's a multiple of the interval is reported with it.
+	r.SetRate(1)
+	r.Start("next", 0, avs.ProgressReport{ProgressReportDelayInMilliseconds: 10000, ProgressReportIntervalInMilliseconds: 5000})
+	clock.BlockUntil(1)
+	clock.Advance(10 * time.Second)
+	for _, want := range []string{"interval", "delay", "interval"} {
+		name, _ := nextReport(t, reports)
+		if name != want {
+			t.Errorf("got %s report; want %s", name, want)
+		}
+	}
+	r.Stop()
+	clock.Advance(time.Minute)
+	select {
+	case event := <-reports:
+		t.Errorf("got %v after Stop", event)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
@@ -0,0 +1,378 @@
+package avs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// The Store namespace of the provisioning progress, and the key of the
+// refresh token obtained by code-based linking.
+const (
+	provisionStoreNamespace  = "Provisioning"
+	provisionRefreshTokenKey = "refreshToken"
+)
+
+// ProvisionStep is a step of the provisioning of a device.
+type ProvisionStep int
+
+// The steps of the provisioning, in the order they run.
+const (
+	// Link the device with code-based linking, or refresh the access token
+	// if it's already linked.
+	ProvisionAuthorize ProvisionStep = iota
+	ProvisionPublishCapabilities
+	ProvisionConnect
+	ProvisionSynchronizeState
+	ProvisionSoftwareInfo
+	ProvisionSettingsUpdated
+)
+
+var provisionStepNames = [...]string{
+	ProvisionAuthorize:           "Authorize",
+	ProvisionPublishCapabilities: "PublishCapabilities",
+	ProvisionConnect:             "Connect",
+	ProvisionSynchronizeState:    "SynchronizeState",
+	ProvisionSoftwareInfo:        "SoftwareInfo",
+	ProvisionSettingsUpdated:     "SettingsUpdated",
+}
+
+// String returns the name of the step.
+func (s ProvisionStep) String() string {
+	if s < 0 || int(s) >= len(provisionStepNames) {
+		return fmt.Sprintf("ProvisionStep(%d)", int(s))
+	}
+	return provisionStepNames[s]
+}
+
+// Whether the step only needs to run once for the data it sends, so that it's
+// recorded in the Store when it completes. The downchannel is opened and the
+// state synchronized on every connection.
+func (s ProvisionStep) once() bool {
+	return s == ProvisionPublishCapabilities || s == ProvisionSoftwareInfo || s == ProvisionSettingsUpdated
+}
+
+// ProvisionState is the outcome of Provisioner.Run.
+type ProvisionState int
+
+const (
+	// Every step completed: the device is linked, connected and known to
+	// AVS.
+	Provisioned ProvisionState = iota
+	// The device isn't linked to an account (or no longer is) and the user
+	// must enter a new code.
+	NeedsUserAuth
+	// A step failed, see ProvisionError.
+	ProvisionFailed
+)
+
+// String returns the name of the state.
+func (s ProvisionState) String() string {
+	switch s {
+	case Provisioned:
+		return "Provisioned"
+	case NeedsUserAuth:
+		return "NeedsUserAuth"
+	case ProvisionFailed:
+		return "ProvisionFailed"
+	}
+	return fmt.Sprintf("ProvisionState(%d)", int(s))
+}
+
+// ProvisionError is returned by Provisioner.Run when a step fails.
+type ProvisionError struct {
+	Step ProvisionStep
+	Err  error
+}
+
+// Error returns the ProvisionError formatted as a human readable string.
+func (e *ProvisionError) Error() string {
+	return fmt.Sprintf("avs: provisioning failed at %s: %v", e.Step, e.Err)
+}
+
+// Unwrap returns the error of the step.
+func (e *ProvisionError) Unwrap() error {
+	return e.Err
+}
+
+// Provisioner brings a device from its first boot to a working state:
+//
+//  1. link the device with code-based linking and persist the refresh token
+//  2. publish the capabilities of the device
+//  3. open the downchannel
+//  4. send SynchronizeState
+//  5. send SoftwareInfo, if FirmwareVersion is set
+//  6. send SettingsUpdated, if Locale is set
+//
+// The steps that only need to run once are recorded in the Store as they
+// complete, with a hash of the data they sent, so that a device restarted in
+// the middle of the provisioning continues where it stopped. They run again
+// when their data changes: a new Profile, FirmwareVersion or Locale is sent
+// by the next run. After linking, the persisted refresh token is used instead
+// of a new code.
+type Provisioner struct {
+	Client     *Client
+	Authorizer *CBLAuthorizer
+	Store      Store
+	Profile    *DeviceProfile
+	// Contexts, if set, provides the contexts of SynchronizeState.
+	Contexts *ContextAggregator
+	// FirmwareVersion, if set, is reported with SoftwareInfo.
+	FirmwareVersion string
+	// Locale, if set, is reported with SettingsUpdated.
+	Locale SettingLocale
+	// OnCodePair is called with the code that the user must enter to link
+	// the device. If nil, Run returns NeedsUserAuth instead of linking the
+	// device.
+	OnCodePair func(pair *CodePair)
+	// OnStep, if set, is called as each step starts (e.g., to show the
+	// progress). The steps skipped because they were completed by a previous
+	// run aren't reported.
+	OnStep func(step ProvisionStep)
+
+	token       *Token
+	downchannel *Downchannel
+}
+
+// NewProvisioner returns a Provisioner that links the device with the
+// authorizer, publishes the profile through the client and records its
+// progress in the store.
+func NewProvisioner(client *Client, authorizer *CBLAuthorizer, store Store, profile *DeviceProfile) *Provisioner {
+	return &Provisioner{Client: client, Authorizer: authorizer, Store: store, Profile: profile}
+}
+
+// Run runs the steps of the provisioning that haven't completed yet. Unless
+// the device needs to be linked to an account, it refreshes the access token
+// and opens the downchannel even if the device was already provisioned; they
+// are then available from Token and Downchannel.
+//
+// It returns NeedsUserAuth with an ErrUnauthorized error if the device can't
+// be linked (there is no OnCodePair, the code expired or the persisted
+// refresh token was revoked), and ProvisionFailed with a *ProvisionError if
+// any other step fails. The downchannel is closed if Run fails.
+func (p *Provisioner) Run(ctx context.Context) (state ProvisionState, err error) {
+	defer func() {
+		if err != nil {
+			p.closeDownchannel()
+		}
+	}()
+	if err := p.authorize(ctx); err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			return NeedsUserAuth, err
+		}
+		return ProvisionFailed, &ProvisionError{Step: ProvisionAuthorize, Err: err}
+	}
+	for step := ProvisionPublishCapabilities; step <= ProvisionSettingsUpdated; step++ {
+		if step.once() {
+			done, err := p.completed(step)
+			if err != nil {
+				return ProvisionFailed, &ProvisionError{Step: step, Err: err}
+			}
+			if done {
+				continue
+			}
+		}
+		if !p.needed(step) {
+			continue
+		}
+		if p.OnStep != nil {
+			p.OnStep(step)
+		}
+		err := p.run(ctx, step)
+		if err == nil && step.once() {
+			var fingerprint string
+			if fingerprint, err = p.fingerprint(step); err == nil {
+				err = p.Store.Put(provisionStoreNamespace, step.String(), []byte(fingerprint))
+			}
+		}
+		if err != nil {
+			return ProvisionFailed, &ProvisionError{Step: step, Err: err}
+		}
+	}
+	return Provisioned, nil
+}
+
+// Provisioned returns whether every step of the provisioning has completed,
+// so that the device only needs its access token refreshed and a downchannel
+// to work.
+func (p *Provisioner) Provisioned() (bool, error) {
+	if _, err := p.Store.Get(provisionStoreNamespace, provisionRefreshTokenKey); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	for step := ProvisionPublishCapabilities; step <= ProvisionSettingsUpdated; step++ {
+		if !step.once() || !p.needed(step) {
+			continue
+		}
+		if done, err := p.completed(step); !done || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Reset forgets the refresh token and the completed steps (e.g., for a
+// factory reset), so that the next Run starts over.
+func (p *Provisioner) Reset() error {
+	keys := []string{provisionRefreshTokenKey}
+	for step := ProvisionPublishCapabilities; step <= ProvisionSettingsUpdated; step++ {
+		if step.once() {
+			keys = append(keys, step.String())
+		}
+	}
+	for _, key := range keys {
+		if err := p.Store.Delete(provisionStoreNamespace, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Token returns the access token obtained by the last Run, if any.
+func (p *Provisioner) Token() *Token {
+	return p.token
+}
+
+// Downchannel returns the downchannel opened by the last Run, if any. The
+// caller is responsible for closing it, unless it runs the Provisioner again:
+// the next Run closes it before opening another.
+func (p *Provisioner) Downchannel() *Downchannel {
+	return p.downchannel
+}
+
+// Gets an access token with the persisted refresh token, or by linking the
+// device if there is none, and persists the new refresh token.
+func (p *Provisioner) authorize(ctx context.Context) error {
+	data, err := p.Store.Get(provisionStoreNamespace, provisionRefreshTokenKey)
+	var token *Token
+	switch {
+	case err == nil:
+		if p.OnStep != nil {
+			p.OnStep(ProvisionAuthorize)
+		}
+		token, err = p.Authorizer.Refresh(ctx, string(data))
+		if errors.Is(err, ErrUnauthorized) {
+			// Linking again is the only way out of a revoked token.
+			if derr := p.Store.Delete(provisionStoreNamespace, provisionRefreshTokenKey); derr != nil {
+				return derr
+			}
+		}
+	case !errors.Is(err, ErrNotFound):
+		return err
+	case p.OnCodePair == nil:
+		return withKind(ErrUnauthorized, errors.New("avs: the device isn't linked to an account"))
+	default:
+		if p.OnStep != nil {
+			p.OnStep(ProvisionAuthorize)
+		}
+		token, err = p.link(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if token.RefreshToken != "" {
+		if err := p.Store.Put(provisionStoreNamespace, provisionRefreshTokenKey, []byte(token.RefreshToken)); err != nil {
+			return err
+		}
+	}
+	p.token = token
+	return nil
+}
+
+// Links the device with a new code.
+func (p *Provisioner) link(ctx context.Context) (*Token, error) {
+	pair, err := p.Authorizer.RequestCodePair(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.OnCodePair(pair)
+	return p.Authorizer.WaitForToken(ctx, pair)
+}
+
+// Returns whether the step was recorded as completed by a previous run, with
+// the same data.
+func (p *Provisioner) completed(step ProvisionStep) (bool, error) {
+	data, err := p.Store.Get(provisionStoreNamespace, step.String())
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	fingerprint, err := p.fingerprint(step)
+	return string(data) == fingerprint, err
+}
+
+// Returns the hash of the data that the step sends.
+func (p *Provisioner) fingerprint(step ProvisionStep) (string, error) {
+	var data []byte
+	switch step {
+	case ProvisionPublishCapabilities:
+		var err error
+		if data, err = json.Marshal(p.Profile); err != nil {
+			return "", err
+		}
+	case ProvisionSoftwareInfo:
+		data = []byte(p.FirmwareVersion)
+	case ProvisionSettingsUpdated:
+		data = []byte(p.Locale)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Closes the downchannel opened by a previous step or run, if any.
+func (p *Provisioner) closeDownchannel() {
+	if p.downchannel != nil {
+		p.downchannel.Close()
+		p.downchannel = nil
+	}
+}
+
+// Returns whether the step applies to the device.
+func (p *Provisioner) needed(step ProvisionStep) bool {
+	switch step {
+	case ProvisionSoftwareInfo:
+		return p.FirmwareVersion != ""
+	case ProvisionSettingsUpdated:
+		return p.Locale != ""
+	}
+	return true
+}
+
+func (p *Provisioner) run(ctx context.Context, step ProvisionStep) error {
+	accessToken := p.token.AccessToken
+	switch step {
+	case ProvisionPublishCapabilities:
+		return p.Client.PublishCapabilities(accessToken, p.Profile)
+	case ProvisionConnect:
+		p.closeDownchannel()
+		d, err := p.Client.OpenDownchannel(accessToken)
+		if err != nil {
+			return err
+		}
+		p.downchannel = d
+		return nil
+	case ProvisionSynchronizeState:
+		return p.send(ctx, NewSynchronizeStateRequest(accessToken, RandomUUIDString(), p.Contexts))
+	case ProvisionSoftwareInfo:
+		request := NewRequest(accessToken)
+		request.Event = NewSoftwareInfo(RandomUUIDString(), p.FirmwareVersion)
+		return p.send(ctx, request)
+	case ProvisionSettingsUpdated:
+		request := NewRequest(accessToken)
+		request.Event = NewLocaleSettingsUpdated(RandomUUIDString(), p.Locale)
+		return p.send(ctx, request)
+	}
+	return fmt.Errorf("avs: unknown provisioning step %s", step)
+}
+
+func (p *Provisioner) send(ctx context.Context, request *Request) error {
+	_, err := p.Client.DoContext(ctx, request)
+	return err
+}
@@ -0,0 +1,158 @@
+package avs_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+	"github.com/fika-io/go-avs/avstest"
+)
+
+func TestProvisioner(t *testing.T) {
+	var codePairs int32
+	revoked := false
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == avs.CodePairPath:
+			atomic.AddInt32(&codePairs, 1)
+			w.Write([]byte(`{"user_code":"ABC123","device_code":"dev","verification_uri":"https://amazon.com/us/code","expires_in":600,"interval":5}`))
+		case r.Form.Get("grant_type") == "device_code" || r.Form.Get("refresh_token") == "refresh" && !revoked:
+			w.Write([]byte(`{"access_token":"access","refresh_token":"refresh","expires_in":3600}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant","error_description":"bad token"}`))
+		}
+	}))
+	defer auth.Close()
+	publishStatus := http.StatusInternalServerError
+	capabilities := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(publishStatus)
+	}))
+	defer capabilities.Close()
+	server := avstest.NewServer()
+	defer server.Close()
+
+	client := &avs.Client{EndpointURL: server.URL, CapabilitiesURL: capabilities.URL}
+	store, _ := avs.NewFileStore(t.TempDir(), nil)
+	authorizer := &avs.CBLAuthorizer{ClientId: "client", ProductId: "product", DeviceSerialNumber: "1", AuthURL: auth.URL}
+	p := avs.NewProvisioner(client, authorizer, store, avs.NewDeviceProfile(avs.NewCapability("SpeechSynthesizer", 1, 3)))
+	p.FirmwareVersion = "42"
+	p.Locale = avs.SettingLocaleUS
+	var steps []avs.ProvisionStep
+	p.OnStep = func(step avs.ProvisionStep) {
+		steps = append(steps, step)
+	}
+	ctx := context.Background()
+
+	// Without a way to show the code, the device can't be linked.
+	if state, err := p.Run(ctx); state != avs.NeedsUserAuth || !errors.Is(err, avs.ErrUnauthorized) {
+		t.Fatalf("got %s, %v; want NeedsUserAuth", state, err)
+	}
+	p.OnCodePair = func(pair *avs.CodePair) {
+		if pair.UserCode != "ABC123" {
+			t.Errorf("got code %q", pair.UserCode)
+		}
+	}
+
+	// The first run stops at the capabilities, after linking the device.
+	state, err := p.Run(ctx)
+	var perr *avs.ProvisionError
+	if state != avs.ProvisionFailed || !errors.As(err, &perr) || perr.Step != avs.ProvisionPublishCapabilities {
+		t.Fatalf("got %s, %v; want a PublishCapabilities failure", state, err)
+	}
+
+	// The next run continues with the persisted refresh token.
+	publishStatus = http.StatusNoContent
+	steps = nil
+	if state, err := p.Run(ctx); state != avs.Provisioned || err != nil {
+		t.Fatalf("got %s, %v; want Provisioned", state, err)
+	}
+	want := []avs.ProvisionStep{
+		avs.ProvisionAuthorize,
+		avs.ProvisionPublishCapabilities,
+		avs.ProvisionConnect,
+		avs.ProvisionSynchronizeState,
+		avs.ProvisionSoftwareInfo,
+		avs.ProvisionSettingsUpdated,
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("got steps %v; want %v", steps, want)
+	}
+	if n := atomic.LoadInt32(&codePairs); n != 1 {
+		t.Errorf("got %d code pairs; want 1", n)
+	}
+	if p.Token().AccessToken != "access" {
+		t.Errorf("got token %+v", p.Token())
+	}
+	var events []avs.MessageType
+	for _, r := range server.Requests() {
+		events = append(events, r.Event.GetMessage().Type())
+	}
+	if want := []avs.MessageType{avs.TypeSynchronizeState, avs.TypeSoftwareInfo, avs.TypeSettingsUpdated}; !reflect.DeepEqual(events, want) {
+		t.Errorf("got events %v; want %v", events, want)
+	}
+	if ok, err := p.Provisioned(); !ok || err != nil {
+		t.Errorf("got %v, %v; want provisioned", ok, err)
+	}
+
+	// Once provisioned, a run only connects.
+	p.Downchannel().Close()
+	steps = nil
+	if state, err := p.Run(ctx); state != avs.Provisioned || err != nil {
+		t.Fatalf("got %s, %v; want Provisioned", state, err)
+	}
+	want = []avs.ProvisionStep{avs.ProvisionAuthorize, avs.ProvisionConnect, avs.ProvisionSynchronizeState}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("got steps %v; want %v", steps, want)
+	}
+
+	// A new firmware version is reported, and the previous downchannel is
+	// closed.
+	previous := p.Downchannel()
+	p.FirmwareVersion = "43"
+	steps = nil
+	if state, err := p.Run(ctx); state != avs.Provisioned || err != nil {
+		t.Fatalf("got %s, %v; want Provisioned", state, err)
+	}
+	want = []avs.ProvisionStep{avs.ProvisionAuthorize, avs.ProvisionConnect, avs.ProvisionSynchronizeState, avs.ProvisionSoftwareInfo}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("got steps %v; want %v", steps, want)
+	}
+	select {
+	case <-drain(previous.Directives):
+	case <-time.After(5 * time.Second):
+		t.Error("the downchannel of the previous run is still open")
+	}
+
+	// A revoked refresh token must be replaced by linking again.
+	revoked = true
+	p.OnCodePair = nil
+	if state, err := p.Run(ctx); state != avs.NeedsUserAuth || !errors.Is(err, avs.ErrUnauthorized) {
+		t.Errorf("got %s, %v; want NeedsUserAuth", state, err)
+	}
+	if ok, _ := p.Provisioned(); ok {
+		t.Error("still provisioned after the refresh token was revoked")
+	}
+	if p.Downchannel() != nil {
+		t.Error("a failed run left the downchannel open")
+	}
+}
+
+// Returns a channel that's closed once the directives are.
+func drain(directives <-chan *avs.Message) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for range directives {
+		}
+		close(done)
+	}()
+	return done
+}
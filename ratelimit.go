@@ -0,0 +1,179 @@
+package avs
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The delay applied when AVS throttles a client without specifying a
+// Retry-After header.
+const defaultThrottleDelay = 5 * time.Second
+
+// RateLimiter paces the events sent to AVS using a token bucket. It also
+// backs off automatically when AVS responds with a THROTTLING_EXCEPTION or
+// an HTTP 429 status.
+//
+// User initiated events (i.e., Recognize) are never delayed by the limiter.
+type RateLimiter struct {
+	// Clock, if set, replaces the system clock.
+	Clock Clock
+
+	mu             sync.Mutex
+	rate           float64
+	burst          int
+	tokens         float64
+	last           time.Time
+	throttledUntil time.Time
+}
+
+// RateLimiterState is a snapshot of the state of a RateLimiter.
+type RateLimiterState struct {
+	// The number of events per second allowed by the limiter.
+	Rate float64
+	// The maximum number of events that may be sent in a burst.
+	Burst int
+	// The number of events that may currently be sent without waiting.
+	Tokens float64
+	// Whether AVS has asked the client to slow down.
+	Throttled bool
+	// When the client may resume sending events, if throttled.
+	ThrottledUntil time.Time
+}
+
+// NewRateLimiter returns a RateLimiter that allows eventsPerSecond events on
+// average, with bursts of up to burst events.
+func NewRateLimiter(eventsPerSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   eventsPerSecond,
+		burst:  burst,
+		tokens: float64(burst),
+	}
+}
+
+// State returns the current state of the limiter.
+func (l *RateLimiter) State() RateLimiterState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := clockOrDefault(l.Clock).Now()
+	l.refill(now)
+	return RateLimiterState{
+		Rate:           l.rate,
+		Burst:          l.burst,
+		Tokens:         l.tokens,
+		Throttled:      now.Before(l.throttledUntil),
+		ThrottledUntil: l.throttledUntil,
+	}
+}
+
+// Throttle delays all events subject to the limiter for the duration d.
+func (l *RateLimiter) Throttle(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := clockOrDefault(l.Clock).Now().Add(d); until.After(l.throttledUntil) {
+		l.throttledUntil = until
+	}
+}
+
+// Wait blocks until an event may be sent.
+func (l *RateLimiter) Wait() {
+	l.WaitContext(context.Background())
+}
+
+// WaitContext blocks until an event may be sent, or until the context is
+// done, in which case it returns the context's error without taking a token.
+func (l *RateLimiter) WaitContext(ctx context.Context) error {
+	for {
+		d := l.reserve()
+		if d <= 0 {
+			return nil
+		}
+		if err := sleepContext(ctx, l.Clock, d); err != nil {
+			return err
+		}
+	}
+}
+
+// Attempts to take a token from the bucket. Returns the duration to wait
+// before trying again, or zero if a token was taken.
+func (l *RateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := clockOrDefault(l.Clock).Now()
+	if now.Before(l.throttledUntil) {
+		return l.throttledUntil.Sub(now)
+	}
+	l.refill(now)
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	if l.rate <= 0 {
+		// No refill will ever happen, so keep polling slowly.
+		return time.Second
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+func (l *RateLimiter) refill(now time.Time) {
+	if l.last.IsZero() {
+		// The bucket starts out full.
+		l.last = now
+	}
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+	if elapsed <= 0 {
+		return
+	}
+	l.tokens += elapsed * l.rate
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+}
+
+// Returns whether the event should bypass the rate limiter.
+func isUserInitiated(event TypedMessage) bool {
+	if event == nil {
+		return false
+	}
+	return event.GetMessage().String() == "SpeechRecognizer.Recognize"
+}
+
+// Returns whether the event is sent right before a Recognize event, so that
+// delaying it would delay the interaction too.
+func precedesRecognize(event TypedMessage) bool {
+	return event != nil && event.GetMessage().Type() == TypeReportEchoSpatialPerceptionData
+}
+
+// Returns whether the response and error indicates that AVS is throttling
+// the client, together with the duration to wait before trying again.
+func throttleDelay(resp *http.Response, err error) (time.Duration, bool) {
+	exception, _ := err.(*Exception)
+	if resp.StatusCode != 429 && (exception == nil || exception.Payload.Code != ExceptionCodeThrottling) {
+		return 0, false
+	}
+	return retryAfter(resp.Header.Get("Retry-After"), defaultThrottleDelay), true
+}
+
+// Parses a Retry-After header value, which is either a number of seconds or
+// an HTTP date.
+func retryAfter(value string, fallback time.Duration) time.Duration {
+	if value == "" {
+		return fallback
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return fallback
+}
@@ -0,0 +1,111 @@
+package avs_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+	"github.com/fika-io/go-avs/avstest"
+)
+
+func TestRateLimiter(t *testing.T) {
+	clock := avstest.NewFakeClock(time.Now())
+	l := avs.NewRateLimiter(1, 2)
+	l.Clock = clock
+	// The burst can be sent right away.
+	l.Wait()
+	l.Wait()
+	if state := l.State(); state.Tokens != 0 {
+		t.Errorf("got %v tokens after burst; want 0", state.Tokens)
+	}
+	done := make(chan bool)
+	go func() {
+		l.Wait()
+		close(done)
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	<-done
+
+	l.Throttle(30 * time.Second)
+	if state := l.State(); !state.Throttled {
+		t.Errorf("expected limiter to be throttled")
+	}
+	clock.Advance(30 * time.Second)
+	if state := l.State(); state.Throttled {
+		t.Errorf("expected limiter to no longer be throttled")
+	}
+}
+
+func TestRateLimiterWaitContext(t *testing.T) {
+	l := avs.NewRateLimiter(0, 1)
+	l.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.WaitContext(ctx); err != context.Canceled {
+		t.Errorf("got %v; want context.Canceled", err)
+	}
+
+	// DoContext gives up waiting when the context is done.
+	client := &avs.Client{EndpointURL: "https://avs.invalid", RateLimiter: l}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	request := avs.NewRequest("token")
+	request.Event = avs.NewSynchronizeState("m1")
+	if _, err := client.DoContext(ctx, request); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v; want context.DeadlineExceeded", err)
+	}
+}
+
+// A 429 response throttles the limiter for the Retry-After delay, which
+// delays the next events but not Recognize.
+func TestClientThrottle(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	clock := avstest.NewFakeClock(time.Now())
+	l := avs.NewRateLimiter(10, 10)
+	l.Clock = clock
+	client := &avs.Client{EndpointURL: server.URL, RateLimiter: l}
+	if _, err := client.Do(avs.NewSynchronizeStateRequest("token", "m1", nil)); !errors.Is(err, avs.ErrThrottled) {
+		t.Fatalf("got %v; want ErrThrottled", err)
+	}
+	state := l.State()
+	if !state.Throttled || !state.ThrottledUntil.Equal(clock.Now().Add(30*time.Second)) {
+		t.Fatalf("got state %+v; want throttled for 30s", state)
+	}
+
+	request := avs.NewRequest("token")
+	request.Event = avs.NewRecognize("m2", "d1")
+	request.Audio = strings.NewReader("audio data")
+	if _, err := client.Do(request); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := client.Do(avs.NewSynchronizeStateRequest("token", "m3", nil))
+		done <- err
+	}()
+	clock.BlockUntil(1)
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("got %d requests while throttled; want 2", n)
+	}
+	clock.Advance(30 * time.Second)
+	if err := <-done; err != nil || atomic.LoadInt32(&requests) != 3 {
+		t.Errorf("got %v after %d requests", err, requests)
+	}
+}
@@ -0,0 +1,176 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// TokenSource provides the access tokens of a Client (see
+// Client.SetTokenSource).
+type TokenSource interface {
+	// Token returns the current access token.
+	Token(ctx context.Context) (string, error)
+	// Refresh returns a new access token to replace one that AVS rejected as
+	// expired. An ErrUnauthorized error (e.g., the invalid_grant AuthError
+	// of a revoked refresh token) means that the device can't get one.
+	Refresh(ctx context.Context, expired string) (string, error)
+}
+
+// ErrDeauthorized is returned by the requests of a Client that AVS rejected
+// for good, until a new TokenSource is installed. It is an ErrUnauthorized
+// error.
+var ErrDeauthorized = withKind(ErrUnauthorized, errors.New("avs: the device is no longer authorized"))
+
+// ForbiddenReason is why AVS rejected a request with HTTP 403.
+type ForbiddenReason int
+
+// Possible values for ForbiddenReason.
+const (
+	// The access token expired or isn't valid; a refreshed token should be
+	// accepted.
+	ForbiddenExpiredToken ForbiddenReason = iota + 1
+	// The device was deregistered or the user revoked its authorization; it
+	// has to be set up again.
+	ForbiddenRevoked
+)
+
+// String returns the name of the reason.
+func (r ForbiddenReason) String() string {
+	switch r {
+	case ForbiddenExpiredToken:
+		return "ExpiredToken"
+	case ForbiddenRevoked:
+		return "Revoked"
+	}
+	return "Unknown"
+}
+
+// The words of the descriptions of the exceptions of devices that can't be
+// authorized anymore, in lower case. AVS doesn't document these descriptions,
+// and the words haven't been checked against its actual responses.
+var revokedDescriptions = []string{"revoked", "deregistered", "disabled", "not registered"}
+
+// ClassifyForbidden returns why AVS rejected a request with HTTP 403, from the
+// description of its exception, and false if the error isn't an HTTP 403
+// response. Responses without an exception are taken for expired tokens.
+//
+// Both reasons come with the same UNAUTHORIZED_REQUEST_EXCEPTION code, so the
+// classification is a guess from words of the description (e.g.,
+// "revoked" or "deregistered"). It hasn't been verified against captured
+// responses of AVS: a revocation described otherwise is taken for an expired
+// token, which is refreshed and rejected again.
+func ClassifyForbidden(err error) (ForbiddenReason, bool) {
+	var exception *Exception
+	if errors.As(err, &exception) && exception.StatusCode == 403 {
+		description := strings.ToLower(exception.Payload.Description)
+		for _, word := range revokedDescriptions {
+			if strings.Contains(description, word) {
+				return ForbiddenRevoked, true
+			}
+		}
+		return ForbiddenExpiredToken, true
+	}
+	var requestError *RequestError
+	if errors.As(err, &requestError) && requestError.StatusCode == 403 {
+		return ForbiddenExpiredToken, true
+	}
+	return 0, false
+}
+
+// The authorization state of a Client.
+type clientAuth struct {
+	mu     sync.Mutex
+	source TokenSource
+	// Incremented by every SetTokenSource, so that the rejection of a
+	// request sent with a previous source is ignored.
+	generation int
+	// Set once AVS rejected the device for good.
+	deauthorized bool
+}
+
+// SetTokenSource installs the source of the access tokens of the client, and
+// makes an unauthorized client authorized again. Requests without an
+// AccessToken are sent with the current token of the source, and AVS
+// rejecting a request with HTTP 403 is handled according to its
+// ClassifyForbidden reason:
+//
+//	ForbiddenExpiredToken: the token is refreshed with the source and the
+//	request is sent again once.
+//	ForbiddenRevoked: the client becomes unauthorized. OnDeauthorized is
+//	called and the requests fail with ErrDeauthorized until a new source is
+//	installed.
+//
+// A token that can't be refreshed because of an ErrUnauthorized error makes
+// the client unauthorized too. The RefreshToken of the RetryPolicy isn't
+// needed with a source. A nil source turns this off.
+func (c *Client) SetTokenSource(source TokenSource) {
+	c.auth.mu.Lock()
+	defer c.auth.mu.Unlock()
+	c.auth.source = source
+	c.auth.generation++
+	c.auth.deauthorized = false
+}
+
+// Authorized reports whether the client hasn't been rejected for good by AVS
+// since its TokenSource was installed.
+func (c *Client) Authorized() bool {
+	c.auth.mu.Lock()
+	defer c.auth.mu.Unlock()
+	return !c.auth.deauthorized
+}
+
+// Returns the installed TokenSource and its generation, or ErrDeauthorized
+// if the client is unauthorized.
+func (c *Client) tokenSource() (TokenSource, int, error) {
+	c.auth.mu.Lock()
+	defer c.auth.mu.Unlock()
+	if c.auth.deauthorized {
+		return nil, 0, ErrDeauthorized
+	}
+	return c.auth.source, c.auth.generation, nil
+}
+
+// Handles the error of a request sent with a token of the source. It returns
+// a refreshed token and true if the request should be sent again.
+func (c *Client) reauthorize(ctx context.Context, source TokenSource, generation int, accessToken string, err error) (string, bool) {
+	reason, ok := ClassifyForbidden(err)
+	if !ok {
+		return "", false
+	}
+	if reason == ForbiddenExpiredToken {
+		var token string
+		rerr := retryBackoff(ctx, c.Backoff.TokenRefresh, c.Clock, func(ctx context.Context) error {
+			var err error
+			token, err = source.Refresh(ctx, accessToken)
+			if errors.Is(err, ErrUnauthorized) {
+				return Permanent(err)
+			}
+			return err
+		})
+		if rerr == nil {
+			return token, true
+		}
+		if !errors.Is(rerr, ErrUnauthorized) {
+			return "", false
+		}
+	}
+	c.deauthorize(generation, err)
+	return "", false
+}
+
+// Makes the client unauthorized, unless another source was installed since
+// the request was sent.
+func (c *Client) deauthorize(generation int, err error) {
+	c.auth.mu.Lock()
+	if c.auth.generation != generation || c.auth.deauthorized {
+		c.auth.mu.Unlock()
+		return
+	}
+	c.auth.deauthorized = true
+	c.auth.mu.Unlock()
+	if c.OnDeauthorized != nil {
+		c.OnDeauthorized(err)
+	}
+}
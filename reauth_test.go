@@ -0,0 +1,237 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A TokenSource that hands out token-1, token-2 and so on.
+type countingTokenSource struct {
+	mu         sync.Mutex
+	tokens     int
+	refreshErr error
+}
+
+func (s *countingTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokens == 0 {
+		s.tokens++
+	}
+	return tokenName(s.tokens), nil
+}
+
+func (s *countingTokenSource) Refresh(ctx context.Context, expired string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refreshErr != nil {
+		return "", s.refreshErr
+	}
+	s.tokens++
+	return tokenName(s.tokens), nil
+}
+
+func tokenName(n int) string {
+	return "token-" + string(rune('0'+n))
+}
+
+// Returns a server that rejects the requests without the accepted token with
+// HTTP 403 and the body in testdata/forbidden, and the authorizations of the
+// requests it got.
+func newForbiddenServer(t *testing.T, body, accepted string) (*httptest.Server, func() []string) {
+	data, err := ioutil.ReadFile("testdata/forbidden/" + body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		mu.Unlock()
+		ioutil.ReadAll(r.Body)
+		if r.Header.Get("Authorization") == "Bearer "+accepted {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(data)
+	}))
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), authorizations...)
+	}
+}
+
+func TestClassifyForbidden(t *testing.T) {
+	for body, want := range map[string]ForbiddenReason{
+		"expired_token.json": ForbiddenExpiredToken,
+		"revoked.json":       ForbiddenRevoked,
+	} {
+		server, _ := newForbiddenServer(t, body, "")
+		client := &Client{EndpointURL: server.URL}
+		request := NewRequest("token")
+		request.Event = NewSynchronizeState("m1")
+		_, err := client.Do(request)
+		server.Close()
+		if reason, ok := ClassifyForbidden(err); !ok || reason != want {
+			t.Errorf("%s: got %s, %t; want %s", body, reason, ok, want)
+		}
+		if !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("%s: got %v; want an ErrUnauthorized error", body, err)
+		}
+	}
+	if _, ok := ClassifyForbidden(&RequestError{StatusCode: 401}); ok {
+		t.Error("classified HTTP 401")
+	}
+	if reason, ok := ClassifyForbidden(&RequestError{StatusCode: 403}); !ok || reason != ForbiddenExpiredToken {
+		t.Errorf("got %s, %t for HTTP 403 without an exception", reason, ok)
+	}
+}
+
+func TestTokenSourceRefresh(t *testing.T) {
+	server, authorizations := newForbiddenServer(t, "expired_token.json", "token-2")
+	defer server.Close()
+	source := new(countingTokenSource)
+	client := &Client{EndpointURL: server.URL, OnDeauthorized: func(err error) {
+		t.Errorf("deauthorized by %v", err)
+	}}
+	client.SetTokenSource(source)
+	request := NewRequest("")
+	request.Event = NewSynchronizeState("m1")
+	if _, err := client.Do(request); err != nil {
+		t.Fatal(err)
+	}
+	if got := authorizations(); len(got) != 2 || got[0] != "Bearer token-1" || got[1] != "Bearer token-2" {
+		t.Errorf("got authorizations %q; want one retry with the refreshed token", got)
+	}
+
+	// The refreshed token is only tried once.
+	client.SetTokenSource(&countingTokenSource{tokens: 5})
+	if _, err := client.Do(request); !errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrDeauthorized) {
+		t.Errorf("got %v; want the HTTP 403 error", err)
+	}
+	if got := authorizations(); len(got) != 4 {
+		t.Errorf("got %d requests; want 4", len(got))
+	}
+	if !client.Authorized() {
+		t.Error("an expired token deauthorized the client")
+	}
+}
+
+func TestTokenSourceRevoked(t *testing.T) {
+	server, authorizations := newForbiddenServer(t, "revoked.json", "")
+	defer server.Close()
+	var deauthorized []error
+	client := &Client{EndpointURL: server.URL, OnDeauthorized: func(err error) {
+		deauthorized = append(deauthorized, err)
+	}}
+	client.SetTokenSource(new(countingTokenSource))
+	request := NewRequest("")
+	request.Event = NewSynchronizeState("m1")
+	if _, err := client.Do(request); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("got %v; want the HTTP 403 error", err)
+	}
+	if client.Authorized() || len(deauthorized) != 1 {
+		t.Fatalf("got authorized %t and %d call(s) of OnDeauthorized", client.Authorized(), len(deauthorized))
+	}
+	if reason, _ := ClassifyForbidden(deauthorized[0]); reason != ForbiddenRevoked {
+		t.Errorf("OnDeauthorized got %v", deauthorized[0])
+	}
+
+	// Nothing is sent until a new source is installed.
+	if _, err := client.Do(request); err != ErrDeauthorized || !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("got %v; want ErrDeauthorized", err)
+	}
+	if got := authorizations(); len(got) != 1 {
+		t.Errorf("got %d requests; want no retry and nothing after the rejection", len(got))
+	}
+	client.SetTokenSource(new(countingTokenSource))
+	if !client.Authorized() {
+		t.Error("a new source didn't authorize the client")
+	}
+	client.Do(request)
+	if got := authorizations(); len(got) != 2 {
+		t.Errorf("got %d requests; want one with the new source", len(got))
+	}
+}
+
+// A request that can't be sent again still deauthorizes the client.
+func TestTokenSourceRevokedOnce(t *testing.T) {
+	server, authorizations := newForbiddenServer(t, "revoked.json", "")
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL}
+	client.SetTokenSource(new(countingTokenSource))
+	request := NewRequest("")
+	request.Event = NewSynchronizeState("m1")
+	// The audio can't be rewound.
+	request.Audio = ioutil.NopCloser(strings.NewReader("audio"))
+	if _, err := client.Do(request); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("got %v; want the HTTP 403 error", err)
+	}
+	if client.Authorized() {
+		t.Error("a revoked device stayed authorized")
+	}
+	if got := authorizations(); len(got) != 1 {
+		t.Errorf("got %d requests; want 1", len(got))
+	}
+}
+
+func TestTokenSourceRefreshRejected(t *testing.T) {
+	server, _ := newForbiddenServer(t, "expired_token.json", "")
+	defer server.Close()
+	calls := 0
+	client := &Client{EndpointURL: server.URL, OnDeauthorized: func(err error) { calls++ }}
+
+	// Failing to reach the authorization server isn't a rejection.
+	client.SetTokenSource(&countingTokenSource{refreshErr: errors.New("no route to host")})
+	request := NewRequest("")
+	request.Event = NewSynchronizeState("m1")
+	client.Do(request)
+	if !client.Authorized() || calls != 0 {
+		t.Errorf("got authorized %t and %d call(s) of OnDeauthorized", client.Authorized(), calls)
+	}
+
+	client.SetTokenSource(&countingTokenSource{refreshErr: &AuthError{Code: "invalid_grant", StatusCode: 400}})
+	client.Do(request)
+	if client.Authorized() || calls != 1 {
+		t.Errorf("got authorized %t and %d call(s) of OnDeauthorized; want a revoked refresh token to deauthorize", client.Authorized(), calls)
+	}
+}
+
+func TestTokenSourceRefreshBackoff(t *testing.T) {
+	server, authorizations := newForbiddenServer(t, "expired_token.json", "token-2")
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL, Backoff: BackoffPolicies{TokenRefresh: &BackoffPolicy{MaxAttempts: 3}}}
+	client.SetTokenSource(&flakyTokenSource{countingTokenSource: new(countingTokenSource), failures: 2})
+	request := NewRequest("")
+	request.Event = NewSynchronizeState("m1")
+	if _, err := client.Do(request); err != nil {
+		t.Fatalf("got %v; want the refresh to be retried", err)
+	}
+	if got := authorizations(); len(got) != 2 || got[1] != "Bearer token-2" {
+		t.Errorf("got authorizations %q", got)
+	}
+}
+
+// A TokenSource whose first refreshes fail.
+type flakyTokenSource struct {
+	*countingTokenSource
+	failures int
+}
+
+func (s *flakyTokenSource) Refresh(ctx context.Context, expired string) (string, error) {
+	if s.failures > 0 {
+		s.failures--
+		return "", errors.New("no route to host")
+	}
+	return s.countingTokenSource.Refresh(ctx, expired)
+}
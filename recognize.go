@@ -0,0 +1,143 @@
+package avs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// InitiatorType specifies how a Recognize interaction was started.
+type InitiatorType string
+
+// Possible values for InitiatorType.
+const (
+	InitiatorTypePressAndHold = InitiatorType("PRESS_AND_HOLD")
+	InitiatorTypeTap          = InitiatorType("TAP")
+	InitiatorTypeWakeWord     = InitiatorType("WAKEWORD")
+)
+
+// Initiator describes how a Recognize interaction was started. It's part of
+// version 2 of the Recognize event.
+type Initiator struct {
+	Type    InitiatorType    `json:"type"`
+	Payload InitiatorPayload `json:"payload"`
+}
+
+// InitiatorPayload holds the details of an Initiator.
+type InitiatorPayload struct {
+	// The position of the wake word in the audio, for WAKEWORD initiators.
+	WakeWordIndices *WakeWordIndices `json:"wakeWordIndices,omitempty"`
+	// The token of the ExpectSpeech directive that prompted the interaction.
+	Token string `json:"token,omitempty"`
+}
+
+// WakeWordIndices is the position of the wake word in the audio stream.
+type WakeWordIndices struct {
+	StartIndexInSamples int64 `json:"startIndexInSamples"`
+	EndIndexInSamples   int64 `json:"endIndexInSamples"`
+}
+
+// ErrLossyConversion is returned when converting a message to another API
+// version would drop information.
+var ErrLossyConversion = errors.New("avs: conversion would drop information")
+
+// APIProfile specifies the version of the events that a Client sends.
+type APIProfile string
+
+// Possible values for APIProfile.
+const (
+	// Recognize events are sent without an initiator.
+	APIProfileV1 = APIProfile("v1")
+	// Recognize events are sent with an initiator. Events without one get an
+	// initiator based on their ASR profile.
+	APIProfileV2 = APIProfile("v2")
+)
+
+// ToV2 returns a copy of the Recognize event with the provided initiator. It
+// fails if the event already has a different initiator or if a WAKEWORD
+// initiator has no wake word indices.
+func (m *Recognize) ToV2(initiator Initiator) (*Recognize, error) {
+	if initiator.Type == InitiatorTypeWakeWord && initiator.Payload.WakeWordIndices == nil {
+		return nil, fmt.Errorf("avs: %s initiator without wake word indices", initiator.Type)
+	}
+	if m.Payload.Initiator != nil && !m.Payload.Initiator.equal(initiator) {
+		return nil, fmt.Errorf("%w: event already has a %s initiator", ErrLossyConversion, m.Payload.Initiator.Type)
+	}
+	v2 := m.copy()
+	v2.Payload.Initiator = &initiator
+	if i := initiator.Payload.WakeWordIndices; i != nil {
+		indices := *i
+		v2.Payload.Initiator.Payload.WakeWordIndices = &indices
+	}
+	return v2, nil
+}
+
+// DowngradeToV1 returns a copy of the Recognize event without an initiator.
+// It returns ErrLossyConversion if the initiator is for a wake word or
+// carries an ExpectSpeech token, which version 1 can't express.
+func (m *Recognize) DowngradeToV1() (*Recognize, error) {
+	if i := m.Payload.Initiator; i != nil {
+		if i.Type == InitiatorTypeWakeWord || i.Payload.WakeWordIndices != nil {
+			return nil, fmt.Errorf("%w: wake word indices", ErrLossyConversion)
+		}
+		if i.Payload.Token != "" {
+			return nil, fmt.Errorf("%w: initiator token", ErrLossyConversion)
+		}
+	}
+	v1 := m.copy()
+	v1.Payload.Initiator = nil
+	return v1, nil
+}
+
+// Returns a copy of the event with its own header.
+func (m *Recognize) copy() *Recognize {
+	c := new(Recognize)
+	c.Message = m.Message.Clone()
+	c.Payload = m.Payload
+	return c
+}
+
+func (i *Initiator) equal(other Initiator) bool {
+	if i.Type != other.Type || i.Payload.Token != other.Payload.Token {
+		return false
+	}
+	a, b := i.Payload.WakeWordIndices, other.Payload.WakeWordIndices
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+// Returns the request with its event converted to the profile. The request
+// is returned as is if nothing needs to change.
+func (p APIProfile) apply(request *Request) (*Request, error) {
+	recognize, ok := request.Event.(*Recognize)
+	if !ok {
+		return request, nil
+	}
+	var converted *Recognize
+	var err error
+	switch p {
+	case "":
+		return request, nil
+	case APIProfileV1:
+		if recognize.Payload.Initiator == nil {
+			return request, nil
+		}
+		converted, err = recognize.DowngradeToV1()
+	case APIProfileV2:
+		if recognize.Payload.Initiator != nil {
+			return request, nil
+		}
+		// Version 1 used the ASR profile to tell press and hold apart from tap.
+		initiator := Initiator{Type: InitiatorTypeTap}
+		if recognize.Payload.Profile == RecognizeProfileCloseTalk {
+			initiator.Type = InitiatorTypePressAndHold
+		}
+		converted, err = recognize.ToV2(initiator)
+	default:
+		return nil, fmt.Errorf("avs: unknown API profile %q", string(p))
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := *request
+	r.Event = converted
+	return &r, nil
+}
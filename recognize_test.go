@@ -0,0 +1,76 @@
+package avs
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRecognizeConversion(t *testing.T) {
+	v1 := NewRecognizeWithProfile("m1", "d1", RecognizeProfileFarField)
+	wakeWord := Initiator{
+		Type:    InitiatorTypeWakeWord,
+		Payload: InitiatorPayload{WakeWordIndices: &WakeWordIndices{8000, 16000}},
+	}
+	v2, err := v1.ToV2(wakeWord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1.Payload.Initiator != nil {
+		t.Error("ToV2 modified the original event")
+	}
+	data, _ := json.Marshal(v2)
+	if !strings.Contains(string(data), `"initiator":{"type":"WAKEWORD","payload":{"wakeWordIndices":{"startIndexInSamples":8000,"endIndexInSamples":16000}}}`) {
+		t.Errorf("unexpected v2 event %s", data)
+	}
+	if _, err := v2.DowngradeToV1(); !errors.Is(err, ErrLossyConversion) {
+		t.Errorf("downgrading a wake word event should be lossy, got %v", err)
+	}
+	if _, err := v2.ToV2(Initiator{Type: InitiatorTypeTap}); !errors.Is(err, ErrLossyConversion) {
+		t.Errorf("replacing the initiator should be lossy, got %v", err)
+	}
+	if _, err := v1.ToV2(Initiator{Type: InitiatorTypeWakeWord}); err == nil {
+		t.Error("a wake word initiator without indices should fail")
+	}
+
+	tap, err := v1.ToV2(Initiator{Type: InitiatorTypeTap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := tap.DowngradeToV1()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := json.Marshal(v1)
+	b, _ := json.Marshal(back)
+	if string(a) != string(b) {
+		t.Errorf("round trip changed the event:\n%s\n%s", a, b)
+	}
+}
+
+func TestAPIProfile(t *testing.T) {
+	request := NewRequest("token")
+	request.Event = NewRecognize("m1", "d1")
+	converted, err := APIProfileV2.apply(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initiator := converted.Event.(*Recognize).Payload.Initiator
+	if initiator == nil || initiator.Type != InitiatorTypePressAndHold {
+		t.Errorf("close talk events should be press and hold, got %+v", initiator)
+	}
+	if request.Event.(*Recognize).Payload.Initiator != nil {
+		t.Error("apply modified the original request")
+	}
+	back, err := APIProfileV1.apply(converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Event.(*Recognize).Payload.Initiator != nil {
+		t.Error("v1 events shouldn't have an initiator")
+	}
+	if same, _ := APIProfile("").apply(request); same != request {
+		t.Error("no profile shouldn't convert anything")
+	}
+}
@@ -0,0 +1,211 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// InteractionOutcome is how an interaction of a DialogController ended.
+type InteractionOutcome string
+
+// Possible values for InteractionOutcome.
+const (
+	// The response was played to the end.
+	InteractionCompleted InteractionOutcome = "COMPLETED"
+	// The interaction took longer than the deadline of its context.
+	InteractionTimedOut InteractionOutcome = "TIMED_OUT"
+	// The interaction failed (e.g., AVS couldn't be reached).
+	InteractionErrored InteractionOutcome = "ERRORED"
+	// A new interaction superseded it (see DialogController.Recognize).
+	InteractionBargedIn InteractionOutcome = "BARGED_IN"
+)
+
+// InteractionRecord describes an interaction of a DialogController, for
+// analytics. It doesn't include any audio. Its times are those of the Clock
+// of the Client.
+type InteractionRecord struct {
+	// The dialogRequestId of the Recognize event.
+	DialogRequestId string
+	// When the interaction started, i.e., when the user spoke, and when it
+	// ended.
+	Started, Finished time.Time
+	// When the microphone was drained, which ends the capture. It's zero if
+	// the interaction ended before.
+	CaptureEnded time.Time
+	// When the first directive of the response arrived. It's zero if none
+	// did.
+	Responded time.Time
+	// The types of the directives of the response, in order.
+	Directives []MessageType
+	// The Amazon request ids of the Recognize event and of the events sent
+	// during the interaction, in order, to be joined against the logs of
+	// AVS. Requests that failed before AVS answered have none.
+	RequestIds []string
+	// How the interaction ended, and the error it ended with, if any.
+	Outcome InteractionOutcome
+	Err     error
+}
+
+// CaptureDuration returns how long the capture lasted, or zero if it didn't
+// end.
+func (r *InteractionRecord) CaptureDuration() time.Duration {
+	if r.CaptureEnded.IsZero() {
+		return 0
+	}
+	return r.CaptureEnded.Sub(r.Started)
+}
+
+// Latency returns the time from the end of the capture until the first
+// directive arrived, or zero if either didn't happen.
+func (r *InteractionRecord) Latency() time.Duration {
+	if r.CaptureEnded.IsZero() || r.Responded.IsZero() {
+		return 0
+	}
+	return r.Responded.Sub(r.CaptureEnded)
+}
+
+// Returns the outcome of an interaction that returned err.
+func interactionOutcome(err error) InteractionOutcome {
+	var timeout interface{ Timeout() bool }
+	switch {
+	case err == nil:
+		return InteractionCompleted
+	case errors.Is(err, ErrDialogSuperseded):
+		return InteractionBargedIn
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &timeout) && timeout.Timeout():
+		return InteractionTimedOut
+	}
+	return InteractionErrored
+}
+
+// Builds the record of an interaction. Its methods may be called
+// concurrently, and on a nil recorder, which records nothing.
+type interactionRecorder struct {
+	clock Clock
+
+	mu      sync.Mutex
+	started bool
+	record  InteractionRecord
+}
+
+func newInteractionRecorder(clock Clock, dialogRequestId string) *interactionRecorder {
+	return &interactionRecorder{
+		clock:  clockOrDefault(clock),
+		record: InteractionRecord{DialogRequestId: dialogRequestId},
+	}
+}
+
+// Records the start of the interaction, once no other one is in progress.
+func (r *interactionRecorder) start() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.started = true
+	r.record.Started = r.clock.Now()
+	r.mu.Unlock()
+}
+
+// Returns the microphone, wrapped to record when the capture ends. It's
+// still an io.Seeker if mic is one, so that the request can be retried.
+func (r *interactionRecorder) capture(mic io.Reader) io.Reader {
+	if r == nil {
+		return mic
+	}
+	reader := &captureReader{Reader: mic, r: r}
+	if seeker, ok := mic.(io.Seeker); ok {
+		return &seekingCaptureReader{reader, seeker}
+	}
+	return reader
+}
+
+// Records the request id of the response, or of the error the request
+// failed with.
+func (r *interactionRecorder) request(response *Response, err error) {
+	if r == nil {
+		return
+	}
+	var requestId string
+	var requestErr *RequestError
+	var exception *Exception
+	switch {
+	case response != nil:
+		requestId = response.RequestId
+	case errors.As(err, &requestErr):
+		requestId = requestErr.RequestId
+	case errors.As(err, &exception):
+		requestId = exception.RequestId
+	}
+	if requestId == "" {
+		return
+	}
+	r.mu.Lock()
+	r.record.RequestIds = append(r.record.RequestIds, requestId)
+	r.mu.Unlock()
+}
+
+// Records the arrival of a directive of the response.
+func (r *interactionRecorder) directive(t MessageType) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.record.Responded.IsZero() {
+		r.record.Responded = r.clock.Now()
+	}
+	r.record.Directives = append(r.record.Directives, t)
+}
+
+// Ends the record with the error the interaction returned, and returns it.
+// It returns nil if the interaction didn't start.
+func (r *interactionRecorder) finish(err error) *InteractionRecord {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started {
+		return nil
+	}
+	record := r.record
+	record.Finished = r.clock.Now()
+	record.Outcome = interactionOutcome(err)
+	record.Err = err
+	return &record
+}
+
+// Records the time of the first error (usually io.EOF) of the microphone,
+// which ends the capture.
+type captureReader struct {
+	io.Reader
+	r *interactionRecorder
+}
+
+func (c *captureReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	if err != nil {
+		c.r.mu.Lock()
+		if c.r.record.CaptureEnded.IsZero() {
+			c.r.record.CaptureEnded = c.r.clock.Now()
+		}
+		c.r.mu.Unlock()
+	}
+	return n, err
+}
+
+type seekingCaptureReader struct {
+	*captureReader
+	seeker io.Seeker
+}
+
+// Seek rewinds the audio for a retry, whose upload ends the capture again.
+func (c *seekingCaptureReader) Seek(offset int64, whence int) (int64, error) {
+	c.r.mu.Lock()
+	c.r.record.CaptureEnded = time.Time{}
+	c.r.mu.Unlock()
+	return c.seeker.Seek(offset, whence)
+}
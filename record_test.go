@@ -0,0 +1,124 @@
+package avs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A clock whose time moves a second forward every time it's read.
+type tickingClock struct {
+	realClock
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *tickingClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(time.Second)
+	return c.now
+}
+
+func TestDialogControllerRecords(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		w.Header().Set("x-amzn-requestid", fmt.Sprintf("r%d", requests))
+		mu.Unlock()
+		mr, err := r.MultipartReader()
+		if err != nil {
+			t.Errorf("unexpected request: %v", err)
+			return
+		}
+		p, _ := mr.NextPart()
+		var metadata struct {
+			Event *Message `json:"event"`
+		}
+		json.NewDecoder(p).Decode(&metadata)
+		if metadata.Event.Header["name"] != "Recognize" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		audio, _ := mr.NextPart()
+		io.Copy(ioutil.Discard, audio)
+		w.Header().Set("Content-Type", "multipart/related; boundary=------abcde123; type=application/json")
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "--------abcde123\r\nContent-Type: application/json\r\n\r\n{\"directive\":%s}\r\n", speakDirective)
+		fmt.Fprint(w, "--------abcde123\r\nContent-ID: <abc>\r\nContent-Type: application/octet-stream\r\n\r\nmp3\r\n--------abcde123--\r\n")
+	}))
+	defer server.Close()
+
+	var records []*InteractionRecord
+	c := &DialogController{
+		Client:      &Client{EndpointURL: server.URL, Clock: &tickingClock{now: time.Unix(0, 0)}},
+		AccessToken: "token",
+		Records: func(record *InteractionRecord) {
+			records = append(records, record)
+		},
+	}
+	for _, incremental := range []bool{false, true} {
+		records = nil
+		c.Incremental = incremental
+		if _, err := c.Recognize(context.Background(), ioutil.NopCloser(strings.NewReader("hello"))); err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 {
+			t.Fatalf("got %d records, want 1", len(records))
+		}
+		r := records[0]
+		if r.DialogRequestId == "" || r.Outcome != InteractionCompleted || r.Err != nil {
+			t.Errorf("got record of dialog %q with outcome %s (%v)", r.DialogRequestId, r.Outcome, r.Err)
+		}
+		if want := []MessageType{TypeSpeak}; !reflect.DeepEqual(r.Directives, want) {
+			t.Errorf("got directives %v, want %v", r.Directives, want)
+		}
+		// The Recognize event, SpeechStarted and SpeechFinished.
+		if len(r.RequestIds) != 3 {
+			t.Errorf("got request ids %v", r.RequestIds)
+		}
+		if !r.Started.Before(r.CaptureEnded) || !r.CaptureEnded.Before(r.Responded) || !r.Responded.Before(r.Finished) {
+			t.Errorf("got times %v, %v, %v and %v out of order", r.Started, r.CaptureEnded, r.Responded, r.Finished)
+		}
+		if r.CaptureDuration() <= 0 || r.Latency() <= 0 {
+			t.Errorf("got capture of %s and latency of %s", r.CaptureDuration(), r.Latency())
+		}
+	}
+
+	// Failures are recorded too.
+	records = nil
+	server.Close()
+	if _, err := c.Recognize(context.Background(), ioutil.NopCloser(strings.NewReader("hello"))); err == nil {
+		t.Fatal("Recognize succeeded without a server")
+	}
+	if len(records) != 1 || records[0].Outcome != InteractionErrored || records[0].Err == nil {
+		t.Errorf("got records %+v after a failure", records)
+	}
+}
+
+func TestInteractionOutcome(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want InteractionOutcome
+	}{
+		{nil, InteractionCompleted},
+		{ErrDialogSuperseded, InteractionBargedIn},
+		{fmt.Errorf("upload: %w", context.DeadlineExceeded), InteractionTimedOut},
+		{ErrShutdown, InteractionErrored},
+	} {
+		if got := interactionOutcome(test.err); got != test.want {
+			t.Errorf("got outcome %s for %v, want %s", got, test.err, test.want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package avs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ReplayBuffer holds the directives that a Dispatcher has no handler for yet,
+// so that handlers registered late (e.g., after an asynchronous setup) still
+// get the directives that arrived in the meantime.
+//
+// A held directive is dispatched as soon as a handler for it is registered.
+// Once it has been held for its maximum age, or when it's the oldest and the
+// buffer is full, it's dispatched anyway, so that it's handled like any
+// directive without a handler. The directives of a dialog that arrive after a
+// held one are held behind it, so that a dialog is still handled in order.
+//
+// While a Run is running, the held directives are dispatched by Run with its
+// context, one at a time like the others. Otherwise, they're dispatched on a
+// goroutine of their own with a background context.
+//
+// StopCapture directives, and the types in Exclude, are never held, since
+// they mustn't be handled late.
+type ReplayBuffer struct {
+	// Exclude lists more types that are never held.
+	Exclude []MessageType
+
+	size   int
+	maxAge time.Duration
+
+	mu       sync.Mutex
+	entries  []*replayEntry
+	running  bool // a replay is in progress
+	watching bool // a goroutine is waiting for the oldest entry to expire
+}
+
+// A held directive.
+type replayEntry struct {
+	m      *Message
+	held   time.Time
+	forced bool // evicted to make room
+	busy   bool // being dispatched
+}
+
+// NewReplayBuffer returns a ReplayBuffer that holds up to size directives for
+// up to maxAge each. A zero maxAge holds them until the buffer is full.
+func NewReplayBuffer(size int, maxAge time.Duration) *ReplayBuffer {
+	return &ReplayBuffer{size: size, maxAge: maxAge}
+}
+
+// Len returns the number of directives held.
+func (b *ReplayBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.entries)
+}
+
+func (b *ReplayBuffer) excluded(t MessageType) bool {
+	if t == TypeStopCapture {
+		return true
+	}
+	for _, e := range b.Exclude {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Holds the directive if it has no handler, or if a directive of its dialog
+// is already held. It reports whether it did.
+func (d *Dispatcher) hold(m *Message) bool {
+	b := d.Replay
+	if b.excluded(m.Type()) {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.follows(m) && d.handler(m) != nil {
+		return false
+	}
+	if b.size <= 0 {
+		return false
+	}
+	b.entries = append(b.entries, &replayEntry{m: m, held: clockOrDefault(d.Clock).Now()})
+	d.logf("avs: holding directive %s until it has a handler", m)
+	if n := len(b.entries) - b.size; n > 0 {
+		for _, e := range b.entries[:n] {
+			e.forced = true
+		}
+		d.scheduleReplay()
+	}
+	if b.maxAge > 0 && !b.watching {
+		b.watching = true
+		go d.watchReplays()
+	}
+	return true
+}
+
+// Reports whether a directive of the dialog of m is held. The lock must be
+// held.
+func (b *ReplayBuffer) follows(m *Message) bool {
+	dialog := m.header("dialogRequestId")
+	if dialog == "" {
+		return false
+	}
+	for _, e := range b.entries {
+		if e.m.header("dialogRequestId") == dialog {
+			return true
+		}
+	}
+	return false
+}
+
+// Has the held directives that can be dispatched replayed by Run, or on a
+// new goroutine if no Run is running.
+func (d *Dispatcher) scheduleReplay() {
+	d.runMu.Lock()
+	running := d.runs > 0
+	d.runMu.Unlock()
+	if running && d.replays != nil {
+		select {
+		case d.replays <- struct{}{}:
+		default:
+			// Run hasn't picked up the previous request yet.
+		}
+		return
+	}
+	go d.replay(context.Background())
+}
+
+// Dispatches the held directives that can be, in order, until none can.
+func (d *Dispatcher) replay(ctx context.Context) {
+	b := d.Replay
+	b.mu.Lock()
+	if b.running {
+		b.mu.Unlock()
+		return
+	}
+	b.running = true
+	for {
+		e := d.nextReplay()
+		if e == nil {
+			break
+		}
+		e.busy = true
+		b.mu.Unlock()
+		if err := d.dispatchHandler(ctx, e.m); err != nil {
+			d.logf("avs: handler for held directive %s failed: %v", e.m, err)
+		}
+		b.mu.Lock()
+		for i, entry := range b.entries {
+			if entry == e {
+				b.entries = append(b.entries[:i], b.entries[i+1:]...)
+				break
+			}
+		}
+	}
+	b.running = false
+	b.mu.Unlock()
+}
+
+// Returns the first held directive that has a handler, has expired or was
+// evicted, and isn't behind another directive of its dialog. The lock must be
+// held.
+func (d *Dispatcher) nextReplay() *replayEntry {
+	b := d.Replay
+	now := clockOrDefault(d.Clock).Now()
+	blocked := make(map[string]bool)
+	for _, e := range b.entries {
+		dialog := e.m.header("dialogRequestId")
+		if dialog != "" && blocked[dialog] {
+			continue
+		}
+		expired := b.maxAge > 0 && now.Sub(e.held) >= b.maxAge
+		if !e.busy && (e.forced || expired || d.handler(e.m) != nil) {
+			return e
+		}
+		if dialog != "" {
+			blocked[dialog] = true
+		}
+	}
+	return nil
+}
+
+// Schedules a replay as the directives expire, until every directive held
+// has expired. The expired ones that can't be dispatched yet, behind a
+// directive being dispatched, are left to that replay.
+func (d *Dispatcher) watchReplays() {
+	b := d.Replay
+	clock := clockOrDefault(d.Clock)
+	for {
+		d.scheduleReplay()
+		b.mu.Lock()
+		now := clock.Now()
+		var next time.Time
+		for _, e := range b.entries {
+			if now.Sub(e.held) < b.maxAge && (next.IsZero() || e.held.Before(next)) {
+				next = e.held
+			}
+		}
+		if next.IsZero() {
+			b.watching = false
+			b.mu.Unlock()
+			return
+		}
+		b.mu.Unlock()
+		<-clock.NewTimer(b.maxAge - now.Sub(next)).C()
+	}
+}
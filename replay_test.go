@@ -0,0 +1,167 @@
+package avs
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newDialogDirective(namespace, name, messageId, dialogRequestId string) *Message {
+	return &Message{
+		Header: map[string]string{
+			"namespace":       namespace,
+			"name":            name,
+			"messageId":       messageId,
+			"dialogRequestId": dialogRequestId,
+		},
+		Payload: json.RawMessage(`{}`),
+	}
+}
+
+// Waits until the dispatcher holds n directives.
+func waitHeld(t *testing.T, d *Dispatcher, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for d.Replay.Len() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("still holding %d directives, want %d", d.Replay.Len(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReplayBufferLateHandler(t *testing.T) {
+	d := NewDispatcher()
+	d.Replay = NewReplayBuffer(10, 0)
+	ctx := context.Background()
+	for _, m := range []*Message{
+		newDialogSpeak("s1", "d1"),
+		newDialogDirective("SpeechRecognizer", "ExpectSpeech", "e1", "d1"),
+		newDialogDirective("SpeechRecognizer", "StopCapture", "c1", "d1"),
+	} {
+		if err := d.Dispatch(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// StopCapture isn't held, and the ExpectSpeech has a handler already but
+	// waits for the Speak of its dialog.
+	handled := make(chan string, 3)
+	d.HandleFunc("SpeechRecognizer", func(ctx context.Context, directive TypedMessage) error {
+		handled <- directive.GetMessage().Type().Name
+		return nil
+	})
+	if got := d.Replay.Len(); got != 2 {
+		t.Fatalf("got %d directives held, want 2", got)
+	}
+	d.HandleFunc("SpeechSynthesizer.Speak", func(ctx context.Context, directive TypedMessage) error {
+		handled <- directive.GetMessage().Type().Name
+		return nil
+	})
+	var names []string
+	for len(names) < 2 {
+		select {
+		case name := <-handled:
+			names = append(names, name)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %v before timing out", names)
+		}
+	}
+	if want := []string{"Speak", "ExpectSpeech"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+
+	// Directives with a handler are dispatched right away once none is held.
+	waitHeld(t, d, 0)
+	if err := d.Dispatch(ctx, newDialogDirective("SpeechRecognizer", "ExpectSpeech", "e2", "d2")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handled:
+	default:
+		t.Error("directive with a handler was held")
+	}
+}
+
+func TestReplayBufferExpiry(t *testing.T) {
+	d := NewDispatcher()
+	d.Replay = NewReplayBuffer(10, 20*time.Millisecond)
+	d.Profile = NewDeviceProfile(NewCapability("SpeechSynthesizer", 1, 0))
+	exceptions := make(chan *ExceptionEncountered, 1)
+	d.ReportException = func(e *ExceptionEncountered) {
+		exceptions <- e
+	}
+	if err := d.Dispatch(context.Background(), newDialogSpeak("s1", "d1")); err != nil {
+		t.Fatalf("got %v for a held directive", err)
+	}
+	// Once expired, it's handled like any directive without a handler.
+	select {
+	case e := <-exceptions:
+		if e.Payload.Error.Type != ErrorTypeUnsupportedOperation {
+			t.Errorf("got error type %s", e.Payload.Error.Type)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expired directive wasn't reported")
+	}
+	waitHeld(t, d, 0)
+}
+
+func TestReplayBufferFull(t *testing.T) {
+	d := NewDispatcher()
+	d.Replay = NewReplayBuffer(2, 0)
+	dropped := make(chan string, 3)
+	d.ReportException = func(e *ExceptionEncountered) {
+		dropped <- e.Payload.UnparsedDirective
+	}
+	d.Profile = NewDeviceProfile(NewCapability("SpeechSynthesizer", 1, 0))
+	for _, id := range []string{"s1", "s2", "s3"} {
+		if err := d.Dispatch(context.Background(), newDialogSpeak(id, id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The oldest directive makes room for the newest.
+	select {
+	case <-dropped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("oldest directive wasn't evicted")
+	}
+	waitHeld(t, d, 2)
+	var tokens []string
+	d.HandleFunc("SpeechSynthesizer", func(ctx context.Context, directive TypedMessage) error {
+		tokens = append(tokens, directive.(*Speak).Payload.Token)
+		return nil
+	})
+	waitHeld(t, d, 0)
+	if want := []string{"s2", "s3"}; !reflect.DeepEqual(tokens, want) {
+		t.Errorf("got %v after the buffer was full, want %v", tokens, want)
+	}
+}
+
+// While Run is running, it dispatches the held directives with its context,
+// rather than that of a Dispatch call that may be over.
+func TestReplayBufferRun(t *testing.T) {
+	d := NewDispatcher()
+	d.Replay = NewReplayBuffer(10, 0)
+	type key struct{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "run"))
+	defer cancel()
+	directives := make(chan *Message)
+	go d.Run(ctx, directives)
+	directives <- newDialogSpeak("s1", "d1")
+	waitHeld(t, d, 1)
+	handled := make(chan context.Context, 1)
+	d.HandleFunc("SpeechSynthesizer", func(ctx context.Context, directive TypedMessage) error {
+		handled <- ctx
+		return nil
+	})
+	select {
+	case ctx := <-handled:
+		if ctx.Value(key{}) != "run" || ctx.Value(runKey{}) != d {
+			t.Error("held directive wasn't dispatched with the context of Run")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("held directive wasn't dispatched")
+	}
+	waitHeld(t, d, 0)
+}
@@ -0,0 +1,132 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// StateReporter sends the events that report changes made on the device
+// itself (e.g., the user turning the volume knob or stopping the playback
+// with a button), with generated message ids and the contexts of the device.
+//
+// The context matching the reported change (e.g., the VolumeState of a
+// VolumeChanged event) is replaced by the reported state, so that it agrees
+// with the event even if the ContextAggregator has a stale value.
+//
+// Events that can't be sent because AVS can't be reached are queued in the
+// Queue with their contexts, and sent before the next report. Events that
+// may have reached AVS (see ErrIndeterminate) are only queued if they're
+// idempotent.
+type StateReporter struct {
+	Client *Client
+	// AccessToken returns the access token to send the events with.
+	AccessToken func() string
+	// Contexts, if set, provides the contexts of the events.
+	Contexts *ContextAggregator
+	// Queue holds the events that couldn't be sent. If nil, they're
+	// returned as errors instead.
+	Queue *EventQueue
+
+	mu sync.Mutex
+	// The last reported volume, if any.
+	volume *VolumeState
+}
+
+// NewStateReporter returns a StateReporter that sends the events through the
+// client and queues them while AVS can't be reached.
+func NewStateReporter(client *Client, accessToken func() string, contexts *ContextAggregator) *StateReporter {
+	return &StateReporter{Client: client, AccessToken: accessToken, Contexts: contexts, Queue: new(EventQueue)}
+}
+
+// ReportVolume reports a new volume with MuteChanged if the device was muted
+// or unmuted, and with VolumeChanged otherwise. The previous state is that of
+// the last report, or that of the VolumeState context before the first.
+func (r *StateReporter) ReportVolume(ctx context.Context, volume int, muted bool) error {
+	state := NewVolumeState(volume, muted)
+	r.mu.Lock()
+	previous := r.volume
+	r.volume = state
+	r.mu.Unlock()
+	contexts := r.contexts()
+	if previous == nil {
+		for _, c := range contexts {
+			if v, ok := c.(*VolumeState); ok {
+				previous = v
+			}
+		}
+	}
+	var event TypedMessage
+	if previous != nil && previous.Payload.Muted != muted {
+		event = NewMuteChanged(RandomUUIDString(), volume, muted)
+	} else {
+		event = NewVolumeChanged(RandomUUIDString(), volume, muted)
+	}
+	return r.report(ctx, event, replaceContext(contexts, state))
+}
+
+// ReportPlaybackStopped reports that the audio item of the token was stopped
+// at the offset with PlaybackStopped.
+func (r *StateReporter) ReportPlaybackStopped(ctx context.Context, token string, offset time.Duration) error {
+	state := NewPlaybackState(token, offset, PlayerActivityStopped)
+	return r.report(ctx, NewPlaybackStopped(RandomUUIDString(), token, offset), replaceContext(r.contexts(), state))
+}
+
+// ReportLocaleChanged reports a new locale with SettingsUpdated.
+func (r *StateReporter) ReportLocaleChanged(ctx context.Context, locale SettingLocale) error {
+	return r.report(ctx, NewLocaleSettingsUpdated(RandomUUIDString(), locale), r.contexts())
+}
+
+func (r *StateReporter) contexts() []TypedMessage {
+	if r.Contexts == nil {
+		return nil
+	}
+	return r.Contexts.Contexts(false)
+}
+
+// Sends the queued events, then the event. The event is queued if AVS can't
+// be reached.
+func (r *StateReporter) report(ctx context.Context, event TypedMessage, contexts []TypedMessage) error {
+	accessToken := r.AccessToken()
+	if r.Queue != nil && r.Queue.Len() > 0 {
+		if err := r.Queue.Flush(ctx, r.Client, accessToken); err != nil {
+			if !errors.Is(err, ErrNotConnected) {
+				return err
+			}
+			// Keep the order of the events.
+			r.Queue.Add(event, contexts...)
+			return nil
+		}
+	}
+	request := NewRequest(accessToken)
+	request.Event = event
+	request.Context = contexts
+	_, err := r.Client.DoContext(ctx, request)
+	if r.Queue != nil && errors.Is(err, ErrNotConnected) {
+		if errors.Is(err, ErrIndeterminate) && !r.Client.IsIdempotent(request) {
+			// Sending it again might duplicate it.
+			return err
+		}
+		r.Queue.Add(event, contexts...)
+		return nil
+	}
+	return err
+}
+
+// Returns the contexts with the context of the same type as c replaced by c,
+// or with c added if there is none. The contexts aren't changed.
+func replaceContext(contexts []TypedMessage, c TypedMessage) []TypedMessage {
+	replaced := make([]TypedMessage, 0, len(contexts)+1)
+	found := false
+	for _, existing := range contexts {
+		if existing.GetMessage().Type() == c.GetMessage().Type() {
+			existing, found = c, true
+		}
+		replaced = append(replaced, existing)
+	}
+	if !found {
+		replaced = append(replaced, c)
+	}
+	return replaced
+}
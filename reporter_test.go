@@ -0,0 +1,115 @@
+package avs_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+	"github.com/fika-io/go-avs/avstest"
+)
+
+func TestStateReporter(t *testing.T) {
+	offline := httptest.NewServer(nil)
+	offline.Close()
+	client := &avs.Client{EndpointURL: offline.URL}
+	contexts := avs.NewContextAggregator()
+	contexts.Add(avs.ContextProviderFunc(func() (avs.TypedMessage, error) {
+		return avs.NewVolumeState(30, false), nil
+	}), 0)
+	contexts.Add(avs.ContextProviderFunc(func() (avs.TypedMessage, error) {
+		return avs.NewPlaybackState("song", time.Second, avs.PlayerActivityPlaying), nil
+	}), 0)
+	r := avs.NewStateReporter(client, func() string { return "token" }, contexts)
+	ctx := context.Background()
+
+	// The events are queued while AVS can't be reached.
+	if err := r.ReportPlaybackStopped(ctx, "song", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if r.Queue.Len() != 1 {
+		t.Fatalf("got %d queued events; want 1", r.Queue.Len())
+	}
+
+	server := avstest.NewServer()
+	defer server.Close()
+	client.EndpointURL = server.URL
+	if err := r.ReportVolume(ctx, 30, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReportVolume(ctx, 40, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReportLocaleChanged(ctx, avs.SettingLocaleGB); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := server.Requests()
+	want := []avs.MessageType{avs.TypePlaybackStopped, avs.TypeMuteChanged, avs.TypeVolumeChanged, avs.TypeSettingsUpdated}
+	if len(requests) != len(want) {
+		t.Fatalf("got %d requests; want %d", len(requests), len(want))
+	}
+	for i, request := range requests {
+		if got := request.Event.GetMessage().Type(); got != want[i] {
+			t.Errorf("event %d: got %s; want %s", i, got, want[i])
+		}
+		if request.Event.GetMessage().Header["messageId"] == "" {
+			t.Errorf("event %d has no message id", i)
+		}
+	}
+	// The queued event is sent with the contexts it was reported with.
+	for _, c := range requests[0].Context {
+		if p, ok := c.(*avs.PlaybackState); ok && p.Payload.PlayerActivity != avs.PlayerActivityStopped {
+			t.Errorf("got PlaybackState %+v with the queued PlaybackStopped", p.Payload)
+		}
+		if v, ok := c.(*avs.VolumeState); ok && v.Payload.Muted {
+			t.Errorf("got the VolumeState of a later event with the queued PlaybackStopped")
+		}
+	}
+	for _, c := range requests[2].Context {
+		if v, ok := c.(*avs.VolumeState); ok && (v.Payload.Volume != 40 || !v.Payload.Muted) {
+			t.Errorf("got VolumeState %+v with VolumeChanged; want the reported volume", v.Payload)
+		}
+	}
+	if len(requests[2].Context) != 2 {
+		t.Errorf("got %d contexts; want 2", len(requests[2].Context))
+	}
+	if r.Queue.Len() != 0 {
+		t.Errorf("got %d queued events after reconnecting", r.Queue.Len())
+	}
+}
+
+// Events that may have reached AVS are only queued if sending them twice is
+// harmless.
+func TestStateReporterIndeterminate(t *testing.T) {
+	// The server drops the connection once it has read the request.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}))
+	defer server.Close()
+	client := &avs.Client{EndpointURL: server.URL}
+	r := avs.NewStateReporter(client, func() string { return "token" }, nil)
+	ctx := context.Background()
+	if err := r.ReportPlaybackStopped(ctx, "song", time.Second); !errors.Is(err, avs.ErrIndeterminate) {
+		t.Errorf("got %v; want an ErrIndeterminate error", err)
+	}
+	if r.Queue.Len() != 0 {
+		t.Fatalf("queued a PlaybackStopped that may have been sent")
+	}
+	if err := r.ReportVolume(ctx, 30, false); err != nil {
+		t.Fatal(err)
+	}
+	if r.Queue.Len() != 1 {
+		t.Errorf("got %d queued events; want the VolumeChanged", r.Queue.Len())
+	}
+}
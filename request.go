@@ -1,16 +1,87 @@
 package avs
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
 )
 
 // A Request represents an event and optional context to send to AVS.
 type Request struct {
 	// Access token for the user that this request should be made for.
-	AccessToken string         `json:"-"`
+	AccessToken string `json:"-"`
+	// Audio, if set, is sent in the "audio" part, ahead of the Attachments.
 	Audio       io.Reader      `json:"-"`
+	Attachments []Attachment   `json:"-"`
 	Context     []TypedMessage `json:"context"`
 	Event       TypedMessage   `json:"event"`
+
+	// More events sent in the same request, after Event.
+	batch []*Envelope
+}
+
+// Envelope is the metadata of a request: an event and its contexts.
+type Envelope struct {
+	Context []TypedMessage `json:"context"`
+	Event   TypedMessage   `json:"event"`
+}
+
+// Validate checks that the envelope has a valid event with a message id, and
+// that its contexts are valid and of different types. It returns an
+// ErrInvalidMessage error otherwise.
+func (e *Envelope) Validate() error {
+	if e.Event == nil || e.Event.GetMessage() == nil {
+		return withKind(ErrInvalidMessage, errors.New("avs: request without an event"))
+	}
+	event := e.Event.GetMessage()
+	if err := event.Validate(); err != nil {
+		return err
+	}
+	if event.header("messageId") == "" {
+		return withKind(ErrInvalidMessage, fmt.Errorf("avs: event %s without a message id", event))
+	}
+	seen := make(map[MessageType]bool, len(e.Context))
+	for _, c := range e.Context {
+		if c == nil || c.GetMessage() == nil {
+			return withKind(ErrInvalidMessage, fmt.Errorf("avs: nil context in %s request", event))
+		}
+		m := c.GetMessage()
+		if err := m.Validate(); err != nil {
+			return err
+		}
+		if seen[m.Type()] {
+			return withKind(ErrInvalidMessage, fmt.Errorf("avs: context %s is sent more than once", m))
+		}
+		seen[m.Type()] = true
+	}
+	return nil
+}
+
+// Returns a copy of the envelope with copies of its messages, so that they
+// may be changed without affecting the originals.
+func (e *Envelope) clone() (*Envelope, error) {
+	c := &Envelope{Context: make([]TypedMessage, len(e.Context))}
+	var err error
+	for i, m := range e.Context {
+		if c.Context[i], err = cloneTyped(m); err != nil {
+			return nil, err
+		}
+	}
+	if c.Event, err = cloneTyped(e.Event); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Returns a copy of the message with the same type. Typed messages keep their
+// payload in their own struct, so the copy is made from their encoding.
+func cloneTyped(m TypedMessage) (TypedMessage, error) {
+	data, err := codec().Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return TypedFromReader(bytes.NewReader(data))
 }
 
 // NewRequest returns a new Request given an access token.
@@ -27,3 +98,76 @@ func NewRequest(accessToken string) *Request {
 func (r *Request) AddContext(m TypedMessage) {
 	r.Context = append(r.Context, m)
 }
+
+// NewSynchronizeStateRequest returns a new Request with a SynchronizeState
+// event and the contexts gathered from the aggregator.
+func NewSynchronizeStateRequest(accessToken, messageId string, aggregator *ContextAggregator) *Request {
+	r := NewRequest(accessToken)
+	r.Event = NewSynchronizeState(messageId)
+	if aggregator != nil {
+		r.Context = aggregator.Contexts(true)
+	}
+	return r
+}
+
+// UnmarshalJSON parses the JSON metadata of a request (e.g., as received by a
+// mock server). The event and contexts are parsed as Message values; use
+// ParseEnvelope to get typed contexts.
+func (r *Request) UnmarshalJSON(data []byte) error {
+	var envelope struct {
+		Context []*Message `json:"context"`
+		Event   *Message   `json:"event"`
+	}
+	if err := codec().Unmarshal(data, &envelope); err != nil {
+		return err
+	}
+	r.Context = make([]TypedMessage, len(envelope.Context))
+	for i, m := range envelope.Context {
+		r.Context[i] = m
+	}
+	// Avoid storing a nil *Message in the interface.
+	r.Event = nil
+	if envelope.Event != nil {
+		r.Event = envelope.Event
+	}
+	return nil
+}
+
+// ParseEnvelope parses the JSON metadata of a request and passes the event
+// and every context through Typed. Contexts without a specific type are kept
+// as Message values. JSON nested deeper than DefaultLimits.MaxDepth is an
+// ErrInvalidMessage error, and so are unknown payload fields in ParseStrict
+// mode.
+func ParseEnvelope(r io.Reader) (*Request, error) {
+	request := new(Request)
+	if err := codec().NewDecoder(&depthChecker{r: r, max: DefaultLimits.MaxDepth}).Decode(request); err != nil {
+		return nil, err
+	}
+	if parseMode() == ParseStrict {
+		if err := request.checkPayloads(); err != nil {
+			return nil, err
+		}
+	}
+	for i, c := range request.Context {
+		request.Context[i] = c.Typed()
+	}
+	if request.Event != nil {
+		request.Event = request.Event.Typed()
+	}
+	return request, nil
+}
+
+// Checks the payloads of the event and contexts with CheckPayload.
+func (r *Request) checkPayloads() error {
+	if r.Event != nil {
+		if err := CheckPayload(r.Event.GetMessage()); err != nil {
+			return err
+		}
+	}
+	for _, c := range r.Context {
+		if err := CheckPayload(c.GetMessage()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package avs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Compares two JSON documents, ignoring formatting and field ordering.
+func jsonEqual(t *testing.T, a, b []byte) bool {
+	var va, vb interface{}
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatalf("invalid JSON %s: %v", a, err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatalf("invalid JSON %s: %v", b, err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func TestSynchronizeStateRequestGolden(t *testing.T) {
+	golden, err := ioutil.ReadFile("testdata/synchronize_state.golden.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aggregator := NewContextAggregator()
+	aggregator.Add(ContextProviderFunc(func() (TypedMessage, error) {
+		return NewPlaybackState("abc123token", 12500*time.Millisecond, PlayerActivityPaused), nil
+	}), 0)
+	aggregator.Add(ContextProviderFunc(func() (TypedMessage, error) {
+		return NewVolumeState(50, false), nil
+	}), 0)
+	request := NewSynchronizeStateRequest("token", "abc123", aggregator)
+	data, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !jsonEqual(t, golden, data) {
+		t.Errorf("SynchronizeState request doesn't match golden file:\n got: %s\nwant: %s", data, golden)
+	}
+}
+
+func TestParseSynchronizeStateRequest(t *testing.T) {
+	golden, err := ioutil.ReadFile("testdata/synchronize_state.golden.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var request Request
+	if err := json.Unmarshal(golden, &request); err != nil {
+		t.Fatal(err)
+	}
+	if s := request.Event.GetMessage().String(); s != "System.SynchronizeState" {
+		t.Errorf("got event %s; want System.SynchronizeState", s)
+	}
+	var names []string
+	for _, c := range request.Context {
+		names = append(names, c.GetMessage().String())
+	}
+	if want := []string{"AudioPlayer.PlaybackState", "Speaker.VolumeState"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got contexts %v; want %v", names, want)
+	}
+}
+
+func TestParseEnvelope(t *testing.T) {
+	golden, err := ioutil.ReadFile("testdata/synchronize_state.golden.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Add a context that the package doesn't know about.
+	data := strings.Replace(string(golden), `"context": [`,
+		`"context": [{"header": {"namespace": "Bluetooth", "name": "BluetoothState"}, "payload": {"alexaDevice": {}}},`, 1)
+	request, err := ParseEnvelope(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(request.Context) != 3 {
+		t.Fatalf("got %d contexts; want 3", len(request.Context))
+	}
+	unknown, ok := request.Context[0].(*Message)
+	if !ok || unknown.Type() != (MessageType{"Bluetooth", "BluetoothState"}) || string(unknown.Payload) != `{"alexaDevice": {}}` {
+		t.Errorf("unknown context wasn't preserved: %#v", request.Context[0])
+	}
+	playback, ok := request.Context[1].(*PlaybackState)
+	if !ok || playback.Payload.PlayerActivity != PlayerActivityPaused || playback.Payload.OffsetInMilliseconds != 12500 {
+		t.Errorf("got %#v; want a paused *PlaybackState at 12500 ms", request.Context[1])
+	}
+	if volume, ok := request.Context[2].(*VolumeState); !ok || volume.Payload.Volume != 50 {
+		t.Errorf("got %#v; want a *VolumeState at 50", request.Context[2])
+	}
+	if request.Event.GetMessage().Type() != TypeSynchronizeState {
+		t.Errorf("got event %v; want System.SynchronizeState", request.Event)
+	}
+}
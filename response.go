@@ -1,11 +1,224 @@
 package avs
 
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fika-io/go-avs/multipart2"
+)
+
 // Response represents a response from the AVS API.
 type Response struct {
 	// The Amazon request id (for debugging purposes).
 	RequestId string
+	// The HTTP status code of the response.
+	StatusCode int
+	// When the request was sent and when the response was fully read.
+	Started, Finished time.Time
 	// All the directives in the response.
 	Directives []*Message
-	// Attachments (usually audio). Key is the Content-ID header value.
+	// Attachments (usually audio). Key is the Content-ID header value. The
+	// slices are copied out of the parser's buffers and may be retained.
 	Content map[string][]byte
+
+	next   int
+	stream *responseStream
+	// The attachments of a response read by DoIncremental, as they arrive.
+	streamsMu    sync.Mutex
+	streams      map[string]*attachmentStream
+	streamsEnded bool
+	clock        Clock
+	metrics      *Metrics
+	processed    *processedTracker
+}
+
+// ErrMixedIteration is returned when both Next and TypedDirectives are used
+// on a streamed response.
+var ErrMixedIteration = errors.New("avs: Next and TypedDirectives used on the same streamed response")
+
+// The body of a response returned by Client.DoStream, which is read as the
+// directives are requested.
+type responseStream struct {
+	body      io.ReadCloser
+	mr        *multipart2.Reader
+	threshold int
+	limits    Limits
+	clock     Clock
+
+	iterated bool // Next was called
+	drained  bool // TypedDirectives was called
+	err      error
+	closed   int32 // Close was called
+}
+
+// Next returns the next directive of the response, in the order in which AVS
+// sent them, or io.EOF after the last one.
+//
+// For streamed responses (see Client.DoStream), the directive is read from
+// the response body, and the attachments read along the way are added to
+// Content. As AVS may send an attachment after the directive that refers to
+// it, it may only be available after more calls to Next. Using Next after
+// TypedDirectives on a streamed response returns ErrMixedIteration.
+func (r *Response) Next() (TypedMessage, error) {
+	if r.stream == nil {
+		if r.next >= len(r.Directives) {
+			return nil, io.EOF
+		}
+		r.next++
+		return r.Directives[r.next-1].Typed(), nil
+	}
+	if r.stream.drained {
+		return nil, ErrMixedIteration
+	}
+	r.stream.iterated = true
+	directive, err := r.stream.read(r)
+	if err != nil {
+		return nil, err
+	}
+	return directive.Typed(), nil
+}
+
+// TypedDirectives returns all the directives of the response with their most
+// specific type, in the order in which AVS sent them. A streamed response is
+// read to the end first; using TypedDirectives after Next on a streamed
+// response returns ErrMixedIteration.
+func (r *Response) TypedDirectives() ([]TypedMessage, error) {
+	if r.stream != nil {
+		if r.stream.iterated {
+			return nil, ErrMixedIteration
+		}
+		r.stream.drained = true
+		for {
+			_, err := r.stream.read(r)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	typed := make([]TypedMessage, len(r.Directives))
+	for i, directive := range r.Directives {
+		typed[i] = directive.Typed()
+	}
+	return typed, nil
+}
+
+// Exceptions returns the System.Exception directives of the response, which
+// AVS may send instead of failing the request when it rejects the event
+// itself. Their StatusCode and RequestId are those of the response. Streamed
+// responses only have the exceptions read so far.
+func (r *Response) Exceptions() []*Exception {
+	var exceptions []*Exception
+	for _, directive := range r.Directives {
+		if directive.Type() != TypeException {
+			continue
+		}
+		if exception, ok := directive.Typed().(*Exception); ok {
+			exception.StatusCode = r.StatusCode
+			exception.RequestId = r.RequestId
+			exceptions = append(exceptions, exception)
+		}
+	}
+	return exceptions
+}
+
+// Close closes the body of a streamed response. It does nothing for other
+// responses. Next returns an ErrClosed error afterwards.
+func (r *Response) Close() error {
+	if r.stream == nil {
+		return nil
+	}
+	atomic.StoreInt32(&r.stream.closed, 1)
+	return r.stream.body.Close()
+}
+
+// Attachment returns the attachment with the content id, which may be a cid:
+// URL. It returns an ErrAttachmentMissing error if there is no such
+// attachment.
+func (r *Response) Attachment(contentId string) ([]byte, error) {
+	if id, ok := ParseCID(contentId); ok {
+		contentId = string(id)
+	}
+	if data, ok := r.Content[contentId]; ok {
+		return data, nil
+	}
+	return nil, withKind(ErrAttachmentMissing, fmt.Errorf("avs: response has no attachment %s", contentId))
+}
+
+// Reads parts until the next directive. At the end of the body, it closes
+// the body, sets the Finished time of the response and returns io.EOF.
+func (s *responseStream) read(response *Response) (*Message, error) {
+	for s.err == nil {
+		p, err := s.mr.NextPart()
+		if err == io.EOF {
+			response.Finished = clockOrDefault(s.clock).Now()
+			s.body.Close()
+			s.err = io.EOF
+			break
+		}
+		if err != nil {
+			if atomic.LoadInt32(&s.closed) != 0 {
+				err = withKind(ErrClosed, err)
+			}
+			s.err = err
+			break
+		}
+		directive, err := readResponsePart(p, response, s.threshold, s.limits)
+		if err != nil {
+			s.err = err
+			break
+		}
+		if directive != nil {
+			return directive, nil
+		}
+	}
+	return nil, s.err
+}
+
+// Latency returns the time it took from sending the request until the
+// response was fully read.
+func (r *Response) Latency() time.Duration {
+	return r.Finished.Sub(r.Started)
+}
+
+// String returns a summary of the response suitable for logs.
+func (r *Response) String() string {
+	return fmt.Sprintf("%d (request id %s) with %d directive(s) and %d attachment(s) in %s",
+		r.StatusCode, r.RequestId, len(r.Directives), len(r.Content), r.Latency())
+}
+
+// RequestError is returned when a request to AVS fails without AVS providing
+// a System.Exception message.
+type RequestError struct {
+	// The HTTP status code and status line of the response.
+	StatusCode int
+	Status     string
+	// The Amazon request id (for debugging purposes).
+	RequestId string
+	// The delay that the Retry-After header of the response asked for, if
+	// any.
+	RetryAfter time.Duration
+}
+
+// Error returns the RequestError formatted as a human readable string.
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("request failed with %s (request id %s)", e.Status, e.RequestId)
+}
+
+// Is reports whether the error is of the kind of target: HTTP 401 and 403
+// are ErrUnauthorized and HTTP 429 is ErrThrottled.
+func (e *RequestError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == 401 || e.StatusCode == 403
+	case ErrThrottled:
+		return e.StatusCode == 429
+	}
+	return false
 }
@@ -0,0 +1,223 @@
+package avs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// A response with a Speak directive, its attachment and an ExpectSpeech
+// directive, in that order.
+var speakAndExpectSpeech = "--------abcde123\r\nContent-Type: application/json\r\n\r\n{\"directive\":" + speakDirective + "}\r\n" +
+	"--------abcde123\r\nContent-ID: <abc>\r\nContent-Type: application/octet-stream\r\n\r\nmp3\r\n" +
+	"--------abcde123\r\nContent-Type: application/json\r\n\r\n" +
+	`{"directive":{"header":{"namespace":"SpeechRecognizer","name":"ExpectSpeech","messageId":"m2"},"payload":{"timeoutInMilliseconds":8000}}}` + "\r\n" +
+	"--------abcde123--\r\n"
+
+// Returns a server that responds to every event with the multipart body.
+func newResponseServer(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "multipart/related; boundary=------abcde123; type=application/json")
+		w.WriteHeader(200)
+		fmt.Fprint(w, body)
+	}))
+}
+
+// Returns the names of the directives returned by Next until io.EOF.
+func nextNames(t *testing.T, response *Response) []string {
+	var names []string
+	for {
+		directive, err := response.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, directive.GetMessage().Type().Name)
+	}
+}
+
+func TestResponseNext(t *testing.T) {
+	server := newResponseServer(speakAndExpectSpeech)
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL}
+	request := NewRequest("token")
+	request.Event = NewRecognize("m1", "d1")
+
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := fmt.Sprint(nextNames(t, response)); names != "[Speak ExpectSpeech]" {
+		t.Errorf("got %s; want [Speak ExpectSpeech]", names)
+	}
+	if typed, err := response.TypedDirectives(); err != nil || len(typed) != 2 {
+		t.Errorf("got %d directives, %v", len(typed), err)
+	}
+
+	response, err = client.DoStream(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Close()
+	directive, err := response.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := directive.(*Speak); !ok || len(response.Content) != 0 || !response.Finished.IsZero() {
+		t.Fatalf("got %#v with %d attachments; want only a *Speak", directive, len(response.Content))
+	}
+	if _, err := response.TypedDirectives(); err != ErrMixedIteration {
+		t.Errorf("got %v; want ErrMixedIteration", err)
+	}
+	if names := fmt.Sprint(nextNames(t, response)); names != "[ExpectSpeech]" {
+		t.Errorf("got %s; want [ExpectSpeech]", names)
+	}
+	if string(response.Content["abc"]) != "mp3" || response.Finished.IsZero() || len(response.Directives) != 2 {
+		t.Errorf("streamed response wasn't completed: %v", response)
+	}
+
+	response, err = client.DoStream(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typed, err := response.TypedDirectives(); err != nil || len(typed) != 2 {
+		t.Errorf("got %d directives, %v", len(typed), err)
+	}
+	if _, err := response.Next(); err != ErrMixedIteration {
+		t.Errorf("got %v; want ErrMixedIteration", err)
+	}
+}
+
+func TestResponseRequestInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-amzn-requestid", "req-"+r.Header.Get("x-test"))
+		if r.Header.Get("x-test") == "2" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "multipart/related; boundary=------abcde123; type=application/json")
+		w.WriteHeader(200)
+		fmt.Fprint(w, speakAndExpectSpeech)
+	}))
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL}
+	client.SetHeader("x-test", "1")
+	request := NewRequest("token")
+	request.Event = NewRecognize("m1", "d1")
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 200 || response.RequestId != "req-1" {
+		t.Errorf("got status %d and request id %q", response.StatusCode, response.RequestId)
+	}
+	if response.Started.IsZero() || response.Finished.Before(response.Started) || response.Latency() < 0 {
+		t.Errorf("got started %s, finished %s", response.Started, response.Finished)
+	}
+	if s := response.String(); !strings.HasPrefix(s, "200 (request id req-1) with 2 directive(s) and 1 attachment(s)") {
+		t.Errorf("got summary %q", s)
+	}
+
+	// A failure without an exception is a RequestError with the request id.
+	client.SetHeader("x-test", "2")
+	_, err = client.Do(request)
+	var requestError *RequestError
+	if !errors.As(err, &requestError) || requestError.StatusCode != 500 || requestError.RequestId != "req-2" {
+		t.Errorf("got %v; want a RequestError for req-2", err)
+	}
+}
+
+func TestDispatchResponse(t *testing.T) {
+	server := newResponseServer(speakAndExpectSpeech)
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL}
+	request := NewRequest("token")
+	request.Event = NewRecognize("m1", "d1")
+	response, err := client.DoStream(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Close()
+	var handled []string
+	d := NewDispatcher()
+	d.HandleFunc("SpeechSynthesizer.Speak", func(ctx context.Context, directive TypedMessage) error {
+		handled = append(handled, "Speak")
+		return nil
+	})
+	d.HandleFunc("SpeechRecognizer", func(ctx context.Context, directive TypedMessage) error {
+		if _, ok := response.Content["abc"]; !ok {
+			t.Error("the attachment should have been read before the ExpectSpeech directive")
+		}
+		handled = append(handled, "ExpectSpeech")
+		return nil
+	})
+	if err := d.DispatchResponse(context.Background(), response); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(handled) != "[Speak ExpectSpeech]" {
+		t.Errorf("handled %v", handled)
+	}
+}
+
+func TestResponseExceptions(t *testing.T) {
+	// A 200 response in which AVS rejected the context of the event with a
+	// System.Exception after the speech. It's synthetic, not captured (see
+	// the preamble of the file).
+	body, err := ioutil.ReadFile("testdata/exception_200.multipart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "multipart/related; boundary=------abcde123; type=application/json")
+		w.Header().Set("x-amzn-requestid", "req-1")
+		w.WriteHeader(200)
+		w.Write(body)
+	}))
+	defer server.Close()
+	request := NewRequest("token")
+	request.Event = NewRecognize("m1", "d1")
+
+	client := &Client{EndpointURL: server.URL}
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exceptions := response.Exceptions()
+	if len(exceptions) != 1 {
+		t.Fatalf("got %d exceptions; want 1", len(exceptions))
+	}
+	if e := exceptions[0]; e.Payload.Code != ExceptionCodeInvalidRequest || e.StatusCode != 200 || e.RequestId != "req-1" {
+		t.Errorf("got exception %+v", e)
+	}
+
+	client.FailOnException = true
+	client.RetryPolicy = &RetryPolicy{Actions: map[ExceptionCode]RetryAction{
+		ExceptionCodeInvalidRequest: {Retries: 1},
+	}}
+	atomic.StoreInt32(&requests, 0)
+	response, err = client.Do(request)
+	var exception *Exception
+	if !errors.As(err, &exception) || exception.Payload.Code != ExceptionCodeInvalidRequest {
+		t.Fatalf("got error %v; want the exception", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("sent %d requests; want 2 with the retry", n)
+	}
+	if response == nil || len(response.Directives) != 2 {
+		t.Fatalf("got response %v; want the response with its directives", response)
+	}
+	if _, err := response.Attachment("DeviceTTSRendererV4_1a2b3c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,227 @@
+package avs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RetryAction describes how a Client reacts to a specific exception.
+type RetryAction struct {
+	// The maximum number of times the request is retried.
+	Retries int
+	// The delay before the first retry. The delay is doubled for every
+	// subsequent retry. A BackoffPolicy of the Client for the operation
+	// replaces it.
+	Backoff time.Duration
+	// Whether the delay should be randomized (between zero and the delay),
+	// so that a fleet of devices doesn't retry in lockstep.
+	Jitter bool
+	// Whether the access token should be refreshed before retrying.
+	RefreshToken bool
+}
+
+// RetryPolicy describes how a Client should react to the exceptions returned
+// by AVS. It's applied to Do, to establishing downchannels and to
+// PublishCapabilities.
+type RetryPolicy struct {
+	// The action to take for a specific exception code. Codes without an
+	// action are never retried.
+	Actions map[ExceptionCode]RetryAction
+	// RefreshToken returns a new access token to replace the expired one.
+	// Actions that require a new token are not retried if this is nil.
+	RefreshToken func(accessToken string) (string, error)
+	// Budget, if set, bounds the retries made with the policy, whatever the
+	// operation. Once it's exhausted, the error is returned as an
+	// ErrRetryBudgetExhausted error. The events of a dialog (those with a
+	// dialog request id) are retried regardless of the budget.
+	Budget *RetryBudget
+	// IdempotencyKeyHeader, if set, is the HTTP header in which the requests
+	// to the /events endpoint carry their IdempotencyKey, which is the same
+	// for every attempt.
+	IdempotencyKeyHeader string
+}
+
+// RetryBudget limits the number of retries in a sliding time window, so that
+// an outage of AVS doesn't turn into a retry storm from every device of a
+// fleet. It may be shared by the policies of several clients.
+type RetryBudget struct {
+	// Clock, if set, replaces the system clock. It must be set before the
+	// budget is used.
+	Clock Clock
+
+	retries int
+	window  time.Duration
+
+	mu   sync.Mutex
+	used []time.Time
+}
+
+// NewRetryBudget returns a RetryBudget that allows retries retries in any
+// window of the provided duration.
+func NewRetryBudget(retries int, window time.Duration) *RetryBudget {
+	return &RetryBudget{retries: retries, window: window}
+}
+
+// Remaining returns the number of retries currently allowed.
+func (b *RetryBudget) Remaining() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(clockOrDefault(b.Clock).Now())
+	return b.retries - len(b.used)
+}
+
+// Takes a retry from the budget, returning false if there is none left.
+func (b *RetryBudget) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := clockOrDefault(b.Clock).Now()
+	b.expire(now)
+	if len(b.used) >= b.retries {
+		return false
+	}
+	b.used = append(b.used, now)
+	return true
+}
+
+// Forgets the retries that are out of the window.
+func (b *RetryBudget) expire(now time.Time) {
+	i := 0
+	for i < len(b.used) && !now.Before(b.used[i].Add(b.window)) {
+		i++
+	}
+	b.used = b.used[i:]
+}
+
+// IdempotencyKey returns a key derived from the message ids of the events of
+// the request, which stays the same when the request is sent again. Logging
+// it on both ends tells a duplicate delivery (e.g., after an
+// ErrIndeterminate error) from a new event.
+func IdempotencyKey(request *Request) string {
+	h := sha256.New()
+	events := []TypedMessage{request.Event}
+	for _, envelope := range request.batch {
+		events = append(events, envelope.Event)
+	}
+	for _, event := range events {
+		if event != nil && event.GetMessage() != nil {
+			fmt.Fprintf(h, "%s\n", event.GetMessage().header("messageId"))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// Returns whether the request is part of a dialog, so that retrying it can't
+// wait for the budget.
+func inDialog(request *Request) bool {
+	return request.Event != nil && request.Event.GetMessage() != nil && request.Event.GetMessage().header("dialogRequestId") != ""
+}
+
+// DefaultRetryPolicy returns a new RetryPolicy with the recommended actions
+// for the exceptions documented by AVS:
+//
+//	UNAUTHORIZED_REQUEST_EXCEPTION: refresh the access token and retry once.
+//	THROTTLING_EXCEPTION: retry up to 3 times, backing off exponentially
+//	with jitter.
+//	INTERNAL_SERVICE_EXCEPTION: retry up to 3 times with jitter.
+//	INVALID_REQUEST_EXCEPTION: never retry.
+//
+// The returned policy may be modified to override the action for any code.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		Actions: map[ExceptionCode]RetryAction{
+			ExceptionCodeUnauthorizedRequest: {Retries: 1, RefreshToken: true},
+			ExceptionCodeThrottling:          {Retries: 3, Backoff: time.Second, Jitter: true},
+			ExceptionCodeInternalService:     {Retries: 3, Backoff: 500 * time.Millisecond, Jitter: true},
+		},
+	}
+}
+
+// Returns the BackoffPolicy of the Backoff and Jitter of the action.
+func (a RetryAction) backoffPolicy() *BackoffPolicy {
+	p := &BackoffPolicy{Initial: a.Backoff}
+	if a.Jitter {
+		p.Jitter = JitterFull
+	}
+	return p
+}
+
+// Calls op until it succeeds or the policy says that it shouldn't be retried.
+// A nil policy means that op is only called once. The delays are those of
+// backoff, if set, or else of the actions, and the Retry-After delays of
+// the errors take precedence. Unless budgeted is false, every retry is taken
+// from the budget of the policy, and its exhaustion is reported to metrics.
+// The last error is returned as soon as the context is done, or if it would
+// be done before the delay elapses.
+func (p *RetryPolicy) retry(ctx context.Context, clock Clock, metrics *Metrics, backoff *BackoffPolicy, budgeted bool, accessToken string, op func(accessToken string) error) error {
+	retries := make(map[ExceptionCode]int)
+	backoffs := make(map[ExceptionCode]*Backoff)
+	for {
+		err := op(accessToken)
+		if err == nil || p == nil {
+			return err
+		}
+		code, ok := exceptionCode(err)
+		if !ok {
+			return err
+		}
+		action, ok := p.Actions[code]
+		if !ok || retries[code] >= action.Retries {
+			return err
+		}
+		b := backoffs[code]
+		if b == nil {
+			policy := backoff
+			if policy == nil {
+				policy = action.backoffPolicy()
+			}
+			b = policy.start(clock)
+			backoffs[code] = b
+		}
+		d, ok := b.Next(retryAfterOf(err))
+		if !ok {
+			return err
+		}
+		// A retry that can't happen doesn't take from the budget.
+		if action.RefreshToken && p.RefreshToken == nil {
+			return err
+		}
+		if budgeted && p.Budget != nil && !p.Budget.take() {
+			metrics.retryBudgetExhausted(code)
+			return withKind(ErrRetryBudgetExhausted, err)
+		}
+		if action.RefreshToken {
+			token, rerr := p.RefreshToken(accessToken)
+			if rerr != nil {
+				return err
+			}
+			accessToken = token
+		}
+		if sleepContext(ctx, b.clock, d) != nil {
+			return err
+		}
+		retries[code]++
+	}
+}
+
+// Returns the exception code for an error returned by the Client. HTTP
+// statuses without an exception are mapped to the closest code.
+func exceptionCode(err error) (ExceptionCode, bool) {
+	switch e := err.(type) {
+	case *Exception:
+		return e.Payload.Code, true
+	case *RequestError:
+		switch {
+		case e.StatusCode == 403:
+			return ExceptionCodeUnauthorizedRequest, true
+		case e.StatusCode == 429:
+			return ExceptionCodeThrottling, true
+		case e.StatusCode >= 500:
+			return ExceptionCodeInternalService, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,190 @@
+package avs
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Returns a server that always responds with an exception with the provided
+// code, and a counter of the requests it received.
+func newExceptionServer(code ExceptionCode) (*httptest.Server, *int32) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"header":{"namespace":"System","name":"Exception"},"payload":{"code":"%s","description":"test"}}`, code)
+	}))
+	return server, &attempts
+}
+
+// Returns the default policy without any delays.
+func testRetryPolicy() *RetryPolicy {
+	policy := DefaultRetryPolicy()
+	for code, action := range policy.Actions {
+		action.Backoff = 0
+		policy.Actions[code] = action
+	}
+	return policy
+}
+
+func TestRetryPolicyAttempts(t *testing.T) {
+	tests := []struct {
+		code     ExceptionCode
+		refresh  bool
+		attempts int32
+	}{
+		{ExceptionCodeUnauthorizedRequest, true, 2},
+		{ExceptionCodeUnauthorizedRequest, false, 1},
+		{ExceptionCodeThrottling, false, 4},
+		{ExceptionCodeInternalService, false, 4},
+		{ExceptionCodeInvalidRequest, false, 1},
+	}
+	for _, test := range tests {
+		server, attempts := newExceptionServer(test.code)
+		policy := testRetryPolicy()
+		var refreshes int
+		if test.refresh {
+			policy.RefreshToken = func(accessToken string) (string, error) {
+				refreshes++
+				return accessToken + "-refreshed", nil
+			}
+		}
+		client := &Client{EndpointURL: server.URL, RetryPolicy: policy}
+		request := NewRequest("token")
+		request.Event = NewSynchronizeState("abc123")
+		_, err := client.Do(request)
+		server.Close()
+		if e, ok := err.(*Exception); !ok || e.Payload.Code != test.code {
+			t.Errorf("%s: expected exception, got %v", test.code, err)
+		}
+		if *attempts != test.attempts {
+			t.Errorf("%s (refresh %t): got %d attempts; want %d", test.code, test.refresh, *attempts, test.attempts)
+		}
+		if test.refresh && refreshes != 1 {
+			t.Errorf("%s: got %d token refreshes; want 1", test.code, refreshes)
+		}
+	}
+}
+
+func TestRetryPolicyDownchannel(t *testing.T) {
+	server, attempts := newExceptionServer(ExceptionCodeInternalService)
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL, RetryPolicy: testRetryPolicy()}
+	if _, err := client.OpenDownchannel("token"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if *attempts != 4 {
+		t.Errorf("got %d attempts; want 4", *attempts)
+	}
+}
+
+func TestNoRetryPolicy(t *testing.T) {
+	server, attempts := newExceptionServer(ExceptionCodeInternalService)
+	defer server.Close()
+	client := &Client{EndpointURL: server.URL}
+	request := NewRequest("token")
+	request.Event = NewSynchronizeState("abc123")
+	if _, err := client.Do(request); err == nil {
+		t.Fatal("expected an error")
+	}
+	if *attempts != 1 {
+		t.Errorf("got %d attempts; want 1", *attempts)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	server, attempts := newExceptionServer(ExceptionCodeInternalService)
+	defer server.Close()
+	capabilities := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer capabilities.Close()
+	policy := testRetryPolicy()
+	policy.Budget = NewRetryBudget(2, time.Minute)
+	var exhausted []ExceptionCode
+	client := &Client{
+		EndpointURL:     server.URL,
+		CapabilitiesURL: capabilities.URL,
+		RetryPolicy:     policy,
+		Metrics: &Metrics{RetryBudgetExhausted: func(code ExceptionCode) {
+			exhausted = append(exhausted, code)
+		}},
+	}
+	request := NewRequest("token")
+	request.Event = NewSynchronizeState("abc123")
+	_, err := client.Do(request)
+	var exception *Exception
+	if !errors.Is(err, ErrRetryBudgetExhausted) || !errors.As(err, &exception) {
+		t.Errorf("got %v; want an exception with ErrRetryBudgetExhausted", err)
+	}
+	if *attempts != 3 || len(exhausted) != 1 || exhausted[0] != ExceptionCodeInternalService {
+		t.Errorf("got %d attempts and exhaustions %v; want 3 and one", *attempts, exhausted)
+	}
+
+	// The budget is shared with the capabilities, but not with dialogs.
+	if err := client.PublishCapabilities("token", NewDeviceProfile()); !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Errorf("got %v; want ErrRetryBudgetExhausted", err)
+	}
+	atomic.StoreInt32(attempts, 0)
+	request.Event.GetMessage().Header["dialogRequestId"] = "d1"
+	if _, err := client.Do(request); errors.Is(err, ErrRetryBudgetExhausted) || *attempts != 4 {
+		t.Errorf("got %v after %d attempts; want 4 attempts", err, *attempts)
+	}
+	if n := policy.Budget.Remaining(); n != 0 {
+		t.Errorf("got %d retries left; want 0", n)
+	}
+
+	// The retries expire after the window, by the clock of the budget.
+	clock := &fixedClock{now: time.Now()}
+	policy.Budget = NewRetryBudget(2, time.Minute)
+	policy.Budget.Clock = clock
+	request.Event = NewSynchronizeState("abc123")
+	client.Do(request)
+	if n := policy.Budget.Remaining(); n != 0 {
+		t.Errorf("got %d retries left; want 0", n)
+	}
+	clock.now = clock.now.Add(time.Minute)
+	if n := policy.Budget.Remaining(); n != 2 {
+		t.Errorf("got %d retries left after the window; want 2", n)
+	}
+
+	// An action that needs a new token without a RefreshToken isn't retried,
+	// so it takes nothing from the budget.
+	unauthorized, _ := newExceptionServer(ExceptionCodeUnauthorizedRequest)
+	defer unauthorized.Close()
+	client.EndpointURL = unauthorized.URL
+	client.Do(request)
+	if n := policy.Budget.Remaining(); n != 2 {
+		t.Errorf("got %d retries left; want 2", n)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		w.WriteHeader(500)
+	}))
+	defer server.Close()
+	policy := testRetryPolicy()
+	policy.IdempotencyKeyHeader = "Idempotency-Key"
+	client := &Client{EndpointURL: server.URL, RetryPolicy: policy}
+	request := NewRequest("token")
+	request.Event = NewSynchronizeState("abc123")
+	client.Do(request)
+	want := IdempotencyKey(request)
+	if len(keys) != 4 || keys[0] != want || keys[3] != want {
+		t.Errorf("got keys %q; want %q for every attempt", keys, want)
+	}
+	other := NewRequest("token")
+	other.Event = NewSynchronizeState("def456")
+	if IdempotencyKey(other) == want {
+		t.Error("got the same key for another message id")
+	}
+}
@@ -0,0 +1,103 @@
+package avs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// The Store namespace and key of the first sequence number that may be used
+// after a restart.
+const (
+	sequenceStoreNamespace = "Sequence"
+	sequenceStoreKey       = "next"
+)
+
+// The number of sequence numbers reserved at a time by default.
+const defaultSequenceReserve = 1000
+
+// Sequencer numbers the events sent by a Client, so that their order can be
+// told from device logs without trusting the device clock. The numbers
+// increase across restarts and never repeat.
+//
+// To avoid writing to the Store for every event, numbers are reserved in
+// ranges: the end of the current range is saved before any of its numbers is
+// used, and the unused numbers of a range are skipped after a restart.
+type Sequencer struct {
+	// HeaderField, if set, is the header field of the events in which the
+	// number is sent to AVS (in decimal). Otherwise the number is only in the
+	// metadata of the events (see Message.Metadata and FormatMessage).
+	HeaderField string
+
+	store   Store
+	reserve uint64
+
+	mu    sync.Mutex
+	next  uint64
+	limit uint64
+}
+
+// NewSequencer returns a new Sequencer that continues the sequence saved in
+// the store, reserving reserve numbers at a time (1000 if zero or less).
+func NewSequencer(store Store, reserve int) (*Sequencer, error) {
+	s := &Sequencer{store: store, reserve: defaultSequenceReserve, next: 1, limit: 1}
+	if reserve > 0 {
+		s.reserve = uint64(reserve)
+	}
+	data, err := store.Get(sequenceStoreNamespace, sequenceStoreKey)
+	if errors.Is(err, ErrNotFound) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	next, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil || next == 0 {
+		return nil, fmt.Errorf("avs: invalid saved sequence number %q", data)
+	}
+	s.next, s.limit = next, next
+	return s, nil
+}
+
+// Next returns the next sequence number.
+func (s *Sequencer) Next() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next == s.limit {
+		limit := s.limit + s.reserve
+		if err := s.store.Put(sequenceStoreNamespace, sequenceStoreKey, []byte(strconv.FormatUint(limit, 10))); err != nil {
+			return 0, err
+		}
+		s.limit = limit
+	}
+	n := s.next
+	s.next++
+	return n, nil
+}
+
+// Numbers the events of the request.
+func (s *Sequencer) stamp(request *Request) error {
+	events := []TypedMessage{request.Event}
+	for _, envelope := range request.batch {
+		events = append(events, envelope.Event)
+	}
+	for _, event := range events {
+		if event == nil || event.GetMessage() == nil {
+			continue
+		}
+		n, err := s.Next()
+		if err != nil {
+			return err
+		}
+		m := event.GetMessage()
+		m.sequence = n
+		if s.HeaderField != "" {
+			if m.Header == nil {
+				m.Header = make(map[string]string)
+			}
+			m.Header[s.HeaderField] = strconv.FormatUint(n, 10)
+		}
+	}
+	return nil
+}
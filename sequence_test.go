@@ -0,0 +1,130 @@
+package avs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Counts the writes to the underlying store.
+type countingStore struct {
+	Store
+	puts int
+}
+
+func (s *countingStore) Put(namespace, key string, value []byte) error {
+	s.puts++
+	return s.Store.Put(namespace, key, value)
+}
+
+func TestSequencer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "avs-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs, _ := NewFileStore(dir, nil)
+	store := &countingStore{Store: fs}
+
+	s, err := NewSequencer(store, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last uint64
+	for i := 0; i < 25; i++ {
+		n, err := s.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != last+1 {
+			t.Fatalf("Next() = %d after %d", n, last)
+		}
+		last = n
+	}
+	if store.puts != 3 {
+		t.Errorf("%d writes to the store for 25 numbers in ranges of 10; want 3", store.puts)
+	}
+
+	// After a restart, the rest of the reserved range is skipped.
+	s, err = NewSequencer(store, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := s.Next(); n != 31 {
+		t.Errorf("Next() after a restart = %d; want 31", n)
+	}
+}
+
+func TestSequencerInvalidStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "avs-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs, _ := NewFileStore(dir, nil)
+	fs.Put(sequenceStoreNamespace, sequenceStoreKey, []byte("garbage"))
+	if _, err := NewSequencer(fs, 0); err == nil {
+		t.Errorf("expected an error for an invalid saved sequence number")
+	}
+}
+
+func TestClientSequencer(t *testing.T) {
+	var headers []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			t.Errorf("unexpected request: %v", err)
+			return
+		}
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Errorf("no metadata part: %v", err)
+			return
+		}
+		var metadata struct {
+			Event *Message `json:"event"`
+		}
+		json.NewDecoder(p).Decode(&metadata)
+		headers = append(headers, metadata.Event.Header)
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "avs-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs, _ := NewFileStore(dir, nil)
+	s, _ := NewSequencer(fs, 0)
+	s.HeaderField = "x-sequence"
+	c, err := NewClient(WithEndpointURL(server.URL), WithSequencer(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []*MuteChanged
+	for i := 0; i < 2; i++ {
+		request := NewRequest("token")
+		event := NewMuteChanged(RandomUUIDString(), 50, true)
+		request.Event = event
+		if _, err := c.Do(request); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	for i, want := range []string{"1", "2"} {
+		if i >= len(headers) || headers[i]["x-sequence"] != want {
+			t.Errorf("event %d sent with header %v; want x-sequence %s", i, headers, want)
+		}
+		if seq := events[i].Metadata().Sequence; seq != uint64(i+1) {
+			t.Errorf("event %d has sequence %d in its metadata; want %d", i, seq, i+1)
+		}
+	}
+	if s := FormatMessage(events[1]); !strings.Contains(s, " seq=2") {
+		t.Errorf("FormatMessage = %q; want seq=2", s)
+	}
+}
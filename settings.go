@@ -0,0 +1,40 @@
+package avs
+
+// The events of the Settings interface.
+var TypeSettingsUpdated = MessageType{"Settings", "SettingsUpdated"}
+
+func init() {
+	register(TypeSettingsUpdated, SettingsUpdated{})
+}
+
+// The SettingsUpdated event.
+type Setting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type SettingsUpdated struct {
+	*Message
+	Payload struct {
+		Settings []Setting `json:"settings"`
+	} `json:"payload"`
+}
+
+type SettingLocale string
+
+// Possible values for SettingLocale.
+const (
+	SettingLocaleUS = SettingLocale("en-US")
+	SettingLocaleGB = SettingLocale("en-GB")
+	SettingLocaleDE = SettingLocale("de-DE")
+)
+
+func NewLocaleSettingsUpdated(messageId string, locale SettingLocale, opts ...HeaderOption) *SettingsUpdated {
+	m := new(SettingsUpdated)
+	m.Message = newEvent("Settings", "SettingsUpdated", messageId, "", opts...)
+	m.Payload.Settings = append(m.Payload.Settings, Setting{
+		Key:   "locale",
+		Value: string(locale),
+	})
+	return m
+}
@@ -0,0 +1,273 @@
+//go:build soak
+
+package avs
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The soak test runs a client against a fake AVS that keeps disconnecting,
+// rotating connections, throttling and bursting directives, then checks that
+// nothing leaked. Run it with:
+//
+//	go test -tags soak -run TestSoak -soak.duration 24h -timeout 0
+var (
+	soakDuration = flag.Duration("soak.duration", 30*time.Second, "how long the soak test runs")
+	soakSeed     = flag.Int64("soak.seed", 0, "seed of the faults; zero for the current time")
+)
+
+// How far the resources may be above the baseline after the soak test.
+const (
+	soakGoroutineSlack = 4
+	soakFileSlack      = 4
+	soakHeapSlack      = 16 << 20
+)
+
+// A fake AVS that bursts directives on the open downchannels on demand and
+// randomly throttles events.
+type soakServer struct {
+	*goAwayServer
+	rand *lockedRand
+
+	mu      sync.Mutex
+	streams map[chan int]bool
+}
+
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (r *lockedRand) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Intn(n)
+}
+
+func newSoakServer(t *testing.T, r *lockedRand) *soakServer {
+	s := &soakServer{rand: r, streams: make(map[chan int]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc(DirectivesPath, s.handleDirectives)
+	mux.HandleFunc(EventsPath, s.handleEvent)
+	mux.HandleFunc(PingPath, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	})
+	s.goAwayServer = newGoAwayServer(t, mux)
+	return s
+}
+
+func (s *soakServer) handleDirectives(w http.ResponseWriter, r *http.Request) {
+	bursts := make(chan int, 1)
+	s.mu.Lock()
+	s.streams[bursts] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.streams, bursts)
+		s.mu.Unlock()
+	}()
+	w.Header().Set("Content-Type", "multipart/related; boundary=------abcde123; type=application/json")
+	w.WriteHeader(200)
+	fmt.Fprint(w, "--------abcde123\r\n")
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case n := <-bursts:
+			for i := 0; i < n; i++ {
+				fmt.Fprintf(w, "Content-Type: application/json\r\n\r\n"+
+					`{"directive":{"header":{"namespace":"Speaker","name":"SetVolume","messageId":"%s"},"payload":{"volume":%d}}}`+
+					"\r\n--------abcde123\r\n", RandomUUIDString(), i%100)
+			}
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *soakServer) handleEvent(w http.ResponseWriter, r *http.Request) {
+	io.Copy(ioutil.Discard, r.Body)
+	switch n := s.rand.Intn(10); {
+	case n < 2:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(429)
+		fmt.Fprint(w, `{"header":{"namespace":"System","name":"Exception"},"payload":{"code":"THROTTLING_EXCEPTION","description":"slow down"}}`)
+	case n < 4:
+		w.Header().Set("Content-Type", "multipart/related; boundary=------abcde123; type=application/json")
+		w.WriteHeader(200)
+		fmt.Fprint(w, speakAndExpectSpeech)
+	default:
+		w.WriteHeader(204)
+	}
+}
+
+// Sends n directives on every open downchannel.
+func (s *soakServer) burst(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for bursts := range s.streams {
+		select {
+		case bursts <- n:
+		default:
+		}
+	}
+}
+
+// Closes every connection without a GOAWAY, as when the network drops.
+func (s *soakServer) disconnect() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = nil
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// The resources of the process that a leak increases.
+type resourceUsage struct {
+	goroutines int
+	files      int
+	heap       uint64
+}
+
+func measureResources() resourceUsage {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	files := -1
+	if entries, err := ioutil.ReadDir("/proc/self/fd"); err == nil {
+		files = len(entries)
+	}
+	return resourceUsage{goroutines: runtime.NumGoroutine(), files: files, heap: stats.HeapAlloc}
+}
+
+// Waits for the resources to come back within the slack of the baseline, as
+// goroutines and connections take a moment to wind down, and fails the test
+// with a dump of the goroutines if they don't.
+func checkResources(t *testing.T, baseline resourceUsage) {
+	t.Helper()
+	var usage resourceUsage
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		usage = measureResources()
+		if usage.goroutines <= baseline.goroutines+soakGoroutineSlack &&
+			usage.files <= baseline.files+soakFileSlack &&
+			usage.heap <= baseline.heap+soakHeapSlack {
+			return
+		}
+	}
+	if usage.goroutines > baseline.goroutines+soakGoroutineSlack {
+		buf := make([]byte, 1<<20)
+		buf = buf[:runtime.Stack(buf, true)]
+		t.Errorf("%d goroutines, %d before the soak test:\n%s", usage.goroutines, baseline.goroutines, buf)
+	}
+	if usage.files > baseline.files+soakFileSlack {
+		t.Errorf("%d open files, %d before the soak test", usage.files, baseline.files)
+	}
+	if usage.heap > baseline.heap+soakHeapSlack {
+		t.Errorf("%d bytes of heap, %d before the soak test", usage.heap, baseline.heap)
+	}
+}
+
+func TestSoak(t *testing.T) {
+	seed := *soakSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Logf("soaking for %s with seed %d", *soakDuration, seed)
+	r := &lockedRand{r: rand.New(rand.NewSource(seed))}
+	baseline := measureResources()
+
+	server := newSoakServer(t, r)
+	roots := tr.TLSClientConfig.RootCAs
+	tr.TLSClientConfig.RootCAs = server.roots
+	defer func() {
+		tr.TLSClientConfig.RootCAs = roots
+	}()
+	client := &Client{
+		EndpointURL:         server.URL,
+		DirectiveBufferSize: 16,
+		Backpressure:        BackpressureDropOldestNonDialog,
+		RetryPolicy: &RetryPolicy{Actions: map[ExceptionCode]RetryAction{
+			ExceptionCodeThrottling: {Retries: 1, Backoff: time.Millisecond},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *soakDuration)
+	defer cancel()
+	var directives, reconnects, events, failures int64
+	var wg sync.WaitGroup
+	wg.Add(2)
+	// The application: keeps a downchannel open, reconnecting when it drops.
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			d, err := client.OpenDownchannel("token")
+			if err != nil {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			atomic.AddInt64(&reconnects, 1)
+			finished := make(chan struct{})
+			go func() {
+				select {
+				case <-ctx.Done():
+					d.Close()
+				case <-finished:
+				}
+			}()
+			for range d.Directives {
+				atomic.AddInt64(&directives, 1)
+			}
+			close(finished)
+			d.Close()
+		}
+	}()
+	// The user: sends events now and then.
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			request := NewRequest("token")
+			request.Event = NewRecognize(RandomUUIDString(), RandomUUIDString())
+			request.Audio = strings.NewReader("audio")
+			requestCtx, cancelRequest := context.WithTimeout(ctx, 5*time.Second)
+			if response, err := client.DoContext(requestCtx, request); err == nil {
+				response.Close()
+				atomic.AddInt64(&events, 1)
+			} else {
+				atomic.AddInt64(&failures, 1)
+			}
+			cancelRequest()
+			time.Sleep(time.Duration(r.Intn(20)) * time.Millisecond)
+		}
+	}()
+	// The network and AVS.
+	for ctx.Err() == nil {
+		switch r.Intn(10) {
+		case 0:
+			server.GoAway(uint32(r.Intn(2)) * 1000)
+		case 1:
+			server.disconnect()
+		default:
+			server.burst(r.Intn(50))
+		}
+		time.Sleep(time.Duration(10+r.Intn(40)) * time.Millisecond)
+	}
+	wg.Wait()
+	server.Close()
+	tr.CloseIdleConnections()
+	t.Logf("%d directives, %d downchannels, %d events sent, %d failed", directives, reconnects, events, failures)
+	checkResources(t, baseline)
+}
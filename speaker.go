@@ -0,0 +1,101 @@
+package avs
+
+// The directives of the Speaker interface.
+var (
+	TypeAdjustVolume = MessageType{"Speaker", "AdjustVolume"}
+	TypeSetMute      = MessageType{"Speaker", "SetMute"}
+	TypeSetVolume    = MessageType{"Speaker", "SetVolume"}
+)
+
+// The events of the Speaker interface.
+var (
+	TypeMuteChanged   = MessageType{"Speaker", "MuteChanged"}
+	TypeVolumeChanged = MessageType{"Speaker", "VolumeChanged"}
+)
+
+// The context of the Speaker interface.
+var TypeVolumeState = MessageType{"Speaker", "VolumeState"}
+
+func init() {
+	registerDirective(TypeAdjustVolume, AdjustVolume{})
+	registerDirective(TypeSetMute, SetMute{})
+	registerDirective(TypeSetVolume, SetVolume{})
+	register(TypeMuteChanged, MuteChanged{})
+	register(TypeVolumeChanged, VolumeChanged{})
+	register(TypeVolumeState, VolumeState{})
+}
+
+// The AdjustVolume directive.
+type AdjustVolume struct {
+	*Message
+	Payload struct {
+		Volume int `json:"volume"`
+	} `json:"payload"`
+}
+
+// The SetMute directive.
+type SetMute struct {
+	*Message
+	Payload struct {
+		Mute bool `json:"mute"`
+	} `json:"payload"`
+}
+
+// The SetVolume directive.
+type SetVolume struct {
+	*Message
+	Payload struct {
+		Volume int `json:"volume"`
+	} `json:"payload"`
+}
+
+// The MuteChanged event.
+type MuteChanged struct {
+	*Message
+	Payload struct {
+		Volume int  `json:"volume"`
+		Muted  bool `json:"muted"`
+	} `json:"payload"`
+}
+
+func NewMuteChanged(messageId string, volume int, muted bool, opts ...HeaderOption) *MuteChanged {
+	m := new(MuteChanged)
+	m.Message = newEvent("Speaker", "MuteChanged", messageId, "", opts...)
+	m.Payload.Volume = volume
+	m.Payload.Muted = muted
+	return m
+}
+
+// The VolumeChanged event.
+type VolumeChanged struct {
+	*Message
+	Payload struct {
+		Volume int  `json:"volume"`
+		Muted  bool `json:"muted"`
+	} `json:"payload"`
+}
+
+func NewVolumeChanged(messageId string, volume int, muted bool, opts ...HeaderOption) *VolumeChanged {
+	m := new(VolumeChanged)
+	m.Message = newEvent("Speaker", "VolumeChanged", messageId, "", opts...)
+	m.Payload.Volume = volume
+	m.Payload.Muted = muted
+	return m
+}
+
+// The VolumeState context.
+type VolumeState struct {
+	*Message
+	Payload struct {
+		Volume int  `json:"volume"`
+		Muted  bool `json:"muted"`
+	} `json:"payload"`
+}
+
+func NewVolumeState(volume int, muted bool, opts ...HeaderOption) *VolumeState {
+	m := new(VolumeState)
+	m.Message = newContext("Speaker", "VolumeState", opts...)
+	m.Payload.Volume = volume
+	m.Payload.Muted = muted
+	return m
+}
@@ -0,0 +1,139 @@
+package avs
+
+import (
+	"sync"
+	"time"
+)
+
+// SpeechTracker keeps track of the Speak directive being played and provides
+// the SpeechState context, with the offset computed from the time the speech
+// started.
+//
+// An interrupted speech is reported differently depending on what interrupted
+// it. When the user stops it on the device, the speech is reported with
+// InterruptionEvent. When a new dialog barges in, it isn't reported at all:
+// the Recognize event of the new dialog carries the SpeechState context,
+// which gives the offset at which the speech stopped; call StartDialog before
+// gathering it.
+type SpeechTracker struct {
+	// Clock, if set, replaces the system clock. It must be set before the
+	// first call to Started.
+	Clock Clock
+
+	mu              sync.Mutex
+	token           string
+	dialogRequestId string
+	// The time the speech started, while it plays.
+	started time.Time
+	// The offset at which the last speech stopped.
+	offset   time.Duration
+	activity PlayerActivity
+}
+
+// NewSpeechTracker returns a SpeechTracker with no speech.
+func NewSpeechTracker() *SpeechTracker {
+	return &SpeechTracker{activity: PlayerActivityFinished}
+}
+
+// Started records that the speech of the directive started playing.
+func (t *SpeechTracker) Started(speak *Speak) {
+	dialogRequestId, _ := speak.DialogRequestId()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = speak.Payload.Token
+	t.dialogRequestId = dialogRequestId
+	t.started = clockOrDefault(t.Clock).Now()
+	t.offset = 0
+	t.activity = PlayerActivityPlaying
+}
+
+// Finished records that the speech of the directive played to its end. It
+// does nothing if the speech isn't the one being played.
+func (t *SpeechTracker) Finished(speak *Speak) {
+	t.stop(speak.Payload.Token)
+}
+
+// Interrupted records that the speech of the directive stopped before its
+// end. It does nothing if the speech isn't the one being played.
+func (t *SpeechTracker) Interrupted(speak *Speak) {
+	t.stop(speak.Payload.Token)
+}
+
+// Failed records that the speech of the directive couldn't be played at all
+// (e.g., its attachment was missing or couldn't be decoded). The speech is
+// FINISHED at offset 0: the SpeechState context has no error activity, so the
+// failure itself should be reported with ExceptionEncountered.
+func (t *SpeechTracker) Failed(speak *Speak) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = speak.Payload.Token
+	t.dialogRequestId, _ = speak.DialogRequestId()
+	t.started = time.Time{}
+	t.offset = 0
+	t.activity = PlayerActivityFinished
+}
+
+// StartDialog records that a new dialog started. The speech of another
+// dialog that's still playing is interrupted at its current offset, without
+// an event.
+func (t *SpeechTracker) StartDialog(dialogRequestId string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.activity == PlayerActivityPlaying && t.dialogRequestId != dialogRequestId {
+		t.stopLocked()
+	}
+}
+
+// InterruptionEvent records that the speech being played, if any, was
+// stopped on the device (e.g., by a barge-in or a stop button) at its
+// current offset, and returns the event reporting the interruption, which is
+// always nil: SpeechFinished is only for speech that played to its end, and
+// SpeechSynthesizer 1.0 has no event for an interruption. AVS learns of it
+// from the SpeechState context of the next event, which is FINISHED at the
+// offset where the speech stopped.
+func (t *SpeechTracker) InterruptionEvent(messageId string) TypedMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.activity == PlayerActivityPlaying {
+		t.stopLocked()
+	}
+	return nil
+}
+
+// State returns the token of the last speech, its offset and its activity.
+func (t *SpeechTracker) State() (token string, offset time.Duration, activity PlayerActivity) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.token, t.offsetLocked(), t.activity
+}
+
+// Context returns the SpeechState context.
+func (t *SpeechTracker) Context() (TypedMessage, error) {
+	return NewSpeechState(t.State()), nil
+}
+
+func (t *SpeechTracker) stop(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.activity == PlayerActivityPlaying && t.token == token {
+		t.stopLocked()
+	}
+}
+
+// Freezes the offset of the speech being played. t.mu must be held.
+func (t *SpeechTracker) stopLocked() {
+	t.offset = t.offsetLocked()
+	t.started = time.Time{}
+	t.activity = PlayerActivityFinished
+}
+
+// Returns the offset of the speech. t.mu must be held.
+func (t *SpeechTracker) offsetLocked() time.Duration {
+	if t.activity != PlayerActivityPlaying {
+		return t.offset
+	}
+	if elapsed := clockOrDefault(t.Clock).Now().Sub(t.started); elapsed > 0 {
+		return elapsed
+	}
+	return 0
+}
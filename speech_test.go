@@ -0,0 +1,85 @@
+package avs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+	"github.com/fika-io/go-avs/avstest"
+)
+
+func TestSpeechTracker(t *testing.T) {
+	clock := avstest.NewFakeClock(time.Date(2017, 5, 16, 12, 0, 0, 0, time.UTC))
+	tracker := avs.NewSpeechTracker()
+	tracker.Clock = clock
+	if event := tracker.InterruptionEvent("m1"); event != nil {
+		t.Errorf("got %v without a speech; want nil", event)
+	}
+
+	// The offset follows the clock while the speech plays.
+	first := avstest.Speak("first")
+	avstest.SetDialogRequestId(first, "d1")
+	tracker.Started(first)
+	clock.Advance(1500 * time.Millisecond)
+	context, err := tracker.Context()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := context.(*avs.SpeechState)
+	if state.Payload.Token != "speak-first" || state.Payload.OffsetInMilliseconds != 1500 || state.Payload.PlayerActivity != avs.PlayerActivityPlaying {
+		t.Errorf("got %+v; want speak-first playing at 1500ms", state.Payload)
+	}
+
+	// Stopping it on the device freezes its offset, like a barge-in, and
+	// isn't reported with SpeechFinished.
+	if event := tracker.InterruptionEvent("m2"); event != nil {
+		t.Fatalf("got %v for an interrupted speech; want nil", event)
+	}
+	clock.Advance(time.Second)
+	if token, offset, activity := tracker.State(); token != "speak-first" || offset != 1500*time.Millisecond || activity != avs.PlayerActivityFinished {
+		t.Errorf("got %s at %s, %s; want speak-first finished at 1.5s", token, offset, activity)
+	}
+	if event := tracker.InterruptionEvent("m3"); event != nil {
+		t.Errorf("got %v for a finished speech; want nil", event)
+	}
+
+	// Another speech of the same dialog keeps playing, one of an earlier
+	// dialog stops without an event.
+	second := avstest.Speak("second")
+	avstest.SetDialogRequestId(second, "d1")
+	tracker.Started(second)
+	clock.Advance(time.Second)
+	tracker.StartDialog("d1")
+	if _, _, activity := tracker.State(); activity != avs.PlayerActivityPlaying {
+		t.Errorf("got %s after the same dialog; want PLAYING", activity)
+	}
+	tracker.StartDialog("d2")
+	clock.Advance(time.Second)
+	if token, offset, activity := tracker.State(); token != "speak-second" || offset != time.Second || activity != avs.PlayerActivityFinished {
+		t.Errorf("got %s at %s, %s after a barge-in; want speak-second finished at 1s", token, offset, activity)
+	}
+	if event := tracker.InterruptionEvent("m4"); event != nil {
+		t.Errorf("got %v after a barge-in; want nil", event)
+	}
+
+	// A speech that couldn't be played finished at 0.
+	third := avstest.Speak("third")
+	tracker.Failed(third)
+	if token, offset, activity := tracker.State(); token != "speak-third" || offset != 0 || activity != avs.PlayerActivityFinished {
+		t.Errorf("got %s at %s, %s; want speak-third finished at 0", token, offset, activity)
+	}
+
+	// Only the speech being played is stopped.
+	fourth := avstest.Speak("fourth")
+	tracker.Started(fourth)
+	clock.Advance(time.Second)
+	tracker.Finished(third)
+	if _, _, activity := tracker.State(); activity != avs.PlayerActivityPlaying {
+		t.Errorf("got %s after another speech finished; want PLAYING", activity)
+	}
+	clock.Advance(time.Second)
+	tracker.Finished(fourth)
+	if token, offset, activity := tracker.State(); token != "speak-fourth" || offset != 2*time.Second || activity != avs.PlayerActivityFinished {
+		t.Errorf("got %s at %s, %s; want speak-fourth finished at 2s", token, offset, activity)
+	}
+}
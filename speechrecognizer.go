@@ -0,0 +1,221 @@
+package avs
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// The directives of the SpeechRecognizer interface.
+var (
+	TypeExpectSpeech = MessageType{"SpeechRecognizer", "ExpectSpeech"}
+	TypeSetWakeWords = MessageType{"SpeechRecognizer", "SetWakeWords"}
+	TypeStopCapture  = MessageType{"SpeechRecognizer", "StopCapture"}
+)
+
+// The events of the SpeechRecognizer interface.
+var (
+	TypeExpectSpeechTimedOut            = MessageType{"SpeechRecognizer", "ExpectSpeechTimedOut"}
+	TypeRecognize                       = MessageType{"SpeechRecognizer", "Recognize"}
+	TypeReportEchoSpatialPerceptionData = MessageType{"SpeechRecognizer", "ReportEchoSpatialPerceptionData"}
+	TypeWakeWordsChanged                = MessageType{"SpeechRecognizer", "WakeWordsChanged"}
+)
+
+// The context of the SpeechRecognizer interface.
+var TypeRecognizerState = MessageType{"SpeechRecognizer", "RecognizerState"}
+
+func init() {
+	registerDirective(TypeExpectSpeech, ExpectSpeech{})
+	registerDirective(TypeSetWakeWords, SetWakeWords{})
+	registerDirective(TypeStopCapture, StopCapture{})
+	register(TypeExpectSpeechTimedOut, ExpectSpeechTimedOut{})
+	register(TypeRecognize, Recognize{})
+	register(TypeReportEchoSpatialPerceptionData, ReportEchoSpatialPerceptionData{})
+	register(TypeWakeWordsChanged, WakeWordsChanged{})
+	register(TypeRecognizerState, RecognizerState{})
+}
+
+// The ExpectSpeech directive.
+type ExpectSpeech struct {
+	*Message
+	Payload struct {
+		TimeoutInMilliseconds int `json:"timeoutInMilliseconds"`
+	} `json:"payload"`
+}
+
+func (m *ExpectSpeech) Timeout() time.Duration {
+	return time.Duration(m.Payload.TimeoutInMilliseconds) * time.Millisecond
+}
+
+// ScopedWakeWords is a set of wake words and the scopes in which they apply,
+// as in the payloads of SetWakeWords and WakeWordsChanged. Unlike in the
+// WakeWords of the capability configurations, each value is one wake word.
+type ScopedWakeWords struct {
+	Scopes []string `json:"scopes"`
+	Values []string `json:"values"`
+}
+
+// The SetWakeWords directive, which changes the wake words that the device
+// responds to (e.g., from the companion app). It's part of SpeechRecognizer
+// 2.1.
+type SetWakeWords struct {
+	*Message
+	Payload struct {
+		WakeWords []ScopedWakeWords `json:"wakeWords"`
+	} `json:"payload"`
+}
+
+// WakeWordsIn returns the wake words of the directive that apply in the
+// scope (e.g., WakeWordScope).
+func (m *SetWakeWords) WakeWordsIn(scope string) []string {
+	var wakeWords []string
+	for _, w := range m.Payload.WakeWords {
+		for _, s := range w.Scopes {
+			if s == scope {
+				wakeWords = append(wakeWords, w.Values...)
+				break
+			}
+		}
+	}
+	return wakeWords
+}
+
+// The StopCapture directive.
+type StopCapture struct {
+	*Message
+	Payload struct{} `json:"payload"`
+}
+
+// The ExpectSpeechTimedOut event.
+type ExpectSpeechTimedOut struct {
+	*Message
+	Payload struct{} `json:"payload"`
+}
+
+func NewExpectSpeechTimedOut(messageId string, opts ...HeaderOption) *ExpectSpeechTimedOut {
+	m := new(ExpectSpeechTimedOut)
+	m.Message = newEvent("SpeechRecognizer", "ExpectSpeechTimedOut", messageId, "", opts...)
+	return m
+}
+
+// RecognizeProfile identifies the ASR profile associated with your product.
+type RecognizeProfile string
+
+// Possible values for RecognizeProfile.
+// Supports three distinct profiles optimized for speech at varying distances.
+const (
+	RecognizeProfileCloseTalk = RecognizeProfile("CLOSE_TALK")
+	RecognizeProfileNearField = RecognizeProfile("NEAR_FIELD")
+	RecognizeProfileFarField  = RecognizeProfile("FAR_FIELD")
+)
+
+// The Recognize event.
+type Recognize struct {
+	*Message
+	Payload struct {
+		Profile RecognizeProfile `json:"profile"`
+		Format  string           `json:"format"`
+		// Only in version 2 of the event.
+		Initiator *Initiator `json:"initiator,omitempty"`
+	} `json:"payload"`
+}
+
+func NewRecognize(messageId, dialogRequestId string, opts ...HeaderOption) *Recognize {
+	return NewRecognizeWithProfile(messageId, dialogRequestId, RecognizeProfileCloseTalk, opts...)
+}
+
+func NewRecognizeWithProfile(messageId, dialogRequestId string, profile RecognizeProfile, opts ...HeaderOption) *Recognize {
+	m := new(Recognize)
+	m.Message = newEvent("SpeechRecognizer", "Recognize", messageId, dialogRequestId, opts...)
+	m.Payload.Format = "AUDIO_L16_RATE_16000_CHANNELS_1"
+	m.Payload.Profile = profile
+	return m
+}
+
+// The ReportEchoSpatialPerceptionData event, used by far-field devices to
+// report the energy of the voice and the ambient noise for a wake word.
+type ReportEchoSpatialPerceptionData struct {
+	*Message
+	Payload struct {
+		VoiceEnergy   float64 `json:"voiceEnergy"`
+		AmbientEnergy float64 `json:"ambientEnergy"`
+	} `json:"payload"`
+}
+
+func NewReportEchoSpatialPerceptionData(messageId string, voiceEnergy, ambientEnergy float64, opts ...HeaderOption) *ReportEchoSpatialPerceptionData {
+	m := new(ReportEchoSpatialPerceptionData)
+	m.Message = newEvent("SpeechRecognizer", "ReportEchoSpatialPerceptionData", messageId, "", opts...)
+	m.Payload.VoiceEnergy = voiceEnergy
+	m.Payload.AmbientEnergy = ambientEnergy
+	return m
+}
+
+// MarshalJSON implements the json.Marshaler interface. The energy values are
+// never encoded in exponent notation, which AVS rejects.
+func (m *ReportEchoSpatialPerceptionData) MarshalJSON() ([]byte, error) {
+	var payload struct {
+		VoiceEnergy   plainFloat `json:"voiceEnergy"`
+		AmbientEnergy plainFloat `json:"ambientEnergy"`
+	}
+	payload.VoiceEnergy = plainFloat(m.Payload.VoiceEnergy)
+	payload.AmbientEnergy = plainFloat(m.Payload.AmbientEnergy)
+	return json.Marshal(struct {
+		*Message
+		Payload interface{} `json:"payload"`
+	}{m.Message, payload})
+}
+
+// A float64 that's encoded without exponent notation.
+type plainFloat float64
+
+func (f plainFloat) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return nil, withKind(ErrInvalidMessage, fmt.Errorf("avs: unsupported number %v", float64(f)))
+	}
+	return strconv.AppendFloat(nil, float64(f), 'f', -1, 64), nil
+}
+
+// The WakeWordsChanged event, sent when the wake words of the device change.
+// It's part of SpeechRecognizer 2.1.
+type WakeWordsChanged struct {
+	*Message
+	Payload struct {
+		WakeWords []ScopedWakeWords `json:"wakeWords"`
+	} `json:"payload"`
+}
+
+// NewWakeWordsChanged returns a WakeWordsChanged event with the wake words in
+// the default scope.
+func NewWakeWordsChanged(messageId string, wakeWords []string, opts ...HeaderOption) *WakeWordsChanged {
+	m := new(WakeWordsChanged)
+	m.Message = newEvent("SpeechRecognizer", "WakeWordsChanged", messageId, "", opts...)
+	m.Payload.WakeWords = []ScopedWakeWords{{Scopes: []string{WakeWordScope}, Values: wakeWords}}
+	return m
+}
+
+// The wake words that AVS recognizes.
+const (
+	WakeWordAlexa    = "ALEXA"
+	WakeWordComputer = "COMPUTER"
+	WakeWordEcho     = "ECHO"
+)
+
+// DefaultWakeWord is the wake word reported by DefaultContexts.
+const DefaultWakeWord = WakeWordAlexa
+
+// The RecognizerState context.
+type RecognizerState struct {
+	*Message
+	Payload struct {
+		Wakeword string `json:"wakeword"`
+	} `json:"payload"`
+}
+
+func NewRecognizerState(wakeword string, opts ...HeaderOption) *RecognizerState {
+	m := new(RecognizerState)
+	m.Message = newContext("SpeechRecognizer", "RecognizerState", opts...)
+	m.Payload.Wakeword = wakeword
+	return m
+}
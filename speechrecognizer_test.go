@@ -0,0 +1,124 @@
+package avs
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReportEchoSpatialPerceptionDataJSON(t *testing.T) {
+	m := NewReportEchoSpatialPerceptionData("m1", 0.0000001, 1e21)
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"header":{"messageId":"m1","name":"ReportEchoSpatialPerceptionData","namespace":"SpeechRecognizer"},` +
+		`"payload":{"voiceEnergy":0.0000001,"ambientEnergy":1000000000000000000000}}`
+	if string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+	for _, v := range []float64{math.Inf(1), math.NaN()} {
+		m.Payload.AmbientEnergy = v
+		if _, err := json.Marshal(m); err == nil {
+			t.Errorf("%v should fail to encode", v)
+		}
+	}
+}
+
+func TestEchoSpatialPerceptionBeforeRecognize(t *testing.T) {
+	server, names := newEventServer(t)
+	defer server.Close()
+	measure := true
+	client := &Client{
+		EndpointURL: server.URL,
+		EchoSpatialPerception: func() (float64, float64, bool) {
+			return 12.5, 3.25, measure
+		},
+	}
+	send := func(event TypedMessage) {
+		request := NewRequest("token")
+		request.Event = event
+		if _, err := client.Do(request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(NewRecognize("m1", "d1"))
+	send(NewSynchronizeState("m2"))
+	measure = false
+	send(NewRecognize("m3", "d2"))
+	want := []string{"ReportEchoSpatialPerceptionData", "Recognize", "SynchronizeState", "Recognize"}
+	if got := names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+}
+
+// The ESP measurements aren't paced by the rate limiter, and failing to send
+// them doesn't fail the Recognize event.
+func TestEchoSpatialPerceptionUnpaced(t *testing.T) {
+	var mu sync.Mutex
+	var names []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			t.Errorf("unexpected request: %v", err)
+			return
+		}
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Errorf("unexpected request: %v", err)
+			return
+		}
+		var metadata struct {
+			Event *Message `json:"event"`
+		}
+		json.NewDecoder(p).Decode(&metadata)
+		name := metadata.Event.Header["name"]
+		mu.Lock()
+		names = append(names, name)
+		mu.Unlock()
+		if name == "ReportEchoSpatialPerceptionData" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	client := &Client{
+		EndpointURL: server.URL,
+		RateLimiter: NewRateLimiter(0.001, 1),
+		EchoSpatialPerception: func() (float64, float64, bool) {
+			return 12.5, 3.25, true
+		},
+	}
+	// Use up the only token of the limiter.
+	request := NewRequest("token")
+	request.Event = NewSynchronizeState("m1")
+	if _, err := client.Do(request); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		request := NewRequest("token")
+		request.Event = NewRecognize("m2", "d1")
+		_, err := client.Do(request)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("got %v; want the Recognize event to succeed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the ESP measurements waited for the rate limiter")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"SynchronizeState", "ReportEchoSpatialPerceptionData", "Recognize"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got events %v, want %v", names, want)
+	}
+}
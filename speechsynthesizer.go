@@ -0,0 +1,90 @@
+package avs
+
+import (
+	"time"
+)
+
+// The directives of the SpeechSynthesizer interface.
+var TypeSpeak = MessageType{"SpeechSynthesizer", "Speak"}
+
+// The events of the SpeechSynthesizer interface.
+var (
+	TypeSpeechFinished = MessageType{"SpeechSynthesizer", "SpeechFinished"}
+	TypeSpeechStarted  = MessageType{"SpeechSynthesizer", "SpeechStarted"}
+)
+
+// The context of the SpeechSynthesizer interface.
+var TypeSpeechState = MessageType{"SpeechSynthesizer", "SpeechState"}
+
+func init() {
+	registerDirective(TypeSpeak, Speak{})
+	register(TypeSpeechFinished, SpeechFinished{})
+	register(TypeSpeechStarted, SpeechStarted{})
+	register(TypeSpeechState, SpeechState{})
+}
+
+// The Speak directive.
+type Speak struct {
+	*Message
+	Payload struct {
+		Format string `json:"format"`
+		URL    string `json:"url"`
+		Token  string `json:"token"`
+	} `json:"payload"`
+}
+
+// ContentId returns the content id of the speech, which is attached with the
+// directive; or an empty string if the URL isn't a cid: URL.
+func (m *Speak) ContentId() string {
+	id, _ := ParseCID(m.Payload.URL)
+	return string(id)
+}
+
+// The SpeechFinished event.
+type SpeechFinished struct {
+	*Message
+	Payload struct {
+		Token string `json:"token"`
+	} `json:"payload"`
+}
+
+func NewSpeechFinished(messageId, token string, opts ...HeaderOption) *SpeechFinished {
+	m := new(SpeechFinished)
+	m.Message = newEvent("SpeechSynthesizer", "SpeechFinished", messageId, "", opts...)
+	m.Payload.Token = token
+	return m
+}
+
+// The SpeechStarted event.
+type SpeechStarted struct {
+	*Message
+	Payload struct {
+		Token string `json:"token"`
+	} `json:"payload"`
+}
+
+func NewSpeechStarted(messageId, token string, opts ...HeaderOption) *SpeechStarted {
+	m := new(SpeechStarted)
+	m.Message = newEvent("SpeechSynthesizer", "SpeechStarted", messageId, "", opts...)
+	m.Payload.Token = token
+	return m
+}
+
+// The SpeechState context.
+type SpeechState struct {
+	*Message
+	Payload struct {
+		Token                string         `json:"token"`
+		OffsetInMilliseconds int            `json:"offsetInMilliseconds"`
+		PlayerActivity       PlayerActivity `json:"playerActivity"`
+	} `json:"payload"`
+}
+
+func NewSpeechState(token string, offset time.Duration, playerActivity PlayerActivity, opts ...HeaderOption) *SpeechState {
+	m := new(SpeechState)
+	m.Message = newContext("SpeechSynthesizer", "SpeechState", opts...)
+	m.Payload.Token = token
+	m.Payload.OffsetInMilliseconds = int(offset.Seconds() * 1000)
+	m.Payload.PlayerActivity = playerActivity
+	return m
+}
@@ -0,0 +1,130 @@
+package avs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotFound is returned by Store.Get when the key doesn't exist.
+var ErrNotFound = errors.New("avs: key not found")
+
+// Store provides durable storage for the components of the package that need
+// to persist state across restarts (e.g., playback progress). Keys are
+// namespaced by component.
+type Store interface {
+	// Get returns the value of the key, or ErrNotFound.
+	Get(namespace, key string) ([]byte, error)
+	// Put sets the value of the key.
+	Put(namespace, key string, value []byte) error
+	// Delete removes the key. Deleting a key that doesn't exist is not an
+	// error.
+	Delete(namespace, key string) error
+}
+
+// FileStore is a Store that keeps every key in its own file in a directory.
+// Writes are atomic (the value is written to a temporary file which then
+// replaces the old one). Values can optionally be encrypted at rest.
+type FileStore struct {
+	dir  string
+	aead cipher.AEAD
+}
+
+// NewFileStore returns a FileStore that stores its files in dir, creating it
+// if necessary. If key is not nil, values are encrypted with AES-GCM using
+// the key, which must be 16, 24 or 32 bytes long.
+func NewFileStore(dir string, key []byte) (*FileStore, error) {
+	s := &FileStore{dir: dir}
+	if key != nil {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if s.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns the value of the key, or ErrNotFound.
+func (s *FileStore) Get(namespace, key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path(namespace, key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil || s.aead == nil {
+		return data, err
+	}
+	n := s.aead.NonceSize()
+	if len(data) < n {
+		return nil, fmt.Errorf("avs: value of %s/%s is corrupt", namespace, key)
+	}
+	return s.aead.Open(nil, data[:n], data[n:], []byte(namespace+"/"+key))
+}
+
+// Put sets the value of the key.
+func (s *FileStore) Put(namespace, key string, value []byte) error {
+	if s.aead != nil {
+		nonce := make([]byte, s.aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return err
+		}
+		value = s.aead.Seal(nonce, nonce, value, []byte(namespace+"/"+key))
+	}
+	path := s.path(namespace, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(value)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Delete removes the key.
+func (s *FileStore) Delete(namespace, key string) error {
+	err := os.Remove(s.path(namespace, key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *FileStore) path(namespace, key string) string {
+	return filepath.Join(s.dir, escapePathSegment(namespace), escapePathSegment(key))
+}
+
+// Escapes a string so that it can be used as a single file name.
+func escapePathSegment(s string) string {
+	s = url.PathEscape(s)
+	if s == "" || s == "." || s == ".." {
+		// Avoid names with a special meaning.
+		s = "%" + s
+	}
+	return s
+}
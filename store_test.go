@@ -0,0 +1,84 @@
+package avs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	for _, key := range [][]byte{nil, bytes.Repeat([]byte{1}, 32)} {
+		dir, err := ioutil.TempDir("", "avs-store")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		s, err := NewFileStore(dir, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Get("ns", "missing"); err != ErrNotFound {
+			t.Errorf("Get of missing key = %v; want ErrNotFound", err)
+		}
+		for _, k := range []string{"a", "../escape", ".."} {
+			if err := s.Put("ns", k, []byte("value")); err != nil {
+				t.Fatalf("Put(%q): %v", k, err)
+			}
+			if v, err := s.Get("ns", k); err != nil || string(v) != "value" {
+				t.Errorf("Get(%q) = %q, %v", k, v, err)
+			}
+		}
+		if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(dir), "escape")); len(matches) > 0 {
+			t.Errorf("key escaped the store directory")
+		}
+		raw, _ := ioutil.ReadFile(filepath.Join(dir, "ns", "a"))
+		if encrypted := !bytes.Equal(raw, []byte("value")); encrypted != (key != nil) {
+			t.Errorf("key %v: stored %q", key, raw)
+		}
+		if err := s.Delete("ns", "a"); err != nil {
+			t.Errorf("Delete: %v", err)
+		}
+		if _, err := s.Get("ns", "a"); err != ErrNotFound {
+			t.Errorf("Get after Delete = %v; want ErrNotFound", err)
+		}
+		if err := s.Delete("ns", "a"); err != nil {
+			t.Errorf("Delete of missing key: %v", err)
+		}
+	}
+}
+
+func TestFileStoreWrongKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "avs-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, _ := NewFileStore(dir, bytes.Repeat([]byte{1}, 16))
+	s.Put("ns", "k", []byte("secret"))
+	other, _ := NewFileStore(dir, bytes.Repeat([]byte{2}, 16))
+	if _, err := other.Get("ns", "k"); err == nil {
+		t.Errorf("expected an error when decrypting with the wrong key")
+	}
+}
+
+func TestPlaybackStateProviderRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "avs-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, _ := NewFileStore(dir, nil)
+	p := NewPlaybackStateProvider(s, 0)
+	p.SetState("book", 90*time.Second, PlayerActivityPaused)
+	p.Close()
+
+	p = NewPlaybackStateProvider(s, 0)
+	defer p.Close()
+	token, offset, activity := p.State()
+	if token != "book" || offset != 90*time.Second || activity != PlayerActivityStopped {
+		t.Errorf("restored state = %s, %s, %s; want book, 1m30s, STOPPED", token, offset, activity)
+	}
+}
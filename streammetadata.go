@@ -0,0 +1,89 @@
+package avs
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// DefaultStreamMetadataInterval is the minimum time between two
+// StreamMetadataExtracted events for a stream, for the reporters that don't
+// set one.
+const DefaultStreamMetadataInterval = 10 * time.Second
+
+// StreamMetadataReporter turns the metadata extracted from the streams of
+// the audio player (e.g., by avsaudio.NewICYReader) into
+// StreamMetadataExtracted events, sending at most one per stream every
+// Interval. Metadata that is extracted sooner, or that hasn't changed since
+// the last event, is dropped.
+type StreamMetadataReporter struct {
+	// Send sends the event. It's called from Report, outside of any lock.
+	Send func(event *StreamMetadataExtracted)
+	// Interval is the minimum time between two events for a stream. Zero
+	// means DefaultStreamMetadataInterval.
+	Interval time.Duration
+	// Clock, if set, replaces the system clock.
+	Clock Clock
+
+	mu      sync.Mutex
+	streams map[string]*reportedMetadata
+}
+
+// The last metadata reported for a stream.
+type reportedMetadata struct {
+	sent     time.Time
+	metadata map[string]string
+}
+
+// NewStreamMetadataReporter returns a StreamMetadataReporter that sends its
+// events with send.
+func NewStreamMetadataReporter(send func(event *StreamMetadataExtracted)) *StreamMetadataReporter {
+	return &StreamMetadataReporter{Send: send}
+}
+
+// Report sends a StreamMetadataExtracted event with the metadata of the
+// stream with the token, unless it's too soon or the metadata is the same as
+// last time. It reports whether it did.
+func (r *StreamMetadataReporter) Report(token string, metadata map[string]string) bool {
+	if len(metadata) == 0 {
+		return false
+	}
+	interval := r.Interval
+	if interval == 0 {
+		interval = DefaultStreamMetadataInterval
+	}
+	now := clockOrDefault(r.Clock).Now()
+	r.mu.Lock()
+	last := r.streams[token]
+	if last != nil && (now.Sub(last.sent) < interval || reflect.DeepEqual(last.metadata, metadata)) {
+		r.mu.Unlock()
+		return false
+	}
+	if r.streams == nil {
+		r.streams = make(map[string]*reportedMetadata)
+	}
+	r.streams[token] = &reportedMetadata{sent: now, metadata: metadata}
+	r.mu.Unlock()
+	values := make(map[string]interface{}, len(metadata))
+	for k, v := range metadata {
+		values[k] = v
+	}
+	if r.Send != nil {
+		r.Send(NewStreamMetadataExtracted(RandomUUIDString(), token, values))
+	}
+	return true
+}
+
+// Extracted returns a function that reports the metadata of the stream with
+// the token, to be passed to a metadata extractor.
+func (r *StreamMetadataReporter) Extracted(token string) func(metadata map[string]string) {
+	return func(metadata map[string]string) { r.Report(token, metadata) }
+}
+
+// Forget drops what was reported for the stream (e.g., once it's done
+// playing).
+func (r *StreamMetadataReporter) Forget(token string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.streams, token)
+}
@@ -0,0 +1,86 @@
+package avs_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+	"github.com/fika-io/go-avs/avsaudio"
+	"github.com/fika-io/go-avs/avstest"
+)
+
+func TestStreamMetadataReporter(t *testing.T) {
+	clock := avstest.NewFakeClock(time.Date(2017, 5, 16, 12, 0, 0, 0, time.UTC))
+	var events []*avs.StreamMetadataExtracted
+	r := avs.NewStreamMetadataReporter(func(event *avs.StreamMetadataExtracted) {
+		events = append(events, event)
+	})
+	r.Interval = 5 * time.Second
+	r.Clock = clock
+
+	// An ICY stream with a new title every 8 bytes of audio.
+	var stream bytes.Buffer
+	for _, title := range []string{"One", "Two", "Two"} {
+		stream.WriteString("12345678\x02")
+		block := "StreamTitle='" + title + "';"
+		stream.WriteString(block + string(make([]byte, 32-len(block))))
+	}
+	audio, err := ioutil.ReadAll(avsaudio.NewICYReader(&stream, 8, r.Extracted("stream1")))
+	if err != nil || len(audio) != 24 {
+		t.Fatalf("got %q (%v)", audio, err)
+	}
+	// Only the first title is sent, the others are too soon.
+	if len(events) != 1 || events[0].Payload.Token != "stream1" || events[0].Payload.Metadata["StreamTitle"] != "One" {
+		t.Fatalf("got events %v", events)
+	}
+
+	clock.Advance(5 * time.Second)
+	if !r.Report("stream1", map[string]string{"StreamTitle": "Two"}) {
+		t.Error("metadata wasn't sent after the interval")
+	}
+	clock.Advance(5 * time.Second)
+	if r.Report("stream1", map[string]string{"StreamTitle": "Two"}) {
+		t.Error("unchanged metadata was sent again")
+	}
+	// Other streams have their own interval.
+	if !r.Report("stream2", map[string]string{"StreamTitle": "Two"}) {
+		t.Error("metadata of another stream wasn't sent")
+	}
+	if len(events) != 3 {
+		t.Errorf("got %d events, want 3", len(events))
+	}
+}
+
+// A PlaybackQueue reports the metadata of its items, and forgets the streams
+// of the items it removes.
+func TestPlaybackQueueMetadata(t *testing.T) {
+	var events []*avs.StreamMetadataExtracted
+	q := &avs.PlaybackQueue{Metadata: avs.NewStreamMetadataReporter(func(event *avs.StreamMetadataExtracted) {
+		events = append(events, event)
+	})}
+	ctx := context.Background()
+	a, b := newPlay("a", avs.PlayBehaviorReplaceAll, time.Time{}), newPlay("b", avs.PlayBehaviorEnqueue, time.Time{})
+	q.Enqueue(ctx, a)
+	q.Enqueue(ctx, b)
+	title := map[string]string{"StreamTitle": "One"}
+	q.Extracted(a)(title)
+	q.Extracted(b)(title)
+	// Within the interval, so dropped.
+	q.Extracted(a)(map[string]string{"StreamTitle": "Two"})
+	if len(events) != 2 || events[0].Payload.Token != "a" || events[1].Payload.Token != "b" {
+		t.Fatalf("got events %v", events)
+	}
+	q.Advance()
+	q.Clear(avs.ClearBehaviorClearAll)
+	// Both streams were forgotten, so their metadata is new again.
+	if !q.Metadata.Report("a", title) || !q.Metadata.Report("b", title) {
+		t.Error("the metadata of removed items wasn't forgotten")
+	}
+
+	if (&avs.PlaybackQueue{}).Extracted(a) != nil {
+		t.Error("got an extractor without a Metadata reporter")
+	}
+}
@@ -0,0 +1,144 @@
+package avs
+
+import (
+	"fmt"
+	"time"
+)
+
+// The directives of the System interface.
+var (
+	TypeResetUserInactivity = MessageType{"System", "ResetUserInactivity"}
+	TypeSetEndpoint         = MessageType{"System", "SetEndpoint"}
+)
+
+// The exception message, which isn't a directive but may also be sent by AVS.
+var TypeException = MessageType{"System", "Exception"}
+
+// The events of the System interface.
+var (
+	TypeExceptionEncountered = MessageType{"System", "ExceptionEncountered"}
+	TypeSoftwareInfo         = MessageType{"System", "SoftwareInfo"}
+	TypeSynchronizeState     = MessageType{"System", "SynchronizeState"}
+	TypeUserInactivityReport = MessageType{"System", "UserInactivityReport"}
+)
+
+func init() {
+	registerDirective(TypeResetUserInactivity, ResetUserInactivity{})
+	registerDirective(TypeSetEndpoint, SetEndpoint{})
+	register(TypeException, Exception{})
+	register(TypeExceptionEncountered, ExceptionEncountered{})
+	register(TypeSoftwareInfo, SoftwareInfo{})
+	register(TypeSynchronizeState, SynchronizeState{})
+	register(TypeUserInactivityReport, UserInactivityReport{})
+}
+
+// The SetEndpoint directive.
+type SetEndpoint struct {
+	*Message
+	Payload struct {
+		Endpoint string `json:"endpoint"`
+	} `json:"payload"`
+}
+
+// The ResetUserInactivity directive.
+type ResetUserInactivity struct {
+	*Message
+	Payload struct{} `json:"payload"`
+}
+
+// The Exception message.
+type Exception struct {
+	*Message
+	Payload struct {
+		Code        ExceptionCode `json:"code"`
+		Description string        `json:"description"`
+	} `json:"payload"`
+	// The HTTP status code and Amazon request id of the response that
+	// contained the exception, if it was returned as an error.
+	StatusCode int    `json:"-"`
+	RequestId  string `json:"-"`
+	// The delay that the Retry-After header of the response asked for, if
+	// any.
+	RetryAfter time.Duration `json:"-"`
+}
+
+// Error returns the Exception formatted as a human readable string.
+func (m *Exception) Error() string {
+	return fmt.Sprintf("%s: %s", m.Payload.Code, m.Payload.Description)
+}
+
+// Is reports whether the exception is of the kind of target:
+// UNAUTHORIZED_REQUEST_EXCEPTION is ErrUnauthorized and THROTTLING_EXCEPTION
+// is ErrThrottled.
+func (m *Exception) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return m.Payload.Code == ExceptionCodeUnauthorizedRequest
+	case ErrThrottled:
+		return m.Payload.Code == ExceptionCodeThrottling
+	}
+	return false
+}
+
+// The ExceptionEncountered event.
+type ExceptionEncountered struct {
+	*Message
+	Payload struct {
+		UnparsedDirective string `json:"unparsedDirective"`
+		Error             struct {
+			Type    ErrorType `json:"type"`
+			Message string    `json:"message"`
+		} `json:"error"`
+	} `json:"payload"`
+}
+
+func NewExceptionEncountered(messageId, directive string, errorType ErrorType, errorMessage string, opts ...HeaderOption) *ExceptionEncountered {
+	m := new(ExceptionEncountered)
+	m.Message = newEvent("System", "ExceptionEncountered", messageId, "", opts...)
+	m.Payload.UnparsedDirective = directive
+	m.Payload.Error.Type = errorType
+	m.Payload.Error.Message = errorMessage
+	return m
+}
+
+// The SoftwareInfo event.
+type SoftwareInfo struct {
+	*Message
+	Payload struct {
+		FirmwareVersion string `json:"firmwareVersion"`
+	} `json:"payload"`
+}
+
+func NewSoftwareInfo(messageId, firmwareVersion string, opts ...HeaderOption) *SoftwareInfo {
+	m := new(SoftwareInfo)
+	m.Message = newEvent("System", "SoftwareInfo", messageId, "", opts...)
+	m.Payload.FirmwareVersion = firmwareVersion
+	return m
+}
+
+// The SynchronizeState event.
+type SynchronizeState struct {
+	*Message
+	Payload struct{} `json:"payload"`
+}
+
+func NewSynchronizeState(messageId string, opts ...HeaderOption) *SynchronizeState {
+	m := new(SynchronizeState)
+	m.Message = newEvent("System", "SynchronizeState", messageId, "", opts...)
+	return m
+}
+
+// The UserInactivityReport event.
+type UserInactivityReport struct {
+	*Message
+	Payload struct {
+		InactiveTimeInSeconds int `json:"inactiveTimeInSeconds"`
+	} `json:"payload"`
+}
+
+func NewUserInactivityReport(messageId string, inactiveTime time.Duration, opts ...HeaderOption) *UserInactivityReport {
+	m := new(UserInactivityReport)
+	m.Message = newEvent("System", "UserInactivityReport", messageId, "", opts...)
+	m.Payload.InactiveTimeInSeconds = int(inactiveTime.Seconds())
+	return m
+}
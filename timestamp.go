@@ -0,0 +1,75 @@
+package avs
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// The canonical format of the ISO 8601 timestamps sent to AVS.
+const avsTimeFormat = "2006-01-02T15:04:05-0700"
+
+// The formats accepted when parsing timestamps. Fractional seconds are
+// accepted by all of them.
+var avsTimeLayouts = []string{
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05Z07",
+}
+
+// Parses an ISO 8601 timestamp as sent by AVS, with or without fractional
+// seconds and with either Z or a numeric offset (with or without a colon).
+func parseAVSTime(s string) (time.Time, error) {
+	for _, layout := range avsTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("avs: invalid timestamp %q", s)
+}
+
+// Formats a time in the canonical format expected by AVS.
+func formatAVSTime(t time.Time) string {
+	return t.Format(avsTimeFormat)
+}
+
+// Timestamp is an ISO 8601 time in a message. It accepts all the variants
+// sent by AVS and is always encoded in the canonical format. The zero
+// Timestamp is encoded as an empty string.
+type Timestamp struct {
+	time.Time
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte(`""`), nil
+	}
+	return json.Marshal(formatAVSTime(t.Time))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		t.Time = time.Time{}
+		return nil
+	}
+	parsed, err := parseAVSTime(s)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+// String returns the timestamp in the canonical format.
+func (t Timestamp) String() string {
+	if t.IsZero() {
+		return ""
+	}
+	return formatAVSTime(t.Time)
+}
@@ -0,0 +1,84 @@
+package avs
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseAVSTime(t *testing.T) {
+	want := time.Date(2016, 1, 29, 19, 30, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2016-01-29T19:30:00Z", want},
+		{"2016-01-29T19:30:00+0000", want},
+		{"2016-01-29T19:30:00+00:00", want},
+		{"2016-01-29T19:30:00+00", want},
+		{"2016-01-29T19:30:00.000Z", want},
+		{"2016-01-29T19:30:00.250+0000", want.Add(250 * time.Millisecond)},
+		{"2016-01-29T21:30:00+0200", want},
+		{"2016-01-29T14:30:00-05:00", want},
+		{"2016-01-29T14:30:00.5-0500", want.Add(500 * time.Millisecond)},
+	}
+	for _, test := range tests {
+		got, err := parseAVSTime(test.in)
+		if err != nil {
+			t.Errorf("parseAVSTime(%q): %v", test.in, err)
+			continue
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("parseAVSTime(%q) = %v, want %v", test.in, got, test.want)
+		}
+	}
+	for _, in := range []string{"", "2016-01-29", "2016-01-29T19:30:00", "29/01/2016 19:30"} {
+		if _, err := parseAVSTime(in); err == nil {
+			t.Errorf("parseAVSTime(%q) should fail", in)
+		}
+	}
+}
+
+func TestFormatAVSTime(t *testing.T) {
+	tests := []struct {
+		in   time.Time
+		want string
+	}{
+		{time.Date(2016, 1, 29, 19, 30, 0, 0, time.UTC), "2016-01-29T19:30:00+0000"},
+		{time.Date(2016, 1, 29, 19, 30, 0, 999, time.UTC), "2016-01-29T19:30:00+0000"},
+		{time.Date(2016, 1, 29, 21, 30, 0, 0, time.FixedZone("", 2*60*60)), "2016-01-29T21:30:00+0200"},
+		{time.Date(2016, 1, 29, 14, 0, 0, 0, time.FixedZone("", -(5*60+30)*60)), "2016-01-29T14:00:00-0530"},
+	}
+	for _, test := range tests {
+		if got := formatAVSTime(test.in); got != test.want {
+			t.Errorf("formatAVSTime(%v) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestTimestampJSON(t *testing.T) {
+	var alert Alert
+	if err := json.Unmarshal([]byte(`{"token":"t","type":"ALARM","scheduledTime":"2016-01-29T19:30:00.000Z"}`), &alert); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2016, 1, 29, 19, 30, 0, 0, time.UTC); !alert.ScheduledTime.Equal(want) {
+		t.Errorf("got %v, want %v", alert.ScheduledTime, want)
+	}
+	data, err := json.Marshal(alert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"token":"t","type":"ALARM","scheduledTime":"2016-01-29T19:30:00+0000"}`; string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+	var stream Stream
+	if err := json.Unmarshal([]byte(`{"expiryTime":""}`), &stream); err != nil {
+		t.Fatal(err)
+	}
+	if !stream.ExpiryTime.IsZero() {
+		t.Errorf("empty expiry time should be zero, got %v", stream.ExpiryTime)
+	}
+	if err := json.Unmarshal([]byte(`{"expiryTime":"tomorrow"}`), &stream); err == nil {
+		t.Error("invalid expiry time should fail")
+	}
+}
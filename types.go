@@ -1,15 +1,29 @@
 package avs
 
 import (
-	"strings"
 	"time"
+
+	"github.com/fika-io/go-avs/avsaudio"
 )
 
 // Alert represents a single alarm or timer with a scheduled time.
 type Alert struct {
 	Token         string    `json:"token"`
 	Type          AlertType `json:"type"`
-	ScheduledTime string    `json:"scheduledTime"`
+	ScheduledTime Timestamp `json:"scheduledTime"`
+	// Custom sounds to play instead of the default tone, in the order of
+	// AssetPlayOrder (by asset id), repeated LoopCount times.
+	Assets                  []AlertAsset `json:"assets,omitempty"`
+	AssetPlayOrder          []string     `json:"assetPlayOrder,omitempty"`
+	BackgroundAlertAsset    string       `json:"backgroundAlertAsset,omitempty"`
+	LoopCount               int          `json:"loopCount,omitempty"`
+	LoopPauseInMilliseconds int          `json:"loopPauseInMilliseconds,omitempty"`
+}
+
+// AlertAsset is a named audio file that an alert plays when it fires.
+type AlertAsset struct {
+	AssetId string `json:"assetId"`
+	URL     string `json:"url"`
 }
 
 // AlertType specifies the type of an alert.
@@ -44,6 +58,24 @@ const (
 	ClearBehaviorClearEnqueued = ClearBehavior("CLEAR_ENQUEUED")
 )
 
+// ExceptionCode specifies the type of a System.Exception sent by AVS.
+type ExceptionCode string
+
+// Possible values for ExceptionCode.
+const (
+	// ExceptionCodeInvalidRequest is sent when the request was malformed.
+	ExceptionCodeInvalidRequest = ExceptionCode("INVALID_REQUEST_EXCEPTION")
+	// ExceptionCodeUnauthorizedRequest is sent when the access token is invalid
+	// or expired.
+	ExceptionCodeUnauthorizedRequest = ExceptionCode("UNAUTHORIZED_REQUEST_EXCEPTION")
+	// ExceptionCodeThrottling is sent when the client sends too many events.
+	ExceptionCodeThrottling = ExceptionCode("THROTTLING_EXCEPTION")
+	// ExceptionCodeInternalService is sent when AVS failed to handle the request.
+	ExceptionCodeInternalService = ExceptionCode("INTERNAL_SERVICE_EXCEPTION")
+	// ExceptionCodeNA is sent when AVS is unavailable.
+	ExceptionCodeNA = ExceptionCode("N/A")
+)
+
 // ErrorType specifies the types of errors that the client may report to AVS.
 type ErrorType string
 
@@ -113,21 +145,66 @@ func (p *ProgressReport) Delay() time.Duration {
 	return time.Duration(p.ProgressReportDelayInMilliseconds) * time.Millisecond
 }
 
+// StreamFormat specifies the format of the audio of a stream. Formats that
+// the package doesn't know are kept as declared, so that they're encoded
+// again unchanged.
+type StreamFormat string
+
+// Possible values for StreamFormat.
+const (
+	// StreamFormatUnknown is the format of streams that don't declare one.
+	StreamFormatUnknown = StreamFormat("")
+	// StreamFormatAudioMPEG is MP3 audio.
+	StreamFormatAudioMPEG = StreamFormat("AUDIO_MPEG")
+)
+
+// Known reports whether the format is one of the constants other than
+// StreamFormatUnknown.
+func (f StreamFormat) Known() bool {
+	return f == StreamFormatAudioMPEG
+}
+
+// UnmarshalJSON decodes a format. Values that aren't strings are decoded as
+// StreamFormatUnknown rather than failing the directive.
+func (f *StreamFormat) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := codec().Unmarshal(data, &s); err != nil {
+		*f = StreamFormatUnknown
+		return nil
+	}
+	*f = StreamFormat(s)
+	return nil
+}
+
 // An audio stream which can either be attached with the response or a remote URL.
 type Stream struct {
-	ExpiryTime            string         `json:"expiryTime"`
+	ExpiryTime            Timestamp      `json:"expiryTime"`
 	OffsetInMilliseconds  float64        `json:"offsetInMilliseconds"`
 	ProgressReport        ProgressReport `json:"progressReport"`
 	Token                 string         `json:"token"`
 	ExpectedPreviousToken string         `json:"expectedPreviousToken"`
 	URL                   string         `json:"url"`
+	// StreamFormat is only declared by the responses of the v20160207 API.
+	StreamFormat StreamFormat `json:"streamFormat,omitempty"`
+}
+
+// GuessFormat returns the format of the audio: the declared StreamFormat, if
+// it's known, or else the format sniffed from the first bytes of the audio,
+// if they're available (e.g., of an attachment). It returns
+// StreamFormatUnknown if neither tells.
+func (s *Stream) GuessFormat(attachmentSniff []byte) StreamFormat {
+	if s.StreamFormat.Known() {
+		return s.StreamFormat
+	}
+	if avsaudio.Sniff(attachmentSniff) == avsaudio.FormatMP3 {
+		return StreamFormatAudioMPEG
+	}
+	return StreamFormatUnknown
 }
 
 // ContentId returns the content id of the audio, if it's attached with the
 // response; otherwise, an empty string.
 func (s *Stream) ContentId() string {
-	if !strings.HasPrefix(s.URL, "cid:") {
-		return ""
-	}
-	return s.URL[4:]
+	id, _ := ParseCID(s.URL)
+	return string(id)
 }
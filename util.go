@@ -2,19 +2,18 @@ package avs
 
 import (
 	"crypto/rand"
-	"encoding/json"
 	"fmt"
 	"io"
-	"mime"
-	"mime/multipart"
 	"net/http"
-	"net/textproto"
-	"strings"
 
 	"github.com/fika-io/go-avs/multipart2"
 )
 
-var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+// The multipart field names used by AVS for the event metadata and the audio.
+const (
+	metadataFieldName = "metadata"
+	audioFieldName    = "audio"
+)
 
 // UUID holds a 16 byte unique identifier.
 type UUID []byte
@@ -48,36 +47,17 @@ func RandomUUIDString() string {
 	return uuid.String()
 }
 
-func newMultipartReaderFromResponse(resp *http.Response) (*multipart2.Reader, error) {
-	// Work around bug in Amazon's downchannel server.
-	contentType := strings.Replace(resp.Header.Get("Content-Type"), "type=application/json", `type="application/json"`, 1)
-	mediatype, params, err := mime.ParseMediaType(contentType)
+// Returns a reader for the multipart body of the response that enforces the
+// header limits, which must be resolved.
+func newMultipartReaderFromResponse(resp *http.Response, limits Limits) (*multipart2.Reader, error) {
+	// Amazon's downchannel server doesn't quote all parameter values, so
+	// this must be parsed leniently.
+	_, boundary, err := multipart2.ParseContentType(resp.Header.Get("Content-Type"))
 	if err != nil {
 		return nil, err
 	}
-	if !strings.HasPrefix(mediatype, "multipart/") {
-		return nil, fmt.Errorf("unexpected content type %s", mediatype)
-	}
-	return multipart2.NewReader(resp.Body, params["boundary"]), nil
-}
-
-func escapeQuotes(s string) string {
-	return quoteEscaper.Replace(s)
-}
-
-// Encodes a JSON value and writes it to a field with the provided multipart writer.
-func writeJSON(writer *multipart.Writer, fieldname string, value interface{}) error {
-	h := make(textproto.MIMEHeader)
-	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(fieldname)))
-	h.Set("Content-Type", "application/json; charset=UTF-8")
-	p, err := writer.CreatePart(h)
-	if err != nil {
-		return err
-	}
-	data, err := json.Marshal(value)
-	if err != nil {
-		return err
-	}
-	_, err = p.Write(data)
-	return err
+	mr := multipart2.NewReader(resp.Body, boundary)
+	mr.MaxHeaderBytes = orUnlimited(limits.MaxHeaderBytes)
+	mr.MaxHeaders = orUnlimited(limits.MaxHeaders)
+	return mr, nil
 }
@@ -0,0 +1,303 @@
+package avs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// The Store namespace and key of the wake words set with a WakeWordManager.
+const (
+	wakeWordStoreNamespace = "WakeWords"
+	wakeWordStoreKey       = "active"
+)
+
+// WakeWordDetector is the integration of the wake word engine of a device.
+type WakeWordDetector interface {
+	// SuppressDetection stops detecting the wake word if suppress is true,
+	// and starts again if it's false.
+	SuppressDetection(suppress bool)
+}
+
+// SelfTriggerGuard keeps a device from waking itself up with its own
+// speech: it suppresses the detection of the wake word while audio plays
+// through the speaker of the device, and for a tail window after the audio
+// ends, as the wake word engine may still pick up the end of it.
+//
+// Playback is marked with Begin, or by playing through the SpeechPlayer and
+// AudioSink wrappers (e.g., for alert tones). A DialogController with a
+// guard marks its speech and fallback prompts itself.
+type SelfTriggerGuard struct {
+	// Detector is the wake word engine to suppress.
+	Detector WakeWordDetector
+	// Tail is how long detection stays suppressed after the audio ends.
+	Tail time.Duration
+	// Clock, if set, replaces the system clock.
+	Clock Clock
+	// Metrics, if set, receives the changes of suppression.
+	Metrics *Metrics
+
+	mu         sync.Mutex
+	playing    int
+	suppressed bool
+	// Incremented whenever audio starts, so that the end of an older tail
+	// window is ignored.
+	generation int
+}
+
+// Begin marks the start of audio played through the speaker of the device.
+// Detection is suppressed until the returned function is called to mark its
+// end, and for the Tail after. Overlapping playbacks may each call Begin.
+func (g *SelfTriggerGuard) Begin() (end func()) {
+	g.mu.Lock()
+	g.playing++
+	g.generation++
+	g.suppress(true)
+	g.mu.Unlock()
+	var once sync.Once
+	return func() { once.Do(g.end) }
+}
+
+func (g *SelfTriggerGuard) end() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.playing--
+	if g.playing > 0 {
+		return
+	}
+	if g.Tail <= 0 {
+		g.suppress(false)
+		return
+	}
+	generation := g.generation
+	after := clockOrDefault(g.Clock).After(g.Tail)
+	go func() {
+		<-after
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.playing == 0 && g.generation == generation {
+			g.suppress(false)
+		}
+	}()
+}
+
+// Suppressed reports whether detection is suppressed.
+func (g *SelfTriggerGuard) Suppressed() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.suppressed
+}
+
+// Tells the detector about a change of suppression. The lock must be held.
+func (g *SelfTriggerGuard) suppress(suppressed bool) {
+	if g.suppressed == suppressed {
+		return
+	}
+	g.suppressed = suppressed
+	g.Metrics.wakeWordSuppressed(suppressed)
+	if g.Detector != nil {
+		g.Detector.SuppressDetection(suppressed)
+	}
+}
+
+// SpeechPlayer returns a SpeechPlayer that suppresses detection while p
+// plays.
+func (g *SelfTriggerGuard) SpeechPlayer(p SpeechPlayer) SpeechPlayer {
+	return &guardedPlayer{guard: g, player: p}
+}
+
+// AudioSink returns an AudioSink that suppresses detection while sink plays
+// (e.g., the sink of the alert tones).
+func (g *SelfTriggerGuard) AudioSink(sink AudioSink) AudioSink {
+	return &guardedSink{guard: g, sink: sink}
+}
+
+type guardedPlayer struct {
+	guard  *SelfTriggerGuard
+	player SpeechPlayer
+}
+
+func (p *guardedPlayer) PlaySpeech(ctx context.Context, speak *Speak, audio io.Reader) error {
+	defer p.guard.Begin()()
+	return p.player.PlaySpeech(ctx, speak, audio)
+}
+
+type guardedSink struct {
+	guard *SelfTriggerGuard
+	sink  AudioSink
+}
+
+func (s *guardedSink) PlayAudio(ctx context.Context, audio io.Reader) error {
+	defer s.guard.Begin()()
+	return s.sink.PlayAudio(ctx, audio)
+}
+
+// WakeWordSwitcher is implemented by the wake word engines that can detect
+// other wake words (e.g., by loading another model).
+type WakeWordSwitcher interface {
+	SwitchWakeWords(wakeWords []string) error
+}
+
+// WakeWordManager keeps track of the wake words that the device responds to,
+// in the default scope. It applies the changes made with SetWakeWords or a
+// SetWakeWords directive by switching the detector to them, persists them in
+// the Store once the detector has switched so that they survive a restart,
+// and reports the first one in the RecognizerState context. Changes are
+// applied one at a time, in the order they're made.
+//
+// A change made during an interaction (see BeginInteraction) takes effect
+// once the interaction is over, so that it isn't aborted.
+type WakeWordManager struct {
+	// Supported, if set, declares the wake words of the device; other wake
+	// words are rejected.
+	Supported *SpeechRecognizerConfigurations
+	// OnChange, if set, is called with the wake words once they've been
+	// applied (e.g., to send a WakeWordsChanged event). It mustn't change
+	// the wake words itself.
+	OnChange func(wakeWords []string)
+	// Logger, if set, receives the changes that fail once an interaction is
+	// over.
+	Logger *log.Logger
+
+	store    Store
+	detector WakeWordSwitcher
+
+	// Held while a change is applied.
+	applying sync.Mutex
+
+	mu           sync.Mutex
+	wakeWords    []string
+	pending      []string
+	interactions int
+}
+
+// NewWakeWordManager returns a WakeWordManager with the wake words persisted
+// in the store, or DefaultWakeWord, and switches the detector to them. The
+// store and the detector may be nil.
+func NewWakeWordManager(store Store, detector WakeWordSwitcher) (*WakeWordManager, error) {
+	m := &WakeWordManager{store: store, detector: detector, wakeWords: []string{DefaultWakeWord}}
+	if store != nil {
+		data, err := store.Get(wakeWordStoreNamespace, wakeWordStoreKey)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(data, &m.wakeWords); err != nil || len(m.wakeWords) == 0 {
+				return nil, fmt.Errorf("avs: stored wake words %q are corrupt", data)
+			}
+		case err != ErrNotFound:
+			return nil, err
+		}
+	}
+	if detector != nil {
+		if err := detector.SwitchWakeWords(m.WakeWords()); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// WakeWords returns the wake words in effect.
+func (m *WakeWordManager) WakeWords() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.wakeWords...)
+}
+
+// SetWakeWords changes the wake words, right away unless an interaction is
+// in progress.
+func (m *WakeWordManager) SetWakeWords(wakeWords []string) error {
+	if len(wakeWords) == 0 {
+		return withKind(ErrInvalidMessage, errors.New("avs: no wake words"))
+	}
+	for _, w := range wakeWords {
+		if w == "" || (m.Supported != nil && !m.Supported.Supports(w)) {
+			return withKind(ErrInvalidMessage, fmt.Errorf("avs: unsupported wake word %q", w))
+		}
+	}
+	wakeWords = append([]string(nil), wakeWords...)
+	m.applying.Lock()
+	defer m.applying.Unlock()
+	m.mu.Lock()
+	if m.interactions > 0 {
+		m.pending = wakeWords
+		m.mu.Unlock()
+		return nil
+	}
+	m.mu.Unlock()
+	return m.apply(wakeWords)
+}
+
+// Switches the detector to the wake words, then persists them. If they can't
+// be persisted, the detector is switched back. The applying lock must be
+// held.
+func (m *WakeWordManager) apply(wakeWords []string) error {
+	previous := m.WakeWords()
+	if m.detector != nil {
+		if err := m.detector.SwitchWakeWords(wakeWords); err != nil {
+			return err
+		}
+	}
+	if m.store != nil {
+		data, _ := json.Marshal(wakeWords)
+		if err := m.store.Put(wakeWordStoreNamespace, wakeWordStoreKey, data); err != nil {
+			if m.detector != nil {
+				m.detector.SwitchWakeWords(previous)
+			}
+			return err
+		}
+	}
+	m.mu.Lock()
+	m.wakeWords = wakeWords
+	m.mu.Unlock()
+	if m.OnChange != nil {
+		m.OnChange(append([]string(nil), wakeWords...))
+	}
+	return nil
+}
+
+// BeginInteraction marks the start of an interaction. Changes of the wake
+// words are held until the returned function is called to mark its end. A
+// DialogController with the manager marks its interactions itself.
+func (m *WakeWordManager) BeginInteraction() (end func()) {
+	m.mu.Lock()
+	m.interactions++
+	m.mu.Unlock()
+	var once sync.Once
+	return func() { once.Do(m.endInteraction) }
+}
+
+func (m *WakeWordManager) endInteraction() {
+	m.applying.Lock()
+	defer m.applying.Unlock()
+	m.mu.Lock()
+	m.interactions--
+	pending := m.pending
+	if m.interactions > 0 || pending == nil {
+		m.mu.Unlock()
+		return
+	}
+	m.pending = nil
+	m.mu.Unlock()
+	if err := m.apply(pending); err != nil && m.Logger != nil {
+		m.Logger.Printf("avs: failed to switch to the wake words %v: %v", pending, err)
+	}
+}
+
+// Context returns the RecognizerState context with the first wake word.
+func (m *WakeWordManager) Context() (TypedMessage, error) {
+	return NewRecognizerState(m.WakeWords()[0]), nil
+}
+
+// HandleDirective applies the wake words of the default scope of a
+// SetWakeWords directive.
+func (m *WakeWordManager) HandleDirective(ctx context.Context, directive TypedMessage) error {
+	d, ok := directive.(*SetWakeWords)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedDirective, directive.GetMessage())
+	}
+	return m.SetWakeWords(d.WakeWordsIn(WakeWordScope))
+}
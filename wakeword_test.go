@@ -0,0 +1,250 @@
+package avs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+	"github.com/fika-io/go-avs/avstest"
+)
+
+// Sends the calls to SuppressDetection on a channel.
+type fakeDetector chan bool
+
+func (d fakeDetector) SuppressDetection(suppress bool) { d <- suppress }
+
+func (d fakeDetector) expect(t *testing.T, want bool) {
+	t.Helper()
+	select {
+	case got := <-d:
+		if got != want {
+			t.Errorf("SuppressDetection(%t); want %t", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("SuppressDetection(%t) wasn't called", want)
+	}
+}
+
+func (d fakeDetector) expectNone(t *testing.T) {
+	t.Helper()
+	select {
+	case got := <-d:
+		t.Errorf("unexpected SuppressDetection(%t)", got)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestSelfTriggerGuardTail(t *testing.T) {
+	clock := avstest.NewFakeClock(time.Now())
+	detector := make(fakeDetector, 10)
+	var changes []bool
+	guard := &avs.SelfTriggerGuard{
+		Detector: detector,
+		Tail:     500 * time.Millisecond,
+		Clock:    clock,
+		Metrics:  &avs.Metrics{WakeWordSuppressed: func(suppressed bool) { changes = append(changes, suppressed) }},
+	}
+
+	end := guard.Begin()
+	detector.expect(t, true)
+	// Overlapping audio doesn't suppress again or resume early.
+	endTone := guard.Begin()
+	end()
+	clock.Advance(time.Second)
+	detector.expectNone(t)
+	endTone()
+	endTone()
+	if !guard.Suppressed() {
+		t.Errorf("detection resumed before the end of the tail window")
+	}
+
+	// Audio that starts during the tail window extends it.
+	clock.Advance(400 * time.Millisecond)
+	guard.Begin()()
+	clock.Advance(400 * time.Millisecond)
+	detector.expectNone(t)
+	clock.Advance(100 * time.Millisecond)
+	detector.expect(t, false)
+	if guard.Suppressed() {
+		t.Errorf("detection still suppressed after the tail window")
+	}
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("metrics got %v; want [true false]", changes)
+	}
+}
+
+// A SpeechPlayer that checks that detection is suppressed while it plays.
+type suppressionCheckingPlayer struct {
+	guard  *avs.SelfTriggerGuard
+	played int
+}
+
+func (p *suppressionCheckingPlayer) PlaySpeech(ctx context.Context, speak *avs.Speak, audio io.Reader) error {
+	return p.PlayAudio(ctx, audio)
+}
+
+func (p *suppressionCheckingPlayer) PlayAudio(ctx context.Context, audio io.Reader) error {
+	if p.guard.Suppressed() {
+		p.played++
+	}
+	_, err := io.Copy(ioutil.Discard, audio)
+	return err
+}
+
+func TestSelfTriggerGuardWrappers(t *testing.T) {
+	detector := make(fakeDetector, 10)
+	guard := &avs.SelfTriggerGuard{Detector: detector}
+	p := &suppressionCheckingPlayer{guard: guard}
+	if err := guard.SpeechPlayer(p).PlaySpeech(context.Background(), avstest.Speak("speech"), strings.NewReader("speech")); err != nil {
+		t.Fatal(err)
+	}
+	detector.expect(t, true)
+	detector.expect(t, false)
+	if err := guard.AudioSink(p).PlayAudio(context.Background(), strings.NewReader("tone")); err != nil {
+		t.Fatal(err)
+	}
+	detector.expect(t, true)
+	detector.expect(t, false)
+	if p.played != 2 {
+		t.Errorf("%d of 2 plays with detection suppressed", p.played)
+	}
+}
+
+// Records the wake words it's switched to.
+type switchingDetector struct {
+	switches [][]string
+}
+
+func (d *switchingDetector) SwitchWakeWords(wakeWords []string) error {
+	d.switches = append(d.switches, wakeWords)
+	return nil
+}
+
+func TestWakeWordManager(t *testing.T) {
+	store, _ := avs.NewFileStore(t.TempDir(), nil)
+	detector := &switchingDetector{}
+	m, err := avs.NewWakeWordManager(store, detector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Supported = avs.NewSpeechRecognizerConfigurations(avs.WakeWordAlexa, avs.WakeWordComputer)
+	var changes [][]string
+	m.OnChange = func(wakeWords []string) { changes = append(changes, wakeWords) }
+
+	d := avs.NewDispatcher()
+	d.Handle("SpeechRecognizer.SetWakeWords", m)
+	setWakeWords := func(wakeWords ...string) error {
+		return d.Dispatch(context.Background(), avstest.SetWakeWords(wakeWords...).GetMessage())
+	}
+
+	if err := setWakeWords(avs.WakeWordEcho); !errors.Is(err, avs.ErrInvalidMessage) {
+		t.Errorf("got %v for an undeclared wake word, want ErrInvalidMessage", err)
+	}
+
+	// A change during an interaction waits for it to end.
+	end := m.BeginInteraction()
+	if err := setWakeWords(avs.WakeWordComputer); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.WakeWords(); !reflect.DeepEqual(got, []string{avs.WakeWordAlexa}) {
+		t.Errorf("got %v during the interaction", got)
+	}
+	end()
+	want := [][]string{{avs.WakeWordAlexa}, {avs.WakeWordComputer}}
+	if !reflect.DeepEqual(detector.switches, want) {
+		t.Errorf("detector switched to %v, want %v", detector.switches, want)
+	}
+	if !reflect.DeepEqual(changes, want[1:]) {
+		t.Errorf("got changes %v", changes)
+	}
+	state, _ := m.Context()
+	if got := state.(*avs.RecognizerState).Payload.Wakeword; got != avs.WakeWordComputer {
+		t.Errorf("RecognizerState has wake word %s, want COMPUTER", got)
+	}
+
+	// The wake words are restored after a restart.
+	restarted := &switchingDetector{}
+	if _, err := avs.NewWakeWordManager(store, restarted); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restarted.switches, want[1:]) {
+		t.Errorf("restarted detector switched to %v", restarted.switches)
+	}
+}
+
+// A detector that can't switch to COMPUTER.
+type failingDetector struct {
+	mu      sync.Mutex
+	current []string
+}
+
+func (d *failingDetector) SwitchWakeWords(wakeWords []string) error {
+	if wakeWords[0] == avs.WakeWordComputer {
+		return errors.New("no model for COMPUTER")
+	}
+	d.mu.Lock()
+	d.current = wakeWords
+	d.mu.Unlock()
+	return nil
+}
+
+func TestWakeWordManagerFailures(t *testing.T) {
+	store, _ := avs.NewFileStore(t.TempDir(), nil)
+	detector := &failingDetector{}
+	m, err := avs.NewWakeWordManager(store, detector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	m.Logger = log.New(&logs, "", 0)
+
+	// Wake words that the detector can't switch to aren't persisted.
+	if err := m.SetWakeWords([]string{avs.WakeWordComputer}); err == nil {
+		t.Fatal("switched to wake words the detector rejected")
+	}
+	restarted := &failingDetector{}
+	if _, err := avs.NewWakeWordManager(store, restarted); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.WakeWords(); !reflect.DeepEqual(got, []string{avs.WakeWordAlexa}) || !reflect.DeepEqual(restarted.current, got) {
+		t.Errorf("got %v and stored %v; want ALEXA", got, restarted.current)
+	}
+
+	// A change that fails once the interaction is over is logged.
+	end := m.BeginInteraction()
+	if err := m.SetWakeWords([]string{avs.WakeWordComputer}); err != nil {
+		t.Fatal(err)
+	}
+	end()
+	if !strings.Contains(logs.String(), "no model for COMPUTER") {
+		t.Errorf("got logs %q; want the failure", logs.String())
+	}
+
+	// Concurrent changes are applied one at a time, so that the detector,
+	// the manager and the store agree on the last one.
+	var wg sync.WaitGroup
+	for _, w := range []string{avs.WakeWordAlexa, avs.WakeWordEcho, avs.WakeWordAlexa, avs.WakeWordEcho} {
+		wg.Add(1)
+		go func(w string) {
+			defer wg.Done()
+			m.SetWakeWords([]string{w})
+		}(w)
+	}
+	wg.Wait()
+	restarted = &failingDetector{}
+	if _, err := avs.NewWakeWordManager(store, restarted); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.WakeWords(); !reflect.DeepEqual(got, detector.current) || !reflect.DeepEqual(got, restarted.current) {
+		t.Errorf("got %v, detector %v and stored %v; want the same wake words", got, detector.current, restarted.current)
+	}
+}
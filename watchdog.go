@@ -0,0 +1,129 @@
+package avs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// The defaults of DownchannelWatchdog: how long the downchannel may be idle,
+// and how long a probe may take.
+const (
+	defaultWatchdogIdle = 5 * time.Minute
+	defaultProbeTimeout = 10 * time.Second
+)
+
+// DownchannelWatchdog detects a downchannel that is still open but on which
+// AVS stopped sending anything, before a user interaction fails because of
+// it. When nothing was received on the downchannel for Idle, the connection
+// is probed; if the probe fails, the downchannel is reconnected.
+//
+// A successful probe, or a successful Ping of the Client, counts as activity,
+// so a downchannel that is quiet while the connection works is left alone.
+type DownchannelWatchdog struct {
+	Downchannel *Downchannel
+	// Idle is how long the downchannel may go without activity before the
+	// connection is probed. If zero, it's 5 minutes.
+	Idle time.Duration
+	// Probe, if set, checks the connection instead of a Ping with the access
+	// token of the downchannel.
+	Probe func(ctx context.Context) error
+	// ProbeTimeout is how long a probe may take before it's considered
+	// failed. If zero, it's 10 seconds.
+	ProbeTimeout time.Duration
+	// OnReconnect, if set, is called with the error of the probe before the
+	// downchannel is reconnected.
+	OnReconnect func(err error)
+
+	mu        sync.Mutex
+	lastProbe time.Time
+}
+
+// NewDownchannelWatchdog returns a DownchannelWatchdog that probes the
+// connection of the downchannel after idle without activity.
+func NewDownchannelWatchdog(d *Downchannel, idle time.Duration) *DownchannelWatchdog {
+	return &DownchannelWatchdog{Downchannel: d, Idle: idle}
+}
+
+// Run checks the downchannel periodically until the context is canceled or
+// the downchannel is closed.
+func (w *DownchannelWatchdog) Run(ctx context.Context) {
+	ticker := w.Downchannel.clock.NewTicker(w.idle() / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			w.Check(ctx)
+		case <-w.Downchannel.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check probes the connection if the downchannel has been idle for too long,
+// and reconnects the downchannel if the probe fails. It returns the error of
+// the failed probe, or nil if the connection is alive.
+func (w *DownchannelWatchdog) Check(ctx context.Context) error {
+	d := w.Downchannel
+	now := d.clock.Now()
+	if now.Sub(w.lastAlive()) < w.idle() {
+		return nil
+	}
+	timeout := w.ProbeTimeout
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	err := w.probe(probeCtx)
+	cancel()
+	if err == nil {
+		w.mu.Lock()
+		w.lastProbe = now
+		w.mu.Unlock()
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if w.OnReconnect != nil {
+		w.OnReconnect(err)
+	}
+	if rerr := d.Reconnect(); rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+func (w *DownchannelWatchdog) idle() time.Duration {
+	if w.Idle <= 0 {
+		return defaultWatchdogIdle
+	}
+	return w.Idle
+}
+
+// Returns the last time the connection was known to work.
+func (w *DownchannelWatchdog) lastAlive() time.Time {
+	alive := w.Downchannel.LastActivity()
+	w.mu.Lock()
+	if w.lastProbe.After(alive) {
+		alive = w.lastProbe
+	}
+	w.mu.Unlock()
+	if h := w.Downchannel.client.Health(); h.LastPingErr == nil && h.LastPing.After(alive) {
+		alive = h.LastPing
+	}
+	return alive
+}
+
+func (w *DownchannelWatchdog) probe(ctx context.Context) error {
+	if w.Probe != nil {
+		return w.Probe(ctx)
+	}
+	d := w.Downchannel
+	d.mu.Lock()
+	accessToken := d.accessToken
+	d.mu.Unlock()
+	return d.client.PingContext(ctx, accessToken)
+}
@@ -0,0 +1,130 @@
+package avs_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fika-io/go-avs"
+	"github.com/fika-io/go-avs/avstest"
+)
+
+func TestDownchannelWatchdog(t *testing.T) {
+	server := avstest.NewServer()
+	defer server.Close()
+	clock := avstest.NewFakeClock(time.Now())
+	client := &avs.Client{EndpointURL: server.URL, Clock: clock}
+	d, err := client.OpenDownchannel("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	w := avs.NewDownchannelWatchdog(d, time.Minute)
+	var probes int
+	var probeErr error
+	w.Probe = func(ctx context.Context) error {
+		probes++
+		return probeErr
+	}
+	var reconnects []error
+	w.OnReconnect = func(err error) {
+		reconnects = append(reconnects, err)
+	}
+	ctx := context.Background()
+
+	if err := w.Check(ctx); err != nil || probes != 0 {
+		t.Fatalf("got %v after %d probes; want no probe", err, probes)
+	}
+	// A quiet downchannel is left alone while the probes succeed.
+	clock.Advance(2 * time.Minute)
+	if err := w.Check(ctx); err != nil || probes != 1 {
+		t.Fatalf("got %v after %d probes; want one successful probe", err, probes)
+	}
+	if err := w.Check(ctx); err != nil || probes != 1 {
+		t.Fatalf("got %v after %d probes; want no new probe", err, probes)
+	}
+
+	clock.Advance(2 * time.Minute)
+	probeErr = errors.New("stale")
+	if err := w.Check(ctx); err != probeErr || len(reconnects) != 1 {
+		t.Fatalf("got %v and reconnects %v; want the probe error", err, reconnects)
+	}
+	deadline := time.Now().Add(time.Second)
+	for d.Rotations() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("downchannel wasn't reconnected: %v", d.Err())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := d.LastActivity(); !got.Equal(clock.Now()) {
+		t.Errorf("got last activity %v; want the reconnection at %v", got, clock.Now())
+	}
+	select {
+	case <-d.Directives:
+		t.Fatalf("downchannel closed: %v", d.Err())
+	default:
+	}
+
+	d.Close()
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after the downchannel was closed")
+	}
+	if err := d.Reconnect(); !errors.Is(err, avs.ErrClosed) {
+		t.Errorf("got %v; want ErrClosed", err)
+	}
+
+	// The default Idle applies when it's zero.
+	(&avs.DownchannelWatchdog{Downchannel: d}).Run(ctx)
+}
+
+// A downchannel reconnected while the network is down keeps trying to open
+// its new stream.
+func TestDownchannelReconnectRetried(t *testing.T) {
+	var streams int32
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&streams, 1) == 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "multipart/related; boundary=avstest")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+	client := &avs.Client{EndpointURL: server.URL}
+	d, err := client.OpenDownchannel("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for d.Rotations() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("downchannel wasn't reconnected after %d streams: %v", atomic.LoadInt32(&streams), d.Err())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&streams); n != 3 {
+		t.Errorf("got %d streams; want the failed one retried", n)
+	}
+}